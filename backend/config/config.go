@@ -3,6 +3,7 @@ package config
 import (
 	"bufio"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -35,15 +36,40 @@ func loadEnvFile(filename string) {
 	}
 }
 
+// DefaultSessionSecret is the session secret used when SESSION_SECRET isn't set
+const DefaultSessionSecret = "default-secret-change-in-production"
+
+// placeholderSessionSecrets are the session secrets shipped in .env.example and docker-compose.yml
+var placeholderSessionSecrets = []string{
+	DefaultSessionSecret,
+	"your-secure-session-secret-change-in-production",
+	"change-me-in-production",
+}
+
 // Config holds all configuration for the application
 type Config struct {
 	ServerAddress      string
 	DatabasePath       string
 	OIDC               OIDCConfig
 	WeChat             WeChatConfig
+	SMTP               SMTPConfig
+	TelegramBotToken   string // Bot used to reach recipients who prefer Telegram
 	SessionSecret      string
 	CORSAllowedOrigins []string
-	DevMode            bool // Skip authentication when true
+	DevMode            bool   // Skip authentication when true
+	PublicBaseURL      string // Externally reachable URL of the frontend, used in links sent to recipients
+	LogLevel           string // debug, info or warn; can be changed at runtime
+	MaxInFlight        int    // Global cap on concurrent requests before shedding with 503; 0 disables
+	SendConcurrency    int    // Template messages sent to WeChat in parallel during a broadcast
+	SendTimeoutSeconds int    // Deadline for the WeChat calls of a single send; 0 disables
+	DBTimeoutSeconds   int    // Deadline of each database statement; 0 disables
+
+	TrustedProxies []string // Proxies whose X-Forwarded-For names the client IP; a request from anywhere else is the client
+
+	WebhookReplayWindowSeconds int  // Largest clock skew accepted on signed webhook requests; their nonces are remembered this long
+	WebhookRateLimit           int  // Webhook requests per second per caller, unless changed through the admin API
+	WebhookRateBurst           int  // Webhook requests a caller can send at once
+	WebhookQueryToken          bool // Accept the webhook token as ?token= from callers that can't set headers
 }
 
 // OIDCConfig holds OIDC provider configuration
@@ -56,9 +82,21 @@ type OIDCConfig struct {
 
 // WeChatConfig holds WeChat API configuration
 type WeChatConfig struct {
-	AppID      string
-	AppSecret  string
-	TemplateID string
+	AppID       string
+	AppSecret   string
+	TemplateID  string
+	StableToken bool   // Fetch tokens from cgi-bin/stable_token, for AppIDs shared with other systems
+	TokenURL    string // Central token service to fetch access tokens from instead of WeChat; no AppSecret needed
+	Sandbox     bool   // The account is a WeChat test account (测试号); sends are serialized and labelled in the history
+}
+
+// SMTPConfig holds the outgoing mail server used to reach recipients who prefer email
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
 }
 
 // Load loads configuration from environment variables
@@ -72,9 +110,21 @@ func Load() (*Config, error) {
 	cfg := &Config{
 		ServerAddress:      getEnv("SERVER_ADDRESS", ":8080"),
 		DatabasePath:       getEnv("DATABASE_PATH", "./data/notification.db"),
-		SessionSecret:      getEnv("SESSION_SECRET", "default-secret-change-in-production"),
+		SessionSecret:      getEnv("SESSION_SECRET", DefaultSessionSecret),
 		CORSAllowedOrigins: parseCSV(getEnv("CORS_ALLOWED_ORIGINS", "*")),
+		TrustedProxies:     parseCSV(getEnv("TRUSTED_PROXIES", "127.0.0.1,::1,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16")),
 		DevMode:            devMode,
+		PublicBaseURL:      strings.TrimSuffix(getEnv("PUBLIC_BASE_URL", "http://localhost:5173"), "/"),
+		LogLevel:           getEnv("LOG_LEVEL", "info"),
+		MaxInFlight:        getEnvInt("MAX_INFLIGHT_REQUESTS", 64),
+		SendConcurrency:    getEnvInt("SEND_CONCURRENCY", 10),
+		SendTimeoutSeconds: getEnvInt("SEND_TIMEOUT_SECONDS", 30),
+		DBTimeoutSeconds:   getEnvInt("DB_QUERY_TIMEOUT_SECONDS", 10),
+
+		WebhookReplayWindowSeconds: getEnvInt("WEBHOOK_REPLAY_WINDOW_SECONDS", 300),
+		WebhookRateLimit:           getEnvInt("WEBHOOK_RATE_LIMIT", 10),
+		WebhookRateBurst:           getEnvInt("WEBHOOK_RATE_BURST", 20),
+		WebhookQueryToken:          getEnv("WEBHOOK_QUERY_TOKEN", "") == "true",
 		OIDC: OIDCConfig{
 			ProviderURL:  oidcProviderURL,
 			ClientID:     getEnv("OIDC_CLIENT_ID", ""),
@@ -82,14 +132,36 @@ func Load() (*Config, error) {
 			RedirectURL:  getEnv("OIDC_REDIRECT_URL", "http://localhost:8080/auth/callback"),
 		},
 		WeChat: WeChatConfig{
-			AppID:      getEnv("WECHAT_APP_ID", ""),
-			AppSecret:  getEnv("WECHAT_APP_SECRET", ""),
-			TemplateID: getEnv("WECHAT_TEMPLATE_ID", ""),
+			AppID:       getEnv("WECHAT_APP_ID", ""),
+			AppSecret:   getEnv("WECHAT_APP_SECRET", ""),
+			TemplateID:  getEnv("WECHAT_TEMPLATE_ID", ""),
+			StableToken: getEnv("WECHAT_STABLE_TOKEN", "") == "true",
+			TokenURL:    getEnv("WECHAT_TOKEN_PROVIDER_URL", ""),
+			Sandbox:     getEnv("WECHAT_SANDBOX", "") == "true",
+		},
+		SMTP: SMTPConfig{
+			Host:     getEnv("SMTP_HOST", ""),
+			Port:     getEnvInt("SMTP_PORT", 587),
+			Username: getEnv("SMTP_USERNAME", ""),
+			Password: getEnv("SMTP_PASSWORD", ""),
+			From:     getEnv("SMTP_FROM", ""),
 		},
+		TelegramBotToken: getEnv("TELEGRAM_BOT_TOKEN", ""),
 	}
 	return cfg, nil
 }
 
+// UsesDefaultSessionSecret reports whether the session secret is one anybody can look up,
+// so tokens signed with it can be forged
+func (c *Config) UsesDefaultSessionSecret() bool {
+	for _, placeholder := range placeholderSessionSecrets {
+		if c.SessionSecret == placeholder {
+			return true
+		}
+	}
+	return false
+}
+
 // parseCSV parses a comma-separated string into a slice of strings
 func parseCSV(value string) []string {
 	if value == "" {
@@ -106,6 +178,13 @@ func parseCSV(value string) []string {
 	return result
 }
 
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
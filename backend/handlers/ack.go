@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AckHandler handles acknowledgement endpoints
+type AckHandler struct {
+	repo *repository.SQLiteRepository
+}
+
+// NewAckHandler creates a new acknowledgement handler
+func NewAckHandler(repo *repository.SQLiteRepository) *AckHandler {
+	return &AckHandler{repo: repo}
+}
+
+// ackPage is the mini confirm page opened from the template message jump link
+var ackPage = template.Must(template.New("ack").Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>消息确认</title>
+<style>
+body{font-family:-apple-system,sans-serif;text-align:center;padding:48px 24px;color:#333}
+button{font-size:18px;padding:14px 48px;border:0;border-radius:8px;background:#07c160;color:#fff}
+.done{color:#07c160;font-size:18px}
+</style>
+</head>
+<body>
+<p>{{.RecipientName}}，请确认已收到「{{.TemplateKey}}」通知</p>
+{{if .AcknowledgedAt}}
+<p class="done">✓ 已于 {{.AcknowledgedAt.Format "2006-01-02 15:04"}} 确认</p>
+{{else}}
+<form method="post"><button type="submit">确认收到</button></form>
+{{end}}
+</body>
+</html>`))
+
+// ConfirmPage renders the one-tap acknowledge page
+// GET /api/ack/:token
+func (h *AckHandler) ConfirmPage(c *gin.Context) {
+	ack, ok := h.loadAck(c)
+	if !ok {
+		return
+	}
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	ackPage.Execute(c.Writer, ack)
+}
+
+// Acknowledge records the recipient's acknowledgement
+// POST /api/ack/:token
+func (h *AckHandler) Acknowledge(c *gin.Context) {
+	if err := h.repo.MarkAcknowledged(c.Param("token")); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, models.ApiResponse{
+				Success: false, Error: "Acknowledgement not found", Code: "NOT_FOUND",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to record acknowledgement", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+
+	// Browsers submitting the confirm form get the page back, API callers get JSON
+	if c.ContentType() == "application/x-www-form-urlencoded" {
+		h.ConfirmPage(c)
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true})
+}
+
+// List returns recent acknowledgements
+// GET /api/acknowledgements
+func (h *AckHandler) List(c *gin.Context) {
+	acks, err := h.repo.ListAcknowledgements(200)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to get acknowledgements", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: acks})
+}
+
+func (h *AckHandler) loadAck(c *gin.Context) (*models.Acknowledgement, bool) {
+	ack, err := h.repo.GetAcknowledgementByToken(c.Param("token"))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.String(http.StatusNotFound, "链接无效或已过期")
+			return nil, false
+		}
+		c.String(http.StatusInternalServerError, "服务器错误")
+		return nil, false
+	}
+	return ack, true
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"wechat-notification/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAcknowledge(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+	recipient := &models.Recipient{OpenID: "o1", Name: "张三"}
+	repo.Create(recipient)
+	repo.CreateAcknowledgement(&models.Acknowledgement{Token: "tok1", RecipientID: recipient.ID, TemplateKey: "alert"})
+
+	handler := NewAckHandler(repo)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/ack/:token", handler.ConfirmPage)
+	router.POST("/api/ack/:token", handler.Acknowledge)
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	if w := serve(http.MethodGet, "/api/ack/tok1"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "确认收到") {
+		t.Errorf("confirm page: status %d, body %s", w.Code, w.Body.String())
+	}
+	if w := serve(http.MethodPost, "/api/ack/tok1"); w.Code != http.StatusOK {
+		t.Errorf("acknowledge: status %d, body %s", w.Code, w.Body.String())
+	}
+	if ack, err := repo.GetAcknowledgementByToken("tok1"); err != nil || ack.AcknowledgedAt == nil {
+		t.Errorf("acknowledgement not recorded: %+v, %v", ack, err)
+	}
+	if w := serve(http.MethodGet, "/api/ack/tok1"); !strings.Contains(w.Body.String(), "已于") {
+		t.Errorf("acknowledged page should show the time: %s", w.Body.String())
+	}
+	if w := serve(http.MethodPost, "/api/ack/unknown"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown token: status %d", w.Code)
+	}
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"wechat-notification/logger"
+	"wechat-notification/models"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminHandler handles server-wide settings. There are no roles: like the rest of /api, these
+// endpoints are open to any signed-in session, which can already change the AppSecret.
+type AdminHandler struct {
+	maintenance *services.Maintenance
+	sender      *services.Sender
+	diagnostics *services.Diagnostics
+}
+
+// NewAdminHandler creates a new admin handler
+func NewAdminHandler(maintenance *services.Maintenance, sender *services.Sender) *AdminHandler {
+	return &AdminHandler{maintenance: maintenance, sender: sender}
+}
+
+// SetDiagnostics enables the support diagnostics bundle
+func (h *AdminHandler) SetDiagnostics(diagnostics *services.Diagnostics) {
+	h.diagnostics = diagnostics
+}
+
+// LogLevelRequest represents the request body for changing the log level
+type LogLevelRequest struct {
+	Level string `json:"level" binding:"required"`
+}
+
+// GetLogLevel returns the current log level
+// GET /api/admin/loglevel
+func (h *AdminHandler) GetLogLevel(c *gin.Context) {
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: gin.H{"level": logger.Level()}})
+}
+
+// SetLogLevel switches the log level without restarting. Any signed-in session may change it;
+// the change is logged at warning level so it shows up whatever the new level is.
+// PUT /api/admin/loglevel
+func (h *AdminHandler) SetLogLevel(c *gin.Context) {
+	var req LogLevelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request: level is required", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+
+	previous := logger.Level()
+	if err := logger.SetLevel(req.Level); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: err.Error(), Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+	logger.Warnf("log level changed from %s to %s", previous, logger.Level())
+
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: gin.H{"level": logger.Level()}})
+}
+
+// MaintenanceRequest represents the request body for switching maintenance mode
+type MaintenanceRequest struct {
+	Enabled *bool  `json:"enabled" binding:"required"`
+	Reason  string `json:"reason"` // Shown in the SPA banner
+}
+
+// GetMaintenance returns the maintenance switch and how many sends are held
+// GET /api/admin/maintenance
+func (h *AdminHandler) GetMaintenance(c *gin.Context) {
+	h.writeMaintenance(c)
+}
+
+// SetMaintenance pauses or resumes all outbound dispatch. Sends held during maintenance
+// are dispatched in the background when it is switched off. Any signed-in session may switch
+// it; every switch is logged at warning level.
+// POST /api/admin/maintenance
+func (h *AdminHandler) SetMaintenance(c *gin.Context) {
+	var req MaintenanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request: enabled is required", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+
+	if err := h.maintenance.Set(*req.Enabled, req.Reason, time.Now()); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to save maintenance mode", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	if !*req.Enabled {
+		go func() {
+			if _, err := h.sender.ReleaseHeld(context.Background()); err != nil {
+				logger.Warnf("admin: failed to release held sends: %v", err)
+			}
+		}()
+	}
+	h.writeMaintenance(c)
+}
+
+func (h *AdminHandler) writeMaintenance(c *gin.Context) {
+	status, err := h.maintenance.Status()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve maintenance mode", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: status})
+}
+
+// Diagnostics returns a signed zip of the configuration summary, recent errors and version
+// information for support requests. Secrets are redacted.
+// GET /api/admin/diagnostics
+func (h *AdminHandler) Diagnostics(c *gin.Context) {
+	now := time.Now()
+	bundle, err := h.diagnostics.Bundle(now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to build diagnostics bundle", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename=tongzhi-diagnostics-"+now.Format("20060102-150405")+".zip")
+	c.Data(http.StatusOK, "application/zip", bundle)
+}
+
+// VerifyDiagnostics checks that an uploaded bundle was produced by this server and not edited
+// POST /api/admin/diagnostics/verify
+func (h *AdminHandler) VerifyDiagnostics(c *gin.Context) {
+	bundle, err := io.ReadAll(io.LimitReader(c.Request.Body, 10<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Failed to read bundle", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+	if err := h.diagnostics.Verify(bundle); errors.Is(err, services.ErrInvalidDiagnostics) {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: err.Error(), Code: "INVALID_SIGNATURE",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: gin.H{"valid": true}})
+}
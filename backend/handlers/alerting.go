@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"net/http"
+
+	"wechat-notification/models"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Grafana sends an alert from a Grafana webhook contact point, unified or legacy alerting. The
+// message links to the alert's panel when Grafana sends one.
+// POST /api/webhook/grafana/:token
+func (h *CompatHandler) Grafana(c *gin.Context) {
+	var alert services.GrafanaAlert
+	if err := c.ShouldBindBodyWith(&alert, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+	if alert.Empty() {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "alerts or state is required", Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+	h.sendParts(c, models.CompatGrafana, alert.Parts())
+}
+
+// Sentry sends an issue alert from Sentry's WebHooks plugin or an internal integration, linking
+// to the issue
+// POST /api/webhook/sentry/:token
+func (h *CompatHandler) Sentry(c *gin.Context) {
+	var alert services.SentryAlert
+	if err := c.ShouldBindBodyWith(&alert, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+	if alert.Title() == "" {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "message or event title is required", Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+	h.sendParts(c, models.CompatSentry, alert.Parts())
+}
+
+// Zabbix sends a problem, its recovery or an update from a Zabbix webhook media type set up with
+// the parameters and script Setup returns
+// POST /api/webhook/zabbix/:token
+func (h *CompatHandler) Zabbix(c *gin.Context) {
+	var event services.ZabbixEvent
+	if err := c.ShouldBindBodyWith(&event, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+	if event.Name() == "" {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "event_name is required", Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+	h.sendParts(c, models.CompatZabbix, event.Parts())
+}
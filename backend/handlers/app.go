@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AppHandler manages the additional WeChat official accounts
+type AppHandler struct {
+	repo *repository.SQLiteRepository
+	apps *services.AppRegistry
+}
+
+// NewAppHandler creates a new official account handler
+func NewAppHandler(repo *repository.SQLiteRepository, apps *services.AppRegistry) *AppHandler {
+	return &AppHandler{repo: repo, apps: apps}
+}
+
+// AppRequest represents the request body for creating or updating an official account
+type AppRequest struct {
+	Name       string `json:"name" binding:"required"`
+	AppID      string `json:"appId" binding:"required"`
+	AppSecret  string `json:"appSecret"` // Empty or masked keeps the stored secret on update
+	TemplateID string `json:"templateId"`
+}
+
+// List returns all official accounts with their secrets masked
+// GET /api/apps
+func (h *AppHandler) List(c *gin.Context) {
+	apps, err := h.repo.GetWeChatApps()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to get apps", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	for i := range apps {
+		apps[i].AppSecret = services.MaskSecret(apps[i].AppSecret)
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: apps})
+}
+
+// Create adds an official account
+// POST /api/apps
+func (h *AppHandler) Create(c *gin.Context) {
+	app, ok := h.bindApp(c)
+	if !ok {
+		return
+	}
+
+	if err := h.repo.CreateWeChatApp(app); err != nil {
+		h.writeError(c, err, "Failed to create app")
+		return
+	}
+	h.apps.Put(app)
+
+	app.AppSecret = services.MaskSecret(app.AppSecret)
+	c.JSON(http.StatusCreated, models.ApiResponse{Success: true, Data: app})
+}
+
+// Update changes an official account's name, credentials or default template
+// PUT /api/apps/:id
+func (h *AppHandler) Update(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	app, ok := h.bindApp(c)
+	if !ok {
+		return
+	}
+
+	old, err := h.repo.GetWeChatAppByID(id)
+	if err != nil {
+		h.writeError(c, err, "Failed to retrieve app")
+		return
+	}
+	app.ID, app.CreatedAt = id, old.CreatedAt
+	if app.AppSecret == "" || app.AppSecret == "******" {
+		app.AppSecret = old.AppSecret
+	}
+
+	if err := h.repo.UpdateWeChatApp(app); err != nil {
+		h.writeError(c, err, "Failed to update app")
+		return
+	}
+	h.apps.Put(app)
+
+	app.AppSecret = services.MaskSecret(app.AppSecret)
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: app})
+}
+
+// Delete removes an official account that no template or recipient belongs to any more
+// DELETE /api/apps/:id
+func (h *AppHandler) Delete(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.repo.DeleteWeChatApp(id); err != nil {
+		h.writeError(c, err, "Failed to delete app")
+		return
+	}
+	h.apps.Remove(id)
+
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true})
+}
+
+func (h *AppHandler) bindApp(c *gin.Context) (*models.WeChatApp, bool) {
+	var req AppRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request: name and appId are required", Code: "INVALID_REQUEST",
+		})
+		return nil, false
+	}
+	app := &models.WeChatApp{
+		Name:       strings.TrimSpace(req.Name),
+		AppID:      strings.TrimSpace(req.AppID),
+		AppSecret:  strings.TrimSpace(req.AppSecret),
+		TemplateID: strings.TrimSpace(req.TemplateID),
+	}
+	if app.Name == "" || app.AppID == "" {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Name and appId cannot be empty or whitespace only", Code: "VALIDATION_ERROR",
+		})
+		return nil, false
+	}
+	return app, true
+}
+
+func (h *AppHandler) writeError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		c.JSON(http.StatusNotFound, models.ApiResponse{
+			Success: false, Error: "App not found", Code: "NOT_FOUND",
+		})
+	case errors.Is(err, repository.ErrDuplicateAppID):
+		c.JSON(http.StatusConflict, models.ApiResponse{
+			Success: false, Error: "An app with this appId already exists", Code: "DUPLICATE_APP_ID",
+		})
+	case errors.Is(err, repository.ErrAppInUse):
+		c.JSON(http.StatusConflict, models.ApiResponse{
+			Success: false, Error: "App is still used by templates or recipients", Code: "APP_IN_USE",
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: message, Code: "DATABASE_ERROR",
+		})
+	}
+}
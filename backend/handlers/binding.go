@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BindingHandler handles QR codes that bind whoever scans them to a recipient. Binding needs the
+// callback URL to be set up so the scan events reach the server.
+type BindingHandler struct {
+	repo *repository.SQLiteRepository
+	apps *services.AppRegistry
+}
+
+// NewBindingHandler creates a new QR binding handler
+func NewBindingHandler(repo *repository.SQLiteRepository, apps *services.AppRegistry) *BindingHandler {
+	return &BindingHandler{repo: repo, apps: apps}
+}
+
+// BindingRequest represents the request body for creating a binding QR code
+type BindingRequest struct {
+	Name          string  `json:"name"`
+	RecipientID   int64   `json:"recipientId"`
+	GroupIDs      []int64 `json:"groupIds"`
+	AppID         int64   `json:"appId"`
+	ExpireSeconds int     `json:"expireSeconds"`
+}
+
+// List returns all binding QR codes, used or not
+// GET /api/bindings
+func (h *BindingHandler) List(c *gin.Context) {
+	bindings, err := h.repo.ListQRBindings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to get bindings", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: bindings})
+}
+
+// Create generates a QR code on an official account. The first user to scan it is bound to
+// recipientId, or becomes a new recipient called name when it's 0, and joins groupIds.
+// POST /api/bindings
+func (h *BindingHandler) Create(c *gin.Context) {
+	var req BindingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+
+	expiry := services.DefaultQRBindingExpiry
+	if req.ExpireSeconds != 0 {
+		expiry = time.Duration(req.ExpireSeconds) * time.Second
+	}
+	if expiry < time.Minute || expiry > services.MaxQRBindingExpiry {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "expireSeconds must be between 60 and 2592000", Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+	if req.RecipientID != 0 {
+		if _, err := h.repo.GetByID(req.RecipientID); err != nil {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "Recipient not found", Code: "RECIPIENT_NOT_FOUND",
+			})
+			return
+		}
+	}
+	groupIDs := uniqueIDs(req.GroupIDs)
+	for _, id := range groupIDs {
+		if _, err := h.repo.GetGroupByID(id); err != nil {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "Group not found", Code: "GROUP_NOT_FOUND",
+			})
+			return
+		}
+	}
+	if _, err := h.apps.Service(req.AppID); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "WeChat app not found", Code: "APP_NOT_FOUND",
+		})
+		return
+	}
+
+	binding := &models.QRBinding{
+		Name:        strings.TrimSpace(req.Name),
+		RecipientID: req.RecipientID,
+		GroupIDs:    groupIDs,
+		AppID:       req.AppID,
+	}
+	if err := services.GenerateQRBinding(h.apps, binding, expiry, time.Now()); err != nil {
+		c.JSON(http.StatusBadGateway, models.ApiResponse{
+			Success: false, Error: err.Error(), Code: "QRCODE_CREATE_FAILED",
+		})
+		return
+	}
+	if err := h.repo.CreateQRBinding(binding); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to create binding", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusCreated, models.ApiResponse{Success: true, Data: binding})
+}
+
+// Delete deletes a binding QR code. The code itself stays valid on WeChat until it expires, but
+// scanning it no longer binds anyone.
+// DELETE /api/bindings/:id
+func (h *BindingHandler) Delete(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	if err := h.repo.DeleteQRBinding(id); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, models.ApiResponse{
+				Success: false, Error: "Binding not found", Code: "NOT_FOUND",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to delete binding", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true})
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CalendarHandler handles ICS calendar reminder endpoints
+type CalendarHandler struct {
+	repo      *repository.SQLiteRepository
+	scheduler *services.CalendarScheduler
+}
+
+// NewCalendarHandler creates a new calendar handler
+func NewCalendarHandler(repo *repository.SQLiteRepository, scheduler *services.CalendarScheduler) *CalendarHandler {
+	return &CalendarHandler{repo: repo, scheduler: scheduler}
+}
+
+// CalendarRequest represents the request body for creating or updating a calendar.
+// Either url (a linked calendar) or content (an uploaded .ics file) must be set.
+type CalendarRequest struct {
+	Name        string            `json:"name" binding:"required"`
+	URL         string            `json:"url"`
+	Content     string            `json:"content"`
+	LeadMinutes []int             `json:"leadMinutes"`
+	TemplateKey string            `json:"templateKey" binding:"required"`
+	GroupIDs    []int64           `json:"groupIds" binding:"required"`
+	Keywords    map[string]string `json:"keywords" binding:"required"`
+	Enabled     *bool             `json:"enabled"`
+}
+
+// UpcomingEvent is an event returned by the events preview endpoint
+type UpcomingEvent struct {
+	UID      string    `json:"uid"`
+	Summary  string    `json:"summary"`
+	Location string    `json:"location,omitempty"`
+	Start    time.Time `json:"start"`
+	AllDay   bool      `json:"allDay"`
+}
+
+// List returns all calendars
+// GET /api/calendars
+func (h *CalendarHandler) List(c *gin.Context) {
+	calendars, err := h.repo.GetAllCalendars()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to get calendars", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: calendars})
+}
+
+// Create creates a new calendar
+// POST /api/calendars
+func (h *CalendarHandler) Create(c *gin.Context) {
+	cal, ok := h.bindCalendar(c, true)
+	if !ok {
+		return
+	}
+
+	if err := h.repo.CreateCalendar(cal); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to create calendar", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.ApiResponse{Success: true, Data: cal})
+}
+
+// Update updates a calendar; omitting content keeps the previously uploaded file
+// PUT /api/calendars/:id
+func (h *CalendarHandler) Update(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	cal, ok := h.bindCalendar(c, false)
+	if !ok {
+		return
+	}
+	cal.ID = id
+
+	if err := h.repo.UpdateCalendar(cal); err != nil {
+		h.writeError(c, err, "Failed to update calendar")
+		return
+	}
+
+	updated, err := h.repo.GetCalendarByID(id)
+	if err != nil {
+		h.writeError(c, err, "Failed to retrieve calendar")
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: updated})
+}
+
+// Delete deletes a calendar
+// DELETE /api/calendars/:id
+func (h *CalendarHandler) Delete(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	if err := h.repo.DeleteCalendar(id); err != nil {
+		h.writeError(c, err, "Failed to delete calendar")
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true})
+}
+
+// Events lists the calendar's upcoming events, downloading a linked calendar first
+// GET /api/calendars/:id/events
+func (h *CalendarHandler) Events(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	cal, err := h.repo.GetCalendarByID(id)
+	if err != nil {
+		h.writeError(c, err, "Failed to retrieve calendar")
+		return
+	}
+
+	if cal.URL != "" {
+		if err := h.scheduler.Refresh(cal); err != nil {
+			c.JSON(http.StatusBadGateway, models.ApiResponse{
+				Success: false, Error: err.Error(), Code: "CALENDAR_FETCH_FAILED",
+			})
+			return
+		}
+	}
+
+	events, err := services.ParseICS(cal.Content)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: err.Error(), Code: "INVALID_CALENDAR",
+		})
+		return
+	}
+
+	now := time.Now()
+	upcoming := []UpcomingEvent{}
+	for _, e := range events {
+		if e.Start.After(now) {
+			upcoming = append(upcoming, UpcomingEvent{UID: e.UID, Summary: e.Summary, Location: e.Location, Start: e.Start, AllDay: e.AllDay})
+		}
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: upcoming})
+}
+
+func (h *CalendarHandler) bindCalendar(c *gin.Context, creating bool) (*models.Calendar, bool) {
+	var req CalendarRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request: name, templateKey, groupIds and keywords are required", Code: "INVALID_REQUEST",
+		})
+		return nil, false
+	}
+
+	req.URL = strings.TrimSpace(req.URL)
+	if req.URL != "" && req.Content != "" {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Provide either url or content, not both", Code: "VALIDATION_ERROR",
+		})
+		return nil, false
+	}
+	if req.URL != "" {
+		// webcal:// links are plain https subscriptions
+		if strings.HasPrefix(req.URL, "webcal://") {
+			req.URL = "https://" + strings.TrimPrefix(req.URL, "webcal://")
+		}
+		if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "URL must be an absolute http(s) or webcal URL", Code: "VALIDATION_ERROR",
+			})
+			return nil, false
+		}
+	} else if req.Content != "" {
+		if _, err := services.ParseICS(req.Content); err != nil {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: err.Error(), Code: "INVALID_CALENDAR",
+			})
+			return nil, false
+		}
+	} else if creating {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Either url or content is required", Code: "VALIDATION_ERROR",
+		})
+		return nil, false
+	}
+
+	if len(req.LeadMinutes) == 0 {
+		req.LeadMinutes = []int{15}
+	}
+	for _, lead := range req.LeadMinutes {
+		if lead < 0 || lead > 30*1440 {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "Lead times must be between 0 and 43200 minutes", Code: "VALIDATION_ERROR",
+			})
+			return nil, false
+		}
+	}
+	if len(req.GroupIDs) == 0 || len(req.Keywords) == 0 {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "At least one group and one keyword are required", Code: "VALIDATION_ERROR",
+		})
+		return nil, false
+	}
+	if _, err := h.repo.GetTemplateByKey(req.TemplateKey); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Template not found", Code: "TEMPLATE_NOT_FOUND",
+		})
+		return nil, false
+	}
+
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	return &models.Calendar{
+		Name:        strings.TrimSpace(req.Name),
+		URL:         req.URL,
+		Content:     req.Content,
+		LeadMinutes: req.LeadMinutes,
+		TemplateKey: req.TemplateKey,
+		GroupIDs:    uniqueIDs(req.GroupIDs),
+		Keywords:    req.Keywords,
+		Enabled:     enabled,
+	}, true
+}
+
+func (h *CalendarHandler) writeError(c *gin.Context, err error, message string) {
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, models.ApiResponse{
+			Success: false, Error: "Calendar not found", Code: "NOT_FOUND",
+		})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, models.ApiResponse{
+		Success: false, Error: message, Code: "DATABASE_ERROR",
+	})
+}
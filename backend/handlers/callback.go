@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"wechat-notification/logger"
+	"wechat-notification/models"
+	"wechat-notification/repository"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CallbackHandler serves the URL WeChat pushes follower events to (服务器配置). The default
+// account uses /api/wechat/callback, additional accounts /api/wechat/callback/<app id>.
+type CallbackHandler struct {
+	repo      *repository.SQLiteRepository
+	apps      *services.AppRegistry
+	callbacks *services.Callbacks
+}
+
+// NewCallbackHandler creates a new WeChat callback handler
+func NewCallbackHandler(repo *repository.SQLiteRepository, apps *services.AppRegistry, callbacks *services.Callbacks) *CallbackHandler {
+	return &CallbackHandler{repo: repo, apps: apps, callbacks: callbacks}
+}
+
+// Verify answers WeChat's check of the callback URL by echoing echostr when the signature matches
+// GET /api/wechat/callback[/:app]
+func (h *CallbackHandler) Verify(c *gin.Context) {
+	if _, _, ok := h.authorize(c); !ok {
+		return
+	}
+	c.String(http.StatusOK, c.Query("echostr"))
+}
+
+// Receive handles a message or event pushed by WeChat. Messages sent in safe mode
+// (encrypt_type=aes) are decrypted with the EncodingAESKey and replies to them encrypted. It
+// answers "success" when there's nothing to reply, also after events that failed on our side so
+// WeChat doesn't retry them.
+// POST /api/wechat/callback[/:app]
+func (h *CallbackHandler) Receive(c *gin.Context) {
+	appID, cfg, ok := h.authorize(c)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 64<<10))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid body")
+		return
+	}
+
+	var crypter *services.CallbackCipher
+	if c.Query("encrypt_type") == "aes" {
+		wechatSvc, _ := h.apps.Service(appID)
+		crypter, err = services.NewCallbackCipher(cfg.Token, cfg.EncodingAESKey, wechatSvc.AppID())
+		if err != nil {
+			c.String(http.StatusForbidden, "encodingAESKey not configured")
+			return
+		}
+		body, err = crypter.Open(body, c.Query("timestamp"), c.Query("nonce"), c.Query("msg_signature"))
+		if errors.Is(err, services.ErrInvalidMsgSignature) {
+			c.String(http.StatusUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	event, err := services.ParseEvent(body)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	text, err := h.callbacks.Handle(appID, event)
+	if err != nil {
+		logger.Warnf("callback: %s event from %s: %v", event.Event, event.FromUserName, err)
+	}
+	if text == "" {
+		c.String(http.StatusOK, "success")
+		return
+	}
+
+	reply := services.TextReply(event, text, time.Now())
+	if crypter != nil {
+		if reply, err = crypter.Seal(reply, c.Query("timestamp"), c.Query("nonce")); err != nil {
+			logger.Warnf("callback: failed to encrypt reply to %s: %v", event.FromUserName, err)
+			c.String(http.StatusOK, "success")
+			return
+		}
+	}
+	c.Data(http.StatusOK, "application/xml; charset=utf-8", reply)
+}
+
+// authorize resolves the official account of the callback URL and checks the request signature
+// against that account's callback token
+func (h *CallbackHandler) authorize(c *gin.Context) (int64, models.CallbackConfig, bool) {
+	appID, ok := h.appParam(c)
+	if !ok {
+		c.String(http.StatusNotFound, "unknown app")
+		return 0, models.CallbackConfig{}, false
+	}
+
+	cfg, err := services.LoadCallbackConfig(h.repo, appID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "failed to load configuration")
+		return 0, cfg, false
+	}
+	if cfg.Token == "" {
+		c.String(http.StatusForbidden, "callback not configured")
+		return 0, cfg, false
+	}
+	want := services.CallbackSignature(cfg.Token, c.Query("timestamp"), c.Query("nonce"))
+	if subtle.ConstantTimeCompare([]byte(want), []byte(c.Query("signature"))) != 1 {
+		c.String(http.StatusUnauthorized, "invalid signature")
+		return 0, cfg, false
+	}
+	return appID, cfg, true
+}
+
+// appParam returns the official account named by the :app parameter, 0 for the default account
+// when there's none. It reports false for accounts that don't exist.
+func (h *CallbackHandler) appParam(c *gin.Context) (int64, bool) {
+	var appID int64
+	if raw := c.Param("app"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		appID = id
+	}
+	if _, err := h.apps.Service(appID); err != nil {
+		return 0, false
+	}
+	return appID, true
+}
+
+// configApp is appParam for the config endpoints, writing a 404 for unknown accounts
+func (h *CallbackHandler) configApp(c *gin.Context) (int64, bool) {
+	appID, ok := h.appParam(c)
+	if !ok {
+		c.JSON(http.StatusNotFound, models.ApiResponse{
+			Success: false, Error: "App not found", Code: "NOT_FOUND",
+		})
+	}
+	return appID, ok
+}
+
+// GetConfig returns an official account's callback configuration with the token masked
+// GET /api/config/wechat/callback[/:app]
+func (h *CallbackHandler) GetConfig(c *gin.Context) {
+	appID, ok := h.configApp(c)
+	if !ok {
+		return
+	}
+	cfg, err := services.LoadCallbackConfig(h.repo, appID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve configuration", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	cfg.Token = services.MaskSecret(cfg.Token)
+	cfg.EncodingAESKey = services.MaskSecret(cfg.EncodingAESKey)
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: cfg})
+}
+
+// SaveConfig sets an official account's callback token, the EncodingAESKey for encrypted messages
+// and whether its followers are registered automatically
+// PUT /api/config/wechat/callback[/:app]
+func (h *CallbackHandler) SaveConfig(c *gin.Context) {
+	appID, ok := h.configApp(c)
+	if !ok {
+		return
+	}
+	var cfg models.CallbackConfig
+	if err := c.ShouldBindJSON(&cfg); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+
+	// Keep the stored secrets unless new ones were supplied
+	if cfg.Token == "******" || cfg.EncodingAESKey == "******" {
+		old, err := services.LoadCallbackConfig(h.repo, appID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, models.ApiResponse{
+				Success: false, Error: "Failed to retrieve configuration", Code: "DATABASE_ERROR",
+			})
+			return
+		}
+		if cfg.Token == "******" {
+			cfg.Token = old.Token
+		}
+		if cfg.EncodingAESKey == "******" {
+			cfg.EncodingAESKey = old.EncodingAESKey
+		}
+	}
+	if cfg.Token != "" && !validCallbackToken(cfg.Token) {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "token must be 3-32 letters or digits", Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+	if cfg.EncodingAESKey != "" {
+		if _, err := services.NewCallbackCipher(cfg.Token, cfg.EncodingAESKey, ""); err != nil {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: err.Error(), Code: "VALIDATION_ERROR",
+			})
+			return
+		}
+	}
+
+	if err := h.repo.SetJSONConfig(services.CallbackConfigKeyFor(appID), cfg); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to save configuration", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	cfg.Token = services.MaskSecret(cfg.Token)
+	cfg.EncodingAESKey = services.MaskSecret(cfg.EncodingAESKey)
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: cfg})
+}
+
+// validCallbackToken reports whether token is one the MP console accepts: 3 to 32 letters or digits
+func validCallbackToken(token string) bool {
+	if len(token) < 3 || len(token) > 32 {
+		return false
+	}
+	for _, r := range token {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
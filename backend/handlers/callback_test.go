@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"wechat-notification/models"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCallbackConfigPerApp(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	apps := services.NewAppRegistry(services.NewWeChatService(services.NewTokenManager("wx-default", "secret"), "tpl"))
+	second := &models.WeChatApp{Name: "second", AppID: "wx-second", AppSecret: "secret"}
+	if err := repo.CreateWeChatApp(second); err != nil {
+		t.Fatalf("CreateWeChatApp error: %v", err)
+	}
+	apps.Put(second)
+
+	handler := NewCallbackHandler(repo, apps, services.NewCallbacks(repo, apps))
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.PUT("/api/config/wechat/callback", handler.SaveConfig)
+	router.PUT("/api/config/wechat/callback/:app", handler.SaveConfig)
+	router.POST("/api/wechat/callback", handler.Receive)
+	router.POST("/api/wechat/callback/:app", handler.Receive)
+
+	accounts := []struct {
+		path, appID, token, aesKey string
+	}{
+		{"", "wx-default", "defaulttoken", "abcdefghijklmnopqrstuvwxyz0123456789ABCDEFG"},
+		{fmt.Sprintf("/%d", second.ID), "wx-second", "secondtoken", "GFEDCBA9876543210zyxwvutsrqponmlkjihgfedcba"},
+	}
+	for _, a := range accounts {
+		body := fmt.Sprintf(`{"token":%q,"encodingAESKey":%q}`, a.token, a.aesKey)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/api/config/wechat/callback"+a.path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("save config %q: status %d, body %s", a.path, w.Code, w.Body.String())
+		}
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/api/config/wechat/callback/999", strings.NewReader(`{}`)))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown app: status %d", w.Code)
+	}
+
+	// Each account's safe-mode message is checked and decrypted with its own token and key
+	receive := func(path, token, aesKey, appID string) int {
+		crypter, err := services.NewCallbackCipher(token, aesKey, appID)
+		if err != nil {
+			t.Fatalf("NewCallbackCipher error: %v", err)
+		}
+		encrypted, err := crypter.Encrypt([]byte(`<xml><ToUserName><![CDATA[gh_1]]></ToUserName><FromUserName><![CDATA[o-user]]></FromUserName>` +
+			`<MsgType><![CDATA[event]]></MsgType><Event><![CDATA[unsubscribe]]></Event></xml>`))
+		if err != nil {
+			t.Fatalf("Encrypt error: %v", err)
+		}
+		query := url.Values{
+			"timestamp": {"1700000000"}, "nonce": {"nonce42"}, "encrypt_type": {"aes"},
+			"signature":     {services.CallbackSignature(token, "1700000000", "nonce42")},
+			"msg_signature": {crypter.Signature("1700000000", "nonce42", encrypted)},
+		}
+		body := "<xml><ToUserName><![CDATA[gh_1]]></ToUserName><Encrypt><![CDATA[" + encrypted + "]]></Encrypt></xml>"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/wechat/callback"+path+"?"+query.Encode(), strings.NewReader(body)))
+		return w.Code
+	}
+	for _, a := range accounts {
+		if code := receive(a.path, a.token, a.aesKey, a.appID); code != http.StatusOK {
+			t.Errorf("callback %q: status %d", a.path, code)
+		}
+	}
+	if code := receive(accounts[1].path, accounts[0].token, accounts[0].aesKey, accounts[1].appID); code != http.StatusUnauthorized {
+		t.Errorf("second account accepted the default account's token: status %d", code)
+	}
+}
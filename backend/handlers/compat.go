@@ -0,0 +1,475 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// defaultCompatFieldMaps maps template fields to request parts when no mapping is configured
+var defaultCompatFieldMaps = map[string]map[string]string{
+	models.CompatPushPlus:   {"first": "title", "keyword1": "content"},
+	models.CompatWxPusher:   {"first": "summary", "keyword1": "content"},
+	models.CompatSynology:   {"first": "title", "keyword1": "content"},
+	models.CompatQNAP:       {"first": "title", "keyword1": "content"},
+	models.CompatWatchtower: {"first": "title", "keyword1": "host", "keyword2": "content"},
+	models.CompatDiun:       {"first": "title", "keyword1": "host", "keyword2": "content"},
+	models.CompatGrafana:    {"first": "title", "keyword1": "state", "keyword2": "content"},
+	models.CompatGitHub:     {"first": "title", "keyword1": "repo", "keyword2": "content"},
+	models.CompatGitLab:     {"first": "title", "keyword1": "repo", "keyword2": "content"},
+	models.CompatSentry:     {"first": "title", "keyword1": "project", "keyword2": "level", "keyword3": "content"},
+	models.CompatZabbix:     {"first": "title", "keyword1": "host", "keyword2": "severity", "keyword3": "content"},
+	models.CompatJenkins:    {"first": "title", "keyword1": "job", "keyword2": "status", "keyword3": "content"},
+	models.CompatArgoCD:     {"first": "title", "keyword1": "app", "keyword2": "health", "keyword3": "content"},
+}
+
+// compatTokenPrefixes mimic the look of each service's tokens so they're recognisable in client configs
+var compatTokenPrefixes = map[string]string{
+	models.CompatPushPlus:   "",
+	models.CompatWxPusher:   "AT_",
+	models.CompatSynology:   "",
+	models.CompatQNAP:       "",
+	models.CompatWatchtower: "",
+	models.CompatDiun:       "",
+	models.CompatGrafana:    "",
+	models.CompatGitHub:     "",
+	models.CompatGitLab:     "",
+	models.CompatSentry:     "",
+	models.CompatZabbix:     "",
+	models.CompatJenkins:    "",
+	models.CompatArgoCD:     "",
+}
+
+// Response codes of the PushPlus and WxPusher APIs
+const (
+	pushPlusCodeOK           = 200
+	pushPlusCodeUnauthorized = 401
+	pushPlusCodeSendFailed   = 500
+	pushPlusCodeInvalid      = 999
+
+	wxPusherCodeOK    = 1000
+	wxPusherCodeError = 1001
+)
+
+// CompatHandler accepts PushPlus and WxPusher style requests so integrations can migrate by changing the host,
+// and the notifications Synology and QNAP NAS, Watchtower and Diun, Grafana, Sentry, Zabbix, GitHub, GitLab, Jenkins or Argo CD send to a custom webhook
+type CompatHandler struct {
+	repo    *repository.SQLiteRepository
+	sender  *services.Sender
+	updates *services.UpdateGrouper
+	baseURL string // Public URL the endpoints are reached at, for integration setup
+}
+
+// NewCompatHandler creates a new compat handler
+func NewCompatHandler(repo *repository.SQLiteRepository, sender *services.Sender) *CompatHandler {
+	return &CompatHandler{repo: repo, sender: sender, updates: services.NewUpdateGrouper()}
+}
+
+// SetBaseURL sets the public URL of the server, used in the endpoint URLs Setup returns
+func (h *CompatHandler) SetBaseURL(baseURL string) {
+	h.baseURL = baseURL
+}
+
+// pushPlusRequest holds the PushPlus send parameters we use, sent as query, form or JSON
+type pushPlusRequest struct {
+	Token   string `form:"token" json:"token"`
+	Title   string `form:"title" json:"title"`
+	Content string `form:"content" json:"content"`
+}
+
+// wxPusherRequest holds the WxPusher send parameters we use; recipients come from the endpoint config, not uids
+type wxPusherRequest struct {
+	AppToken string `form:"appToken" json:"appToken"`
+	Content  string `form:"content" json:"content"`
+	Summary  string `form:"summary" json:"summary"`
+}
+
+// pushPlusResponse mirrors the PushPlus response body
+type pushPlusResponse struct {
+	Code int         `json:"code"`
+	Msg  string      `json:"msg"`
+	Data interface{} `json:"data"`
+}
+
+// wxPusherResponse mirrors the WxPusher response body
+type wxPusherResponse struct {
+	Code    int         `json:"code"`
+	Msg     string      `json:"msg"`
+	Data    interface{} `json:"data"`
+	Success bool        `json:"success"`
+}
+
+// GetConfig returns a compat endpoint's configuration with the token masked
+// GET /api/config/compat/:service
+func (h *CompatHandler) GetConfig(c *gin.Context) {
+	service, ok := compatService(c)
+	if !ok {
+		return
+	}
+	cfg, err := h.loadConfig(service)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve configuration", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	cfg.Token = services.MaskSecret(cfg.Token)
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: cfg})
+}
+
+// SaveConfig saves a compat endpoint's configuration, generating a token on first enable. A new
+// token is returned only in this response; the database keeps its hash.
+// PUT /api/config/compat/:service
+func (h *CompatHandler) SaveConfig(c *gin.Context) {
+	service, ok := compatService(c)
+	if !ok {
+		return
+	}
+	var cfg models.CompatEndpointConfig
+	if err := c.ShouldBindJSON(&cfg); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+
+	if _, err := services.ParseKeywordTemplates(cfg.KeywordTemplates); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid keyword template " + err.Error(), Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+	if cfg.Enabled {
+		if _, err := h.repo.GetTemplateByKey(cfg.TemplateKey); err != nil {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "Template not found", Code: "TEMPLATE_NOT_FOUND",
+			})
+			return
+		}
+	}
+
+	// Keep the existing token unless the caller supplied a new one; token holds a new one in plaintext
+	token := cfg.Token
+	if token == "" || token == "******" {
+		old, err := h.loadConfig(service)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, models.ApiResponse{
+				Success: false, Error: "Failed to retrieve configuration", Code: "DATABASE_ERROR",
+			})
+			return
+		}
+		token, cfg.Token = "", old.Token
+	}
+	if cfg.Token == "" {
+		generated, err := generateCompatToken(compatTokenPrefixes[service])
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, models.ApiResponse{
+				Success: false, Error: "Failed to generate token", Code: "INTERNAL_ERROR",
+			})
+			return
+		}
+		token = generated
+	}
+	if token != "" {
+		cfg.Token = services.HashCompatToken(service, token)
+	}
+
+	if err := h.repo.SetJSONConfig(services.CompatConfigKeyPrefix+service, cfg); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to save configuration", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+
+	// Only a new token is shown, this once
+	if token == "" {
+		cfg.Token = services.MaskSecret(cfg.Token)
+	} else {
+		cfg.Token = token
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: cfg})
+}
+
+// Setup returns what to paste into a system to point it at its endpoint, with the endpoint's URL
+// filled in: the parameters and script of a Zabbix webhook media type, or the Argo CD
+// notifications ConfigMap entries and the annotation subscribing an application. Save the
+// endpoint first so it has a token. The token is stored hashed, so the URL carries a <token>
+// placeholder to replace with the token shown when it was generated.
+// GET /api/config/compat/:service/setup
+func (h *CompatHandler) Setup(c *gin.Context) {
+	service, ok := compatService(c)
+	if !ok {
+		return
+	}
+	if service != models.CompatZabbix && service != models.CompatArgoCD {
+		c.JSON(http.StatusNotFound, models.ApiResponse{
+			Success: false, Error: "No setup for this endpoint", Code: "NOT_FOUND",
+		})
+		return
+	}
+	cfg, err := h.loadConfig(service)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve configuration", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	if cfg.Token == "" {
+		c.JSON(http.StatusConflict, models.ApiResponse{
+			Success: false, Error: "Save the endpoint first", Code: "NO_TOKEN",
+		})
+		return
+	}
+
+	endpointURL := h.baseURL + "/api/webhook/" + service + "/<token>"
+	if service == models.CompatZabbix {
+		parameters := append([]services.ZabbixParameter(nil), services.ZabbixParameters...)
+		parameters[0].Value = endpointURL
+		c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: gin.H{
+			"type":       "webhook",
+			"parameters": parameters,
+			"script":     services.ZabbixScript,
+		}})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: gin.H{
+		"configMap":  services.ArgoCDConfigMap(endpointURL),
+		"annotation": services.ArgoCDSubscribeAnnotation,
+	}})
+}
+
+// PushPlus sends a notification from a PushPlus style request, answering in PushPlus's response format
+// GET|POST /send
+func (h *CompatHandler) PushPlus(c *gin.Context) {
+	var req pushPlusRequest
+	if err := bindKeepingBody(c, &req); err != nil {
+		c.JSON(http.StatusBadRequest, pushPlusResponse{Code: pushPlusCodeInvalid, Msg: "invalid request"})
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		c.JSON(http.StatusBadRequest, pushPlusResponse{Code: pushPlusCodeInvalid, Msg: "content is required"})
+		return
+	}
+
+	response, status, err := h.send(c, models.CompatPushPlus, req.Token, map[string]string{
+		"title":   req.Title,
+		"content": req.Content,
+	})
+	switch {
+	case status == http.StatusUnauthorized:
+		c.JSON(status, pushPlusResponse{Code: pushPlusCodeUnauthorized, Msg: err.Error()})
+	case err != nil:
+		c.JSON(status, pushPlusResponse{Code: pushPlusCodeSendFailed, Msg: err.Error()})
+	default:
+		c.JSON(http.StatusOK, pushPlusResponse{Code: pushPlusCodeOK, Msg: "请求成功", Data: strconv.FormatInt(response.MessageID, 10)})
+	}
+}
+
+// WxPusher sends a notification from a WxPusher style request, answering in WxPusher's response format
+// GET|POST /api/send/message
+func (h *CompatHandler) WxPusher(c *gin.Context) {
+	var req wxPusherRequest
+	if err := bindKeepingBody(c, &req); err != nil {
+		c.JSON(http.StatusBadRequest, wxPusherResponse{Code: wxPusherCodeError, Msg: "invalid request"})
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		c.JSON(http.StatusBadRequest, wxPusherResponse{Code: wxPusherCodeError, Msg: "content is required"})
+		return
+	}
+	// WxPusher shows the start of the content when no summary is given
+	if req.Summary == "" {
+		req.Summary = services.TruncateRunes(req.Content, 20)
+	}
+
+	response, status, err := h.send(c, models.CompatWxPusher, req.AppToken, map[string]string{
+		"summary": req.Summary,
+		"content": req.Content,
+	})
+	if err != nil {
+		c.JSON(status, wxPusherResponse{Code: wxPusherCodeError, Msg: err.Error()})
+		return
+	}
+
+	data := make([]gin.H, 0, len(response.Results))
+	for _, r := range response.Results {
+		item := gin.H{"uid": strconv.FormatInt(r.RecipientID, 10), "messageId": response.MessageID, "code": wxPusherCodeOK, "status": "创建发送任务成功"}
+		if !r.Success {
+			item["code"], item["status"] = wxPusherCodeError, r.Error
+		}
+		data = append(data, item)
+	}
+	c.JSON(http.StatusOK, wxPusherResponse{Code: wxPusherCodeOK, Msg: "处理成功", Data: data, Success: true})
+}
+
+// send checks the token against a compat endpoint's config and sends the request parts through its template.
+// On error it returns the HTTP status to answer with.
+func (h *CompatHandler) send(c *gin.Context, service, token string, parts map[string]string) (services.SendResponse, int, error) {
+	cfg, status, err := h.endpoint(service, token)
+	if err != nil {
+		return services.SendResponse{}, status, err
+	}
+	return h.deliver(sendContext(c), service, cfg, parts, compatRequest(c, service))
+}
+
+// endpoint returns a compat endpoint's config if it's enabled and token matches.
+// On error it returns the HTTP status to answer with.
+func (h *CompatHandler) endpoint(service, token string) (*models.CompatEndpointConfig, int, error) {
+	cfg, err := h.loadConfig(service)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("failed to retrieve configuration")
+	}
+	if !services.CheckCompatToken(service, cfg, token) {
+		return nil, http.StatusUnauthorized, errors.New("invalid token")
+	}
+	return cfg, http.StatusOK, nil
+}
+
+// deliver sends the request parts through a compat endpoint's template, filling in the keywords
+// of its keyword templates from the inbound request. A url part becomes the link opened by
+// tapping the message.
+// On error it returns the HTTP status to answer with.
+func (h *CompatHandler) deliver(ctx context.Context, service string, cfg *models.CompatEndpointConfig, parts map[string]string, in compatInbound) (services.SendResponse, int, error) {
+	keywords := compatKeywords(service, parts, cfg.FieldMap)
+	if len(cfg.KeywordTemplates) > 0 {
+		templates, err := services.ParseKeywordTemplates(cfg.KeywordTemplates)
+		if err != nil {
+			return services.SendResponse{}, http.StatusInternalServerError, fmt.Errorf("invalid keyword template %v", err)
+		}
+		extracted, err := templates.Execute(in.body, in.query, parts)
+		if err != nil {
+			return services.SendResponse{}, http.StatusBadRequest, fmt.Errorf("keyword template %v", err)
+		}
+		for field, value := range extracted {
+			keywords[field] = services.TruncateRunes(value, services.MaxKeywordLength)
+		}
+	}
+
+	return deliverPush(ctx, h.repo, h.sender, cfg.TemplateKey, cfg.RecipientIDs, keywords, services.SendOptions{
+		Source: in.source,
+		URL:    parts["url"],
+	})
+}
+
+// deliverPush sends the keywords through an endpoint's template to its recipients, everyone when
+// it names none. The compat and Server酱 endpoints share it.
+// On error it returns the HTTP status to answer with.
+func deliverPush(ctx context.Context, repo *repository.SQLiteRepository, sender *services.Sender, templateKey string, recipientIDs []int64, keywords map[string]string, opts services.SendOptions) (services.SendResponse, int, error) {
+	template, err := repo.GetTemplateByKey(templateKey)
+	if err != nil {
+		return services.SendResponse{}, http.StatusBadRequest, errors.New("template not found")
+	}
+	recipients, err := services.LoadRecipients(repo, recipientIDs)
+	if err != nil {
+		return services.SendResponse{}, http.StatusInternalServerError, errors.New("failed to get recipients")
+	}
+	response, err := sender.Send(ctx, template, recipients, keywords, opts)
+	if err != nil {
+		return response, http.StatusInternalServerError, err
+	}
+	return response, http.StatusOK, nil
+}
+
+// generateCompatToken returns a random endpoint token starting with prefix
+func generateCompatToken(prefix string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(b), nil
+}
+
+func (h *CompatHandler) loadConfig(service string) (*models.CompatEndpointConfig, error) {
+	cfg := &models.CompatEndpointConfig{}
+	if err := h.repo.GetJSONConfig(services.CompatConfigKeyPrefix+service, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// compatInbound is what a compat endpoint received: where from, for the message history, and
+// the body and query parameters its keyword templates read
+type compatInbound struct {
+	source models.MessageSource
+	body   interface{}
+	query  map[string]string
+}
+
+// compatRequest describes the inbound request of a compat endpoint. The body is the one kept by
+// bindKeepingBody or set under gin.BodyBytesKey, else the form values.
+func compatRequest(c *gin.Context, service string) compatInbound {
+	in := compatInbound{
+		source: requestSource(c, service, services.CompatConfigKeyPrefix+service),
+		query:  firstValues(c.Request.URL.Query()),
+	}
+	if raw, ok := c.Get(gin.BodyBytesKey); ok {
+		if body, ok := raw.([]byte); ok {
+			in.body = services.DecodeKeywordBody(body)
+		}
+	} else if c.Request.PostForm != nil {
+		in.body = firstValues(c.Request.PostForm)
+	}
+	return in
+}
+
+// firstValues keeps the first value of each query or form parameter
+func firstValues(values map[string][]string) map[string]string {
+	first := make(map[string]string, len(values))
+	for key, v := range values {
+		if len(v) > 0 {
+			first[key] = v[0]
+		}
+	}
+	return first
+}
+
+// bindKeepingBody binds the request like ShouldBind, keeping a JSON or XML body for the keyword
+// templates of the endpoint
+func bindKeepingBody(c *gin.Context, obj interface{}) error {
+	b := binding.Default(c.Request.Method, c.ContentType())
+	if body, ok := b.(binding.BindingBody); ok {
+		return c.ShouldBindBodyWith(obj, body)
+	}
+	return c.ShouldBindWith(obj, b)
+}
+
+// compatService reads the :service parameter, answering 404 for services without a compat endpoint
+func compatService(c *gin.Context) (string, bool) {
+	service := c.Param("service")
+	if _, ok := defaultCompatFieldMaps[service]; !ok {
+		c.JSON(http.StatusNotFound, models.ApiResponse{
+			Success: false, Error: "Unknown compat service", Code: "NOT_FOUND",
+		})
+		return "", false
+	}
+	return service, true
+}
+
+// compatKeywords maps request parts to template fields
+func compatKeywords(service string, parts map[string]string, fieldMap map[string]string) map[string]string {
+	if len(fieldMap) == 0 {
+		fieldMap = defaultCompatFieldMaps[service]
+	}
+	return mapKeywords(parts, fieldMap)
+}
+
+// mapKeywords fills each template field with the request part the field map names
+func mapKeywords(parts map[string]string, fieldMap map[string]string) map[string]string {
+	keywords := make(map[string]string, len(fieldMap))
+	for field, part := range fieldMap {
+		keywords[field] = services.TruncateRunes(parts[part], services.MaxKeywordLength)
+	}
+	return keywords
+}
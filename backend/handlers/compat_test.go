@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"wechat-notification/models"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCompatKeywordsDefaultMapping(t *testing.T) {
+	keywords := compatKeywords(models.CompatPushPlus, map[string]string{"title": "备份失败", "content": "磁盘已满"}, nil)
+	if keywords["first"] != "备份失败" || keywords["keyword1"] != "磁盘已满" {
+		t.Errorf("unexpected PushPlus keywords: %v", keywords)
+	}
+
+	keywords = compatKeywords(models.CompatWxPusher, map[string]string{"summary": "摘要", "content": "正文"}, map[string]string{"remark": "content"})
+	if len(keywords) != 1 || keywords["remark"] != "正文" {
+		t.Errorf("configured field map should be used: %v", keywords)
+	}
+}
+
+func TestNASParts(t *testing.T) {
+	parts := synologyParts(synologyRequest{Text: "Drive 2 on NAS01 has failed\nPlease replace it."})
+	if parts["title"] != "Drive 2 on NAS01 has failed" || parts["content"] != "Drive 2 on NAS01 has failed\nPlease replace it." {
+		t.Errorf("unexpected Synology parts: %v", parts)
+	}
+
+	parts = qnapParts(qnapRequest{Content: "RAID group 1 is degraded", Level: "warning", Category: "Storage & Snapshots", Hostname: "qnap01"})
+	if parts["title"] != "[warning] Storage & Snapshots" || parts["content"] != "RAID group 1 is degraded" || parts["hostname"] != "qnap01" {
+		t.Errorf("unexpected QNAP parts: %v", parts)
+	}
+	keywords := compatKeywords(models.CompatQNAP, parts, nil)
+	if keywords["first"] != "[warning] Storage & Snapshots" || keywords["keyword1"] != "RAID group 1 is degraded" {
+		t.Errorf("unexpected QNAP keywords: %v", keywords)
+	}
+}
+
+func TestGitHubRejectsOversizedBody(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+	repo.SetJSONConfig(services.CompatConfigKeyPrefix+models.CompatGitHub, &models.CompatEndpointConfig{Enabled: true, Token: "gh-secret"})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handler := NewCompatHandler(repo, services.NewSender(repo, services.NewWeChatService(services.NewTokenManager("app", "secret"), "tpl"), ""))
+	router.POST("/api/webhook/github", handler.GitHub)
+
+	post := func(body []byte, signature string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/webhook/github", bytes.NewReader(body))
+		req.Header.Set(services.GitHubSignatureHeader, signature)
+		req.Header.Set("X-GitHub-Event", "ping")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	sign := func(body []byte) string {
+		mac := hmac.New(sha256.New, []byte("gh-secret"))
+		mac.Write(body)
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	big := []byte(`{"zen":"` + strings.Repeat("a", maxGitHubBody) + `"}`)
+	if w := post(big, sign(big)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body: status %d, body %s", w.Code, w.Body.String())
+	}
+	small := []byte(`{"zen":"hi"}`)
+	if w := post(small, sign([]byte(`{"zen":"other"}`))); w.Code != http.StatusUnauthorized {
+		t.Errorf("bad signature: status %d", w.Code)
+	}
+	if w := post(small, sign(small)); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"ignored":true`) {
+		t.Errorf("signed ping: status %d, body %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCompatPushAuthAndSend(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+	repo.CreateTemplate(&models.MessageTemplate{Key: "alert", TemplateID: "tpl", Name: "告警"})
+	repo.Create(&models.Recipient{OpenID: "o1", Name: "张三"})
+	repo.SetJSONConfig(services.CompatConfigKeyPrefix+models.CompatPushPlus, &models.CompatEndpointConfig{Enabled: true, Token: services.HashCompatToken(models.CompatPushPlus, "pp-token"), TemplateKey: "alert"})
+	repo.SetJSONConfig(services.CompatConfigKeyPrefix+models.CompatWxPusher, &models.CompatEndpointConfig{Token: services.HashCompatToken(models.CompatWxPusher, "AT_token"), TemplateKey: "alert"})
+
+	client := &canaryClient{}
+	tokens := services.NewTokenManagerWithClient("app", "secret", client)
+	handler := NewCompatHandler(repo, services.NewSender(repo, services.NewWeChatServiceWithClient(tokens, "tpl", client), ""))
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/send", handler.PushPlus)
+	router.GET("/api/send/message", handler.WxPusher)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	if w := get("/send?token=wrong&content=hi"); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), `"code":401`) || client.posts != 0 {
+		t.Errorf("bad token: status %d, body %s, %d sends", w.Code, w.Body.String(), client.posts)
+	}
+	// A disabled endpoint refuses even its own token
+	if w := get("/api/send/message?appToken=AT_token&content=hi"); w.Code != http.StatusUnauthorized || client.posts != 0 {
+		t.Errorf("disabled endpoint: status %d, %d sends", w.Code, client.posts)
+	}
+	w := get("/send?token=pp-token&title=t&content=hi")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"code":200`) || client.posts != 1 {
+		t.Errorf("valid push: status %d, body %s, %d sends", w.Code, w.Body.String(), client.posts)
+	}
+}
+
+func TestCompatConfigTokenShownOnce(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+	repo.CreateTemplate(&models.MessageTemplate{Key: "alert", TemplateID: "tpl", Name: "告警"})
+
+	handler := NewCompatHandler(repo, services.NewSender(repo, services.NewWeChatService(services.NewTokenManager("app", "secret"), "tpl"), ""))
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/config/compat/:service", handler.GetConfig)
+	router.PUT("/api/config/compat/:service", handler.SaveConfig)
+
+	call := func(method, body string) models.CompatEndpointConfig {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/api/config/compat/wxpusher", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		var resp struct {
+			Data models.CompatEndpointConfig `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, body %s", method, w.Code, w.Body.String())
+		}
+		return resp.Data
+	}
+
+	generated := call(http.MethodPut, `{"enabled":true,"templateKey":"alert"}`)
+	if !strings.HasPrefix(generated.Token, "AT_") {
+		t.Fatalf("generated token not returned: %q", generated.Token)
+	}
+	var stored models.CompatEndpointConfig
+	repo.GetJSONConfig(services.CompatConfigKeyPrefix+models.CompatWxPusher, &stored)
+	if stored.Token == generated.Token || !services.CheckCompatToken(models.CompatWxPusher, &stored, generated.Token) {
+		t.Errorf("token stored as %q", stored.Token)
+	}
+
+	// Afterwards the token stays masked, also when the masked value is saved back
+	if got := call(http.MethodGet, ""); got.Token != "******" {
+		t.Errorf("GetConfig token = %q", got.Token)
+	}
+	if got := call(http.MethodPut, `{"enabled":true,"templateKey":"alert","token":"******"}`); got.Token != "******" {
+		t.Errorf("resaved token = %q", got.Token)
+	}
+	repo.GetJSONConfig(services.CompatConfigKeyPrefix+models.CompatWxPusher, &stored)
+	if !services.CheckCompatToken(models.CompatWxPusher, &stored, generated.Token) {
+		t.Error("saving the masked token replaced it")
+	}
+}
+
+func TestJenkinsAuthenticatesBeforeReadingBody(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+	repo.SetJSONConfig(services.CompatConfigKeyPrefix+models.CompatJenkins, &models.CompatEndpointConfig{
+		Enabled: true, Token: services.HashCompatToken(models.CompatJenkins, "jk-token"), TemplateKey: "alert"})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handler := NewCompatHandler(repo, services.NewSender(repo, services.NewWeChatService(services.NewTokenManager("app", "secret"), "tpl"), ""))
+	router.POST("/api/webhook/jenkins/:token", handler.Jenkins)
+
+	post := func(token, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/webhook/jenkins/"+token, strings.NewReader(body)))
+		return w
+	}
+
+	if w := post("wrong", `not json`); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token with a bad body: status %d, body %s", w.Code, w.Body.String())
+	}
+	if w := post("jk-token", `{"name":"`+strings.Repeat("a", maxBuildNotificationBody)+`"}`); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body: status %d", w.Code)
+	}
+	if w := post("jk-token", `{"name":"deploy"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("missing phase: status %d", w.Code)
+	}
+	if w := post("jk-token", `{"name":"deploy","build":{"phase":"STARTED"}}`); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"ignored":true`) {
+		t.Errorf("started build: status %d, body %s", w.Code, w.Body.String())
+	}
+}
+
+func TestArgoCDAuthenticatesBeforeReadingBody(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+	repo.SetJSONConfig(services.CompatConfigKeyPrefix+models.CompatArgoCD, &models.CompatEndpointConfig{
+		Enabled: true, Token: services.HashCompatToken(models.CompatArgoCD, "argo-token"), TemplateKey: "alert"})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handler := NewCompatHandler(repo, services.NewSender(repo, services.NewWeChatService(services.NewTokenManager("app", "secret"), "tpl"), ""))
+	router.POST("/api/webhook/argocd/:token", handler.ArgoCD)
+
+	post := func(token, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/webhook/argocd/"+token, strings.NewReader(body)))
+		return w
+	}
+
+	if w := post("wrong", `{}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token with a bad body: status %d, body %s", w.Code, w.Body.String())
+	}
+	if w := post("argo-token", `{"app":"`+strings.Repeat("a", maxBuildNotificationBody)+`"}`); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body: status %d", w.Code)
+	}
+	if w := post("argo-token", `{"app":"web"}`); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "VALIDATION_ERROR") {
+		t.Errorf("missing phase and health: status %d, body %s", w.Code, w.Body.String())
+	}
+}
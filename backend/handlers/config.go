@@ -2,7 +2,10 @@ package handlers
 
 import (
 	"net/http"
+	"strings"
+	"time"
 
+	"wechat-notification/config"
 	"wechat-notification/models"
 	"wechat-notification/repository"
 	"wechat-notification/services"
@@ -15,11 +18,18 @@ type ConfigHandler struct {
 	repo         *repository.SQLiteRepository
 	tokenManager *services.TokenManager
 	wechatSvc    *services.WeChatService
+	envWeChat    config.WeChatConfig
+	warnings     *services.ConfigWarnings
 }
 
 // NewConfigHandler creates a new config handler
-func NewConfigHandler(repo *repository.SQLiteRepository, tokenManager *services.TokenManager, wechatSvc *services.WeChatService) *ConfigHandler {
-	return &ConfigHandler{repo: repo, tokenManager: tokenManager, wechatSvc: wechatSvc}
+func NewConfigHandler(cfg *config.Config, repo *repository.SQLiteRepository, tokenManager *services.TokenManager, wechatSvc *services.WeChatService) *ConfigHandler {
+	return &ConfigHandler{repo: repo, tokenManager: tokenManager, wechatSvc: wechatSvc, envWeChat: cfg.WeChat}
+}
+
+// SetWarnings attaches the configuration warnings surfaced with the WeChat config
+func (h *ConfigHandler) SetWarnings(warnings *services.ConfigWarnings) {
+	h.warnings = warnings
 }
 
 // GetWeChatConfig returns the current WeChat configuration
@@ -38,13 +48,14 @@ func (h *ConfigHandler) GetWeChatConfig(c *gin.Context) {
 	// Mask the app secret for security
 	maskedConfig := &models.WeChatConfig{
 		AppID:      config.AppID,
-		AppSecret:  maskSecret(config.AppSecret),
+		AppSecret:  services.MaskSecret(config.AppSecret),
 		TemplateID: config.TemplateID,
 	}
 
 	c.JSON(http.StatusOK, models.ApiResponse{
-		Success: true,
-		Data:    maskedConfig,
+		Success:  true,
+		Data:     maskedConfig,
+		Warnings: h.warnings.Collect(time.Now()),
 	})
 }
 
@@ -62,10 +73,41 @@ func (h *ConfigHandler) SaveWeChatConfig(c *gin.Context) {
 	}
 
 	// If secret is masked, keep the old one
-	if config.AppSecret == "" || config.AppSecret == "******" {
-		oldConfig, _ := h.repo.GetWeChatConfig()
-		if oldConfig != nil {
-			config.AppSecret = oldConfig.AppSecret
+	oldConfig, _ := h.repo.GetWeChatConfig()
+	if (config.AppSecret == "" || config.AppSecret == "******") && oldConfig != nil {
+		config.AppSecret = oldConfig.AppSecret
+	}
+
+	// Try new credentials or a new template on the canary recipient first; nothing is saved if it
+	// doesn't arrive. Saves that leave what's actually used unchanged don't message anyone.
+	effective, _ := services.ResolveWeChatConfig(h.envWeChat, &config)
+	current, _ := services.ResolveWeChatConfig(h.envWeChat, oldConfig)
+	changed := effective.AppID != current.AppID || effective.AppSecret != current.AppSecret || effective.TemplateID != current.TemplateID
+	var canary models.CanaryConfig
+	if err := h.repo.GetJSONConfig(services.CanaryConfigKey, &canary); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false,
+			Error:   "Failed to retrieve configuration",
+			Code:    "DATABASE_ERROR",
+		})
+		return
+	}
+	if canary.Enabled && changed {
+		recipient, err := h.repo.GetByID(canary.RecipientID)
+		if err == nil {
+			err = services.CanarySend(h.wechatSvc.Candidate(effective.AppID, effective.AppSecret, effective.TemplateID), recipient, time.Now())
+		}
+		if err != nil {
+			message := err.Error()
+			if effective.AppSecret != "" {
+				message = strings.ReplaceAll(message, effective.AppSecret, "******")
+			}
+			c.JSON(http.StatusUnprocessableEntity, models.ApiResponse{
+				Success: false,
+				Error:   "Canary send failed, configuration not saved: " + message,
+				Code:    "CANARY_FAILED",
+			})
+			return
 		}
 	}
 
@@ -78,9 +120,9 @@ func (h *ConfigHandler) SaveWeChatConfig(c *gin.Context) {
 		return
 	}
 
-	// Update token manager and wechat service with new config
-	h.tokenManager.UpdateCredentials(config.AppID, config.AppSecret)
-	h.wechatSvc.UpdateTemplateID(config.TemplateID)
+	// Update token manager and wechat service with the newly effective config
+	h.tokenManager.UpdateCredentials(effective.AppID, effective.AppSecret)
+	h.wechatSvc.UpdateTemplateID(effective.TemplateID)
 
 	c.JSON(http.StatusOK, models.ApiResponse{
 		Success: true,
@@ -88,9 +130,137 @@ func (h *ConfigHandler) SaveWeChatConfig(c *gin.Context) {
 	})
 }
 
-func maskSecret(secret string) string {
-	if len(secret) == 0 {
-		return ""
+// TestWeChatConfigRequest represents the credentials to verify
+type TestWeChatConfigRequest struct {
+	AppID         string `json:"appId" binding:"required"`
+	AppSecret     string `json:"appSecret"`     // Masked or empty to use the saved secret
+	ListTemplates bool   `json:"listTemplates"` // Also list the account's templates with the token
+}
+
+// TestWeChatConfig tries the submitted credentials against WeChat without saving them
+// POST /api/config/wechat/test
+func (h *ConfigHandler) TestWeChatConfig(c *gin.Context) {
+	var req TestWeChatConfigRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Error:   "Invalid request format",
+			Code:    "INVALID_REQUEST",
+		})
+		return
+	}
+
+	// If secret is masked, test the saved one
+	if req.AppSecret == "" || req.AppSecret == "******" {
+		oldConfig, _ := h.repo.GetWeChatConfig()
+		if oldConfig != nil {
+			req.AppSecret = oldConfig.AppSecret
+		}
+	}
+
+	check := h.wechatSvc.VerifyCredentials(req.AppID, req.AppSecret, req.ListTemplates)
+	if !check.OK {
+		message := check.Error
+		if check.ErrMsg != "" {
+			message = check.ErrMsg
+		}
+		c.JSON(http.StatusUnprocessableEntity, models.ApiResponse{
+			Success: false,
+			Data:    check,
+			Error:   message,
+			Code:    "CREDENTIALS_INVALID",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: check})
+}
+
+// GetTokenStatus reports the cached access token's expiry and the last refresh error, so
+// credential problems can be debugged without reading logs
+// GET /api/config/wechat/token
+func (h *ConfigHandler) GetTokenStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success:  true,
+		Data:     h.tokenManager.Status(),
+		Warnings: h.warnings.Collect(time.Now()),
+	})
+}
+
+// GetCanaryConfig returns the canary send settings for WeChat config changes
+// GET /api/config/wechat/canary
+func (h *ConfigHandler) GetCanaryConfig(c *gin.Context) {
+	var canary models.CanaryConfig
+	if err := h.repo.GetJSONConfig(services.CanaryConfigKey, &canary); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false,
+			Error:   "Failed to retrieve configuration",
+			Code:    "DATABASE_ERROR",
+		})
+		return
 	}
-	return "******"
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: canary})
+}
+
+// SaveCanaryConfig designates the recipient that verifies WeChat config changes
+// PUT /api/config/wechat/canary
+func (h *ConfigHandler) SaveCanaryConfig(c *gin.Context) {
+	var canary models.CanaryConfig
+	if err := c.ShouldBindJSON(&canary); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Error:   "Invalid request format",
+			Code:    "INVALID_REQUEST",
+		})
+		return
+	}
+	if canary.Enabled {
+		if _, err := h.repo.GetByID(canary.RecipientID); err != nil {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false,
+				Error:   "Canary recipient not found",
+				Code:    "RECIPIENT_NOT_FOUND",
+			})
+			return
+		}
+	}
+
+	if err := h.repo.SetJSONConfig(services.CanaryConfigKey, canary); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false,
+			Error:   "Failed to save configuration",
+			Code:    "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: canary})
+}
+
+// GetConfigDiff reports the effective WeChat settings, where each came from and
+// whether the environment and database disagree
+// GET /api/config/diff
+func (h *ConfigHandler) GetConfigDiff(c *gin.Context) {
+	dbConfig, err := h.repo.GetWeChatConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false,
+			Error:   "Failed to retrieve configuration",
+			Code:    "DATABASE_ERROR",
+		})
+		return
+	}
+
+	_, fields := services.ResolveWeChatConfig(h.envWeChat, dbConfig)
+	drift := false
+	for _, f := range fields {
+		drift = drift || f.Drift
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Data: gin.H{
+			"precedence": services.WeChatConfigPrecedence,
+			"drift":      drift,
+			"fields":     fields,
+		},
+	})
 }
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"wechat-notification/config"
+	"wechat-notification/models"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// canaryClient answers token requests and counts template messages, failing them like an
+// unreachable WeChat when fail is set
+type canaryClient struct {
+	posts int
+	fail  bool
+}
+
+func (m *canaryClient) Get(url string) (*http.Response, error) {
+	return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"access_token":"tok_s3cret","expires_in":7200}`))}, nil
+}
+
+func (m *canaryClient) Post(target, contentType string, body io.Reader) (*http.Response, error) {
+	m.posts++
+	if m.fail {
+		return nil, &url.Error{Op: "Post", URL: target, Err: errors.New("connection refused")}
+	}
+	return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"errcode":0,"errmsg":"ok"}`))}, nil
+}
+
+func TestSaveWeChatConfigCanary(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	client := &canaryClient{}
+	tokens := services.NewTokenManagerWithClient("", "", client)
+	handler := NewConfigHandler(&config.Config{}, repo, tokens, services.NewWeChatServiceWithClient(tokens, "", client))
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/config/wechat", handler.SaveWeChatConfig)
+
+	canary := &models.Recipient{OpenID: "o-canary", Name: "canary"}
+	repo.Create(canary)
+	repo.SetJSONConfig(services.CanaryConfigKey, models.CanaryConfig{Enabled: true, RecipientID: canary.ID})
+
+	save := func(cfg models.WeChatConfig) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(cfg)
+		req := httptest.NewRequest(http.MethodPost, "/api/config/wechat", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	cfg := models.WeChatConfig{AppID: "wx1", AppSecret: "app-s3cret", TemplateID: "tpl1"}
+	if w := save(cfg); w.Code != http.StatusOK || client.posts != 1 {
+		t.Fatalf("first save: status %d, %d canary sends", w.Code, client.posts)
+	}
+	// Saving the same settings again, with the secret masked, messages nobody
+	if w := save(models.WeChatConfig{AppID: "wx1", AppSecret: "******", TemplateID: "tpl1"}); w.Code != http.StatusOK || client.posts != 1 {
+		t.Errorf("unchanged save: status %d, %d canary sends", w.Code, client.posts)
+	}
+
+	// A new template is tried first, and a failure doesn't show the access token
+	client.fail = true
+	cfg.TemplateID = "tpl2"
+	w := save(cfg)
+	if w.Code != http.StatusUnprocessableEntity || client.posts != 2 {
+		t.Fatalf("changed template: status %d, %d canary sends", w.Code, client.posts)
+	}
+	if body := w.Body.String(); strings.Contains(body, "tok_s3cret") || strings.Contains(body, "app-s3cret") || !strings.Contains(body, "connection refused") {
+		t.Errorf("canary failure: %s", body)
+	}
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"wechat-notification/logger"
+	"wechat-notification/models"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Watchtower sends the container updates of a Watchtower run. Point Watchtower's shoutrrr URL at
+// generic+https://<server>/api/webhook/watchtower/<token>; the host is read from the notification
+// title, or from the host query parameter when the title doesn't name one.
+// POST /api/webhook/watchtower/:token
+func (h *CompatHandler) Watchtower(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Failed to read request body", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+	c.Set(gin.BodyBytesKey, body)
+	host, lines := services.ParseWatchtower(body)
+	if q := strings.TrimSpace(c.Query("host")); q != "" {
+		host = q
+	}
+	if len(lines) == 0 {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "message is required", Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+	h.containerUpdates(c, models.CompatWatchtower, host, lines)
+}
+
+// Diun sends an image update reported by Diun's webhook notifier
+// POST /api/webhook/diun/:token
+func (h *CompatHandler) Diun(c *gin.Context) {
+	var event services.DiunEvent
+	if err := c.ShouldBindBodyWith(&event, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+	if strings.TrimSpace(event.Image) == "" {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "image is required", Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+	h.containerUpdates(c, models.CompatDiun, event.Hostname, []string{event.Line()})
+}
+
+// containerUpdates sends a host's update lines right away, or, when the endpoint groups updates,
+// adds them to the host's pending message and answers 202
+func (h *CompatHandler) containerUpdates(c *gin.Context, service, host string, lines []string) {
+	if host == "" {
+		host = service
+	}
+	cfg, status, err := h.endpoint(service, c.Param("token"))
+	if err != nil {
+		c.JSON(status, models.ApiResponse{Success: false, Error: err.Error(), Code: "UNAUTHORIZED"})
+		return
+	}
+	in := compatRequest(c, service)
+
+	if cfg.GroupSeconds <= 0 {
+		response, status, err := h.deliver(sendContext(c), service, cfg, services.ContainerUpdateParts(host, lines), in)
+		if err != nil {
+			c.JSON(status, models.ApiResponse{Success: false, Error: err.Error(), Code: "SEND_FAILED"})
+			return
+		}
+		c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: response})
+		return
+	}
+
+	// A grouped message spans several requests, so keyword templates get no body or query
+	grouped := compatInbound{source: in.source}
+	window := time.Duration(cfg.GroupSeconds) * time.Second
+	h.updates.Add(service+"/"+host, lines, window, func(all []string) {
+		if _, _, err := h.deliver(context.Background(), service, cfg, services.ContainerUpdateParts(host, all), grouped); err != nil {
+			logger.Warnf("%s: failed to send %d updates for %s: %v", service, len(all), host, err)
+		}
+	})
+	c.JSON(http.StatusAccepted, models.ApiResponse{Success: true, Data: gin.H{"host": host, "groupSeconds": cfg.GroupSeconds}})
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// customAdapter names custom endpoints in the message history
+const customAdapter = "custom"
+
+// customSlugPattern limits slugs to what reads well in a URL
+var customSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// CustomEndpointRequest represents the request body for creating or updating a custom endpoint.
+// An empty token keeps the endpoint's token, or generates one for a new endpoint; a chosen token
+// needs at least services.MinCustomEndpointTokenLength characters.
+type CustomEndpointRequest struct {
+	Name             string            `json:"name" binding:"required"`
+	Slug             string            `json:"slug" binding:"required"`
+	Token            string            `json:"token"`
+	TemplateKey      string            `json:"templateKey" binding:"required"`
+	RecipientIDs     []int64           `json:"recipientIds"`
+	KeywordTemplates map[string]string `json:"keywordTemplates" binding:"required"`
+	Enabled          *bool             `json:"enabled"`
+}
+
+// ListCustomEndpoints returns all custom endpoints, without their tokens
+// GET /api/custom-endpoints
+func (h *CompatHandler) ListCustomEndpoints(c *gin.Context) {
+	endpoints, err := h.repo.GetAllCustomEndpoints()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to get custom endpoints", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	for i := range endpoints {
+		endpoints[i].Token = ""
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: endpoints})
+}
+
+// CreateCustomEndpoint creates a custom endpoint, served right away at /api/webhook/custom/:slug.
+// Only the token's hash is stored, so the response is the only place it's shown.
+// POST /api/custom-endpoints
+func (h *CompatHandler) CreateCustomEndpoint(c *gin.Context) {
+	endpoint, ok := h.bindCustomEndpoint(c)
+	if !ok {
+		return
+	}
+	token := endpoint.Token
+	if token == "" {
+		var err error
+		if token, err = generateCompatToken(""); err != nil {
+			c.JSON(http.StatusInternalServerError, models.ApiResponse{
+				Success: false, Error: "Failed to generate token", Code: "INTERNAL_ERROR",
+			})
+			return
+		}
+	}
+	endpoint.Token = services.HashCustomEndpointToken(token)
+
+	if err := h.repo.CreateCustomEndpoint(endpoint); err != nil {
+		writeCustomEndpointError(c, err, "Failed to create custom endpoint")
+		return
+	}
+	endpoint.Token = token
+	c.JSON(http.StatusCreated, models.ApiResponse{Success: true, Data: endpoint})
+}
+
+// RotateCustomEndpointToken replaces a custom endpoint's token right away and returns the new
+// one, the only time it's shown
+// POST /api/custom-endpoints/:id/token
+func (h *CompatHandler) RotateCustomEndpointToken(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	endpoint, err := h.repo.GetCustomEndpointByID(id)
+	if err != nil {
+		writeCustomEndpointError(c, err, "Failed to retrieve custom endpoint")
+		return
+	}
+	token, err := generateCompatToken("")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to generate token", Code: "INTERNAL_ERROR",
+		})
+		return
+	}
+	endpoint.Token = services.HashCustomEndpointToken(token)
+	if err := h.repo.UpdateCustomEndpoint(endpoint); err != nil {
+		writeCustomEndpointError(c, err, "Failed to update custom endpoint")
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: gin.H{"token": token}})
+}
+
+// UpdateCustomEndpoint updates a custom endpoint; requests use the new settings right away
+// PUT /api/custom-endpoints/:id
+func (h *CompatHandler) UpdateCustomEndpoint(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	endpoint, ok := h.bindCustomEndpoint(c)
+	if !ok {
+		return
+	}
+	endpoint.ID = id
+	if endpoint.Token == "" {
+		old, err := h.repo.GetCustomEndpointByID(id)
+		if err != nil {
+			writeCustomEndpointError(c, err, "Failed to retrieve custom endpoint")
+			return
+		}
+		endpoint.Token = old.Token
+	} else {
+		endpoint.Token = services.HashCustomEndpointToken(endpoint.Token)
+	}
+
+	if err := h.repo.UpdateCustomEndpoint(endpoint); err != nil {
+		writeCustomEndpointError(c, err, "Failed to update custom endpoint")
+		return
+	}
+	updated, err := h.repo.GetCustomEndpointByID(id)
+	if err != nil {
+		writeCustomEndpointError(c, err, "Failed to retrieve custom endpoint")
+		return
+	}
+	updated.Token = ""
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: updated})
+}
+
+// DeleteCustomEndpoint deletes a custom endpoint
+// DELETE /api/custom-endpoints/:id
+func (h *CompatHandler) DeleteCustomEndpoint(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	if err := h.repo.DeleteCustomEndpoint(id); err != nil {
+		writeCustomEndpointError(c, err, "Failed to delete custom endpoint")
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true})
+}
+
+// Custom sends any JSON, form or text body through a custom endpoint, filling its template with
+// the endpoint's keyword templates. The endpoint's token is sent as a Bearer token.
+// POST /api/webhook/custom/:slug
+func (h *CompatHandler) Custom(c *gin.Context) {
+	endpoint, err := h.repo.GetCustomEndpointBySlug(c.Param("slug"))
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve configuration", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	// Unknown slugs answer like a wrong token, so slugs can't be probed
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if endpoint == nil || !endpoint.Enabled || !services.CheckCustomEndpointToken(endpoint, token) {
+		c.JSON(http.StatusUnauthorized, models.ApiResponse{Success: false, Error: "invalid token", Code: "UNAUTHORIZED"})
+		return
+	}
+
+	if c.ContentType() == binding.MIMEPOSTForm {
+		err = c.Request.ParseForm()
+	} else {
+		var body []byte
+		if body, err = bufferBody(c); err == nil {
+			c.Set(gin.BodyBytesKey, body)
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Failed to read request body", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+	in := compatRequest(c, customAdapter)
+	in.source.TokenName = "custom_" + endpoint.Slug
+	cfg := &models.CompatEndpointConfig{
+		Enabled:          true,
+		TemplateKey:      endpoint.TemplateKey,
+		RecipientIDs:     endpoint.RecipientIDs,
+		KeywordTemplates: endpoint.KeywordTemplates,
+	}
+	response, status, err := h.deliver(sendContext(c), customAdapter, cfg, map[string]string{}, in)
+	if err != nil {
+		c.JSON(status, models.ApiResponse{Success: false, Error: err.Error(), Code: "SEND_FAILED"})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: response})
+}
+
+func (h *CompatHandler) bindCustomEndpoint(c *gin.Context) (*models.CustomEndpoint, bool) {
+	var req CustomEndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request: name, slug, templateKey and keywordTemplates are required", Code: "INVALID_REQUEST",
+		})
+		return nil, false
+	}
+
+	slug := strings.ToLower(strings.TrimSpace(req.Slug))
+	if !customSlugPattern.MatchString(slug) {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Slug must be up to 63 lowercase letters, digits or '-'", Code: "VALIDATION_ERROR",
+		})
+		return nil, false
+	}
+	if token := strings.TrimSpace(req.Token); token != "" && len(token) < services.MinCustomEndpointTokenLength {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: fmt.Sprintf("Token must be at least %d characters", services.MinCustomEndpointTokenLength), Code: "VALIDATION_ERROR",
+		})
+		return nil, false
+	}
+	if len(req.KeywordTemplates) == 0 {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "At least one keyword template is required", Code: "VALIDATION_ERROR",
+		})
+		return nil, false
+	}
+	if _, err := services.ParseKeywordTemplates(req.KeywordTemplates); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid keyword template " + err.Error(), Code: "VALIDATION_ERROR",
+		})
+		return nil, false
+	}
+	if _, err := h.repo.GetTemplateByKey(req.TemplateKey); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Template not found", Code: "TEMPLATE_NOT_FOUND",
+		})
+		return nil, false
+	}
+
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+	return &models.CustomEndpoint{
+		Name:             strings.TrimSpace(req.Name),
+		Slug:             slug,
+		Token:            strings.TrimSpace(req.Token),
+		TemplateKey:      req.TemplateKey,
+		RecipientIDs:     uniqueIDs(req.RecipientIDs),
+		KeywordTemplates: req.KeywordTemplates,
+		Enabled:          enabled,
+	}, true
+}
+
+func writeCustomEndpointError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		c.JSON(http.StatusNotFound, models.ApiResponse{
+			Success: false, Error: "Custom endpoint not found", Code: "NOT_FOUND",
+		})
+	case errors.Is(err, repository.ErrDuplicateSlug):
+		c.JSON(http.StatusConflict, models.ApiResponse{
+			Success: false, Error: "A custom endpoint with this slug already exists", Code: "DUPLICATE_SLUG",
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: message, Code: "DATABASE_ERROR",
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DeadLetterHandler handles endpoints for messages that used up their retries
+type DeadLetterHandler struct {
+	repo *repository.SQLiteRepository
+}
+
+// NewDeadLetterHandler creates a new dead-letter handler
+func NewDeadLetterHandler(repo *repository.SQLiteRepository) *DeadLetterHandler {
+	return &DeadLetterHandler{repo: repo}
+}
+
+// List returns a page of dead letters, newest first
+// GET /api/deadletter?page=1&pageSize=20
+func (h *DeadLetterHandler) List(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	letters, total, err := h.repo.ListDeadLetters(pageSize, (page-1)*pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to get dead letters", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Data: gin.H{
+			"items":    letters,
+			"total":    total,
+			"page":     page,
+			"pageSize": pageSize,
+		},
+	})
+}
+
+// Retry puts a dead letter back on the retry queue to be sent on its next tick
+// POST /api/deadletter/:id/retry
+func (h *DeadLetterHandler) Retry(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	retry, err := h.repo.RequeueDeadLetter(id, time.Now())
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, models.ApiResponse{
+				Success: false, Error: "Dead letter not found", Code: "NOT_FOUND",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to requeue dead letter", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusAccepted, models.ApiResponse{Success: true, Data: retry})
+}
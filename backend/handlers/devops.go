@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"wechat-notification/models"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// maxGitHubBody is the largest GitHub delivery read; GitHub caps payloads at 25 MB, but the events
+// we notify about are far smaller
+const maxGitHubBody = 1 << 20
+
+// maxBuildNotificationBody is the largest Jenkins or Argo CD notification read; both send small
+// JSON documents
+const maxBuildNotificationBody = 1 << 20
+
+// readBody reads the request body, answering 413 when it's longer than limit rather than
+// cutting it short, which would break a signature over the whole body
+func readBody(c *gin.Context, limit int64) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, limit))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, models.ApiResponse{
+			Success: false, Error: "Request body too large", Code: "PAYLOAD_TOO_LARGE",
+		})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Failed to read request body", Code: "INVALID_REQUEST",
+		})
+		return nil, false
+	}
+	return body, true
+}
+
+// GitHub sends push, release, workflow_run and issues events from a GitHub repository or
+// organization webhook. Deliveries are authenticated by X-Hub-Signature-256, so the endpoint's
+// token goes into the webhook's secret instead of the URL. Other events and actions are
+// acknowledged without sending.
+// POST /api/webhook/github
+func (h *CompatHandler) GitHub(c *gin.Context) {
+	body, ok := readBody(c, maxGitHubBody)
+	if !ok {
+		return
+	}
+	cfg, err := h.loadConfig(models.CompatGitHub)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve configuration", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	if !cfg.Enabled || !services.VerifyGitHubSignature(cfg.Token, c.GetHeader(services.GitHubSignatureHeader), body) {
+		c.JSON(http.StatusUnauthorized, models.ApiResponse{
+			Success: false, Error: "Invalid signature", Code: "UNAUTHORIZED",
+		})
+		return
+	}
+
+	c.Set(gin.BodyBytesKey, body)
+	name := c.GetHeader("X-GitHub-Event")
+	var event services.GitHubEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+	parts, ok := event.Parts(name)
+	if !ok {
+		c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: gin.H{"event": name, "ignored": true}})
+		return
+	}
+
+	response, status, err := h.deliver(sendContext(c), models.CompatGitHub, cfg, parts, compatRequest(c, models.CompatGitHub))
+	if err != nil {
+		c.JSON(status, models.ApiResponse{Success: false, Error: err.Error(), Code: "SEND_FAILED"})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: response})
+}
+
+// GitLab sends push, tag push, pipeline and merge request events from a GitLab project or group
+// webhook. GitLab sends the webhook's secret token in X-Gitlab-Token, so it's set to the
+// endpoint's token. Other events are acknowledged without sending.
+// POST /api/webhook/gitlab
+func (h *CompatHandler) GitLab(c *gin.Context) {
+	cfg, status, err := h.endpoint(models.CompatGitLab, c.GetHeader("X-Gitlab-Token"))
+	if err != nil {
+		c.JSON(status, models.ApiResponse{Success: false, Error: err.Error(), Code: "UNAUTHORIZED"})
+		return
+	}
+	var event services.GitLabEvent
+	if err := c.ShouldBindBodyWith(&event, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+	parts, ok := event.Parts()
+	if !ok {
+		c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: gin.H{"event": event.ObjectKind, "ignored": true}})
+		return
+	}
+
+	response, status, err := h.deliver(sendContext(c), models.CompatGitLab, cfg, parts, compatRequest(c, models.CompatGitLab))
+	if err != nil {
+		c.JSON(status, models.ApiResponse{Success: false, Error: err.Error(), Code: "SEND_FAILED"})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: response})
+}
+
+// Jenkins sends the result of a build reported by the Jenkins Notification plugin, set to JSON
+// over HTTP with the event "Job completed" or "All events". Phases other than COMPLETED are
+// acknowledged without sending.
+// POST /api/webhook/jenkins/:token
+func (h *CompatHandler) Jenkins(c *gin.Context) {
+	cfg, status, err := h.endpoint(models.CompatJenkins, c.Param("token"))
+	if err != nil {
+		c.JSON(status, models.ApiResponse{Success: false, Error: err.Error(), Code: "UNAUTHORIZED"})
+		return
+	}
+	body, ok := readBody(c, maxBuildNotificationBody)
+	if !ok {
+		return
+	}
+
+	c.Set(gin.BodyBytesKey, body)
+	var build services.JenkinsBuild
+	if err := json.Unmarshal(body, &build); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+	if build.Name == "" || build.Build.Phase == "" {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "name and build.phase are required", Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+	parts, ok := build.Parts()
+	if !ok {
+		c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: gin.H{"phase": build.Build.Phase, "ignored": true}})
+		return
+	}
+
+	response, status, err := h.deliver(sendContext(c), models.CompatJenkins, cfg, parts, compatRequest(c, models.CompatJenkins))
+	if err != nil {
+		c.JSON(status, models.ApiResponse{Success: false, Error: err.Error(), Code: "SEND_FAILED"})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: response})
+}
+
+// ArgoCD sends a finished sync or an application's health from Argo CD notifications, set up
+// with the ConfigMap entries Setup returns
+// POST /api/webhook/argocd/:token
+func (h *CompatHandler) ArgoCD(c *gin.Context) {
+	cfg, status, err := h.endpoint(models.CompatArgoCD, c.Param("token"))
+	if err != nil {
+		c.JSON(status, models.ApiResponse{Success: false, Error: err.Error(), Code: "UNAUTHORIZED"})
+		return
+	}
+	body, ok := readBody(c, maxBuildNotificationBody)
+	if !ok {
+		return
+	}
+
+	c.Set(gin.BodyBytesKey, body)
+	var notification services.ArgoCDNotification
+	if err := json.Unmarshal(body, &notification); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+	parts := notification.Parts()
+	if parts["app"] == "" || (parts["phase"] == "" && parts["health"] == "") {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "app and operation_phase or health_status are required", Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+
+	response, status, err := h.deliver(sendContext(c), models.CompatArgoCD, cfg, parts, compatRequest(c, models.CompatArgoCD))
+	if err != nil {
+		c.JSON(status, models.ApiResponse{Success: false, Error: err.Error(), Code: "SEND_FAILED"})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: response})
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DirectoryHandler handles the recipient sync from an external directory
+type DirectoryHandler struct {
+	repo *repository.SQLiteRepository
+	sync *services.DirectorySync
+}
+
+// NewDirectoryHandler creates a new directory handler
+func NewDirectoryHandler(repo *repository.SQLiteRepository, sync *services.DirectorySync) *DirectoryHandler {
+	return &DirectoryHandler{repo: repo, sync: sync}
+}
+
+// GetConfig returns the directory sync configuration with the auth header masked
+// GET /api/config/directory-sync
+func (h *DirectoryHandler) GetConfig(c *gin.Context) {
+	cfg, err := h.loadConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve configuration", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	cfg.AuthHeader = services.MaskSecret(cfg.AuthHeader)
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: cfg})
+}
+
+// SaveConfig sets the directory source, field mapping and sync interval
+// PUT /api/config/directory-sync
+func (h *DirectoryHandler) SaveConfig(c *gin.Context) {
+	var cfg models.DirectorySyncConfig
+	if err := c.ShouldBindJSON(&cfg); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+	switch cfg.Source {
+	case models.DirectorySourceCSV, models.DirectorySourceJSON:
+	case models.DirectorySourceLDAP:
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: services.ErrDirectoryUnsupported.Error(), Code: "UNSUPPORTED_SOURCE",
+		})
+		return
+	default:
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "source must be csv or json", Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+	if u, err := url.Parse(cfg.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "url must be an http(s) URL", Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+	if cfg.IntervalMinutes < 0 {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "intervalMinutes must not be negative", Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+
+	// Keep the stored auth header unless a new one was supplied
+	if cfg.AuthHeader == "******" {
+		old, err := h.loadConfig()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, models.ApiResponse{
+				Success: false, Error: "Failed to retrieve configuration", Code: "DATABASE_ERROR",
+			})
+			return
+		}
+		cfg.AuthHeader = old.AuthHeader
+	}
+
+	if err := h.repo.SetJSONConfig(services.DirectorySyncConfigKey, cfg); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to save configuration", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	cfg.AuthHeader = services.MaskSecret(cfg.AuthHeader)
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: cfg})
+}
+
+// Sync reconciles recipients with the directory right away. With dryRun=true it only
+// returns the diff of what would be created, updated and deactivated.
+// POST /api/recipients/sync
+func (h *DirectoryHandler) Sync(c *gin.Context) {
+	cfg, err := h.loadConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve configuration", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	if cfg.URL == "" {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "No directory configured", Code: "DIRECTORY_NOT_CONFIGURED",
+		})
+		return
+	}
+
+	plan, err := h.sync.Sync(*cfg, c.Query("dryRun") == "true")
+	if plan != nil {
+		plan.Create = maskRecipients(plan.Create)
+		plan.Update = maskRecipients(plan.Update)
+		plan.Deactivate = maskRecipients(plan.Deactivate)
+	}
+	if errors.Is(err, services.ErrDirectoryUnsupported) {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: err.Error(), Code: "UNSUPPORTED_SOURCE",
+		})
+		return
+	}
+	if errors.Is(err, services.ErrDirectoryEmpty) || errors.Is(err, services.ErrTooManyDeactivations) {
+		c.JSON(http.StatusConflict, models.ApiResponse{
+			Success: false, Data: plan, Error: err.Error(), Code: "DIRECTORY_SYNC_ABORTED",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, models.ApiResponse{
+			Success: false, Data: plan, Error: err.Error(), Code: "DIRECTORY_SYNC_FAILED",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: plan})
+}
+
+func (h *DirectoryHandler) loadConfig() (*models.DirectorySyncConfig, error) {
+	cfg := &models.DirectorySyncConfig{}
+	if err := h.repo.GetJSONConfig(services.DirectorySyncConfigKey, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultEmailFieldMap maps template fields to parts of the email when no mapping is configured
+var defaultEmailFieldMap = map[string]string{
+	"first":    "subject",
+	"keyword1": "from",
+	"keyword2": "body",
+}
+
+// EmailGatewayHandler converts inbound emails posted by a mail provider into notifications
+type EmailGatewayHandler struct {
+	repo   *repository.SQLiteRepository
+	sender *services.Sender
+}
+
+// NewEmailGatewayHandler creates a new email gateway handler
+func NewEmailGatewayHandler(repo *repository.SQLiteRepository, sender *services.Sender) *EmailGatewayHandler {
+	return &EmailGatewayHandler{repo: repo, sender: sender}
+}
+
+// inboundEmail holds the fields we use from a provider's inbound parse payload
+type inboundEmail struct {
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+// GetConfig returns the email gateway configuration, without its token
+// GET /api/config/email-gateway
+func (h *EmailGatewayHandler) GetConfig(c *gin.Context) {
+	cfg, err := h.loadConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve configuration", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	cfg.Token = ""
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: cfg})
+}
+
+// SaveConfig saves the email gateway configuration, generating an inbound token on first enable.
+// Only the token's hash is stored, so a generated token is shown in this response only.
+// PUT /api/config/email-gateway
+func (h *EmailGatewayHandler) SaveConfig(c *gin.Context) {
+	var cfg models.EmailGatewayConfig
+	if err := c.ShouldBindJSON(&cfg); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+
+	if cfg.Enabled {
+		if _, err := h.repo.GetTemplateByKey(cfg.TemplateKey); err != nil {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "Template not found", Code: "TEMPLATE_NOT_FOUND",
+			})
+			return
+		}
+	}
+
+	// Keep the existing token unless the caller supplied a new one
+	token := cfg.Token
+	if token != "" {
+		cfg.Token = services.HashEmailGatewayToken(token)
+	} else {
+		old, err := h.loadConfig()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, models.ApiResponse{
+				Success: false, Error: "Failed to retrieve configuration", Code: "DATABASE_ERROR",
+			})
+			return
+		}
+		cfg.Token = old.Token
+	}
+	generated := false
+	if cfg.Token == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			c.JSON(http.StatusInternalServerError, models.ApiResponse{
+				Success: false, Error: "Failed to generate token", Code: "INTERNAL_ERROR",
+			})
+			return
+		}
+		token = hex.EncodeToString(b)
+		cfg.Token = services.HashEmailGatewayToken(token)
+		generated = true
+	}
+
+	if err := h.repo.SetJSONConfig(services.EmailGatewayConfigKey, cfg); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to save configuration", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+
+	cfg.Token = ""
+	if generated {
+		cfg.Token = token
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: cfg})
+}
+
+// Receive accepts SendGrid / Mailgun style inbound parse posts and sends a notification
+// POST /api/webhook/email/:token
+func (h *EmailGatewayHandler) Receive(c *gin.Context) {
+	cfg, err := h.loadConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve configuration", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	if !cfg.Enabled || !services.CheckEmailGatewayToken(cfg, c.Param("token")) {
+		c.JSON(http.StatusNotFound, models.ApiResponse{
+			Success: false, Error: "Email gateway not found", Code: "NOT_FOUND",
+		})
+		return
+	}
+
+	email := parseInboundEmail(c)
+	if email.Subject == "" && email.Body == "" {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Email has no subject or body", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+
+	// Providers retry on non-2xx, so rejected mail is acknowledged with 200 and dropped
+	if !emailAddressed(email.To, cfg.Address) || !emailSenderAllowed(email.From, cfg.AllowedSenders) {
+		c.JSON(http.StatusOK, models.ApiResponse{
+			Success: false, Error: "Email rejected by gateway policy", Code: "EMAIL_REJECTED",
+		})
+		return
+	}
+
+	template, err := h.repo.GetTemplateByKey(cfg.TemplateKey)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Template not found", Code: "TEMPLATE_NOT_FOUND",
+		})
+		return
+	}
+
+	recipients, err := services.LoadRecipients(h.repo, cfg.RecipientIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to get recipients", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+
+	response, err := h.sender.Send(sendContext(c), template, recipients, emailKeywords(email, cfg.FieldMap), services.SendOptions{
+		Source: requestSource(c, models.SourceEmail, services.EmailGatewayConfigKey),
+	})
+	if err != nil {
+		writeSendError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: response})
+}
+
+func (h *EmailGatewayHandler) loadConfig() (*models.EmailGatewayConfig, error) {
+	cfg := &models.EmailGatewayConfig{}
+	if err := h.repo.GetJSONConfig(services.EmailGatewayConfigKey, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parseInboundEmail reads the form fields posted by SendGrid (from/to/text) or Mailgun (sender/recipient/body-plain)
+func parseInboundEmail(c *gin.Context) inboundEmail {
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if v := strings.TrimSpace(c.PostForm(key)); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	return inboundEmail{
+		From:    first("from", "sender", "From"),
+		To:      first("to", "recipient", "To"),
+		Subject: first("subject", "Subject"),
+		Body:    first("stripped-text", "text", "body-plain"),
+	}
+}
+
+// emailKeywords maps email parts to template fields
+func emailKeywords(email inboundEmail, fieldMap map[string]string) map[string]string {
+	if len(fieldMap) == 0 {
+		fieldMap = defaultEmailFieldMap
+	}
+	parts := map[string]string{
+		"subject": email.Subject,
+		"from":    email.From,
+		"to":      email.To,
+		"body":    email.Body,
+	}
+	keywords := make(map[string]string, len(fieldMap))
+	for field, part := range fieldMap {
+		keywords[field] = services.TruncateRunes(parts[part], services.MaxKeywordLength)
+	}
+	return keywords
+}
+
+// emailAddressed reports whether the To header includes the gateway address
+func emailAddressed(to, address string) bool {
+	if address == "" {
+		return true
+	}
+	addrs, err := mail.ParseAddressList(to)
+	if err != nil {
+		return strings.Contains(strings.ToLower(to), strings.ToLower(address))
+	}
+	for _, a := range addrs {
+		if strings.EqualFold(a.Address, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// emailSenderAllowed reports whether the sender matches the allow list (exact address or @domain)
+func emailSenderAllowed(from string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	addr := from
+	if parsed, err := mail.ParseAddress(from); err == nil {
+		addr = parsed.Address
+	}
+	addr = strings.ToLower(addr)
+	for _, a := range allowed {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if a == addr || (strings.HasPrefix(a, "@") && strings.HasSuffix(addr, a)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"wechat-notification/models"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestEmailSenderAllowed(t *testing.T) {
+	cases := []struct {
+		from    string
+		allowed []string
+		want    bool
+	}{
+		{"backup@example.com", nil, true},
+		{"Backup Job <backup@example.com>", []string{"backup@example.com"}, true},
+		{"cron@ops.example.com", []string{"@ops.example.com"}, true},
+		{"spam@evil.com", []string{"@example.com", "backup@example.com"}, false},
+	}
+	for _, tc := range cases {
+		if got := emailSenderAllowed(tc.from, tc.allowed); got != tc.want {
+			t.Errorf("emailSenderAllowed(%q, %v) = %v, want %v", tc.from, tc.allowed, got, tc.want)
+		}
+	}
+}
+
+func TestEmailAddressed(t *testing.T) {
+	if !emailAddressed("alerts@notify.example.com, other@example.com", "Alerts@notify.example.com") {
+		t.Error("expected gateway address to match case-insensitively")
+	}
+	if emailAddressed("other@example.com", "alerts@notify.example.com") {
+		t.Error("expected mismatched address to be rejected")
+	}
+}
+
+func TestEmailKeywordsDefaultMapping(t *testing.T) {
+	keywords := emailKeywords(inboundEmail{From: "a@b.c", Subject: "Backup failed", Body: "disk full"}, nil)
+	if keywords["first"] != "Backup failed" || keywords["keyword1"] != "a@b.c" || keywords["keyword2"] != "disk full" {
+		t.Errorf("unexpected keywords: %v", keywords)
+	}
+}
+
+func TestEmailGatewayTokenStoredAsHash(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+	repo.CreateTemplate(&models.MessageTemplate{Key: "alert", TemplateID: "tpl", Name: "告警"})
+
+	handler := NewEmailGatewayHandler(repo, nil)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/config/email-gateway", handler.GetConfig)
+	router.PUT("/api/config/email-gateway", handler.SaveConfig)
+	router.POST("/api/webhook/email/:token", handler.Receive)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+	configToken := func(w *httptest.ResponseRecorder) string {
+		var resp struct {
+			Data models.EmailGatewayConfig `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Data.Token
+	}
+
+	w := serve(http.MethodPut, "/api/config/email-gateway", `{"enabled":true,"templateKey":"alert"}`)
+	token := configToken(w)
+	if w.Code != http.StatusOK || token == "" {
+		t.Fatalf("generated token not returned: status %d, body %s", w.Code, w.Body.String())
+	}
+	var stored models.EmailGatewayConfig
+	repo.GetJSONConfig(services.EmailGatewayConfigKey, &stored)
+	if stored.Token == token || !services.CheckEmailGatewayToken(&stored, token) {
+		t.Errorf("token stored as %q", stored.Token)
+	}
+
+	if w := serve(http.MethodGet, "/api/config/email-gateway", ""); configToken(w) != "" {
+		t.Errorf("GET returned the token: %s", w.Body.String())
+	}
+	// Saving again keeps the token without showing it
+	if w := serve(http.MethodPut, "/api/config/email-gateway", `{"enabled":true,"templateKey":"alert","address":"alerts@example.com"}`); configToken(w) != "" {
+		t.Errorf("kept token returned: %s", w.Body.String())
+	}
+
+	if w := serve(http.MethodPost, "/api/webhook/email/"+stored.Token, ""); w.Code != http.StatusNotFound {
+		t.Errorf("stored hash accepted as token: status %d", w.Code)
+	}
+	// The right token gets past the token check to the empty email
+	if w := serve(http.MethodPost, "/api/webhook/email/"+token, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("token rejected: status %d, body %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FeedHandler handles RSS/Atom feed source endpoints
+type FeedHandler struct {
+	repo   *repository.SQLiteRepository
+	poller *services.FeedPoller
+}
+
+// NewFeedHandler creates a new feed handler
+func NewFeedHandler(repo *repository.SQLiteRepository, poller *services.FeedPoller) *FeedHandler {
+	return &FeedHandler{repo: repo, poller: poller}
+}
+
+// FeedRequest represents the request body for creating or updating a feed
+type FeedRequest struct {
+	Name            string            `json:"name" binding:"required"`
+	URL             string            `json:"url" binding:"required"`
+	IntervalSeconds int               `json:"intervalSeconds"`
+	TemplateKey     string            `json:"templateKey" binding:"required"`
+	GroupIDs        []int64           `json:"groupIds" binding:"required"`
+	Keywords        map[string]string `json:"keywords" binding:"required"`
+	Enabled         *bool             `json:"enabled"`
+}
+
+// List returns all feeds
+// GET /api/feeds
+func (h *FeedHandler) List(c *gin.Context) {
+	feeds, err := h.repo.GetAllFeeds()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to get feeds", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: feeds})
+}
+
+// Create creates a new feed
+// POST /api/feeds
+func (h *FeedHandler) Create(c *gin.Context) {
+	feed, ok := h.bindFeed(c)
+	if !ok {
+		return
+	}
+
+	if err := h.repo.CreateFeed(feed); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to create feed", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.ApiResponse{Success: true, Data: feed})
+}
+
+// Update updates a feed
+// PUT /api/feeds/:id
+func (h *FeedHandler) Update(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	feed, ok := h.bindFeed(c)
+	if !ok {
+		return
+	}
+	feed.ID = id
+
+	if err := h.repo.UpdateFeed(feed); err != nil {
+		h.writeError(c, err, "Failed to update feed")
+		return
+	}
+
+	updated, err := h.repo.GetFeedByID(id)
+	if err != nil {
+		h.writeError(c, err, "Failed to retrieve feed")
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: updated})
+}
+
+// Delete deletes a feed
+// DELETE /api/feeds/:id
+func (h *FeedHandler) Delete(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	if err := h.repo.DeleteFeed(id); err != nil {
+		h.writeError(c, err, "Failed to delete feed")
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true})
+}
+
+// Poll polls a feed immediately
+// POST /api/feeds/:id/poll
+func (h *FeedHandler) Poll(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	feed, err := h.repo.GetFeedByID(id)
+	if err != nil {
+		h.writeError(c, err, "Failed to retrieve feed")
+		return
+	}
+
+	sent, err := h.poller.Poll(feed)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, models.ApiResponse{
+			Success: false, Data: gin.H{"entriesSent": sent}, Error: err.Error(), Code: "FEED_POLL_FAILED",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: gin.H{"entriesSent": sent}})
+}
+
+func (h *FeedHandler) bindFeed(c *gin.Context) (*models.Feed, bool) {
+	var req FeedRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request: name, url, templateKey, groupIds and keywords are required", Code: "INVALID_REQUEST",
+		})
+		return nil, false
+	}
+
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "URL must be an absolute http(s) URL", Code: "VALIDATION_ERROR",
+		})
+		return nil, false
+	}
+	if len(req.GroupIDs) == 0 || len(req.Keywords) == 0 {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "At least one group and one keyword are required", Code: "VALIDATION_ERROR",
+		})
+		return nil, false
+	}
+	if _, err := h.repo.GetTemplateByKey(req.TemplateKey); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Template not found", Code: "TEMPLATE_NOT_FOUND",
+		})
+		return nil, false
+	}
+
+	interval := req.IntervalSeconds
+	if interval == 0 {
+		interval = 300
+	}
+	if interval < services.MinFeedInterval {
+		interval = services.MinFeedInterval
+	}
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	return &models.Feed{
+		Name:            strings.TrimSpace(req.Name),
+		URL:             req.URL,
+		IntervalSeconds: interval,
+		TemplateKey:     req.TemplateKey,
+		GroupIDs:        uniqueIDs(req.GroupIDs),
+		Keywords:        req.Keywords,
+		Enabled:         enabled,
+	}, true
+}
+
+func (h *FeedHandler) writeError(c *gin.Context, err error, message string) {
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, models.ApiResponse{
+			Success: false, Error: "Feed not found", Code: "NOT_FOUND",
+		})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, models.ApiResponse{
+		Success: false, Error: message, Code: "DATABASE_ERROR",
+	})
+}
+
+// parseIDParam parses the :id route parameter, writing a 400 response on failure
+func parseIDParam(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid ID", Code: "INVALID_ID",
+		})
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FollowerHandler handles the recipient sync from the follower list of an official account and
+// the WeChat profiles of recipients
+type FollowerHandler struct {
+	repo *repository.SQLiteRepository
+	apps *services.AppRegistry
+}
+
+// NewFollowerHandler creates a new follower sync handler
+func NewFollowerHandler(repo *repository.SQLiteRepository, apps *services.AppRegistry) *FollowerHandler {
+	return &FollowerHandler{repo: repo, apps: apps}
+}
+
+// Sync imports the followers of an official account as recipients and flags recipients of the
+// account who no longer follow it. With dryRun=true it only reports the new and removed users.
+// POST /api/recipients/followers/sync?appId=&dryRun=
+func (h *FollowerHandler) Sync(c *gin.Context) {
+	var appID int64
+	if raw := c.Query("appId"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "Invalid appId", Code: "INVALID_REQUEST",
+			})
+			return
+		}
+		appID = id
+	}
+	if _, err := h.apps.Service(appID); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "WeChat app not found", Code: "APP_NOT_FOUND",
+		})
+		return
+	}
+
+	report, err := services.SyncFollowers(h.repo, h.apps, appID, c.Query("dryRun") == "true")
+	if report != nil {
+		report.Removed = maskRecipients(report.Removed)
+		report.Returned = maskRecipients(report.Returned)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, models.ApiResponse{
+			Success: false, Data: report, Error: err.Error(), Code: "FOLLOWER_SYNC_FAILED",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: report})
+}
+
+// RefreshProfile fetches a recipient's nickname, avatar and language from WeChat
+// POST /api/recipients/:id/wechat-profile
+func (h *FollowerHandler) RefreshProfile(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	recipient, err := h.repo.GetByID(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, models.ApiResponse{
+			Success: false, Error: "Recipient not found", Code: "NOT_FOUND",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve recipient", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+
+	if err := services.RefreshWeChatProfile(h.repo, h.apps, recipient, time.Now()); err != nil {
+		if errors.Is(err, services.ErrNotFollower) {
+			c.JSON(http.StatusConflict, models.ApiResponse{
+				Success: false, Error: err.Error(), Code: "NOT_FOLLOWING",
+			})
+			return
+		}
+		c.JSON(http.StatusBadGateway, models.ApiResponse{
+			Success: false, Error: err.Error(), Code: "PROFILE_FETCH_FAILED",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: maskRecipient(*recipient)})
+}
+
+// RefreshProfiles fetches the WeChat profile of every recipient, or with missing=true only of
+// recipients whose profile was never fetched
+// POST /api/recipients/followers/profiles?missing=
+func (h *FollowerHandler) RefreshProfiles(c *gin.Context) {
+	all, err := h.repo.GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to get recipients", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	recipients := all
+	if c.Query("missing") == "true" {
+		recipients = nil
+		for _, r := range all {
+			if r.ProfileSyncedAt == nil {
+				recipients = append(recipients, r)
+			}
+		}
+	}
+	report := services.RefreshWeChatProfiles(h.repo, h.apps, recipients, time.Now())
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: report})
+}
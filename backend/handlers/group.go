@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GroupHandler handles recipient group endpoints
+type GroupHandler struct {
+	repo *repository.SQLiteRepository
+}
+
+// NewGroupHandler creates a new group handler
+func NewGroupHandler(repo *repository.SQLiteRepository) *GroupHandler {
+	return &GroupHandler{repo: repo}
+}
+
+// GroupRequest represents the request body for creating or updating a group
+type GroupRequest struct {
+	Name         string           `json:"name" binding:"required"`
+	Description  string           `json:"description"`
+	RecipientIDs []int64          `json:"recipientIds"`
+	Channels     []models.Channel `json:"channels"`
+}
+
+// List returns all groups
+// GET /api/groups
+func (h *GroupHandler) List(c *gin.Context) {
+	groups, err := h.repo.GetAllGroups()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to get groups", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	for i := range groups {
+		groups[i].Channels = maskChannels(groups[i].Channels)
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: groups})
+}
+
+// Create creates a new group
+// POST /api/groups
+func (h *GroupHandler) Create(c *gin.Context) {
+	group, ok := h.bindGroup(c, nil)
+	if !ok {
+		return
+	}
+
+	if err := h.repo.CreateGroup(group); err != nil {
+		h.writeError(c, err, "Failed to create group")
+		return
+	}
+
+	group.Channels = maskChannels(group.Channels)
+	c.JSON(http.StatusCreated, models.ApiResponse{Success: true, Data: group})
+}
+
+// Update replaces a group's name, description and members
+// PUT /api/groups/:id
+func (h *GroupHandler) Update(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	// Channel secrets come back masked from the list; keep the saved ones
+	saved, err := h.repo.GetGroupByID(id)
+	if err != nil {
+		h.writeError(c, err, "Failed to retrieve group")
+		return
+	}
+
+	group, ok := h.bindGroup(c, saved.Channels)
+	if !ok {
+		return
+	}
+	group.ID = id
+
+	if err := h.repo.UpdateGroup(group); err != nil {
+		h.writeError(c, err, "Failed to update group")
+		return
+	}
+
+	updated, err := h.repo.GetGroupByID(id)
+	if err != nil {
+		h.writeError(c, err, "Failed to retrieve group")
+		return
+	}
+	updated.Channels = maskChannels(updated.Channels)
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: updated})
+}
+
+// Delete deletes a group
+// DELETE /api/groups/:id
+func (h *GroupHandler) Delete(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.repo.DeleteGroup(id); err != nil {
+		h.writeError(c, err, "Failed to delete group")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true})
+}
+
+func (h *GroupHandler) bindGroup(c *gin.Context, saved []models.Channel) (*models.Group, bool) {
+	var req GroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request: name is required", Code: "INVALID_REQUEST",
+		})
+		return nil, false
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Name cannot be empty or whitespace only", Code: "VALIDATION_ERROR",
+		})
+		return nil, false
+	}
+
+	// Reject unknown recipients up front rather than storing dangling members
+	if len(req.RecipientIDs) > 0 {
+		found, err := h.repo.GetByIDs(req.RecipientIDs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, models.ApiResponse{
+				Success: false, Error: "Failed to retrieve recipients", Code: "DATABASE_ERROR",
+			})
+			return nil, false
+		}
+		if len(found) != len(uniqueIDs(req.RecipientIDs)) {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "One or more recipients not found", Code: "RECIPIENT_NOT_FOUND",
+			})
+			return nil, false
+		}
+	}
+
+	keepChannelSecrets(req.Channels, saved)
+	for i, ch := range req.Channels {
+		if err := services.ValidateChannel(&req.Channels[i]); err != nil {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "Invalid " + ch.Type + " channel: " + err.Error(), Code: "VALIDATION_ERROR",
+			})
+			return nil, false
+		}
+	}
+
+	return &models.Group{
+		Name:         name,
+		Description:  strings.TrimSpace(req.Description),
+		RecipientIDs: uniqueIDs(req.RecipientIDs),
+		Channels:     req.Channels,
+	}, true
+}
+
+func (h *GroupHandler) writeError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		c.JSON(http.StatusNotFound, models.ApiResponse{
+			Success: false, Error: "Group not found", Code: "NOT_FOUND",
+		})
+	case errors.Is(err, repository.ErrDuplicateName):
+		c.JSON(http.StatusConflict, models.ApiResponse{
+			Success: false, Error: "A group with this name already exists", Code: "DUPLICATE_NAME",
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: message, Code: "DATABASE_ERROR",
+		})
+	}
+}
+
+// uniqueIDs removes duplicate IDs while preserving order
+func uniqueIDs(ids []int64) []int64 {
+	seen := make(map[int64]bool, len(ids))
+	result := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HeartbeatHandler handles heartbeat (dead-man's switch) endpoints
+type HeartbeatHandler struct {
+	repo    *repository.SQLiteRepository
+	checker *services.HeartbeatChecker
+}
+
+// NewHeartbeatHandler creates a new heartbeat handler
+func NewHeartbeatHandler(repo *repository.SQLiteRepository, checker *services.HeartbeatChecker) *HeartbeatHandler {
+	return &HeartbeatHandler{repo: repo, checker: checker}
+}
+
+// HeartbeatRequest represents the request body for creating or updating a heartbeat
+type HeartbeatRequest struct {
+	Name            string            `json:"name" binding:"required"`
+	IntervalSeconds int               `json:"intervalSeconds" binding:"required"`
+	GraceSeconds    int               `json:"graceSeconds"`
+	TemplateKey     string            `json:"templateKey" binding:"required"`
+	GroupIDs        []int64           `json:"groupIds" binding:"required"`
+	Keywords        map[string]string `json:"keywords" binding:"required"`
+	Enabled         *bool             `json:"enabled"`
+}
+
+// List returns all heartbeats
+// GET /api/heartbeats
+func (h *HeartbeatHandler) List(c *gin.Context) {
+	heartbeats, err := h.repo.GetAllHeartbeats()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to get heartbeats", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: heartbeats})
+}
+
+// Create creates a new heartbeat with a random ping key
+// POST /api/heartbeats
+func (h *HeartbeatHandler) Create(c *gin.Context) {
+	heartbeat, ok := h.bindHeartbeat(c)
+	if !ok {
+		return
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to generate ping key", Code: "INTERNAL_ERROR",
+		})
+		return
+	}
+	heartbeat.PingKey = hex.EncodeToString(b)
+
+	if err := h.repo.CreateHeartbeat(heartbeat); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to create heartbeat", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.ApiResponse{Success: true, Data: heartbeat})
+}
+
+// Update updates a heartbeat
+// PUT /api/heartbeats/:id
+func (h *HeartbeatHandler) Update(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	heartbeat, ok := h.bindHeartbeat(c)
+	if !ok {
+		return
+	}
+	heartbeat.ID = id
+
+	if err := h.repo.UpdateHeartbeat(heartbeat); err != nil {
+		h.writeError(c, err, "Failed to update heartbeat")
+		return
+	}
+
+	updated, err := h.repo.GetHeartbeatByID(id)
+	if err != nil {
+		h.writeError(c, err, "Failed to retrieve heartbeat")
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: updated})
+}
+
+// Delete deletes a heartbeat
+// DELETE /api/heartbeats/:id
+func (h *HeartbeatHandler) Delete(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	if err := h.repo.DeleteHeartbeat(id); err != nil {
+		h.writeError(c, err, "Failed to delete heartbeat")
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true})
+}
+
+// Ping records a ping from an external job; the path parameter is the heartbeat's ping key, named
+// token so the access log redacts it
+// POST /api/heartbeat/:token
+func (h *HeartbeatHandler) Ping(c *gin.Context) {
+	heartbeat, err := h.repo.GetHeartbeatByPingKey(c.Param("token"))
+	if err != nil {
+		h.writeError(c, err, "Failed to retrieve heartbeat")
+		return
+	}
+
+	if err := h.checker.Ping(heartbeat); err != nil {
+		// The ping itself is recorded; only the recovery notification failed
+		c.JSON(http.StatusOK, models.ApiResponse{
+			Success: true, Error: "Recovery notification failed: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true})
+}
+
+func (h *HeartbeatHandler) bindHeartbeat(c *gin.Context) (*models.Heartbeat, bool) {
+	var req HeartbeatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request: name, intervalSeconds, templateKey, groupIds and keywords are required", Code: "INVALID_REQUEST",
+		})
+		return nil, false
+	}
+
+	if req.IntervalSeconds < services.MinHeartbeatInterval || req.GraceSeconds < 0 {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Interval must be at least 60 seconds and grace cannot be negative", Code: "VALIDATION_ERROR",
+		})
+		return nil, false
+	}
+	if len(req.GroupIDs) == 0 || len(req.Keywords) == 0 {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "At least one group and one keyword are required", Code: "VALIDATION_ERROR",
+		})
+		return nil, false
+	}
+	if _, err := h.repo.GetTemplateByKey(req.TemplateKey); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Template not found", Code: "TEMPLATE_NOT_FOUND",
+		})
+		return nil, false
+	}
+
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	return &models.Heartbeat{
+		Name:            strings.TrimSpace(req.Name),
+		IntervalSeconds: req.IntervalSeconds,
+		GraceSeconds:    req.GraceSeconds,
+		TemplateKey:     req.TemplateKey,
+		GroupIDs:        uniqueIDs(req.GroupIDs),
+		Keywords:        req.Keywords,
+		Enabled:         enabled,
+	}, true
+}
+
+func (h *HeartbeatHandler) writeError(c *gin.Context, err error, message string) {
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, models.ApiResponse{
+			Success: false, Error: "Heartbeat not found", Code: "NOT_FOUND",
+		})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, models.ApiResponse{
+		Success: false, Error: message, Code: "DATABASE_ERROR",
+	})
+}
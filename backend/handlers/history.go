@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HistoryHandler handles bulk actions over the message history
+type HistoryHandler struct {
+	jobs *services.HistoryJobs
+}
+
+// NewHistoryHandler creates a new history handler
+func NewHistoryHandler(jobs *services.HistoryJobs) *HistoryHandler {
+	return &HistoryHandler{jobs: jobs}
+}
+
+// BulkHistoryRequest selects the messages a bulk action runs over; the filters combine like
+// those of the history list
+type BulkHistoryRequest struct {
+	Action     string     `json:"action" binding:"required"` // requeue, purge or export
+	Adapter    string     `json:"adapter"`
+	TokenName  string     `json:"tokenName"`
+	SourceIP   string     `json:"sourceIp"`
+	UserAgent  string     `json:"userAgent"`
+	From       *time.Time `json:"from"`
+	To         *time.Time `json:"to"`
+	FailedOnly bool       `json:"failedOnly"`
+}
+
+// Bulk starts a bulk action over the filtered history as a background job and returns the job
+// to poll for progress. Purging requires at least one filter. Like the rest of /api, any
+// signed-in session may run it, purges included; every job is logged when it finishes.
+// POST /api/messages/bulk
+func (h *HistoryHandler) Bulk(c *gin.Context) {
+	var req BulkHistoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+
+	filter := repository.MessageFilter{
+		Adapter:    req.Adapter,
+		TokenName:  req.TokenName,
+		SourceIP:   req.SourceIP,
+		UserAgent:  req.UserAgent,
+		FailedOnly: req.FailedOnly,
+	}
+	if req.From != nil {
+		filter.From = *req.From
+	}
+	if req.To != nil {
+		filter.To = *req.To
+	}
+	if req.Action == services.HistoryActionPurge && filter.IsEmpty() {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Purging needs at least one filter", Code: "FILTER_REQUIRED",
+		})
+		return
+	}
+
+	job, err := h.jobs.Start(req.Action, filter)
+	if errors.Is(err, services.ErrUnknownHistoryAction) {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: err.Error(), Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to select messages", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusAccepted, models.ApiResponse{Success: true, Data: job})
+}
+
+// ListJobs returns the bulk history jobs, newest first
+// GET /api/messages/jobs
+func (h *HistoryHandler) ListJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: h.jobs.List()})
+}
+
+// GetJob returns the progress of a bulk history job
+// GET /api/messages/jobs/:id
+func (h *HistoryHandler) GetJob(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	job, ok := h.jobs.Get(id)
+	if !ok {
+		c.JSON(http.StatusNotFound, models.ApiResponse{
+			Success: false, Error: "Job not found", Code: "NOT_FOUND",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: job})
+}
+
+// DownloadExport returns the CSV written by a finished export job
+// GET /api/messages/jobs/:id/export
+func (h *HistoryHandler) DownloadExport(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	data, ok := h.jobs.Export(id)
+	if !ok {
+		c.JSON(http.StatusNotFound, models.ApiResponse{
+			Success: false, Error: "No finished export job with this ID", Code: "NOT_FOUND",
+		})
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename=tongzhi-history-"+strconv.FormatInt(id, 10)+".csv")
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", data)
+}
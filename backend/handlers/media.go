@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"wechat-notification/models"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MediaHandler uploads images to WeChat for image messages
+type MediaHandler struct {
+	apps *services.AppRegistry
+}
+
+// NewMediaHandler creates a new media handler
+func NewMediaHandler(apps *services.AppRegistry) *MediaHandler {
+	return &MediaHandler{apps: apps}
+}
+
+// Upload uploads the image in the media form field as temporary media of an official account.
+// The returned mediaId can be attached to text messages of that account until it expires.
+// POST /api/media?appId=
+func (h *MediaHandler) Upload(c *gin.Context) {
+	var appID int64
+	if raw := c.Query("appId"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "Invalid appId", Code: "INVALID_REQUEST",
+			})
+			return
+		}
+		appID = id
+	}
+	wechatSvc, err := h.apps.Service(appID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "WeChat app not found", Code: "APP_NOT_FOUND",
+		})
+		return
+	}
+
+	file, err := c.FormFile("media")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "media file is required", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Failed to read media file", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, services.MaxImageSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Failed to read media file", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+
+	media, err := wechatSvc.UploadImage(file.Filename, data)
+	if errors.Is(err, services.ErrUnsupportedImage) {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: err.Error(), Code: "INVALID_MEDIA",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, models.ApiResponse{
+			Success: false, Error: err.Error(), Code: "MEDIA_UPLOAD_FAILED",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: media})
+}
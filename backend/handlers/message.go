@@ -1,7 +1,15 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"wechat-notification/models"
 	"wechat-notification/repository"
@@ -12,18 +20,24 @@ import (
 
 // MessageHandler handles message endpoints
 type MessageHandler struct {
-	repo          *repository.SQLiteRepository
-	wechatService *services.WeChatService
+	repo     *repository.SQLiteRepository
+	sender   *services.Sender
+	warnings *services.ConfigWarnings
 }
 
 // NewMessageHandler creates a new message handler
-func NewMessageHandler(repo *repository.SQLiteRepository, wechatService *services.WeChatService) *MessageHandler {
+func NewMessageHandler(repo *repository.SQLiteRepository, sender *services.Sender) *MessageHandler {
 	return &MessageHandler{
-		repo:          repo,
-		wechatService: wechatService,
+		repo:   repo,
+		sender: sender,
 	}
 }
 
+// SetWarnings attaches the configuration warnings surfaced with send results
+func (h *MessageHandler) SetWarnings(warnings *services.ConfigWarnings) {
+	h.warnings = warnings
+}
+
 // Send sends a message to selected recipients
 // POST /api/messages/send
 func (h *MessageHandler) Send(c *gin.Context) {
@@ -37,6 +51,10 @@ func (h *MessageHandler) Send(c *gin.Context) {
 		return
 	}
 
+	if !checkSendMode(c, req.Mode, req.Miniprogram, req.MediaID) || !defaultTemplateKey(c, h.repo, &req.TemplateKey, req.Mode) {
+		return
+	}
+
 	// Validate the message request
 	validationResult := services.ValidateMessage(&req)
 	if !validationResult.Valid {
@@ -49,7 +67,7 @@ func (h *MessageHandler) Send(c *gin.Context) {
 	}
 
 	// Get template by key
-	template, err := h.repo.GetTemplateByKey(req.TemplateKey)
+	template, err := services.LoadTemplate(h.repo, req.TemplateKey, req.Mode)
 	if err != nil {
 		if err == repository.ErrNotFound {
 			c.JSON(http.StatusBadRequest, models.ApiResponse{
@@ -91,14 +109,561 @@ func (h *MessageHandler) Send(c *gin.Context) {
 	}
 
 	// Send messages using shared logic
-	response := SendMessages(h.wechatService, recipients, template.TemplateID, req.Keywords)
+	if !checkKeywords(c, template, req.Keywords, req.Mode) || !validateChannels(c, req.Channels) || !normalizePriority(c, &req.Priority) ||
+		!checkMessageLinks(c, req.URL, req.Miniprogram, req.RequireAck) || !checkCanary(c, h.repo, req.Canary, req.RequireAck) {
+		return
+	}
+
+	opts := services.SendOptions{
+		RequireAck:  req.RequireAck,
+		ReplyTo:     req.ReplyTo,
+		Channels:    req.Channels,
+		Tags:        req.Tags,
+		Priority:    req.Priority,
+		AppID:       req.AppID,
+		URL:         req.URL,
+		Miniprogram: req.Miniprogram,
+		Mode:        req.Mode,
+		MediaID:     req.MediaID,
+	}
+	var response services.SendResponse
+	if req.Canary != nil {
+		response, err = h.sender.SendCanary(sendContext(c), template, recipients, req.Keywords, opts, req.Canary)
+	} else {
+		response, err = h.sender.Send(sendContext(c), template, recipients, req.Keywords, opts)
+	}
+	if err != nil {
+		writeSendError(c, err)
+		return
+	}
 
 	// Determine response status
+	warnings := h.warnings.Collect(time.Now())
 	if response.TotalFailed == 0 {
-		c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: response})
+		c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: response, Warnings: warnings})
 	} else if response.TotalSent > 0 {
-		c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: response, Error: "Some messages failed to send", Code: "PARTIAL_SUCCESS"})
+		c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: response, Error: "Some messages failed to send", Code: "PARTIAL_SUCCESS", Warnings: warnings})
 	} else {
-		c.JSON(http.StatusInternalServerError, models.ApiResponse{Success: false, Data: response, Error: "Failed to send messages", Code: "SEND_FAILED"})
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{Success: false, Data: response, Error: "Failed to send messages", Code: "SEND_FAILED", Warnings: warnings})
+	}
+}
+
+// RenderMessageRequest represents a request to preview a message
+type RenderMessageRequest struct {
+	TemplateKey string            `json:"templateKey" binding:"required"`
+	Keywords    map[string]string `json:"keywords"`
+	RecipientID int64             `json:"recipientId"` // Optional, fills in {{name}} and other recipient placeholders
+}
+
+// PreviewTemplateRequest represents a request to preview a template message
+type PreviewTemplateRequest struct {
+	Keywords    map[string]string `json:"keywords"`
+	RecipientID int64             `json:"recipientId"` // Optional, previews the message as sent to this recipient
+	AppID       int64             `json:"appId"`       // Send through this official account, 0 for the template's
+}
+
+// PreviewTemplate returns the WeChat template message JSON a send would post, without sending it.
+// Keywords that don't match the template's fields are reported alongside.
+// POST /api/templates/:key/preview
+func (h *MessageHandler) PreviewTemplate(c *gin.Context) {
+	var req PreviewTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+
+	template, err := h.repo.GetTemplateByKey(c.Param("key"))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, models.ApiResponse{
+				Success: false, Error: "Template not found", Code: "TEMPLATE_NOT_FOUND",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve template", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+
+	var recipient *models.Recipient
+	if req.RecipientID != 0 {
+		recipient, err = h.repo.GetByID(req.RecipientID)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				c.JSON(http.StatusBadRequest, models.ApiResponse{
+					Success: false, Error: "Recipient not found", Code: "RECIPIENT_NOT_FOUND",
+				})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, models.ApiResponse{
+				Success: false, Error: "Failed to retrieve recipient", Code: "DATABASE_ERROR",
+			})
+			return
+		}
+	}
+
+	message, err := h.sender.Preview(template, recipient, req.Keywords, req.AppID)
+	if errors.Is(err, services.ErrAppNotFound) {
+		writeSendError(c, err)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: err.Error(), Code: "TEMPLATE_UNAVAILABLE",
+		})
+		return
+	}
+
+	data := gin.H{"message": message}
+	if mismatch := services.ValidateKeywords(template, req.Keywords); mismatch != nil {
+		data["keywordMismatch"] = mismatch
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: data})
+}
+
+// Render returns the field values a message would be sent with, for a live preview
+// POST /api/messages/render
+func (h *MessageHandler) Render(c *gin.Context) {
+	var req RenderMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request: templateKey is required", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+
+	template, err := h.repo.GetTemplateByKey(req.TemplateKey)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "Template not found", Code: "TEMPLATE_NOT_FOUND",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve template", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+
+	var recipient *models.Recipient
+	if req.RecipientID != 0 {
+		recipient, err = h.repo.GetByID(req.RecipientID)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				c.JSON(http.StatusBadRequest, models.ApiResponse{
+					Success: false, Error: "Recipient not found", Code: "RECIPIENT_NOT_FOUND",
+				})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, models.ApiResponse{
+				Success: false, Error: "Failed to retrieve recipient", Code: "DATABASE_ERROR",
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Data:    services.RenderMessage(template, req.Keywords, recipient),
+	})
+}
+
+// CheckDuplicateRequest represents a request to look for a recent identical send
+type CheckDuplicateRequest struct {
+	TemplateKey   string            `json:"templateKey" binding:"required"`
+	Keywords      map[string]string `json:"keywords"`
+	RecipientIDs  []int64           `json:"recipientIds"`  // Optional, when set only sends to exactly these recipients match
+	WithinMinutes int               `json:"withinMinutes"` // Optional, defaults to 10, at most 1440
+}
+
+// CheckDuplicate reports whether an identical message was sent recently so the UI can warn before a double send
+// POST /api/messages/check-duplicate
+func (h *MessageHandler) CheckDuplicate(c *gin.Context) {
+	var req CheckDuplicateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request: templateKey is required", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+	if req.WithinMinutes == 0 {
+		req.WithinMinutes = 10
+	}
+	if req.WithinMinutes < 0 || req.WithinMinutes > 1440 {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "withinMinutes must be between 1 and 1440", Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+	if req.Keywords == nil {
+		req.Keywords = map[string]string{}
+	}
+
+	since := time.Now().Add(-time.Duration(req.WithinMinutes) * time.Minute)
+	recent, err := h.repo.FindRecentMessages(req.TemplateKey, req.Keywords, since)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to get message history", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+
+	matches := []models.Message{}
+	for _, m := range recent {
+		if len(req.RecipientIDs) > 0 && !sameRecipients(m.Results, req.RecipientIDs) {
+			continue
+		}
+		m.Results = nil
+		matches = append(matches, m)
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Data: gin.H{
+			"duplicate":     len(matches) > 0,
+			"withinMinutes": req.WithinMinutes,
+			"matches":       matches,
+		},
+	})
+}
+
+// sameRecipients reports whether a message's stored results cover exactly the given recipients
+func sameRecipients(results json.RawMessage, ids []int64) bool {
+	var sent []services.SendResult
+	if err := json.Unmarshal(results, &sent); err != nil {
+		return false
+	}
+	want := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		want[id] = true
+	}
+	got := make(map[int64]bool, len(sent))
+	for _, r := range sent {
+		if !want[r.RecipientID] {
+			return false
+		}
+		got[r.RecipientID] = true
+	}
+	return len(got) == len(want)
+}
+
+// List returns a page of the message history, newest first, optionally filtered by
+// source adapter, token name, source IP or user agent. Timestamps are given in the tz time zone
+// (an IANA name such as Asia/Shanghai), the server's by default.
+// GET /api/messages?page=1&pageSize=20&adapter=webhook&tz=Asia/Shanghai
+func (h *MessageHandler) List(c *gin.Context) {
+	loc, ok := requestLocation(c)
+	if !ok {
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	filter := repository.MessageFilter{
+		Adapter:   c.Query("adapter"),
+		TokenName: c.Query("tokenName"),
+		SourceIP:  c.Query("sourceIp"),
+		UserAgent: c.Query("userAgent"),
+	}
+	// History searches can be slow; stop them when the client gives up
+	messages, total, err := h.repo.WithContext(c.Request.Context()).ListMessages(filter, pageSize, (page-1)*pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to get messages", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	localizeMessages(messages, loc, time.Now())
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Data: gin.H{
+			"items":    messages,
+			"total":    total,
+			"page":     page,
+			"pageSize": pageSize,
+		},
+	})
+}
+
+// Get returns a message with its per-recipient results and the conversation thread it belongs to,
+// with timestamps in the tz time zone like List
+// GET /api/messages/:id?tz=Asia/Shanghai
+func (h *MessageHandler) Get(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	loc, ok := requestLocation(c)
+	if !ok {
+		return
+	}
+
+	message, err := h.repo.GetMessageByID(id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, models.ApiResponse{
+				Success: false, Error: "Message not found", Code: "NOT_FOUND",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve message", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+
+	thread, err := h.repo.GetThread(message.ThreadID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve thread", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	detail := []models.Message{*message}
+	now := time.Now()
+	localizeMessages(detail, loc, now)
+	localizeMessages(thread, loc, now)
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Data:    models.MessageDetail{Message: detail[0], Thread: thread},
+	})
+}
+
+// requestLocation loads the time zone named by the tz query parameter, the server's when absent,
+// answering 400 for unknown names
+func requestLocation(c *gin.Context) (*time.Location, bool) {
+	name := c.Query("tz")
+	if name == "" {
+		return time.Local, true
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Unknown time zone: " + name, Code: "INVALID_TIMEZONE",
+		})
+		return nil, false
+	}
+	return loc, true
+}
+
+// localizeMessages converts the creation times to loc, dropping sub-second precision, and fills in
+// how long ago each message was sent
+func localizeMessages(messages []models.Message, loc *time.Location, now time.Time) {
+	for i := range messages {
+		m := &messages[i]
+		age := now.Sub(m.CreatedAt)
+		m.CreatedAt = m.CreatedAt.In(loc).Truncate(time.Second)
+		m.AgeSeconds = int64(max(age, 0) / time.Second)
+		m.CreatedRelative = relativeTime(age)
+	}
+}
+
+// relativeTime describes an age the way the history page shows it, e.g. 5 分钟前
+func relativeTime(age time.Duration) string {
+	switch {
+	case age < time.Minute:
+		return "刚刚"
+	case age < time.Hour:
+		return fmt.Sprintf("%d 分钟前", int(age/time.Minute))
+	case age < 24*time.Hour:
+		return fmt.Sprintf("%d 小时前", int(age/time.Hour))
+	case age < 30*24*time.Hour:
+		return fmt.Sprintf("%d 天前", int(age/(24*time.Hour)))
+	case age < 365*24*time.Hour:
+		return fmt.Sprintf("%d 个月前", int(age/(30*24*time.Hour)))
+	default:
+		return fmt.Sprintf("%d 年前", int(age/(365*24*time.Hour)))
+	}
+}
+
+// checkCanary validates the canary options of a send, if any, and that its canary group exists
+func checkCanary(c *gin.Context, repo *repository.SQLiteRepository, canary *models.CanaryOptions, requireAck bool) bool {
+	if canary == nil {
+		return true
+	}
+	if err := services.ValidateCanary(canary, requireAck); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: err.Error(), Code: "INVALID_CANARY",
+		})
+		return false
+	}
+	if canary.GroupID != 0 {
+		if _, err := repo.GetGroupByID(canary.GroupID); err != nil {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "Canary group not found", Code: "GROUP_NOT_FOUND",
+			})
+			return false
+		}
+	}
+	return true
+}
+
+// validateChannels checks per-message channels, writing a 400 response on failure
+func validateChannels(c *gin.Context, channels []models.Channel) bool {
+	for i := range channels {
+		if err := services.ValidateChannel(&channels[i]); err != nil {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "Invalid " + channels[i].Type + " channel: " + err.Error(), Code: "VALIDATION_ERROR",
+			})
+			return false
+		}
+	}
+	return true
+}
+
+// normalizePriority validates a send priority in place, defaulting it to normal
+func normalizePriority(c *gin.Context, priority *string) bool {
+	normalized, err := services.NormalizePriority(*priority)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: err.Error(), Code: "INVALID_PRIORITY",
+		})
+		return false
+	}
+	*priority = normalized
+	return true
+}
+
+// defaultTemplateKey fills in the default template's key when a send doesn't name a template.
+// Text sends don't need one and use models.TextTemplateKey instead.
+func defaultTemplateKey(c *gin.Context, repo *repository.SQLiteRepository, key *string, mode string) bool {
+	if strings.TrimSpace(*key) != "" {
+		return true
+	}
+	if mode == models.SendModeText {
+		*key = models.TextTemplateKey
+		return true
+	}
+	template, err := repo.GetDefaultTemplate()
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "No templateKey given and no default template set", Code: "NO_DEFAULT_TEMPLATE",
+		})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve template", Code: "DATABASE_ERROR",
+		})
+		return false
+	}
+	*key = template.Key
+	return true
+}
+
+// checkKeywords rejects keywords that don't match the template's fields, listing the missing and
+// unexpected ones. Text messages take any keywords.
+func checkKeywords(c *gin.Context, template *models.MessageTemplate, keywords map[string]string, mode string) bool {
+	if mode == models.SendModeText {
+		return true
+	}
+	err := services.ValidateKeywords(template, keywords)
+	if err == nil {
+		return true
+	}
+	c.JSON(http.StatusBadRequest, models.ApiResponse{
+		Success: false, Data: err, Error: err.Error(), Code: "KEYWORD_MISMATCH",
+	})
+	return false
+}
+
+// checkMessageLinks rejects a jump URL that isn't an absolute http(s) URL, a mini program without
+// an appid, or either of them combined with an acknowledgement link, which already takes the
+// message's only link
+func checkMessageLinks(c *gin.Context, link string, miniprogram *models.Miniprogram, requireAck bool) bool {
+	if link != "" {
+		if u, err := url.Parse(link); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "url must be an absolute http(s) URL", Code: "INVALID_URL",
+			})
+			return false
+		}
+	}
+	if miniprogram != nil && strings.TrimSpace(miniprogram.AppID) == "" {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "miniprogram.appid is required", Code: "INVALID_MINIPROGRAM",
+		})
+		return false
+	}
+	if requireAck && (link != "" || miniprogram != nil) {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "url and miniprogram cannot be combined with requireAck", Code: "INVALID_URL",
+		})
+		return false
+	}
+	return true
+}
+
+// checkSendMode rejects send modes other than template and text, mini programs on text messages,
+// which can't carry one, and images on template messages
+func checkSendMode(c *gin.Context, mode string, miniprogram *models.Miniprogram, mediaID string) bool {
+	if mode != "" && mode != models.SendModeTemplate && mode != models.SendModeText {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "mode must be template or text", Code: "VALIDATION_ERROR",
+		})
+		return false
+	}
+	if mode == models.SendModeText && miniprogram != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "miniprogram cannot be used with text messages", Code: "INVALID_MINIPROGRAM",
+		})
+		return false
+	}
+	if mode != models.SendModeText && mediaID != "" {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "mediaId can only be sent with text messages", Code: "VALIDATION_ERROR",
+		})
+		return false
+	}
+	return true
+}
+
+// sendContext returns the context for a send started by a request. It doesn't end when the
+// caller disconnects, so a send isn't abandoned halfway with some recipients reached; the
+// sender's send timeout bounds the WeChat calls instead.
+func sendContext(c *gin.Context) context.Context {
+	return context.WithoutCancel(c.Request.Context())
+}
+
+// writeSendError maps an error from the send pipeline to a response
+func writeSendError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrReplyToNotFound) {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Message to reply to not found", Code: "REPLY_TO_NOT_FOUND",
+		})
+		return
+	}
+	if errors.Is(err, services.ErrAppNotFound) {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "WeChat app not found", Code: "APP_NOT_FOUND",
+		})
+		return
+	}
+	if errors.Is(err, services.ErrEmptyCanary) {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: err.Error(), Code: "INVALID_CANARY",
+		})
+		return
+	}
+	if errors.Is(err, services.ErrCanaryMaintenance) {
+		c.JSON(http.StatusServiceUnavailable, models.ApiResponse{
+			Success: false, Error: err.Error(), Code: "MAINTENANCE_MODE",
+		})
+		return
 	}
+	c.JSON(http.StatusInternalServerError, models.ApiResponse{
+		Success: false, Error: "Failed to prepare messages", Code: "DATABASE_ERROR",
+	})
 }
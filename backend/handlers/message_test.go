@@ -2,13 +2,16 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"wechat-notification/models"
 	"wechat-notification/repository"
@@ -44,6 +47,13 @@ func (m *MockHTTPClient) Post(url, contentType string, body io.Reader) (*http.Re
 	}, nil
 }
 
+func (m *MockHTTPClient) Get(url string) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: 200,
+		Body:       io.NopCloser(strings.NewReader(`{"template_list": []}`)),
+	}, nil
+}
+
 func (m *MockHTTPClient) GetSentMessages() []string {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -73,7 +83,7 @@ func (m *MockTokenHTTPClient) Get(url string) (*http.Response, error) {
 func setupMessageRouter(repo *repository.SQLiteRepository, wechatService *services.WeChatService) *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
-	handler := NewMessageHandler(repo, wechatService)
+	handler := NewMessageHandler(repo, services.NewSender(repo, wechatService, ""))
 
 	api := router.Group("/api")
 	api.POST("/messages/send", handler.Send)
@@ -126,6 +136,13 @@ func TestProperty3_MessageSentToAllSelectedRecipients(t *testing.T) {
 
 			router := setupMessageRouter(repo, wechatService)
 
+			// Create the template referenced by the request
+			template := &models.MessageTemplate{Key: "test", TemplateID: "test_template_id", Name: "Test"}
+			if err := repo.CreateTemplate(template); err != nil {
+				t.Logf("Failed to create template: %v", err)
+				return false
+			}
+
 			// Create recipients in the database
 			recipientIDs := make([]int64, 0, recipientCount)
 			expectedOpenIDs := make(map[string]bool)
@@ -146,8 +163,8 @@ func TestProperty3_MessageSentToAllSelectedRecipients(t *testing.T) {
 
 			// Send message request
 			reqBody := models.SendMessageRequest{
-				Title:        title,
-				Content:      content,
+				TemplateKey:  template.Key,
+				Keywords:     map[string]string{"first": title, "keyword1": content},
 				RecipientIDs: recipientIDs,
 			}
 			bodyBytes, _ := json.Marshal(reqBody)
@@ -205,3 +222,234 @@ func generateUniqueOpenID(index int) string {
 func generateUniqueName(index int) string {
 	return "name_" + string(rune('A'+index%26)) + "_" + string(rune('0'+index/26))
 }
+
+func TestSendRejectsKeywordMismatch(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	mockMessageClient := &MockHTTPClient{}
+	tokenManager := services.NewTokenManagerWithClient("test_app_id", "test_app_secret", &MockTokenHTTPClient{})
+	router := setupMessageRouter(repo, services.NewWeChatServiceWithClient(tokenManager, "test_template_id", mockMessageClient))
+
+	template := &models.MessageTemplate{Key: "test", TemplateID: "test_template_id", Name: "Test", Fields: []string{"first", "keyword1", "remark"}}
+	if err := repo.CreateTemplate(template); err != nil {
+		t.Fatalf("CreateTemplate error: %v", err)
+	}
+	recipient := &models.Recipient{OpenID: "openid_a", Name: "a"}
+	if err := repo.Create(recipient); err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+
+	bodyBytes, _ := json.Marshal(models.SendMessageRequest{
+		TemplateKey:  template.Key,
+		Keywords:     map[string]string{"first": "title", "keywrod1": "typo"},
+		RecipientIDs: []int64{recipient.ID},
+	})
+	req, _ := http.NewRequest("POST", "/api/messages/send", bytes.NewReader(bodyBytes))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var resp struct {
+		Code string                      `json:"code"`
+		Data services.KeywordSchemaError `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusBadRequest || resp.Code != "KEYWORD_MISMATCH" {
+		t.Fatalf("status %d, body %s", w.Code, w.Body.String())
+	}
+	if strings.Join(resp.Data.Missing, ",") != "keyword1,remark" || strings.Join(resp.Data.Extra, ",") != "keywrod1" {
+		t.Errorf("unexpected mismatch: %+v", resp.Data)
+	}
+	if sent := mockMessageClient.GetSentMessages(); len(sent) != 0 {
+		t.Errorf("message reached WeChat: %v", sent)
+	}
+}
+
+// recordingClient records each template message posted to WeChat
+type recordingClient struct {
+	MockHTTPClient
+	messages []models.WeChatTemplateMessage
+}
+
+func (m *recordingClient) Post(url, contentType string, body io.Reader) (*http.Response, error) {
+	var msg models.WeChatTemplateMessage
+	json.NewDecoder(body).Decode(&msg)
+	m.messages = append(m.messages, msg)
+	return m.MockHTTPClient.Post(url, contentType, strings.NewReader("{}"))
+}
+
+func TestSendWithLinks(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	client := &recordingClient{}
+	tokenManager := services.NewTokenManagerWithClient("test_app_id", "test_app_secret", &MockTokenHTTPClient{})
+	router := setupMessageRouter(repo, services.NewWeChatServiceWithClient(tokenManager, "test_template_id", client))
+
+	template := &models.MessageTemplate{Key: "test", TemplateID: "test_template_id", Name: "Test"}
+	repo.CreateTemplate(template)
+	recipient := &models.Recipient{OpenID: "openid_a", Name: "a"}
+	repo.Create(recipient)
+
+	send := func(req models.SendMessageRequest) *httptest.ResponseRecorder {
+		bodyBytes, _ := json.Marshal(req)
+		httpReq, _ := http.NewRequest("POST", "/api/messages/send", bytes.NewReader(bodyBytes))
+		httpReq.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httpReq)
+		return w
+	}
+	keywords := map[string]string{"first": "磁盘告警"}
+
+	for _, req := range []models.SendMessageRequest{
+		{TemplateKey: "test", Keywords: keywords, RecipientIDs: []int64{recipient.ID}, URL: "javascript:alert(1)"},
+		{TemplateKey: "test", Keywords: keywords, RecipientIDs: []int64{recipient.ID}, URL: "https://grafana.local/d/disk", RequireAck: true},
+		{TemplateKey: "test", Keywords: keywords, RecipientIDs: []int64{recipient.ID}, Miniprogram: &models.Miniprogram{PagePath: "pages/index"}},
+	} {
+		if w := send(req); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "INVALID_") {
+			t.Errorf("url %q (requireAck %v): status %d, body %s", req.URL, req.RequireAck, w.Code, w.Body.String())
+		}
+	}
+
+	w := send(models.SendMessageRequest{TemplateKey: "test", Keywords: keywords, RecipientIDs: []int64{recipient.ID}, URL: "https://grafana.local/d/disk",
+		Miniprogram: &models.Miniprogram{AppID: "wx_mini", PagePath: "pages/alert?id=1"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body.String())
+	}
+	if len(client.messages) != 1 || client.messages[0].URL != "https://grafana.local/d/disk" ||
+		client.messages[0].Miniprogram == nil || client.messages[0].Miniprogram.PagePath != "pages/alert?id=1" {
+		t.Errorf("sent messages = %+v", client.messages)
+	}
+}
+
+func TestSendOutlivesCaller(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	client := &MockHTTPClient{}
+	tokenManager := services.NewTokenManagerWithClient("test_app_id", "test_app_secret", &MockTokenHTTPClient{})
+	router := setupMessageRouter(repo, services.NewWeChatServiceWithClient(tokenManager, "test_template_id", client))
+
+	repo.CreateTemplate(&models.MessageTemplate{Key: "test", TemplateID: "test_template_id", Name: "Test"})
+	recipient := &models.Recipient{OpenID: "openid_a", Name: "a"}
+	repo.Create(recipient)
+
+	// The caller is gone before the send starts; it goes out all the same
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	bodyBytes, _ := json.Marshal(models.SendMessageRequest{TemplateKey: "test", Keywords: map[string]string{"first": "hi"}, RecipientIDs: []int64{recipient.ID}})
+	req := httptest.NewRequest("POST", "/api/messages/send", bytes.NewReader(bodyBytes)).WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || len(client.GetSentMessages()) != 1 {
+		t.Errorf("status %d, sent to %v, body %s", w.Code, client.GetSentMessages(), w.Body.String())
+	}
+}
+
+func TestPreviewTemplate(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	mockMessageClient := &MockHTTPClient{}
+	tokenManager := services.NewTokenManagerWithClient("test_app_id", "test_app_secret", &MockTokenHTTPClient{})
+	handler := NewMessageHandler(repo, services.NewSender(repo, services.NewWeChatServiceWithClient(tokenManager, "test_template_id", mockMessageClient), ""))
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/templates/:key/preview", handler.PreviewTemplate)
+
+	template := &models.MessageTemplate{Key: "alert", TemplateID: "tpl_alert", Name: "Alert", Fields: []string{"first", "keyword1"}}
+	repo.CreateTemplate(template)
+	recipient := &models.Recipient{OpenID: "openid_a", Name: "Alice"}
+	repo.Create(recipient)
+
+	bodyBytes, _ := json.Marshal(PreviewTemplateRequest{Keywords: map[string]string{"first": "Hi {{name}}"}, RecipientID: recipient.ID})
+	req, _ := http.NewRequest("POST", "/api/templates/alert/preview", bytes.NewReader(bodyBytes))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Data struct {
+			Message         models.WeChatTemplateMessage `json:"message"`
+			KeywordMismatch *services.KeywordSchemaError `json:"keywordMismatch"`
+		} `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	msg := resp.Data.Message
+	first, _ := msg.Data["first"].(map[string]interface{})
+	if msg.ToUser != "openid_a" || msg.TemplateID != "tpl_alert" || first["value"] != "Hi Alice" {
+		t.Errorf("unexpected preview: %+v", msg)
+	}
+	if resp.Data.KeywordMismatch == nil || strings.Join(resp.Data.KeywordMismatch.Missing, ",") != "keyword1" {
+		t.Errorf("keyword mismatch = %+v", resp.Data.KeywordMismatch)
+	}
+	if sent := mockMessageClient.GetSentMessages(); len(sent) != 0 {
+		t.Errorf("preview sent a message: %v", sent)
+	}
+}
+
+func TestHistoryTimeZone(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	router := gin.New()
+	handler := NewMessageHandler(repo, services.NewSender(repo, nil, ""))
+	router.GET("/api/messages", handler.List)
+	router.GET("/api/messages/:id", handler.Get)
+
+	message := &models.Message{TemplateKey: "test", Keywords: map[string]string{}}
+	if err := repo.CreateMessage(message); err != nil {
+		t.Fatalf("CreateMessage error: %v", err)
+	}
+
+	get := func(path string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		var body struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w, body.Data
+	}
+
+	w, data := get("/api/messages?tz=Asia/Tokyo")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body.String())
+	}
+	item := data["items"].([]interface{})[0].(map[string]interface{})
+	if created, _ := item["createdAt"].(string); !strings.HasSuffix(created, "+09:00") || strings.Contains(created, ".") {
+		t.Errorf("createdAt = %v, want seconds precision in +09:00", item["createdAt"])
+	}
+	if item["createdRelative"] != "刚刚" {
+		t.Errorf("createdRelative = %v", item["createdRelative"])
+	}
+
+	_, data = get("/api/messages/" + strconv.FormatInt(message.ID, 10) + "?tz=UTC")
+	if created, _ := data["createdAt"].(string); !strings.HasSuffix(created, "Z") {
+		t.Errorf("detail createdAt = %v", data["createdAt"])
+	}
+	if w, _ := get("/api/messages?tz=Mars/Olympus"); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown zone: status %d", w.Code)
+	}
+}
+
+func TestRelativeTime(t *testing.T) {
+	for age, want := range map[time.Duration]string{
+		30 * time.Second:     "刚刚",
+		5 * time.Minute:      "5 分钟前",
+		3 * time.Hour:        "3 小时前",
+		50 * time.Hour:       "2 天前",
+		90 * 24 * time.Hour:  "3 个月前",
+		800 * 24 * time.Hour: "2 年前",
+	} {
+		if got := relativeTime(age); got != want {
+			t.Errorf("relativeTime(%s) = %q, want %q", age, got, want)
+		}
+	}
+}
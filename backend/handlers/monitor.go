@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MonitorHandler handles HTTP uptime monitor endpoints
+type MonitorHandler struct {
+	repo    *repository.SQLiteRepository
+	checker *services.MonitorChecker
+}
+
+// NewMonitorHandler creates a new monitor handler
+func NewMonitorHandler(repo *repository.SQLiteRepository, checker *services.MonitorChecker) *MonitorHandler {
+	return &MonitorHandler{repo: repo, checker: checker}
+}
+
+// MonitorRequest represents the request body for creating or updating a monitor
+type MonitorRequest struct {
+	Name            string            `json:"name" binding:"required"`
+	URL             string            `json:"url" binding:"required"`
+	Method          string            `json:"method"`
+	ExpectedStatus  int               `json:"expectedStatus"`
+	IntervalSeconds int               `json:"intervalSeconds"`
+	TimeoutSeconds  int               `json:"timeoutSeconds"`
+	TemplateKey     string            `json:"templateKey" binding:"required"`
+	GroupIDs        []int64           `json:"groupIds" binding:"required"`
+	Keywords        map[string]string `json:"keywords" binding:"required"`
+	Enabled         *bool             `json:"enabled"`
+}
+
+// List returns all monitors
+// GET /api/monitors
+func (h *MonitorHandler) List(c *gin.Context) {
+	monitors, err := h.repo.GetAllMonitors()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to get monitors", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: monitors})
+}
+
+// Create creates a new monitor
+// POST /api/monitors
+func (h *MonitorHandler) Create(c *gin.Context) {
+	monitor, ok := h.bindMonitor(c)
+	if !ok {
+		return
+	}
+
+	if err := h.repo.CreateMonitor(monitor); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to create monitor", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.ApiResponse{Success: true, Data: monitor})
+}
+
+// Update updates a monitor
+// PUT /api/monitors/:id
+func (h *MonitorHandler) Update(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	monitor, ok := h.bindMonitor(c)
+	if !ok {
+		return
+	}
+	monitor.ID = id
+
+	if err := h.repo.UpdateMonitor(monitor); err != nil {
+		h.writeError(c, err, "Failed to update monitor")
+		return
+	}
+
+	updated, err := h.repo.GetMonitorByID(id)
+	if err != nil {
+		h.writeError(c, err, "Failed to retrieve monitor")
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: updated})
+}
+
+// Delete deletes a monitor
+// DELETE /api/monitors/:id
+func (h *MonitorHandler) Delete(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	if err := h.repo.DeleteMonitor(id); err != nil {
+		h.writeError(c, err, "Failed to delete monitor")
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true})
+}
+
+// Check runs a monitor immediately
+// POST /api/monitors/:id/check
+func (h *MonitorHandler) Check(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	monitor, err := h.repo.GetMonitorByID(id)
+	if err != nil {
+		h.writeError(c, err, "Failed to retrieve monitor")
+		return
+	}
+
+	result, err := h.checker.Check(monitor)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Data: result, Error: err.Error(), Code: "MONITOR_CHECK_FAILED",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: result})
+}
+
+func (h *MonitorHandler) bindMonitor(c *gin.Context) (*models.Monitor, bool) {
+	var req MonitorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request: name, url, templateKey, groupIds and keywords are required", Code: "INVALID_REQUEST",
+		})
+		return nil, false
+	}
+
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "URL must be an absolute http(s) URL", Code: "VALIDATION_ERROR",
+		})
+		return nil, false
+	}
+	method := strings.ToUpper(strings.TrimSpace(req.Method))
+	switch method {
+	case "":
+		method = http.MethodGet
+	case http.MethodGet, http.MethodHead:
+	default:
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Method must be GET or HEAD", Code: "VALIDATION_ERROR",
+		})
+		return nil, false
+	}
+	if req.ExpectedStatus == 0 {
+		req.ExpectedStatus = http.StatusOK
+	}
+	if req.ExpectedStatus < 100 || req.ExpectedStatus > 599 {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Expected status must be a valid HTTP status code", Code: "VALIDATION_ERROR",
+		})
+		return nil, false
+	}
+	if len(req.GroupIDs) == 0 || len(req.Keywords) == 0 {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "At least one group and one keyword are required", Code: "VALIDATION_ERROR",
+		})
+		return nil, false
+	}
+	if _, err := h.repo.GetTemplateByKey(req.TemplateKey); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Template not found", Code: "TEMPLATE_NOT_FOUND",
+		})
+		return nil, false
+	}
+
+	interval := req.IntervalSeconds
+	if interval == 0 {
+		interval = 60
+	}
+	if interval < services.MinMonitorInterval {
+		interval = services.MinMonitorInterval
+	}
+	timeout := req.TimeoutSeconds
+	if timeout <= 0 || timeout > interval {
+		timeout = services.DefaultMonitorTimeout
+	}
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	return &models.Monitor{
+		Name:            strings.TrimSpace(req.Name),
+		URL:             req.URL,
+		Method:          method,
+		ExpectedStatus:  req.ExpectedStatus,
+		IntervalSeconds: interval,
+		TimeoutSeconds:  timeout,
+		TemplateKey:     req.TemplateKey,
+		GroupIDs:        uniqueIDs(req.GroupIDs),
+		Keywords:        req.Keywords,
+		Enabled:         enabled,
+	}, true
+}
+
+func (h *MonitorHandler) writeError(c *gin.Context, err error, message string) {
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, models.ApiResponse{
+			Success: false, Error: "Monitor not found", Code: "NOT_FOUND",
+		})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, models.ApiResponse{
+		Success: false, Error: message, Code: "DATABASE_ERROR",
+	})
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"wechat-notification/models"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// synologyRequest holds a Synology DSM webhook notification. DSM's custom webhook provider sends
+// the message text in whichever parameter was given the "message content" type (@@TEXT@@), as
+// query, form or JSON; the endpoint expects it to be named text.
+type synologyRequest struct {
+	Text     string `form:"text" json:"text"`
+	Title    string `form:"title" json:"title"`
+	Hostname string `form:"hostname" json:"hostname"`
+}
+
+// qnapRequest holds a QNAP Notification Center webhook notification. QTS and QuTS hero fill the
+// body from the event; older firmware names the message content.
+type qnapRequest struct {
+	Message  string `form:"message" json:"message"`
+	Content  string `form:"content" json:"content"`
+	Title    string `form:"title" json:"title"`
+	Level    string `form:"level" json:"level"`       // information, warning or error
+	Category string `form:"category" json:"category"` // Application that raised the event, e.g. Storage & Snapshots
+	Hostname string `form:"hostname" json:"hostname"`
+}
+
+// Synology sends a Synology DSM notification through the template configured for the Synology endpoint
+// GET|POST /api/webhook/synology/:token
+func (h *CompatHandler) Synology(c *gin.Context) {
+	var req synologyRequest
+	if err := bindKeepingBody(c, &req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+	if strings.TrimSpace(req.Text) == "" {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "text is required", Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+	h.sendParts(c, models.CompatSynology, synologyParts(req))
+}
+
+// QNAP sends a QNAP Notification Center event through the template configured for the QNAP endpoint
+// POST /api/webhook/qnap/:token
+func (h *CompatHandler) QNAP(c *gin.Context) {
+	var req qnapRequest
+	if err := bindKeepingBody(c, &req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+	if strings.TrimSpace(req.Message) == "" && strings.TrimSpace(req.Content) == "" {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "message is required", Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+	h.sendParts(c, models.CompatQNAP, qnapParts(req))
+}
+
+// sendParts sends request parts through the endpoint of service, checking the token in the path
+func (h *CompatHandler) sendParts(c *gin.Context, service string, parts map[string]string) {
+	response, status, err := h.send(c, service, c.Param("token"), parts)
+	if err != nil {
+		code := "SEND_FAILED"
+		if status == http.StatusUnauthorized {
+			code = "UNAUTHORIZED"
+		}
+		c.JSON(status, models.ApiResponse{Success: false, Error: err.Error(), Code: code})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: response})
+}
+
+// synologyParts splits a DSM notification into request parts. DSM puts the subject on the first
+// line of the text, so it becomes the title unless one was sent.
+func synologyParts(req synologyRequest) map[string]string {
+	text := strings.TrimSpace(req.Text)
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		title, _, _ = strings.Cut(text, "\n")
+	}
+	return map[string]string{
+		"title":    strings.TrimSpace(title),
+		"content":  text,
+		"hostname": req.Hostname,
+	}
+}
+
+// qnapParts maps a QNAP event to request parts, titling it "[level] category" when no title was sent
+func qnapParts(req qnapRequest) map[string]string {
+	content := strings.TrimSpace(req.Message)
+	if content == "" {
+		content = strings.TrimSpace(req.Content)
+	}
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		title = strings.TrimSpace(req.Category)
+		if req.Level != "" {
+			title = strings.TrimSpace("[" + req.Level + "] " + title)
+		}
+	}
+	if title == "" {
+		title = services.TruncateRunes(content, 20)
+	}
+	return map[string]string{
+		"title":    title,
+		"content":  content,
+		"level":    req.Level,
+		"category": req.Category,
+		"hostname": req.Hostname,
+	}
+}
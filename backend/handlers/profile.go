@@ -0,0 +1,344 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"wechat-notification/config"
+	"wechat-notification/middleware"
+	"wechat-notification/models"
+	"wechat-notification/repository"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// ProfileLinkTTL is how long a magic link issued by an admin stays valid
+	ProfileLinkTTL = 7 * 24 * time.Hour
+	// ProfileOAuthTTL is how long a token obtained through WeChat OAuth stays valid
+	ProfileOAuthTTL = 24 * time.Hour
+)
+
+// ProfileHandler handles recipient self-service endpoints
+type ProfileHandler struct {
+	repo          *repository.SQLiteRepository
+	signer        *services.ProfileTokenSigner
+	tokenManager  *services.TokenManager
+	httpClient    services.HTTPClient
+	baseURL       string
+	defaultSecret bool // SESSION_SECRET is a published default, so profile tokens could be forged and none are issued
+}
+
+// NewProfileHandler creates a new profile handler
+func NewProfileHandler(cfg *config.Config, repo *repository.SQLiteRepository, signer *services.ProfileTokenSigner, tokenManager *services.TokenManager) *ProfileHandler {
+	return &ProfileHandler{
+		repo:          repo,
+		signer:        signer,
+		tokenManager:  tokenManager,
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+		baseURL:       cfg.PublicBaseURL,
+		defaultSecret: cfg.UsesDefaultSessionSecret(),
+	}
+}
+
+// UpdateProfileRequest represents the request body for updating a profile
+type UpdateProfileRequest struct {
+	Name            *string `json:"name"`
+	Muted           *bool   `json:"muted"`
+	QuietHoursStart *string `json:"quietHoursStart"`
+	QuietHoursEnd   *string `json:"quietHoursEnd"`
+}
+
+// UpdateSubscriptionRequest represents the request body for toggling a template subscription
+type UpdateSubscriptionRequest struct {
+	Subscribed bool `json:"subscribed"`
+}
+
+// CreateLink issues a magic link for a recipient's profile page
+// POST /api/recipients/:id/profile-link
+func (h *ProfileHandler) CreateLink(c *gin.Context) {
+	if !h.canIssueTokens(c) {
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid recipient ID", Code: "INVALID_ID",
+		})
+		return
+	}
+
+	if _, err := h.repo.GetByID(id); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, models.ApiResponse{
+				Success: false, Error: "Recipient not found", Code: "NOT_FOUND",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve recipient", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+
+	token := h.signer.Sign(id, ProfileLinkTTL)
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Data: gin.H{
+			"token":     token,
+			"url":       h.profileURL(token),
+			"expiresAt": time.Now().Add(ProfileLinkTTL),
+		},
+	})
+}
+
+// OAuthLogin redirects the recipient to WeChat web authorization
+// GET /auth/profile/login
+func (h *ProfileHandler) OAuthLogin(c *gin.Context) {
+	appID, _ := h.tokenManager.GetCredentials()
+	if appID == "" {
+		c.JSON(http.StatusServiceUnavailable, models.ApiResponse{
+			Success: false, Error: "WeChat configuration not set", Code: "CONFIG_NOT_SET",
+		})
+		return
+	}
+
+	state, err := services.GenerateState()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to generate state", Code: "STATE_GENERATION_FAILED",
+		})
+		return
+	}
+	c.SetCookie(StateCookieName, state, 600, "/", "", false, true)
+
+	redirectURL := h.baseURL + "/auth/profile/callback"
+	c.Redirect(http.StatusFound, services.BuildWeChatOAuthURL(appID, redirectURL, state))
+}
+
+// OAuthCallback resolves the recipient's openid and redirects to the profile page with a token
+// GET /auth/profile/callback
+func (h *ProfileHandler) OAuthCallback(c *gin.Context) {
+	if !h.canIssueTokens(c) {
+		return
+	}
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Missing authorization code", Code: "MISSING_CODE",
+		})
+		return
+	}
+
+	storedState, err := c.Cookie(StateCookieName)
+	if err != nil || c.Query("state") == "" || c.Query("state") != storedState {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid state parameter", Code: "INVALID_STATE",
+		})
+		return
+	}
+	c.SetCookie(StateCookieName, "", -1, "/", "", false, true)
+
+	appID, appSecret := h.tokenManager.GetCredentials()
+	openID, err := services.ExchangeWeChatOAuthCode(h.httpClient, appID, appSecret, code)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, models.ApiResponse{
+			Success: false, Error: err.Error(), Code: "TOKEN_EXCHANGE_FAILED",
+		})
+		return
+	}
+
+	recipient, err := h.repo.GetByOpenID(openID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, models.ApiResponse{
+				Success: false, Error: "You are not registered as a recipient", Code: "NOT_FOUND",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve recipient", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+
+	c.Redirect(http.StatusFound, h.profileURL(h.signer.Sign(recipient.ID, ProfileOAuthTTL)))
+}
+
+// Get returns the authenticated recipient's profile and subscriptions
+// GET /api/profile
+func (h *ProfileHandler) Get(c *gin.Context) {
+	profile, ok := h.loadProfile(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: profile})
+}
+
+// Update changes the authenticated recipient's display name, mute flag or quiet hours
+// PUT /api/profile
+func (h *ProfileHandler) Update(c *gin.Context) {
+	recipient, ok := h.loadRecipient(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "Name cannot be empty or whitespace only", Code: "VALIDATION_ERROR",
+			})
+			return
+		}
+		recipient.Name = name
+	}
+	if req.Muted != nil {
+		recipient.Muted = *req.Muted
+	}
+	if req.QuietHoursStart != nil {
+		recipient.QuietHoursStart = strings.TrimSpace(*req.QuietHoursStart)
+	}
+	if req.QuietHoursEnd != nil {
+		recipient.QuietHoursEnd = strings.TrimSpace(*req.QuietHoursEnd)
+	}
+	if err := services.ValidateQuietHours(recipient.QuietHoursStart, recipient.QuietHoursEnd); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: err.Error(), Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+
+	if err := h.repo.UpdateProfile(recipient); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to update profile", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+
+	h.Get(c)
+}
+
+// UpdateSubscription opts the authenticated recipient in to or out of a template
+// PUT /api/profile/subscriptions/:key
+func (h *ProfileHandler) UpdateSubscription(c *gin.Context) {
+	recipient, ok := h.loadRecipient(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+
+	key := c.Param("key")
+	if _, err := h.repo.GetTemplateByKey(key); err != nil {
+		c.JSON(http.StatusNotFound, models.ApiResponse{
+			Success: false, Error: "Template not found", Code: "TEMPLATE_NOT_FOUND",
+		})
+		return
+	}
+
+	if err := h.repo.SetTemplateSubscription(recipient.ID, key, req.Subscribed); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to update subscription", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+
+	h.Get(c)
+}
+
+// loadRecipient fetches the recipient identified by the profile token, writing an error response on failure
+func (h *ProfileHandler) loadRecipient(c *gin.Context) (*models.Recipient, bool) {
+	recipient, err := h.repo.GetByID(middleware.GetRecipientIDFromContext(c))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, models.ApiResponse{
+				Success: false, Error: "Recipient not found", Code: "NOT_FOUND",
+			})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve recipient", Code: "DATABASE_ERROR",
+		})
+		return nil, false
+	}
+	return recipient, true
+}
+
+// loadProfile builds the profile view for the authenticated recipient
+func (h *ProfileHandler) loadProfile(c *gin.Context) (*models.RecipientProfile, bool) {
+	recipient, ok := h.loadRecipient(c)
+	if !ok {
+		return nil, false
+	}
+
+	templates, err := h.repo.GetAllTemplates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to get templates", Code: "DATABASE_ERROR",
+		})
+		return nil, false
+	}
+	unsubscribed, err := h.repo.GetUnsubscribedTemplateKeys(recipient.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to get subscriptions", Code: "DATABASE_ERROR",
+		})
+		return nil, false
+	}
+	optedOut := make(map[string]bool, len(unsubscribed))
+	for _, key := range unsubscribed {
+		optedOut[key] = true
+	}
+
+	subscriptions := make([]models.ProfileSubscription, 0, len(templates))
+	for _, t := range templates {
+		subscriptions = append(subscriptions, models.ProfileSubscription{
+			TemplateKey: t.Key,
+			Name:        t.Name,
+			Subscribed:  !optedOut[t.Key],
+		})
+	}
+
+	return &models.RecipientProfile{
+		Name:            recipient.Name,
+		Muted:           recipient.Muted,
+		QuietHoursStart: recipient.QuietHoursStart,
+		QuietHoursEnd:   recipient.QuietHoursEnd,
+		Subscriptions:   subscriptions,
+	}, true
+}
+
+// canIssueTokens answers 503 while SESSION_SECRET is a published default
+func (h *ProfileHandler) canIssueTokens(c *gin.Context) bool {
+	if h.defaultSecret {
+		c.JSON(http.StatusServiceUnavailable, models.ApiResponse{
+			Success: false, Error: "Set SESSION_SECRET before issuing profile links", Code: "DEFAULT_SESSION_SECRET",
+		})
+		return false
+	}
+	return true
+}
+
+func (h *ProfileHandler) profileURL(token string) string {
+	return h.baseURL + "/profile?token=" + url.QueryEscape(token)
+}
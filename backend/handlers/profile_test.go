@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"wechat-notification/config"
+	"wechat-notification/middleware"
+	"wechat-notification/models"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCreateProfileLinkNeedsSessionSecret(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+	recipient := &models.Recipient{OpenID: "o1", Name: "张三"}
+	repo.Create(recipient)
+
+	createLink := func(secret string) *httptest.ResponseRecorder {
+		cfg := &config.Config{SessionSecret: secret}
+		handler := NewProfileHandler(cfg, repo, services.NewProfileTokenSigner(secret), services.NewTokenManager("app", "secret"))
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.POST("/api/recipients/:id/profile-link", handler.CreateLink)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/recipients/"+strconv.FormatInt(recipient.ID, 10)+"/profile-link", nil))
+		return w
+	}
+
+	if w := createLink(config.DefaultSessionSecret); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("default secret: status %d, body %s", w.Code, w.Body.String())
+	}
+	if w := createLink("a-real-secret"); w.Code != http.StatusOK {
+		t.Errorf("configured secret: status %d, body %s", w.Code, w.Body.String())
+	}
+}
+
+func TestProfileHidesDeliveryDetails(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+	recipient := &models.Recipient{
+		OpenID: "o1", Name: "张三", Email: "zs@example.com", TelegramChatID: "42",
+		Metadata:        map[string]string{"team": "ops"},
+		Channels:        []models.Channel{{Type: models.ChannelServerChan, Token: "SCTs3cret"}},
+		FallbackChannel: &models.Channel{Type: models.ChannelSlack, WebhookURL: "https://hooks.slack.com/services/s3cret"},
+	}
+	repo.Create(recipient)
+
+	signer := services.NewProfileTokenSigner("a-real-secret")
+	handler := NewProfileHandler(&config.Config{SessionSecret: "a-real-secret"}, repo, signer, services.NewTokenManager("app", "secret"))
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/profile", middleware.ProfileAuthMiddleware(signer), handler.Get)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/profile?token="+signer.Sign(recipient.ID, ProfileLinkTTL), nil))
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(body, `"name":"张三"`) {
+		t.Fatalf("status %d, body %s", w.Code, body)
+	}
+	for _, hidden := range []string{"s3cret", "zs@example.com", "ops", "o1", `"channels"`} {
+		if strings.Contains(body, hidden) {
+			t.Errorf("profile exposes %s: %s", hidden, body)
+		}
+	}
+}
@@ -5,16 +5,19 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"wechat-notification/models"
 	"wechat-notification/repository"
+	"wechat-notification/services"
 
 	"github.com/gin-gonic/gin"
 )
 
 // RecipientHandler handles recipient endpoints
 type RecipientHandler struct {
-	repo *repository.SQLiteRepository
+	repo     *repository.SQLiteRepository
+	warnings *services.ConfigWarnings
 }
 
 // NewRecipientHandler creates a new recipient handler
@@ -22,16 +25,38 @@ func NewRecipientHandler(repo *repository.SQLiteRepository) *RecipientHandler {
 	return &RecipientHandler{repo: repo}
 }
 
+// SetWarnings attaches the configuration warnings surfaced with the recipient list
+func (h *RecipientHandler) SetWarnings(warnings *services.ConfigWarnings) {
+	h.warnings = warnings
+}
+
 // CreateRecipientRequest represents the request body for creating a recipient
 type CreateRecipientRequest struct {
-	OpenID string `json:"openId" binding:"required"`
-	Name   string `json:"name" binding:"required"`
+	OpenID   string            `json:"openId" binding:"required"`
+	AppID    int64             `json:"appId"` // Official account the OpenID belongs to, 0 for the default account
+	Name     string            `json:"name" binding:"required"`
+	Metadata map[string]string `json:"metadata"`
+	Channels []models.Channel  `json:"channels"`
+
+	FallbackChannel *models.Channel `json:"fallbackChannel"`
+	Email           string          `json:"email"`
+	TelegramChatID  string          `json:"telegramChatId"`
+	Preferences     []string        `json:"preferences"`
 }
 
 // UpdateRecipientRequest represents the request body for updating a recipient
 type UpdateRecipientRequest struct {
-	OpenID string `json:"openId"`
-	Name   string `json:"name"`
+	OpenID   string            `json:"openId"`
+	AppID    *int64            `json:"appId"` // Moves the recipient to another official account when present
+	Name     string            `json:"name"`
+	Metadata map[string]string `json:"metadata"` // Replaces all metadata when present
+	Channels []models.Channel  `json:"channels"` // Replaces all personal channels when present
+
+	FallbackChannel *models.Channel `json:"fallbackChannel"` // Replaces the fallback when present; an empty type removes it
+	Email           *string         `json:"email"`           // Replaces the email when present; "" removes it
+	TelegramChatID  *string         `json:"telegramChatId"`  // Replaces the Telegram chat when present; "" removes it
+	Preferences     []string        `json:"preferences"`     // Replaces the contact preferences when present
+	Unsubscribed    *bool           `json:"unsubscribed"`    // Set to false to include the recipient in sends to everyone again
 }
 
 // GetAll returns all recipients
@@ -48,8 +73,9 @@ func (h *RecipientHandler) GetAll(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, models.ApiResponse{
-		Success: true,
-		Data:    recipients,
+		Success:  true,
+		Data:     maskRecipients(recipients),
+		Warnings: h.warnings.Collect(time.Now()),
 	})
 }
 
@@ -86,9 +112,41 @@ func (h *RecipientHandler) Create(c *gin.Context) {
 		return
 	}
 
+	metadata, err := services.NormalizeMetadata(req.Metadata)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Error:   err.Error(),
+			Code:    "VALIDATION_ERROR",
+		})
+		return
+	}
+
+	if !validateRecipientChannels(c, req.Channels) {
+		return
+	}
+	fallback, ok := bindFallbackChannel(c, req.FallbackChannel)
+	if !ok {
+		return
+	}
+
+	if !h.validateApp(c, req.AppID) {
+		return
+	}
+
 	recipient := &models.Recipient{
-		OpenID: strings.TrimSpace(req.OpenID),
-		Name:   strings.TrimSpace(req.Name),
+		OpenID:          strings.TrimSpace(req.OpenID),
+		AppID:           req.AppID,
+		Name:            strings.TrimSpace(req.Name),
+		Metadata:        metadata,
+		Channels:        req.Channels,
+		FallbackChannel: fallback,
+		Email:           req.Email,
+		TelegramChatID:  req.TelegramChatID,
+		Preferences:     req.Preferences,
+	}
+	if !validatePreferences(c, recipient) {
+		return
 	}
 
 	if err := h.repo.Create(recipient); err != nil {
@@ -110,7 +168,7 @@ func (h *RecipientHandler) Create(c *gin.Context) {
 
 	c.JSON(http.StatusCreated, models.ApiResponse{
 		Success: true,
-		Data:    recipient,
+		Data:    maskRecipient(*recipient),
 	})
 }
 
@@ -171,6 +229,13 @@ func (h *RecipientHandler) Update(c *gin.Context) {
 		existing.OpenID = trimmedOpenID
 	}
 
+	if req.AppID != nil {
+		if !h.validateApp(c, *req.AppID) {
+			return
+		}
+		existing.AppID = *req.AppID
+	}
+
 	if req.Name != "" {
 		trimmedName := strings.TrimSpace(req.Name)
 		if trimmedName == "" {
@@ -184,6 +249,56 @@ func (h *RecipientHandler) Update(c *gin.Context) {
 		existing.Name = trimmedName
 	}
 
+	if req.Metadata != nil {
+		metadata, err := services.NormalizeMetadata(req.Metadata)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false,
+				Error:   err.Error(),
+				Code:    "VALIDATION_ERROR",
+			})
+			return
+		}
+		existing.Metadata = metadata
+	}
+
+	if req.Channels != nil {
+		keepChannelSecrets(req.Channels, existing.Channels)
+		if !validateRecipientChannels(c, req.Channels) {
+			return
+		}
+		existing.Channels = req.Channels
+	}
+
+	if req.FallbackChannel != nil {
+		if existing.FallbackChannel != nil {
+			channels := []models.Channel{*req.FallbackChannel}
+			keepChannelSecrets(channels, []models.Channel{*existing.FallbackChannel})
+			req.FallbackChannel = &channels[0]
+		}
+		fallback, ok := bindFallbackChannel(c, req.FallbackChannel)
+		if !ok {
+			return
+		}
+		existing.FallbackChannel = fallback
+	}
+
+	if req.Email != nil {
+		existing.Email = *req.Email
+	}
+	if req.TelegramChatID != nil {
+		existing.TelegramChatID = *req.TelegramChatID
+	}
+	if req.Preferences != nil {
+		existing.Preferences = req.Preferences
+	}
+	if req.Unsubscribed != nil {
+		existing.Unsubscribed = *req.Unsubscribed
+	}
+	if !validatePreferences(c, existing) {
+		return
+	}
+
 	if err := h.repo.Update(existing); err != nil {
 		if errors.Is(err, repository.ErrDuplicateOpenID) {
 			c.JSON(http.StatusConflict, models.ApiResponse{
@@ -203,7 +318,7 @@ func (h *RecipientHandler) Update(c *gin.Context) {
 
 	c.JSON(http.StatusOK, models.ApiResponse{
 		Success: true,
-		Data:    existing,
+		Data:    maskRecipient(*existing),
 	})
 }
 
@@ -243,3 +358,199 @@ func (h *RecipientHandler) Delete(c *gin.Context) {
 		Data:    gin.H{"message": "Recipient deleted successfully"},
 	})
 }
+
+// defaultStaleFailures is how many unsubscribed failures in a row make a recipient stale
+const defaultStaleFailures = 3
+
+// DeactivateStaleRequest represents the request body for deactivating stale recipients
+type DeactivateStaleRequest struct {
+	RecipientIDs []int64 `json:"recipientIds"` // Optional, defaults to every recipient in the stale report
+	MinFailures  int     `json:"minFailures"`  // Threshold used when recipientIds is empty, defaults to 3
+}
+
+// Stale lists recipients whose recent sends keep failing because they unfollowed the account
+// GET /api/recipients/stale?minFailures=3
+func (h *RecipientHandler) Stale(c *gin.Context) {
+	minFailures, err := strconv.Atoi(c.DefaultQuery("minFailures", strconv.Itoa(defaultStaleFailures)))
+	if err != nil || minFailures < 1 {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "minFailures must be a positive integer", Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+
+	recipients, err := h.repo.GetStaleRecipients(minFailures)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve recipients", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Data:    gin.H{"minFailures": minFailures, "items": maskRecipients(recipients)},
+	})
+}
+
+// DeactivateStale mutes stale recipients so they stop counting as failures
+// POST /api/recipients/stale/deactivate
+func (h *RecipientHandler) DeactivateStale(c *gin.Context) {
+	var req DeactivateStaleRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+			})
+			return
+		}
+	}
+	if req.MinFailures == 0 {
+		req.MinFailures = defaultStaleFailures
+	}
+	if req.MinFailures < 0 {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "minFailures must be a positive integer", Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+
+	ids := uniqueIDs(req.RecipientIDs)
+	if len(ids) == 0 {
+		stale, err := h.repo.GetStaleRecipients(req.MinFailures)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, models.ApiResponse{
+				Success: false, Error: "Failed to retrieve recipients", Code: "DATABASE_ERROR",
+			})
+			return
+		}
+		for _, r := range stale {
+			ids = append(ids, r.ID)
+		}
+	}
+
+	deactivated, err := h.repo.MuteRecipients(ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to deactivate recipients", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Data:    gin.H{"deactivated": deactivated},
+	})
+}
+
+// validateRecipientChannels validates personal channels, rejecting group robots that only make sense on groups
+func validateRecipientChannels(c *gin.Context, channels []models.Channel) bool {
+	for _, ch := range channels {
+		if strings.TrimSpace(ch.Type) == models.ChannelWeComBot {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "Group robot channels can only be attached to groups", Code: "VALIDATION_ERROR",
+			})
+			return false
+		}
+	}
+	return validateChannels(c, channels)
+}
+
+// bindFallbackChannel validates a fallback channel, returning nil when none is set
+func bindFallbackChannel(c *gin.Context, ch *models.Channel) (*models.Channel, bool) {
+	if ch == nil || strings.TrimSpace(ch.Type) == "" {
+		return nil, true
+	}
+	channels := []models.Channel{*ch}
+	if !validateRecipientChannels(c, channels) {
+		return nil, false
+	}
+	return &channels[0], true
+}
+
+// maskChannels returns a copy of channels with their secrets, tokens and webhook URLs masked.
+// Slack, Feishu and WeCom robot webhook URLs carry their key, so they're as secret as a token.
+func maskChannels(channels []models.Channel) []models.Channel {
+	if channels == nil {
+		return nil
+	}
+	masked := make([]models.Channel, len(channels))
+	for i, ch := range channels {
+		ch.WebhookURL = services.MaskSecret(ch.WebhookURL)
+		ch.Secret = services.MaskSecret(ch.Secret)
+		ch.Token = services.MaskSecret(ch.Token)
+		masked[i] = ch
+	}
+	return masked
+}
+
+// maskRecipient returns a copy of recipient with its channel secrets masked
+func maskRecipient(recipient models.Recipient) models.Recipient {
+	recipient.Channels = maskChannels(recipient.Channels)
+	if recipient.FallbackChannel != nil {
+		fallback := maskChannels([]models.Channel{*recipient.FallbackChannel})[0]
+		recipient.FallbackChannel = &fallback
+	}
+	return recipient
+}
+
+// maskRecipients masks the channel secrets of every recipient in a list
+func maskRecipients(recipients []models.Recipient) []models.Recipient {
+	masked := make([]models.Recipient, len(recipients))
+	for i, recipient := range recipients {
+		masked[i] = maskRecipient(recipient)
+	}
+	return masked
+}
+
+// keepChannelSecrets restores secrets, tokens and webhook URLs submitted masked from the saved
+// channel of the same type at the same position
+func keepChannelSecrets(channels, saved []models.Channel) {
+	for i := range channels {
+		if i >= len(saved) || channels[i].Type != saved[i].Type {
+			continue
+		}
+		if channels[i].WebhookURL == "******" {
+			channels[i].WebhookURL = saved[i].WebhookURL
+		}
+		if channels[i].Secret == "******" {
+			channels[i].Secret = saved[i].Secret
+		}
+		if channels[i].Token == "******" {
+			channels[i].Token = saved[i].Token
+		}
+	}
+}
+
+// validatePreferences checks a recipient's contact details and preferences, writing a 400 response on failure
+func validatePreferences(c *gin.Context, recipient *models.Recipient) bool {
+	if err := services.ValidatePreferences(recipient); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: err.Error(), Code: "VALIDATION_ERROR",
+		})
+		return false
+	}
+	return true
+}
+
+// validateApp checks that the official account a recipient belongs to exists; 0 is the default account
+func (h *RecipientHandler) validateApp(c *gin.Context, appID int64) bool {
+	if appID == 0 {
+		return true
+	}
+	if _, err := h.repo.GetWeChatAppByID(appID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false,
+				Error:   "WeChat app not found",
+				Code:    "APP_NOT_FOUND",
+			})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false,
+			Error:   "Failed to retrieve app",
+			Code:    "DATABASE_ERROR",
+		})
+		return false
+	}
+	return true
+}
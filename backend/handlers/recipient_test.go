@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"wechat-notification/models"
@@ -161,3 +162,81 @@ func TestProperty6_RecipientListCompleteness(t *testing.T) {
 
 	properties.TestingRun(t)
 }
+
+func TestRecipientChannelSecretsMasked(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+	router := setupRouter(repo)
+
+	recipient := &models.Recipient{
+		OpenID:          "o1",
+		Name:            "张三",
+		Channels:        []models.Channel{{Type: models.ChannelServerChan, Token: "SCTs3cret"}},
+		FallbackChannel: &models.Channel{Type: models.ChannelGotify, ServerURL: "https://gotify.example.com", Token: "g0tify"},
+	}
+	repo.Create(recipient)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/recipients", nil))
+	if body := w.Body.String(); w.Code != http.StatusOK || strings.Contains(body, "SCTs3cret") || strings.Contains(body, "g0tify") {
+		t.Fatalf("list: status %d, body %s", w.Code, body)
+	}
+
+	// Saving the masked values back keeps the stored secrets
+	body := `{"channels":[{"type":"serverchan","token":"******"}],"fallbackChannel":{"type":"gotify","serverUrl":"https://gotify.example.com","token":"******"}}`
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/api/recipients/%d", recipient.ID), strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "SCTs3cret") {
+		t.Fatalf("update: status %d, body %s", w.Code, w.Body.String())
+	}
+	saved, _ := repo.GetByID(recipient.ID)
+	if saved.Channels[0].Token != "SCTs3cret" || saved.FallbackChannel.Token != "g0tify" {
+		t.Errorf("masked update overwrote secrets: %+v, %+v", saved.Channels, saved.FallbackChannel)
+	}
+}
+
+func TestChannelWebhookURLsMasked(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+	router := setupRouter(repo)
+	groups := NewGroupHandler(repo)
+	router.GET("/api/groups", groups.List)
+	router.PUT("/api/groups/:id", groups.Update)
+
+	const hook = "https://hooks.slack.com/services/T000/B000/s3cret"
+	recipient := &models.Recipient{OpenID: "o1", Name: "张三", Channels: []models.Channel{{Type: models.ChannelSlack, WebhookURL: hook}}}
+	repo.Create(recipient)
+	group := &models.Group{Name: "ops", RecipientIDs: []int64{}, Channels: []models.Channel{{Type: models.ChannelSlack, WebhookURL: hook}}}
+	if err := repo.CreateGroup(group); err != nil {
+		t.Fatalf("CreateGroup error: %v", err)
+	}
+
+	for _, path := range []string{"/api/recipients", "/api/groups"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if body := w.Body.String(); w.Code != http.StatusOK || strings.Contains(body, "s3cret") || !strings.Contains(body, `"webhookUrl":"******"`) {
+			t.Fatalf("%s: status %d, body %s", path, w.Code, body)
+		}
+	}
+
+	// Saving the masked URL back keeps the stored one
+	for path, body := range map[string]string{
+		fmt.Sprintf("/api/recipients/%d", recipient.ID): `{"channels":[{"type":"slack","webhookUrl":"******"}]}`,
+		fmt.Sprintf("/api/groups/%d", group.ID):         `{"name":"ops","channels":[{"type":"slack","webhookUrl":"******"}]}`,
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "s3cret") {
+			t.Fatalf("%s: status %d, body %s", path, w.Code, w.Body.String())
+		}
+	}
+	savedRecipient, _ := repo.GetByID(recipient.ID)
+	savedGroup, _ := repo.GetGroupByID(group.ID)
+	if savedRecipient.Channels[0].WebhookURL != hook || savedGroup.Channels[0].WebhookURL != hook {
+		t.Errorf("masked update overwrote webhook URLs: %+v, %+v", savedRecipient.Channels, savedGroup.Channels)
+	}
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RoutingHandler handles routing rule endpoints
+type RoutingHandler struct {
+	repo *repository.SQLiteRepository
+}
+
+// NewRoutingHandler creates a new routing rule handler
+func NewRoutingHandler(repo *repository.SQLiteRepository) *RoutingHandler {
+	return &RoutingHandler{repo: repo}
+}
+
+// RoutingRuleRequest represents the request body for creating or updating a routing rule
+type RoutingRuleRequest struct {
+	Name         string           `json:"name" binding:"required"`
+	Position     int              `json:"position"`
+	Enabled      *bool            `json:"enabled"`
+	TemplateKeys []string         `json:"templateKeys"`
+	Tags         []string         `json:"tags"`
+	Priorities   []string         `json:"priorities"`
+	TimeStart    string           `json:"timeStart"`
+	TimeEnd      string           `json:"timeEnd"`
+	Channels     []models.Channel `json:"channels"`
+	SkipWeChat   bool             `json:"skipWeChat"`
+	Stop         bool             `json:"stop"`
+}
+
+// List returns all routing rules in evaluation order
+// GET /api/routing-rules
+func (h *RoutingHandler) List(c *gin.Context) {
+	rules, err := h.repo.GetAllRoutingRules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to get routing rules", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: rules})
+}
+
+// Create creates a new routing rule
+// POST /api/routing-rules
+func (h *RoutingHandler) Create(c *gin.Context) {
+	rule, ok := h.bindRule(c)
+	if !ok {
+		return
+	}
+	if err := h.repo.CreateRoutingRule(rule); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to create routing rule", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusCreated, models.ApiResponse{Success: true, Data: rule})
+}
+
+// Update replaces a routing rule
+// PUT /api/routing-rules/:id
+func (h *RoutingHandler) Update(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	rule, ok := h.bindRule(c)
+	if !ok {
+		return
+	}
+	rule.ID = id
+
+	if err := h.repo.UpdateRoutingRule(rule); err != nil {
+		h.writeError(c, err, "Failed to update routing rule")
+		return
+	}
+
+	updated, err := h.repo.GetRoutingRuleByID(id)
+	if err != nil {
+		h.writeError(c, err, "Failed to retrieve routing rule")
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: updated})
+}
+
+// Delete deletes a routing rule
+// DELETE /api/routing-rules/:id
+func (h *RoutingHandler) Delete(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	if err := h.repo.DeleteRoutingRule(id); err != nil {
+		h.writeError(c, err, "Failed to delete routing rule")
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true})
+}
+
+func (h *RoutingHandler) bindRule(c *gin.Context) (*models.RoutingRule, bool) {
+	var req RoutingRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request: name is required", Code: "INVALID_REQUEST",
+		})
+		return nil, false
+	}
+
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+	rule := &models.RoutingRule{
+		Name:         req.Name,
+		Position:     req.Position,
+		Enabled:      enabled,
+		TemplateKeys: req.TemplateKeys,
+		Tags:         req.Tags,
+		Priorities:   req.Priorities,
+		TimeStart:    req.TimeStart,
+		TimeEnd:      req.TimeEnd,
+		Channels:     req.Channels,
+		SkipWeChat:   req.SkipWeChat,
+		Stop:         req.Stop,
+	}
+	if err := services.ValidateRoutingRule(rule); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: err.Error(), Code: "VALIDATION_ERROR",
+		})
+		return nil, false
+	}
+	return rule, true
+}
+
+func (h *RoutingHandler) writeError(c *gin.Context, err error, message string) {
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, models.ApiResponse{
+			Success: false, Error: "Routing rule not found", Code: "NOT_FOUND",
+		})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, models.ApiResponse{
+		Success: false, Error: message, Code: "DATABASE_ERROR",
+	})
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ScheduledHandler handles endpoints for delayed webhook sends
+type ScheduledHandler struct {
+	repo *repository.SQLiteRepository
+}
+
+// NewScheduledHandler creates a new scheduled message handler
+func NewScheduledHandler(repo *repository.SQLiteRepository) *ScheduledHandler {
+	return &ScheduledHandler{repo: repo}
+}
+
+// List returns recent scheduled messages, newest first
+// GET /api/scheduled
+func (h *ScheduledHandler) List(c *gin.Context) {
+	messages, err := h.repo.ListScheduledMessages(200)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to get scheduled messages", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: messages})
+}
+
+// Cancel cancels a scheduled message that hasn't been sent yet
+// DELETE /api/scheduled/:id
+func (h *ScheduledHandler) Cancel(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	if err := h.repo.CancelScheduledMessage(id); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, models.ApiResponse{
+				Success: false, Error: "No pending scheduled message with this ID", Code: "NOT_FOUND",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to cancel scheduled message", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true})
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serverChanSuffix ends every Server酱 push path: /serverchan/<SendKey>.send
+const serverChanSuffix = ".send"
+
+// defaultServerChanFieldMap maps template fields to Server酱 parameters when no mapping is configured
+var defaultServerChanFieldMap = map[string]string{
+	"first":    "title",
+	"keyword1": "desp",
+}
+
+// Error codes returned in the Server酱 response body
+const (
+	serverChanCodeOK         = 0
+	serverChanCodeBadKey     = 40001
+	serverChanCodeBadRequest = 40002
+	serverChanCodeSendFailed = 50001
+)
+
+// ServerChanHandler accepts Server酱 (ServerChan) style pushes so existing integrations work unchanged
+type ServerChanHandler struct {
+	repo   *repository.SQLiteRepository
+	sender *services.Sender
+}
+
+// NewServerChanHandler creates a new ServerChan handler
+func NewServerChanHandler(repo *repository.SQLiteRepository, sender *services.Sender) *ServerChanHandler {
+	return &ServerChanHandler{repo: repo, sender: sender}
+}
+
+// serverChanRequest holds the parameters Server酱 clients send as query, form or JSON
+type serverChanRequest struct {
+	Title string `form:"title" json:"title"`
+	Text  string `form:"text" json:"text"` // Title parameter of the legacy sc.ftqq.com API
+	Desp  string `form:"desp" json:"desp"`
+	Short string `form:"short" json:"short"`
+}
+
+// serverChanResponse mirrors the Server酱 response body, which clients check for code 0
+type serverChanResponse struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// GetConfig returns the ServerChan endpoint configuration with the SendKey masked
+// GET /api/config/serverchan
+func (h *ServerChanHandler) GetConfig(c *gin.Context) {
+	cfg, err := h.loadConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve configuration", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	cfg.SendKey = services.MaskSecret(cfg.SendKey)
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: cfg})
+}
+
+// SaveConfig saves the ServerChan endpoint configuration, generating a SendKey on first enable. A
+// new SendKey is returned only in this response; the database keeps its hash.
+// PUT /api/config/serverchan
+func (h *ServerChanHandler) SaveConfig(c *gin.Context) {
+	var cfg models.ServerChanConfig
+	if err := c.ShouldBindJSON(&cfg); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+
+	if cfg.Enabled {
+		if _, err := h.repo.GetTemplateByKey(cfg.TemplateKey); err != nil {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "Template not found", Code: "TEMPLATE_NOT_FOUND",
+			})
+			return
+		}
+	}
+	// Keep the existing SendKey unless the caller supplied a new one; key holds a new one in plaintext
+	key := strings.TrimSpace(cfg.SendKey)
+	if key == "******" {
+		key = ""
+	}
+	if strings.ContainsAny(key, "/.") {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "SendKey cannot contain '/' or '.'", Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+	if key != "" && len(key) < services.MinCustomEndpointTokenLength {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: fmt.Sprintf("SendKey must be at least %d characters", services.MinCustomEndpointTokenLength), Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+	if key == "" {
+		old, err := h.loadConfig()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, models.ApiResponse{
+				Success: false, Error: "Failed to retrieve configuration", Code: "DATABASE_ERROR",
+			})
+			return
+		}
+		cfg.SendKey = old.SendKey
+		if cfg.SendKey == "" {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				c.JSON(http.StatusInternalServerError, models.ApiResponse{
+					Success: false, Error: "Failed to generate SendKey", Code: "INTERNAL_ERROR",
+				})
+				return
+			}
+			key = "SCT" + hex.EncodeToString(b)
+		}
+	}
+	if key != "" {
+		cfg.SendKey = services.HashServerChanKey(key)
+	}
+
+	if err := h.repo.SetJSONConfig(services.ServerChanConfigKey, cfg); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to save configuration", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+
+	// Only a new SendKey is shown, this once
+	if key == "" {
+		cfg.SendKey = services.MaskSecret(cfg.SendKey)
+	} else {
+		cfg.SendKey = key
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: cfg})
+}
+
+// Push sends a notification from a Server酱 style request, answering in Server酱's response format
+// GET|POST /serverchan/:sendkey (the path segment is <SendKey>.send)
+func (h *ServerChanHandler) Push(c *gin.Context) {
+	key, ok := strings.CutSuffix(c.Param("sendkey"), serverChanSuffix)
+	if !ok {
+		c.JSON(http.StatusNotFound, models.ApiResponse{
+			Success: false, Error: "Not found", Code: "NOT_FOUND",
+		})
+		return
+	}
+
+	cfg, err := h.loadConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, serverChanResponse{Code: serverChanCodeSendFailed, Message: "failed to retrieve configuration"})
+		return
+	}
+	if !cfg.Enabled || !services.CheckServerChanKey(cfg, key) {
+		c.JSON(http.StatusUnauthorized, serverChanResponse{Code: serverChanCodeBadKey, Message: "bad sendkey"})
+		return
+	}
+
+	var req serverChanRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, serverChanResponse{Code: serverChanCodeBadRequest, Message: "invalid request"})
+		return
+	}
+	if req.Title == "" {
+		req.Title = req.Text
+	}
+	if strings.TrimSpace(req.Title) == "" {
+		c.JSON(http.StatusBadRequest, serverChanResponse{Code: serverChanCodeBadRequest, Message: "title is required"})
+		return
+	}
+
+	response, status, err := deliverPush(sendContext(c), h.repo, h.sender, cfg.TemplateKey, cfg.RecipientIDs, serverChanKeywords(req, cfg.FieldMap), services.SendOptions{
+		Source: requestSource(c, models.SourceServerChan, services.ServerChanConfigKey),
+	})
+	if err != nil {
+		code := serverChanCodeSendFailed
+		if status == http.StatusBadRequest {
+			code = serverChanCodeBadRequest
+		}
+		c.JSON(status, serverChanResponse{Code: code, Message: err.Error()})
+		return
+	}
+	if response.TotalSent == 0 && response.TotalFailed > 0 {
+		c.JSON(http.StatusOK, serverChanResponse{Code: serverChanCodeSendFailed, Message: "all messages failed", Data: response})
+		return
+	}
+
+	pushID := strconv.FormatInt(response.MessageID, 10)
+	c.JSON(http.StatusOK, serverChanResponse{
+		Code: serverChanCodeOK,
+		Data: gin.H{"pushid": pushID, "readkey": "", "error": "SUCCESS", "errno": 0},
+	})
+}
+
+func (h *ServerChanHandler) loadConfig() (*models.ServerChanConfig, error) {
+	cfg := &models.ServerChanConfig{}
+	if err := h.repo.GetJSONConfig(services.ServerChanConfigKey, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// serverChanKeywords maps Server酱 parameters to template fields
+func serverChanKeywords(req serverChanRequest, fieldMap map[string]string) map[string]string {
+	if len(fieldMap) == 0 {
+		fieldMap = defaultServerChanFieldMap
+	}
+	desp := req.Desp
+	if desp == "" {
+		desp = req.Short
+	}
+	return mapKeywords(map[string]string{
+		"title": req.Title,
+		"desp":  desp,
+		"short": req.Short,
+	}, fieldMap)
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"wechat-notification/models"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServerChanKeywordsDefaultMapping(t *testing.T) {
+	keywords := serverChanKeywords(serverChanRequest{Title: "备份失败", Desp: "磁盘已满"}, nil)
+	if keywords["first"] != "备份失败" || keywords["keyword1"] != "磁盘已满" {
+		t.Errorf("unexpected keywords: %v", keywords)
+	}
+
+	keywords = serverChanKeywords(serverChanRequest{Title: "备份失败", Short: "磁盘"}, map[string]string{"remark": "desp"})
+	if len(keywords) != 1 || keywords["remark"] != "磁盘" {
+		t.Errorf("desp should fall back to short: %v", keywords)
+	}
+}
+
+func TestServerChanPush(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+	repo.CreateTemplate(&models.MessageTemplate{Key: "alert", TemplateID: "tpl", Name: "告警"})
+	repo.Create(&models.Recipient{OpenID: "o1", Name: "张三"})
+	repo.SetJSONConfig(services.ServerChanConfigKey, models.ServerChanConfig{Enabled: true, SendKey: services.HashServerChanKey("SCTkey"), TemplateKey: "alert"})
+
+	client := &canaryClient{}
+	tokens := services.NewTokenManagerWithClient("app", "secret", client)
+	handler := NewServerChanHandler(repo, services.NewSender(repo, services.NewWeChatServiceWithClient(tokens, "tpl", client), ""))
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/serverchan/:sendkey", handler.Push)
+
+	push := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	if w := push("/serverchan/SCTkey?title=hi"); w.Code != http.StatusNotFound {
+		t.Errorf("missing .send suffix: status %d", w.Code)
+	}
+	if w := push("/serverchan/SCTother.send?title=hi"); w.Code != http.StatusUnauthorized || client.posts != 0 {
+		t.Errorf("bad key: status %d, %d sends", w.Code, client.posts)
+	}
+	w := push("/serverchan/SCTkey.send?title=hi&desp=body")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"code":0`) || client.posts != 1 {
+		t.Errorf("valid push: status %d, body %s, %d sends", w.Code, w.Body.String(), client.posts)
+	}
+}
+
+func TestServerChanConfigSendKeyShownOnce(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+	repo.CreateTemplate(&models.MessageTemplate{Key: "alert", TemplateID: "tpl", Name: "告警"})
+
+	handler := NewServerChanHandler(repo, services.NewSender(repo, services.NewWeChatService(services.NewTokenManager("app", "secret"), "tpl"), ""))
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/config/serverchan", handler.GetConfig)
+	router.PUT("/api/config/serverchan", handler.SaveConfig)
+
+	call := func(method, body string) (int, models.ServerChanConfig) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/api/config/serverchan", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		var resp struct {
+			Data models.ServerChanConfig `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp.Data
+	}
+	stored := func() *models.ServerChanConfig {
+		var cfg models.ServerChanConfig
+		repo.GetJSONConfig(services.ServerChanConfigKey, &cfg)
+		return &cfg
+	}
+
+	code, generated := call(http.MethodPut, `{"enabled":true,"templateKey":"alert"}`)
+	if code != http.StatusOK || !strings.HasPrefix(generated.SendKey, "SCT") {
+		t.Fatalf("generated SendKey not returned: %d %q", code, generated.SendKey)
+	}
+	if cfg := stored(); cfg.SendKey == generated.SendKey || !services.CheckServerChanKey(cfg, generated.SendKey) {
+		t.Errorf("SendKey stored as %q", cfg.SendKey)
+	}
+
+	// Afterwards the SendKey stays masked, also when the masked value is saved back
+	if _, got := call(http.MethodGet, ""); got.SendKey != "******" {
+		t.Errorf("GetConfig SendKey = %q", got.SendKey)
+	}
+	if _, got := call(http.MethodPut, `{"enabled":true,"templateKey":"alert","sendKey":"******"}`); got.SendKey != "******" {
+		t.Errorf("resaved SendKey = %q", got.SendKey)
+	}
+	if !services.CheckServerChanKey(stored(), generated.SendKey) {
+		t.Error("saving the masked SendKey replaced it")
+	}
+
+	// Keys the admin chooses must be long enough
+	if code, _ := call(http.MethodPut, `{"enabled":true,"templateKey":"alert","sendKey":"SCTshort"}`); code != http.StatusBadRequest {
+		t.Errorf("short SendKey: status %d", code)
+	}
+	if code, got := call(http.MethodPut, `{"enabled":true,"templateKey":"alert","sendKey":"SCT0123456789abcdef"}`); code != http.StatusOK || got.SendKey != "SCT0123456789abcdef" {
+		t.Errorf("chosen SendKey: status %d, %q", code, got.SendKey)
+	}
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StatsHandler handles delivery statistics endpoints
+type StatsHandler struct {
+	repo     *repository.SQLiteRepository
+	exporter *services.StatsExporter
+}
+
+// NewStatsHandler creates a new stats handler
+func NewStatsHandler(repo *repository.SQLiteRepository, exporter *services.StatsExporter) *StatsHandler {
+	return &StatsHandler{repo: repo, exporter: exporter}
+}
+
+// Failures aggregates failed deliveries per day by errcode, template and webhook token over
+// the window query parameter (e.g. 7d, 30d, 12h; default 7d)
+// GET /api/stats/failures
+func (h *StatsHandler) Failures(c *gin.Context) {
+	window := c.DefaultQuery("window", "7d")
+	d, err := services.ParseStatsWindow(window)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: err.Error(), Code: "INVALID_WINDOW",
+		})
+		return
+	}
+
+	stats, err := services.CollectFailureStats(h.repo, window, d, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to collect statistics", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: stats})
+}
+
+// GetExportConfig returns the daily stats export targets
+// GET /api/config/stats-export
+func (h *StatsHandler) GetExportConfig(c *gin.Context) {
+	cfg, err := h.loadExportConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve configuration", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	cfg.RemoteWritePassword = services.MaskSecret(cfg.RemoteWritePassword)
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: cfg})
+}
+
+// SaveExportConfig sets the Prometheus remote-write endpoint and CSV directory for the daily stats export
+// PUT /api/config/stats-export
+func (h *StatsHandler) SaveExportConfig(c *gin.Context) {
+	var cfg models.StatsExportConfig
+	if err := c.ShouldBindJSON(&cfg); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+	if cfg.RemoteWriteURL != "" {
+		if u, err := url.Parse(cfg.RemoteWriteURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "remoteWriteUrl must be an http(s) URL", Code: "VALIDATION_ERROR",
+			})
+			return
+		}
+	}
+	if cfg.CSVDir != "" {
+		if info, err := os.Stat(cfg.CSVDir); err != nil || !info.IsDir() {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "csvDir must be an existing directory", Code: "VALIDATION_ERROR",
+			})
+			return
+		}
+	}
+
+	// Keep the stored password unless a new one was supplied
+	if cfg.RemoteWritePassword == "" || cfg.RemoteWritePassword == "******" {
+		old, err := h.loadExportConfig()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, models.ApiResponse{
+				Success: false, Error: "Failed to retrieve configuration", Code: "DATABASE_ERROR",
+			})
+			return
+		}
+		cfg.RemoteWritePassword = old.RemoteWritePassword
+	}
+
+	if err := h.repo.SetJSONConfig(services.StatsExportConfigKey, cfg); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to save configuration", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	cfg.RemoteWritePassword = services.MaskSecret(cfg.RemoteWritePassword)
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: cfg})
+}
+
+// Export exports one day's stats right away, e.g. to backfill a day the exporter missed
+// (date query parameter, YYYY-MM-DD; default yesterday)
+// POST /api/stats/export
+func (h *StatsHandler) Export(c *gin.Context) {
+	day := time.Now().AddDate(0, 0, -1)
+	if date := c.Query("date"); date != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", date, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "date must be YYYY-MM-DD", Code: "INVALID_DATE",
+			})
+			return
+		}
+		day = parsed
+	}
+
+	cfg, err := h.loadExportConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve configuration", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	if cfg.RemoteWriteURL == "" && cfg.CSVDir == "" {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "No export target configured", Code: "EXPORT_NOT_CONFIGURED",
+		})
+		return
+	}
+
+	stats, err := h.exporter.ExportDay(*cfg, day)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, models.ApiResponse{
+			Success: false, Error: err.Error(), Code: "EXPORT_FAILED",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: stats})
+}
+
+func (h *StatsHandler) loadExportConfig() (*models.StatsExportConfig, error) {
+	cfg := &models.StatsExportConfig{}
+	if err := h.repo.GetJSONConfig(services.StatsExportConfigKey, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -1,11 +1,15 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"wechat-notification/models"
 	"wechat-notification/repository"
+	"wechat-notification/services"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,11 +17,12 @@ import (
 // TemplateHandler handles template endpoints
 type TemplateHandler struct {
 	repo *repository.SQLiteRepository
+	apps *services.AppRegistry
 }
 
 // NewTemplateHandler creates a new template handler
-func NewTemplateHandler(repo *repository.SQLiteRepository) *TemplateHandler {
-	return &TemplateHandler{repo: repo}
+func NewTemplateHandler(repo *repository.SQLiteRepository, apps *services.AppRegistry) *TemplateHandler {
+	return &TemplateHandler{repo: repo, apps: apps}
 }
 
 // CreateTemplateRequest represents a request to create a template
@@ -25,6 +30,21 @@ type CreateTemplateRequest struct {
 	Key        string `json:"key" binding:"required"`
 	TemplateID string `json:"templateId" binding:"required"`
 	Name       string `json:"name" binding:"required"`
+	AppID      int64  `json:"appId"` // Official account the template belongs to, 0 for the default account
+	Mode       string `json:"mode"`  // template (default) or subscribe
+
+	DefaultGroupIDs []int64  `json:"defaultGroupIds"`
+	Fields          []string `json:"fields"` // Keywords sends must provide; empty accepts any, metadata sync overwrites it
+}
+
+// UpdateTemplateRequest represents a request to update a template; the key cannot be changed
+type UpdateTemplateRequest struct {
+	TemplateID      string   `json:"templateId"`
+	Name            string   `json:"name"`
+	AppID           *int64   `json:"appId"`           // Moves the template to another official account when present
+	Mode            string   `json:"mode"`            // Switches between template and subscribe messages when present
+	DefaultGroupIDs []int64  `json:"defaultGroupIds"` // Replaces the default groups when present
+	Fields          []string `json:"fields"`          // Replaces the keyword schema when present
 }
 
 // List returns all templates
@@ -52,12 +72,28 @@ func (h *TemplateHandler) Create(c *gin.Context) {
 	}
 
 	template := &models.MessageTemplate{
-		Key:        req.Key,
-		TemplateID: req.TemplateID,
-		Name:       req.Name,
+		Key:             req.Key,
+		TemplateID:      req.TemplateID,
+		Name:            req.Name,
+		AppID:           req.AppID,
+		Mode:            req.Mode,
+		DefaultGroupIDs: uniqueIDs(req.DefaultGroupIDs),
+		Fields:          normalizeFields(req.Fields),
+	}
+	if template.Mode == "" {
+		template.Mode = models.TemplateModeTemplate
+	}
+	if !h.checkMode(c, template.Mode) || !h.checkApp(c, template.AppID) || !h.checkGroups(c, template.DefaultGroupIDs) {
+		return
 	}
 
 	if err := h.repo.CreateTemplate(template); err != nil {
+		if errors.Is(err, repository.ErrDuplicateKey) {
+			c.JSON(http.StatusConflict, models.ApiResponse{
+				Success: false, Error: "A template with this key already exists", Code: "DUPLICATE_KEY",
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, models.ApiResponse{
 			Success: false, Error: "Failed to create template", Code: "DATABASE_ERROR",
 		})
@@ -67,6 +103,79 @@ func (h *TemplateHandler) Create(c *gin.Context) {
 	c.JSON(http.StatusCreated, models.ApiResponse{Success: true, Data: template})
 }
 
+// Update changes a template's WeChat template ID, name, official account, send mode, default groups or keyword schema
+// PUT /api/templates/:id
+func (h *TemplateHandler) Update(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+
+	template, err := h.repo.GetTemplateByID(id)
+	if err != nil {
+		h.writeError(c, err, "Failed to get template")
+		return
+	}
+	if v := strings.TrimSpace(req.TemplateID); v != "" {
+		template.TemplateID = v
+	}
+	if v := strings.TrimSpace(req.Name); v != "" {
+		template.Name = v
+	}
+	if req.Mode != "" {
+		template.Mode = req.Mode
+		if !h.checkMode(c, template.Mode) {
+			return
+		}
+	}
+	if req.AppID != nil {
+		template.AppID = *req.AppID
+		if !h.checkApp(c, template.AppID) {
+			return
+		}
+	}
+	if req.DefaultGroupIDs != nil {
+		template.DefaultGroupIDs = uniqueIDs(req.DefaultGroupIDs)
+		if !h.checkGroups(c, template.DefaultGroupIDs) {
+			return
+		}
+	}
+
+	if req.Fields != nil {
+		template.Fields = normalizeFields(req.Fields)
+	}
+
+	if err := h.repo.UpdateTemplate(template); err != nil {
+		h.writeError(c, err, "Failed to update template")
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: template})
+}
+
+// SetDefault marks the template as the one used by sends that don't name a template
+// POST /api/templates/:key/default
+func (h *TemplateHandler) SetDefault(c *gin.Context) {
+	template, err := h.repo.GetTemplateByKey(c.Param("key"))
+	if err != nil {
+		h.writeError(c, err, "Failed to get template")
+		return
+	}
+	if err := h.repo.SetDefaultTemplate(template.ID); err != nil {
+		h.writeError(c, err, "Failed to set default template")
+		return
+	}
+	template.IsDefault = true
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: template})
+}
+
 // Delete deletes a template
 // DELETE /api/templates/:id
 func (h *TemplateHandler) Delete(c *gin.Context) {
@@ -93,3 +202,113 @@ func (h *TemplateHandler) Delete(c *gin.Context) {
 
 	c.JSON(http.StatusOK, models.ApiResponse{Success: true})
 }
+
+// checkGroups verifies every default group exists
+func (h *TemplateHandler) checkGroups(c *gin.Context, groupIDs []int64) bool {
+	for _, id := range groupIDs {
+		if _, err := h.repo.GetGroupByID(id); err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				c.JSON(http.StatusBadRequest, models.ApiResponse{
+					Success: false, Error: "One or more groups not found", Code: "GROUP_NOT_FOUND",
+				})
+				return false
+			}
+			c.JSON(http.StatusInternalServerError, models.ApiResponse{
+				Success: false, Error: "Failed to get groups", Code: "DATABASE_ERROR",
+			})
+			return false
+		}
+	}
+	return true
+}
+
+// checkApp verifies the official account is configured
+// Import creates templates for the WeChat templates of an official account that aren't configured
+// yet and refreshes the existing ones, reporting which were added and which vanished upstream
+// POST /api/templates/sync?appId=
+func (h *TemplateHandler) Import(c *gin.Context) {
+	var appID int64
+	if raw := c.Query("appId"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "Invalid appId", Code: "INVALID_REQUEST",
+			})
+			return
+		}
+		appID = id
+	}
+	if !h.checkApp(c, appID) {
+		return
+	}
+
+	result, err := services.ImportTemplates(h.repo, h.apps, appID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadGateway, models.ApiResponse{
+			Success: false, Error: err.Error(), Code: "TEMPLATE_SYNC_FAILED",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: result})
+}
+
+// normalizeFields trims keyword names, dropping blanks and duplicates
+func normalizeFields(fields []string) []string {
+	result := []string{}
+	seen := map[string]bool{}
+	for _, f := range fields {
+		f = strings.TrimSpace(f)
+		if f != "" && !seen[f] {
+			seen[f] = true
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+func (h *TemplateHandler) checkMode(c *gin.Context, mode string) bool {
+	if mode != models.TemplateModeTemplate && mode != models.TemplateModeSubscribe {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "mode must be template or subscribe", Code: "VALIDATION_ERROR",
+		})
+		return false
+	}
+	return true
+}
+
+func (h *TemplateHandler) checkApp(c *gin.Context, appID int64) bool {
+	if _, err := h.apps.Service(appID); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "WeChat app not found", Code: "APP_NOT_FOUND",
+		})
+		return false
+	}
+	return true
+}
+
+func (h *TemplateHandler) writeError(c *gin.Context, err error, message string) {
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, models.ApiResponse{
+			Success: false, Error: "Template not found", Code: "NOT_FOUND",
+		})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, models.ApiResponse{
+		Success: false, Error: message, Code: "DATABASE_ERROR",
+	})
+}
+
+// SyncMetadata refreshes each template's title, industry and fields from the WeChat account,
+// reporting templates whose template ID was deleted in the MP console. Each template is checked
+// against the official account it belongs to.
+// POST /api/templates/metadata/sync
+func (h *TemplateHandler) SyncMetadata(c *gin.Context) {
+	result, err := services.SyncTemplateMetadata(h.repo, h.apps, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadGateway, models.ApiResponse{
+			Success: false, Error: err.Error(), Code: "TEMPLATE_SYNC_FAILED",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: result})
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VolumeHandler handles the send volume anomaly alerts
+type VolumeHandler struct {
+	repo    *repository.SQLiteRepository
+	monitor *services.VolumeMonitor
+}
+
+// NewVolumeHandler creates a new send volume handler
+func NewVolumeHandler(repo *repository.SQLiteRepository, monitor *services.VolumeMonitor) *VolumeHandler {
+	return &VolumeHandler{repo: repo, monitor: monitor}
+}
+
+// GetConfig returns the volume alert configuration with defaults filled in
+// GET /api/config/volume-alert
+func (h *VolumeHandler) GetConfig(c *gin.Context) {
+	cfg, ok := h.loadConfig(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: cfg})
+}
+
+// SaveConfig sets the volume thresholds and who is alerted
+// PUT /api/config/volume-alert
+func (h *VolumeHandler) SaveConfig(c *gin.Context) {
+	var cfg models.VolumeAlertConfig
+	if err := c.ShouldBindJSON(&cfg); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+	if cfg.WindowMinutes < 0 || cfg.BaselineWindows < 0 || cfg.Factor < 0 || cfg.MinMessages < 0 || cfg.CooldownMinutes < 0 {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Thresholds cannot be negative", Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+	if cfg.Factor > 0 && cfg.Factor <= 1 {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "factor must be greater than 1", Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+	if cfg.Enabled {
+		if len(cfg.GroupIDs) == 0 || len(cfg.Keywords) == 0 {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "At least one group and one keyword are required", Code: "VALIDATION_ERROR",
+			})
+			return
+		}
+		if _, err := h.repo.GetTemplateByKey(cfg.TemplateKey); err != nil {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "Template not found", Code: "TEMPLATE_NOT_FOUND",
+			})
+			return
+		}
+	}
+	cfg.GroupIDs = uniqueIDs(cfg.GroupIDs)
+	cfg = services.ResolveVolumeAlertConfig(cfg)
+
+	if err := h.repo.SetJSONConfig(services.VolumeAlertConfigKey, cfg); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to save configuration", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: cfg})
+}
+
+// Volume returns each token's send volume in the latest window against its baseline
+// GET /api/stats/volume
+func (h *VolumeHandler) Volume(c *gin.Context) {
+	cfg, ok := h.loadConfig(c)
+	if !ok {
+		return
+	}
+	volumes, err := h.monitor.Measure(cfg, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to count messages", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: volumes})
+}
+
+func (h *VolumeHandler) loadConfig(c *gin.Context) (models.VolumeAlertConfig, bool) {
+	var cfg models.VolumeAlertConfig
+	if err := h.repo.GetJSONConfig(services.VolumeAlertConfigKey, &cfg); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve configuration", Code: "DATABASE_ERROR",
+		})
+		return cfg, false
+	}
+	return services.ResolveVolumeAlertConfig(cfg), true
+}
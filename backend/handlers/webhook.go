@@ -1,93 +1,154 @@
 package handlers
 
 import (
-	"crypto/rand"
-	"encoding/hex"
+	"bytes"
+	"context"
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"wechat-notification/logger"
+	"wechat-notification/middleware"
 	"wechat-notification/models"
 	"wechat-notification/repository"
 	"wechat-notification/services"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// webhookFormatConfigKey stores the response format used when a request doesn't pick one
+const webhookFormatConfigKey = "webhook_response_format"
+
+// Webhook response formats, from smallest to largest
+const (
+	webhookFormatMinimal  = "minimal"  // {"ok":true}
+	webhookFormatStandard = "standard" // Totals and message ID, without per-recipient results
+	webhookFormatVerbose  = "verbose"  // Full send response with per-recipient and channel results
 )
 
 // WebhookHandler handles webhook endpoints
 type WebhookHandler struct {
-	repo      *repository.SQLiteRepository
-	wechatSvc *services.WeChatService
+	repo    *repository.SQLiteRepository
+	sender  *services.Sender
+	nonces  *services.NonceCache // Rejects replays of signed requests
+	limiter *middleware.RateLimiter
+	// Default rate limit from WEBHOOK_RATE_LIMIT and WEBHOOK_RATE_BURST
+	envLimit models.RateLimit
+	// Accept the token as ?token= when there's no Authorization header (WEBHOOK_QUERY_TOKEN)
+	queryToken bool
+	jobs       *services.SendJobs // Sends queued with ?async=true
 }
 
 // NewWebhookHandler creates a new webhook handler
-func NewWebhookHandler(repo *repository.SQLiteRepository, wechatSvc *services.WeChatService) *WebhookHandler {
-	return &WebhookHandler{repo: repo, wechatSvc: wechatSvc}
+func NewWebhookHandler(repo *repository.SQLiteRepository, sender *services.Sender, nonces *services.NonceCache) *WebhookHandler {
+	return &WebhookHandler{repo: repo, sender: sender, nonces: nonces, jobs: services.NewSendJobs(repo, sender)}
+}
+
+// ResumeJobs runs again the sends queued with ?async=true that a restart interrupted
+func (h *WebhookHandler) ResumeJobs() {
+	h.jobs.Resume()
+}
+
+// SetRateLimiter lets the admin API tune limiter, the rate limiter of the webhook routes, whose
+// default limit comes from envLimit unless changed there
+func (h *WebhookHandler) SetRateLimiter(limiter *middleware.RateLimiter, envLimit models.RateLimit) {
+	h.limiter = limiter
+	h.envLimit = envLimit
+}
+
+// AllowQueryToken sets whether callers that can't set headers may send the webhook token as the
+// token query parameter
+func (h *WebhookHandler) AllowQueryToken(allow bool) {
+	h.queryToken = allow
 }
 
 // WebhookSendRequest represents the webhook send request
 type WebhookSendRequest struct {
-	TemplateKey  string            `json:"templateKey" binding:"required"`
-	Keywords     map[string]string `json:"keywords" binding:"required"`
-	RecipientIDs []int64           `json:"recipientIds"` // Optional, if empty sends to all recipients
+	TemplateKey    string                `json:"templateKey"`    // Optional, defaults to the template marked as default
+	Keywords       map[string]string     `json:"keywords"`       // Optional for templates without variables
+	RecipientIDs   []int64               `json:"recipientIds"`   // Optional, if empty and no names, groups or tags are given sends to the template's default groups, or all recipients
+	RecipientNames []string              `json:"recipientNames"` // Optional, recipients by name, added to recipientIds
+	Groups         []string              `json:"groups"`         // Optional, names of groups whose members receive the message
+	RecipientTags  []string              `json:"recipientTags"`  // Optional, recipients by metadata, e.g. department=ops
+	RequireAck     bool                  `json:"requireAck"`     // Optional, attach a one-tap acknowledgement link
+	ReplyTo        int64                 `json:"replyTo"`        // Optional, messageId of an earlier send to thread onto
+	Channels       []models.Channel      `json:"channels"`       // Optional, extra channels (e.g. gotify, ntfy) for this message; private addresses are refused
+	Tags           []string              `json:"tags"`           // Optional, matched against routing rules
+	Priority       string                `json:"priority"`       // Optional, low / normal / high / urgent
+	DelaySeconds   int                   `json:"delaySeconds"`   // Optional, send this many seconds from now
+	SendAt         *time.Time            `json:"sendAt"`         // Optional, RFC 3339 time to send at; exclusive with delaySeconds
+	AppID          int64                 `json:"appId"`          // Optional, official account to send through instead of the template's
+	URL            string                `json:"url"`            // Optional, link opened by tapping the message; exclusive with requireAck
+	Miniprogram    *models.Miniprogram   `json:"miniprogram"`    // Optional, mini program opened by tapping the message; exclusive with requireAck
+	Mode           string                `json:"mode"`           // Optional, template (default) or text for a customer service text message; templateKey is then optional
+	MediaID        string                `json:"mediaId"`        // Optional, uploaded image sent after the text message; text mode only
+	Canary         *models.CanaryOptions `json:"canary"`         // Optional, send to a slice first and the rest only if it went through
 }
 
-// Send handles webhook message sending
+// Send handles webhook message sending. With ?async=true the send is queued once the request
+// checks out and answered with 202 and a job to poll at GET /api/webhook/jobs/:id.
 // POST /webhook/send
 func (h *WebhookHandler) Send(c *gin.Context) {
-	// Validate token
-	authHeader := c.GetHeader("Authorization")
-	if authHeader == "" {
-		c.JSON(http.StatusUnauthorized, models.ApiResponse{
-			Success: false, Error: "Missing authorization header", Code: "UNAUTHORIZED",
-		})
+	tokenName, ok := h.authenticate(c)
+	if !ok {
 		return
 	}
-
-	token := strings.TrimPrefix(authHeader, "Bearer ")
-	if token == authHeader {
-		c.JSON(http.StatusUnauthorized, models.ApiResponse{
-			Success: false, Error: "Invalid authorization format, use: Bearer <token>", Code: "UNAUTHORIZED",
+	async, err := strconv.ParseBool(c.DefaultQuery("async", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "async must be true or false", Code: "INVALID_REQUEST",
 		})
 		return
 	}
 
-	// Verify token
-	savedToken, _ := h.repo.GetConfig("webhook_token")
-	if savedToken == "" || token != savedToken {
-		c.JSON(http.StatusUnauthorized, models.ApiResponse{
-			Success: false, Error: "Invalid webhook token", Code: "UNAUTHORIZED",
+	// Check WeChat config; the AppSecret isn't needed when a token provider hands out access tokens
+	wechatConfig, _ := h.repo.GetWeChatConfig()
+	if wechatConfig == nil || wechatConfig.AppID == "" || (wechatConfig.AppSecret == "" && !h.sender.UsesTokenProvider()) {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "WeChat configuration not set. Please configure AppID and AppSecret first.", Code: "CONFIG_NOT_SET",
 		})
 		return
 	}
 
-	// Check WeChat config
-	wechatConfig, _ := h.repo.GetWeChatConfig()
-	if wechatConfig == nil || wechatConfig.AppID == "" || wechatConfig.AppSecret == "" || wechatConfig.TemplateID == "" {
-		c.JSON(http.StatusBadRequest, models.ApiResponse{
-			Success: false, Error: "WeChat configuration not set. Please configure AppID, AppSecret and TemplateID first.", Code: "CONFIG_NOT_SET",
-		})
+	format, ok := h.responseFormat(c)
+	if !ok {
 		return
 	}
 
 	// Parse request
 	var req WebhookSendRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	form, err := bindSendRequest(c, &req)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, models.ApiResponse{
-			Success: false, Error: "Invalid request: templateKey and keywords are required", Code: "INVALID_REQUEST",
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
 		})
 		return
 	}
+	if !checkSendMode(c, req.Mode, req.Miniprogram, req.MediaID) || !defaultTemplateKey(c, h.repo, &req.TemplateKey, req.Mode) {
+		return
+	}
+	if req.Keywords == nil {
+		req.Keywords = map[string]string{}
+	}
 
-	// Validate request
-	if strings.TrimSpace(req.TemplateKey) == "" || len(req.Keywords) == 0 {
+	sendAt, err := services.ResolveSendAt(req.DelaySeconds, req.SendAt, time.Now())
+	if err != nil {
+		code := "INVALID_DELAY"
+		if errors.Is(err, services.ErrDelayTooLong) {
+			code = "DELAY_TOO_LONG"
+		}
 		c.JSON(http.StatusBadRequest, models.ApiResponse{
-			Success: false, Error: "TemplateKey and keywords cannot be empty", Code: "VALIDATION_ERROR",
+			Success: false, Error: err.Error(), Code: code,
 		})
 		return
 	}
 
 	// Get template by key
-	template, err := h.repo.GetTemplateByKey(req.TemplateKey)
+	template, err := services.LoadTemplate(h.repo, req.TemplateKey, req.Mode)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.ApiResponse{
 			Success: false, Error: "Template not found", Code: "TEMPLATE_NOT_FOUND",
@@ -95,73 +156,513 @@ func (h *WebhookHandler) Send(c *gin.Context) {
 		return
 	}
 
-	// Get recipients
+	if form != nil {
+		form.fill(template, req.Keywords)
+	}
+	if !checkKeywords(c, template, req.Keywords, req.Mode) {
+		return
+	}
+
+	if !resolveTargets(c, h.repo, &req) {
+		return
+	}
+
+	// Get recipients: the template's default groups, or all recipients, when no IDs are given
+	useDefaults := services.UsesDefaultGroups(template, req.RecipientIDs)
 	var recipients []models.Recipient
+	if useDefaults {
+		recipients, err = h.repo.GetRecipientsByGroupIDs(template.DefaultGroupIDs)
+	} else {
+		recipients, err = services.LoadRecipients(h.repo, req.RecipientIDs)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to get recipients", Code: "DATABASE_ERROR",
+		})
+		return
+	}
 
-	if len(req.RecipientIDs) > 0 {
-		// Get specific recipients by IDs
-		recipients, err = h.repo.GetByIDs(req.RecipientIDs)
+	// Default groups may deliver through their channels alone
+	channelsOnly := false
+	if len(recipients) == 0 && useDefaults {
+		channelsOnly, err = groupsHaveChannels(h.repo, template.DefaultGroupIDs)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, models.ApiResponse{
-				Success: false, Error: "Failed to get recipients", Code: "DATABASE_ERROR",
+				Success: false, Error: "Failed to get groups", Code: "DATABASE_ERROR",
+			})
+			return
+		}
+	}
+	if len(recipients) == 0 && !channelsOnly {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "No recipients found", Code: "NO_RECIPIENTS",
+		})
+		return
+	}
+
+	if !validateChannels(c, req.Channels) || !normalizePriority(c, &req.Priority) || !checkMessageLinks(c, req.URL, req.Miniprogram, req.RequireAck) ||
+		!checkCanary(c, h.repo, req.Canary, req.RequireAck) {
+		return
+	}
+	// The rest of a canary send is already scheduled, and default groups also deliver through their
+	// own channels, which a split can't carry over
+	if req.Canary != nil && (!sendAt.IsZero() || useDefaults) {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "canary can't be combined with a delay or the template's default groups", Code: "INVALID_CANARY",
+		})
+		return
+	}
+
+	if !sendAt.IsZero() {
+		h.schedule(c, req, sendAt, format, tokenName)
+		return
+	}
+
+	// Send messages using shared logic
+
+	opts := services.SendOptions{
+		RequireAck:  req.RequireAck,
+		ReplyTo:     req.ReplyTo,
+		Channels:    req.Channels,
+		Tags:        req.Tags,
+		Priority:    req.Priority,
+		Source:      requestSource(c, models.SourceWebhook, tokenName),
+		AppID:       req.AppID,
+		URL:         req.URL,
+		Miniprogram: req.Miniprogram,
+		Mode:        req.Mode,
+		MediaID:     req.MediaID,
+	}
+	send := func(ctx context.Context) (services.SendResponse, error) {
+		switch {
+		case req.Canary != nil:
+			return h.sender.SendCanary(ctx, template, recipients, req.Keywords, opts, req.Canary)
+		case useDefaults:
+			return h.sender.SendToGroups(ctx, template, template.DefaultGroupIDs, req.Keywords, opts)
+		default:
+			return h.sender.Send(ctx, template, recipients, req.Keywords, opts)
+		}
+	}
+	if async {
+		queued := services.QueuedSend{
+			TemplateKey: req.TemplateKey, Keywords: req.Keywords, DefaultGroups: useDefaults, Canary: req.Canary, Options: opts,
+		}
+		if !useDefaults {
+			queued.RecipientIDs = make([]int64, len(recipients))
+			for i, r := range recipients {
+				queued.RecipientIDs[i] = r.ID
+			}
+		}
+		job, err := h.jobs.Start(tokenName, queued)
+		if errors.Is(err, services.ErrSendQueueFull) {
+			c.Header("Retry-After", "5")
+			c.JSON(http.StatusServiceUnavailable, models.ApiResponse{
+				Success: false, Error: err.Error(), Code: "QUEUE_FULL",
 			})
 			return
 		}
-	} else {
-		// Get all recipients
-		recipients, err = h.repo.GetAll()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, models.ApiResponse{
-				Success: false, Error: "Failed to get recipients", Code: "DATABASE_ERROR",
+				Success: false, Error: "Failed to queue send", Code: "DATABASE_ERROR",
 			})
 			return
 		}
+		if format == webhookFormatMinimal {
+			c.JSON(http.StatusAccepted, gin.H{"ok": true, "jobId": job.ID})
+			return
+		}
+		c.JSON(http.StatusAccepted, models.ApiResponse{Success: true, Data: job})
+		return
+	}
+	response, err := send(sendContext(c))
+	if err != nil {
+		writeSendError(c, err)
+		return
 	}
 
-	if len(recipients) == 0 {
-		c.JSON(http.StatusBadRequest, models.ApiResponse{
-			Success: false, Error: "No recipients found", Code: "NO_RECIPIENTS",
+	switch format {
+	case webhookFormatMinimal:
+		c.JSON(http.StatusOK, gin.H{"ok": response.TotalFailed == 0})
+	case webhookFormatStandard:
+		data := gin.H{
+			"messageId":      response.MessageID,
+			"totalCount":     response.TotalCount,
+			"totalSent":      response.TotalSent,
+			"totalFailed":    response.TotalFailed,
+			"totalSkipped":   response.TotalSkipped,
+			"totalThrottled": response.TotalThrottled,
+		}
+		if response.Canary != nil {
+			data["canary"] = response.Canary
+		}
+		c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: data})
+	default:
+		c.JSON(http.StatusOK, models.ApiResponse{
+			Success: true,
+			Data:    response,
+		})
+	}
+}
+
+// GetJob returns the state of a send queued with ?async=true, and its results once it's done.
+// Jobs are only visible to the token that queued them. They're stored, so a send interrupted by a
+// restart is resumed and can still be looked up.
+// GET /api/webhook/jobs/:id
+func (h *WebhookHandler) GetJob(c *gin.Context) {
+	tokenName, ok := h.authenticate(c)
+	if !ok {
+		return
+	}
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	job, ok := h.jobs.Get(tokenName, id)
+	if !ok {
+		c.JSON(http.StatusNotFound, models.ApiResponse{
+			Success: false, Error: "Job not found", Code: "NOT_FOUND",
 		})
 		return
 	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: job})
+}
 
-	// Send messages using shared logic
-	response := SendMessages(h.wechatSvc, recipients, template.TemplateID, req.Keywords)
+// groupsHaveChannels reports whether any of the groups delivers through channels of its own.
+// Groups that no longer exist have none.
+func groupsHaveChannels(repo *repository.SQLiteRepository, ids []int64) (bool, error) {
+	for _, id := range ids {
+		group, err := repo.GetGroupByID(id)
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if len(group.Channels) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
 
-	c.JSON(http.StatusOK, models.ApiResponse{
-		Success: true,
-		Data:    response,
+// resolveTargets turns the recipient names, groups and tags of a send into recipient IDs,
+// answering 400 when some are unknown or they select nobody
+func resolveTargets(c *gin.Context, repo *repository.SQLiteRepository, req *WebhookSendRequest) bool {
+	ids, err := services.ResolveRecipientTargets(repo, services.RecipientTargets{
+		IDs: req.RecipientIDs, Names: req.RecipientNames, Groups: req.Groups, Tags: req.RecipientTags,
 	})
+	var unknown *services.UnknownTargetsError
+	switch {
+	case errors.As(err, &unknown):
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Data: unknown, Error: err.Error(), Code: "UNKNOWN_RECIPIENTS",
+		})
+		return false
+	case errors.Is(err, services.ErrNoTargetedRecipients):
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: err.Error(), Code: "NO_RECIPIENTS",
+		})
+		return false
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to get recipients", Code: "DATABASE_ERROR",
+		})
+		return false
+	}
+	req.RecipientIDs = ids
+	return true
+}
+
+// webhookForm holds the title and content of a form-encoded send
+type webhookForm struct {
+	title, content string
+}
+
+// bindSendRequest reads a JSON send request, or a form-encoded one for shell scripts and legacy
+// systems: templateKey, title, content, recipients (comma-separated IDs), recipientNames, groups and
+// recipientTags (comma-separated), keywords[<field>], url, priority, tags (comma-separated) and mode. The title and content are returned to fill the
+// template's fields once it's loaded.
+func bindSendRequest(c *gin.Context, req *WebhookSendRequest) (*webhookForm, error) {
+	if c.ContentType() != binding.MIMEPOSTForm {
+		return nil, c.ShouldBindJSON(req)
+	}
+	if err := c.Request.ParseForm(); err != nil {
+		return nil, err
+	}
+	values := c.Request.PostForm
+	req.TemplateKey = strings.TrimSpace(values.Get("templateKey"))
+	req.URL = strings.TrimSpace(values.Get("url"))
+	req.Priority = strings.TrimSpace(values.Get("priority"))
+	req.Mode = strings.TrimSpace(values.Get("mode"))
+	req.Tags = splitList(values.Get("tags"))
+	req.RecipientNames = splitList(values.Get("recipientNames"))
+	req.Groups = splitList(values.Get("groups"))
+	req.RecipientTags = splitList(values.Get("recipientTags"))
+	for _, id := range splitList(values.Get("recipients")) {
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		req.RecipientIDs = append(req.RecipientIDs, n)
+	}
+	req.Keywords = map[string]string{}
+	for key, v := range values {
+		if field, ok := strings.CutPrefix(key, "keywords["); ok && strings.HasSuffix(field, "]") && len(v) > 0 {
+			req.Keywords[strings.TrimSuffix(field, "]")] = v[0]
+		}
+	}
+	return &webhookForm{title: values.Get("title"), content: values.Get("content")}, nil
+}
+
+// fill puts the title and content in the template's first two fields, or in first and keyword1
+// when the template's fields aren't known, unless keywords already set those fields
+func (f *webhookForm) fill(template *models.MessageTemplate, keywords map[string]string) {
+	fields := []string{"first", "keyword1"}
+	if len(template.Fields) > 0 {
+		fields = template.Fields
+	}
+	for i, value := range []string{f.title, f.content} {
+		if i >= len(fields) || value == "" {
+			continue
+		}
+		if _, ok := keywords[fields[i]]; !ok {
+			keywords[fields[i]] = value
+		}
+	}
+}
+
+// splitList splits a comma-separated form value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// authenticate checks the caller of the webhook, returning the name sends are recorded under.
+// Requests with an X-Signature header must be signed with the signing secret and carry a recent
+// X-Timestamp and an unused X-Nonce; others need the webhook token as a Bearer token, or as the
+// token query parameter when that's allowed and there's no Authorization header.
+func (h *WebhookHandler) authenticate(c *gin.Context) (string, bool) {
+	if signature := c.GetHeader(services.WebhookSignatureHeader); signature != "" {
+		body, err := bufferBody(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "Failed to read request body", Code: "INVALID_REQUEST",
+			})
+			return "", false
+		}
+
+		timestamp, nonce := c.GetHeader(services.WebhookTimestampHeader), c.GetHeader(services.WebhookNonceHeader)
+		secret, _ := h.repo.GetConfig(services.WebhookSigningSecretConfigKey)
+		if !services.VerifyWebhookSignature(secret, signature, timestamp, nonce, body) {
+			c.JSON(http.StatusUnauthorized, models.ApiResponse{
+				Success: false, Error: "Invalid request signature", Code: "UNAUTHORIZED",
+			})
+			return "", false
+		}
+		// Checked after the signature so unsigned requests can't fill the nonce cache
+		if err := h.nonces.Check(timestamp, nonce, time.Now()); err != nil {
+			c.JSON(http.StatusUnauthorized, models.ApiResponse{
+				Success: false, Error: err.Error(), Code: "REPLAYED_REQUEST",
+			})
+			return "", false
+		}
+		return services.WebhookSigningSecretConfigKey, true
+	}
+
+	authHeader := c.GetHeader("Authorization")
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	switch {
+	case authHeader == "" && h.queryToken && c.Query("token") != "":
+		token = c.Query("token")
+	case authHeader == "":
+		c.JSON(http.StatusUnauthorized, models.ApiResponse{
+			Success: false, Error: "Missing authorization header", Code: "UNAUTHORIZED",
+		})
+		return "", false
+	case token == authHeader:
+		c.JSON(http.StatusUnauthorized, models.ApiResponse{
+			Success: false, Error: "Invalid authorization format, use: Bearer <token>", Code: "UNAUTHORIZED",
+		})
+		return "", false
+	}
+
+	// The token replaced by the last rotation works until its grace period ends
+	tokenName := services.CheckWebhookToken(h.repo, token, time.Now())
+	if tokenName == "" {
+		c.JSON(http.StatusUnauthorized, models.ApiResponse{
+			Success: false, Error: "Invalid webhook token", Code: "UNAUTHORIZED",
+		})
+		return "", false
+	}
+	return tokenName, true
+}
+
+// RateLimitKey limits requests by the webhook credential they carry rather than the client IP,
+// using the rate limit set for that credential. Requests without a valid credential are limited
+// by IP and then rejected by authenticate.
+func (h *WebhookHandler) RateLimitKey(c *gin.Context) (string, models.RateLimit, bool) {
+	tokenName := ""
+	if signature := c.GetHeader(services.WebhookSignatureHeader); signature != "" {
+		body, err := bufferBody(c)
+		if err != nil {
+			return "", models.RateLimit{}, false
+		}
+		secret, _ := h.repo.GetConfig(services.WebhookSigningSecretConfigKey)
+		timestamp, nonce := c.GetHeader(services.WebhookTimestampHeader), c.GetHeader(services.WebhookNonceHeader)
+		if services.VerifyWebhookSignature(secret, signature, timestamp, nonce, body) {
+			tokenName = services.WebhookSigningSecretConfigKey
+		}
+	} else if token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "); token != c.GetHeader("Authorization") {
+		tokenName = services.CheckWebhookToken(h.repo, token, time.Now())
+	} else if c.GetHeader("Authorization") == "" && h.queryToken && c.Query("token") != "" {
+		tokenName = services.CheckWebhookToken(h.repo, c.Query("token"), time.Now())
+	}
+	if tokenName == "" {
+		return "", models.RateLimit{}, false
+	}
+	limit, _ := services.WebhookRateLimit(h.repo, tokenName)
+	return "token:" + tokenName, limit, true
+}
+
+// bufferBody reads the request body, up to 1 MB, and puts it back for the handler to bind
+func bufferBody(c *gin.Context) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// requestSource describes the inbound request for the message history
+func requestSource(c *gin.Context, adapter, tokenName string) models.MessageSource {
+	return models.MessageSource{
+		Adapter:   adapter,
+		TokenName: tokenName,
+		SourceIP:  c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
+
+// responseFormat picks the response format from the format query parameter or the saved setting,
+// defaulting to verbose; an unknown format is answered with 400
+func (h *WebhookHandler) responseFormat(c *gin.Context) (string, bool) {
+	format := c.Query("format")
+	if format == "" {
+		format, _ = h.repo.GetConfig(webhookFormatConfigKey)
+	}
+	if format == "" {
+		return webhookFormatVerbose, true
+	}
+	if !validWebhookFormat(format) {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "format must be minimal, standard or verbose", Code: "INVALID_FORMAT",
+		})
+		return "", false
+	}
+	return format, true
+}
+
+func validWebhookFormat(format string) bool {
+	return format == webhookFormatMinimal || format == webhookFormatStandard || format == webhookFormatVerbose
+}
+
+// schedule queues a delayed send; recipients are resolved again when it goes out
+func (h *WebhookHandler) schedule(c *gin.Context, req WebhookSendRequest, sendAt time.Time, format, tokenName string) {
+	if req.ReplyTo != 0 {
+		if _, err := h.repo.GetMessageByID(req.ReplyTo); err != nil {
+			writeSendError(c, services.ErrReplyToNotFound)
+			return
+		}
+	}
+
+	scheduled := &models.ScheduledMessage{
+		TemplateKey:  req.TemplateKey,
+		Keywords:     req.Keywords,
+		RecipientIDs: req.RecipientIDs,
+		RequireAck:   req.RequireAck,
+		ReplyTo:      req.ReplyTo,
+		Channels:     req.Channels,
+		Tags:         req.Tags,
+		Priority:     req.Priority,
+		AppID:        req.AppID,
+		URL:          req.URL,
+		Miniprogram:  req.Miniprogram,
+		Mode:         req.Mode,
+		MediaID:      req.MediaID,
+		SendAt:       sendAt,
+		Source:       requestSource(c, models.SourceWebhook, tokenName),
+	}
+	if err := h.repo.CreateScheduledMessage(scheduled); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to schedule message", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+
+	if format == webhookFormatMinimal {
+		c.JSON(http.StatusAccepted, gin.H{"ok": true})
+		return
+	}
+	c.JSON(http.StatusAccepted, models.ApiResponse{Success: true, Data: scheduled})
 }
 
-// GetToken returns the current webhook token (masked)
+// GetToken reports whether a webhook token is set and when the rotated-out one stops working.
+// Only hashes of the tokens are stored, so the token itself is shown once, when it's generated.
 // GET /api/webhook/token
 func (h *WebhookHandler) GetToken(c *gin.Context) {
-	token, _ := h.repo.GetConfig("webhook_token")
-	c.JSON(http.StatusOK, models.ApiResponse{
-		Success: true,
-		Data: map[string]interface{}{
-			"hasToken": token != "",
-			"token":    token, // Show full token for copying
-		},
-	})
+	format, _ := h.repo.GetConfig(webhookFormatConfigKey)
+	if format == "" {
+		format = webhookFormatVerbose
+	}
+	secret, _ := h.repo.GetConfig(services.WebhookSigningSecretConfigKey)
+	data := map[string]interface{}{
+		"hasToken":         services.HasWebhookToken(h.repo),
+		"hasSigningSecret": secret != "",
+		"responseFormat":   format,
+	}
+	if previous, _ := services.PreviousWebhookToken(h.repo, time.Now()); previous != nil {
+		data["previousTokenExpiresAt"] = previous.ExpiresAt
+	}
+	if limits, err := services.WebhookRateLimits(h.repo); err == nil {
+		data["rateLimits"] = limits
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: data})
 }
 
-// GenerateToken generates a new webhook token
-// POST /api/webhook/token
-func (h *WebhookHandler) GenerateToken(c *gin.Context) {
-	// Generate random token
-	bytes := make([]byte, 32)
-	if _, err := rand.Read(bytes); err != nil {
+// SetResponseFormat sets the response format used when a webhook request doesn't pass ?format=
+// PUT /api/webhook/response-format
+func (h *WebhookHandler) SetResponseFormat(c *gin.Context) {
+	var req struct {
+		Format string `json:"format" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || !validWebhookFormat(req.Format) {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "format must be minimal, standard or verbose", Code: "INVALID_FORMAT",
+		})
+		return
+	}
+	if err := h.repo.SetConfig(webhookFormatConfigKey, req.Format); err != nil {
 		c.JSON(http.StatusInternalServerError, models.ApiResponse{
-			Success: false, Error: "Failed to generate token", Code: "INTERNAL_ERROR",
+			Success: false, Error: "Failed to save configuration", Code: "DATABASE_ERROR",
 		})
 		return
 	}
-	token := hex.EncodeToString(bytes)
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: map[string]string{"responseFormat": req.Format}})
+}
 
-	// Save token
-	if err := h.repo.SetConfig("webhook_token", token); err != nil {
+// GenerateToken generates a new webhook token. The old token, and one still in the grace period
+// of a rotation, stop working right away; use RotateToken to replace a token without downtime.
+// The token is only shown in this response.
+// POST /api/webhook/token
+func (h *WebhookHandler) GenerateToken(c *gin.Context) {
+	token, err := services.GenerateWebhookToken(h.repo)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.ApiResponse{
 			Success: false, Error: "Failed to save token", Code: "DATABASE_ERROR",
 		})
@@ -173,3 +674,170 @@ func (h *WebhookHandler) GenerateToken(c *gin.Context) {
 		Data:    map[string]string{"token": token},
 	})
 }
+
+// RotateToken issues a new webhook token while the current one keeps working for graceSeconds
+// (default 1 day), so integrations can be moved over one by one. Sends made with the old token
+// are recorded under the token name webhook_token_previous.
+// POST /api/webhook/token/rotate
+func (h *WebhookHandler) RotateToken(c *gin.Context) {
+	var req struct {
+		GraceSeconds int `json:"graceSeconds"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+			})
+			return
+		}
+	}
+	grace := services.DefaultTokenGrace
+	if req.GraceSeconds != 0 {
+		grace = time.Duration(req.GraceSeconds) * time.Second
+	}
+	if grace <= 0 || grace > services.MaxTokenGrace {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "graceSeconds must be between 1 and 2592000", Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+
+	token, previous, err := services.RotateWebhookToken(h.repo, grace, time.Now())
+	if errors.Is(err, services.ErrNoWebhookToken) {
+		c.JSON(http.StatusConflict, models.ApiResponse{
+			Success: false, Error: "Generate a webhook token first", Code: "NO_TOKEN",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to save token", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Data:    gin.H{"token": token, "previousTokenExpiresAt": previous.ExpiresAt},
+	})
+}
+
+// SetRateLimit sets how many requests per second, and how many at once, a webhook credential can
+// send: token webhook_token (shared with the token in its grace period) or webhook_signing_secret.
+// Rate and burst 0 go back to the server default.
+// PUT /api/webhook/rate-limit
+func (h *WebhookHandler) SetRateLimit(c *gin.Context) {
+	var req struct {
+		Token string `json:"token" binding:"required"`
+		models.RateLimit
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+	if req.Token != services.WebhookTokenConfigKey && req.Token != services.WebhookSigningSecretConfigKey {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "token must be webhook_token or webhook_signing_secret", Code: "VALIDATION_ERROR",
+		})
+		return
+	}
+	if !validRateLimit(c, req.RateLimit) {
+		return
+	}
+	if err := services.SetWebhookRateLimit(h.repo, req.Token, req.RateLimit); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to save configuration", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: req.RateLimit})
+}
+
+// GetRateLimits returns the default webhook rate limit, the one set by the environment and the
+// limits of individual tokens
+// GET /api/webhook/rate-limit
+func (h *WebhookHandler) GetRateLimits(c *gin.Context) {
+	limits, err := services.WebhookRateLimits(h.repo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to retrieve configuration", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: gin.H{
+		"default":    h.limiter.DefaultLimit(),
+		"envDefault": h.envLimit,
+		"tokens":     limits,
+	}})
+}
+
+// SetDefaultRateLimit changes the rate limit of webhook callers without a limit of their own,
+// callers identified by IP included, without restarting. Rate and burst 0 go back to
+// WEBHOOK_RATE_LIMIT and WEBHOOK_RATE_BURST.
+// PUT /api/webhook/rate-limit/default
+func (h *WebhookHandler) SetDefaultRateLimit(c *gin.Context) {
+	var req models.RateLimit
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "Invalid request format", Code: "INVALID_REQUEST",
+		})
+		return
+	}
+	if !validRateLimit(c, req) {
+		return
+	}
+	if err := h.repo.SetJSONConfig(services.WebhookDefaultRateLimitConfigKey, req); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to save configuration", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	limit := req
+	if limit == (models.RateLimit{}) {
+		limit = h.envLimit
+	}
+	h.limiter.SetDefaultLimit(limit)
+	logger.Infof("webhook: default rate limit set to %d/s, burst %d", limit.Rate, limit.Burst)
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: limit})
+}
+
+// validRateLimit rejects limits that would refuse every request or allow huge bursts. The zero
+// limit, which restores the default, is valid.
+func validRateLimit(c *gin.Context, limit models.RateLimit) bool {
+	if limit != (models.RateLimit{}) && (limit.Rate < 1 || limit.Burst < limit.Rate || limit.Burst > 1000) {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false, Error: "rate must be at least 1 and burst between rate and 1000", Code: "VALIDATION_ERROR",
+		})
+		return false
+	}
+	return true
+}
+
+// GenerateSigningSecret generates the shared secret for signed webhook requests. Instead of the
+// token, callers send X-Timestamp (Unix seconds), a fresh X-Nonce and "X-Signature: sha256=<hex>",
+// the HMAC-SHA256 of "<timestamp>.<nonce>.<body>", so no secret travels with the request. The
+// secret is only shown here; generating it again replaces it.
+// POST /api/webhook/signing-secret
+func (h *WebhookHandler) GenerateSigningSecret(c *gin.Context) {
+	secret, err := services.GenerateWebhookSigningSecret(h.repo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to save signing secret", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true, Data: map[string]string{"secret": secret}})
+}
+
+// DeleteSigningSecret turns signed webhook requests off
+// DELETE /api/webhook/signing-secret
+func (h *WebhookHandler) DeleteSigningSecret(c *gin.Context) {
+	if err := h.repo.SetConfig(services.WebhookSigningSecretConfigKey, ""); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false, Error: "Failed to save configuration", Code: "DATABASE_ERROR",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ApiResponse{Success: true})
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"wechat-notification/models"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBindSendRequestForm(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/webhook/send",
+		strings.NewReader("templateKey=alert&title=备份完成&content=用时+3+分钟&recipients=1,+2&tags=ops,&keywords%5Bremark%5D=r"))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var req WebhookSendRequest
+	form, err := bindSendRequest(c, &req)
+	if err != nil {
+		t.Fatalf("bindSendRequest error: %v", err)
+	}
+	if req.TemplateKey != "alert" || len(req.RecipientIDs) != 2 || req.RecipientIDs[1] != 2 || len(req.Tags) != 1 {
+		t.Errorf("unexpected request: %+v", req)
+	}
+
+	form.fill(&models.MessageTemplate{}, req.Keywords)
+	if req.Keywords["first"] != "备份完成" || req.Keywords["keyword1"] != "用时 3 分钟" || req.Keywords["remark"] != "r" {
+		t.Errorf("unexpected keywords: %v", req.Keywords)
+	}
+
+	// Templates with a known field list get the title and content in their first two fields
+	keywords := map[string]string{"thing1": "set"}
+	form.fill(&models.MessageTemplate{Fields: []string{"thing1", "time2", "thing3"}}, keywords)
+	if keywords["thing1"] != "set" || keywords["time2"] != "用时 3 分钟" || len(keywords) != 2 {
+		t.Errorf("unexpected keywords for a template with fields: %v", keywords)
+	}
+}
+
+func TestAuthenticateQueryToken(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+	token, err := services.GenerateWebhookToken(repo)
+	if err != nil {
+		t.Fatalf("GenerateWebhookToken error: %v", err)
+	}
+	h := NewWebhookHandler(repo, nil, nil)
+
+	authenticate := func(target string) bool {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, target, nil)
+		_, ok := h.authenticate(c)
+		return ok
+	}
+	if authenticate("/api/webhook/send?token=" + token) {
+		t.Error("the query token must be refused unless allowed")
+	}
+	h.AllowQueryToken(true)
+	if !authenticate("/api/webhook/send?token=" + token) {
+		t.Error("the query token should be accepted once allowed")
+	}
+	if authenticate("/api/webhook/send?token=wrong") {
+		t.Error("a wrong query token must be refused")
+	}
+}
+
+func TestSendDefaultGroupsWithoutAudience(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+	token, _ := services.GenerateWebhookToken(repo)
+	repo.SaveWeChatConfig(&models.WeChatConfig{AppID: "app", AppSecret: "secret", TemplateID: "tpl"})
+	empty := &models.Group{Name: "空分组"}
+	repo.CreateGroup(empty)
+	repo.CreateTemplate(&models.MessageTemplate{Key: "alert", TemplateID: "tpl", Name: "告警", DefaultGroupIDs: []int64{empty.ID}})
+
+	client := &canaryClient{}
+	tokens := services.NewTokenManagerWithClient("app", "secret", client)
+	h := NewWebhookHandler(repo, services.NewSender(repo, services.NewWeChatServiceWithClient(tokens, "tpl", client), ""), nil)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/webhook/send", h.Send)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/webhook/send", bytes.NewReader([]byte(`{"templateKey":"alert","keywords":{"first":"hi"}}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "NO_RECIPIENTS") || client.posts != 0 {
+		t.Errorf("empty default groups: status %d, body %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSendResponseFormat(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+	token, _ := services.GenerateWebhookToken(repo)
+	repo.SaveWeChatConfig(&models.WeChatConfig{AppID: "app", AppSecret: "secret", TemplateID: "tpl"})
+	recipient := &models.Recipient{OpenID: "o-1", Name: "张三"}
+	repo.Create(recipient)
+	repo.CreateTemplate(&models.MessageTemplate{Key: "alert", TemplateID: "tpl", Name: "告警"})
+
+	client := &canaryClient{}
+	tokens := services.NewTokenManagerWithClient("app", "secret", client)
+	h := NewWebhookHandler(repo, services.NewSender(repo, services.NewWeChatServiceWithClient(tokens, "tpl", client), ""), nil)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/webhook/send", h.Send)
+	router.PUT("/api/webhook/response-format", h.SetResponseFormat)
+
+	send := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/webhook/send"+query, strings.NewReader(`{"templateKey":"alert","keywords":{"first":"hi"}}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	setFormat := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api/webhook/response-format", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send(""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"results":[`) {
+		t.Errorf("default verbose: status %d, body %s", w.Code, w.Body.String())
+	}
+	if w := send("?format=standard"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"totalSent":1`) || strings.Contains(w.Body.String(), `"results"`) {
+		t.Errorf("standard: status %d, body %s", w.Code, w.Body.String())
+	}
+	if w := send("?format=minimal"); w.Code != http.StatusOK || w.Body.String() != `{"ok":true}` {
+		t.Errorf("minimal: status %d, body %s", w.Code, w.Body.String())
+	}
+	if w := send("?format=full"); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "INVALID_FORMAT") {
+		t.Errorf("unknown query format: status %d, body %s", w.Code, w.Body.String())
+	}
+
+	if w := setFormat(`{"format":"full"}`); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "INVALID_FORMAT") {
+		t.Errorf("unknown saved format: status %d, body %s", w.Code, w.Body.String())
+	}
+	if w := setFormat(`{"format":"minimal"}`); w.Code != http.StatusOK {
+		t.Fatalf("SetResponseFormat: status %d, body %s", w.Code, w.Body.String())
+	}
+	if format, _ := repo.GetConfig(webhookFormatConfigKey); format != webhookFormatMinimal {
+		t.Errorf("saved format = %q, want minimal", format)
+	}
+	if w := send(""); w.Body.String() != `{"ok":true}` {
+		t.Errorf("saved minimal default: body %s", w.Body.String())
+	}
+	if w := send("?format=verbose"); !strings.Contains(w.Body.String(), `"results":[`) {
+		t.Errorf("query should override the saved default: body %s", w.Body.String())
+	}
+}
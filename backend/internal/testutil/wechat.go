@@ -0,0 +1,135 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+
+	"wechat-notification/models"
+)
+
+// wechatAPIHost is the host the server sends WeChat API calls to
+const wechatAPIHost = "api.weixin.qq.com"
+
+// WeChatServer is a mock of the WeChat official account API: access tokens, template messages and
+// the private template list. Tokens are only issued for AppID and AppSecret.
+type WeChatServer struct {
+	*httptest.Server
+	AppID     string
+	AppSecret string
+	Templates []models.WeChatPrivateTemplate
+
+	mu     sync.Mutex
+	tokens int
+	sent   []models.WeChatTemplateMessage
+}
+
+// NewWeChatServer starts a mock WeChat API accepting the given credentials; Close stops it
+func NewWeChatServer(appID, appSecret string) *WeChatServer {
+	s := &WeChatServer{AppID: appID, AppSecret: appSecret}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cgi-bin/token", s.token)
+	mux.HandleFunc("/cgi-bin/stable_token", s.stableToken)
+	mux.HandleFunc("/cgi-bin/message/template/send", s.send)
+	mux.HandleFunc("/cgi-bin/template/get_all_private_template", s.privateTemplates)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Sent returns the template messages accepted so far
+func (s *WeChatServer) Sent() []models.WeChatTemplateMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.WeChatTemplateMessage(nil), s.sent...)
+}
+
+// Transport returns a round tripper sending requests for the WeChat API host to the mock server
+// and everything else through base. Install it as http.DefaultTransport to redirect the server's
+// WeChat clients.
+func (s *WeChatServer) Transport(base http.RoundTripper) http.RoundTripper {
+	target, _ := url.Parse(s.URL)
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host == wechatAPIHost {
+			req = req.Clone(req.Context())
+			req.URL.Scheme, req.URL.Host, req.Host = target.Scheme, target.Host, target.Host
+		}
+		return base.RoundTrip(req)
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func (s *WeChatServer) token(w http.ResponseWriter, r *http.Request) {
+	s.issueToken(w, r.URL.Query().Get("appid"), r.URL.Query().Get("secret"))
+}
+
+func (s *WeChatServer) stableToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		AppID  string `json:"appid"`
+		Secret string `json:"secret"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	s.issueToken(w, req.AppID, req.Secret)
+}
+
+func (s *WeChatServer) issueToken(w http.ResponseWriter, appID, secret string) {
+	if appID != s.AppID {
+		writeJSON(w, models.WeChatAPIResponse{ErrCode: 40013, ErrMsg: "invalid appid"})
+		return
+	}
+	if secret != s.AppSecret {
+		writeJSON(w, models.WeChatAPIResponse{ErrCode: 40125, ErrMsg: "invalid appsecret"})
+		return
+	}
+	s.mu.Lock()
+	s.tokens++
+	token := fmt.Sprintf("mock-token-%d", s.tokens)
+	s.mu.Unlock()
+	writeJSON(w, map[string]interface{}{"access_token": token, "expires_in": 7200})
+}
+
+// validToken reports whether the request carries a token issued by this server
+func (s *WeChatServer) validToken(w http.ResponseWriter, r *http.Request) bool {
+	if !strings.HasPrefix(r.URL.Query().Get("access_token"), "mock-token-") {
+		writeJSON(w, models.WeChatAPIResponse{ErrCode: 40001, ErrMsg: "invalid credential"})
+		return false
+	}
+	return true
+}
+
+func (s *WeChatServer) send(w http.ResponseWriter, r *http.Request) {
+	if !s.validToken(w, r) {
+		return
+	}
+	var msg models.WeChatTemplateMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		writeJSON(w, models.WeChatAPIResponse{ErrCode: 47001, ErrMsg: "data format error"})
+		return
+	}
+	if msg.ToUser == "" || msg.TemplateID == "" {
+		writeJSON(w, models.WeChatAPIResponse{ErrCode: 40003, ErrMsg: "invalid openid"})
+		return
+	}
+	s.mu.Lock()
+	s.sent = append(s.sent, msg)
+	msgID := int64(len(s.sent))
+	s.mu.Unlock()
+	writeJSON(w, models.WeChatAPIResponse{ErrMsg: "ok", MsgID: msgID})
+}
+
+func (s *WeChatServer) privateTemplates(w http.ResponseWriter, r *http.Request) {
+	if !s.validToken(w, r) {
+		return
+	}
+	templates := s.Templates
+	if templates == nil {
+		templates = []models.WeChatPrivateTemplate{}
+	}
+	writeJSON(w, map[string]interface{}{"template_list": templates})
+}
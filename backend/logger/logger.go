@@ -0,0 +1,96 @@
+// Package logger provides leveled logging on top of the standard log package
+// with a level that can be changed at runtime.
+package logger
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Supported levels, from most to least verbose
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+)
+
+var levels = []string{LevelDebug, LevelInfo, LevelWarn}
+
+var current atomic.Int32
+
+// RecentWarnings is how many warnings Recent keeps
+const RecentWarnings = 100
+
+// Entry is a logged warning kept for diagnostics
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+var (
+	recentMu sync.Mutex
+	recent   []Entry
+)
+
+func init() {
+	current.Store(1) // info
+}
+
+// SetLevel changes the minimum level that is written
+func SetLevel(level string) error {
+	level = strings.ToLower(strings.TrimSpace(level))
+	for i, l := range levels {
+		if l == level {
+			current.Store(int32(i))
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown log level %q, expected one of %s", level, strings.Join(levels, ", "))
+}
+
+// Level returns the current minimum level
+func Level() string {
+	return levels[current.Load()]
+}
+
+// Debugf logs diagnostic detail that is off by default
+func Debugf(format string, args ...interface{}) {
+	logf(0, "DEBUG", format, args...)
+}
+
+// Infof logs normal operational messages
+func Infof(format string, args ...interface{}) {
+	logf(1, "INFO", format, args...)
+}
+
+// Warnf logs problems that need attention but don't stop the server
+func Warnf(format string, args ...interface{}) {
+	logf(2, "WARN", format, args...)
+}
+
+// Recent returns the most recent warnings, oldest first
+func Recent() []Entry {
+	recentMu.Lock()
+	defer recentMu.Unlock()
+	return append([]Entry(nil), recent...)
+}
+
+func logf(level int32, prefix, format string, args ...interface{}) {
+	if level < current.Load() {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if level == 2 {
+		recentMu.Lock()
+		if len(recent) == RecentWarnings {
+			recent = recent[1:]
+		}
+		recent = append(recent, Entry{Time: time.Now(), Message: msg})
+		recentMu.Unlock()
+	}
+	log.Output(3, prefix+" "+msg)
+}
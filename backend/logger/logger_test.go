@@ -0,0 +1,53 @@
+package logger
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSetLevel(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer SetLevel(LevelInfo)
+
+	if err := SetLevel("WARN"); err != nil || Level() != LevelWarn {
+		t.Fatalf("SetLevel(WARN) = %v, level %s", err, Level())
+	}
+	Infof("hidden")
+	Warnf("shown")
+	if out := buf.String(); strings.Contains(out, "hidden") || !strings.Contains(out, "WARN shown") {
+		t.Errorf("unexpected output at warn level: %q", out)
+	}
+
+	buf.Reset()
+	SetLevel(LevelDebug)
+	Debugf("detail %d", 42)
+	if !strings.Contains(buf.String(), "DEBUG detail 42") {
+		t.Errorf("debug message not written at debug level: %q", buf.String())
+	}
+
+	if err := SetLevel("trace"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+	if Level() != LevelDebug {
+		t.Errorf("invalid level changed current level to %s", Level())
+	}
+}
+
+func TestRecent(t *testing.T) {
+	log.SetOutput(&bytes.Buffer{})
+	defer log.SetOutput(os.Stderr)
+
+	Infof("not kept")
+	for i := 0; i < RecentWarnings+5; i++ {
+		Warnf("warning %d", i)
+	}
+	entries := Recent()
+	if len(entries) != RecentWarnings || entries[0].Message != "warning 5" || entries[len(entries)-1].Message != "warning 104" {
+		t.Errorf("unexpected recent warnings: %d entries, first %+v", len(entries), entries[0])
+	}
+}
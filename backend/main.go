@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"time"
 
 	"wechat-notification/config"
 	"wechat-notification/handlers"
+	"wechat-notification/logger"
 	"wechat-notification/middleware"
+	"wechat-notification/models"
 	"wechat-notification/repository"
 	"wechat-notification/services"
 
@@ -19,6 +23,9 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	if err := logger.SetLevel(cfg.LogLevel); err != nil {
+		log.Fatalf("Invalid LOG_LEVEL: %v", err)
+	}
 
 	// Initialize database
 	repo, err := repository.NewSQLiteRepository(cfg.DatabasePath)
@@ -26,42 +33,166 @@ func main() {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer repo.Close()
+	repo.SetQueryTimeout(time.Duration(cfg.DBTimeoutSeconds) * time.Second)
 
-	// Initialize services
-	tokenManager := services.NewTokenManager(cfg.WeChat.AppID, cfg.WeChat.AppSecret)
-	wechatService := services.NewWeChatService(tokenManager, cfg.WeChat.TemplateID)
+	r, err := newServer(context.Background(), cfg, repo)
+	if err != nil {
+		log.Fatalf("Failed to initialize server: %v", err)
+	}
 
-	// Load WeChat config from database if available
+	log.Printf("Server starting on %s (dev mode: %v)", cfg.ServerAddress, cfg.DevMode)
+	if err := r.Run(cfg.ServerAddress); err != nil {
+		log.Fatalf("Failed to start server: %v", err)
+	}
+}
+
+// newServer wires the services and handlers on top of repo and returns the router. Background
+// sources run until ctx is cancelled.
+func newServer(ctx context.Context, cfg *config.Config, repo *repository.SQLiteRepository) (*gin.Engine, error) {
+	// Resolve WeChat config from the database and environment
 	dbConfig, _ := repo.GetWeChatConfig()
-	if dbConfig != nil && dbConfig.AppID != "" {
-		tokenManager.UpdateCredentials(dbConfig.AppID, dbConfig.AppSecret)
-		wechatService.UpdateTemplateID(dbConfig.TemplateID)
+	wechatConfig, configReport := services.ResolveWeChatConfig(cfg.WeChat, dbConfig)
+	for _, field := range configReport {
+		if field.Drift {
+			logger.Warnf("Config drift: WeChat %s differs between environment and database, using %s value", field.Field, field.Source)
+		}
+	}
+
+	// Initialize services
+	tokenManager := services.NewTokenManager(wechatConfig.AppID, wechatConfig.AppSecret)
+	tokenManager.SetStableToken(cfg.WeChat.StableToken)
+	tokenManager.SetTokenProvider(cfg.WeChat.TokenURL)
+	wechatService := services.NewWeChatService(tokenManager, wechatConfig.TemplateID)
+	wechatService.SetConcurrency(cfg.SendConcurrency)
+	wechatService.SetSandbox(cfg.WeChat.Sandbox)
+	apps := services.NewAppRegistry(wechatService)
+	if err := apps.Load(repo); err != nil {
+		return nil, fmt.Errorf("failed to load WeChat apps: %w", err)
+	}
+	if err := services.HashStoredWebhookTokens(repo); err != nil {
+		return nil, fmt.Errorf("failed to hash webhook tokens: %w", err)
 	}
 
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(cfg)
 	recipientHandler := handlers.NewRecipientHandler(repo)
-	messageHandler := handlers.NewMessageHandler(repo, wechatService)
-	configHandler := handlers.NewConfigHandler(repo, tokenManager, wechatService)
-	webhookHandler := handlers.NewWebhookHandler(repo, wechatService)
-	templateHandler := handlers.NewTemplateHandler(repo)
+	sender := services.NewSender(repo, wechatService, cfg.PublicBaseURL)
+	sender.SetContactSettings(services.ContactSettings{
+		SMTP: services.SMTPSettings{
+			Host: cfg.SMTP.Host, Port: cfg.SMTP.Port, Username: cfg.SMTP.Username, Password: cfg.SMTP.Password, From: cfg.SMTP.From,
+		},
+		TelegramBotToken: cfg.TelegramBotToken,
+	})
+	sender.SetApps(apps)
+	sender.SetSendTimeout(time.Duration(cfg.SendTimeoutSeconds) * time.Second)
+	maintenance, err := services.NewMaintenance(repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load maintenance mode: %w", err)
+	}
+	sender.SetMaintenance(maintenance)
+	messageHandler := handlers.NewMessageHandler(repo, sender)
+	historyHandler := handlers.NewHistoryHandler(services.NewHistoryJobs(repo, sender))
+	adminHandler := handlers.NewAdminHandler(maintenance, sender)
+	adminHandler.SetDiagnostics(services.NewDiagnostics(cfg, repo, tokenManager))
+	configHandler := handlers.NewConfigHandler(cfg, repo, tokenManager, wechatService)
+	warnings := services.NewConfigWarnings(repo, tokenManager, maintenance, cfg.DevMode)
+	recipientHandler.SetWarnings(warnings)
+	messageHandler.SetWarnings(warnings)
+	configHandler.SetWarnings(warnings)
+	webhookHandler := handlers.NewWebhookHandler(repo, sender, services.NewNonceCache(time.Duration(cfg.WebhookReplayWindowSeconds)*time.Second))
+	webhookEnvLimit := models.RateLimit{Rate: cfg.WebhookRateLimit, Burst: cfg.WebhookRateBurst}
+	webhookLimit, err := services.DefaultWebhookRateLimit(repo, webhookEnvLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load webhook rate limit: %w", err)
+	}
+	webhookLimiter := middleware.NewRateLimiter(webhookLimit.Rate, time.Second, webhookLimit.Burst)
+	webhookHandler.SetRateLimiter(webhookLimiter, webhookEnvLimit)
+	webhookHandler.AllowQueryToken(cfg.WebhookQueryToken)
+	templateHandler := handlers.NewTemplateHandler(repo, apps)
+	profileSigner := services.NewProfileTokenSigner(cfg.SessionSecret)
+	profileHandler := handlers.NewProfileHandler(cfg, repo, profileSigner, tokenManager)
+	ackHandler := handlers.NewAckHandler(repo)
+	emailGatewayHandler := handlers.NewEmailGatewayHandler(repo, sender)
+	serverChanHandler := handlers.NewServerChanHandler(repo, sender)
+	compatHandler := handlers.NewCompatHandler(repo, sender)
+	compatHandler.SetBaseURL(cfg.PublicBaseURL)
+	groupHandler := handlers.NewGroupHandler(repo)
+	appHandler := handlers.NewAppHandler(repo, apps)
+	mediaHandler := handlers.NewMediaHandler(apps)
+	bindingHandler := handlers.NewBindingHandler(repo, apps)
+	followerHandler := handlers.NewFollowerHandler(repo, apps)
+	callbackHandler := handlers.NewCallbackHandler(repo, apps, services.NewCallbacks(repo, apps))
+	statsExporter := services.NewStatsExporter(repo)
+	statsHandler := handlers.NewStatsHandler(repo, statsExporter)
+	feedPoller := services.NewFeedPoller(repo, sender)
+	feedHandler := handlers.NewFeedHandler(repo, feedPoller)
+	monitorChecker := services.NewMonitorChecker(repo, sender)
+	monitorHandler := handlers.NewMonitorHandler(repo, monitorChecker)
+	heartbeatChecker := services.NewHeartbeatChecker(repo, sender)
+	heartbeatHandler := handlers.NewHeartbeatHandler(repo, heartbeatChecker)
+	volumeMonitor := services.NewVolumeMonitor(repo, sender)
+	volumeHandler := handlers.NewVolumeHandler(repo, volumeMonitor)
+	calendarScheduler := services.NewCalendarScheduler(repo, sender)
+	calendarHandler := handlers.NewCalendarHandler(repo, calendarScheduler)
+	scheduledSender := services.NewScheduledSender(repo, sender)
+	retryQueue := services.NewRetryQueue(repo, wechatService)
+	retryQueue.SetApps(apps)
+	retryQueue.SetMaintenance(maintenance)
+	scheduledHandler := handlers.NewScheduledHandler(repo)
+	deadLetterHandler := handlers.NewDeadLetterHandler(repo)
+	routingHandler := handlers.NewRoutingHandler(repo)
+	directorySync := services.NewDirectorySync(repo)
+	directoryHandler := handlers.NewDirectoryHandler(repo, directorySync)
 
-	// Setup router
-	r := gin.Default()
+	// Start background sources
+	go feedPoller.Run(ctx)
+	go monitorChecker.Run(ctx)
+	go heartbeatChecker.Run(ctx)
+	go volumeMonitor.Run(ctx)
+	go calendarScheduler.Run(ctx)
+	go scheduledSender.Run(ctx)
+	go retryQueue.Run(ctx)
+	go statsExporter.Run(ctx)
+	go directorySync.Run(ctx)
+	webhookHandler.ResumeJobs()
+
+	// Setup router; the access log leaves out tokens passed in the query string
+	r := gin.New()
+	r.Use(middleware.LoggerMiddleware(gin.DefaultWriter), gin.Recovery())
+	// Only proxies we know of may name the client IP recorded with webhook sends and rate limited
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
 
 	// Configure CORS
 	r.Use(middleware.CORSMiddleware(middleware.CORSConfig{
 		AllowedOrigins: cfg.CORSAllowedOrigins,
 	}))
 
+	// Shed load before it reaches the database
+	r.Use(middleware.ConcurrencyLimitMiddleware(middleware.ConcurrencyConfig{
+		MaxInFlight: cfg.MaxInFlight,
+		MaxWait:     200 * time.Millisecond,
+		RetryAfter:  1,
+		ExemptPaths: []string{"/api/health"},
+	}))
+
 	// Auth routes (public)
 	r.GET("/auth/login", authHandler.Login)
 	r.GET("/auth/callback", authHandler.Callback)
 	r.POST("/auth/logout", authHandler.Logout)
+	r.GET("/auth/profile/login", profileHandler.OAuthLogin)
+	r.GET("/auth/profile/callback", profileHandler.OAuthCallback)
 
 	// Health check endpoint
 	r.GET("/api/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{"status": "ok"})
+		c.JSON(200, gin.H{"status": "ok", "maintenance": maintenance.Enabled()})
+	})
+
+	// Feature discovery for the SPA and CLI; public so the login page can pick the auth mode
+	capabilities := services.BuildCapabilities(cfg, tokenManager)
+	r.GET("/api/capabilities", func(c *gin.Context) {
+		c.JSON(200, models.ApiResponse{Success: true, Data: capabilities})
 	})
 
 	// Redirect root to frontend (for development)
@@ -78,25 +209,168 @@ func main() {
 	}
 	{
 		api.GET("/recipients", recipientHandler.GetAll)
+		api.GET("/recipients/stale", recipientHandler.Stale)
+		api.POST("/recipients/stale/deactivate", recipientHandler.DeactivateStale)
+		api.POST("/recipients/sync", directoryHandler.Sync)
+		api.POST("/recipients/followers/sync", followerHandler.Sync)
+		api.POST("/recipients/followers/profiles", followerHandler.RefreshProfiles)
 		api.POST("/recipients", recipientHandler.Create)
 		api.PUT("/recipients/:id", recipientHandler.Update)
 		api.DELETE("/recipients/:id", recipientHandler.Delete)
+		api.POST("/recipients/:id/profile-link", profileHandler.CreateLink)
+		api.POST("/recipients/:id/wechat-profile", followerHandler.RefreshProfile)
 		api.POST("/messages/send", messageHandler.Send)
+		api.POST("/messages/render", messageHandler.Render)
+		api.POST("/messages/check-duplicate", messageHandler.CheckDuplicate)
+		api.GET("/messages", messageHandler.List)
+		api.GET("/messages/:id", messageHandler.Get)
+		api.POST("/messages/bulk", historyHandler.Bulk)
+		api.GET("/messages/jobs", historyHandler.ListJobs)
+		api.GET("/messages/jobs/:id", historyHandler.GetJob)
+		api.GET("/messages/jobs/:id/export", historyHandler.DownloadExport)
+		api.GET("/scheduled", scheduledHandler.List)
+		api.DELETE("/scheduled/:id", scheduledHandler.Cancel)
+		api.GET("/deadletter", deadLetterHandler.List)
+		api.POST("/deadletter/:id/retry", deadLetterHandler.Retry)
 		api.GET("/config/wechat", configHandler.GetWeChatConfig)
 		api.POST("/config/wechat", configHandler.SaveWeChatConfig)
+		api.GET("/config/diff", configHandler.GetConfigDiff)
+		api.POST("/config/wechat/test", configHandler.TestWeChatConfig)
+		api.GET("/config/wechat/token", configHandler.GetTokenStatus)
+		api.GET("/config/wechat/callback", callbackHandler.GetConfig)
+		api.PUT("/config/wechat/callback", callbackHandler.SaveConfig)
+		api.GET("/config/wechat/callback/:app", callbackHandler.GetConfig)
+		api.PUT("/config/wechat/callback/:app", callbackHandler.SaveConfig)
+		api.GET("/config/wechat/canary", configHandler.GetCanaryConfig)
+		api.PUT("/config/wechat/canary", configHandler.SaveCanaryConfig)
+		api.GET("/admin/loglevel", adminHandler.GetLogLevel)
+		api.PUT("/admin/loglevel", adminHandler.SetLogLevel)
+		api.GET("/admin/maintenance", adminHandler.GetMaintenance)
+		api.POST("/admin/maintenance", adminHandler.SetMaintenance)
+		api.GET("/admin/diagnostics", adminHandler.Diagnostics)
+		api.POST("/admin/diagnostics/verify", adminHandler.VerifyDiagnostics)
 		api.GET("/webhook/token", webhookHandler.GetToken)
 		api.POST("/webhook/token", webhookHandler.GenerateToken)
+		api.POST("/webhook/token/rotate", webhookHandler.RotateToken)
+		api.POST("/webhook/signing-secret", webhookHandler.GenerateSigningSecret)
+		api.DELETE("/webhook/signing-secret", webhookHandler.DeleteSigningSecret)
+		api.PUT("/webhook/response-format", webhookHandler.SetResponseFormat)
+		api.GET("/webhook/rate-limit", webhookHandler.GetRateLimits)
+		api.PUT("/webhook/rate-limit", webhookHandler.SetRateLimit)
+		api.PUT("/webhook/rate-limit/default", webhookHandler.SetDefaultRateLimit)
 		api.GET("/templates", templateHandler.List)
 		api.POST("/templates", templateHandler.Create)
+		api.POST("/templates/metadata/sync", templateHandler.SyncMetadata)
+		api.POST("/templates/sync", templateHandler.Import)
+		api.POST("/templates/:key/preview", messageHandler.PreviewTemplate)
+		api.POST("/templates/:key/default", templateHandler.SetDefault)
+		api.PUT("/templates/:id", templateHandler.Update)
 		api.DELETE("/templates/:id", templateHandler.Delete)
+		api.GET("/acknowledgements", ackHandler.List)
+		api.GET("/routing-rules", routingHandler.List)
+		api.POST("/routing-rules", routingHandler.Create)
+		api.PUT("/routing-rules/:id", routingHandler.Update)
+		api.DELETE("/routing-rules/:id", routingHandler.Delete)
+		api.GET("/groups", groupHandler.List)
+		api.POST("/groups", groupHandler.Create)
+		api.PUT("/groups/:id", groupHandler.Update)
+		api.DELETE("/groups/:id", groupHandler.Delete)
+		api.GET("/apps", appHandler.List)
+		api.POST("/apps", appHandler.Create)
+		api.PUT("/apps/:id", appHandler.Update)
+		api.DELETE("/apps/:id", appHandler.Delete)
+		api.POST("/media", mediaHandler.Upload)
+		api.GET("/bindings", bindingHandler.List)
+		api.POST("/bindings", bindingHandler.Create)
+		api.DELETE("/bindings/:id", bindingHandler.Delete)
+		api.GET("/stats/failures", statsHandler.Failures)
+		api.POST("/stats/export", statsHandler.Export)
+		api.GET("/stats/volume", volumeHandler.Volume)
+		api.GET("/config/volume-alert", volumeHandler.GetConfig)
+		api.PUT("/config/volume-alert", volumeHandler.SaveConfig)
+		api.GET("/config/stats-export", statsHandler.GetExportConfig)
+		api.PUT("/config/stats-export", statsHandler.SaveExportConfig)
+		api.GET("/config/directory-sync", directoryHandler.GetConfig)
+		api.PUT("/config/directory-sync", directoryHandler.SaveConfig)
+
+		api.GET("/custom-endpoints", compatHandler.ListCustomEndpoints)
+		api.POST("/custom-endpoints", compatHandler.CreateCustomEndpoint)
+		api.PUT("/custom-endpoints/:id", compatHandler.UpdateCustomEndpoint)
+		api.DELETE("/custom-endpoints/:id", compatHandler.DeleteCustomEndpoint)
+		api.POST("/custom-endpoints/:id/token", compatHandler.RotateCustomEndpointToken)
+
+		api.GET("/feeds", feedHandler.List)
+		api.POST("/feeds", feedHandler.Create)
+		api.PUT("/feeds/:id", feedHandler.Update)
+		api.DELETE("/feeds/:id", feedHandler.Delete)
+		api.POST("/feeds/:id/poll", feedHandler.Poll)
+		api.GET("/monitors", monitorHandler.List)
+		api.POST("/monitors", monitorHandler.Create)
+		api.PUT("/monitors/:id", monitorHandler.Update)
+		api.DELETE("/monitors/:id", monitorHandler.Delete)
+		api.POST("/monitors/:id/check", monitorHandler.Check)
+		api.GET("/heartbeats", heartbeatHandler.List)
+		api.POST("/heartbeats", heartbeatHandler.Create)
+		api.PUT("/heartbeats/:id", heartbeatHandler.Update)
+		api.DELETE("/heartbeats/:id", heartbeatHandler.Delete)
+		api.GET("/calendars", calendarHandler.List)
+		api.POST("/calendars", calendarHandler.Create)
+		api.PUT("/calendars/:id", calendarHandler.Update)
+		api.DELETE("/calendars/:id", calendarHandler.Delete)
+		api.GET("/calendars/:id/events", calendarHandler.Events)
+		api.GET("/config/email-gateway", emailGatewayHandler.GetConfig)
+		api.PUT("/config/email-gateway", emailGatewayHandler.SaveConfig)
+		api.GET("/config/serverchan", serverChanHandler.GetConfig)
+		api.PUT("/config/serverchan", serverChanHandler.SaveConfig)
+		api.GET("/config/compat/:service", compatHandler.GetConfig)
+		api.PUT("/config/compat/:service", compatHandler.SaveConfig)
+		api.GET("/config/compat/:service/setup", compatHandler.Setup)
 	}
 
-	// Public webhook endpoint (uses its own token auth + rate limiting)
-	webhookLimiter := middleware.NewRateLimiter(10, time.Second, 20) // 10 req/s, burst 20
-	r.POST("/api/webhook/send", middleware.RateLimitMiddleware(webhookLimiter), webhookHandler.Send)
+	// Public webhook endpoint (uses its own token auth + rate limiting, per token on /api/webhook/send)
+	r.POST("/api/webhook/send", middleware.RateLimitByKeyMiddleware(webhookLimiter, webhookHandler.RateLimitKey), webhookHandler.Send)
+	r.GET("/api/webhook/jobs/:id", middleware.RateLimitByKeyMiddleware(webhookLimiter, webhookHandler.RateLimitKey), webhookHandler.GetJob)
+	r.POST("/api/webhook/email/:token", middleware.RateLimitMiddleware(webhookLimiter), emailGatewayHandler.Receive)
+	r.GET("/api/webhook/synology/:token", middleware.RateLimitMiddleware(webhookLimiter), compatHandler.Synology)
+	r.POST("/api/webhook/synology/:token", middleware.RateLimitMiddleware(webhookLimiter), compatHandler.Synology)
+	r.POST("/api/webhook/qnap/:token", middleware.RateLimitMiddleware(webhookLimiter), compatHandler.QNAP)
+	r.POST("/api/webhook/watchtower/:token", middleware.RateLimitMiddleware(webhookLimiter), compatHandler.Watchtower)
+	r.POST("/api/webhook/diun/:token", middleware.RateLimitMiddleware(webhookLimiter), compatHandler.Diun)
+	r.POST("/api/webhook/grafana/:token", middleware.RateLimitMiddleware(webhookLimiter), compatHandler.Grafana)
+	r.POST("/api/webhook/sentry/:token", middleware.RateLimitMiddleware(webhookLimiter), compatHandler.Sentry)
+	r.POST("/api/webhook/zabbix/:token", middleware.RateLimitMiddleware(webhookLimiter), compatHandler.Zabbix)
+	r.POST("/api/webhook/github", middleware.RateLimitMiddleware(webhookLimiter), compatHandler.GitHub)
+	r.POST("/api/webhook/gitlab", middleware.RateLimitMiddleware(webhookLimiter), compatHandler.GitLab)
+	r.POST("/api/webhook/jenkins/:token", middleware.RateLimitMiddleware(webhookLimiter), compatHandler.Jenkins)
+	r.POST("/api/webhook/argocd/:token", middleware.RateLimitMiddleware(webhookLimiter), compatHandler.ArgoCD)
+	r.POST("/api/webhook/custom/:slug", middleware.RateLimitMiddleware(webhookLimiter), compatHandler.Custom)
+	r.POST("/api/heartbeat/:token", middleware.RateLimitMiddleware(webhookLimiter), heartbeatHandler.Ping)
+	// WeChat server callbacks (signed with the callback token)
+	r.GET("/api/wechat/callback", callbackHandler.Verify)
+	r.POST("/api/wechat/callback", callbackHandler.Receive)
+	r.GET("/api/wechat/callback/:app", callbackHandler.Verify)
+	r.POST("/api/wechat/callback/:app", callbackHandler.Receive)
+	// Server酱-compatible push: /serverchan/<SendKey>.send
+	r.GET("/serverchan/:sendkey", middleware.RateLimitMiddleware(webhookLimiter), serverChanHandler.Push)
+	r.POST("/serverchan/:sendkey", middleware.RateLimitMiddleware(webhookLimiter), serverChanHandler.Push)
+	// PushPlus- and WxPusher-compatible pushes
+	r.GET("/send", middleware.RateLimitMiddleware(webhookLimiter), compatHandler.PushPlus)
+	r.POST("/send", middleware.RateLimitMiddleware(webhookLimiter), compatHandler.PushPlus)
+	r.GET("/api/send/message", middleware.RateLimitMiddleware(webhookLimiter), compatHandler.WxPusher)
+	r.POST("/api/send/message", middleware.RateLimitMiddleware(webhookLimiter), compatHandler.WxPusher)
 
-	log.Printf("Server starting on %s (dev mode: %v)", cfg.ServerAddress, cfg.DevMode)
-	if err := r.Run(cfg.ServerAddress); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	// Acknowledgement confirm page opened from template message links (token is the credential)
+	r.GET("/api/ack/:token", ackHandler.ConfirmPage)
+	r.POST("/api/ack/:token", ackHandler.Acknowledge)
+
+	// Recipient self-service profile (uses signed profile tokens instead of admin sessions)
+	profile := r.Group("/api/profile", middleware.ProfileAuthMiddleware(profileSigner))
+	{
+		profile.GET("", profileHandler.Get)
+		profile.PUT("", profileHandler.Update)
+		profile.PUT("/subscriptions/:key", profileHandler.UpdateSubscription)
 	}
+
+	log.Printf("Capabilities: %s", capabilities)
+	return r, nil
 }
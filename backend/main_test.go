@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"wechat-notification/config"
+	"wechat-notification/internal/testutil"
+	"wechat-notification/models"
+	"wechat-notification/repository"
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// e2eClient drives the server like the SPA: cookies are kept and redirects are followed by hand
+type e2eClient struct {
+	t      *testing.T
+	base   string
+	client *http.Client
+}
+
+func (c *e2eClient) do(method, path string, body interface{}) *http.Response {
+	c.t.Helper()
+	url := path
+	if path[0] == '/' {
+		url = c.base + path
+	}
+	var payload bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&payload).Encode(body)
+	}
+	req, err := http.NewRequest(method, url, &payload)
+	if err != nil {
+		c.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return resp
+}
+
+// api calls an API endpoint, failing the test unless it answers with status
+func (c *e2eClient) api(method, path string, body interface{}, status int) models.ApiResponse {
+	c.t.Helper()
+	resp := c.do(method, path, body)
+	defer resp.Body.Close()
+	var result models.ApiResponse
+	json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != status {
+		c.t.Fatalf("%s %s: status %d, want %d (%+v)", method, path, resp.StatusCode, status, result)
+	}
+	return result
+}
+
+// redirect follows one redirect step, returning the Location
+func (c *e2eClient) redirect(url string) string {
+	c.t.Helper()
+	resp := c.do(http.MethodGet, url, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		c.t.Fatalf("GET %s: status %d, want redirect", url, resp.StatusCode)
+	}
+	return resp.Header.Get("Location")
+}
+
+func TestEndToEnd(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	oidc := testutil.NewOIDCServer(services.UserInfo{Sub: "admin", Email: "admin@example.com"})
+	defer oidc.Close()
+	wechat := testutil.NewWeChatServer("wx-e2e", "e2e-secret")
+	defer wechat.Close()
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = wechat.Transport(defaultTransport)
+	defer func() { http.DefaultTransport = defaultTransport }()
+
+	// The callback URL has to be known before the router is built
+	srv := httptest.NewUnstartedServer(nil)
+	srv.Start()
+	defer srv.Close()
+	cfg := &config.Config{
+		DatabasePath:       filepath.Join(t.TempDir(), "e2e.db"),
+		SessionSecret:      "e2e",
+		CORSAllowedOrigins: []string{"*"},
+		PublicBaseURL:      "http://localhost:5173",
+		LogLevel:           "warn",
+		SendConcurrency:    2,
+		SendTimeoutSeconds: 10,
+		OIDC: config.OIDCConfig{
+			ProviderURL:  oidc.URL,
+			ClientID:     testutil.OIDCClientID,
+			ClientSecret: testutil.OIDCClientSecret,
+			RedirectURL:  srv.URL + "/auth/callback",
+		},
+	}
+	repo, err := repository.NewSQLiteRepository(cfg.DatabasePath)
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	router, err := newServer(ctx, cfg, repo)
+	if err != nil {
+		t.Fatalf("newServer error: %v", err)
+	}
+	srv.Config.Handler = router
+
+	jar, _ := cookiejar.New(nil)
+	c := &e2eClient{t: t, base: srv.URL, client: &http.Client{
+		Jar:           jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}}
+
+	// Login
+	c.api(http.MethodGet, "/api/recipients", nil, http.StatusUnauthorized)
+	authorize := c.redirect("/auth/login")
+	callback := c.redirect(authorize)
+	if home := c.redirect(callback); home != "/" {
+		t.Fatalf("callback redirected to %q", home)
+	}
+
+	// Configure
+	c.api(http.MethodPost, "/api/config/wechat/test", map[string]string{"appId": "wx-e2e", "appSecret": "wrong"}, http.StatusUnprocessableEntity)
+	c.api(http.MethodPost, "/api/config/wechat", models.WeChatConfig{AppID: "wx-e2e", AppSecret: "e2e-secret", TemplateID: "tpl-default"}, http.StatusOK)
+	c.api(http.MethodPost, "/api/templates", map[string]string{"key": "alert", "templateId": "tpl-alert", "name": "告警"}, http.StatusCreated)
+	created := c.api(http.MethodPost, "/api/recipients", map[string]string{"openId": "o-admin", "name": "Admin"}, http.StatusCreated)
+	recipientID := int64(created.Data.(map[string]interface{})["id"].(float64))
+
+	// Send
+	sent := c.api(http.MethodPost, "/api/messages/send", models.SendMessageRequest{
+		TemplateKey:  "alert",
+		RecipientIDs: []int64{recipientID},
+		Keywords:     map[string]string{"first": "磁盘告警", "keyword1": "/dev/sda1 95%"},
+	}, http.StatusOK)
+	if result := sent.Data.(map[string]interface{}); result["totalSent"].(float64) != 1 {
+		t.Fatalf("unexpected send result: %+v", result)
+	}
+	messages := wechat.Sent()
+	if len(messages) != 1 || messages[0].ToUser != "o-admin" || messages[0].TemplateID != "tpl-alert" {
+		t.Fatalf("WeChat received %+v", messages)
+	}
+
+	// Sends without a template key use the default template
+	noKey := models.SendMessageRequest{RecipientIDs: []int64{recipientID}, Keywords: map[string]string{"first": "恢复"}}
+	c.api(http.MethodPost, "/api/messages/send", noKey, http.StatusBadRequest)
+	c.api(http.MethodPost, "/api/templates/alert/default", nil, http.StatusOK)
+	c.api(http.MethodPost, "/api/messages/send", noKey, http.StatusOK)
+	if messages := wechat.Sent(); len(messages) != 2 || messages[1].TemplateID != "tpl-alert" {
+		t.Fatalf("WeChat received %+v", messages)
+	}
+
+	// History
+	history := c.api(http.MethodGet, "/api/messages", nil, http.StatusOK)
+	if total := history.Data.(map[string]interface{})["total"].(float64); total != 2 {
+		t.Errorf("history total = %v, want 2", total)
+	}
+
+	// Logout
+	c.api(http.MethodPost, "/auth/logout", nil, http.StatusOK)
+	c.api(http.MethodGet, "/api/recipients", nil, http.StatusUnauthorized)
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConcurrencyConfig holds load shedding middleware configuration
+type ConcurrencyConfig struct {
+	MaxInFlight int           // Requests served at once; 0 disables the limit
+	MaxWait     time.Duration // How long a request may queue for a free slot before being shed
+	RetryAfter  int           // Seconds sent in the Retry-After header of shed requests
+	ExemptPaths []string      // Routes that are never shed, e.g. health checks
+}
+
+// ConcurrencyLimitMiddleware caps the number of in-flight requests and answers
+// 503 with Retry-After once the cap is reached, so bursts of webhook calls queue
+// at the client instead of piling up on the single SQLite writer
+func ConcurrencyLimitMiddleware(config ConcurrencyConfig) gin.HandlerFunc {
+	if config.MaxInFlight <= 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	slots := make(chan struct{}, config.MaxInFlight)
+	exempt := make(map[string]bool, len(config.ExemptPaths))
+	for _, p := range config.ExemptPaths {
+		exempt[p] = true
+	}
+	retryAfter := strconv.Itoa(config.RetryAfter)
+
+	return func(c *gin.Context) {
+		if exempt[c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		select {
+		case slots <- struct{}{}:
+		default:
+			timer := time.NewTimer(config.MaxWait)
+			select {
+			case slots <- struct{}{}:
+				timer.Stop()
+			case <-timer.C:
+				c.Header("Retry-After", retryAfter)
+				c.JSON(http.StatusServiceUnavailable, gin.H{
+					"success": false,
+					"error":   "Server is busy, please retry later",
+					"code":    "SERVER_BUSY",
+				})
+				c.Abort()
+				return
+			case <-c.Request.Context().Done():
+				timer.Stop()
+				c.Abort()
+				return
+			}
+		}
+		defer func() { <-slots }()
+
+		c.Next()
+	}
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestConcurrencyLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	r := gin.New()
+	r.Use(ConcurrencyLimitMiddleware(ConcurrencyConfig{
+		MaxInFlight: 1,
+		MaxWait:     10 * time.Millisecond,
+		RetryAfter:  2,
+		ExemptPaths: []string{"/health"},
+	}))
+	r.GET("/slow", func(c *gin.Context) {
+		started <- struct{}{}
+		<-release
+		c.Status(http.StatusOK)
+	})
+	r.GET("/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	first := httptest.NewRecorder()
+	go func() {
+		defer wg.Done()
+		r.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	}()
+	<-started
+
+	shed := httptest.NewRecorder()
+	r.ServeHTTP(shed, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if shed.Code != http.StatusServiceUnavailable || shed.Header().Get("Retry-After") != "2" {
+		t.Errorf("second request: status %d, Retry-After %q; want 503 with Retry-After 2", shed.Code, shed.Header().Get("Retry-After"))
+	}
+
+	health := httptest.NewRecorder()
+	r.ServeHTTP(health, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if health.Code != http.StatusOK {
+		t.Errorf("exempt path status = %d, want 200", health.Code)
+	}
+
+	close(release)
+	wg.Wait()
+	if first.Code != http.StatusOK {
+		t.Errorf("first request status = %d, want 200", first.Code)
+	}
+
+	after := httptest.NewRecorder()
+	go func() { <-started }()
+	r.ServeHTTP(after, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if after.Code != http.StatusOK {
+		t.Errorf("request after slot was released: status %d, want 200", after.Code)
+	}
+}
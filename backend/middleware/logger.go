@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// secretParams names the query and route parameters that carry credentials: webhook, acknowledgement
+// and profile tokens, WeChat access tokens, ServerChan send keys and WxPusher app tokens
+var secretParams = []string{"token", "access_token", "sendkey", "appToken"}
+
+// secretQueryPattern matches the query parameters named in secretParams
+var secretQueryPattern = regexp.MustCompile(`(?i)([?&](?:` + strings.Join(quoteAll(secretParams), "|") + `)=)[^&]*`)
+
+func quoteAll(names []string) []string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = regexp.QuoteMeta(name)
+	}
+	return quoted
+}
+
+// logPathKey holds the request path with credentials redacted for the access log
+const logPathKey = "middleware.logPath"
+
+// LoggerMiddleware writes gin's access log to out with credentials in the path and query string redacted
+func LoggerMiddleware(out io.Writer) gin.HandlerFunc {
+	logger := gin.LoggerWithConfig(gin.LoggerConfig{Output: out, Formatter: redactedLogFormatter})
+	return func(c *gin.Context) {
+		// The formatter doesn't see the matched route, so the path is redacted up front
+		path := redactPathParams(c.FullPath(), c.Request.URL.Path)
+		if c.Request.URL.RawQuery != "" {
+			path += "?" + c.Request.URL.RawQuery
+		}
+		c.Set(logPathKey, RedactQuery(path))
+		logger(c)
+	}
+}
+
+// redactedLogFormatter formats like gin's default logger, without colors
+func redactedLogFormatter(param gin.LogFormatterParams) string {
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		param.Method,
+		logPath(param),
+		param.ErrorMessage,
+	)
+}
+
+func logPath(param gin.LogFormatterParams) string {
+	if path, ok := param.Keys[logPathKey].(string); ok {
+		return path
+	}
+	return RedactQuery(param.Path)
+}
+
+// isSecretParam reports whether a route parameter is named in secretParams
+func isSecretParam(name string) bool {
+	for _, secret := range secretParams {
+		if strings.EqualFold(name, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactPathParams replaces the path segments matched by secret parameters of route. Requests
+// that matched no route are logged as they came.
+func redactPathParams(route, path string) string {
+	if route == "" {
+		return path
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range strings.Split(route, "/") {
+		if i < len(segments) && strings.HasPrefix(segment, ":") && isSecretParam(segment[1:]) {
+			segments[i] = "REDACTED"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// RedactQuery replaces the values of credential query parameters in path
+func RedactQuery(path string) string {
+	return secretQueryPattern.ReplaceAllString(path, "${1}REDACTED")
+}
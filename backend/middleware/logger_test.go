@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLoggerMiddlewareRedactsTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var out bytes.Buffer
+	r := gin.New()
+	r.Use(LoggerMiddleware(&out))
+	r.POST("/api/webhook/send", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/webhook/send?async=true&Token=s3cret&access_token=k3y", nil))
+
+	logged := out.String()
+	if strings.Contains(logged, "s3cret") || strings.Contains(logged, "k3y") {
+		t.Errorf("credentials logged: %s", logged)
+	}
+	if !strings.Contains(logged, "/api/webhook/send?async=true&Token=REDACTED&access_token=REDACTED") || !strings.Contains(logged, "200") {
+		t.Errorf("unexpected log line: %s", logged)
+	}
+}
+
+func TestLoggerMiddlewareRedactsCompatTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var out bytes.Buffer
+	r := gin.New()
+	r.Use(LoggerMiddleware(&out))
+	r.GET("/send", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/api/send/message", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/send?token=pp-s3cret&title=hi", nil),
+		httptest.NewRequest(http.MethodGet, "/api/send/message?appToken=AT_s3cret&content=hello", nil),
+	} {
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	logged := out.String()
+	if strings.Contains(logged, "s3cret") {
+		t.Errorf("credentials logged: %s", logged)
+	}
+	for _, want := range []string{"/send?token=REDACTED&title=hi", "/api/send/message?appToken=REDACTED&content=hello"} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("log is missing %s: %s", want, logged)
+		}
+	}
+}
+
+func TestLoggerMiddlewareRedactsPathTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var out bytes.Buffer
+	r := gin.New()
+	r.Use(LoggerMiddleware(&out))
+	r.POST("/api/webhook/grafana/:token", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/serverchan/:sendkey", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/api/templates/:key/preview", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.POST("/api/heartbeat/:token", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/api/webhook/grafana/s3cret?dryRun=true", nil),
+		httptest.NewRequest(http.MethodGet, "/serverchan/SCTk3y.send", nil),
+		httptest.NewRequest(http.MethodGet, "/api/templates/deploy/preview", nil),
+		httptest.NewRequest(http.MethodPost, "/api/heartbeat/hb-pingk3y", nil),
+	} {
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	logged := out.String()
+	if strings.Contains(logged, "s3cret") || strings.Contains(logged, "SCTk3y") || strings.Contains(logged, "pingk3y") {
+		t.Errorf("credentials logged: %s", logged)
+	}
+	for _, want := range []string{"/api/webhook/grafana/REDACTED?dryRun=true", "/serverchan/REDACTED", "/api/templates/deploy/preview", "/api/heartbeat/REDACTED"} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("log is missing %s: %s", want, logged)
+		}
+	}
+}
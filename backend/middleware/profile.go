@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"wechat-notification/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ContextKeyRecipientID = "recipientId"
+)
+
+// ProfileAuthMiddleware authenticates recipients using a signed profile token
+// passed as "Authorization: Bearer <token>" or the "token" query parameter
+func ProfileAuthMiddleware(signer *services.ProfileTokenSigner) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if token == "" {
+			token = c.Query("token")
+		}
+
+		recipientID, err := signer.Verify(token)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"error":   err.Error(),
+				"code":    "UNAUTHORIZED",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Set(ContextKeyRecipientID, recipientID)
+		c.Next()
+	}
+}
+
+// GetRecipientIDFromContext retrieves the authenticated recipient ID from the gin context
+func GetRecipientIDFromContext(c *gin.Context) int64 {
+	return c.GetInt64(ContextKeyRecipientID)
+}
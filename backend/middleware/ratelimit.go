@@ -2,20 +2,29 @@ package middleware
 
 import (
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
+	"wechat-notification/models"
+
 	"github.com/gin-gonic/gin"
 )
 
+// rateLimitIdleTimeout is how long a key goes unused before the limiter forgets it. Longer than
+// any bucket takes to refill, so a forgotten key starts over with the burst it would have had.
+const rateLimitIdleTimeout = time.Hour
+
 // RateLimiter implements a simple token bucket rate limiter
 type RateLimiter struct {
-	mu       sync.Mutex
-	tokens   map[string]int
-	lastTime map[string]time.Time
-	rate     int           // tokens per interval
-	interval time.Duration // refill interval
-	burst    int           // max tokens
+	mu        sync.Mutex
+	tokens    map[string]int
+	lastTime  map[string]time.Time
+	rate      int           // tokens per interval
+	interval  time.Duration // refill interval
+	burst     int           // max tokens
+	idle      time.Duration // keys unused this long are dropped
+	lastSweep time.Time
 }
 
 // NewRateLimiter creates a new rate limiter
@@ -26,46 +35,109 @@ func NewRateLimiter(rate int, interval time.Duration, burst int) *RateLimiter {
 		rate:     rate,
 		interval: interval,
 		burst:    burst,
+		idle:     rateLimitIdleTimeout,
 	}
 }
 
+// DefaultLimit returns the limit of keys without a limit of their own
+func (rl *RateLimiter) DefaultLimit() models.RateLimit {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return models.RateLimit{Rate: rl.rate, Burst: rl.burst}
+}
+
+// SetDefaultLimit changes the limit of keys without a limit of their own, taking effect on
+// their next request
+func (rl *RateLimiter) SetDefaultLimit(limit models.RateLimit) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.rate, rl.burst = limit.Rate, limit.Burst
+}
+
 // Allow checks if a request is allowed
 func (rl *RateLimiter) Allow(key string) bool {
+	allowed, _, _ := rl.Take(key, rl.DefaultLimit())
+	return allowed
+}
+
+// Take checks if a request is allowed under limit instead of the limiter's defaults. It also
+// returns the tokens left and, for a refused request, how long until the next refill.
+func (rl *RateLimiter) Take(key string, limit models.RateLimit) (bool, int, time.Duration) {
+	return rl.take(key, limit, time.Now())
+}
+
+func (rl *RateLimiter) take(key string, limit models.RateLimit, now time.Time) (bool, int, time.Duration) {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
-	now := time.Now()
+	// Drop idle keys so the maps don't grow with every address that ever called
+	if now.Sub(rl.lastSweep) >= rl.idle {
+		for k, last := range rl.lastTime {
+			if now.Sub(last) >= rl.idle {
+				delete(rl.lastTime, k)
+				delete(rl.tokens, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+
 	last, exists := rl.lastTime[key]
 
 	if !exists {
-		rl.tokens[key] = rl.burst - 1
+		rl.tokens[key] = limit.Burst - 1
 		rl.lastTime[key] = now
-		return true
+		return true, limit.Burst - 1, 0
 	}
 
 	// Refill tokens based on elapsed time
 	elapsed := now.Sub(last)
-	refill := int(elapsed / rl.interval) * rl.rate
+	refill := int(elapsed / rl.interval) * limit.Rate
 	tokens := rl.tokens[key] + refill
-	if tokens > rl.burst {
-		tokens = rl.burst
+	if tokens > limit.Burst {
+		tokens = limit.Burst
 	}
 
 	if tokens > 0 {
 		rl.tokens[key] = tokens - 1
 		rl.lastTime[key] = now
-		return true
+		return true, tokens - 1, 0
 	}
 
-	return false
+	return false, 0, rl.interval - elapsed%rl.interval
 }
 
+// KeyFunc identifies who a request is rate limited as and the limit that applies to them; a zero
+// limit uses the limiter's defaults. Returning ok false limits the request by client IP.
+type KeyFunc func(c *gin.Context) (key string, limit models.RateLimit, ok bool)
+
 // RateLimitMiddleware creates a rate limiting middleware
 // Default: 10 requests per second with burst of 20
+// Responses carry X-RateLimit-Limit (the burst) and X-RateLimit-Remaining, and refused requests
+// Retry-After, so callers can back off instead of retrying blindly.
 func RateLimitMiddleware(limiter *RateLimiter) gin.HandlerFunc {
+	return RateLimitByKeyMiddleware(limiter, nil)
+}
+
+// RateLimitByKeyMiddleware creates a rate limiting middleware that limits the callers keyFunc
+// identifies separately, so callers behind one NAT address don't share a limit
+func RateLimitByKeyMiddleware(limiter *RateLimiter, keyFunc KeyFunc) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		key := c.ClientIP()
-		if !limiter.Allow(key) {
+		key, limit, ok := "", models.RateLimit{}, false
+		if keyFunc != nil {
+			key, limit, ok = keyFunc(c)
+		}
+		if !ok {
+			key, limit = "ip:"+c.ClientIP(), models.RateLimit{}
+		}
+		if limit == (models.RateLimit{}) {
+			limit = limiter.DefaultLimit()
+		}
+		allowed, remaining, retryAfter := limiter.Take(key, limit)
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limit.Burst))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		if !allowed {
+			// Whole seconds, rounded up so a client waiting that long finds a token
+			c.Header("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
 			c.JSON(http.StatusTooManyRequests, gin.H{
 				"success": false,
 				"error":   "Too many requests, please try again later",
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimitByKeyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Callers are told apart by X-Caller; "big" has its own limit, the others use the default
+	keyFunc := func(c *gin.Context) (string, models.RateLimit, bool) {
+		switch caller := c.GetHeader("X-Caller"); caller {
+		case "":
+			return "", models.RateLimit{}, false
+		case "big":
+			return caller, models.RateLimit{Rate: 1, Burst: 3}, true
+		default:
+			return caller, models.RateLimit{}, true
+		}
+	}
+	r := gin.New()
+	r.Use(RateLimitByKeyMiddleware(NewRateLimiter(1, time.Hour, 1), keyFunc))
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	send := func(caller string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Caller", caller)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Callers behind the same address don't share a limit
+	for _, caller := range []string{"a", "b", ""} {
+		if code := send(caller); code != http.StatusOK {
+			t.Errorf("first request from %q: status %d", caller, code)
+		}
+		if code := send(caller); code != http.StatusTooManyRequests {
+			t.Errorf("second request from %q: status %d, want 429", caller, code)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if code := send("big"); code != http.StatusOK {
+			t.Errorf("request %d within the caller's burst: status %d", i+1, code)
+		}
+	}
+	if code := send("big"); code != http.StatusTooManyRequests {
+		t.Errorf("request beyond the caller's burst: status %d, want 429", code)
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(RateLimitMiddleware(NewRateLimiter(1, time.Minute, 2)))
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for i, want := range []struct {
+		code                  int
+		remaining, retryAfter string
+	}{{http.StatusOK, "1", ""}, {http.StatusOK, "0", ""}, {http.StatusTooManyRequests, "0", "60"}} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		h := w.Header()
+		if w.Code != want.code || h.Get("X-RateLimit-Limit") != "2" || h.Get("X-RateLimit-Remaining") != want.remaining || h.Get("Retry-After") != want.retryAfter {
+			t.Errorf("request %d: status %d, headers %v; want %d, remaining %s, Retry-After %q",
+				i+1, w.Code, h, want.code, want.remaining, want.retryAfter)
+		}
+	}
+}
+
+func TestRateLimiterEvictsIdleKeys(t *testing.T) {
+	rl := NewRateLimiter(1, time.Second, 1)
+	limit := models.RateLimit{Rate: 1, Burst: 1}
+	now := time.Now()
+
+	for _, key := range []string{"a", "b", "c"} {
+		rl.take(key, limit, now)
+	}
+	rl.take("c", limit, now.Add(30*time.Minute))
+	if allowed, _, _ := rl.take("d", limit, now.Add(rateLimitIdleTimeout)); !allowed {
+		t.Fatal("new key refused")
+	}
+	if len(rl.lastTime) != 2 || len(rl.tokens) != 2 {
+		t.Errorf("expected only the recently used keys c and d, got %v", rl.lastTime)
+	}
+	if _, ok := rl.lastTime["c"]; !ok {
+		t.Error("key used within the idle timeout was dropped")
+	}
+}
+
+func TestRateLimiterSetDefaultLimit(t *testing.T) {
+	rl := NewRateLimiter(1, time.Hour, 1)
+	if !rl.Allow("a") || rl.Allow("a") {
+		t.Fatal("expected a burst of 1")
+	}
+	rl.SetDefaultLimit(models.RateLimit{Rate: 1, Burst: 3})
+	if got := rl.DefaultLimit(); got != (models.RateLimit{Rate: 1, Burst: 3}) {
+		t.Errorf("DefaultLimit = %+v", got)
+	}
+	for i := 0; i < 3; i++ {
+		if !rl.Allow("b") {
+			t.Errorf("request %d refused under the new default", i+1)
+		}
+	}
+}
@@ -1,23 +1,166 @@
 package models
 
 import (
+	"encoding/json"
+	"encoding/xml"
 	"time"
 )
 
 // Recipient represents a message recipient
 type Recipient struct {
-	ID        int64     `json:"id"`
-	OpenID    string    `json:"openId"`
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID              int64             `json:"id"`
+	OpenID          string            `json:"openId"`
+	AppID           int64             `json:"appId"` // OpenID 所属的公众号（WeChatApp.ID），0 为默认公众号
+	Name            string            `json:"name"`
+	Muted           bool              `json:"muted"`
+	QuietHoursStart string            `json:"quietHoursStart"` // HH:MM, empty when unset
+	QuietHoursEnd   string            `json:"quietHoursEnd"`   // HH:MM, empty when unset
+	Metadata        map[string]string `json:"metadata"`        // 自定义字段，如 department、phone、locale
+	Channels        []Channel         `json:"channels"`        // 个人推送渠道，如 Server酱
+	FallbackChannel *Channel          `json:"fallbackChannel"` // 微信发送失败时改用的渠道
+	Email           string            `json:"email"`
+	TelegramChatID  string            `json:"telegramChatId"`
+	Preferences     []string          `json:"preferences"` // 接收渠道偏好 wechat / email / telegram，为空时只发微信
+	CreatedAt       time.Time         `json:"createdAt"`
+	UpdatedAt       time.Time         `json:"updatedAt"`
+
+	LastDeliveredAt      *time.Time `json:"lastDeliveredAt"`      // 最近一次微信发送成功的时间
+	UnsubscribedFailures int        `json:"unsubscribedFailures"` // 自上次成功以来因未关注而失败的次数
+	Unsubscribed         bool       `json:"unsubscribed"`         // 最近一次发送因未关注（43004）或拒收失败，发送给所有人时跳过
+	Deactivated          bool       `json:"deactivated"`          // 已从同步的通讯录中移除，不再接收消息，重新出现在通讯录中时恢复
+
+	Nickname        string     `json:"nickname"`        // 微信昵称，来自 user/info
+	AvatarURL       string     `json:"avatarUrl"`       // 微信头像地址
+	Language        string     `json:"language"`        // 微信客户端语言
+	ProfileSyncedAt *time.Time `json:"profileSyncedAt"` // 最近一次从微信获取资料的时间
+}
+
+// Contact channels a recipient can list in their preferences
+const (
+	ContactWeChat   = "wechat"
+	ContactEmail    = "email"
+	ContactTelegram = "telegram"
+)
+
+// ProfileSubscription describes whether a recipient receives a given template
+type ProfileSubscription struct {
+	TemplateKey string `json:"templateKey"`
+	Name        string `json:"name"`
+	Subscribed  bool   `json:"subscribed"`
+}
+
+// RecipientProfile is the self-service view of a recipient. It holds only what the recipient may
+// change themselves: channels, contact details and metadata stay with the admin API.
+type RecipientProfile struct {
+	Name            string                `json:"name"`
+	Muted           bool                  `json:"muted"`
+	QuietHoursStart string                `json:"quietHoursStart"`
+	QuietHoursEnd   string                `json:"quietHoursEnd"`
+	Subscriptions   []ProfileSubscription `json:"subscriptions"`
+}
+
+// Group is a named set of recipients that sources and channels can target
+type Group struct {
+	ID           int64     `json:"id"`
+	Name         string    `json:"name"`
+	Description  string    `json:"description"`
+	RecipientIDs []int64   `json:"recipientIds"`
+	Channels     []Channel `json:"channels"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// Channel types
+const (
+	ChannelSlack  = "slack"
+	ChannelFeishu = "feishu" // 飞书 / Lark 自定义机器人
+	ChannelGotify = "gotify"
+	ChannelNtfy   = "ntfy"
+	// ServerChan (方糖) is a personal push channel configured on a recipient
+	ChannelServerChan = "serverchan"
+	// 企业微信自建应用消息
+	ChannelWeComApp = "wecom_app"
+	// 企业微信群机器人, only attachable to groups
+	ChannelWeComBot = "wecom_bot"
+	// Bark iOS push, usually configured on a recipient with their device key
+	ChannelBark = "bark"
+)
+
+// Channel is an extra delivery channel. Channels on a group receive every notification sent to the group;
+// channels on a recipient receive that recipient's copy of each message.
+type Channel struct {
+	Type       string `json:"type"`
+	WebhookURL string `json:"webhookUrl,omitempty"` // Incoming webhook URL (Slack, Feishu, WeCom robot)
+	Secret     string `json:"secret,omitempty"`     // Signing secret (Feishu) or app secret (WeCom)
+	ServerURL  string `json:"serverUrl,omitempty"`  // Self-hosted server base URL (Gotify, ntfy, Bark)
+	Token      string `json:"token,omitempty"`      // Gotify application token, ntfy access token, ServerChan SendKey or Bark device key
+	Topic      string `json:"topic,omitempty"`      // ntfy topic
+	CorpID     string `json:"corpId,omitempty"`     // 企业微信 corpid
+	AgentID    int    `json:"agentId,omitempty"`    // 企业微信应用 agentid，Secret 填应用 secret
+	ToUser     string `json:"toUser,omitempty"`     // 企业微信成员 userid，多个用 | 分隔，默认 @all
+	Format     string `json:"format,omitempty"`     // 企业微信群机器人消息格式 markdown（默认）或 text
+}
+
+// Message priorities
+const (
+	PriorityLow    = "low"
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+	PriorityUrgent = "urgent"
+)
+
+// RoutingRule picks extra channels for matching sends. Empty match fields match everything;
+// rules are evaluated in position order and Stop ends evaluation after a match.
+type RoutingRule struct {
+	ID           int64     `json:"id"`
+	Name         string    `json:"name"`
+	Position     int       `json:"position"`
+	Enabled      bool      `json:"enabled"`
+	TemplateKeys []string  `json:"templateKeys"`
+	Tags         []string  `json:"tags"`       // 消息带有其中任意一个标签即匹配
+	Priorities   []string  `json:"priorities"` // low / normal / high / urgent
+	TimeStart    string    `json:"timeStart"`  // HH:MM，与 TimeEnd 同时为空表示全天
+	TimeEnd      string    `json:"timeEnd"`
+	Channels     []Channel `json:"channels"`
+	SkipWeChat   bool      `json:"skipWeChat"` // 匹配时不再发送微信模板消息
+	Stop         bool      `json:"stop"`
+	CreatedAt    time.Time `json:"createdAt"`
 }
 
 // SendMessageRequest represents a request to send a message
 type SendMessageRequest struct {
-	TemplateKey  string            `json:"templateKey"`  // 模板标识（用于选择模板）
-	Keywords     map[string]string `json:"keywords"`     // keyword0, keyword1, keyword2...
+	TemplateKey  string            `json:"templateKey"` // 模板标识（用于选择模板）
+	Keywords     map[string]string `json:"keywords"`    // keyword0, keyword1, keyword2...
 	RecipientIDs []int64           `json:"recipientIds"`
+	RequireAck   bool              `json:"requireAck"`  // 附带一键确认链接
+	ReplyTo      int64             `json:"replyTo"`     // 回复的历史消息 ID，用于串联同一会话
+	Channels     []Channel         `json:"channels"`    // 本次发送额外推送的渠道
+	Tags         []string          `json:"tags"`        // 用于匹配路由规则
+	Priority     string            `json:"priority"`    // low / normal / high / urgent，默认 normal
+	AppID        int64             `json:"appId"`       // 通过指定公众号发送，0 则使用模板所属的公众号
+	URL          string            `json:"url"`         // 点击消息打开的链接，不能与 requireAck 同时使用
+	Miniprogram  *Miniprogram      `json:"miniprogram"` // 点击消息跳转的小程序，不能与 requireAck 同时使用
+	Mode         string            `json:"mode"`        // template（默认）或 text（客服文本消息，需在用户 48 小时内互动过）
+	MediaID      string            `json:"mediaId"`     // 随文本消息发送的图片临时素材，仅 text 模式
+	Canary       *CanaryOptions    `json:"canary"`      // 先试发给部分接收者，确认无误后再发送其余接收者
+}
+
+// CanaryOptions sends a broadcast to a slice of its recipients first and continues with the rest
+// only if that slice went through
+type CanaryOptions struct {
+	Percent     int   `json:"percent"`     // 先发送给此比例（1-50）的接收者，与 groupId 二选一
+	GroupID     int64 `json:"groupId"`     // 先发送给接收者中属于此分组的人
+	WaitSeconds int   `json:"waitSeconds"` // 等待多久后发送其余接收者，默认 300，最长 24 小时
+	MaxFailures int   `json:"maxFailures"` // 试发失败超过此数则取消其余发送，默认 0
+	MinAcks     int   `json:"minAcks"`     // 试发中至少多少人确认才继续，需 requireAck
+}
+
+// CanaryGate holds back the rest of a canary broadcast until the canary send has been checked
+type CanaryGate struct {
+	MessageID    int64     `json:"messageId"`    // 试发的历史记录
+	RecipientIDs []int64   `json:"recipientIds"` // 试发的接收者
+	MaxFailures  int       `json:"maxFailures"`
+	MinAcks      int       `json:"minAcks"`
+	StartedAt    time.Time `json:"startedAt"`
 }
 
 // MessageTemplate represents a WeChat message template
@@ -26,13 +169,182 @@ type MessageTemplate struct {
 	Key        string `json:"key"`        // 模板标识（如 "订单通知"）
 	TemplateID string `json:"templateId"` // 微信模板ID
 	Name       string `json:"name"`       // 模板名称
+	AppID      int64  `json:"appId"`      // 模板所属的公众号（WeChatApp.ID），0 为默认公众号
+	IsDefault  bool   `json:"isDefault"`  // 未指定 templateKey 的发送使用此模板，最多一个
+	Mode       string `json:"mode"`       // template（模板消息）或 subscribe（订阅通知，subscribe/bizsend）
+
+	DefaultGroupIDs []int64 `json:"defaultGroupIds"` // 未指定接收者时默认发送的分组
+
+	// 从微信后台同步的模板信息
+	Title           string     `json:"title"`           // 模板标题
+	Industry        string     `json:"industry"`        // 所属行业，如 "IT科技/互联网|电子商务"
+	Fields          []string   `json:"fields"`          // 模板内容中的字段，如 first、keyword1、remark；发送时按此校验关键词
+	UpstreamMissing bool       `json:"upstreamMissing"` // 微信后台已找不到该模板ID
+	SyncedAt        *time.Time `json:"syncedAt"`
 }
 
+// Template send modes
+const (
+	TemplateModeTemplate  = "template"  // 模板消息，message/template/send
+	TemplateModeSubscribe = "subscribe" // 一次性订阅通知，message/subscribe/bizsend
+)
+
+// Send modes selectable on the send APIs
+const (
+	SendModeTemplate = "template" // 按模板的发送方式发送
+	SendModeText     = "text"     // 客服文本消息，message/custom/send
+)
+
+// TextTemplateKey is the template key recorded for text sends that don't name a template
+const TextTemplateKey = "text"
+
 // WeChatTemplateMessage represents a WeChat template message
 type WeChatTemplateMessage struct {
-	ToUser     string                 `json:"touser"`
-	TemplateID string                 `json:"template_id"`
-	Data       map[string]interface{} `json:"data"`
+	ToUser      string                 `json:"touser"`
+	TemplateID  string                 `json:"template_id"`
+	URL         string                 `json:"url,omitempty"`
+	Miniprogram *Miniprogram           `json:"miniprogram,omitempty"`
+	Data        map[string]interface{} `json:"data"`
+	Mode        string                 `json:"mode,omitempty"`    // subscribe or text, empty for a template message; not part of the payload
+	Content     string                 `json:"content,omitempty"` // Body of a text message
+	MediaID     string                 `json:"mediaId,omitempty"` // Image sent after a text message
+}
+
+// WeChatSubscribeMessage is the body of a subscribe/bizsend call. It differs from a template
+// message only in naming the jump URL page.
+type WeChatSubscribeMessage struct {
+	ToUser      string                 `json:"touser"`
+	TemplateID  string                 `json:"template_id"`
+	Page        string                 `json:"page,omitempty"`
+	Miniprogram *Miniprogram           `json:"miniprogram,omitempty"`
+	Data        map[string]interface{} `json:"data"`
+}
+
+// WeChatTextMessage is the body of a customer service text message (客服消息), which WeChat only
+// delivers within 48 hours of the user's last interaction with the account
+type WeChatTextMessage struct {
+	ToUser  string `json:"touser"`
+	MsgType string `json:"msgtype"`
+	Text    struct {
+		Content string `json:"content"`
+	} `json:"text"`
+}
+
+// WeChatImageMessage is the body of a customer service image message
+type WeChatImageMessage struct {
+	ToUser  string `json:"touser"`
+	MsgType string `json:"msgtype"`
+	Image   struct {
+		MediaID string `json:"media_id"`
+	} `json:"image"`
+}
+
+// WeChatMedia is temporary media (临时素材) uploaded to an official account
+type WeChatMedia struct {
+	Type      string    `json:"type"`
+	MediaID   string    `json:"mediaId"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"` // 临时素材保存 3 天
+}
+
+// WeChat callback event types
+const (
+	WeChatEventSubscribe   = "subscribe"
+	WeChatEventUnsubscribe = "unsubscribe"
+	WeChatEventScan        = "SCAN" // 已关注的用户扫描带参数二维码
+)
+
+// WeChatEvent is a message WeChat pushes to the callback URL (服务器配置). Events carry
+// MsgType "event"; EventKey is "qrscene_<scene>" when a subscribe came from a QR code.
+type WeChatEvent struct {
+	XMLName      xml.Name `xml:"xml"`
+	ToUserName   string   `xml:"ToUserName"` // 公众号原始 ID
+	FromUserName string   `xml:"FromUserName"`
+	CreateTime   int64    `xml:"CreateTime"`
+	MsgType      string   `xml:"MsgType"`
+	Event        string   `xml:"Event"`
+	EventKey     string   `xml:"EventKey"`
+	Ticket       string   `xml:"Ticket"`
+}
+
+// WeChatUserInfo is a follower's basic info from cgi-bin/user/info
+type WeChatUserInfo struct {
+	Subscribe  int    `json:"subscribe"` // 0 表示未关注，此时其余字段为空
+	OpenID     string `json:"openid"`
+	Nickname   string `json:"nickname"`
+	HeadImgURL string `json:"headimgurl"`
+	Language   string `json:"language"` // 如 zh_CN、en
+}
+
+// QRBinding is a parameterized QR code: whoever scans it is bound to a recipient and joins groups
+type QRBinding struct {
+	ID          int64      `json:"id"`
+	Scene       string     `json:"scene"`       // 二维码场景值（scene_str）
+	Name        string     `json:"name"`        // 新建接收者的名称，为空则使用微信昵称
+	RecipientID int64      `json:"recipientId"` // 将扫码用户的 OpenID 绑定到此接收者，0 则新建接收者
+	GroupIDs    []int64    `json:"groupIds"`    // 绑定后加入的分组
+	AppID       int64      `json:"appId"`       // 生成二维码的公众号
+	ImageURL    string     `json:"imageUrl"`    // 二维码图片地址
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	BoundAt     *time.Time `json:"boundAt,omitempty"` // 二维码只能绑定一次
+	BoundOpenID string     `json:"boundOpenId,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+// FollowerSyncReport compares the followers of an official account with its recipients
+type FollowerSyncReport struct {
+	AppID     int64       `json:"appId"`
+	Followers int         `json:"followers"` // 公众号的关注者总数
+	New       []Recipient `json:"new"`       // 尚未登记的关注者，同步时新建为接收者
+	Removed   []Recipient `json:"removed"`   // 已不再关注的接收者，同步时标记为已取消关注
+	Returned  []Recipient `json:"returned"`  // 标记为已取消关注但仍在关注的接收者，同步时清除标记
+	Applied   bool        `json:"applied"`
+}
+
+// ProfileRefreshReport summarizes fetching the WeChat profiles of recipients
+type ProfileRefreshReport struct {
+	Refreshed    int      `json:"refreshed"`
+	NotFollowing int      `json:"notFollowing"` // 未关注公众号，微信不返回资料
+	Failed       int      `json:"failed"`
+	Errors       []string `json:"errors"`
+}
+
+// RateLimit limits how many requests a webhook caller can send
+type RateLimit struct {
+	Rate  int `json:"rate"`  // 每秒允许的请求数
+	Burst int `json:"burst"` // 突发请求上限
+}
+
+// RetiredToken is a replaced token that stays valid until its grace period ends. Only the
+// token's hash is kept.
+type RetiredToken struct {
+	Hash      string    `json:"hash"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// CallbackConfig configures the WeChat callback URL (服务器配置)
+type CallbackConfig struct {
+	Token          string `json:"token"`          // 服务器配置中填写的 Token，用于校验签名
+	EncodingAESKey string `json:"encodingAESKey"` // 消息加解密密钥，安全模式或兼容模式下需要
+	AutoRegister   bool   `json:"autoRegister"`   // 用户关注或扫码时自动添加为接收者
+}
+
+// Miniprogram is the mini program a template message opens when tapped; WeChat falls back to
+// the URL on clients that can't open mini programs
+type Miniprogram struct {
+	AppID    string `json:"appid"`
+	PagePath string `json:"pagepath,omitempty"` // 小程序页面路径，可带参数，为空时打开首页
+}
+
+// Acknowledgement tracks a recipient confirming receipt of a message
+type Acknowledgement struct {
+	ID             int64      `json:"id"`
+	Token          string     `json:"-"`
+	RecipientID    int64      `json:"recipientId"`
+	RecipientName  string     `json:"recipientName"`
+	TemplateKey    string     `json:"templateKey"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	AcknowledgedAt *time.Time `json:"acknowledgedAt,omitempty"`
 }
 
 // WeChatAPIResponse represents a response from WeChat API
@@ -42,12 +354,24 @@ type WeChatAPIResponse struct {
 	MsgID   int64  `json:"msgid,omitempty"`
 }
 
+// WeChatPrivateTemplate is a template added to the account, as listed by get_all_private_template
+type WeChatPrivateTemplate struct {
+	TemplateID      string `json:"template_id"`
+	Title           string `json:"title"`
+	PrimaryIndustry string `json:"primary_industry"`
+	DeputyIndustry  string `json:"deputy_industry"`
+	Content         string `json:"content"`
+	Example         string `json:"example"`
+}
+
 // ApiResponse represents a generic API response
 type ApiResponse struct {
 	Success bool        `json:"success"`
 	Data    interface{} `json:"data,omitempty"`
 	Error   string      `json:"error,omitempty"`
 	Code    string      `json:"code,omitempty"`
+
+	Warnings []string `json:"warnings,omitempty"` // 不影响本次请求、但需要处理的配置问题，界面以横幅展示
 }
 
 // WeChatConfig represents WeChat test account configuration
@@ -56,3 +380,389 @@ type WeChatConfig struct {
 	AppSecret  string `json:"appSecret"`
 	TemplateID string `json:"templateId"`
 }
+
+// WeChatApp is an additional official account. Templates and sends select it by ID; ID 0 is
+// the default account configured through WeChatConfig.
+type WeChatApp struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name"`
+	AppID      string    `json:"appId"`
+	AppSecret  string    `json:"appSecret"`
+	TemplateID string    `json:"templateId"` // 该公众号的默认模板ID
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// StatsExportConfig configures the daily export of aggregate delivery stats; an empty URL or
+// directory disables that target
+type StatsExportConfig struct {
+	RemoteWriteURL      string `json:"remoteWriteUrl"` // Prometheus remote-write 地址
+	RemoteWriteUsername string `json:"remoteWriteUsername"`
+	RemoteWritePassword string `json:"remoteWritePassword"`
+	CSVDir              string `json:"csvDir"` // 每天写入一个 CSV 文件的目录
+}
+
+// VolumeAlertConfig configures alerts on sudden jumps in a token's send volume, which can mean a
+// runaway script or a leaked token
+type VolumeAlertConfig struct {
+	Enabled         bool              `json:"enabled"`
+	WindowMinutes   int               `json:"windowMinutes"`   // 统计窗口，默认 10
+	BaselineWindows int               `json:"baselineWindows"` // 基线取此前多少个窗口的平均值，默认 12
+	Factor          float64           `json:"factor"`          // 当前窗口达到基线的多少倍即告警，默认 5
+	MinMessages     int               `json:"minMessages"`     // 当前窗口至少多少条才告警，避免低流量误报，默认 20
+	CooldownMinutes int               `json:"cooldownMinutes"` // 同一 token 两次告警的最短间隔，默认 60
+	TemplateKey     string            `json:"templateKey"`
+	GroupIDs        []int64           `json:"groupIds"`
+	Keywords        map[string]string `json:"keywords"` // 支持 {{token}} {{count}} {{baseline}} {{window}}
+}
+
+// TokenVolume is a token's send volume in the latest window against its baseline
+type TokenVolume struct {
+	TokenName string  `json:"tokenName"`
+	Count     int     `json:"count"`
+	Baseline  float64 `json:"baseline"` // 此前各窗口的平均条数
+	Anomalous bool    `json:"anomalous"`
+}
+
+// Directory sync sources
+const (
+	DirectorySourceCSV  = "csv"
+	DirectorySourceJSON = "json"
+	DirectorySourceLDAP = "ldap"
+)
+
+// DirectorySyncConfig configures the periodic pull of recipients from an external directory
+type DirectorySyncConfig struct {
+	Enabled              bool             `json:"enabled"`
+	Source               string           `json:"source"` // csv / json（ldap 暂不支持）
+	URL                  string           `json:"url"`
+	AuthHeader           string           `json:"authHeader"`      // 请求时附带的 Authorization 头
+	IntervalMinutes      int              `json:"intervalMinutes"` // 同步间隔，默认 60
+	ItemsPath            string           `json:"itemsPath"`       // JSON 中记录数组的路径，如 data.users，为空时取根数组
+	Mapping              DirectoryMapping `json:"mapping"`
+	Deactivate           bool             `json:"deactivate"`           // 静音目录中已不存在的已同步接收者
+	MaxDeactivatePercent int              `json:"maxDeactivatePercent"` // 单次同步最多静音的已同步接收者比例（%），默认 20；不超过 5 个时不限
+}
+
+// DirectoryMapping names the source field (CSV column or dotted JSON path) of each recipient field
+type DirectoryMapping struct {
+	OpenID   string            `json:"openId"` // 默认 openId
+	Name     string            `json:"name"`   // 默认 name
+	Email    string            `json:"email"`
+	Metadata map[string]string `json:"metadata"` // 接收者 metadata 键 → 源字段
+}
+
+// DirectorySyncPlan lists the changes reconciling recipients with the directory
+type DirectorySyncPlan struct {
+	Create     []Recipient `json:"create"`
+	Update     []Recipient `json:"update"`     // 包括重新出现而恢复的接收者
+	Deactivate []Recipient `json:"deactivate"` // 目录中已不存在，将被静音
+	Skipped    int         `json:"skipped"`    // 缺少 OpenID 或重复的记录数
+	Applied    bool        `json:"applied"`
+}
+
+// DailyTemplateStats aggregates one day of message history for a template
+type DailyTemplateStats struct {
+	Date        string `json:"date"` // 2006-01-02
+	TemplateKey string `json:"templateKey"`
+	Messages    int    `json:"messages"`
+	Sent        int    `json:"sent"`
+	Failed      int    `json:"failed"`
+	Skipped     int    `json:"skipped"`
+	Throttled   int    `json:"throttled"`
+}
+
+// CanaryConfig designates a test recipient that receives a message with the new settings
+// before WeChat credentials or the default template are changed; the change is only saved if it arrives
+type CanaryConfig struct {
+	Enabled     bool  `json:"enabled"`
+	RecipientID int64 `json:"recipientId"`
+}
+
+// EmailGatewayConfig configures the inbound email-to-notification gateway
+type EmailGatewayConfig struct {
+	Enabled        bool              `json:"enabled"`
+	Token          string            `json:"token,omitempty"` // 入站地址中的密钥；只保存哈希，明文仅在生成时返回一次
+	Address        string            `json:"address"`         // 专用收件地址，为空则不校验
+	AllowedSenders []string          `json:"allowedSenders"`  // 允许的发件人，为空则不限制
+	TemplateKey    string            `json:"templateKey"`
+	RecipientIDs   []int64           `json:"recipientIds"` // 为空则发送给所有人
+	FieldMap       map[string]string `json:"fieldMap"`     // 模板字段 -> subject/from/body
+}
+
+// ServerChanConfig configures the Server酱-compatible /<SendKey>.send endpoint
+type ServerChanConfig struct {
+	Enabled      bool              `json:"enabled"`
+	SendKey      string            `json:"sendKey"` // 推送地址中的 SendKey
+	TemplateKey  string            `json:"templateKey"`
+	RecipientIDs []int64           `json:"recipientIds"` // 为空则发送给所有人
+	FieldMap     map[string]string `json:"fieldMap"`     // 模板字段 -> title/desp/short
+}
+
+// Hosted push services whose inbound API can be mimicked by a compat endpoint, and NAS systems
+// whose notification webhooks are accepted the same way
+const (
+	CompatPushPlus   = "pushplus"
+	CompatWxPusher   = "wxpusher"
+	CompatSynology   = "synology"   // Synology DSM 自定义 Webhook 通知
+	CompatQNAP       = "qnap"       // QNAP 通知中心 Webhook
+	CompatWatchtower = "watchtower" // Watchtower 容器更新通知（shoutrrr generic webhook）
+	CompatDiun       = "diun"       // Diun 镜像更新 Webhook
+	CompatGrafana    = "grafana"    // Grafana 告警 Webhook 联络点
+	CompatGitHub     = "github"     // GitHub 仓库 Webhook，token 作为签名密钥（Secret）
+	CompatGitLab     = "gitlab"     // GitLab 项目 Webhook，token 作为 Secret token
+	CompatSentry     = "sentry"     // Sentry 问题告警 Webhook
+	CompatZabbix     = "zabbix"     // Zabbix Webhook 媒介类型
+	CompatJenkins    = "jenkins"    // Jenkins Notification 插件构建通知
+	CompatArgoCD     = "argocd"     // Argo CD Notifications Webhook
+)
+
+// CompatEndpointConfig configures an endpoint that accepts another push service's request format
+type CompatEndpointConfig struct {
+	Enabled          bool              `json:"enabled"`
+	Token            string            `json:"token"` // 对应服务的 token（PushPlus）或 appToken（WxPusher）
+	TemplateKey      string            `json:"templateKey"`
+	RecipientIDs     []int64           `json:"recipientIds"`     // 为空则发送给所有人
+	FieldMap         map[string]string `json:"fieldMap"`         // 模板字段 -> title/content/summary；NAS 另有 hostname，QNAP 另有 level/category，容器更新另有 host/count，Grafana 为 title/state/content/matches，GitHub / GitLab 为 title/content/repo/sender/event，Sentry 为 title/project/level/content，Zabbix 为 title/name/status/severity/host/time/event_id/content，Jenkins 为 title/job/number/status/content，Argo CD 为 title/app/project/sync/health/phase/revision/content
+	KeywordTemplates map[string]string `json:"keywordTemplates"` // 模板字段 -> Go text/template，以 .body（请求 JSON 或表单）、.query、.parts（上述字段）为数据，优先于 fieldMap
+	GroupSeconds     int               `json:"groupSeconds"`     // 容器更新：同一主机在此秒数内的更新合并为一条消息，0 则逐条发送
+}
+
+// CustomEndpoint is an ingest endpoint defined by an admin, taking any JSON at
+// /api/webhook/custom/:slug and mapping it to template keywords
+type CustomEndpoint struct {
+	ID               int64             `json:"id"`
+	Name             string            `json:"name"`
+	Slug             string            `json:"slug"`
+	Token            string            `json:"token,omitempty"` // 以 Bearer token 发送；只保存哈希，明文仅在创建或重新生成时返回一次
+	TemplateKey      string            `json:"templateKey"`
+	RecipientIDs     []int64           `json:"recipientIds"`     // 为空则发送给所有人
+	KeywordTemplates map[string]string `json:"keywordTemplates"` // 模板字段 -> Go text/template，以 .body（请求 JSON 或表单）、.text、.query 为数据
+	Enabled          bool              `json:"enabled"`
+	CreatedAt        time.Time         `json:"createdAt"`
+}
+
+// Feed is an RSS/Atom feed polled for new entries
+type Feed struct {
+	ID              int64             `json:"id"`
+	Name            string            `json:"name"`
+	URL             string            `json:"url"`
+	IntervalSeconds int               `json:"intervalSeconds"`
+	TemplateKey     string            `json:"templateKey"`
+	GroupIDs        []int64           `json:"groupIds"`
+	Keywords        map[string]string `json:"keywords"` // 支持 {{title}} {{link}} {{summary}} {{published}} {{feed}}
+	Enabled         bool              `json:"enabled"`
+	LastPolledAt    *time.Time        `json:"lastPolledAt,omitempty"`
+	LastError       string            `json:"lastError,omitempty"`
+	SeenIDs         []string          `json:"-"`
+}
+
+// Monitor status values
+const (
+	MonitorStatusUnknown = "unknown"
+	MonitorStatusUp      = "up"
+	MonitorStatusDown    = "down"
+)
+
+// Monitor is an HTTP endpoint checked periodically for availability
+type Monitor struct {
+	ID              int64             `json:"id"`
+	Name            string            `json:"name"`
+	URL             string            `json:"url"`
+	Method          string            `json:"method"`
+	ExpectedStatus  int               `json:"expectedStatus"`
+	IntervalSeconds int               `json:"intervalSeconds"`
+	TimeoutSeconds  int               `json:"timeoutSeconds"`
+	TemplateKey     string            `json:"templateKey"`
+	GroupIDs        []int64           `json:"groupIds"`
+	Keywords        map[string]string `json:"keywords"` // 支持 {{monitor}} {{url}} {{status}} {{statusCode}} {{error}} {{responseTime}} {{checkedAt}}
+	Enabled         bool              `json:"enabled"`
+	Status          string            `json:"status"`
+	LastCheckedAt   *time.Time        `json:"lastCheckedAt,omitempty"`
+	LastChangedAt   *time.Time        `json:"lastChangedAt,omitempty"`
+	LastError       string            `json:"lastError,omitempty"`
+}
+
+// Heartbeat is a dead-man's switch pinged by an external job; a missed deadline triggers a notification
+type Heartbeat struct {
+	ID              int64             `json:"id"`
+	Name            string            `json:"name"`
+	PingKey         string            `json:"pingKey"`
+	IntervalSeconds int               `json:"intervalSeconds"`
+	GraceSeconds    int               `json:"graceSeconds"`
+	TemplateKey     string            `json:"templateKey"`
+	GroupIDs        []int64           `json:"groupIds"`
+	Keywords        map[string]string `json:"keywords"` // 支持 {{heartbeat}} {{status}} {{lastPing}} {{checkedAt}}
+	Enabled         bool              `json:"enabled"`
+	Status          string            `json:"status"`
+	LastPingAt      *time.Time        `json:"lastPingAt,omitempty"`
+	LastChangedAt   *time.Time        `json:"lastChangedAt,omitempty"`
+	CreatedAt       time.Time         `json:"createdAt"`
+}
+
+// Calendar is an ICS calendar, linked by URL or uploaded, whose upcoming events trigger reminders
+type Calendar struct {
+	ID            int64             `json:"id"`
+	Name          string            `json:"name"`
+	URL           string            `json:"url,omitempty"`
+	Content       string            `json:"-"`
+	LeadMinutes   []int             `json:"leadMinutes"`
+	TemplateKey   string            `json:"templateKey"`
+	GroupIDs      []int64           `json:"groupIds"`
+	Keywords      map[string]string `json:"keywords"` // 支持 {{event}} {{start}} {{location}} {{description}} {{lead}} {{calendar}}
+	Enabled       bool              `json:"enabled"`
+	LastFetchedAt *time.Time        `json:"lastFetchedAt,omitempty"`
+	LastError     string            `json:"lastError,omitempty"`
+	SentKeys      []string          `json:"-"`
+}
+
+// Message is a history record of one send, optionally threaded onto an earlier message
+type Message struct {
+	ID             int64             `json:"id"`
+	TemplateKey    string            `json:"templateKey"`
+	Keywords       map[string]string `json:"keywords"`
+	ReplyTo        *int64            `json:"replyTo,omitempty"`
+	ThreadID       int64             `json:"threadId"`
+	TotalCount     int               `json:"totalCount"`
+	TotalSent      int               `json:"totalSent"`
+	TotalFailed    int               `json:"totalFailed"`
+	TotalSkipped   int               `json:"totalSkipped"`
+	TotalThrottled int               `json:"totalThrottled"`
+	Results        json.RawMessage   `json:"results,omitempty"`
+	Sandbox        bool              `json:"sandbox"` // 通过测试号发送
+	AppID          int64             `json:"appId"`   // 发送使用的公众号，0 为默认公众号
+	CreatedAt      time.Time         `json:"createdAt"`
+
+	// 仅历史接口返回：距今秒数与相对时间，如「5 分钟前」
+	AgeSeconds      int64  `json:"ageSeconds,omitempty"`
+	CreatedRelative string `json:"createdRelative,omitempty"`
+
+	MessageSource
+}
+
+// Inbound adapters recorded as a message's source
+const (
+	SourceWebhook    = "webhook"
+	SourceEmail      = "email"
+	SourceServerChan = "serverchan"
+)
+
+// MessageSource records where an inbound (webhook-style) send came from; empty for sends from the UI or background jobs
+type MessageSource struct {
+	Adapter   string `json:"adapter,omitempty"`   // webhook / email / serverchan / pushplus / wxpusher / synology / qnap / watchtower / diun / grafana / github / gitlab / sentry / zabbix / jenkins / argocd
+	TokenName string `json:"tokenName,omitempty"` // 调用方使用的令牌
+	SourceIP  string `json:"sourceIp,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+}
+
+// Scheduled message states
+const (
+	ScheduledStatusPending   = "pending"
+	ScheduledStatusSending   = "sending"
+	ScheduledStatusSent      = "sent"
+	ScheduledStatusFailed    = "failed"
+	ScheduledStatusCancelled = "cancelled"
+	ScheduledStatusAborted   = "aborted" // 金丝雀试发未通过，其余部分未发送
+)
+
+// ScheduledMessage is a webhook send held back until SendAt
+type ScheduledMessage struct {
+	ID           int64             `json:"id"`
+	TemplateKey  string            `json:"templateKey"`
+	Keywords     map[string]string `json:"keywords"`
+	RecipientIDs []int64           `json:"recipientIds"` // 为空时发送给模板默认分组或所有接收者，在发送时解析
+	RequireAck   bool              `json:"requireAck"`
+	ReplyTo      int64             `json:"replyTo,omitempty"`
+	Channels     []Channel         `json:"channels"`
+	Tags         []string          `json:"tags"`
+	Priority     string            `json:"priority"`
+	AppID        int64             `json:"appId,omitempty"`
+	URL          string            `json:"url,omitempty"`
+	Miniprogram  *Miniprogram      `json:"miniprogram,omitempty"`
+	Mode         string            `json:"mode,omitempty"`
+	MediaID      string            `json:"mediaId,omitempty"`
+	SendAt       time.Time         `json:"sendAt"`
+	Status       string            `json:"status"`
+	MessageID    *int64            `json:"messageId,omitempty"`
+	LastError    string            `json:"lastError,omitempty"`
+	CreatedAt    time.Time         `json:"createdAt"`
+	Source       MessageSource     `json:"source"`           // 发起定时发送的入站请求，发送时写入历史记录
+	Canary       *CanaryGate       `json:"canary,omitempty"` // 金丝雀发送的其余部分，发送前检查试发结果
+}
+
+// HeldSend is a send received during maintenance mode, dispatched once maintenance ends
+type HeldSend struct {
+	ID            int64             `json:"id"`
+	TemplateKey   string            `json:"templateKey"`
+	Keywords      map[string]string `json:"keywords"`
+	RecipientIDs  []int64           `json:"recipientIds"` // 收到请求时解析出的接收者
+	RequireAck    bool              `json:"requireAck"`
+	ReplyTo       int64             `json:"replyTo,omitempty"`
+	Channels      []Channel         `json:"channels"`
+	GroupChannels []Channel         `json:"groupChannels"` // 所发送分组的渠道，可访问内网地址
+	Tags          []string          `json:"tags"`
+	Priority      string            `json:"priority"`
+	AppID         int64             `json:"appId,omitempty"`
+	URL           string            `json:"url,omitempty"`
+	Miniprogram   *Miniprogram      `json:"miniprogram,omitempty"`
+	Mode          string            `json:"mode,omitempty"`
+	MediaID       string            `json:"mediaId,omitempty"`
+	Source        MessageSource     `json:"source"`
+	LastError     string            `json:"lastError,omitempty"` // 上次放行失败的原因，放行时会再次尝试
+	CreatedAt     time.Time         `json:"createdAt"`
+}
+
+// SendJob is a webhook send queued with ?async=true. It's stored so a send queued or running when
+// the process stops is run again after a restart instead of being lost.
+type SendJob struct {
+	ID         int64           `json:"id"`
+	Status     string          `json:"status"`
+	Result     json.RawMessage `json:"result,omitempty"` // 发送完成后的结果
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+
+	Owner   string `json:"-"` // 提交任务的令牌名称，只有它能查询任务
+	Request string `json:"-"` // 待发送的内容（JSON），重启后据此重新发送
+}
+
+// Retry queue states
+const (
+	RetryStatusPending   = "pending"
+	RetryStatusSending   = "sending"
+	RetryStatusSucceeded = "succeeded"
+	RetryStatusExhausted = "exhausted"
+)
+
+// SendRetry is a WeChat message that failed with a transient error and is re-sent with exponential backoff
+type SendRetry struct {
+	ID            int64                 `json:"id"`
+	MessageID     int64                 `json:"messageId,omitempty"` // 对应的消息历史 ID
+	RecipientID   int64                 `json:"recipientId"`
+	AppID         int64                 `json:"appId,omitempty"` // 发送所用的公众号，0 为默认公众号
+	Message       WeChatTemplateMessage `json:"message"`
+	Attempts      int                   `json:"attempts"` // 已重试次数，不含首次发送
+	NextAttemptAt time.Time             `json:"nextAttemptAt"`
+	Status        string                `json:"status"`
+	LastError     string                `json:"lastError,omitempty"`
+	CreatedAt     time.Time             `json:"createdAt"`
+	UpdatedAt     time.Time             `json:"updatedAt"`
+}
+
+// DeadLetter is a WeChat message that used up its retries, kept for inspection and manual replay
+type DeadLetter struct {
+	ID          int64                 `json:"id"`
+	RetryID     int64                 `json:"retryId"`
+	MessageID   int64                 `json:"messageId,omitempty"`
+	RecipientID int64                 `json:"recipientId"`
+	AppID       int64                 `json:"appId,omitempty"`
+	Message     WeChatTemplateMessage `json:"message"`
+	Attempts    int                   `json:"attempts"`
+	LastError   string                `json:"lastError"`
+	CreatedAt   time.Time             `json:"createdAt"`
+}
+
+// MessageDetail is a message together with every message in its thread, oldest first
+type MessageDetail struct {
+	Message
+	Thread []Message `json:"thread"`
+}
@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"strings"
+	"time"
+
+	"wechat-notification/models"
+)
+
+// RecordDeliveries updates recipient activity after a send: delivered recipients get their last delivery
+// time set and their unsubscribed flag and failure count reset, unsubscribed recipients are flagged and
+// have the count incremented
+func (r *SQLiteRepository) RecordDeliveries(delivered, unsubscribed []int64) error {
+	if len(delivered) == 0 && len(unsubscribed) == 0 {
+		return nil
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	for _, id := range delivered {
+		if _, err := tx.Exec("UPDATE recipients SET last_delivered_at = ?, unsubscribed_failures = 0, unsubscribed = 0 WHERE id = ?", now, id); err != nil {
+			return err
+		}
+	}
+	for _, id := range unsubscribed {
+		if _, err := tx.Exec("UPDATE recipients SET unsubscribed_failures = unsubscribed_failures + 1, unsubscribed = 1 WHERE id = ?", id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SetUnsubscribed flags or clears the recipient with the given OpenID as no longer following the
+// account, returning whether such a recipient exists
+func (r *SQLiteRepository) SetUnsubscribed(openID string, unsubscribed bool) (bool, error) {
+	result, err := r.db.Exec("UPDATE recipients SET unsubscribed = ?, updated_at = ? WHERE open_id = ?", unsubscribed, time.Now(), openID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// GetStaleRecipients retrieves unmuted recipients whose last minFailures or more sends failed because
+// they no longer follow the account, longest failing first
+func (r *SQLiteRepository) GetStaleRecipients(minFailures int) ([]models.Recipient, error) {
+	rows, err := r.db.Query("SELECT "+recipientColumns+" FROM recipients WHERE muted = 0 AND unsubscribed_failures >= ? ORDER BY unsubscribed_failures DESC, id", minFailures)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recipients := []models.Recipient{}
+	for rows.Next() {
+		var rec models.Recipient
+		if err := scanRecipient(rows, &rec); err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, rec)
+	}
+	return recipients, rows.Err()
+}
+
+// MuteRecipients mutes the given recipients, returning how many were changed
+func (r *SQLiteRepository) MuteRecipients(ids []int64) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, 0, len(ids)+1)
+	args = append(args, time.Now())
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args = append(args, id)
+	}
+	result, err := r.db.Exec("UPDATE recipients SET muted = 1, updated_at = ? WHERE muted = 0 AND id IN ("+strings.Join(placeholders, ",")+")", args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"testing"
+
+	"wechat-notification/models"
+)
+
+func TestStaleRecipients(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	var ids []int64
+	for _, openID := range []string{"o_active", "o_gone", "o_flaky"} {
+		r := &models.Recipient{OpenID: openID, Name: openID}
+		if err := repo.Create(r); err != nil {
+			t.Fatalf("Create error: %v", err)
+		}
+		ids = append(ids, r.ID)
+	}
+	active, gone, flaky := ids[0], ids[1], ids[2]
+
+	for i := 0; i < 3; i++ {
+		if err := repo.RecordDeliveries([]int64{active}, []int64{gone, flaky}); err != nil {
+			t.Fatalf("RecordDeliveries error: %v", err)
+		}
+	}
+	// A successful delivery resets the count
+	if err := repo.RecordDeliveries([]int64{flaky}, nil); err != nil {
+		t.Fatalf("RecordDeliveries error: %v", err)
+	}
+
+	stale, err := repo.GetStaleRecipients(3)
+	if err != nil {
+		t.Fatalf("GetStaleRecipients error: %v", err)
+	}
+	if len(stale) != 1 || stale[0].ID != gone || stale[0].UnsubscribedFailures != 3 || stale[0].LastDeliveredAt != nil {
+		t.Fatalf("unexpected stale recipients: %+v", stale)
+	}
+	if r, _ := repo.GetByID(active); r.LastDeliveredAt == nil || r.UnsubscribedFailures != 0 || r.Unsubscribed {
+		t.Errorf("active recipient not tracked: %+v", r)
+	}
+	if r, _ := repo.GetByID(flaky); r.Unsubscribed {
+		t.Errorf("a successful delivery should clear the unsubscribed flag: %+v", r)
+	}
+	if r, _ := repo.GetByID(gone); !r.Unsubscribed {
+		t.Errorf("unsubscribed recipient should be flagged: %+v", r)
+	}
+
+	muted, err := repo.MuteRecipients([]int64{gone})
+	if err != nil || muted != 1 {
+		t.Fatalf("MuteRecipients = %d, %v", muted, err)
+	}
+	if stale, _ := repo.GetStaleRecipients(3); len(stale) != 0 {
+		t.Errorf("muted recipients should leave the stale report: %+v", stale)
+	}
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"wechat-notification/models"
+)
+
+const wechatAppColumns = "id, name, app_id, app_secret, template_id, created_at"
+
+func scanWeChatApp(row rowScanner, a *models.WeChatApp) error {
+	return row.Scan(&a.ID, &a.Name, &a.AppID, &a.AppSecret, &a.TemplateID, &a.CreatedAt)
+}
+
+// CreateWeChatApp adds an official account
+func (r *SQLiteRepository) CreateWeChatApp(app *models.WeChatApp) error {
+	app.CreatedAt = time.Now()
+	result, err := r.db.Exec("INSERT INTO wechat_apps (name, app_id, app_secret, template_id, created_at) VALUES (?, ?, ?, ?, ?)",
+		app.Name, app.AppID, app.AppSecret, app.TemplateID, app.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateAppID
+		}
+		return err
+	}
+	app.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// GetWeChatApps retrieves all official accounts
+func (r *SQLiteRepository) GetWeChatApps() ([]models.WeChatApp, error) {
+	rows, err := r.db.Query("SELECT " + wechatAppColumns + " FROM wechat_apps ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	apps := []models.WeChatApp{}
+	for rows.Next() {
+		var a models.WeChatApp
+		if err := scanWeChatApp(rows, &a); err != nil {
+			return nil, err
+		}
+		apps = append(apps, a)
+	}
+	return apps, rows.Err()
+}
+
+// GetWeChatAppByID retrieves an official account by ID
+func (r *SQLiteRepository) GetWeChatAppByID(id int64) (*models.WeChatApp, error) {
+	var a models.WeChatApp
+	err := scanWeChatApp(r.db.QueryRow("SELECT "+wechatAppColumns+" FROM wechat_apps WHERE id = ?", id), &a)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// UpdateWeChatApp updates an official account's name, credentials and default template
+func (r *SQLiteRepository) UpdateWeChatApp(app *models.WeChatApp) error {
+	result, err := r.db.Exec("UPDATE wechat_apps SET name = ?, app_id = ?, app_secret = ?, template_id = ? WHERE id = ?",
+		app.Name, app.AppID, app.AppSecret, app.TemplateID, app.ID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateAppID
+		}
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteWeChatApp removes an official account, refusing with ErrAppInUse while templates or
+// recipients still belong to it
+func (r *SQLiteRepository) DeleteWeChatApp(id int64) error {
+	var users int
+	err := r.db.QueryRow("SELECT (SELECT COUNT(*) FROM templates WHERE app_id = ?) + (SELECT COUNT(*) FROM recipients WHERE app_id = ?)", id, id).Scan(&users)
+	if err != nil {
+		return err
+	}
+	if users > 0 {
+		return ErrAppInUse
+	}
+
+	result, err := r.db.Exec("DELETE FROM wechat_apps WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
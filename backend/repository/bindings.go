@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"wechat-notification/models"
+)
+
+const qrBindingColumns = "id, scene, name, recipient_id, group_ids, app_id, image_url, expires_at, bound_at, bound_open_id, created_at"
+
+func scanQRBinding(row rowScanner, b *models.QRBinding) error {
+	var groupIDs string
+	var boundAt sql.NullTime
+	if err := row.Scan(&b.ID, &b.Scene, &b.Name, &b.RecipientID, &groupIDs, &b.AppID, &b.ImageURL, &b.ExpiresAt,
+		&boundAt, &b.BoundOpenID, &b.CreatedAt); err != nil {
+		return err
+	}
+	if boundAt.Valid {
+		b.BoundAt = &boundAt.Time
+	}
+	return json.Unmarshal([]byte(groupIDs), &b.GroupIDs)
+}
+
+// CreateQRBinding stores a QR binding
+func (r *SQLiteRepository) CreateQRBinding(b *models.QRBinding) error {
+	groupIDs, _ := json.Marshal(nonNilIDs(b.GroupIDs))
+	b.CreatedAt = time.Now()
+	result, err := r.db.Exec(
+		`INSERT INTO qr_bindings (scene, name, recipient_id, group_ids, app_id, image_url, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		b.Scene, b.Name, b.RecipientID, string(groupIDs), b.AppID, b.ImageURL, b.ExpiresAt, b.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	b.ID = id
+	b.GroupIDs = nonNilIDs(b.GroupIDs)
+	return nil
+}
+
+// GetQRBindingByScene retrieves a QR binding by its scene value
+func (r *SQLiteRepository) GetQRBindingByScene(scene string) (*models.QRBinding, error) {
+	var b models.QRBinding
+	err := scanQRBinding(r.db.QueryRow("SELECT "+qrBindingColumns+" FROM qr_bindings WHERE scene = ?", scene), &b)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// ListQRBindings retrieves every QR binding, newest first
+func (r *SQLiteRepository) ListQRBindings() ([]models.QRBinding, error) {
+	rows, err := r.db.Query("SELECT " + qrBindingColumns + " FROM qr_bindings ORDER BY id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bindings := []models.QRBinding{}
+	for rows.Next() {
+		var b models.QRBinding
+		if err := scanQRBinding(rows, &b); err != nil {
+			return nil, err
+		}
+		bindings = append(bindings, b)
+	}
+	return bindings, rows.Err()
+}
+
+// ClaimQRBinding marks a binding as used by openID, reporting false if it was already used so
+// each QR code binds at most once
+func (r *SQLiteRepository) ClaimQRBinding(id int64, openID string, now time.Time) (bool, error) {
+	result, err := r.db.Exec("UPDATE qr_bindings SET bound_at = ?, bound_open_id = ? WHERE id = ? AND bound_at IS NULL", now, openID, id)
+	if err != nil {
+		return false, err
+	}
+	rows, _ := result.RowsAffected()
+	return rows == 1, nil
+}
+
+// DeleteQRBinding removes a QR binding
+func (r *SQLiteRepository) DeleteQRBinding(id int64) error {
+	result, err := r.db.Exec("DELETE FROM qr_bindings WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"wechat-notification/models"
+)
+
+const calendarColumns = "id, name, url, content, lead_minutes, template_key, group_ids, keywords, enabled, last_fetched_at, last_error, sent_keys"
+
+func scanCalendar(row rowScanner, cal *models.Calendar) error {
+	var leads, groupIDs, keywords, sentKeys string
+	var lastFetched sql.NullTime
+	if err := row.Scan(&cal.ID, &cal.Name, &cal.URL, &cal.Content, &leads, &cal.TemplateKey, &groupIDs, &keywords,
+		&cal.Enabled, &lastFetched, &cal.LastError, &sentKeys); err != nil {
+		return err
+	}
+	if lastFetched.Valid {
+		cal.LastFetchedAt = &lastFetched.Time
+	}
+	if err := json.Unmarshal([]byte(leads), &cal.LeadMinutes); err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(groupIDs), &cal.GroupIDs); err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(keywords), &cal.Keywords); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(sentKeys), &cal.SentKeys)
+}
+
+// CreateCalendar adds a new calendar
+func (r *SQLiteRepository) CreateCalendar(cal *models.Calendar) error {
+	leads, _ := json.Marshal(cal.LeadMinutes)
+	groupIDs, _ := json.Marshal(nonNilIDs(cal.GroupIDs))
+	keywords, _ := json.Marshal(cal.Keywords)
+	result, err := r.db.Exec(
+		"INSERT INTO calendars (name, url, content, lead_minutes, template_key, group_ids, keywords, enabled) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		cal.Name, cal.URL, cal.Content, string(leads), cal.TemplateKey, string(groupIDs), string(keywords), cal.Enabled,
+	)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	cal.ID = id
+	return nil
+}
+
+// UpdateCalendar updates a calendar's settings; an empty content keeps the stored copy of a linked calendar
+func (r *SQLiteRepository) UpdateCalendar(cal *models.Calendar) error {
+	leads, _ := json.Marshal(cal.LeadMinutes)
+	groupIDs, _ := json.Marshal(nonNilIDs(cal.GroupIDs))
+	keywords, _ := json.Marshal(cal.Keywords)
+	result, err := r.db.Exec(`
+		UPDATE calendars SET name = ?, lead_minutes = ?, template_key = ?, group_ids = ?, keywords = ?, enabled = ?,
+			content = CASE WHEN ? != '' THEN ? WHEN url = ? THEN content ELSE '' END,
+			last_fetched_at = CASE WHEN url = ? THEN last_fetched_at ELSE NULL END,
+			url = ?
+		WHERE id = ?`,
+		cal.Name, string(leads), cal.TemplateKey, string(groupIDs), string(keywords), cal.Enabled,
+		cal.Content, cal.Content, cal.URL, cal.URL, cal.URL, cal.ID,
+	)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteCalendar removes a calendar
+func (r *SQLiteRepository) DeleteCalendar(id int64) error {
+	result, err := r.db.Exec("DELETE FROM calendars WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetCalendarByID retrieves a calendar by ID
+func (r *SQLiteRepository) GetCalendarByID(id int64) (*models.Calendar, error) {
+	var cal models.Calendar
+	err := scanCalendar(r.db.QueryRow("SELECT "+calendarColumns+" FROM calendars WHERE id = ?", id), &cal)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cal, nil
+}
+
+// GetAllCalendars retrieves all calendars
+func (r *SQLiteRepository) GetAllCalendars() ([]models.Calendar, error) {
+	rows, err := r.db.Query("SELECT " + calendarColumns + " FROM calendars ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	calendars := []models.Calendar{}
+	for rows.Next() {
+		var cal models.Calendar
+		if err := scanCalendar(rows, &cal); err != nil {
+			return nil, err
+		}
+		calendars = append(calendars, cal)
+	}
+	return calendars, rows.Err()
+}
+
+// UpdateCalendarContent stores a freshly fetched copy of a linked calendar
+func (r *SQLiteRepository) UpdateCalendarContent(id int64, content string, fetchedAt time.Time, lastError string) error {
+	_, err := r.db.Exec(
+		"UPDATE calendars SET content = CASE WHEN ? = '' THEN ? ELSE content END, last_fetched_at = ?, last_error = ? WHERE id = ?",
+		lastError, content, fetchedAt, lastError, id,
+	)
+	return err
+}
+
+// UpdateCalendarSentKeys records which reminders have been sent
+func (r *SQLiteRepository) UpdateCalendarSentKeys(id int64, sentKeys []string) error {
+	if sentKeys == nil {
+		sentKeys = []string{}
+	}
+	sent, _ := json.Marshal(sentKeys)
+	_, err := r.db.Exec("UPDATE calendars SET sent_keys = ? WHERE id = ?", string(sent), id)
+	return err
+}
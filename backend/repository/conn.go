@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// DefaultQueryTimeout bounds each statement unless configured otherwise, so a runaway query or an
+// exhausted connection pool fails requests instead of hanging them. Waiting for another process's
+// lock on the file is bounded separately, by SQLite's busy timeout.
+const DefaultQueryTimeout = 10 * time.Second
+
+// conn runs statements on the database under the repository's context and statement timeout
+type conn struct {
+	db      *sql.DB
+	ctx     context.Context
+	timeout time.Duration
+}
+
+// statementContext returns the context of a single statement
+func (c *conn) statementContext() (context.Context, context.CancelFunc) {
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if c.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+func (c *conn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := c.statementContext()
+	defer cancel()
+	return c.db.ExecContext(ctx, query, args...)
+}
+
+// Query runs a query whose rows can be read until the timeout; closing them releases the context
+func (c *conn) Query(query string, args ...interface{}) (*rows, error) {
+	ctx, cancel := c.statementContext()
+	r, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &rows{Rows: r, cancel: cancel}, nil
+}
+
+// QueryRow runs a query for a single row; scanning it releases the context
+func (c *conn) QueryRow(query string, args ...interface{}) *row {
+	ctx, cancel := c.statementContext()
+	return &row{Row: c.db.QueryRowContext(ctx, query, args...), cancel: cancel}
+}
+
+// Begin starts a transaction that is rolled back if it's still open when the timeout passes
+func (c *conn) Begin() (*sql.Tx, error) {
+	ctx, cancel := c.statementContext()
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if c.timeout > 0 {
+		time.AfterFunc(c.timeout, cancel)
+	}
+	return tx, nil
+}
+
+func (c *conn) Close() error {
+	return c.db.Close()
+}
+
+type rows struct {
+	*sql.Rows
+	cancel context.CancelFunc
+}
+
+func (r *rows) Close() error {
+	defer r.cancel()
+	return r.Rows.Close()
+}
+
+type row struct {
+	*sql.Row
+	cancel context.CancelFunc
+}
+
+func (r *row) Scan(dest ...interface{}) error {
+	defer r.cancel()
+	return r.Row.Scan(dest...)
+}
+
+// WithContext returns a repository whose statements run under ctx, so they are abandoned when
+// the request that issued them goes away. It shares the database and timeout with r.
+func (r *SQLiteRepository) WithContext(ctx context.Context) *SQLiteRepository {
+	return &SQLiteRepository{db: &conn{db: r.db.db, ctx: ctx, timeout: r.db.timeout}}
+}
+
+// SetQueryTimeout sets the timeout of each statement; 0 disables it. Repositories derived with
+// WithContext afterwards inherit it.
+func (r *SQLiteRepository) SetQueryTimeout(timeout time.Duration) {
+	r.db.timeout = timeout
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+)
+
+func TestWithContextCancelled(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := repo.Create(&models.Recipient{OpenID: "o1", Name: "a"}); err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cancelled := repo.WithContext(ctx)
+	if _, err := cancelled.GetAll(); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetAll error = %v, want context.Canceled", err)
+	}
+	if err := cancelled.Create(&models.Recipient{OpenID: "o2", Name: "b"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Create error = %v, want context.Canceled", err)
+	}
+
+	// The repository it was derived from is unaffected
+	if all, err := repo.WithContext(context.Background()).GetAll(); err != nil || len(all) != 1 {
+		t.Errorf("GetAll = %d recipients, %v", len(all), err)
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+	repo.SetQueryTimeout(100 * time.Millisecond)
+
+	// A query that never finishes on its own
+	start := time.Now()
+	var count int
+	err := repo.db.QueryRow("WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c) SELECT COUNT(*) FROM c").Scan(&count)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("runaway query error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("runaway query took %v, want it bounded by the query timeout", elapsed)
+	}
+
+	if _, err := repo.GetAll(); err != nil {
+		t.Errorf("GetAll after timeout: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"wechat-notification/models"
+)
+
+const customEndpointColumns = "id, name, slug, token, template_key, recipient_ids, keyword_templates, enabled, created_at"
+
+func scanCustomEndpoint(row rowScanner, e *models.CustomEndpoint) error {
+	var recipientIDs, keywordTemplates string
+	if err := row.Scan(&e.ID, &e.Name, &e.Slug, &e.Token, &e.TemplateKey, &recipientIDs, &keywordTemplates, &e.Enabled, &e.CreatedAt); err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(recipientIDs), &e.RecipientIDs); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(keywordTemplates), &e.KeywordTemplates)
+}
+
+// CreateCustomEndpoint adds a custom ingest endpoint
+func (r *SQLiteRepository) CreateCustomEndpoint(e *models.CustomEndpoint) error {
+	recipientIDs, _ := json.Marshal(nonNilIDs(e.RecipientIDs))
+	keywordTemplates, _ := json.Marshal(e.KeywordTemplates)
+	e.CreatedAt = time.Now()
+	result, err := r.db.Exec(
+		"INSERT INTO custom_endpoints (name, slug, token, template_key, recipient_ids, keyword_templates, enabled, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		e.Name, e.Slug, e.Token, e.TemplateKey, string(recipientIDs), string(keywordTemplates), e.Enabled, e.CreatedAt,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateSlug
+		}
+		return err
+	}
+	e.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// UpdateCustomEndpoint updates a custom ingest endpoint's settings and token
+func (r *SQLiteRepository) UpdateCustomEndpoint(e *models.CustomEndpoint) error {
+	recipientIDs, _ := json.Marshal(nonNilIDs(e.RecipientIDs))
+	keywordTemplates, _ := json.Marshal(e.KeywordTemplates)
+	result, err := r.db.Exec(
+		"UPDATE custom_endpoints SET name = ?, slug = ?, token = ?, template_key = ?, recipient_ids = ?, keyword_templates = ?, enabled = ? WHERE id = ?",
+		e.Name, e.Slug, e.Token, e.TemplateKey, string(recipientIDs), string(keywordTemplates), e.Enabled, e.ID,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateSlug
+		}
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteCustomEndpoint removes a custom ingest endpoint
+func (r *SQLiteRepository) DeleteCustomEndpoint(id int64) error {
+	result, err := r.db.Exec("DELETE FROM custom_endpoints WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetCustomEndpointByID retrieves a custom ingest endpoint by ID
+func (r *SQLiteRepository) GetCustomEndpointByID(id int64) (*models.CustomEndpoint, error) {
+	return r.getCustomEndpoint("id = ?", id)
+}
+
+// GetCustomEndpointBySlug retrieves the custom ingest endpoint served at a slug
+func (r *SQLiteRepository) GetCustomEndpointBySlug(slug string) (*models.CustomEndpoint, error) {
+	return r.getCustomEndpoint("slug = ?", slug)
+}
+
+func (r *SQLiteRepository) getCustomEndpoint(where string, arg interface{}) (*models.CustomEndpoint, error) {
+	var e models.CustomEndpoint
+	err := scanCustomEndpoint(r.db.QueryRow("SELECT "+customEndpointColumns+" FROM custom_endpoints WHERE "+where, arg), &e)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// GetAllCustomEndpoints retrieves all custom ingest endpoints
+func (r *SQLiteRepository) GetAllCustomEndpoints() ([]models.CustomEndpoint, error) {
+	rows, err := r.db.Query("SELECT " + customEndpointColumns + " FROM custom_endpoints ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	endpoints := []models.CustomEndpoint{}
+	for rows.Next() {
+		var e models.CustomEndpoint
+		if err := scanCustomEndpoint(rows, &e); err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, rows.Err()
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+
+	"wechat-notification/models"
+)
+
+func TestCustomEndpoints(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	endpoint := &models.CustomEndpoint{
+		Name: "Backups", Slug: "backups", Token: "secret", TemplateKey: "alert",
+		KeywordTemplates: map[string]string{"first": "{{.body.job}}"}, Enabled: true,
+	}
+	if err := repo.CreateCustomEndpoint(endpoint); err != nil {
+		t.Fatalf("CreateCustomEndpoint error: %v", err)
+	}
+	if err := repo.CreateCustomEndpoint(&models.CustomEndpoint{Name: "Copy", Slug: "backups", Token: "other", TemplateKey: "alert"}); !errors.Is(err, ErrDuplicateSlug) {
+		t.Fatalf("expected ErrDuplicateSlug, got %v", err)
+	}
+
+	got, err := repo.GetCustomEndpointBySlug("backups")
+	if err != nil {
+		t.Fatalf("GetCustomEndpointBySlug error: %v", err)
+	}
+	if got.ID != endpoint.ID || got.Token != "secret" || got.KeywordTemplates["first"] != "{{.body.job}}" || got.RecipientIDs == nil {
+		t.Errorf("unexpected endpoint: %+v", got)
+	}
+
+	got.Slug, got.RecipientIDs = "nightly-backups", []int64{3}
+	if err := repo.UpdateCustomEndpoint(got); err != nil {
+		t.Fatalf("UpdateCustomEndpoint error: %v", err)
+	}
+	if _, err := repo.GetCustomEndpointBySlug("backups"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("old slug should be gone, got %v", err)
+	}
+	if updated, _ := repo.GetCustomEndpointBySlug("nightly-backups"); updated == nil || len(updated.RecipientIDs) != 1 {
+		t.Errorf("unexpected updated endpoint: %+v", updated)
+	}
+
+	if err := repo.DeleteCustomEndpoint(endpoint.ID); err != nil {
+		t.Fatalf("DeleteCustomEndpoint error: %v", err)
+	}
+	if err := repo.DeleteCustomEndpoint(endpoint.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+)
+
+func TestDeleteCascades(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	gone := &models.Recipient{OpenID: "o-gone", Name: "gone"}
+	kept := &models.Recipient{OpenID: "o-kept", Name: "kept"}
+	for _, r := range []*models.Recipient{gone, kept} {
+		if err := repo.Create(r); err != nil {
+			t.Fatalf("Create error: %v", err)
+		}
+	}
+	group := &models.Group{Name: "ops", RecipientIDs: []int64{gone.ID, kept.ID}}
+	if err := repo.CreateGroup(group); err != nil {
+		t.Fatalf("CreateGroup error: %v", err)
+	}
+	repo.SetTemplateSubscription(gone.ID, "alert", false)
+	repo.CreateAcknowledgement(&models.Acknowledgement{Token: "pending", RecipientID: gone.ID, TemplateKey: "alert"})
+	repo.CreateAcknowledgement(&models.Acknowledgement{Token: "confirmed", RecipientID: gone.ID, TemplateKey: "alert"})
+	repo.MarkAcknowledged("confirmed")
+	repo.CreateSendRetry(&models.SendRetry{RecipientID: gone.ID, Message: models.WeChatTemplateMessage{ToUser: "o-gone"}, NextAttemptAt: time.Now()})
+	shared := &models.ScheduledMessage{TemplateKey: "alert", RecipientIDs: []int64{gone.ID, kept.ID}, SendAt: time.Now().Add(time.Hour)}
+	alone := &models.ScheduledMessage{TemplateKey: "alert", RecipientIDs: []int64{gone.ID}, SendAt: time.Now().Add(time.Hour)}
+	repo.CreateScheduledMessage(shared)
+	repo.CreateScheduledMessage(alone)
+	repo.CreateHeldSend(&models.HeldSend{TemplateKey: "alert", RecipientIDs: []int64{kept.ID, gone.ID}})
+
+	if err := repo.Delete(gone.ID); err != nil {
+		t.Fatalf("Delete error: %v", err)
+	}
+	if err := repo.Delete(gone.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Delete error = %v, want ErrNotFound", err)
+	}
+
+	if g, _ := repo.GetGroupByID(group.ID); len(g.RecipientIDs) != 1 || g.RecipientIDs[0] != kept.ID {
+		t.Errorf("group members = %v", g.RecipientIDs)
+	}
+	if _, err := repo.GetAcknowledgementByToken("pending"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("pending acknowledgement not removed: %v", err)
+	}
+	if ack, err := repo.GetAcknowledgementByToken("confirmed"); err != nil || ack.RecipientID != 0 {
+		t.Errorf("confirmed acknowledgement = %+v, %v; want kept without recipient", ack, err)
+	}
+	var retries int
+	repo.db.QueryRow("SELECT COUNT(*) FROM send_retries WHERE recipient_id = ?", gone.ID).Scan(&retries)
+	if retries != 0 {
+		t.Errorf("%d retries left for deleted recipient", retries)
+	}
+	if m, _ := repo.GetScheduledMessageByID(shared.ID); len(m.RecipientIDs) != 1 || m.Status != models.ScheduledStatusPending {
+		t.Errorf("shared scheduled message = %+v", m)
+	}
+	if m, _ := repo.GetScheduledMessageByID(alone.ID); len(m.RecipientIDs) != 0 || m.Status != models.ScheduledStatusCancelled {
+		t.Errorf("scheduled message for deleted recipient only = %+v", m)
+	}
+	if held, _ := repo.ListHeldSends(); len(held) != 1 || len(held[0].RecipientIDs) != 1 || held[0].RecipientIDs[0] != kept.ID {
+		t.Errorf("held sends = %+v", held)
+	}
+}
@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"time"
+
+	"wechat-notification/models"
+)
+
+// ApplyDirectorySync stores a directory sync in one transaction, so a failure halfway leaves the
+// recipients as they were. Created recipients get their ID; updated and deactivated ones have
+// their name, email, metadata and deactivated flag replaced.
+func (r *SQLiteRepository) ApplyDirectorySync(create, update, deactivate []models.Recipient) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	ids := make([]int64, len(create))
+	for i, recipient := range create {
+		metadata, err := encodeMetadata(recipient.Metadata)
+		if err != nil {
+			return err
+		}
+		result, err := tx.Exec(
+			"INSERT INTO recipients (open_id, name, metadata, email, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+			recipient.OpenID, recipient.Name, metadata, recipient.Email, now, now,
+		)
+		if err != nil {
+			if isUniqueViolation(err) {
+				return ErrDuplicateOpenID
+			}
+			return err
+		}
+		if ids[i], err = result.LastInsertId(); err != nil {
+			return err
+		}
+	}
+	changed := append(append([]models.Recipient(nil), update...), deactivate...)
+	for _, recipient := range changed {
+		metadata, err := encodeMetadata(recipient.Metadata)
+		if err != nil {
+			return err
+		}
+		result, err := tx.Exec(
+			"UPDATE recipients SET name = ?, email = ?, metadata = ?, deactivated = ?, updated_at = ? WHERE id = ?",
+			recipient.Name, recipient.Email, metadata, recipient.Deactivated, now, recipient.ID,
+		)
+		if err != nil {
+			return err
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			return ErrNotFound
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for i := range create {
+		create[i].ID = ids[i]
+		create[i].CreatedAt = now
+		create[i].UpdatedAt = now
+	}
+	for i := range update {
+		update[i].UpdatedAt = now
+	}
+	for i := range deactivate {
+		deactivate[i].UpdatedAt = now
+	}
+	return nil
+}
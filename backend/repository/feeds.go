@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"wechat-notification/models"
+)
+
+const feedColumns = "id, name, url, interval_seconds, template_key, group_ids, keywords, enabled, last_polled_at, last_error, seen_ids"
+
+func scanFeed(row rowScanner, f *models.Feed) error {
+	var groupIDs, keywords, seenIDs string
+	var lastPolled sql.NullTime
+	if err := row.Scan(&f.ID, &f.Name, &f.URL, &f.IntervalSeconds, &f.TemplateKey, &groupIDs, &keywords, &f.Enabled, &lastPolled, &f.LastError, &seenIDs); err != nil {
+		return err
+	}
+	if lastPolled.Valid {
+		f.LastPolledAt = &lastPolled.Time
+	}
+	if err := json.Unmarshal([]byte(groupIDs), &f.GroupIDs); err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(keywords), &f.Keywords); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(seenIDs), &f.SeenIDs)
+}
+
+// CreateFeed adds a new feed
+func (r *SQLiteRepository) CreateFeed(f *models.Feed) error {
+	groupIDs, _ := json.Marshal(nonNilIDs(f.GroupIDs))
+	keywords, _ := json.Marshal(f.Keywords)
+	result, err := r.db.Exec(
+		"INSERT INTO feeds (name, url, interval_seconds, template_key, group_ids, keywords, enabled) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		f.Name, f.URL, f.IntervalSeconds, f.TemplateKey, string(groupIDs), string(keywords), f.Enabled,
+	)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	f.ID = id
+	return nil
+}
+
+// UpdateFeed updates a feed's settings; changing the URL resets the seen entries
+func (r *SQLiteRepository) UpdateFeed(f *models.Feed) error {
+	groupIDs, _ := json.Marshal(nonNilIDs(f.GroupIDs))
+	keywords, _ := json.Marshal(f.Keywords)
+	result, err := r.db.Exec(`
+		UPDATE feeds SET name = ?, interval_seconds = ?, template_key = ?, group_ids = ?, keywords = ?, enabled = ?,
+			seen_ids = CASE WHEN url = ? THEN seen_ids ELSE '[]' END,
+			last_polled_at = CASE WHEN url = ? THEN last_polled_at ELSE NULL END,
+			url = ?
+		WHERE id = ?`,
+		f.Name, f.IntervalSeconds, f.TemplateKey, string(groupIDs), string(keywords), f.Enabled, f.URL, f.URL, f.URL, f.ID,
+	)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteFeed removes a feed
+func (r *SQLiteRepository) DeleteFeed(id int64) error {
+	result, err := r.db.Exec("DELETE FROM feeds WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetFeedByID retrieves a feed by ID
+func (r *SQLiteRepository) GetFeedByID(id int64) (*models.Feed, error) {
+	var f models.Feed
+	err := scanFeed(r.db.QueryRow("SELECT "+feedColumns+" FROM feeds WHERE id = ?", id), &f)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// GetAllFeeds retrieves all feeds
+func (r *SQLiteRepository) GetAllFeeds() ([]models.Feed, error) {
+	rows, err := r.db.Query("SELECT " + feedColumns + " FROM feeds ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	feeds := []models.Feed{}
+	for rows.Next() {
+		var f models.Feed
+		if err := scanFeed(rows, &f); err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, f)
+	}
+	return feeds, rows.Err()
+}
+
+// UpdateFeedPollState records the outcome of a poll
+func (r *SQLiteRepository) UpdateFeedPollState(id int64, polledAt time.Time, lastError string, seenIDs []string) error {
+	if seenIDs == nil {
+		seenIDs = []string{}
+	}
+	seen, _ := json.Marshal(seenIDs)
+	_, err := r.db.Exec("UPDATE feeds SET last_polled_at = ?, last_error = ?, seen_ids = ? WHERE id = ?", polledAt, lastError, string(seen), id)
+	return err
+}
+
+// SetFeedError records a failed fetch, leaving the poll time and seen entries as they were
+func (r *SQLiteRepository) SetFeedError(id int64, lastError string) error {
+	_, err := r.db.Exec("UPDATE feeds SET last_error = ? WHERE id = ?", lastError, id)
+	return err
+}
+
+func nonNilIDs(ids []int64) []int64 {
+	if ids == nil {
+		return []int64{}
+	}
+	return ids
+}
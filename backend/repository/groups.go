@@ -0,0 +1,235 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"wechat-notification/models"
+)
+
+const groupColumns = "id, name, description, channels, created_at"
+
+func scanGroup(row rowScanner, g *models.Group) error {
+	var channels string
+	if err := row.Scan(&g.ID, &g.Name, &g.Description, &channels, &g.CreatedAt); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(channels), &g.Channels)
+}
+
+// CreateGroup adds a new recipient group with its members
+func (r *SQLiteRepository) CreateGroup(group *models.Group) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	channels, _ := json.Marshal(nonNilChannels(group.Channels))
+	result, err := tx.Exec("INSERT INTO groups (name, description, channels, created_at) VALUES (?, ?, ?, ?)", group.Name, group.Description, string(channels), now)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateName
+		}
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if err := replaceGroupMembers(tx, id, group.RecipientIDs); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	group.ID = id
+	group.CreatedAt = now
+	if group.RecipientIDs == nil {
+		group.RecipientIDs = []int64{}
+	}
+	group.Channels = nonNilChannels(group.Channels)
+	return nil
+}
+
+// UpdateGroup updates a group's name, description, channels and members
+func (r *SQLiteRepository) UpdateGroup(group *models.Group) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	channels, _ := json.Marshal(nonNilChannels(group.Channels))
+	result, err := tx.Exec("UPDATE groups SET name = ?, description = ?, channels = ? WHERE id = ?", group.Name, group.Description, string(channels), group.ID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateName
+		}
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	if err := replaceGroupMembers(tx, group.ID, group.RecipientIDs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteGroup removes a group and its memberships
+func (r *SQLiteRepository) DeleteGroup(id int64) error {
+	result, err := r.db.Exec("DELETE FROM groups WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	_, err = r.db.Exec("DELETE FROM group_members WHERE group_id = ?", id)
+	return err
+}
+
+// GetAllGroups retrieves all groups with their member IDs
+func (r *SQLiteRepository) GetAllGroups() ([]models.Group, error) {
+	rows, err := r.db.Query("SELECT " + groupColumns + " FROM groups ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []models.Group{}
+	for rows.Next() {
+		var g models.Group
+		if err := scanGroup(rows, &g); err != nil {
+			return nil, err
+		}
+		g.RecipientIDs = []int64{}
+		groups = append(groups, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	members, err := r.db.Query("SELECT group_id, recipient_id FROM group_members ORDER BY recipient_id")
+	if err != nil {
+		return nil, err
+	}
+	defer members.Close()
+
+	index := make(map[int64]int, len(groups))
+	for i, g := range groups {
+		index[g.ID] = i
+	}
+	for members.Next() {
+		var groupID, recipientID int64
+		if err := members.Scan(&groupID, &recipientID); err != nil {
+			return nil, err
+		}
+		if i, ok := index[groupID]; ok {
+			groups[i].RecipientIDs = append(groups[i].RecipientIDs, recipientID)
+		}
+	}
+	return groups, members.Err()
+}
+
+// GetGroupByID retrieves a group with its member IDs
+func (r *SQLiteRepository) GetGroupByID(id int64) (*models.Group, error) {
+	var g models.Group
+	err := scanGroup(r.db.QueryRow("SELECT "+groupColumns+" FROM groups WHERE id = ?", id), &g)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.Query("SELECT recipient_id FROM group_members WHERE group_id = ? ORDER BY recipient_id", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	g.RecipientIDs = []int64{}
+	for rows.Next() {
+		var recipientID int64
+		if err := rows.Scan(&recipientID); err != nil {
+			return nil, err
+		}
+		g.RecipientIDs = append(g.RecipientIDs, recipientID)
+	}
+	return &g, rows.Err()
+}
+
+// GetRecipientsByGroupIDs retrieves the distinct members of the given groups
+func (r *SQLiteRepository) GetRecipientsByGroupIDs(groupIDs []int64) ([]models.Recipient, error) {
+	if len(groupIDs) == 0 {
+		return []models.Recipient{}, nil
+	}
+
+	placeholders := make([]string, len(groupIDs))
+	args := make([]interface{}, len(groupIDs))
+	for i, id := range groupIDs {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+
+	query := "SELECT " + recipientColumns + " FROM recipients WHERE id IN (SELECT recipient_id FROM group_members WHERE group_id IN (" +
+		strings.Join(placeholders, ",") + ")) ORDER BY id"
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recipients := []models.Recipient{}
+	for rows.Next() {
+		var rec models.Recipient
+		if err := scanRecipient(rows, &rec); err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, rec)
+	}
+	return recipients, rows.Err()
+}
+
+// AddToGroups adds a recipient to groups, ignoring groups it's already in and IDs that don't exist
+func (r *SQLiteRepository) AddToGroups(recipientID int64, groupIDs []int64) error {
+	for _, groupID := range groupIDs {
+		if _, err := r.db.Exec("INSERT OR IGNORE INTO group_members (group_id, recipient_id) SELECT id, ? FROM groups WHERE id = ?", recipientID, groupID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func replaceGroupMembers(tx *sql.Tx, groupID int64, recipientIDs []int64) error {
+	if _, err := tx.Exec("DELETE FROM group_members WHERE group_id = ?", groupID); err != nil {
+		return err
+	}
+	for _, recipientID := range recipientIDs {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO group_members (group_id, recipient_id) VALUES (?, ?)", groupID, recipientID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isUniqueViolation reports whether err is a SQLite UNIQUE constraint failure
+func isUniqueViolation(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+func nonNilChannels(channels []models.Channel) []models.Channel {
+	if channels == nil {
+		return []models.Channel{}
+	}
+	return channels
+}
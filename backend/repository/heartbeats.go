@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"wechat-notification/models"
+)
+
+const heartbeatColumns = "id, name, ping_key, interval_seconds, grace_seconds, template_key, group_ids, keywords, enabled, status, last_ping_at, last_changed_at, created_at"
+
+func scanHeartbeat(row rowScanner, h *models.Heartbeat) error {
+	var groupIDs, keywords string
+	var lastPing, lastChanged sql.NullTime
+	if err := row.Scan(&h.ID, &h.Name, &h.PingKey, &h.IntervalSeconds, &h.GraceSeconds, &h.TemplateKey,
+		&groupIDs, &keywords, &h.Enabled, &h.Status, &lastPing, &lastChanged, &h.CreatedAt); err != nil {
+		return err
+	}
+	if lastPing.Valid {
+		h.LastPingAt = &lastPing.Time
+	}
+	if lastChanged.Valid {
+		h.LastChangedAt = &lastChanged.Time
+	}
+	if err := json.Unmarshal([]byte(groupIDs), &h.GroupIDs); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(keywords), &h.Keywords)
+}
+
+// CreateHeartbeat adds a new heartbeat
+func (r *SQLiteRepository) CreateHeartbeat(h *models.Heartbeat) error {
+	groupIDs, _ := json.Marshal(nonNilIDs(h.GroupIDs))
+	keywords, _ := json.Marshal(h.Keywords)
+	h.Status = models.MonitorStatusUnknown
+	h.CreatedAt = time.Now()
+	result, err := r.db.Exec(
+		`INSERT INTO heartbeats (name, ping_key, interval_seconds, grace_seconds, template_key, group_ids, keywords, enabled, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		h.Name, h.PingKey, h.IntervalSeconds, h.GraceSeconds, h.TemplateKey, string(groupIDs), string(keywords), h.Enabled, h.Status, h.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	h.ID = id
+	return nil
+}
+
+// UpdateHeartbeat updates a heartbeat's settings, keeping its ping key and state
+func (r *SQLiteRepository) UpdateHeartbeat(h *models.Heartbeat) error {
+	groupIDs, _ := json.Marshal(nonNilIDs(h.GroupIDs))
+	keywords, _ := json.Marshal(h.Keywords)
+	result, err := r.db.Exec(
+		"UPDATE heartbeats SET name = ?, interval_seconds = ?, grace_seconds = ?, template_key = ?, group_ids = ?, keywords = ?, enabled = ? WHERE id = ?",
+		h.Name, h.IntervalSeconds, h.GraceSeconds, h.TemplateKey, string(groupIDs), string(keywords), h.Enabled, h.ID,
+	)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteHeartbeat removes a heartbeat
+func (r *SQLiteRepository) DeleteHeartbeat(id int64) error {
+	result, err := r.db.Exec("DELETE FROM heartbeats WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetHeartbeatByID retrieves a heartbeat by ID
+func (r *SQLiteRepository) GetHeartbeatByID(id int64) (*models.Heartbeat, error) {
+	return r.getHeartbeat("id", id)
+}
+
+// GetHeartbeatByPingKey retrieves a heartbeat by its ping key
+func (r *SQLiteRepository) GetHeartbeatByPingKey(key string) (*models.Heartbeat, error) {
+	return r.getHeartbeat("ping_key", key)
+}
+
+func (r *SQLiteRepository) getHeartbeat(column string, value interface{}) (*models.Heartbeat, error) {
+	var h models.Heartbeat
+	err := scanHeartbeat(r.db.QueryRow("SELECT "+heartbeatColumns+" FROM heartbeats WHERE "+column+" = ?", value), &h)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// GetAllHeartbeats retrieves all heartbeats
+func (r *SQLiteRepository) GetAllHeartbeats() ([]models.Heartbeat, error) {
+	rows, err := r.db.Query("SELECT " + heartbeatColumns + " FROM heartbeats ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	heartbeats := []models.Heartbeat{}
+	for rows.Next() {
+		var h models.Heartbeat
+		if err := scanHeartbeat(rows, &h); err != nil {
+			return nil, err
+		}
+		heartbeats = append(heartbeats, h)
+	}
+	return heartbeats, rows.Err()
+}
+
+// RecordHeartbeatPing stores a ping and marks the heartbeat up
+func (r *SQLiteRepository) RecordHeartbeatPing(id int64, pingAt time.Time, changed bool) error {
+	_, err := r.db.Exec(
+		"UPDATE heartbeats SET last_ping_at = ?, status = ?, last_changed_at = CASE WHEN ? THEN ? ELSE last_changed_at END WHERE id = ?",
+		pingAt, models.MonitorStatusUp, changed, pingAt, id,
+	)
+	return err
+}
+
+// MarkHeartbeatDown flags a heartbeat whose deadline passed
+func (r *SQLiteRepository) MarkHeartbeatDown(id int64, at time.Time) error {
+	_, err := r.db.Exec("UPDATE heartbeats SET status = ?, last_changed_at = ? WHERE id = ?", models.MonitorStatusDown, at, id)
+	return err
+}
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"encoding/json"
+	"time"
+
+	"wechat-notification/models"
+)
+
+const heldSendColumns = "id, template_key, keywords, recipient_ids, require_ack, reply_to, channels, tags, priority, app_id, source, created_at, url, miniprogram, mode, media_id, group_channels, last_error"
+
+func scanHeldSend(row rowScanner, h *models.HeldSend) error {
+	var keywords, recipientIDs, channels, tags, source, miniprogram, groupChannels string
+	if err := row.Scan(&h.ID, &h.TemplateKey, &keywords, &recipientIDs, &h.RequireAck, &h.ReplyTo, &channels, &tags, &h.Priority,
+		&h.AppID, &source, &h.CreatedAt, &h.URL, &miniprogram, &h.Mode, &h.MediaID, &groupChannels, &h.LastError); err != nil {
+		return err
+	}
+	for _, field := range []struct {
+		data string
+		v    interface{}
+	}{
+		{keywords, &h.Keywords}, {recipientIDs, &h.RecipientIDs}, {channels, &h.Channels}, {tags, &h.Tags}, {source, &h.Source},
+		{miniprogram, &h.Miniprogram}, {groupChannels, &h.GroupChannels},
+	} {
+		if err := json.Unmarshal([]byte(field.data), field.v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateHeldSend stores a send received during maintenance mode
+func (r *SQLiteRepository) CreateHeldSend(h *models.HeldSend) error {
+	keywords, _ := json.Marshal(h.Keywords)
+	recipientIDs, _ := json.Marshal(nonNilIDs(h.RecipientIDs))
+	channels, _ := json.Marshal(nonNilChannels(h.Channels))
+	groupChannels, _ := json.Marshal(nonNilChannels(h.GroupChannels))
+	tags, _ := json.Marshal(nonNilStrings(h.Tags))
+	source, _ := json.Marshal(h.Source)
+	miniprogram, _ := json.Marshal(h.Miniprogram)
+	h.CreatedAt = time.Now()
+	result, err := r.db.Exec(
+		`INSERT INTO held_sends (template_key, keywords, recipient_ids, require_ack, reply_to, channels, tags, priority, app_id, source, created_at, url, miniprogram, mode, media_id, group_channels)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		h.TemplateKey, string(keywords), string(recipientIDs), h.RequireAck, h.ReplyTo, string(channels), string(tags), h.Priority, h.AppID, string(source), h.CreatedAt, h.URL, string(miniprogram), h.Mode, h.MediaID, string(groupChannels),
+	)
+	if err != nil {
+		return err
+	}
+	h.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// ListHeldSends retrieves held sends, oldest first
+func (r *SQLiteRepository) ListHeldSends() ([]models.HeldSend, error) {
+	rows, err := r.db.Query("SELECT " + heldSendColumns + " FROM held_sends ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	held := []models.HeldSend{}
+	for rows.Next() {
+		var h models.HeldSend
+		if err := scanHeldSend(rows, &h); err != nil {
+			return nil, err
+		}
+		held = append(held, h)
+	}
+	return held, rows.Err()
+}
+
+// CountHeldSends returns how many sends are waiting for maintenance to end
+func (r *SQLiteRepository) CountHeldSends() (int, error) {
+	var count int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM held_sends").Scan(&count)
+	return count, err
+}
+
+// SetHeldSendError records why releasing a held send failed; it stays held and is tried again
+func (r *SQLiteRepository) SetHeldSendError(id int64, lastError string) error {
+	_, err := r.db.Exec("UPDATE held_sends SET last_error = ? WHERE id = ?", lastError, id)
+	return err
+}
+
+// DeleteHeldSend removes a held send once it has been dispatched
+func (r *SQLiteRepository) DeleteHeldSend(id int64) error {
+	_, err := r.db.Exec("DELETE FROM held_sends WHERE id = ?", id)
+	return err
+}
@@ -0,0 +1,302 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"wechat-notification/models"
+)
+
+const messageColumns = "id, template_key, keywords, reply_to, thread_id, total_count, total_sent, total_failed, total_skipped, total_throttled, results, created_at, adapter, token_name, source_ip, user_agent, sandbox, app_id"
+
+func scanMessage(row rowScanner, m *models.Message) error {
+	var keywords, results string
+	var replyTo sql.NullInt64
+	if err := row.Scan(&m.ID, &m.TemplateKey, &keywords, &replyTo, &m.ThreadID, &m.TotalCount, &m.TotalSent,
+		&m.TotalFailed, &m.TotalSkipped, &m.TotalThrottled, &results, &m.CreatedAt, &m.Adapter, &m.TokenName, &m.SourceIP, &m.UserAgent, &m.Sandbox, &m.AppID); err != nil {
+		return err
+	}
+	if replyTo.Valid {
+		m.ReplyTo = &replyTo.Int64
+	}
+	m.Results = json.RawMessage(results)
+	return json.Unmarshal([]byte(keywords), &m.Keywords)
+}
+
+// CreateMessage records a send in the history.
+// A message that doesn't reply to another starts its own thread.
+func (r *SQLiteRepository) CreateMessage(m *models.Message) error {
+	keywords, _ := json.Marshal(m.Keywords)
+	results := m.Results
+	if len(results) == 0 || string(results) == "null" {
+		results = json.RawMessage("[]")
+	}
+	m.CreatedAt = time.Now()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`INSERT INTO messages (template_key, keywords, reply_to, thread_id, total_count, total_sent, total_failed, total_skipped, total_throttled, results, created_at,
+			adapter, token_name, source_ip, user_agent, sandbox, app_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		m.TemplateKey, string(keywords), m.ReplyTo, m.ThreadID, m.TotalCount, m.TotalSent, m.TotalFailed, m.TotalSkipped, m.TotalThrottled, string(results), m.CreatedAt,
+		m.Adapter, m.TokenName, m.SourceIP, m.UserAgent, m.Sandbox, m.AppID,
+	)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	if m.ThreadID == 0 {
+		if _, err := tx.Exec("UPDATE messages SET thread_id = ? WHERE id = ?", id, id); err != nil {
+			return err
+		}
+		m.ThreadID = id
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	m.ID = id
+	return nil
+}
+
+// GetMessageByID retrieves a message with its per-recipient results
+func (r *SQLiteRepository) GetMessageByID(id int64) (*models.Message, error) {
+	var m models.Message
+	err := scanMessage(r.db.QueryRow("SELECT "+messageColumns+" FROM messages WHERE id = ?", id), &m)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// MessageFilter narrows the message history by source. Empty fields match everything;
+// UserAgent matches a substring, the others match exactly.
+type MessageFilter struct {
+	Adapter   string
+	TokenName string
+	SourceIP  string
+	UserAgent string
+
+	From       time.Time // Sent at or after; zero for no lower bound
+	To         time.Time // Sent before; zero for no upper bound
+	FailedOnly bool      // Only messages that failed for at least one recipient
+}
+
+// IsEmpty reports whether the filter matches every message
+func (f MessageFilter) IsEmpty() bool {
+	return f == MessageFilter{}
+}
+
+// where builds the WHERE clause and arguments for the filter
+func (f MessageFilter) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	for _, c := range []struct{ column, value string }{
+		{"adapter", f.Adapter}, {"token_name", f.TokenName}, {"source_ip", f.SourceIP},
+	} {
+		if c.value != "" {
+			conditions = append(conditions, c.column+" = ?")
+			args = append(args, c.value)
+		}
+	}
+	if f.UserAgent != "" {
+		conditions = append(conditions, "instr(user_agent, ?) > 0")
+		args = append(args, f.UserAgent)
+	}
+	if !f.From.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, f.From)
+	}
+	if !f.To.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, f.To)
+	}
+	if f.FailedOnly {
+		conditions = append(conditions, "total_failed > 0")
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// ListMessages retrieves a page of messages matching the filter, newest first, without per-recipient results,
+// along with the total number of matching messages
+func (r *SQLiteRepository) ListMessages(filter MessageFilter, limit, offset int) ([]models.Message, int, error) {
+	where, args := filter.where()
+	var total int
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM messages"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	messages, err := r.queryMessages("SELECT "+messageColumns+" FROM messages"+where+" ORDER BY id DESC LIMIT ? OFFSET ?", append(args, limit, offset)...)
+	return messages, total, err
+}
+
+// ListMessageIDs returns the IDs of every message matching the filter, oldest first
+func (r *SQLiteRepository) ListMessageIDs(filter MessageFilter) ([]int64, error) {
+	where, args := filter.where()
+	rows, err := r.db.Query("SELECT id FROM messages"+where+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// UpdateMessageResults replaces the per-recipient results of a message
+func (r *SQLiteRepository) UpdateMessageResults(id int64, results json.RawMessage) error {
+	result, err := r.db.Exec("UPDATE messages SET results = ? WHERE id = ?", string(results), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteMessages removes messages from the history, returning how many were deleted
+func (r *SQLiteRepository) DeleteMessages(ids []int64) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+	result, err := r.db.Exec("DELETE FROM messages WHERE id IN ("+strings.Join(placeholders, ",")+")", args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetThread retrieves every message in a thread, oldest first, without per-recipient results
+func (r *SQLiteRepository) GetThread(threadID int64) ([]models.Message, error) {
+	return r.queryMessages("SELECT "+messageColumns+" FROM messages WHERE thread_id = ? ORDER BY id", threadID)
+}
+
+// FindRecentMessages retrieves messages sent since the given time with the same template and
+// keywords, newest first, including per-recipient results. Keywords are compared by their
+// JSON encoding, which orders map keys.
+func (r *SQLiteRepository) FindRecentMessages(templateKey string, keywords map[string]string, since time.Time) ([]models.Message, error) {
+	encoded, _ := json.Marshal(keywords)
+	rows, err := r.db.Query("SELECT "+messageColumns+" FROM messages WHERE template_key = ? AND keywords = ? AND created_at >= ? ORDER BY id DESC",
+		templateKey, string(encoded), since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []models.Message{}
+	for rows.Next() {
+		var m models.Message
+		if err := scanMessage(rows, &m); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// GetDailyTemplateStats aggregates the messages sent between start and end per template
+func (r *SQLiteRepository) GetDailyTemplateStats(start, end time.Time) ([]models.DailyTemplateStats, error) {
+	rows, err := r.db.Query(`SELECT template_key, COUNT(*), COALESCE(SUM(total_sent), 0), COALESCE(SUM(total_failed), 0),
+		COALESCE(SUM(total_skipped), 0), COALESCE(SUM(total_throttled), 0)
+		FROM messages WHERE created_at >= ? AND created_at < ? GROUP BY template_key ORDER BY template_key`, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	date := start.Format("2006-01-02")
+	stats := []models.DailyTemplateStats{}
+	for rows.Next() {
+		s := models.DailyTemplateStats{Date: date}
+		if err := rows.Scan(&s.TemplateKey, &s.Messages, &s.Sent, &s.Failed, &s.Skipped, &s.Throttled); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// GetFailedMessagesSince retrieves messages sent since the given time that failed for at least one
+// recipient, oldest first, including per-recipient results
+func (r *SQLiteRepository) GetFailedMessagesSince(since time.Time) ([]models.Message, error) {
+	rows, err := r.db.Query("SELECT "+messageColumns+" FROM messages WHERE total_failed > 0 AND created_at >= ? ORDER BY id", since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []models.Message{}
+	for rows.Next() {
+		var m models.Message
+		if err := scanMessage(rows, &m); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// CountMessagesByToken counts the messages sent in [from, to) per inbound token, leaving out sends
+// that didn't come through a token
+func (r *SQLiteRepository) CountMessagesByToken(from, to time.Time) (map[string]int, error) {
+	rows, err := r.db.Query("SELECT token_name, COUNT(*) FROM messages WHERE token_name != '' AND created_at >= ? AND created_at < ? GROUP BY token_name", from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var name string
+		var n int
+		if err := rows.Scan(&name, &n); err != nil {
+			return nil, err
+		}
+		counts[name] = n
+	}
+	return counts, rows.Err()
+}
+
+func (r *SQLiteRepository) queryMessages(query string, args ...interface{}) ([]models.Message, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []models.Message{}
+	for rows.Next() {
+		var m models.Message
+		if err := scanMessage(rows, &m); err != nil {
+			return nil, err
+		}
+		m.Results = nil
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+)
+
+func TestMessageThreading(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	started := &models.Message{TemplateKey: "deploy", Keywords: map[string]string{"first": "deploy started"}}
+	if err := repo.CreateMessage(started); err != nil {
+		t.Fatalf("CreateMessage error: %v", err)
+	}
+	if started.ThreadID != started.ID {
+		t.Errorf("root message thread = %d, want its own ID %d", started.ThreadID, started.ID)
+	}
+
+	unrelated := &models.Message{TemplateKey: "backup", Keywords: map[string]string{}}
+	if err := repo.CreateMessage(unrelated); err != nil {
+		t.Fatalf("CreateMessage error: %v", err)
+	}
+
+	finished := &models.Message{TemplateKey: "deploy", Keywords: map[string]string{"first": "deploy finished"}, ReplyTo: &started.ID, ThreadID: started.ThreadID}
+	if err := repo.CreateMessage(finished); err != nil {
+		t.Fatalf("CreateMessage error: %v", err)
+	}
+
+	thread, err := repo.GetThread(started.ThreadID)
+	if err != nil {
+		t.Fatalf("GetThread error: %v", err)
+	}
+	if len(thread) != 2 || thread[0].ID != started.ID || thread[1].ID != finished.ID {
+		t.Fatalf("unexpected thread: %+v", thread)
+	}
+	if thread[1].ReplyTo == nil || *thread[1].ReplyTo != started.ID {
+		t.Errorf("reply reference not preserved: %v", thread[1].ReplyTo)
+	}
+
+	got, err := repo.GetMessageByID(finished.ID)
+	if err != nil {
+		t.Fatalf("GetMessageByID error: %v", err)
+	}
+	if string(got.Results) != "[]" || got.Keywords["first"] != "deploy finished" {
+		t.Errorf("unexpected message: %+v", got)
+	}
+
+	messages, total, err := repo.ListMessages(MessageFilter{}, 2, 0)
+	if err != nil {
+		t.Fatalf("ListMessages error: %v", err)
+	}
+	if total != 3 || len(messages) != 2 || messages[0].ID != finished.ID {
+		t.Errorf("ListMessages = %d items (total %d), want newest first page of 2 (total 3)", len(messages), total)
+	}
+}
+
+func TestFindRecentMessages(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	announcement := map[string]string{"first": "停电通知", "keyword1": "周六 9:00"}
+	for _, m := range []*models.Message{
+		{TemplateKey: "notice", Keywords: announcement},
+		{TemplateKey: "notice", Keywords: map[string]string{"first": "停电通知"}},
+		{TemplateKey: "other", Keywords: announcement},
+	} {
+		if err := repo.CreateMessage(m); err != nil {
+			t.Fatalf("CreateMessage error: %v", err)
+		}
+	}
+
+	// Same keywords built in a different order must still match
+	lookup := map[string]string{"keyword1": "周六 9:00", "first": "停电通知"}
+	found, err := repo.FindRecentMessages("notice", lookup, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("FindRecentMessages error: %v", err)
+	}
+	if len(found) != 1 || found[0].Keywords["keyword1"] != "周六 9:00" {
+		t.Errorf("found %d messages, want the one identical send", len(found))
+	}
+
+	found, _ = repo.FindRecentMessages("notice", lookup, time.Now().Add(time.Minute))
+	if len(found) != 0 {
+		t.Errorf("messages before the window should not match, got %d", len(found))
+	}
+}
+
+func TestListMessagesFilterBySource(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	for _, m := range []*models.Message{
+		{TemplateKey: "alert", MessageSource: models.MessageSource{Adapter: models.SourceWebhook, TokenName: "webhook_token", SourceIP: "10.0.0.1", UserAgent: "curl/8.4.0"}},
+		{TemplateKey: "alert", MessageSource: models.MessageSource{Adapter: models.SourceWebhook, TokenName: "webhook_token", SourceIP: "10.0.0.2", UserAgent: "Grafana/10.2"}},
+		{TemplateKey: "alert", MessageSource: models.MessageSource{Adapter: models.SourceEmail, SourceIP: "10.0.0.1"}},
+		{TemplateKey: "alert"},
+	} {
+		if err := repo.CreateMessage(m); err != nil {
+			t.Fatalf("CreateMessage error: %v", err)
+		}
+	}
+
+	cases := []struct {
+		filter MessageFilter
+		want   int
+	}{
+		{MessageFilter{}, 4},
+		{MessageFilter{Adapter: models.SourceWebhook}, 2},
+		{MessageFilter{SourceIP: "10.0.0.1"}, 2},
+		{MessageFilter{Adapter: models.SourceWebhook, UserAgent: "Grafana"}, 1},
+		{MessageFilter{TokenName: "other"}, 0},
+	}
+	for _, tc := range cases {
+		messages, total, err := repo.ListMessages(tc.filter, 10, 0)
+		if err != nil {
+			t.Fatalf("ListMessages(%+v) error: %v", tc.filter, err)
+		}
+		if total != tc.want || len(messages) != tc.want {
+			t.Errorf("ListMessages(%+v) = %d items (total %d), want %d", tc.filter, len(messages), total, tc.want)
+		}
+	}
+
+	messages, _, _ := repo.ListMessages(MessageFilter{UserAgent: "curl"}, 10, 0)
+	if len(messages) != 1 || messages[0].SourceIP != "10.0.0.1" || messages[0].TokenName != "webhook_token" {
+		t.Errorf("source not stored: %+v", messages)
+	}
+}
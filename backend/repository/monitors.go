@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"wechat-notification/models"
+)
+
+const monitorColumns = "id, name, url, method, expected_status, interval_seconds, timeout_seconds, template_key, group_ids, keywords, enabled, status, last_checked_at, last_changed_at, last_error"
+
+func scanMonitor(row rowScanner, m *models.Monitor) error {
+	var groupIDs, keywords string
+	var lastChecked, lastChanged sql.NullTime
+	if err := row.Scan(&m.ID, &m.Name, &m.URL, &m.Method, &m.ExpectedStatus, &m.IntervalSeconds, &m.TimeoutSeconds,
+		&m.TemplateKey, &groupIDs, &keywords, &m.Enabled, &m.Status, &lastChecked, &lastChanged, &m.LastError); err != nil {
+		return err
+	}
+	if lastChecked.Valid {
+		m.LastCheckedAt = &lastChecked.Time
+	}
+	if lastChanged.Valid {
+		m.LastChangedAt = &lastChanged.Time
+	}
+	if err := json.Unmarshal([]byte(groupIDs), &m.GroupIDs); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(keywords), &m.Keywords)
+}
+
+// CreateMonitor adds a new monitor
+func (r *SQLiteRepository) CreateMonitor(m *models.Monitor) error {
+	groupIDs, _ := json.Marshal(nonNilIDs(m.GroupIDs))
+	keywords, _ := json.Marshal(m.Keywords)
+	m.Status = models.MonitorStatusUnknown
+	result, err := r.db.Exec(
+		`INSERT INTO monitors (name, url, method, expected_status, interval_seconds, timeout_seconds, template_key, group_ids, keywords, enabled, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		m.Name, m.URL, m.Method, m.ExpectedStatus, m.IntervalSeconds, m.TimeoutSeconds, m.TemplateKey, string(groupIDs), string(keywords), m.Enabled, m.Status,
+	)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	m.ID = id
+	return nil
+}
+
+// UpdateMonitor updates a monitor's settings; changing the URL resets its status
+func (r *SQLiteRepository) UpdateMonitor(m *models.Monitor) error {
+	groupIDs, _ := json.Marshal(nonNilIDs(m.GroupIDs))
+	keywords, _ := json.Marshal(m.Keywords)
+	result, err := r.db.Exec(`
+		UPDATE monitors SET name = ?, method = ?, expected_status = ?, interval_seconds = ?, timeout_seconds = ?,
+			template_key = ?, group_ids = ?, keywords = ?, enabled = ?,
+			status = CASE WHEN url = ? THEN status ELSE 'unknown' END,
+			last_checked_at = CASE WHEN url = ? THEN last_checked_at ELSE NULL END,
+			url = ?
+		WHERE id = ?`,
+		m.Name, m.Method, m.ExpectedStatus, m.IntervalSeconds, m.TimeoutSeconds, m.TemplateKey, string(groupIDs), string(keywords), m.Enabled,
+		m.URL, m.URL, m.URL, m.ID,
+	)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteMonitor removes a monitor
+func (r *SQLiteRepository) DeleteMonitor(id int64) error {
+	result, err := r.db.Exec("DELETE FROM monitors WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetMonitorByID retrieves a monitor by ID
+func (r *SQLiteRepository) GetMonitorByID(id int64) (*models.Monitor, error) {
+	var m models.Monitor
+	err := scanMonitor(r.db.QueryRow("SELECT "+monitorColumns+" FROM monitors WHERE id = ?", id), &m)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// GetAllMonitors retrieves all monitors
+func (r *SQLiteRepository) GetAllMonitors() ([]models.Monitor, error) {
+	rows, err := r.db.Query("SELECT " + monitorColumns + " FROM monitors ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	monitors := []models.Monitor{}
+	for rows.Next() {
+		var m models.Monitor
+		if err := scanMonitor(rows, &m); err != nil {
+			return nil, err
+		}
+		monitors = append(monitors, m)
+	}
+	return monitors, rows.Err()
+}
+
+// UpdateMonitorCheckState records the outcome of a check; lastChangedAt is only set when the status flipped
+func (r *SQLiteRepository) UpdateMonitorCheckState(id int64, status string, checkedAt time.Time, lastChangedAt *time.Time, lastError string) error {
+	_, err := r.db.Exec(
+		"UPDATE monitors SET status = ?, last_checked_at = ?, last_changed_at = COALESCE(?, last_changed_at), last_error = ? WHERE id = ?",
+		status, checkedAt, lastChangedAt, lastError, id,
+	)
+	return err
+}
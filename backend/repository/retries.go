@@ -0,0 +1,230 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"wechat-notification/models"
+)
+
+const retryColumns = "id, message_id, recipient_id, app_id, message, attempts, next_attempt_at, status, last_error, created_at, updated_at"
+
+func scanRetry(row rowScanner, r *models.SendRetry) error {
+	var message string
+	if err := row.Scan(&r.ID, &r.MessageID, &r.RecipientID, &r.AppID, &message, &r.Attempts, &r.NextAttemptAt, &r.Status, &r.LastError, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(message), &r.Message)
+}
+
+// CreateSendRetry queues a failed WeChat message to be re-sent at r.NextAttemptAt
+func (r *SQLiteRepository) CreateSendRetry(retry *models.SendRetry) error {
+	message, err := json.Marshal(retry.Message)
+	if err != nil {
+		return err
+	}
+	// next_attempt_at is compared as text by SQLite, so it's always stored in UTC
+	retry.NextAttemptAt = retry.NextAttemptAt.UTC()
+	retry.Status = models.RetryStatusPending
+	retry.CreatedAt = time.Now()
+	retry.UpdatedAt = retry.CreatedAt
+	result, err := r.db.Exec(
+		`INSERT INTO send_retries (message_id, recipient_id, app_id, message, attempts, next_attempt_at, status, last_error, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		retry.MessageID, retry.RecipientID, retry.AppID, string(message), retry.Attempts, retry.NextAttemptAt, retry.Status, retry.LastError, retry.CreatedAt, retry.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	retry.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// GetSendRetryByID retrieves a queued retry by ID
+func (r *SQLiteRepository) GetSendRetryByID(id int64) (*models.SendRetry, error) {
+	var retry models.SendRetry
+	err := scanRetry(r.db.QueryRow("SELECT "+retryColumns+" FROM send_retries WHERE id = ?", id), &retry)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &retry, nil
+}
+
+// GetDueSendRetries retrieves pending retries whose next attempt time has passed, oldest first
+func (r *SQLiteRepository) GetDueSendRetries(now time.Time, limit int) ([]models.SendRetry, error) {
+	rows, err := r.db.Query("SELECT "+retryColumns+" FROM send_retries WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at, id LIMIT ?",
+		models.RetryStatusPending, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	retries := []models.SendRetry{}
+	for rows.Next() {
+		var retry models.SendRetry
+		if err := scanRetry(rows, &retry); err != nil {
+			return nil, err
+		}
+		retries = append(retries, retry)
+	}
+	return retries, rows.Err()
+}
+
+// ClaimSendRetry moves a pending retry to sending, reporting false if it was already claimed
+func (r *SQLiteRepository) ClaimSendRetry(id int64) (bool, error) {
+	result, err := r.db.Exec("UPDATE send_retries SET status = ?, updated_at = ? WHERE id = ? AND status = ?",
+		models.RetryStatusSending, time.Now(), id, models.RetryStatusPending)
+	if err != nil {
+		return false, err
+	}
+	rows, _ := result.RowsAffected()
+	return rows == 1, nil
+}
+
+// ResetSendingSendRetries moves retries left sending by a crash or a failed update back to pending
+// so they are attempted again, returning how many were reset
+func (r *SQLiteRepository) ResetSendingSendRetries() (int64, error) {
+	result, err := r.db.Exec("UPDATE send_retries SET status = ?, updated_at = ? WHERE status = ?",
+		models.RetryStatusPending, time.Now(), models.RetryStatusSending)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// UpdateSendRetry records the outcome of an attempt: its status, attempt count, next attempt time and error
+func (r *SQLiteRepository) UpdateSendRetry(retry *models.SendRetry) error {
+	retry.NextAttemptAt = retry.NextAttemptAt.UTC()
+	retry.UpdatedAt = time.Now()
+	result, err := r.db.Exec("UPDATE send_retries SET attempts = ?, next_attempt_at = ?, status = ?, last_error = ?, updated_at = ? WHERE id = ?",
+		retry.Attempts, retry.NextAttemptAt, retry.Status, retry.LastError, retry.UpdatedAt, retry.ID)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+const deadLetterColumns = "id, retry_id, message_id, recipient_id, app_id, message, attempts, last_error, created_at"
+
+func scanDeadLetter(row rowScanner, d *models.DeadLetter) error {
+	var message string
+	if err := row.Scan(&d.ID, &d.RetryID, &d.MessageID, &d.RecipientID, &d.AppID, &message, &d.Attempts, &d.LastError, &d.CreatedAt); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(message), &d.Message)
+}
+
+// ExhaustSendRetry marks a retry as exhausted and moves its message to the dead-letter table
+func (r *SQLiteRepository) ExhaustSendRetry(retry *models.SendRetry) (*models.DeadLetter, error) {
+	message, err := json.Marshal(retry.Message)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	retry.Status = models.RetryStatusExhausted
+	retry.UpdatedAt = time.Now()
+	if _, err := tx.Exec("UPDATE send_retries SET attempts = ?, status = ?, last_error = ?, updated_at = ? WHERE id = ?",
+		retry.Attempts, retry.Status, retry.LastError, retry.UpdatedAt, retry.ID); err != nil {
+		return nil, err
+	}
+
+	dead := &models.DeadLetter{
+		RetryID:     retry.ID,
+		MessageID:   retry.MessageID,
+		RecipientID: retry.RecipientID,
+		AppID:       retry.AppID,
+		Message:     retry.Message,
+		Attempts:    retry.Attempts,
+		LastError:   retry.LastError,
+		CreatedAt:   retry.UpdatedAt,
+	}
+	result, err := tx.Exec("INSERT INTO dead_letters (retry_id, message_id, recipient_id, app_id, message, attempts, last_error, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		dead.RetryID, dead.MessageID, dead.RecipientID, dead.AppID, string(message), dead.Attempts, dead.LastError, dead.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	dead.ID, _ = result.LastInsertId()
+	return dead, tx.Commit()
+}
+
+// ListDeadLetters returns a page of dead letters, newest first, with the total count
+func (r *SQLiteRepository) ListDeadLetters(limit, offset int) ([]models.DeadLetter, int, error) {
+	var total int
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM dead_letters").Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.db.Query("SELECT "+deadLetterColumns+" FROM dead_letters ORDER BY id DESC LIMIT ? OFFSET ?", limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	letters := []models.DeadLetter{}
+	for rows.Next() {
+		var d models.DeadLetter
+		if err := scanDeadLetter(rows, &d); err != nil {
+			return nil, 0, err
+		}
+		letters = append(letters, d)
+	}
+	return letters, total, rows.Err()
+}
+
+// RequeueDeadLetter puts a dead letter back on the retry queue with a fresh attempt budget,
+// removing it from the dead-letter table
+func (r *SQLiteRepository) RequeueDeadLetter(id int64, nextAttemptAt time.Time) (*models.SendRetry, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var dead models.DeadLetter
+	err = scanDeadLetter(tx.QueryRow("SELECT "+deadLetterColumns+" FROM dead_letters WHERE id = ?", id), &dead)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	message, _ := json.Marshal(dead.Message)
+
+	now := time.Now()
+	retry := &models.SendRetry{
+		MessageID:     dead.MessageID,
+		RecipientID:   dead.RecipientID,
+		AppID:         dead.AppID,
+		Message:       dead.Message,
+		NextAttemptAt: nextAttemptAt.UTC(),
+		Status:        models.RetryStatusPending,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	result, err := tx.Exec(
+		`INSERT INTO send_retries (message_id, recipient_id, app_id, message, attempts, next_attempt_at, status, last_error, created_at, updated_at)
+		VALUES (?, ?, ?, ?, 0, ?, ?, '', ?, ?)`,
+		retry.MessageID, retry.RecipientID, retry.AppID, string(message), retry.NextAttemptAt, retry.Status, now, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	retry.ID, _ = result.LastInsertId()
+	if _, err := tx.Exec("DELETE FROM dead_letters WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	return retry, tx.Commit()
+}
@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"wechat-notification/models"
+)
+
+const routingRuleColumns = "id, name, position, enabled, template_keys, tags, priorities, time_start, time_end, channels, skip_wechat, stop, created_at"
+
+func scanRoutingRule(row rowScanner, rule *models.RoutingRule) error {
+	var templateKeys, tags, priorities, channels string
+	if err := row.Scan(&rule.ID, &rule.Name, &rule.Position, &rule.Enabled, &templateKeys, &tags, &priorities,
+		&rule.TimeStart, &rule.TimeEnd, &channels, &rule.SkipWeChat, &rule.Stop, &rule.CreatedAt); err != nil {
+		return err
+	}
+	for _, field := range []struct {
+		data string
+		dest interface{}
+	}{
+		{templateKeys, &rule.TemplateKeys},
+		{tags, &rule.Tags},
+		{priorities, &rule.Priorities},
+		{channels, &rule.Channels},
+	} {
+		if err := json.Unmarshal([]byte(field.data), field.dest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// routingRuleArgs encodes the JSON columns of a rule, normalizing nil slices
+func routingRuleArgs(rule *models.RoutingRule) (templateKeys, tags, priorities, channels string) {
+	rule.TemplateKeys = nonNilStrings(rule.TemplateKeys)
+	rule.Tags = nonNilStrings(rule.Tags)
+	rule.Priorities = nonNilStrings(rule.Priorities)
+	rule.Channels = nonNilChannels(rule.Channels)
+	k, _ := json.Marshal(rule.TemplateKeys)
+	t, _ := json.Marshal(rule.Tags)
+	p, _ := json.Marshal(rule.Priorities)
+	c, _ := json.Marshal(rule.Channels)
+	return string(k), string(t), string(p), string(c)
+}
+
+// CreateRoutingRule adds a new routing rule
+func (r *SQLiteRepository) CreateRoutingRule(rule *models.RoutingRule) error {
+	templateKeys, tags, priorities, channels := routingRuleArgs(rule)
+	rule.CreatedAt = time.Now()
+	result, err := r.db.Exec(
+		`INSERT INTO routing_rules (name, position, enabled, template_keys, tags, priorities, time_start, time_end, channels, skip_wechat, stop, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rule.Name, rule.Position, rule.Enabled, templateKeys, tags, priorities, rule.TimeStart, rule.TimeEnd, channels, rule.SkipWeChat, rule.Stop, rule.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	rule.ID = id
+	return nil
+}
+
+// UpdateRoutingRule replaces a routing rule's settings
+func (r *SQLiteRepository) UpdateRoutingRule(rule *models.RoutingRule) error {
+	templateKeys, tags, priorities, channels := routingRuleArgs(rule)
+	result, err := r.db.Exec(
+		`UPDATE routing_rules SET name = ?, position = ?, enabled = ?, template_keys = ?, tags = ?, priorities = ?,
+		time_start = ?, time_end = ?, channels = ?, skip_wechat = ?, stop = ? WHERE id = ?`,
+		rule.Name, rule.Position, rule.Enabled, templateKeys, tags, priorities, rule.TimeStart, rule.TimeEnd, channels, rule.SkipWeChat, rule.Stop, rule.ID,
+	)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteRoutingRule removes a routing rule
+func (r *SQLiteRepository) DeleteRoutingRule(id int64) error {
+	result, err := r.db.Exec("DELETE FROM routing_rules WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetRoutingRuleByID retrieves a routing rule by ID
+func (r *SQLiteRepository) GetRoutingRuleByID(id int64) (*models.RoutingRule, error) {
+	var rule models.RoutingRule
+	err := scanRoutingRule(r.db.QueryRow("SELECT "+routingRuleColumns+" FROM routing_rules WHERE id = ?", id), &rule)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// GetAllRoutingRules retrieves all routing rules in evaluation order
+func (r *SQLiteRepository) GetAllRoutingRules() ([]models.RoutingRule, error) {
+	rows, err := r.db.Query("SELECT " + routingRuleColumns + " FROM routing_rules ORDER BY position, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []models.RoutingRule{}
+	for rows.Next() {
+		var rule models.RoutingRule
+		if err := scanRoutingRule(rows, &rule); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"wechat-notification/models"
+)
+
+const scheduledColumns = "id, template_key, keywords, recipient_ids, require_ack, reply_to, channels, tags, priority, send_at, status, message_id, last_error, created_at, source, app_id, url, miniprogram, mode, media_id, canary"
+
+func scanScheduled(row rowScanner, m *models.ScheduledMessage) error {
+	var keywords, recipientIDs, channels, tags, source, miniprogram, canary string
+	var messageID sql.NullInt64
+	if err := row.Scan(&m.ID, &m.TemplateKey, &keywords, &recipientIDs, &m.RequireAck, &m.ReplyTo, &channels, &tags, &m.Priority,
+		&m.SendAt, &m.Status, &messageID, &m.LastError, &m.CreatedAt, &source, &m.AppID, &m.URL, &miniprogram, &m.Mode, &m.MediaID, &canary); err != nil {
+		return err
+	}
+	if canary != "" {
+		if err := json.Unmarshal([]byte(canary), &m.Canary); err != nil {
+			return err
+		}
+	}
+	if messageID.Valid {
+		m.MessageID = &messageID.Int64
+	}
+	if err := json.Unmarshal([]byte(keywords), &m.Keywords); err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(recipientIDs), &m.RecipientIDs); err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(channels), &m.Channels); err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(tags), &m.Tags); err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(miniprogram), &m.Miniprogram); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(source), &m.Source)
+}
+
+// CreateScheduledMessage queues a message to be sent at m.SendAt
+func (r *SQLiteRepository) CreateScheduledMessage(m *models.ScheduledMessage) error {
+	keywords, _ := json.Marshal(m.Keywords)
+	recipientIDs, _ := json.Marshal(nonNilIDs(m.RecipientIDs))
+	channels, _ := json.Marshal(nonNilChannels(m.Channels))
+	tags, _ := json.Marshal(nonNilStrings(m.Tags))
+	source, _ := json.Marshal(m.Source)
+	miniprogram, _ := json.Marshal(m.Miniprogram)
+	var canary []byte
+	if m.Canary != nil {
+		canary, _ = json.Marshal(m.Canary)
+	}
+	// send_at is compared as text by SQLite, so it's always stored in UTC
+	m.SendAt = m.SendAt.UTC()
+	m.Status = models.ScheduledStatusPending
+	m.CreatedAt = time.Now()
+	result, err := r.db.Exec(
+		`INSERT INTO scheduled_messages (template_key, keywords, recipient_ids, require_ack, reply_to, channels, tags, priority, send_at, status, created_at, source, app_id, url, miniprogram, mode, media_id, canary)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		m.TemplateKey, string(keywords), string(recipientIDs), m.RequireAck, m.ReplyTo, string(channels), string(tags), m.Priority, m.SendAt, m.Status, m.CreatedAt, string(source), m.AppID, m.URL, string(miniprogram), m.Mode, m.MediaID, string(canary),
+	)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	m.ID = id
+	m.RecipientIDs = nonNilIDs(m.RecipientIDs)
+	m.Channels = nonNilChannels(m.Channels)
+	m.Tags = nonNilStrings(m.Tags)
+	return nil
+}
+
+// GetScheduledMessageByID retrieves a scheduled message by ID
+func (r *SQLiteRepository) GetScheduledMessageByID(id int64) (*models.ScheduledMessage, error) {
+	var m models.ScheduledMessage
+	err := scanScheduled(r.db.QueryRow("SELECT "+scheduledColumns+" FROM scheduled_messages WHERE id = ?", id), &m)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// ListScheduledMessages retrieves the most recently created scheduled messages
+func (r *SQLiteRepository) ListScheduledMessages(limit int) ([]models.ScheduledMessage, error) {
+	return r.queryScheduled("SELECT "+scheduledColumns+" FROM scheduled_messages ORDER BY id DESC LIMIT ?", limit)
+}
+
+// GetDueScheduledMessages retrieves pending messages whose send time has passed, oldest first
+func (r *SQLiteRepository) GetDueScheduledMessages(now time.Time) ([]models.ScheduledMessage, error) {
+	return r.queryScheduled("SELECT "+scheduledColumns+" FROM scheduled_messages WHERE status = ? AND send_at <= ? ORDER BY send_at, id",
+		models.ScheduledStatusPending, now.UTC())
+}
+
+func (r *SQLiteRepository) queryScheduled(query string, args ...interface{}) ([]models.ScheduledMessage, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []models.ScheduledMessage{}
+	for rows.Next() {
+		var m models.ScheduledMessage
+		if err := scanScheduled(rows, &m); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// ClaimScheduledMessage moves a pending message to sending, reporting false if it was
+// already claimed or cancelled so each message is delivered at most once
+func (r *SQLiteRepository) ClaimScheduledMessage(id int64) (bool, error) {
+	result, err := r.db.Exec("UPDATE scheduled_messages SET status = ? WHERE id = ? AND status = ?",
+		models.ScheduledStatusSending, id, models.ScheduledStatusPending)
+	if err != nil {
+		return false, err
+	}
+	rows, _ := result.RowsAffected()
+	return rows == 1, nil
+}
+
+// ResetSendingScheduledMessages moves messages left sending by a crash back to pending so they
+// are picked up again, returning how many were reset
+func (r *SQLiteRepository) ResetSendingScheduledMessages() (int64, error) {
+	result, err := r.db.Exec("UPDATE scheduled_messages SET status = ? WHERE status = ?",
+		models.ScheduledStatusPending, models.ScheduledStatusSending)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// CompleteScheduledMessage records the outcome of a claimed message; messageID is 0 when nothing was sent
+func (r *SQLiteRepository) CompleteScheduledMessage(id int64, status string, messageID int64, lastError string) error {
+	var mid interface{}
+	if messageID != 0 {
+		mid = messageID
+	}
+	_, err := r.db.Exec("UPDATE scheduled_messages SET status = ?, message_id = ?, last_error = ? WHERE id = ?", status, mid, lastError, id)
+	return err
+}
+
+// CancelScheduledMessage cancels a message that hasn't been sent yet
+func (r *SQLiteRepository) CancelScheduledMessage(id int64) error {
+	result, err := r.db.Exec("UPDATE scheduled_messages SET status = ? WHERE id = ? AND status = ?",
+		models.ScheduledStatusCancelled, id, models.ScheduledStatusPending)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
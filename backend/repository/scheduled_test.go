@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+)
+
+func TestScheduledMessageLifecycle(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	now := time.Now()
+	due := &models.ScheduledMessage{TemplateKey: "deploy", Keywords: map[string]string{"first": "x"}, SendAt: now.Add(-time.Second)}
+	later := &models.ScheduledMessage{TemplateKey: "deploy", Keywords: map[string]string{}, SendAt: now.Add(time.Hour)}
+	for _, m := range []*models.ScheduledMessage{due, later} {
+		if err := repo.CreateScheduledMessage(m); err != nil {
+			t.Fatalf("CreateScheduledMessage error: %v", err)
+		}
+	}
+
+	pending, err := repo.GetDueScheduledMessages(now)
+	if err != nil {
+		t.Fatalf("GetDueScheduledMessages error: %v", err)
+	}
+	if len(pending) != 1 || pending[0].ID != due.ID || pending[0].Keywords["first"] != "x" {
+		t.Fatalf("due messages = %+v, want only %d", pending, due.ID)
+	}
+
+	if ok, _ := repo.ClaimScheduledMessage(due.ID); !ok {
+		t.Fatal("first claim should succeed")
+	}
+	if ok, _ := repo.ClaimScheduledMessage(due.ID); ok {
+		t.Error("second claim should fail")
+	}
+	if err := repo.CompleteScheduledMessage(due.ID, models.ScheduledStatusSent, 7, ""); err != nil {
+		t.Fatalf("CompleteScheduledMessage error: %v", err)
+	}
+	got, _ := repo.GetScheduledMessageByID(due.ID)
+	if got.Status != models.ScheduledStatusSent || got.MessageID == nil || *got.MessageID != 7 {
+		t.Errorf("completed message = %+v", got)
+	}
+
+	if err := repo.CancelScheduledMessage(due.ID); err != ErrNotFound {
+		t.Errorf("cancelling a sent message: err = %v, want ErrNotFound", err)
+	}
+	if err := repo.CancelScheduledMessage(later.ID); err != nil {
+		t.Errorf("CancelScheduledMessage error: %v", err)
+	}
+	if ok, _ := repo.ClaimScheduledMessage(later.ID); ok {
+		t.Error("cancelled message should not be claimable")
+	}
+}
+
+func TestResetSendingScheduledMessages(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	m := &models.ScheduledMessage{TemplateKey: "deploy", Keywords: map[string]string{}, SendAt: time.Now().Add(-time.Second)}
+	repo.CreateScheduledMessage(m)
+	repo.ClaimScheduledMessage(m.ID)
+
+	if reset, err := repo.ResetSendingScheduledMessages(); err != nil || reset != 1 {
+		t.Fatalf("ResetSendingScheduledMessages = %d, %v, want 1", reset, err)
+	}
+	if due, _ := repo.GetDueScheduledMessages(time.Now()); len(due) != 1 || due[0].ID != m.ID {
+		t.Errorf("reset message should be due again: %+v", due)
+	}
+}
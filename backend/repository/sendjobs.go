@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"wechat-notification/models"
+)
+
+const sendJobColumns = "id, owner, status, request, result, error, created_at, finished_at"
+
+func scanSendJob(row rowScanner, j *models.SendJob) error {
+	var result string
+	var finishedAt sql.NullTime
+	if err := row.Scan(&j.ID, &j.Owner, &j.Status, &j.Request, &result, &j.Error, &j.CreatedAt, &finishedAt); err != nil {
+		return err
+	}
+	if result != "" {
+		j.Result = []byte(result)
+	}
+	if finishedAt.Valid {
+		j.FinishedAt = &finishedAt.Time
+	}
+	return nil
+}
+
+// CreateSendJob stores a queued send
+func (r *SQLiteRepository) CreateSendJob(j *models.SendJob) error {
+	j.CreatedAt = time.Now()
+	result, err := r.db.Exec("INSERT INTO send_jobs (owner, status, request, created_at) VALUES (?, ?, ?, ?)",
+		j.Owner, j.Status, j.Request, j.CreatedAt)
+	if err != nil {
+		return err
+	}
+	j.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// GetSendJob retrieves a send job by ID
+func (r *SQLiteRepository) GetSendJob(id int64) (*models.SendJob, error) {
+	var j models.SendJob
+	err := scanSendJob(r.db.QueryRow("SELECT "+sendJobColumns+" FROM send_jobs WHERE id = ?", id), &j)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// GetUnfinishedSendJobs retrieves the jobs that haven't finished, oldest first
+func (r *SQLiteRepository) GetUnfinishedSendJobs() ([]models.SendJob, error) {
+	rows, err := r.db.Query("SELECT " + sendJobColumns + " FROM send_jobs WHERE finished_at IS NULL ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []models.SendJob{}
+	for rows.Next() {
+		var j models.SendJob
+		if err := scanSendJob(rows, &j); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// SetSendJobStatus updates the status of an unfinished job
+func (r *SQLiteRepository) SetSendJobStatus(id int64, status string) error {
+	_, err := r.db.Exec("UPDATE send_jobs SET status = ? WHERE id = ?", status, id)
+	return err
+}
+
+// FinishSendJob records the outcome of a job: its status, result and error
+func (r *SQLiteRepository) FinishSendJob(j *models.SendJob) error {
+	now := time.Now()
+	j.FinishedAt = &now
+	_, err := r.db.Exec("UPDATE send_jobs SET status = ?, result = ?, error = ?, finished_at = ? WHERE id = ?",
+		j.Status, string(j.Result), j.Error, now, j.ID)
+	return err
+}
+
+// PruneSendJobs deletes finished jobs beyond the keep most recent ones
+func (r *SQLiteRepository) PruneSendJobs(keep int) error {
+	_, err := r.db.Exec("DELETE FROM send_jobs WHERE finished_at IS NOT NULL AND id NOT IN (SELECT id FROM send_jobs ORDER BY id DESC LIMIT ?)", keep)
+	return err
+}
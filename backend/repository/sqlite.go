@@ -2,7 +2,9 @@ package repository
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -17,11 +19,16 @@ import (
 var (
 	ErrNotFound        = errors.New("recipient not found")
 	ErrDuplicateOpenID = errors.New("openid already exists")
+	ErrDuplicateName   = errors.New("name already exists")
+	ErrDuplicateAppID  = errors.New("app id already exists")
+	ErrDuplicateKey    = errors.New("template key already exists")
+	ErrDuplicateSlug   = errors.New("slug already exists")
+	ErrAppInUse        = errors.New("app is used by templates or recipients")
 )
 
 // SQLiteRepository handles database operations
 type SQLiteRepository struct {
-	db *sql.DB
+	db *conn
 }
 
 // NewSQLiteRepository creates a new SQLite repository
@@ -37,7 +44,7 @@ func NewSQLiteRepository(dbPath string) (*SQLiteRepository, error) {
 		return nil, err
 	}
 
-	repo := &SQLiteRepository{db: db}
+	repo := &SQLiteRepository{db: &conn{db: db, timeout: DefaultQueryTimeout}}
 	if err := repo.initTables(); err != nil {
 		db.Close()
 		return nil, err
@@ -76,10 +83,494 @@ func (r *SQLiteRepository) initTables() error {
 		template_id TEXT NOT NULL,
 		name TEXT NOT NULL
 	)`
-	_, err := r.db.Exec(templatesQuery)
+	if _, err := r.db.Exec(templatesQuery); err != nil {
+		return err
+	}
+
+	unsubscriptionsQuery := `
+	CREATE TABLE IF NOT EXISTS recipient_unsubscriptions (
+		recipient_id INTEGER NOT NULL,
+		template_key TEXT NOT NULL,
+		PRIMARY KEY (recipient_id, template_key)
+	)`
+	if _, err := r.db.Exec(unsubscriptionsQuery); err != nil {
+		return err
+	}
+
+	acknowledgementsQuery := `
+	CREATE TABLE IF NOT EXISTS acknowledgements (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		token TEXT UNIQUE NOT NULL,
+		recipient_id INTEGER NOT NULL,
+		template_key TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		acknowledged_at DATETIME
+	)`
+	if _, err := r.db.Exec(acknowledgementsQuery); err != nil {
+		return err
+	}
+
+	groupsQuery := `
+	CREATE TABLE IF NOT EXISTS groups (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT UNIQUE NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+	if _, err := r.db.Exec(groupsQuery); err != nil {
+		return err
+	}
+
+	groupMembersQuery := `
+	CREATE TABLE IF NOT EXISTS group_members (
+		group_id INTEGER NOT NULL,
+		recipient_id INTEGER NOT NULL,
+		PRIMARY KEY (group_id, recipient_id)
+	)`
+	if _, err := r.db.Exec(groupMembersQuery); err != nil {
+		return err
+	}
+
+	feedsQuery := `
+	CREATE TABLE IF NOT EXISTS feeds (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		url TEXT NOT NULL,
+		interval_seconds INTEGER NOT NULL,
+		template_key TEXT NOT NULL,
+		group_ids TEXT NOT NULL DEFAULT '[]',
+		keywords TEXT NOT NULL DEFAULT '{}',
+		enabled INTEGER NOT NULL DEFAULT 1,
+		last_polled_at DATETIME,
+		last_error TEXT NOT NULL DEFAULT '',
+		seen_ids TEXT NOT NULL DEFAULT '[]'
+	)`
+	if _, err := r.db.Exec(feedsQuery); err != nil {
+		return err
+	}
+
+	monitorsQuery := `
+	CREATE TABLE IF NOT EXISTS monitors (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		url TEXT NOT NULL,
+		method TEXT NOT NULL DEFAULT 'GET',
+		expected_status INTEGER NOT NULL DEFAULT 200,
+		interval_seconds INTEGER NOT NULL,
+		timeout_seconds INTEGER NOT NULL,
+		template_key TEXT NOT NULL,
+		group_ids TEXT NOT NULL DEFAULT '[]',
+		keywords TEXT NOT NULL DEFAULT '{}',
+		enabled INTEGER NOT NULL DEFAULT 1,
+		status TEXT NOT NULL DEFAULT 'unknown',
+		last_checked_at DATETIME,
+		last_changed_at DATETIME,
+		last_error TEXT NOT NULL DEFAULT ''
+	)`
+	if _, err := r.db.Exec(monitorsQuery); err != nil {
+		return err
+	}
+
+	heartbeatsQuery := `
+	CREATE TABLE IF NOT EXISTS heartbeats (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		ping_key TEXT NOT NULL UNIQUE,
+		interval_seconds INTEGER NOT NULL,
+		grace_seconds INTEGER NOT NULL DEFAULT 0,
+		template_key TEXT NOT NULL,
+		group_ids TEXT NOT NULL DEFAULT '[]',
+		keywords TEXT NOT NULL DEFAULT '{}',
+		enabled INTEGER NOT NULL DEFAULT 1,
+		status TEXT NOT NULL DEFAULT 'unknown',
+		last_ping_at DATETIME,
+		last_changed_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+	if _, err := r.db.Exec(heartbeatsQuery); err != nil {
+		return err
+	}
+
+	calendarsQuery := `
+	CREATE TABLE IF NOT EXISTS calendars (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		url TEXT NOT NULL DEFAULT '',
+		content TEXT NOT NULL DEFAULT '',
+		lead_minutes TEXT NOT NULL DEFAULT '[]',
+		template_key TEXT NOT NULL,
+		group_ids TEXT NOT NULL DEFAULT '[]',
+		keywords TEXT NOT NULL DEFAULT '{}',
+		enabled INTEGER NOT NULL DEFAULT 1,
+		last_fetched_at DATETIME,
+		last_error TEXT NOT NULL DEFAULT '',
+		sent_keys TEXT NOT NULL DEFAULT '[]'
+	)`
+	if _, err := r.db.Exec(calendarsQuery); err != nil {
+		return err
+	}
+
+	messagesQuery := `
+	CREATE TABLE IF NOT EXISTS messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		template_key TEXT NOT NULL,
+		keywords TEXT NOT NULL DEFAULT '{}',
+		reply_to INTEGER,
+		thread_id INTEGER NOT NULL DEFAULT 0,
+		total_count INTEGER NOT NULL DEFAULT 0,
+		total_sent INTEGER NOT NULL DEFAULT 0,
+		total_failed INTEGER NOT NULL DEFAULT 0,
+		total_skipped INTEGER NOT NULL DEFAULT 0,
+		results TEXT NOT NULL DEFAULT '[]',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+	if _, err := r.db.Exec(messagesQuery); err != nil {
+		return err
+	}
+	if _, err := r.db.Exec("CREATE INDEX IF NOT EXISTS idx_messages_thread ON messages(thread_id)"); err != nil {
+		return err
+	}
+
+	scheduledQuery := `
+	CREATE TABLE IF NOT EXISTS scheduled_messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		template_key TEXT NOT NULL,
+		keywords TEXT NOT NULL DEFAULT '{}',
+		recipient_ids TEXT NOT NULL DEFAULT '[]',
+		require_ack INTEGER NOT NULL DEFAULT 0,
+		reply_to INTEGER NOT NULL DEFAULT 0,
+		channels TEXT NOT NULL DEFAULT '[]',
+		send_at DATETIME NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		message_id INTEGER,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+	if _, err := r.db.Exec(scheduledQuery); err != nil {
+		return err
+	}
+	if _, err := r.db.Exec("CREATE INDEX IF NOT EXISTS idx_scheduled_messages_due ON scheduled_messages(status, send_at)"); err != nil {
+		return err
+	}
+
+	sendRetriesQuery := `
+	CREATE TABLE IF NOT EXISTS send_retries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		message_id INTEGER NOT NULL DEFAULT 0,
+		recipient_id INTEGER NOT NULL,
+		message TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at DATETIME NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+	if _, err := r.db.Exec(sendRetriesQuery); err != nil {
+		return err
+	}
+	if _, err := r.db.Exec("CREATE INDEX IF NOT EXISTS idx_send_retries_due ON send_retries(status, next_attempt_at)"); err != nil {
+		return err
+	}
+
+	deadLettersQuery := `
+	CREATE TABLE IF NOT EXISTS dead_letters (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		retry_id INTEGER NOT NULL,
+		message_id INTEGER NOT NULL DEFAULT 0,
+		recipient_id INTEGER NOT NULL,
+		message TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+	if _, err := r.db.Exec(deadLettersQuery); err != nil {
+		return err
+	}
+
+	heldSendsQuery := `
+	CREATE TABLE IF NOT EXISTS held_sends (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		template_key TEXT NOT NULL,
+		keywords TEXT NOT NULL DEFAULT '{}',
+		recipient_ids TEXT NOT NULL DEFAULT '[]',
+		require_ack INTEGER NOT NULL DEFAULT 0,
+		reply_to INTEGER NOT NULL DEFAULT 0,
+		channels TEXT NOT NULL DEFAULT '[]',
+		tags TEXT NOT NULL DEFAULT '[]',
+		priority TEXT NOT NULL DEFAULT '',
+		source TEXT NOT NULL DEFAULT '{}',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+	if _, err := r.db.Exec(heldSendsQuery); err != nil {
+		return err
+	}
+
+	wechatAppsQuery := `
+	CREATE TABLE IF NOT EXISTS wechat_apps (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		app_id TEXT NOT NULL UNIQUE,
+		app_secret TEXT NOT NULL DEFAULT '',
+		template_id TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+	if _, err := r.db.Exec(wechatAppsQuery); err != nil {
+		return err
+	}
+
+	routingRulesQuery := `
+	CREATE TABLE IF NOT EXISTS routing_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		position INTEGER NOT NULL DEFAULT 0,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		template_keys TEXT NOT NULL DEFAULT '[]',
+		tags TEXT NOT NULL DEFAULT '[]',
+		priorities TEXT NOT NULL DEFAULT '[]',
+		time_start TEXT NOT NULL DEFAULT '',
+		time_end TEXT NOT NULL DEFAULT '',
+		channels TEXT NOT NULL DEFAULT '[]',
+		skip_wechat INTEGER NOT NULL DEFAULT 0,
+		stop INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+	if _, err := r.db.Exec(routingRulesQuery); err != nil {
+		return err
+	}
+
+	qrBindingsQuery := `
+	CREATE TABLE IF NOT EXISTS qr_bindings (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		scene TEXT NOT NULL UNIQUE,
+		name TEXT NOT NULL DEFAULT '',
+		recipient_id INTEGER NOT NULL DEFAULT 0,
+		group_ids TEXT NOT NULL DEFAULT '[]',
+		app_id INTEGER NOT NULL DEFAULT 0,
+		image_url TEXT NOT NULL DEFAULT '',
+		expires_at DATETIME NOT NULL,
+		bound_at DATETIME,
+		bound_open_id TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+	if _, err := r.db.Exec(qrBindingsQuery); err != nil {
+		return err
+	}
+
+	customEndpointsQuery := `
+	CREATE TABLE IF NOT EXISTS custom_endpoints (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		slug TEXT NOT NULL UNIQUE,
+		token TEXT NOT NULL,
+		template_key TEXT NOT NULL,
+		recipient_ids TEXT NOT NULL DEFAULT '[]',
+		keyword_templates TEXT NOT NULL DEFAULT '{}',
+		enabled INTEGER NOT NULL DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+	if _, err := r.db.Exec(customEndpointsQuery); err != nil {
+		return err
+	}
+
+	sendJobsQuery := `
+	CREATE TABLE IF NOT EXISTS send_jobs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		owner TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL,
+		request TEXT NOT NULL DEFAULT '{}',
+		result TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		finished_at DATETIME
+	)`
+	if _, err := r.db.Exec(sendJobsQuery); err != nil {
+		return err
+	}
+
+	return r.migrateColumns()
+}
+
+// columnMigrations lists the columns introduced after the initial schema. The schema version
+// recorded in the database is the length of this list.
+var columnMigrations = []struct {
+	table, name, definition string
+}{
+	{"recipients", "muted", "INTEGER NOT NULL DEFAULT 0"},
+	{"recipients", "quiet_hours_start", "TEXT NOT NULL DEFAULT ''"},
+	{"recipients", "quiet_hours_end", "TEXT NOT NULL DEFAULT ''"},
+	{"recipients", "metadata", "TEXT NOT NULL DEFAULT '{}'"},
+	{"groups", "channels", "TEXT NOT NULL DEFAULT '[]'"},
+	{"recipients", "channels", "TEXT NOT NULL DEFAULT '[]'"},
+	{"templates", "default_group_ids", "TEXT NOT NULL DEFAULT '[]'"},
+	{"scheduled_messages", "tags", "TEXT NOT NULL DEFAULT '[]'"},
+	{"scheduled_messages", "priority", "TEXT NOT NULL DEFAULT ''"},
+	{"recipients", "fallback_channel", "TEXT NOT NULL DEFAULT ''"},
+	{"recipients", "email", "TEXT NOT NULL DEFAULT ''"},
+	{"recipients", "telegram_chat_id", "TEXT NOT NULL DEFAULT ''"},
+	{"recipients", "preferences", "TEXT NOT NULL DEFAULT '[]'"},
+	{"recipients", "last_delivered_at", "DATETIME"},
+	{"recipients", "unsubscribed_failures", "INTEGER NOT NULL DEFAULT 0"},
+	{"templates", "title", "TEXT NOT NULL DEFAULT ''"},
+	{"templates", "industry", "TEXT NOT NULL DEFAULT ''"},
+	{"templates", "fields", "TEXT NOT NULL DEFAULT '[]'"},
+	{"templates", "upstream_missing", "INTEGER NOT NULL DEFAULT 0"},
+	{"templates", "synced_at", "DATETIME"},
+	{"messages", "total_throttled", "INTEGER NOT NULL DEFAULT 0"},
+	{"recipients", "unsubscribed", "INTEGER NOT NULL DEFAULT 0"},
+	{"messages", "adapter", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "token_name", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "source_ip", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "user_agent", "TEXT NOT NULL DEFAULT ''"},
+	{"scheduled_messages", "source", "TEXT NOT NULL DEFAULT '{}'"},
+	{"held_sends", "app_id", "INTEGER NOT NULL DEFAULT 0"},
+	{"templates", "app_id", "INTEGER NOT NULL DEFAULT 0"},
+	{"templates", "is_default", "INTEGER NOT NULL DEFAULT 0"},
+	{"scheduled_messages", "app_id", "INTEGER NOT NULL DEFAULT 0"},
+	{"send_retries", "app_id", "INTEGER NOT NULL DEFAULT 0"},
+	{"dead_letters", "app_id", "INTEGER NOT NULL DEFAULT 0"},
+	{"messages", "sandbox", "INTEGER NOT NULL DEFAULT 0"},
+	{"scheduled_messages", "url", "TEXT NOT NULL DEFAULT ''"},
+	{"held_sends", "url", "TEXT NOT NULL DEFAULT ''"},
+	{"scheduled_messages", "miniprogram", "TEXT NOT NULL DEFAULT 'null'"},
+	{"held_sends", "miniprogram", "TEXT NOT NULL DEFAULT 'null'"},
+	{"recipients", "app_id", "INTEGER NOT NULL DEFAULT 0"},
+	{"templates", "mode", "TEXT NOT NULL DEFAULT 'template'"},
+	{"scheduled_messages", "mode", "TEXT NOT NULL DEFAULT ''"},
+	{"held_sends", "mode", "TEXT NOT NULL DEFAULT ''"},
+	{"scheduled_messages", "media_id", "TEXT NOT NULL DEFAULT ''"},
+	{"held_sends", "media_id", "TEXT NOT NULL DEFAULT ''"},
+	{"scheduled_messages", "canary", "TEXT NOT NULL DEFAULT ''"},
+	{"recipients", "nickname", "TEXT NOT NULL DEFAULT ''"},
+	{"recipients", "avatar_url", "TEXT NOT NULL DEFAULT ''"},
+	{"recipients", "language", "TEXT NOT NULL DEFAULT ''"},
+	{"recipients", "profile_synced_at", "DATETIME"},
+	{"held_sends", "group_channels", "TEXT NOT NULL DEFAULT '[]'"},
+	{"messages", "app_id", "INTEGER NOT NULL DEFAULT 0"},
+	{"held_sends", "last_error", "TEXT NOT NULL DEFAULT ''"},
+	{"recipients", "deactivated", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// migrateColumns adds columns introduced after the initial schema to existing databases and
+// records the schema version
+func (r *SQLiteRepository) migrateColumns() error {
+	for _, col := range columnMigrations {
+		if err := r.addColumnIfMissing(col.table, col.name, col.definition); err != nil {
+			return err
+		}
+	}
+	_, err := r.db.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(columnMigrations)))
+	return err
+}
+
+// SchemaVersion returns the schema version recorded by the last migration
+func (r *SQLiteRepository) SchemaVersion() (int, error) {
+	var version int
+	err := r.db.QueryRow("PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+// addColumnIfMissing runs ALTER TABLE ADD COLUMN unless the column already exists
+func (r *SQLiteRepository) addColumnIfMissing(table, column, definition string) error {
+	rows, err := r.db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = r.db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
 	return err
 }
 
+// recipientColumns is the column list matching scanRecipient
+const recipientColumns = "id, open_id, app_id, name, muted, quiet_hours_start, quiet_hours_end, metadata, channels, fallback_channel, email, telegram_chat_id, preferences, last_delivered_at, unsubscribed_failures, unsubscribed, deactivated, nickname, avatar_url, language, profile_synced_at, created_at, updated_at"
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanRecipient scans a row selected with recipientColumns
+func scanRecipient(row rowScanner, rec *models.Recipient) error {
+	var metadata, channels, fallback, preferences string
+	var lastDelivered, profileSynced sql.NullTime
+	if err := row.Scan(&rec.ID, &rec.OpenID, &rec.AppID, &rec.Name, &rec.Muted, &rec.QuietHoursStart, &rec.QuietHoursEnd, &metadata, &channels, &fallback,
+		&rec.Email, &rec.TelegramChatID, &preferences, &lastDelivered, &rec.UnsubscribedFailures, &rec.Unsubscribed, &rec.Deactivated,
+		&rec.Nickname, &rec.AvatarURL, &rec.Language, &profileSynced, &rec.CreatedAt, &rec.UpdatedAt); err != nil {
+		return err
+	}
+	if lastDelivered.Valid {
+		rec.LastDeliveredAt = &lastDelivered.Time
+	}
+	if profileSynced.Valid {
+		rec.ProfileSyncedAt = &profileSynced.Time
+	}
+	rec.Metadata = map[string]string{}
+	if metadata != "" {
+		if err := json.Unmarshal([]byte(metadata), &rec.Metadata); err != nil {
+			return err
+		}
+	}
+	rec.Channels = []models.Channel{}
+	if channels != "" {
+		if err := json.Unmarshal([]byte(channels), &rec.Channels); err != nil {
+			return err
+		}
+	}
+	if fallback != "" {
+		rec.FallbackChannel = &models.Channel{}
+		if err := json.Unmarshal([]byte(fallback), rec.FallbackChannel); err != nil {
+			return err
+		}
+	}
+	rec.Preferences = []string{}
+	if preferences != "" {
+		if err := json.Unmarshal([]byte(preferences), &rec.Preferences); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeFallbackChannel serializes a recipient's fallback channel, empty when unset
+func encodeFallbackChannel(ch *models.Channel) string {
+	if ch == nil {
+		return ""
+	}
+	data, _ := json.Marshal(ch)
+	return string(data)
+}
+
+// encodeMetadata serializes recipient metadata for storage
+func encodeMetadata(metadata map[string]string) (string, error) {
+	if len(metadata) == 0 {
+		return "{}", nil
+	}
+	data, err := json.Marshal(metadata)
+	return string(data), err
+}
+
 // Close closes the database connection
 func (r *SQLiteRepository) Close() error {
 	return r.db.Close()
@@ -96,10 +587,19 @@ func (r *SQLiteRepository) Create(recipient *models.Recipient) error {
 		return ErrDuplicateOpenID
 	}
 
+	metadata, err := encodeMetadata(recipient.Metadata)
+	if err != nil {
+		return err
+	}
+
+	channels, _ := json.Marshal(nonNilChannels(recipient.Channels))
+	preferences, _ := json.Marshal(nonNilStrings(recipient.Preferences))
+
 	now := time.Now()
 	result, err := r.db.Exec(
-		"INSERT INTO recipients (open_id, name, created_at, updated_at) VALUES (?, ?, ?, ?)",
-		recipient.OpenID, recipient.Name, now, now,
+		"INSERT INTO recipients (open_id, app_id, name, metadata, channels, fallback_channel, email, telegram_chat_id, preferences, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		recipient.OpenID, recipient.AppID, recipient.Name, metadata, string(channels), encodeFallbackChannel(recipient.FallbackChannel),
+		recipient.Email, recipient.TelegramChatID, string(preferences), now, now,
 	)
 	if err != nil {
 		return err
@@ -113,12 +613,17 @@ func (r *SQLiteRepository) Create(recipient *models.Recipient) error {
 	recipient.ID = id
 	recipient.CreatedAt = now
 	recipient.UpdatedAt = now
+	if recipient.Metadata == nil {
+		recipient.Metadata = map[string]string{}
+	}
+	recipient.Channels = nonNilChannels(recipient.Channels)
+	recipient.Preferences = nonNilStrings(recipient.Preferences)
 	return nil
 }
 
 // GetAll retrieves all recipients from the database
 func (r *SQLiteRepository) GetAll() ([]models.Recipient, error) {
-	rows, err := r.db.Query("SELECT id, open_id, name, created_at, updated_at FROM recipients ORDER BY id")
+	rows, err := r.db.Query("SELECT " + recipientColumns + " FROM recipients ORDER BY id")
 	if err != nil {
 		return nil, err
 	}
@@ -127,7 +632,7 @@ func (r *SQLiteRepository) GetAll() ([]models.Recipient, error) {
 	var recipients []models.Recipient
 	for rows.Next() {
 		var rec models.Recipient
-		if err := rows.Scan(&rec.ID, &rec.OpenID, &rec.Name, &rec.CreatedAt, &rec.UpdatedAt); err != nil {
+		if err := scanRecipient(rows, &rec); err != nil {
 			return nil, err
 		}
 		recipients = append(recipients, rec)
@@ -148,10 +653,7 @@ func (r *SQLiteRepository) GetAll() ([]models.Recipient, error) {
 // GetByID retrieves a recipient by ID
 func (r *SQLiteRepository) GetByID(id int64) (*models.Recipient, error) {
 	var rec models.Recipient
-	err := r.db.QueryRow(
-		"SELECT id, open_id, name, created_at, updated_at FROM recipients WHERE id = ?",
-		id,
-	).Scan(&rec.ID, &rec.OpenID, &rec.Name, &rec.CreatedAt, &rec.UpdatedAt)
+	err := scanRecipient(r.db.QueryRow("SELECT "+recipientColumns+" FROM recipients WHERE id = ?", id), &rec)
 
 	if err == sql.ErrNoRows {
 		return nil, ErrNotFound
@@ -181,10 +683,19 @@ func (r *SQLiteRepository) Update(recipient *models.Recipient) error {
 		return err
 	}
 
+	metadata, err := encodeMetadata(recipient.Metadata)
+	if err != nil {
+		return err
+	}
+
+	channels, _ := json.Marshal(nonNilChannels(recipient.Channels))
+	preferences, _ := json.Marshal(nonNilStrings(recipient.Preferences))
+
 	now := time.Now()
 	_, err = r.db.Exec(
-		"UPDATE recipients SET open_id = ?, name = ?, updated_at = ? WHERE id = ?",
-		recipient.OpenID, recipient.Name, now, recipient.ID,
+		"UPDATE recipients SET open_id = ?, app_id = ?, name = ?, metadata = ?, channels = ?, fallback_channel = ?, email = ?, telegram_chat_id = ?, preferences = ?, unsubscribed = ?, updated_at = ? WHERE id = ?",
+		recipient.OpenID, recipient.AppID, recipient.Name, metadata, string(channels), encodeFallbackChannel(recipient.FallbackChannel),
+		recipient.Email, recipient.TelegramChatID, string(preferences), recipient.Unsubscribed, now, recipient.ID,
 	)
 	if err != nil {
 		return err
@@ -194,25 +705,210 @@ func (r *SQLiteRepository) Update(recipient *models.Recipient) error {
 	return nil
 }
 
-// Delete removes a recipient by ID
+// recipientCleanup lists what happens to rows referring to a recipient when it is deleted. Tables
+// added later that refer to recipients belong here. Confirmed acknowledgements are kept for the
+// history but detached from the recipient; everything else is removed.
+var recipientCleanup = []string{
+	"DELETE FROM recipient_unsubscriptions WHERE recipient_id = ?",
+	"DELETE FROM group_members WHERE recipient_id = ?",
+	"DELETE FROM acknowledgements WHERE recipient_id = ? AND acknowledged_at IS NULL",
+	"UPDATE acknowledgements SET recipient_id = 0 WHERE recipient_id = ?",
+	"DELETE FROM send_retries WHERE recipient_id = ?",
+	"DELETE FROM dead_letters WHERE recipient_id = ?",
+	"DELETE FROM qr_bindings WHERE recipient_id = ? AND bound_at IS NULL",
+	"UPDATE qr_bindings SET recipient_id = 0 WHERE recipient_id = ?",
+}
+
+// Delete removes a recipient by ID along with its references in other tables, in one transaction.
+// Pending scheduled and held sends no longer address it; a scheduled message left without
+// recipients is cancelled rather than falling back to the template's default groups.
 func (r *SQLiteRepository) Delete(id int64) error {
-	result, err := r.db.Exec("DELETE FROM recipients WHERE id = ?", id)
+	tx, err := r.db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
+	result, err := tx.Exec("DELETE FROM recipients WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
-
 	if rowsAffected == 0 {
 		return ErrNotFound
 	}
 
+	for _, query := range recipientCleanup {
+		if _, err := tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
+	if err := removeFromRecipientLists(tx, "scheduled_messages", "status = '"+models.ScheduledStatusPending+"' AND recipient_ids != '[]'", id, func(listID int64) error {
+		_, err := tx.Exec("UPDATE scheduled_messages SET status = ?, last_error = ? WHERE id = ?",
+			models.ScheduledStatusCancelled, "all recipients were deleted", listID)
+		return err
+	}); err != nil {
+		return err
+	}
+	if err := removeFromRecipientLists(tx, "held_sends", "recipient_ids != '[]'", id, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// removeFromRecipientLists drops id from the recipient_ids lists of the table's rows matching where.
+// onEmpty is called for rows left without recipients.
+func removeFromRecipientLists(tx *sql.Tx, table, where string, id int64, onEmpty func(rowID int64) error) error {
+	rows, err := tx.Query("SELECT id, recipient_ids FROM " + table + " WHERE " + where)
+	if err != nil {
+		return err
+	}
+	updated := map[int64][]int64{}
+	for rows.Next() {
+		var rowID int64
+		var raw string
+		var ids []int64
+		if err := rows.Scan(&rowID, &raw); err != nil {
+			rows.Close()
+			return err
+		}
+		if err := json.Unmarshal([]byte(raw), &ids); err != nil {
+			rows.Close()
+			return err
+		}
+		kept := []int64{}
+		for _, rid := range ids {
+			if rid != id {
+				kept = append(kept, rid)
+			}
+		}
+		if len(kept) != len(ids) {
+			updated[rowID] = kept
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for rowID, kept := range updated {
+		list, _ := json.Marshal(kept)
+		if _, err := tx.Exec("UPDATE "+table+" SET recipient_ids = ? WHERE id = ?", string(list), rowID); err != nil {
+			return err
+		}
+		if len(kept) == 0 && onEmpty != nil {
+			if err := onEmpty(rowID); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
+// UpdateProfile updates the self-service fields of a recipient
+func (r *SQLiteRepository) UpdateProfile(recipient *models.Recipient) error {
+	now := time.Now()
+	result, err := r.db.Exec(
+		"UPDATE recipients SET name = ?, muted = ?, quiet_hours_start = ?, quiet_hours_end = ?, updated_at = ? WHERE id = ?",
+		recipient.Name, recipient.Muted, recipient.QuietHoursStart, recipient.QuietHoursEnd, now, recipient.ID,
+	)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	recipient.UpdatedAt = now
+	return nil
+}
+
+// SetWeChatProfile stores the nickname, avatar and language fetched from WeChat for a recipient
+func (r *SQLiteRepository) SetWeChatProfile(recipient *models.Recipient) error {
+	result, err := r.db.Exec(
+		"UPDATE recipients SET nickname = ?, avatar_url = ?, language = ?, profile_synced_at = ? WHERE id = ?",
+		recipient.Nickname, recipient.AvatarURL, recipient.Language, recipient.ProfileSyncedAt, recipient.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetByOpenID retrieves a recipient by OpenID
+func (r *SQLiteRepository) GetByOpenID(openID string) (*models.Recipient, error) {
+	var rec models.Recipient
+	err := scanRecipient(r.db.QueryRow("SELECT "+recipientColumns+" FROM recipients WHERE open_id = ?", openID), &rec)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// GetUnsubscribedTemplateKeys returns the template keys a recipient has opted out of
+func (r *SQLiteRepository) GetUnsubscribedTemplateKeys(recipientID int64) ([]string, error) {
+	rows, err := r.db.Query("SELECT template_key FROM recipient_unsubscriptions WHERE recipient_id = ? ORDER BY template_key", recipientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// SetTemplateSubscription opts a recipient in to or out of a template
+func (r *SQLiteRepository) SetTemplateSubscription(recipientID int64, templateKey string, subscribed bool) error {
+	if subscribed {
+		_, err := r.db.Exec("DELETE FROM recipient_unsubscriptions WHERE recipient_id = ? AND template_key = ?", recipientID, templateKey)
+		return err
+	}
+	_, err := r.db.Exec("INSERT OR IGNORE INTO recipient_unsubscriptions (recipient_id, template_key) VALUES (?, ?)", recipientID, templateKey)
+	return err
+}
+
+// GetUnsubscribedRecipientIDs returns the IDs of recipients who opted out of a template
+func (r *SQLiteRepository) GetUnsubscribedRecipientIDs(templateKey string) (map[int64]bool, error) {
+	rows, err := r.db.Query("SELECT recipient_id FROM recipient_unsubscriptions WHERE template_key = ?", templateKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// CountRecipients returns the number of recipients
+func (r *SQLiteRepository) CountRecipients() (int, error) {
+	var count int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM recipients").Scan(&count)
+	return count, err
+}
+
 // OpenIDExists checks if an OpenID already exists in the database
 func (r *SQLiteRepository) OpenIDExists(openID string) (bool, error) {
 	var count int
@@ -223,11 +919,10 @@ func (r *SQLiteRepository) OpenIDExists(openID string) (bool, error) {
 	return count > 0, nil
 }
 
-
 // GetWeChatConfig retrieves WeChat configuration from database
 func (r *SQLiteRepository) GetWeChatConfig() (*models.WeChatConfig, error) {
 	config := &models.WeChatConfig{}
-	
+
 	rows, err := r.db.Query("SELECT key, value FROM config WHERE key IN ('wechat_app_id', 'wechat_app_secret', 'wechat_template_id')")
 	if err != nil {
 		return nil, err
@@ -279,7 +974,6 @@ func (r *SQLiteRepository) SaveWeChatConfig(config *models.WeChatConfig) error {
 	return tx.Commit()
 }
 
-
 // GetConfig retrieves a config value by key
 func (r *SQLiteRepository) GetConfig(key string) (string, error) {
 	var value string
@@ -290,12 +984,48 @@ func (r *SQLiteRepository) GetConfig(key string) (string, error) {
 	return value, err
 }
 
+// GetAllConfig retrieves every config value by key
+func (r *SQLiteRepository) GetAllConfig() (map[string]string, error) {
+	rows, err := r.db.Query("SELECT key, value FROM config")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := map[string]string{}
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, rows.Err()
+}
+
 // SetConfig saves a config value
 func (r *SQLiteRepository) SetConfig(key, value string) error {
 	_, err := r.db.Exec("INSERT OR REPLACE INTO config (key, value) VALUES (?, ?)", key, value)
 	return err
 }
 
+// GetJSONConfig decodes a JSON config value into v, leaving v untouched when unset
+func (r *SQLiteRepository) GetJSONConfig(key string, v interface{}) error {
+	value, err := r.GetConfig(key)
+	if err != nil || value == "" {
+		return err
+	}
+	return json.Unmarshal([]byte(value), v)
+}
+
+// SetJSONConfig stores v as a JSON config value
+func (r *SQLiteRepository) SetJSONConfig(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return r.SetConfig(key, string(data))
+}
+
 // GetByIDs retrieves recipients by their IDs
 func (r *SQLiteRepository) GetByIDs(ids []int64) ([]models.Recipient, error) {
 	if len(ids) == 0 {
@@ -310,7 +1040,7 @@ func (r *SQLiteRepository) GetByIDs(ids []int64) ([]models.Recipient, error) {
 		args[i] = id
 	}
 
-	query := "SELECT id, open_id, name, created_at, updated_at FROM recipients WHERE id IN (" + strings.Join(placeholders, ",") + ")"
+	query := "SELECT " + recipientColumns + " FROM recipients WHERE id IN (" + strings.Join(placeholders, ",") + ")"
 	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return nil, err
@@ -320,7 +1050,7 @@ func (r *SQLiteRepository) GetByIDs(ids []int64) ([]models.Recipient, error) {
 	var recipients []models.Recipient
 	for rows.Next() {
 		var rec models.Recipient
-		if err := rows.Scan(&rec.ID, &rec.OpenID, &rec.Name, &rec.CreatedAt, &rec.UpdatedAt); err != nil {
+		if err := scanRecipient(rows, &rec); err != nil {
 			return nil, err
 		}
 		recipients = append(recipients, rec)
@@ -332,14 +1062,41 @@ func (r *SQLiteRepository) GetByIDs(ids []int64) ([]models.Recipient, error) {
 	return recipients, rows.Err()
 }
 
+// templateColumns is the column list matching scanTemplate
+const templateColumns = "id, key, template_id, name, app_id, default_group_ids, title, industry, fields, upstream_missing, synced_at, is_default, mode"
+
+func scanTemplate(row rowScanner, t *models.MessageTemplate) error {
+	var groupIDs, fields string
+	var syncedAt sql.NullTime
+	if err := row.Scan(&t.ID, &t.Key, &t.TemplateID, &t.Name, &t.AppID, &groupIDs, &t.Title, &t.Industry, &fields, &t.UpstreamMissing, &syncedAt, &t.IsDefault, &t.Mode); err != nil {
+		return err
+	}
+	if syncedAt.Valid {
+		t.SyncedAt = &syncedAt.Time
+	}
+	if err := json.Unmarshal([]byte(fields), &t.Fields); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(groupIDs), &t.DefaultGroupIDs)
+}
 
 // CreateTemplate creates a new message template
 func (r *SQLiteRepository) CreateTemplate(template *models.MessageTemplate) error {
+	template.DefaultGroupIDs = nonNilIDs(template.DefaultGroupIDs)
+	template.Fields = nonNilStrings(template.Fields)
+	if template.Mode == "" {
+		template.Mode = models.TemplateModeTemplate
+	}
+	groupIDs, _ := json.Marshal(template.DefaultGroupIDs)
+	fields, _ := json.Marshal(template.Fields)
 	result, err := r.db.Exec(
-		"INSERT INTO templates (key, template_id, name) VALUES (?, ?, ?)",
-		template.Key, template.TemplateID, template.Name,
+		"INSERT INTO templates (key, template_id, name, app_id, default_group_ids, fields, mode) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		template.Key, template.TemplateID, template.Name, template.AppID, string(groupIDs), string(fields), template.Mode,
 	)
 	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateKey
+		}
 		return err
 	}
 	id, _ := result.LastInsertId()
@@ -347,9 +1104,51 @@ func (r *SQLiteRepository) CreateTemplate(template *models.MessageTemplate) erro
 	return nil
 }
 
+// UpdateTemplateMetadata stores the title, industry and fields synced from WeChat, or marks the
+// template as missing upstream when its template ID is no longer on the account
+func (r *SQLiteRepository) UpdateTemplateMetadata(template *models.MessageTemplate) error {
+	fields, _ := json.Marshal(nonNilStrings(template.Fields))
+	result, err := r.db.Exec(
+		"UPDATE templates SET title = ?, industry = ?, fields = ?, upstream_missing = ?, synced_at = ? WHERE id = ?",
+		template.Title, template.Industry, string(fields), template.UpstreamMissing, template.SyncedAt, template.ID,
+	)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UpdateTemplate updates a template's WeChat template ID, name, official account, default groups,
+// keyword schema and send mode
+func (r *SQLiteRepository) UpdateTemplate(template *models.MessageTemplate) error {
+	template.DefaultGroupIDs = nonNilIDs(template.DefaultGroupIDs)
+	template.Fields = nonNilStrings(template.Fields)
+	if template.Mode == "" {
+		template.Mode = models.TemplateModeTemplate
+	}
+	groupIDs, _ := json.Marshal(template.DefaultGroupIDs)
+	fields, _ := json.Marshal(template.Fields)
+	result, err := r.db.Exec(
+		"UPDATE templates SET template_id = ?, name = ?, app_id = ?, default_group_ids = ?, fields = ?, mode = ? WHERE id = ?",
+		template.TemplateID, template.Name, template.AppID, string(groupIDs), string(fields), template.Mode, template.ID,
+	)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 // GetAllTemplates retrieves all templates
 func (r *SQLiteRepository) GetAllTemplates() ([]models.MessageTemplate, error) {
-	rows, err := r.db.Query("SELECT id, key, template_id, name FROM templates ORDER BY id")
+	rows, err := r.db.Query("SELECT " + templateColumns + " FROM templates ORDER BY id")
 	if err != nil {
 		return nil, err
 	}
@@ -358,7 +1157,7 @@ func (r *SQLiteRepository) GetAllTemplates() ([]models.MessageTemplate, error) {
 	var templates []models.MessageTemplate
 	for rows.Next() {
 		var t models.MessageTemplate
-		if err := rows.Scan(&t.ID, &t.Key, &t.TemplateID, &t.Name); err != nil {
+		if err := scanTemplate(rows, &t); err != nil {
 			return nil, err
 		}
 		templates = append(templates, t)
@@ -369,17 +1168,57 @@ func (r *SQLiteRepository) GetAllTemplates() ([]models.MessageTemplate, error) {
 	return templates, rows.Err()
 }
 
+// GetTemplateByID retrieves a template by ID
+func (r *SQLiteRepository) GetTemplateByID(id int64) (*models.MessageTemplate, error) {
+	var t models.MessageTemplate
+	err := scanTemplate(r.db.QueryRow("SELECT "+templateColumns+" FROM templates WHERE id = ?", id), &t)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return &t, err
+}
+
 // GetTemplateByKey retrieves a template by key
 func (r *SQLiteRepository) GetTemplateByKey(key string) (*models.MessageTemplate, error) {
 	var t models.MessageTemplate
-	err := r.db.QueryRow("SELECT id, key, template_id, name FROM templates WHERE key = ?", key).
-		Scan(&t.ID, &t.Key, &t.TemplateID, &t.Name)
+	err := scanTemplate(r.db.QueryRow("SELECT "+templateColumns+" FROM templates WHERE key = ?", key), &t)
 	if err == sql.ErrNoRows {
 		return nil, ErrNotFound
 	}
 	return &t, err
 }
 
+// GetDefaultTemplate retrieves the template used by sends that don't name one
+func (r *SQLiteRepository) GetDefaultTemplate() (*models.MessageTemplate, error) {
+	var t models.MessageTemplate
+	err := scanTemplate(r.db.QueryRow("SELECT "+templateColumns+" FROM templates WHERE is_default = 1 ORDER BY id LIMIT 1"), &t)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return &t, err
+}
+
+// SetDefaultTemplate makes the template the default, replacing the previous one
+func (r *SQLiteRepository) SetDefaultTemplate(id int64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE templates SET is_default = 1 WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.Exec("UPDATE templates SET is_default = 0 WHERE id != ? AND is_default = 1", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 // DeleteTemplate deletes a template by ID
 func (r *SQLiteRepository) DeleteTemplate(id int64) error {
 	result, err := r.db.Exec("DELETE FROM templates WHERE id = ?", id)
@@ -392,3 +1231,114 @@ func (r *SQLiteRepository) DeleteTemplate(id int64) error {
 	}
 	return nil
 }
+
+// CreateAcknowledgement stores a pending acknowledgement
+func (r *SQLiteRepository) CreateAcknowledgement(ack *models.Acknowledgement) error {
+	now := time.Now()
+	result, err := r.db.Exec(
+		"INSERT INTO acknowledgements (token, recipient_id, template_key, created_at) VALUES (?, ?, ?, ?)",
+		ack.Token, ack.RecipientID, ack.TemplateKey, now,
+	)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	ack.ID = id
+	ack.CreatedAt = now
+	return nil
+}
+
+// GetAcknowledgementByToken retrieves an acknowledgement by its link token
+func (r *SQLiteRepository) GetAcknowledgementByToken(token string) (*models.Acknowledgement, error) {
+	var ack models.Acknowledgement
+	var ackedAt sql.NullTime
+	err := r.db.QueryRow(`
+		SELECT a.id, a.token, a.recipient_id, COALESCE(rc.name, ''), a.template_key, a.created_at, a.acknowledged_at
+		FROM acknowledgements a LEFT JOIN recipients rc ON rc.id = a.recipient_id
+		WHERE a.token = ?`, token,
+	).Scan(&ack.ID, &ack.Token, &ack.RecipientID, &ack.RecipientName, &ack.TemplateKey, &ack.CreatedAt, &ackedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ackedAt.Valid {
+		ack.AcknowledgedAt = &ackedAt.Time
+	}
+	return &ack, nil
+}
+
+// MarkAcknowledged records the acknowledgement time if not already set
+func (r *SQLiteRepository) MarkAcknowledged(token string) error {
+	result, err := r.db.Exec("UPDATE acknowledgements SET acknowledged_at = ? WHERE token = ? AND acknowledged_at IS NULL", time.Now(), token)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		if _, err := r.GetAcknowledgementByToken(token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteAcknowledgements removes acknowledgement links by ID
+func (r *SQLiteRepository) DeleteAcknowledgements(ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+	_, err := r.db.Exec("DELETE FROM acknowledgements WHERE id IN ("+strings.Join(placeholders, ",")+")", args...)
+	return err
+}
+
+// CountAcknowledged counts the acknowledgement links for a template created since the given time
+// for the given recipients that have been confirmed
+func (r *SQLiteRepository) CountAcknowledged(templateKey string, recipientIDs []int64, since time.Time) (int, error) {
+	if len(recipientIDs) == 0 {
+		return 0, nil
+	}
+	placeholders := make([]string, len(recipientIDs))
+	args := []interface{}{templateKey, since}
+	for i, id := range recipientIDs {
+		placeholders[i] = "?"
+		args = append(args, id)
+	}
+	var count int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM acknowledgements WHERE template_key = ? AND created_at >= ? AND acknowledged_at IS NOT NULL AND recipient_id IN ("+
+		strings.Join(placeholders, ",")+")", args...).Scan(&count)
+	return count, err
+}
+
+// ListAcknowledgements retrieves the most recent acknowledgements, newest first
+func (r *SQLiteRepository) ListAcknowledgements(limit int) ([]models.Acknowledgement, error) {
+	rows, err := r.db.Query(`
+		SELECT a.id, a.token, a.recipient_id, COALESCE(rc.name, ''), a.template_key, a.created_at, a.acknowledged_at
+		FROM acknowledgements a LEFT JOIN recipients rc ON rc.id = a.recipient_id
+		ORDER BY a.id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	acks := []models.Acknowledgement{}
+	for rows.Next() {
+		var ack models.Acknowledgement
+		var ackedAt sql.NullTime
+		if err := rows.Scan(&ack.ID, &ack.Token, &ack.RecipientID, &ack.RecipientName, &ack.TemplateKey, &ack.CreatedAt, &ackedAt); err != nil {
+			return nil, err
+		}
+		if ackedAt.Valid {
+			ack.AcknowledgedAt = &ackedAt.Time
+		}
+		acks = append(acks, ack)
+	}
+	return acks, rows.Err()
+}
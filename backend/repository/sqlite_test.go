@@ -273,3 +273,44 @@ func TestProperty12_DataPersistenceRoundTrip(t *testing.T) {
 
 	properties.TestingRun(t)
 }
+
+func TestTemplateDefaultGroups(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	template := &models.MessageTemplate{Key: "backup-failed", TemplateID: "tpl", Name: "备份失败"}
+	if err := repo.CreateTemplate(template); err != nil {
+		t.Fatalf("CreateTemplate error: %v", err)
+	}
+	if template.DefaultGroupIDs == nil {
+		t.Error("DefaultGroupIDs should be an empty slice, not nil")
+	}
+
+	template.DefaultGroupIDs = []int64{3, 5}
+	if err := repo.UpdateTemplate(template); err != nil {
+		t.Fatalf("UpdateTemplate error: %v", err)
+	}
+	got, err := repo.GetTemplateByKey("backup-failed")
+	if err != nil {
+		t.Fatalf("GetTemplateByKey error: %v", err)
+	}
+	if len(got.DefaultGroupIDs) != 2 || got.DefaultGroupIDs[0] != 3 || got.DefaultGroupIDs[1] != 5 {
+		t.Errorf("DefaultGroupIDs = %v, want [3 5]", got.DefaultGroupIDs)
+	}
+
+	if err := repo.UpdateTemplate(&models.MessageTemplate{ID: 999}); err != ErrNotFound {
+		t.Errorf("UpdateTemplate on missing ID: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestCreateTemplateDuplicateKey(t *testing.T) {
+	repo, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := repo.CreateTemplate(&models.MessageTemplate{Key: "order", TemplateID: "tpl_1", Name: "订单"}); err != nil {
+		t.Fatalf("CreateTemplate error: %v", err)
+	}
+	if err := repo.CreateTemplate(&models.MessageTemplate{Key: "order", TemplateID: "tpl_2", Name: "订单"}); err != ErrDuplicateKey {
+		t.Errorf("CreateTemplate with a taken key: err = %v, want ErrDuplicateKey", err)
+	}
+}
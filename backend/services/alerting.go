@@ -0,0 +1,355 @@
+package services
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// GrafanaAlert is the body of Grafana's webhook contact point. Unified alerting (Grafana 8 and
+// later) sends the alerts of a notification group; legacy dashboard alerts send one rule with the
+// series that matched its condition as evalMatches. Title, state and message are set by both.
+type GrafanaAlert struct {
+	Title   string `json:"title"`
+	State   string `json:"state"` // alerting, ok, no_data, paused or pending
+	Message string `json:"message"`
+
+	// Unified alerting
+	Status      string                 `json:"status"` // firing or resolved
+	Alerts      []GrafanaAlertInstance `json:"alerts"`
+	ExternalURL string                 `json:"externalURL"`
+
+	// Legacy alerting
+	RuleName    string             `json:"ruleName"`
+	RuleURL     string             `json:"ruleUrl"`
+	EvalMatches []GrafanaEvalMatch `json:"evalMatches"`
+}
+
+// GrafanaAlertInstance is one alert of a unified alerting notification
+type GrafanaAlertInstance struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	PanelURL     string            `json:"panelURL"`
+	DashboardURL string            `json:"dashboardURL"`
+	GeneratorURL string            `json:"generatorURL"`
+}
+
+// GrafanaEvalMatch is a series that matched a legacy alert's condition; Value is null for series
+// without data
+type GrafanaEvalMatch struct {
+	Metric string            `json:"metric"`
+	Value  *float64          `json:"value"`
+	Tags   map[string]string `json:"tags"`
+}
+
+// grafanaStates names Grafana's alert states and statuses in messages
+var grafanaStates = map[string]string{
+	"alerting": "告警",
+	"firing":   "告警",
+	"ok":       "恢复",
+	"resolved": "恢复",
+	"no_data":  "无数据",
+	"paused":   "已暂停",
+	"pending":  "待定",
+}
+
+// Empty reports whether the body carries no alert at all, e.g. because it isn't from Grafana
+func (g GrafanaAlert) Empty() bool {
+	return g.Title == "" && g.State == "" && g.Status == "" && g.RuleName == "" && len(g.Alerts) == 0
+}
+
+// Parts maps the alert to request parts: title, state, content (the message, or one line per
+// alert when Grafana sent none, followed by the matched values), matches and url, the first panel
+// or rule link
+func (g GrafanaAlert) Parts() map[string]string {
+	state := g.State
+	if state == "" {
+		state = g.Status
+	}
+	if name, ok := grafanaStates[strings.ToLower(state)]; ok {
+		state = name
+	}
+
+	title := strings.TrimSpace(g.Title)
+	if title == "" {
+		title = g.RuleName
+	}
+	if title == "" && len(g.Alerts) > 0 {
+		title = g.Alerts[0].Labels["alertname"]
+	}
+	if title == "" {
+		title = "Grafana 告警"
+	}
+
+	var lines []string
+	if message := strings.TrimSpace(g.Message); message != "" {
+		lines = append(lines, message)
+	} else {
+		for _, a := range g.Alerts {
+			line := "[" + grafanaStates[a.Status] + "] " + a.Labels["alertname"]
+			if summary := a.Annotations["summary"]; summary != "" {
+				line += "：" + summary
+			}
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+	var matches []string
+	for _, m := range g.EvalMatches {
+		value := "无数据"
+		if m.Value != nil {
+			value = strconv.FormatFloat(*m.Value, 'f', -1, 64)
+		}
+		matches = append(matches, m.Metric+" = "+value)
+	}
+	lines = append(lines, matches...)
+
+	link := g.RuleURL
+	if len(g.Alerts) > 0 {
+		a := g.Alerts[0]
+		link = a.PanelURL
+		if link == "" {
+			link = a.DashboardURL
+		}
+		if link == "" {
+			link = a.GeneratorURL
+		}
+	}
+	if link == "" {
+		link = g.ExternalURL
+	}
+
+	return map[string]string{
+		"title":   title,
+		"state":   state,
+		"content": strings.Join(lines, "\n"),
+		"matches": strings.Join(matches, "；"),
+		"url":     httpURL(link),
+	}
+}
+
+// httpURL returns link if it's an absolute http(s) URL a message can open, or ""
+func httpURL(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return link
+}
+
+// SentryAlert is the body of a Sentry issue alert. The WebHooks plugin sends the issue at the top
+// level; internal integrations send an event_alert with the event under data.
+type SentryAlert struct {
+	Project         string   `json:"project"`
+	ProjectName     string   `json:"project_name"`
+	Level           string   `json:"level"`
+	Culprit         string   `json:"culprit"`
+	Message         string   `json:"message"`
+	URL             string   `json:"url"`
+	TriggeringRules []string `json:"triggering_rules"`
+	Event           struct {
+		Title       string `json:"title"`
+		Environment string `json:"environment"`
+	} `json:"event"`
+
+	Data struct {
+		Event struct {
+			Title       string `json:"title"`
+			Level       string `json:"level"`
+			Culprit     string `json:"culprit"`
+			Environment string `json:"environment"`
+			WebURL      string `json:"web_url"`
+		} `json:"event"`
+		TriggeredRule string `json:"triggered_rule"`
+	} `json:"data"`
+}
+
+// sentryLevels names Sentry's event levels in messages
+var sentryLevels = map[string]string{
+	"fatal":   "致命",
+	"error":   "错误",
+	"warning": "警告",
+	"info":    "信息",
+	"debug":   "调试",
+}
+
+// Title returns the issue title, empty when the body isn't a Sentry alert
+func (s SentryAlert) Title() string {
+	for _, title := range []string{s.Data.Event.Title, s.Event.Title, s.Message} {
+		if title = strings.TrimSpace(title); title != "" {
+			return title
+		}
+	}
+	return ""
+}
+
+// Parts maps the alert to request parts: title, project, level, content (where the error
+// happened, the environment and the alert rule) and url, the issue's page
+func (s SentryAlert) Parts() map[string]string {
+	level, culprit, environment, link := s.Level, s.Culprit, s.Event.Environment, s.URL
+	rules := s.TriggeringRules
+	if e := s.Data.Event; e.Title != "" {
+		level, culprit, environment, link = e.Level, e.Culprit, e.Environment, e.WebURL
+		if s.Data.TriggeredRule != "" {
+			rules = []string{s.Data.TriggeredRule}
+		}
+	}
+	if name, ok := sentryLevels[level]; ok {
+		level = name
+	}
+	project := s.ProjectName
+	if project == "" {
+		project = s.Project
+	}
+
+	var lines []string
+	if culprit != "" {
+		lines = append(lines, "位置："+culprit)
+	}
+	if environment != "" {
+		lines = append(lines, "环境："+environment)
+	}
+	if len(rules) > 0 {
+		lines = append(lines, "告警规则："+strings.Join(rules, "、"))
+	}
+	return map[string]string{
+		"title":   s.Title(),
+		"project": project,
+		"level":   level,
+		"content": strings.Join(lines, "\n"),
+		"url":     httpURL(link),
+	}
+}
+
+// ZabbixEvent is the body ZabbixScript posts: the media type's
+// parameters, named as in ZabbixParameters
+type ZabbixEvent struct {
+	EventID       string `json:"event_id"`
+	EventValue    string `json:"event_value"`         // 1 for a problem, 0 when it's resolved
+	UpdateStatus  string `json:"event_update_status"` // 1 when the problem was acknowledged or commented on
+	EventName     string `json:"event_name"`
+	Severity      string `json:"severity"`
+	Host          string `json:"host"`
+	EventTime     string `json:"event_time"`
+	RecoveryTime  string `json:"recovery_time"`
+	Duration      string `json:"duration"`
+	UpdateUser    string `json:"update_user"`
+	UpdateAction  string `json:"update_action"`
+	UpdateMessage string `json:"update_message"`
+	Message       string `json:"message"`
+}
+
+// ZabbixParameter is a parameter of a Zabbix webhook media type
+type ZabbixParameter struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// ZabbixParameters maps the fields of ZabbixEvent to Zabbix macros. The URL parameter, the
+// endpoint to post to, comes first.
+var ZabbixParameters = []ZabbixParameter{
+	{Name: "URL", Value: ""},
+	{Name: "event_id", Value: "{EVENT.ID}"},
+	{Name: "event_value", Value: "{EVENT.VALUE}"},
+	{Name: "event_update_status", Value: "{EVENT.UPDATE.STATUS}"},
+	{Name: "event_name", Value: "{EVENT.NAME}"},
+	{Name: "severity", Value: "{EVENT.SEVERITY}"},
+	{Name: "host", Value: "{HOST.NAME}"},
+	{Name: "event_time", Value: "{EVENT.DATE} {EVENT.TIME}"},
+	{Name: "recovery_time", Value: "{EVENT.RECOVERY.DATE} {EVENT.RECOVERY.TIME}"},
+	{Name: "duration", Value: "{EVENT.DURATION}"},
+	{Name: "update_user", Value: "{USER.FULLNAME}"},
+	{Name: "update_action", Value: "{EVENT.UPDATE.ACTION}"},
+	{Name: "update_message", Value: "{EVENT.UPDATE.MESSAGE}"},
+	{Name: "message", Value: "{ALERT.MESSAGE}"},
+}
+
+// ZabbixScript is the script of the webhook media type, posting the parameters other than URL as
+// JSON to URL
+const ZabbixScript = `var params = JSON.parse(value);
+var url = params.URL;
+delete params.URL;
+
+var req = new HttpRequest();
+req.addHeader('Content-Type: application/json');
+var resp = req.post(url, JSON.stringify(params));
+if (req.getStatus() != 200) {
+    throw 'Response code ' + req.getStatus() + ': ' + resp;
+}
+return 'OK';`
+
+// zabbixSeverities names Zabbix's severities, by name and number, as the Chinese UI does
+var zabbixSeverities = map[string]string{
+	"Not classified": "未分类", "0": "未分类",
+	"Information": "信息", "1": "信息",
+	"Warning": "警告", "2": "警告",
+	"Average": "一般严重", "3": "一般严重",
+	"High": "严重", "4": "严重",
+	"Disaster": "灾难", "5": "灾难",
+}
+
+// zabbixMacro matches a macro Zabbix left unexpanded, like the recovery time of a problem that
+// isn't resolved yet
+var zabbixMacro = regexp.MustCompile(`\{[A-Z][A-Z0-9_.]*\}`)
+
+// zabbixValue returns a parameter without the macros Zabbix left unexpanded
+func zabbixValue(v string) string {
+	return strings.TrimSpace(zabbixMacro.ReplaceAllString(v, ""))
+}
+
+// Name returns the problem name, empty when the body isn't from the Zabbix media type
+func (z ZabbixEvent) Name() string {
+	return zabbixValue(z.EventName)
+}
+
+// Parts maps the event to request parts: title, name, status (问题, 已恢复 or 更新), severity, host,
+// time, event_id and content. Resolved problems are titled 已恢复 rather than with their severity.
+func (z ZabbixEvent) Parts() map[string]string {
+	severity := zabbixValue(z.Severity)
+	if name, ok := zabbixSeverities[severity]; ok {
+		severity = name
+	}
+	host := zabbixValue(z.Host)
+	status, tag, at := "问题", severity, zabbixValue(z.EventTime)
+
+	lines := []string{"主机：" + host}
+	switch {
+	case zabbixValue(z.UpdateStatus) == "1":
+		status, tag = "更新", "更新"
+		update := strings.TrimSpace(zabbixValue(z.UpdateUser) + " " + zabbixValue(z.UpdateAction))
+		if text := zabbixValue(z.UpdateMessage); text != "" {
+			update += "：" + text
+		}
+		lines = append(lines, update)
+	case zabbixValue(z.EventValue) == "0":
+		status, tag = "已恢复", "已恢复"
+		if recovered := zabbixValue(z.RecoveryTime); recovered != "" {
+			at = recovered
+		}
+		lines = append(lines, "恢复时间："+at)
+		if duration := zabbixValue(z.Duration); duration != "" {
+			lines = append(lines, "持续时间："+duration)
+		}
+	default:
+		lines = append(lines, "发生时间："+at)
+	}
+	if message := zabbixValue(z.Message); message != "" {
+		lines = append(lines, message)
+	}
+
+	title := z.Name()
+	if tag != "" {
+		title = "[" + tag + "] " + title
+	}
+	return map[string]string{
+		"title":    title,
+		"name":     z.Name(),
+		"status":   status,
+		"severity": severity,
+		"host":     host,
+		"time":     at,
+		"event_id": zabbixValue(z.EventID),
+		"content":  strings.Join(lines, "\n"),
+	}
+}
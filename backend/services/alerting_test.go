@@ -0,0 +1,120 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestGrafanaAlertParts(t *testing.T) {
+	// Unified alerting without a custom message
+	var unified GrafanaAlert
+	json.Unmarshal([]byte(`{
+		"status": "firing", "title": "[FIRING:1] HighCPU", "message": "",
+		"externalURL": "https://grafana.example.com/",
+		"alerts": [{
+			"status": "firing",
+			"labels": {"alertname": "HighCPU", "instance": "web-1"},
+			"annotations": {"summary": "CPU above 90%"},
+			"panelURL": "https://grafana.example.com/d/abc?viewPanel=2"
+		}]
+	}`), &unified)
+	parts := unified.Parts()
+	if parts["title"] != "[FIRING:1] HighCPU" || parts["state"] != "告警" || parts["content"] != "[告警] HighCPU：CPU above 90%" {
+		t.Errorf("unexpected unified parts: %v", parts)
+	}
+	if parts["url"] != "https://grafana.example.com/d/abc?viewPanel=2" {
+		t.Errorf("expected the panel URL, got %q", parts["url"])
+	}
+
+	// Legacy alerting with matched series
+	var legacy GrafanaAlert
+	json.Unmarshal([]byte(`{
+		"ruleName": "Disk usage", "state": "ok", "message": "Disk back to normal",
+		"ruleUrl": "/d/xyz", "evalMatches": [{"metric": "sda", "value": 71.5}, {"metric": "sdb", "value": null}]
+	}`), &legacy)
+	parts = legacy.Parts()
+	if parts["title"] != "Disk usage" || parts["state"] != "恢复" {
+		t.Errorf("unexpected legacy parts: %v", parts)
+	}
+	if parts["content"] != "Disk back to normal\nsda = 71.5\nsdb = 无数据" || parts["matches"] != "sda = 71.5；sdb = 无数据" {
+		t.Errorf("unexpected legacy content: %q / %q", parts["content"], parts["matches"])
+	}
+	if parts["url"] != "" {
+		t.Errorf("relative rule URL should not become the message link, got %q", parts["url"])
+	}
+
+	if !(GrafanaAlert{}).Empty() || legacy.Empty() {
+		t.Error("Empty should only hold for bodies without any alert")
+	}
+}
+
+func TestSentryAlertParts(t *testing.T) {
+	// WebHooks plugin
+	var plugin SentryAlert
+	json.Unmarshal([]byte(`{
+		"project": "api", "project_name": "API", "level": "error", "culprit": "app.views in login",
+		"message": "", "url": "https://sentry.io/organizations/acme/issues/1/?referrer=webhooks_plugin",
+		"triggering_rules": ["Error spike"], "event": {"title": "ZeroDivisionError: division by zero", "environment": "production"}
+	}`), &plugin)
+	parts := plugin.Parts()
+	if parts["title"] != "ZeroDivisionError: division by zero" || parts["project"] != "API" || parts["level"] != "错误" {
+		t.Errorf("unexpected plugin parts: %v", parts)
+	}
+	if parts["content"] != "位置：app.views in login\n环境：production\n告警规则：Error spike" || parts["url"] == "" {
+		t.Errorf("unexpected plugin content: %v", parts)
+	}
+
+	// Internal integration event_alert
+	var integration SentryAlert
+	json.Unmarshal([]byte(`{
+		"action": "triggered",
+		"data": {"event": {"title": "TimeoutError", "level": "fatal", "web_url": "https://sentry.io/organizations/acme/issues/2/events/abc/"}, "triggered_rule": "Any new issue"}
+	}`), &integration)
+	parts = integration.Parts()
+	if parts["title"] != "TimeoutError" || parts["level"] != "致命" || parts["content"] != "告警规则：Any new issue" {
+		t.Errorf("unexpected integration parts: %v", parts)
+	}
+	if parts["url"] != "https://sentry.io/organizations/acme/issues/2/events/abc/" {
+		t.Errorf("unexpected integration link: %q", parts["url"])
+	}
+
+	if (SentryAlert{}).Title() != "" {
+		t.Error("empty body should have no title")
+	}
+}
+
+func TestZabbixEventParts(t *testing.T) {
+	// A problem: the recovery macros aren't expanded yet
+	problem := ZabbixEvent{
+		EventID: "1042", EventValue: "1", UpdateStatus: "0", EventName: "High CPU on web-1", Severity: "High",
+		Host: "web-1", EventTime: "2024.05.01 10:00:00", RecoveryTime: "{EVENT.RECOVERY.DATE} {EVENT.RECOVERY.TIME}",
+		UpdateMessage: "{EVENT.UPDATE.MESSAGE}",
+	}
+	parts := problem.Parts()
+	if parts["title"] != "[严重] High CPU on web-1" || parts["status"] != "问题" || parts["severity"] != "严重" {
+		t.Errorf("unexpected problem parts: %v", parts)
+	}
+	if parts["content"] != "主机：web-1\n发生时间：2024.05.01 10:00:00" || parts["event_id"] != "1042" {
+		t.Errorf("unexpected problem content: %q", parts["content"])
+	}
+
+	resolved := problem
+	resolved.EventValue, resolved.RecoveryTime, resolved.Duration = "0", "2024.05.01 10:12:00", "12m"
+	parts = resolved.Parts()
+	if parts["title"] != "[已恢复] High CPU on web-1" || parts["status"] != "已恢复" || parts["time"] != "2024.05.01 10:12:00" {
+		t.Errorf("unexpected resolved parts: %v", parts)
+	}
+	if parts["content"] != "主机：web-1\n恢复时间：2024.05.01 10:12:00\n持续时间：12m" {
+		t.Errorf("unexpected resolved content: %q", parts["content"])
+	}
+
+	update := problem
+	update.UpdateStatus, update.UpdateUser, update.UpdateAction, update.UpdateMessage = "1", "Ops", "acknowledged", "looking"
+	if parts := update.Parts(); parts["status"] != "更新" || parts["content"] != "主机：web-1\nOps acknowledged：looking" {
+		t.Errorf("unexpected update parts: %v", parts)
+	}
+
+	if (ZabbixEvent{EventName: "{EVENT.NAME}"}).Name() != "" {
+		t.Error("an unexpanded event name should count as missing")
+	}
+}
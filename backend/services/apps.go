@@ -0,0 +1,74 @@
+package services
+
+import (
+	"errors"
+	"sync"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+// ErrAppNotFound is returned when a send selects an official account that isn't configured
+var ErrAppNotFound = errors.New("wechat app not found")
+
+// AppRegistry keeps a WeChat service, each with its own token manager and throttle state, for every
+// configured official account. App 0 is the default account configured through /api/config/wechat.
+type AppRegistry struct {
+	mu       sync.RWMutex
+	fallback *WeChatService
+	apps     map[int64]*WeChatService
+}
+
+// NewAppRegistry creates a registry around the default account's service
+func NewAppRegistry(fallback *WeChatService) *AppRegistry {
+	return &AppRegistry{fallback: fallback, apps: map[int64]*WeChatService{}}
+}
+
+// Load registers every official account stored in the database
+func (r *AppRegistry) Load(repo *repository.SQLiteRepository) error {
+	apps, err := repo.GetWeChatApps()
+	if err != nil {
+		return err
+	}
+	for i := range apps {
+		r.Put(&apps[i])
+	}
+	return nil
+}
+
+// Put registers an official account, replacing the service of an account with the same ID.
+// Tokens are fetched the same way as for the default account, i.e. through the same stable token
+// or token provider settings, and a sandbox deployment treats every account as a test account.
+func (r *AppRegistry) Put(app *models.WeChatApp) {
+	svc := &WeChatService{
+		tokenManager: r.fallback.tokenManager.clone(app.AppID, app.AppSecret),
+		templateID:   app.TemplateID,
+		httpClient:   r.fallback.httpClient,
+		concurrency:  r.fallback.concurrency,
+		sandbox:      r.fallback.sandbox,
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.apps[app.ID] = svc
+}
+
+// Remove unregisters an official account
+func (r *AppRegistry) Remove(id int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.apps, id)
+}
+
+// Service returns the WeChat service of an official account; 0 selects the default account
+func (r *AppRegistry) Service(id int64) (*WeChatService, error) {
+	if id == 0 {
+		return r.fallback, nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	svc, ok := r.apps[id]
+	if !ok {
+		return nil, ErrAppNotFound
+	}
+	return svc, nil
+}
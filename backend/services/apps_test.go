@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+func TestSendThroughApp(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	var posted []string
+	reply := `{"errcode":0,"errmsg":"ok","msgid":1}`
+	client := &MockHTTPClient{
+		GetFunc: func(url string) (*http.Response, error) {
+			token := "token_other"
+			if strings.Contains(url, "appid=wx_default") {
+				token = "token_default"
+			}
+			return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"access_token":"` + token + `","expires_in":7200}`))}, nil
+		},
+		PostFunc: func(url, contentType string, body io.Reader) (*http.Response, error) {
+			posted = append(posted, url)
+			return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(reply))}, nil
+		},
+	}
+	wechatSvc := NewWeChatServiceWithClient(NewTokenManagerWithClient("wx_default", "secret", client), "", client)
+	apps := NewAppRegistry(wechatSvc)
+	app := &models.WeChatApp{Name: "服务号", AppID: "wx_other", AppSecret: "other"}
+	repo.CreateWeChatApp(app)
+	if err := apps.Load(repo); err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	sender := NewSender(repo, wechatSvc, "")
+	sender.SetApps(apps)
+
+	template := &models.MessageTemplate{Key: "order", TemplateID: "tpl", Name: "订单", AppID: app.ID}
+	repo.CreateTemplate(template)
+	recipients := []models.Recipient{{ID: 1, OpenID: "o1", AppID: app.ID}}
+
+	if _, err := sender.Send(context.Background(), template, recipients, nil, SendOptions{}); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	if _, err := sender.Send(context.Background(), template, recipients, nil, SendOptions{AppID: -1}); err != ErrAppNotFound {
+		t.Errorf("unknown app error = %v, want ErrAppNotFound", err)
+	}
+	reply = `{"errcode":-1,"errmsg":"system error"}`
+	if _, err := sender.Send(context.Background(), &models.MessageTemplate{Key: "order", TemplateID: "tpl"}, recipients, nil, SendOptions{AppID: app.ID}); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	if len(posted) != 2 || !strings.Contains(posted[0], "token_other") || !strings.Contains(posted[1], "token_other") {
+		t.Fatalf("sends should use the app's token: %v", posted)
+	}
+
+	// The retry goes out through the same account
+	due, _ := repo.GetDueSendRetries(time.Now().Add(time.Hour), 10)
+	if len(due) != 1 || due[0].AppID != app.ID {
+		t.Fatalf("retry should remember the app: %+v", due)
+	}
+	queue := NewRetryQueue(repo, wechatSvc)
+	queue.SetApps(apps)
+	reply = `{"errcode":0,"errmsg":"ok","msgid":2}`
+	if err := queue.Attempt(&due[0], time.Now()); err != nil {
+		t.Fatalf("Attempt error: %v", err)
+	}
+	if len(posted) != 3 || !strings.Contains(posted[2], "token_other") {
+		t.Errorf("retry should use the app's token: %v", posted)
+	}
+}
+
+func TestSendRoutesByRecipientApp(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	posted := map[string]string{}
+	client := &MockHTTPClient{
+		GetFunc: func(url string) (*http.Response, error) {
+			return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"access_token":"token_other","expires_in":7200}`))}, nil
+		},
+		PostFunc: func(url, contentType string, body io.Reader) (*http.Response, error) {
+			data, _ := io.ReadAll(body)
+			var msg models.WeChatTemplateMessage
+			json.Unmarshal(data, &msg)
+			posted[msg.ToUser] = url[strings.Index(url, "access_token="):] + " " + msg.TemplateID
+			return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"errcode":0,"errmsg":"ok","msgid":1}`))}, nil
+		},
+	}
+	tokens := NewTokenManagerWithClient("wx_default", "secret", client)
+	tokens.SetToken("token_default", time.Hour)
+	wechatSvc := NewWeChatServiceWithClient(tokens, "tpl_default", client)
+	apps := NewAppRegistry(wechatSvc)
+	withTemplate := &models.WeChatApp{ID: 1, AppID: "wx_other", TemplateID: "tpl_other_default"}
+	withoutTemplate := &models.WeChatApp{ID: 2, AppID: "wx_third"}
+	apps.Put(withTemplate)
+	apps.Put(withoutTemplate)
+	sender := NewSender(repo, wechatSvc, "")
+	sender.SetApps(apps)
+
+	template := &models.MessageTemplate{Key: "order", TemplateID: "tpl_order"}
+	recipients := []models.Recipient{
+		{ID: 1, OpenID: "o_default"},
+		{ID: 2, OpenID: "o_other", AppID: 1},
+		{ID: 3, OpenID: "o_third", AppID: 2},
+	}
+	response, err := sender.Send(context.Background(), template, recipients, nil, SendOptions{})
+	if err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	if len(posted) != 1 || posted["o_default"] != "access_token=token_default tpl_order" {
+		t.Errorf("only the send's own account should get the template message: %v", posted)
+	}
+	if response.TotalSent != 1 || response.TotalFailed != 2 || response.Results[1].RecipientID != 2 || response.Results[2].RecipientID != 3 ||
+		!strings.Contains(response.Results[1].Error, ErrTemplateOtherAccount.Error()) || !strings.Contains(response.Results[2].Error, ErrTemplateOtherAccount.Error()) {
+		t.Errorf("recipients of other accounts should fail, in order: %+v", response)
+	}
+
+	// Text messages need no template, so they reach every account
+	posted = map[string]string{}
+	response, err = sender.Send(context.Background(), template, recipients, map[string]string{"first": "hi"}, SendOptions{Mode: models.SendModeText})
+	if err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	if response.TotalSent != 3 || posted["o_other"] != "access_token=token_other " {
+		t.Errorf("text messages should go through each recipient's account: %+v, %v", response, posted)
+	}
+}
+
+func TestAppRegistryKeepsSandbox(t *testing.T) {
+	wechatSvc := NewWeChatService(NewTokenManager("wx_default", "secret"), "")
+	wechatSvc.SetSandbox(true)
+	apps := NewAppRegistry(wechatSvc)
+	apps.Put(&models.WeChatApp{ID: 3, AppID: "wx_other", AppSecret: "other"})
+
+	if svc, err := apps.Service(3); err != nil || !svc.Sandbox() {
+		t.Errorf("accounts added to a sandbox deployment should be test accounts: %v", err)
+	}
+}
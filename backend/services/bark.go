@@ -0,0 +1,69 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"wechat-notification/models"
+)
+
+// BarkDefaultServer is the public Bark server used when a channel doesn't name a self-hosted one
+const BarkDefaultServer = "https://api.day.app"
+
+// BarkNotifier pushes messages to an iOS device through the Bark app
+type BarkNotifier struct {
+	ServerURL string
+	DeviceKey string
+	client    *http.Client
+}
+
+// Notify posts to the server's /push endpoint; Bark reports failures in the JSON code
+func (b *BarkNotifier) Notify(n Notification) error {
+	level, sound := barkLevel(n.Priority)
+	payload := map[string]interface{}{
+		"device_key": b.DeviceKey,
+		"title":      n.Title,
+		"body":       n.Content,
+		"level":      level,
+	}
+	if sound != "" {
+		payload["sound"] = sound
+	}
+	if n.URL != "" {
+		payload["url"] = n.URL
+	}
+
+	resp, err := postJSON(b.client, b.ServerURL+"/push", payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&result); err != nil {
+		return fmt.Errorf("bark returned HTTP %d", resp.StatusCode)
+	}
+	if result.Code != http.StatusOK {
+		return fmt.Errorf("bark error %d: %s", result.Code, result.Message)
+	}
+	return nil
+}
+
+// barkLevel maps a send priority onto Bark's iOS interruption level and sound.
+// Time-sensitive alerts break through Focus modes; critical alerts also play through silent mode.
+func barkLevel(priority string) (level, sound string) {
+	switch priority {
+	case models.PriorityLow:
+		return "passive", ""
+	case models.PriorityHigh:
+		return "timeSensitive", ""
+	case models.PriorityUrgent:
+		return "critical", "alarm"
+	}
+	return "active", ""
+}
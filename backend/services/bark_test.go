@@ -0,0 +1,52 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"wechat-notification/models"
+)
+
+func TestBarkNotifier(t *testing.T) {
+	var path string
+	var payload map[string]interface{}
+	code := 200
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, payload = r.URL.Path, nil
+		json.NewDecoder(r.Body).Decode(&payload)
+		json.NewEncoder(w).Encode(map[string]interface{}{"code": code, "message": "failed to get device token"})
+	}))
+	defer server.Close()
+
+	ch := models.Channel{Type: models.ChannelBark, ServerURL: server.URL + "/", Token: "devicekey"}
+	if err := ValidateChannel(&ch); err != nil {
+		t.Fatalf("ValidateChannel error: %v", err)
+	}
+	notifier, _ := NewChannelNotifier(ch, server.Client())
+	if err := notifier.Notify(Notification{Title: "磁盘告警", Content: "nas 95%", URL: "https://example.com/d", Priority: models.PriorityUrgent}); err != nil {
+		t.Fatalf("Notify error: %v", err)
+	}
+	if path != "/push" || payload["device_key"] != "devicekey" || payload["body"] != "nas 95%" || payload["url"] != "https://example.com/d" {
+		t.Errorf("unexpected request: %s %v", path, payload)
+	}
+	if payload["level"] != "critical" || payload["sound"] != "alarm" {
+		t.Errorf("urgent should be a critical alert with a sound: %v", payload)
+	}
+
+	notifier.Notify(Notification{Title: "x"})
+	if payload["level"] != "active" || payload["sound"] != nil {
+		t.Errorf("normal priority should be an active alert: %v", payload)
+	}
+
+	code = 400
+	if err := notifier.Notify(Notification{Title: "x"}); err == nil {
+		t.Error("expected error for non-200 code")
+	}
+
+	defaulted := models.Channel{Type: models.ChannelBark, Token: "k"}
+	if err := ValidateChannel(&defaulted); err != nil || defaulted.ServerURL != BarkDefaultServer {
+		t.Errorf("server should default to %s: %+v, %v", BarkDefaultServer, defaulted, err)
+	}
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"wechat-notification/logger"
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+const (
+	// WeChatQRCodeCreateURL is the URL to create a parameterized QR code (带参数二维码)
+	WeChatQRCodeCreateURL = "https://api.weixin.qq.com/cgi-bin/qrcode/create"
+	// WeChatQRCodeShowURL is the URL of the QR code image for a ticket
+	WeChatQRCodeShowURL = "https://mp.weixin.qq.com/cgi-bin/showqrcode"
+	// QRBindingScenePrefix starts the scene value of binding QR codes, telling them apart from
+	// codes made elsewhere for the same account
+	QRBindingScenePrefix = "bind_"
+	// DefaultQRBindingExpiry is how long a binding QR code stays valid by default
+	DefaultQRBindingExpiry = 7 * 24 * time.Hour
+	// MaxQRBindingExpiry is the longest WeChat keeps a temporary QR code valid
+	MaxQRBindingExpiry = 30 * 24 * time.Hour
+)
+
+// ErrQRBindingTaken is returned when a QR code binds to a recipient but the scanning user's OpenID
+// already belongs to another recipient
+var ErrQRBindingTaken = errors.New("openid already belongs to another recipient")
+
+// QRCode is a temporary parameterized QR code created by WeChat
+type QRCode struct {
+	Ticket        string `json:"ticket"`
+	ExpireSeconds int    `json:"expire_seconds"`
+	URL           string `json:"url"`
+}
+
+// CreateQRCode creates a temporary QR code carrying scene. Scanning it sends a SCAN event (or a
+// subscribe event for new followers) with the scene as EventKey to the callback URL.
+func (s *WeChatService) CreateQRCode(scene string, expire time.Duration) (*QRCode, error) {
+	token, err := s.tokenManager.GetAccessToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"expire_seconds": int(expire / time.Second),
+		"action_name":    "QR_STR_SCENE",
+		"action_info":    map[string]interface{}{"scene": map[string]string{"scene_str": scene}},
+	})
+	resp, err := s.httpClient.Post(fmt.Sprintf("%s?access_token=%s", WeChatQRCodeCreateURL, token), "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create QR code: %w", withoutURL(err))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	var result struct {
+		models.WeChatAPIResponse
+		QRCode
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if result.ErrCode != 0 {
+		return nil, &APIError{ErrCode: result.ErrCode, ErrMsg: result.ErrMsg}
+	}
+	return &result.QRCode, nil
+}
+
+// GenerateQRBinding creates the QR code of a binding on the binding's official account, filling in
+// its scene, image URL and expiry. The binding still has to be stored.
+func GenerateQRBinding(apps *AppRegistry, binding *models.QRBinding, expiry time.Duration, now time.Time) error {
+	wechatSvc, err := apps.Service(binding.AppID)
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	binding.Scene = QRBindingScenePrefix + hex.EncodeToString(b)
+
+	code, err := wechatSvc.CreateQRCode(binding.Scene, expiry)
+	if err != nil {
+		return err
+	}
+	binding.ImageURL = WeChatQRCodeShowURL + "?ticket=" + url.QueryEscape(code.Ticket)
+	binding.ExpiresAt = now.Add(expiry)
+	if code.ExpireSeconds > 0 {
+		binding.ExpiresAt = now.Add(time.Duration(code.ExpireSeconds) * time.Second)
+	}
+	return nil
+}
+
+// bindingScene returns the binding scene an event carries, or "" when it isn't from a binding QR
+// code. New followers scanning a code send a subscribe event with the scene prefixed by "qrscene_".
+func bindingScene(event *models.WeChatEvent) string {
+	scene := strings.TrimPrefix(event.EventKey, "qrscene_")
+	if !strings.HasPrefix(scene, QRBindingScenePrefix) {
+		return ""
+	}
+	return scene
+}
+
+// bind binds the user who scanned a binding QR code, returning the confirmation to reply with.
+// Unknown, expired and already used codes are ignored.
+func (cb *Callbacks) bind(appID int64, openID, scene string, now time.Time) (string, error) {
+	binding, err := cb.repo.GetQRBindingByScene(scene)
+	if errors.Is(err, repository.ErrNotFound) {
+		logger.Warnf("callback: %s scanned unknown binding code %s", openID, scene)
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if binding.AppID != appID || now.After(binding.ExpiresAt) {
+		logger.Warnf("callback: %s scanned expired binding code %d", openID, binding.ID)
+		return "", nil
+	}
+	claimed, err := cb.repo.ClaimQRBinding(binding.ID, openID, now)
+	if err != nil || !claimed {
+		if err == nil {
+			logger.Warnf("callback: %s scanned binding code %d, which was already used", openID, binding.ID)
+		}
+		return "", err
+	}
+
+	var recipient *models.Recipient
+	if binding.RecipientID != 0 {
+		recipient, err = cb.repo.GetByID(binding.RecipientID)
+		if err != nil {
+			return "", err
+		}
+		recipient.OpenID = openID
+		recipient.AppID = appID
+		if err := cb.repo.Update(recipient); err != nil {
+			if errors.Is(err, repository.ErrDuplicateOpenID) {
+				return "", fmt.Errorf("binding code %d: %w", binding.ID, ErrQRBindingTaken)
+			}
+			return "", err
+		}
+	} else {
+		recipient, err = cb.register(appID, openID, binding.Name)
+		if err != nil {
+			return "", err
+		}
+	}
+	if err := cb.repo.AddToGroups(recipient.ID, binding.GroupIDs); err != nil {
+		return "", err
+	}
+	logger.Infof("callback: bound %s to %s with binding code %d", openID, recipient.Name, binding.ID)
+	return fmt.Sprintf("绑定成功，你已登记为「%s」，之后的通知会发到这里。", recipient.Name), nil
+}
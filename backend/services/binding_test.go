@@ -0,0 +1,138 @@
+package services
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+func TestQRBinding(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	var scene string
+	client := &MockHTTPClient{GetFunc: func(u string) (*http.Response, error) {
+		body := `{"subscribe":1,"openid":"x","nickname":"小张","headimgurl":"http://thirdwx.qlogo.cn/a.png","language":"zh_CN"}`
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}, PostFunc: func(u, contentType string, b io.Reader) (*http.Response, error) {
+		if !strings.HasPrefix(u, WeChatQRCodeCreateURL+"?") {
+			t.Errorf("unexpected request to %s", u)
+		}
+		var req struct {
+			ExpireSeconds int    `json:"expire_seconds"`
+			ActionName    string `json:"action_name"`
+			ActionInfo    struct {
+				Scene struct {
+					SceneStr string `json:"scene_str"`
+				} `json:"scene"`
+			} `json:"action_info"`
+		}
+		json.NewDecoder(b).Decode(&req)
+		if req.ActionName != "QR_STR_SCENE" || req.ExpireSeconds != 3600 {
+			t.Errorf("unexpected QR code request: %+v", req)
+		}
+		scene = req.ActionInfo.Scene.SceneStr
+		body := `{"ticket":"gQH47joAAAAA==","expire_seconds":3600,"url":"http://weixin.qq.com/q/kZgfwMTm72"}`
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}}
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("token", time.Hour)
+	apps := NewAppRegistry(NewWeChatServiceWithClient(tokens, "tpl", client))
+	callbacks := NewCallbacks(repo, apps)
+
+	group := &models.Group{Name: "新同事"}
+	if err := repo.CreateGroup(group); err != nil {
+		t.Fatalf("CreateGroup error: %v", err)
+	}
+	now := time.Now()
+	binding := &models.QRBinding{Name: "张三", GroupIDs: []int64{group.ID}}
+	if err := GenerateQRBinding(apps, binding, time.Hour, now); err != nil {
+		t.Fatalf("GenerateQRBinding error: %v", err)
+	}
+	if binding.Scene != scene || !strings.HasPrefix(scene, QRBindingScenePrefix) {
+		t.Errorf("scene %q, sent %q", binding.Scene, scene)
+	}
+	if binding.ImageURL != WeChatQRCodeShowURL+"?ticket=gQH47joAAAAA%3D%3D" || !binding.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("unexpected binding: %+v", binding)
+	}
+	if err := repo.CreateQRBinding(binding); err != nil {
+		t.Fatalf("CreateQRBinding error: %v", err)
+	}
+
+	// A new follower scanning the code subscribes with the scene prefixed by qrscene_. Binding
+	// works without auto-registration.
+	event := &models.WeChatEvent{MsgType: "event", Event: models.WeChatEventSubscribe, FromUserName: "o-new-1", EventKey: "qrscene_" + scene}
+	reply, err := callbacks.Handle(0, event)
+	if err != nil {
+		t.Fatalf("Handle error: %v", err)
+	}
+	if !strings.Contains(reply, "张三") {
+		t.Errorf("expected a confirmation naming the recipient, got %q", reply)
+	}
+	r, err := repo.GetByOpenID("o-new-1")
+	if err != nil {
+		t.Fatalf("recipient not registered: %v", err)
+	}
+	if r.Name != "张三" || r.Nickname != "小张" {
+		t.Errorf("expected the binding's name and the WeChat nickname, got %q / %q", r.Name, r.Nickname)
+	}
+	if g, _ := repo.GetGroupByID(group.ID); len(g.RecipientIDs) != 1 || g.RecipientIDs[0] != r.ID {
+		t.Errorf("recipient not added to the group: %v", g.RecipientIDs)
+	}
+	stored, _ := repo.GetQRBindingByScene(scene)
+	if stored.BoundAt == nil || stored.BoundOpenID != "o-new-1" {
+		t.Errorf("binding not claimed: %+v", stored)
+	}
+
+	// Each code binds once
+	event = &models.WeChatEvent{MsgType: "event", Event: models.WeChatEventScan, FromUserName: "o-new-2", EventKey: scene}
+	if reply, err := callbacks.Handle(0, event); err != nil || reply != "" {
+		t.Fatalf("Handle = %q, %v", reply, err)
+	}
+	if _, err := repo.GetByOpenID("o-new-2"); err != repository.ErrNotFound {
+		t.Errorf("used code bound another user: %v", err)
+	}
+
+	// A code for an existing recipient sets their OpenID
+	existing := &models.Recipient{Name: "李四", OpenID: "placeholder"}
+	if err := repo.Create(existing); err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+	if err := repo.CreateQRBinding(&models.QRBinding{Scene: QRBindingScenePrefix + "li", RecipientID: existing.ID, ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("CreateQRBinding error: %v", err)
+	}
+	event.EventKey = QRBindingScenePrefix + "li"
+	if _, err := callbacks.Handle(0, event); err != nil {
+		t.Fatalf("Handle error: %v", err)
+	}
+	if r, _ := repo.GetByID(existing.ID); r.OpenID != "o-new-2" {
+		t.Errorf("expected OpenID o-new-2, got %q", r.OpenID)
+	}
+
+	// Expired codes are ignored
+	repo.CreateQRBinding(&models.QRBinding{Scene: QRBindingScenePrefix + "old", ExpiresAt: now.Add(-time.Minute)})
+	event = &models.WeChatEvent{MsgType: "event", Event: models.WeChatEventScan, FromUserName: "o-new-3", EventKey: QRBindingScenePrefix + "old"}
+	callbacks.Handle(0, event)
+	if _, err := repo.GetByOpenID("o-new-3"); err != repository.ErrNotFound {
+		t.Errorf("expired code bound a user: %v", err)
+	}
+}
+
+func TestCreateQRCodeHidesToken(t *testing.T) {
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("tok_s3cret", time.Hour)
+	_, err := NewWeChatServiceWithClient(tokens, "tpl", failingClient()).CreateQRCode("scene", time.Hour)
+	if err == nil || strings.Contains(err.Error(), "tok_s3cret") {
+		t.Errorf("CreateQRCode error = %v, want one without the access token", err)
+	}
+}
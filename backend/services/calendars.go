@@ -0,0 +1,294 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"wechat-notification/logger"
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+const (
+	// CalendarCheckTick is how often the scheduler looks for reminders that are due
+	CalendarCheckTick = time.Minute
+	// CalendarRefreshInterval is how often linked calendars are downloaded again
+	CalendarRefreshInterval = 15 * time.Minute
+)
+
+// ErrInvalidICS is returned when a document is not an iCalendar file
+var ErrInvalidICS = errors.New("not an iCalendar document")
+
+// CalendarEvent is a single VEVENT from an ICS document.
+// Recurring events are not expanded; only the first occurrence is used.
+type CalendarEvent struct {
+	UID         string
+	Summary     string
+	Location    string
+	Description string
+	Start       time.Time
+	AllDay      bool
+}
+
+// ParseICS parses the VEVENTs of an iCalendar document
+func ParseICS(data string) ([]CalendarEvent, error) {
+	// Unfold continuation lines (RFC 5545 3.1)
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\n ", "")
+	data = strings.ReplaceAll(data, "\n\t", "")
+	if !strings.Contains(data, "BEGIN:VCALENDAR") {
+		return nil, ErrInvalidICS
+	}
+
+	var events []CalendarEvent
+	var current *CalendarEvent
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch line {
+		case "BEGIN:VEVENT":
+			current = &CalendarEvent{}
+			continue
+		case "END:VEVENT":
+			if current != nil && !current.Start.IsZero() {
+				if current.UID == "" {
+					current.UID = current.Summary
+				}
+				events = append(events, *current)
+			}
+			current = nil
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			continue
+		}
+		nameParams := strings.Split(line[:colon], ";")
+		value := line[colon+1:]
+		params := make(map[string]string, len(nameParams)-1)
+		for _, p := range nameParams[1:] {
+			if k, v, ok := strings.Cut(p, "="); ok {
+				params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+			}
+		}
+
+		switch strings.ToUpper(nameParams[0]) {
+		case "UID":
+			current.UID = value
+		case "SUMMARY":
+			current.Summary = unescapeICS(value)
+		case "LOCATION":
+			current.Location = unescapeICS(value)
+		case "DESCRIPTION":
+			current.Description = unescapeICS(value)
+		case "DTSTART":
+			start, allDay, err := parseICSTime(value, params)
+			if err == nil {
+				current.Start, current.AllDay = start, allDay
+			}
+		}
+	}
+	return events, nil
+}
+
+func parseICSTime(value string, params map[string]string) (time.Time, bool, error) {
+	if params["VALUE"] == "DATE" || len(value) == 8 {
+		t, err := time.ParseInLocation("20060102", value, time.Local)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+	loc := time.Local
+	if tzid := params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, err
+}
+
+func unescapeICS(s string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
+
+// CalendarScheduler sends reminders for upcoming calendar events to their groups
+type CalendarScheduler struct {
+	repo       *repository.SQLiteRepository
+	sender     *Sender
+	httpClient HTTPClient
+}
+
+// NewCalendarScheduler creates a new calendar scheduler
+func NewCalendarScheduler(repo *repository.SQLiteRepository, sender *Sender) *CalendarScheduler {
+	return &CalendarScheduler{
+		repo:       repo,
+		sender:     sender,
+		httpClient: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// Run checks calendars for due reminders until ctx is cancelled
+func (s *CalendarScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(CalendarCheckTick)
+	defer ticker.Stop()
+
+	for {
+		s.checkAll(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *CalendarScheduler) checkAll(now time.Time) {
+	calendars, err := s.repo.GetAllCalendars()
+	if err != nil {
+		logger.Warnf("calendar scheduler: failed to load calendars: %v", err)
+		return
+	}
+	for i := range calendars {
+		cal := &calendars[i]
+		if !cal.Enabled {
+			continue
+		}
+		if cal.URL != "" && (cal.LastFetchedAt == nil || now.Sub(*cal.LastFetchedAt) >= CalendarRefreshInterval) {
+			if err := s.Refresh(cal); err != nil {
+				logger.Warnf("calendar scheduler: %s: %v", cal.Name, err)
+			}
+		}
+		if _, err := s.SendDue(cal, now); err != nil {
+			logger.Warnf("calendar scheduler: %s: %v", cal.Name, err)
+		}
+	}
+}
+
+// Refresh downloads a linked calendar and stores its content
+func (s *CalendarScheduler) Refresh(cal *models.Calendar) error {
+	content, err := s.fetch(cal.URL)
+	now := time.Now()
+	if err != nil {
+		s.repo.UpdateCalendarContent(cal.ID, "", now, err.Error())
+		return err
+	}
+	cal.Content = content
+	cal.LastFetchedAt = &now
+	return s.repo.UpdateCalendarContent(cal.ID, content, now, "")
+}
+
+func (s *CalendarScheduler) fetch(url string) (string, error) {
+	resp, err := s.httpClient.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch calendar: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("calendar returned HTTP %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 5<<20))
+	if err != nil {
+		return "", fmt.Errorf("failed to read calendar: %w", err)
+	}
+	if _, err := ParseICS(string(body)); err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// SendDue sends reminders whose lead time has been reached for events that haven't started yet.
+// Each (event, lead time) pair is sent at most once.
+func (s *CalendarScheduler) SendDue(cal *models.Calendar, now time.Time) (int, error) {
+	events, err := ParseICS(cal.Content)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := make(map[string]bool, len(cal.SentKeys))
+	for _, key := range cal.SentKeys {
+		sent[key] = true
+	}
+
+	leads := append([]int(nil), cal.LeadMinutes...)
+	sort.Sort(sort.Reverse(sort.IntSlice(leads)))
+
+	count := 0
+	var sendErr error
+	keep := []string{}
+	for _, event := range events {
+		if !event.Start.After(now) {
+			continue
+		}
+		for _, lead := range leads {
+			key := event.UID + "|" + strconv.FormatInt(event.Start.Unix(), 10) + "|" + strconv.Itoa(lead)
+			if sent[key] {
+				keep = append(keep, key)
+				continue
+			}
+			if now.Before(event.Start.Add(-time.Duration(lead)*time.Minute)) || sendErr != nil {
+				continue
+			}
+			if err := s.notify(cal, event, lead); err != nil {
+				sendErr = err
+				continue
+			}
+			keep = append(keep, key)
+			count++
+		}
+	}
+
+	// Only keys for upcoming events are kept so the list doesn't grow forever
+	if err := s.repo.UpdateCalendarSentKeys(cal.ID, keep); err != nil {
+		return count, err
+	}
+	cal.SentKeys = keep
+	return count, sendErr
+}
+
+func (s *CalendarScheduler) notify(cal *models.Calendar, event CalendarEvent, lead int) error {
+	template, err := s.repo.GetTemplateByKey(cal.TemplateKey)
+	if err != nil {
+		return fmt.Errorf("template %q: %w", cal.TemplateKey, err)
+	}
+
+	start := event.Start.Local().Format("2006-01-02 15:04")
+	if event.AllDay {
+		start = event.Start.Format("2006-01-02")
+	}
+	keywords := RenderKeywords(cal.Keywords, map[string]string{
+		"event":       event.Summary,
+		"start":       start,
+		"location":    event.Location,
+		"description": TruncateRunes(event.Description, MaxKeywordLength),
+		"lead":        formatLead(lead),
+		"calendar":    cal.Name,
+	})
+	_, err = s.sender.SendToGroups(context.Background(), template, cal.GroupIDs, keywords, SendOptions{})
+	return err
+}
+
+// formatLead renders a lead time in minutes as a short human readable duration
+func formatLead(minutes int) string {
+	switch {
+	case minutes > 0 && minutes%1440 == 0:
+		return strconv.Itoa(minutes/1440) + "天"
+	case minutes > 0 && minutes%60 == 0:
+		return strconv.Itoa(minutes/60) + "小时"
+	default:
+		return strconv.Itoa(minutes) + "分钟"
+	}
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+const testICS = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\nUID:standup-1\r\nSUMMARY:Daily standup\\, team A\r\nDTSTART:20240301T013000Z\r\nLOCATION:Room 1\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:holiday\r\nSUMMARY:Holiday\r\nDTSTART;VALUE=DATE:20240405\r\nDESCRIPTION:first line\\n\r\n second line\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:no-start\r\nSUMMARY:Broken\r\nEND:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseICS(t *testing.T) {
+	events, err := ParseICS(testICS)
+	if err != nil {
+		t.Fatalf("ParseICS error: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events with a start time, got %d", len(events))
+	}
+
+	standup := events[0]
+	if standup.Summary != "Daily standup, team A" || standup.Location != "Room 1" {
+		t.Errorf("unexpected standup event: %+v", standup)
+	}
+	if want := time.Date(2024, 3, 1, 1, 30, 0, 0, time.UTC); !standup.Start.Equal(want) || standup.AllDay {
+		t.Errorf("standup start = %s (allDay %v), want %s", standup.Start, standup.AllDay, want)
+	}
+
+	holiday := events[1]
+	if !holiday.AllDay || holiday.Start.Format("2006-01-02") != "2024-04-05" {
+		t.Errorf("unexpected holiday start: %s (allDay %v)", holiday.Start, holiday.AllDay)
+	}
+	if holiday.Description != "first line\nsecond line" {
+		t.Errorf("folded description = %q", holiday.Description)
+	}
+
+	if _, err := ParseICS("not a calendar"); err != ErrInvalidICS {
+		t.Errorf("ParseICS(garbage) error = %v, want ErrInvalidICS", err)
+	}
+}
+
+func TestFormatLead(t *testing.T) {
+	cases := map[int]string{15: "15分钟", 60: "1小时", 90: "90分钟", 1440: "1天", 2880: "2天"}
+	for minutes, want := range cases {
+		if got := formatLead(minutes); got != want {
+			t.Errorf("formatLead(%d) = %q, want %q", minutes, got, want)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"wechat-notification/logger"
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+const (
+	// CallbackConfigKey stores the WeChat callback configuration of the default official account;
+	// additional accounts use CallbackConfigKeyFor
+	CallbackConfigKey = "wechat_callback"
+	// WeChatUserInfoURL is the URL to get a follower's basic info
+	WeChatUserInfoURL = "https://api.weixin.qq.com/cgi-bin/user/info"
+	// SubscribeMetadataKey marks recipients added when they followed the official account
+	SubscribeMetadataKey = "subscribe"
+)
+
+// CallbackSignature computes the signature WeChat attaches to callback requests: the SHA-1 of the
+// token, timestamp and nonce sorted and concatenated
+func CallbackSignature(token, timestamp, nonce string) string {
+	parts := []string{token, timestamp, nonce}
+	sort.Strings(parts)
+	sum := sha1.Sum([]byte(strings.Join(parts, "")))
+	return hex.EncodeToString(sum[:])
+}
+
+// CallbackConfigKeyFor returns the config key of an official account's callback configuration.
+// Every account has its own Token and EncodingAESKey in the MP console.
+func CallbackConfigKeyFor(appID int64) string {
+	if appID == 0 {
+		return CallbackConfigKey
+	}
+	return fmt.Sprintf("%s_%d", CallbackConfigKey, appID)
+}
+
+// LoadCallbackConfig returns the callback configuration of an official account; 0 selects the default account
+func LoadCallbackConfig(repo *repository.SQLiteRepository, appID int64) (models.CallbackConfig, error) {
+	var cfg models.CallbackConfig
+	err := repo.GetJSONConfig(CallbackConfigKeyFor(appID), &cfg)
+	return cfg, err
+}
+
+// ParseEvent reads a callback message
+func ParseEvent(body []byte) (*models.WeChatEvent, error) {
+	var event models.WeChatEvent
+	if err := xml.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("invalid callback message: %w", err)
+	}
+	if event.FromUserName == "" {
+		return nil, errors.New("invalid callback message: FromUserName is required")
+	}
+	return &event, nil
+}
+
+// UserInfo gets a follower's basic info. WeChat stopped returning nicknames to most accounts, so
+// Nickname is often empty even for followers.
+func (s *WeChatService) UserInfo(openID string) (*models.WeChatUserInfo, error) {
+	token, err := s.tokenManager.GetAccessToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	resp, err := s.httpClient.Get(fmt.Sprintf("%s?access_token=%s&openid=%s&lang=zh_CN", WeChatUserInfoURL, token, url.QueryEscape(openID)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user info: %w", withoutURL(err))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	var result struct {
+		models.WeChatAPIResponse
+		models.WeChatUserInfo
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if result.ErrCode != 0 {
+		return nil, &APIError{ErrCode: result.ErrCode, ErrMsg: result.ErrMsg}
+	}
+	return &result.WeChatUserInfo, nil
+}
+
+// followerName names a follower whose nickname is unknown after the end of their OpenID
+func followerName(openID string) string {
+	return "微信用户 " + openID[max(0, len(openID)-6):]
+}
+
+// Callbacks handles the events WeChat pushes to the callback URL
+type Callbacks struct {
+	repo *repository.SQLiteRepository
+	apps *AppRegistry
+}
+
+// NewCallbacks creates a new callback event handler
+func NewCallbacks(repo *repository.SQLiteRepository, apps *AppRegistry) *Callbacks {
+	return &Callbacks{repo: repo, apps: apps}
+}
+
+// Handle acts on an event received by the callback URL of an official account and returns the
+// text to reply to the user with, if any. Subscribe and SCAN events from a binding QR code bind
+// the user as the code says and confirm it; other subscribe and SCAN events add the user as a
+// recipient when auto-registration is on. Unsubscribe flags the recipient so sends to everyone
+// skip them until they follow again. Other messages are ignored.
+func (cb *Callbacks) Handle(appID int64, event *models.WeChatEvent) (string, error) {
+	if event.MsgType != "event" {
+		return "", nil
+	}
+	cfg, err := LoadCallbackConfig(cb.repo, appID)
+	if err != nil {
+		return "", err
+	}
+
+	switch event.Event {
+	case models.WeChatEventUnsubscribe:
+		known, err := cb.repo.SetUnsubscribed(event.FromUserName, true)
+		if known {
+			logger.Infof("callback: %s unfollowed the account", event.FromUserName)
+		}
+		return "", err
+	case models.WeChatEventSubscribe, models.WeChatEventScan:
+		if event.Event == models.WeChatEventSubscribe {
+			if _, err := cb.repo.SetUnsubscribed(event.FromUserName, false); err != nil {
+				return "", err
+			}
+		}
+		if scene := bindingScene(event); scene != "" {
+			return cb.bind(appID, event.FromUserName, scene, time.Now())
+		}
+		if !cfg.AutoRegister {
+			return "", nil
+		}
+		_, err := cb.register(appID, event.FromUserName, "")
+		return "", err
+	}
+	return "", nil
+}
+
+// register adds a follower as a recipient of the official account with their WeChat profile, named
+// name or, when that's empty, after their WeChat nickname when it can be fetched. An already known
+// OpenID is returned as is.
+func (cb *Callbacks) register(appID int64, openID, name string) (*models.Recipient, error) {
+	if existing, err := cb.repo.GetByOpenID(openID); err == nil {
+		return existing, nil
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+
+	var info *models.WeChatUserInfo
+	if wechatSvc, err := cb.apps.Service(appID); err == nil {
+		if info, err = wechatSvc.UserInfo(openID); err != nil {
+			logger.Warnf("callback: failed to get user info of %s: %v", openID, err)
+		}
+	}
+	if name == "" && info != nil {
+		name = strings.TrimSpace(info.Nickname)
+	}
+	if name == "" {
+		name = followerName(openID)
+	}
+
+	recipient := &models.Recipient{
+		OpenID:   openID,
+		AppID:    appID,
+		Name:     name,
+		Metadata: map[string]string{SubscribeMetadataKey: "auto"},
+	}
+	if err := cb.repo.Create(recipient); err != nil {
+		if errors.Is(err, repository.ErrDuplicateOpenID) {
+			return cb.repo.GetByOpenID(openID)
+		}
+		return nil, err
+	}
+	if info != nil && info.Subscribe != 0 {
+		applyWeChatProfile(recipient, info, time.Now())
+		if err := cb.repo.SetWeChatProfile(recipient); err != nil {
+			logger.Warnf("callback: failed to store the profile of %s: %v", openID, err)
+		}
+	}
+	logger.Infof("callback: registered %s (%s) on subscribe", name, openID)
+	return recipient, nil
+}
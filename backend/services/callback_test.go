@@ -0,0 +1,129 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+func TestCallbackSignature(t *testing.T) {
+	if got := CallbackSignature("mytoken", "1700000000", "nonce42"); got != "fd581522a38ebec2fca0e36cab4d5753f9d88a94" {
+		t.Errorf("CallbackSignature = %s", got)
+	}
+}
+
+func TestCallbackAutoRegister(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	nickname := "小王"
+	client := &MockHTTPClient{GetFunc: func(url string) (*http.Response, error) {
+		if !strings.HasPrefix(url, WeChatUserInfoURL+"?") {
+			t.Errorf("unexpected request to %s", url)
+		}
+		body := `{"subscribe":1,"openid":"x","nickname":"` + nickname + `"}`
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}}
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("token", time.Hour)
+	callbacks := NewCallbacks(repo, NewAppRegistry(NewWeChatServiceWithClient(tokens, "tpl", client)))
+
+	event, err := ParseEvent([]byte(`<xml><ToUserName><![CDATA[gh_1]]></ToUserName><FromUserName><![CDATA[o-follower-123456]]></FromUserName>
+		<CreateTime>1700000000</CreateTime><MsgType><![CDATA[event]]></MsgType><Event><![CDATA[subscribe]]></Event></xml>`))
+	if err != nil {
+		t.Fatalf("ParseEvent error: %v", err)
+	}
+
+	// Nothing happens until auto-registration is switched on
+	if _, err := callbacks.Handle(0, event); err != nil {
+		t.Fatalf("Handle error: %v", err)
+	}
+	if _, err := repo.GetByOpenID("o-follower-123456"); err != repository.ErrNotFound {
+		t.Fatalf("registered without autoRegister: %v", err)
+	}
+
+	repo.SetJSONConfig(CallbackConfigKey, models.CallbackConfig{Token: "tok", AutoRegister: true})
+	if _, err := callbacks.Handle(0, event); err != nil {
+		t.Fatalf("Handle error: %v", err)
+	}
+	r, err := repo.GetByOpenID("o-follower-123456")
+	if err != nil {
+		t.Fatalf("recipient not registered: %v", err)
+	}
+	if r.Name != "小王" || r.AppID != 0 || r.Metadata[SubscribeMetadataKey] != "auto" {
+		t.Errorf("unexpected recipient: %+v", r)
+	}
+
+	// Following again keeps the existing recipient; without a nickname the OpenID names the recipient
+	if _, err := callbacks.Handle(0, event); err != nil {
+		t.Fatalf("Handle error: %v", err)
+	}
+	if n, _ := repo.CountRecipients(); n != 1 {
+		t.Errorf("expected 1 recipient, got %d", n)
+	}
+	nickname = ""
+	event.Event = models.WeChatEventScan
+	event.FromUserName = "o-other-abcdef"
+	callbacks.Handle(0, event)
+	if r, err := repo.GetByOpenID("o-other-abcdef"); err != nil || r.Name != "微信用户 abcdef" {
+		t.Errorf("unexpected recipient %+v: %v", r, err)
+	}
+}
+
+func TestCallbackUnsubscribe(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	callbacks := NewCallbacks(repo, NewAppRegistry(nil))
+	recipient := &models.Recipient{OpenID: "o1", Name: "Alice"}
+	if err := repo.Create(recipient); err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+
+	event := &models.WeChatEvent{FromUserName: "o1", MsgType: "event", Event: models.WeChatEventUnsubscribe}
+	if _, err := callbacks.Handle(0, event); err != nil {
+		t.Fatalf("Handle error: %v", err)
+	}
+	if r, _ := repo.GetByID(recipient.ID); !r.Unsubscribed {
+		t.Error("recipient not flagged after unsubscribe")
+	}
+	if recipients, _ := LoadRecipients(repo, nil); len(recipients) != 0 {
+		t.Error("sends to everyone still include the unsubscribed recipient")
+	}
+
+	// Following again clears the flag even with auto-registration off
+	event.Event = models.WeChatEventSubscribe
+	if _, err := callbacks.Handle(0, event); err != nil {
+		t.Fatalf("Handle error: %v", err)
+	}
+	if r, _ := repo.GetByID(recipient.ID); r.Unsubscribed {
+		t.Error("recipient still flagged after following again")
+	}
+
+	// Unknown users are ignored
+	event = &models.WeChatEvent{FromUserName: "o-unknown", MsgType: "event", Event: models.WeChatEventUnsubscribe}
+	if _, err := callbacks.Handle(0, event); err != nil {
+		t.Errorf("Handle error for unknown user: %v", err)
+	}
+}
+
+func TestUserInfoHidesToken(t *testing.T) {
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("tok_s3cret", time.Hour)
+	_, err := NewWeChatServiceWithClient(tokens, "tpl", failingClient()).UserInfo("o1")
+	if err == nil || strings.Contains(err.Error(), "tok_s3cret") {
+		t.Errorf("UserInfo error = %v, want one without the access token", err)
+	}
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"wechat-notification/models"
+)
+
+// callbackBlockSize is the padding block size of WeChat's message encryption, which pads to 32
+// bytes although AES blocks are 16
+const callbackBlockSize = 32
+
+var (
+	// ErrInvalidEncodingAESKey is returned for an EncodingAESKey that isn't 43 base64 characters
+	ErrInvalidEncodingAESKey = errors.New("encodingAESKey must be 43 letters or digits")
+	// ErrInvalidMsgSignature is returned when the msg_signature of an encrypted callback doesn't match
+	ErrInvalidMsgSignature = errors.New("invalid msg_signature")
+	// ErrInvalidCiphertext is returned when an encrypted callback message can't be decrypted or
+	// was encrypted for another account
+	ErrInvalidCiphertext = errors.New("invalid encrypted message")
+)
+
+// CallbackCipher encrypts and decrypts callback messages of an official account in safe mode
+// (安全模式) with the EncodingAESKey set in the MP console
+type CallbackCipher struct {
+	token string
+	key   []byte
+	appID string
+}
+
+// NewCallbackCipher creates the cipher of the official account appID
+func NewCallbackCipher(token, encodingAESKey, appID string) (*CallbackCipher, error) {
+	if len(encodingAESKey) != 43 {
+		return nil, ErrInvalidEncodingAESKey
+	}
+	key, err := base64.StdEncoding.DecodeString(encodingAESKey + "=")
+	if err != nil || len(key) != 32 {
+		return nil, ErrInvalidEncodingAESKey
+	}
+	return &CallbackCipher{token: token, key: key, appID: appID}, nil
+}
+
+// Signature computes the msg_signature of an encrypted message: the SHA-1 of the token, timestamp,
+// nonce and ciphertext sorted and concatenated
+func (c *CallbackCipher) Signature(timestamp, nonce, encrypted string) string {
+	parts := []string{c.token, timestamp, nonce, encrypted}
+	sort.Strings(parts)
+	sum := sha1.Sum([]byte(strings.Join(parts, "")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Open checks the signature of an encrypted callback body and returns the message XML inside
+func (c *CallbackCipher) Open(body []byte, timestamp, nonce, msgSignature string) ([]byte, error) {
+	var envelope struct {
+		Encrypt string `xml:"Encrypt"`
+	}
+	if err := xml.Unmarshal(body, &envelope); err != nil || envelope.Encrypt == "" {
+		return nil, ErrInvalidCiphertext
+	}
+	want := c.Signature(timestamp, nonce, envelope.Encrypt)
+	if subtle.ConstantTimeCompare([]byte(want), []byte(msgSignature)) != 1 {
+		return nil, ErrInvalidMsgSignature
+	}
+	return c.Decrypt(envelope.Encrypt)
+}
+
+// Seal encrypts a reply message and wraps it in the signed envelope WeChat expects in safe mode
+func (c *CallbackCipher) Seal(reply []byte, timestamp, nonce string) ([]byte, error) {
+	encrypted, err := c.Encrypt(reply)
+	if err != nil {
+		return nil, err
+	}
+	envelope := struct {
+		XMLName      xml.Name `xml:"xml"`
+		Encrypt      cdata    `xml:"Encrypt"`
+		MsgSignature cdata    `xml:"MsgSignature"`
+		TimeStamp    string   `xml:"TimeStamp"`
+		Nonce        cdata    `xml:"Nonce"`
+	}{
+		Encrypt:      cdata{encrypted},
+		MsgSignature: cdata{c.Signature(timestamp, nonce, encrypted)},
+		TimeStamp:    timestamp,
+		Nonce:        cdata{nonce},
+	}
+	return xml.Marshal(envelope)
+}
+
+// Decrypt decrypts a base64 ciphertext: AES-256-CBC with the key's first 16 bytes as IV over 16
+// random bytes, the message length, the message and the AppID
+func (c *CallbackCipher) Decrypt(encrypted string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil || len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, ErrInvalidCiphertext
+	}
+	block, err := aes.NewCipher(c.key)
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, c.key[:aes.BlockSize]).CryptBlocks(plain, data)
+
+	pad := int(plain[len(plain)-1])
+	if pad < 1 || pad > callbackBlockSize || pad > len(plain) {
+		return nil, ErrInvalidCiphertext
+	}
+	plain = plain[:len(plain)-pad]
+	if len(plain) < 20 {
+		return nil, ErrInvalidCiphertext
+	}
+	size := int(binary.BigEndian.Uint32(plain[16:20]))
+	if size > len(plain)-20 {
+		return nil, ErrInvalidCiphertext
+	}
+	if string(plain[20+size:]) != c.appID {
+		return nil, fmt.Errorf("%w: encrypted for another AppID", ErrInvalidCiphertext)
+	}
+	return plain[20 : 20+size], nil
+}
+
+// Encrypt encrypts a message the way Decrypt expects it
+func (c *CallbackCipher) Encrypt(msg []byte) (string, error) {
+	var buf bytes.Buffer
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	buf.Write(random)
+	binary.Write(&buf, binary.BigEndian, uint32(len(msg)))
+	buf.Write(msg)
+	buf.WriteString(c.appID)
+	pad := callbackBlockSize - buf.Len()%callbackBlockSize
+	buf.Write(bytes.Repeat([]byte{byte(pad)}, pad))
+
+	block, err := aes.NewCipher(c.key)
+	if err != nil {
+		return "", err
+	}
+	data := buf.Bytes()
+	cipher.NewCBCEncrypter(block, c.key[:aes.BlockSize]).CryptBlocks(data, data)
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// cdata marshals a string as a CDATA section, as WeChat's own XML does
+type cdata struct {
+	Value string `xml:",cdata"`
+}
+
+// TextReply builds a passive text reply (被动回复) to the user who sent event
+func TextReply(event *models.WeChatEvent, content string, now time.Time) []byte {
+	reply := struct {
+		XMLName      xml.Name `xml:"xml"`
+		ToUserName   cdata    `xml:"ToUserName"`
+		FromUserName cdata    `xml:"FromUserName"`
+		CreateTime   string   `xml:"CreateTime"`
+		MsgType      cdata    `xml:"MsgType"`
+		Content      cdata    `xml:"Content"`
+	}{
+		ToUserName:   cdata{event.FromUserName},
+		FromUserName: cdata{event.ToUserName},
+		CreateTime:   strconv.FormatInt(now.Unix(), 10),
+		MsgType:      cdata{"text"},
+		Content:      cdata{content},
+	}
+	out, _ := xml.Marshal(reply)
+	return out
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+)
+
+func TestCallbackCipher(t *testing.T) {
+	const key = "abcdefghijklmnopqrstuvwxyz0123456789ABCDEFG"
+	if _, err := NewCallbackCipher("tok", key[:42], "wx1"); err != ErrInvalidEncodingAESKey {
+		t.Errorf("expected ErrInvalidEncodingAESKey for a short key, got %v", err)
+	}
+	crypter, err := NewCallbackCipher("tok", key, "wx1234567890")
+	if err != nil {
+		t.Fatalf("NewCallbackCipher error: %v", err)
+	}
+
+	message := []byte(`<xml><ToUserName><![CDATA[gh_1]]></ToUserName><FromUserName><![CDATA[o-user]]></FromUserName>` +
+		`<MsgType><![CDATA[event]]></MsgType><Event><![CDATA[subscribe]]></Event></xml>`)
+	encrypted, err := crypter.Encrypt(message)
+	if err != nil {
+		t.Fatalf("Encrypt error: %v", err)
+	}
+	body := []byte("<xml><ToUserName><![CDATA[gh_1]]></ToUserName><Encrypt><![CDATA[" + encrypted + "]]></Encrypt></xml>")
+	signature := crypter.Signature("1700000000", "nonce42", encrypted)
+
+	opened, err := crypter.Open(body, "1700000000", "nonce42", signature)
+	if err != nil || string(opened) != string(message) {
+		t.Fatalf("Open = %q, %v", opened, err)
+	}
+	if _, err := crypter.Open(body, "1700000001", "nonce42", signature); err != ErrInvalidMsgSignature {
+		t.Errorf("expected ErrInvalidMsgSignature, got %v", err)
+	}
+
+	// Messages encrypted for another account are rejected
+	other, _ := NewCallbackCipher("tok", key, "wx-other")
+	if _, err := other.Decrypt(encrypted); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("expected ErrInvalidCiphertext, got %v", err)
+	}
+
+	// A sealed reply carries its own signature and decrypts back to the reply
+	reply := TextReply(&models.WeChatEvent{ToUserName: "gh_1", FromUserName: "o-user"}, "绑定成功", time.Unix(1700000000, 0))
+	if !strings.Contains(string(reply), "<ToUserName><![CDATA[o-user]]></ToUserName>") || !strings.Contains(string(reply), "<Content><![CDATA[绑定成功]]></Content>") {
+		t.Errorf("unexpected reply: %s", reply)
+	}
+	sealed, err := crypter.Seal(reply, "1700000000", "nonce42")
+	if err != nil {
+		t.Fatalf("Seal error: %v", err)
+	}
+	var envelope struct {
+		Encrypt      string `xml:"Encrypt"`
+		MsgSignature string `xml:"MsgSignature"`
+		TimeStamp    string `xml:"TimeStamp"`
+		Nonce        string `xml:"Nonce"`
+	}
+	if err := xml.Unmarshal(sealed, &envelope); err != nil {
+		t.Fatalf("invalid envelope %s: %v", sealed, err)
+	}
+	if envelope.MsgSignature != crypter.Signature(envelope.TimeStamp, envelope.Nonce, envelope.Encrypt) {
+		t.Errorf("reply signature does not match")
+	}
+	if decrypted, err := crypter.Decrypt(envelope.Encrypt); err != nil || string(decrypted) != string(reply) {
+		t.Errorf("Decrypt = %q, %v", decrypted, err)
+	}
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"wechat-notification/models"
+)
+
+// CanaryConfigKey is the config key of the canary send run before WeChat config changes are applied
+const CanaryConfigKey = "wechat_canary"
+
+// withCredentials returns a token manager for the given credentials with the same token source
+// settings. The manager itself is returned when the credentials are unchanged, so its cached token
+// is reused instead of fetching a new one.
+func (tm *TokenManager) withCredentials(appID, appSecret string) *TokenManager {
+	if current, secret := tm.GetCredentials(); appID == current && appSecret == secret {
+		return tm
+	}
+	return tm.clone(appID, appSecret)
+}
+
+// Candidate returns a service that sends with the given credentials and default template, so a
+// configuration can be tried out before it replaces the current one
+func (s *WeChatService) Candidate(appID, appSecret, templateID string) *WeChatService {
+	return &WeChatService{
+		tokenManager: s.tokenManager.withCredentials(appID, appSecret),
+		templateID:   templateID,
+		httpClient:   s.httpClient,
+	}
+}
+
+// CanarySend sends a test message with the service's default template to recipient, returning an
+// error unless WeChat accepts it
+func CanarySend(wechatSvc *WeChatService, recipient *models.Recipient, now time.Time) error {
+	if wechatSvc.templateID == "" {
+		return fmt.Errorf("no default template ID configured")
+	}
+	if recipient.OpenID == "" {
+		return fmt.Errorf("canary recipient %s has no OpenID", recipient.Name)
+	}
+	_, err := wechatSvc.SendMessage(recipient.OpenID, wechatSvc.templateID, map[string]string{
+		"first":  "配置变更验证",
+		"remark": now.Format("2006-01-02 15:04:05"),
+	})
+	return err
+}
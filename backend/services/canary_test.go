@@ -0,0 +1,48 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+)
+
+func TestCanarySend(t *testing.T) {
+	var posted string
+	reply := `{"errcode":0,"errmsg":"ok","msgid":1}`
+	client := &MockHTTPClient{PostFunc: func(url, contentType string, body io.Reader) (*http.Response, error) {
+		data, _ := io.ReadAll(body)
+		posted = url + " " + string(data)
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(reply))}, nil
+	}}
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("token", time.Hour)
+	wechatSvc := NewWeChatServiceWithClient(tokens, "tpl_old", client)
+	recipient := &models.Recipient{ID: 1, Name: "测试", OpenID: "o_test"}
+
+	// Same credentials reuse the cached token; only the template changes
+	candidate := wechatSvc.Candidate("app", "secret", "tpl_new")
+	if err := CanarySend(candidate, recipient, time.Now()); err != nil {
+		t.Fatalf("CanarySend error: %v", err)
+	}
+	if !strings.Contains(posted, "access_token=token") || !strings.Contains(posted, `"template_id":"tpl_new"`) || !strings.Contains(posted, "o_test") {
+		t.Errorf("canary should use the new template with the cached token: %s", posted)
+	}
+	if wechatSvc.templateID != "tpl_old" {
+		t.Errorf("candidate must not change the live service, template = %s", wechatSvc.templateID)
+	}
+	if other := wechatSvc.Candidate("app2", "secret2", "tpl_new"); other.tokenManager == tokens {
+		t.Error("new credentials need their own token manager")
+	}
+
+	reply = `{"errcode":40037,"errmsg":"invalid template_id"}`
+	if err := CanarySend(candidate, recipient, time.Now()); err == nil {
+		t.Error("rejected canary should fail")
+	}
+	if err := CanarySend(wechatSvc.Candidate("app", "secret", ""), recipient, time.Now()); err == nil {
+		t.Error("canary without a template should fail")
+	}
+}
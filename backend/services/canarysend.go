@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"wechat-notification/models"
+)
+
+const (
+	// DefaultCanaryWait is how long a canary broadcast waits before sending the rest
+	DefaultCanaryWait = 5 * time.Minute
+	// MaxCanaryWait is the longest a canary broadcast can hold back the rest
+	MaxCanaryWait = 24 * time.Hour
+	// MaxCanaryPercent is the largest share of a broadcast the canary slice can take
+	MaxCanaryPercent = 50
+)
+
+var (
+	// ErrInvalidCanary is returned for canary options that don't select a slice or wait too long
+	ErrInvalidCanary = fmt.Errorf("canary needs percent (1-%d) or groupId, waitSeconds of at most %s and minAcks only with requireAck", MaxCanaryPercent, MaxCanaryWait)
+	// ErrEmptyCanary is returned when none of the recipients are in the canary group
+	ErrEmptyCanary = errors.New("canary group has none of the recipients")
+	// ErrCanaryMaintenance is returned when a canary broadcast is started during maintenance mode
+	ErrCanaryMaintenance = errors.New("canary sends are unavailable during maintenance mode")
+)
+
+// CanaryRollout reports the canary slice of a broadcast and the scheduled send holding the rest
+type CanaryRollout struct {
+	Recipients  int       `json:"recipients"`  // Recipients in the canary slice
+	Remaining   int       `json:"remaining"`   // Recipients held back
+	ScheduledID int64     `json:"scheduledId"` // Scheduled message sending the rest, 0 when nothing was held back
+	ContinueAt  time.Time `json:"continueAt"`
+}
+
+// ValidateCanary checks canary options against the send they belong to
+func ValidateCanary(opts *models.CanaryOptions, requireAck bool) error {
+	if (opts.Percent == 0) == (opts.GroupID == 0) || opts.Percent < 0 || opts.Percent > MaxCanaryPercent {
+		return ErrInvalidCanary
+	}
+	if opts.WaitSeconds < 0 || time.Duration(opts.WaitSeconds)*time.Second > MaxCanaryWait {
+		return ErrInvalidCanary
+	}
+	if opts.MaxFailures < 0 || opts.MinAcks < 0 || (opts.MinAcks > 0 && !requireAck) {
+		return ErrInvalidCanary
+	}
+	return nil
+}
+
+// SplitCanary divides recipients into the canary slice and the rest. With a group, the canary slice
+// is the recipients in it; with a percentage, it's that share of the recipients by ID, at least one.
+func SplitCanary(recipients []models.Recipient, opts *models.CanaryOptions, groupMembers map[int64]bool) (canary, rest []models.Recipient) {
+	if opts.GroupID != 0 {
+		for _, r := range recipients {
+			if groupMembers[r.ID] {
+				canary = append(canary, r)
+			} else {
+				rest = append(rest, r)
+			}
+		}
+		return canary, rest
+	}
+
+	sorted := append([]models.Recipient(nil), recipients...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	n := max(1, (len(sorted)*opts.Percent+99)/100)
+	n = min(n, len(sorted))
+	return sorted[:n], sorted[n:]
+}
+
+// SendCanary sends to the canary slice of recipients now and schedules the rest for after the
+// wait. When the scheduled send comes due, it goes ahead only if the canary send reached at least
+// one recipient, failed for at most MaxFailures and, with MinAcks, enough of them acknowledged it.
+// Recipients skipped as muted or in quiet hours prove nothing, so they don't count as reached.
+func (s *Sender) SendCanary(ctx context.Context, template *models.MessageTemplate, recipients []models.Recipient, keywords map[string]string, opts SendOptions, canary *models.CanaryOptions) (SendResponse, error) {
+	if s.maintenance.Enabled() {
+		return SendResponse{}, ErrCanaryMaintenance
+	}
+	var members map[int64]bool
+	if canary.GroupID != 0 {
+		group, err := s.repo.GetGroupByID(canary.GroupID)
+		if err != nil {
+			return SendResponse{}, fmt.Errorf("canary group: %w", err)
+		}
+		members = make(map[int64]bool, len(group.RecipientIDs))
+		for _, id := range group.RecipientIDs {
+			members[id] = true
+		}
+	}
+	first, rest := SplitCanary(recipients, canary, members)
+	if len(first) == 0 {
+		return SendResponse{}, ErrEmptyCanary
+	}
+
+	started := time.Now()
+	response, err := s.Send(ctx, template, first, keywords, opts)
+	if err != nil {
+		return SendResponse{}, err
+	}
+	wait := DefaultCanaryWait
+	if canary.WaitSeconds > 0 {
+		wait = time.Duration(canary.WaitSeconds) * time.Second
+	}
+	rollout := &CanaryRollout{Recipients: len(first), Remaining: len(rest), ContinueAt: started.Add(wait)}
+	response.Canary = rollout
+	if len(rest) == 0 {
+		return response, nil
+	}
+
+	gate := &models.CanaryGate{
+		MessageID:   response.MessageID,
+		MaxFailures: canary.MaxFailures,
+		MinAcks:     canary.MinAcks,
+		StartedAt:   started,
+	}
+	for _, r := range first {
+		gate.RecipientIDs = append(gate.RecipientIDs, r.ID)
+	}
+	remainder := &models.ScheduledMessage{
+		TemplateKey: template.Key,
+		Keywords:    keywords,
+		RequireAck:  opts.RequireAck,
+		ReplyTo:     opts.ReplyTo,
+		Channels:    opts.Channels,
+		Tags:        opts.Tags,
+		Priority:    opts.Priority,
+		AppID:       opts.AppID,
+		URL:         opts.URL,
+		Miniprogram: opts.Miniprogram,
+		Mode:        opts.Mode,
+		MediaID:     opts.MediaID,
+		SendAt:      rollout.ContinueAt,
+		Source:      opts.Source,
+		Canary:      gate,
+	}
+	for _, r := range rest {
+		remainder.RecipientIDs = append(remainder.RecipientIDs, r.ID)
+	}
+	if err := s.repo.CreateScheduledMessage(remainder); err != nil {
+		return response, fmt.Errorf("failed to schedule the rest of the canary send: %w", err)
+	}
+	rollout.ScheduledID = remainder.ID
+	return response, nil
+}
+
+// canaryFailure checks a canary send, returning why the rest must not go out or "" when it can
+func (s *ScheduledSender) canaryFailure(gate *models.CanaryGate) (string, error) {
+	if gate.MessageID == 0 {
+		return "canary send was not recorded", nil
+	}
+	message, err := s.repo.GetMessageByID(gate.MessageID)
+	if err != nil {
+		return "", err
+	}
+	if message.TotalSent == 0 {
+		return "canary send reached no recipients", nil
+	}
+	if message.TotalFailed > gate.MaxFailures {
+		return fmt.Sprintf("canary send failed for %d recipients (max %d)", message.TotalFailed, gate.MaxFailures), nil
+	}
+	if gate.MinAcks > 0 {
+		acked, err := s.repo.CountAcknowledged(message.TemplateKey, gate.RecipientIDs, gate.StartedAt)
+		if err != nil {
+			return "", err
+		}
+		if acked < gate.MinAcks {
+			return fmt.Sprintf("only %d canary recipients acknowledged (min %d)", acked, gate.MinAcks), nil
+		}
+	}
+	return "", nil
+}
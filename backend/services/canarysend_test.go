@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+func TestSplitCanary(t *testing.T) {
+	var recipients []models.Recipient
+	for id := int64(10); id > 0; id-- {
+		recipients = append(recipients, models.Recipient{ID: id})
+	}
+
+	canary, rest := SplitCanary(recipients, &models.CanaryOptions{Percent: 20}, nil)
+	if len(canary) != 2 || canary[0].ID != 1 || canary[1].ID != 2 || len(rest) != 8 {
+		t.Errorf("20%%: canary %v, rest %d", canary, len(rest))
+	}
+	if canary, _ := SplitCanary(recipients, &models.CanaryOptions{Percent: 1}, nil); len(canary) != 1 {
+		t.Errorf("1%% should still pick one recipient, got %d", len(canary))
+	}
+	canary, rest = SplitCanary(recipients, &models.CanaryOptions{GroupID: 1}, map[int64]bool{3: true, 7: true, 99: true})
+	if len(canary) != 2 || len(rest) != 8 {
+		t.Errorf("group: canary %v, rest %d", canary, len(rest))
+	}
+}
+
+func TestValidateCanary(t *testing.T) {
+	for _, tc := range []struct {
+		opts       models.CanaryOptions
+		requireAck bool
+		ok         bool
+	}{
+		{models.CanaryOptions{Percent: 10}, false, true},
+		{models.CanaryOptions{GroupID: 2, WaitSeconds: 600, MinAcks: 1}, true, true},
+		{models.CanaryOptions{}, false, false},
+		{models.CanaryOptions{Percent: 10, GroupID: 2}, false, false},
+		{models.CanaryOptions{Percent: 60}, false, false},
+		{models.CanaryOptions{Percent: 10, WaitSeconds: 2 * 86400}, false, false},
+		{models.CanaryOptions{Percent: 10, MinAcks: 1}, false, false},
+	} {
+		if err := ValidateCanary(&tc.opts, tc.requireAck); (err == nil) != tc.ok {
+			t.Errorf("%+v (requireAck %v): err = %v", tc.opts, tc.requireAck, err)
+		}
+	}
+}
+
+func TestSendCanary(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	var mu sync.Mutex
+	var sentTo []string
+	failing := map[string]bool{}
+	client := &MockHTTPClient{PostFunc: func(url, contentType string, body io.Reader) (*http.Response, error) {
+		var msg models.WeChatTemplateMessage
+		json.NewDecoder(body).Decode(&msg)
+		mu.Lock()
+		defer mu.Unlock()
+		sentTo = append(sentTo, msg.ToUser)
+		if failing[msg.ToUser] {
+			return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"errcode":40003,"errmsg":"invalid openid"}`))}, nil
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"errcode":0,"errmsg":"ok"}`))}, nil
+	}}
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("token", time.Hour)
+	sender := NewSender(repo, NewWeChatServiceWithClient(tokens, "tpl", client), "")
+	scheduled := NewScheduledSender(repo, sender)
+
+	template := &models.MessageTemplate{Key: "k", TemplateID: "tpl", Name: "K"}
+	if err := repo.CreateTemplate(template); err != nil {
+		t.Fatalf("CreateTemplate error: %v", err)
+	}
+	var recipients []models.Recipient
+	for _, openID := range []string{"o1", "o2", "o3", "o4"} {
+		r := &models.Recipient{OpenID: openID, Name: openID}
+		repo.Create(r)
+		recipients = append(recipients, *r)
+	}
+
+	run := func() (SendResponse, *models.ScheduledMessage) {
+		sentTo = nil
+		response, err := sender.SendCanary(context.Background(), template, recipients, map[string]string{"first": "hi"}, SendOptions{},
+			&models.CanaryOptions{Percent: 25, WaitSeconds: 60})
+		if err != nil {
+			t.Fatalf("SendCanary error: %v", err)
+		}
+		if response.Canary == nil || response.Canary.Recipients != 1 || response.Canary.Remaining != 3 || len(sentTo) != 1 || sentTo[0] != "o1" {
+			t.Fatalf("unexpected canary response %+v, sent to %v", response.Canary, sentTo)
+		}
+		m, err := repo.GetScheduledMessageByID(response.Canary.ScheduledID)
+		if err != nil {
+			t.Fatalf("rest not scheduled: %v", err)
+		}
+		if len(m.RecipientIDs) != 3 || m.Canary == nil || m.Canary.MessageID != response.MessageID {
+			t.Fatalf("unexpected scheduled rest: %+v", m)
+		}
+		return response, m
+	}
+
+	// The canary went through, so the rest follows
+	_, m := run()
+	sentTo = nil
+	if err := scheduled.Deliver(m); err != nil {
+		t.Fatalf("Deliver error: %v", err)
+	}
+	if m, _ = repo.GetScheduledMessageByID(m.ID); m.Status != models.ScheduledStatusSent || len(sentTo) != 3 {
+		t.Errorf("rest: status %s, sent to %v", m.Status, sentTo)
+	}
+
+	// A failed canary aborts the rest
+	failing["o1"] = true
+	_, m = run()
+	sentTo = nil
+	if err := scheduled.Deliver(m); err != nil {
+		t.Fatalf("Deliver error: %v", err)
+	}
+	if m, _ = repo.GetScheduledMessageByID(m.ID); m.Status != models.ScheduledStatusAborted || len(sentTo) != 0 || !strings.Contains(m.LastError, "canary") {
+		t.Errorf("rest after failed canary: status %s, error %q, sent to %v", m.Status, m.LastError, sentTo)
+	}
+
+	// A canary nobody received proves nothing, so the rest is held back too
+	failing["o1"] = false
+	muted := recipients[0]
+	muted.Muted = true
+	recipients[0] = muted
+	response, err := sender.SendCanary(context.Background(), template, recipients, map[string]string{"first": "hi"}, SendOptions{},
+		&models.CanaryOptions{Percent: 25})
+	if err != nil || response.TotalSkipped != 1 {
+		t.Fatalf("SendCanary to a muted recipient: %+v, %v", response, err)
+	}
+	m, _ = repo.GetScheduledMessageByID(response.Canary.ScheduledID)
+	if err := scheduled.Deliver(m); err != nil {
+		t.Fatalf("Deliver error: %v", err)
+	}
+	if m, _ = repo.GetScheduledMessageByID(m.ID); m.Status != models.ScheduledStatusAborted {
+		t.Errorf("rest after a skipped canary: status %s", m.Status)
+	}
+
+	// A group holding none of the recipients is no canary at all
+	group := &models.Group{Name: "empty"}
+	repo.CreateGroup(group)
+	if _, err := sender.SendCanary(context.Background(), template, recipients, map[string]string{"first": "hi"}, SendOptions{},
+		&models.CanaryOptions{GroupID: group.ID}); err != ErrEmptyCanary {
+		t.Errorf("expected ErrEmptyCanary, got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"wechat-notification/config"
+	"wechat-notification/models"
+)
+
+// Auth modes of the admin API and the public endpoints
+const (
+	AuthOIDC         = "oidc"          // Admin API behind an OIDC login session
+	AuthDevMode      = "dev"           // Admin API open, no login
+	AuthWebhookToken = "webhook_token" // Bearer token on /api/webhook/send
+	AuthWebhookHMAC  = "webhook_hmac"  // HMAC-SHA256 body signature in X-Signature on /api/webhook/send
+	AuthSendKey      = "sendkey"       // Per-service keys of the Server酱, PushPlus and WxPusher endpoints
+	AuthProfileLink  = "profile_link"  // Signed links to the recipient self-service profile
+)
+
+// Capabilities describes what this server is configured to do, so clients can adapt to it
+type Capabilities struct {
+	Channels     []string `json:"channels"`     // Channel types attachable to recipients and groups
+	Contacts     []string `json:"contacts"`     // Contact channels recipients can prefer over WeChat
+	Schedulers   []string `json:"schedulers"`   // Background workers
+	Adapters     []string `json:"adapters"`     // Inbound integrations that trigger sends
+	Auth         []string `json:"auth"`         // Auth modes in use
+	TokenSource  string   `json:"tokenSource"`  // token, stable_token or provider
+	MultiAccount bool     `json:"multiAccount"` // Several official accounts can be configured under /api/apps
+	Sandbox      bool     `json:"sandbox"`      // The default account is a WeChat test account
+}
+
+// BuildCapabilities derives the capabilities from the configuration
+func BuildCapabilities(cfg *config.Config, tokens *TokenManager) Capabilities {
+	caps := Capabilities{
+		Channels: []string{
+			models.ChannelSlack, models.ChannelFeishu, models.ChannelGotify, models.ChannelNtfy,
+			models.ChannelServerChan, models.ChannelWeComApp, models.ChannelWeComBot, models.ChannelBark,
+		},
+		Contacts:     []string{models.ContactWeChat},
+		Schedulers:   []string{"scheduled", "retry", "feeds", "monitors", "heartbeats", "calendars", "stats_export"},
+		Adapters:     []string{"webhook", "email_gateway", "serverchan", models.CompatPushPlus, models.CompatWxPusher},
+		TokenSource:  tokens.Status().Source,
+		MultiAccount: true,
+		Sandbox:      cfg.WeChat.Sandbox,
+	}
+	if cfg.SMTP.Host != "" {
+		caps.Contacts = append(caps.Contacts, models.ContactEmail)
+	}
+	if cfg.TelegramBotToken != "" {
+		caps.Contacts = append(caps.Contacts, models.ContactTelegram)
+	}
+	if cfg.DevMode {
+		caps.Auth = append(caps.Auth, AuthDevMode)
+	} else {
+		caps.Auth = append(caps.Auth, AuthOIDC)
+	}
+	caps.Auth = append(caps.Auth, AuthWebhookToken, AuthWebhookHMAC, AuthSendKey, AuthProfileLink)
+	return caps
+}
+
+// String formats the capabilities for the startup banner
+func (c Capabilities) String() string {
+	s := fmt.Sprintf("channels=%s contacts=%s schedulers=%s adapters=%s auth=%s token=%s",
+		strings.Join(c.Channels, ","), strings.Join(c.Contacts, ","), strings.Join(c.Schedulers, ","),
+		strings.Join(c.Adapters, ","), strings.Join(c.Auth, ","), c.TokenSource)
+	if c.Sandbox {
+		s += " sandbox"
+	}
+	return s
+}
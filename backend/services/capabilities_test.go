@@ -0,0 +1,30 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"wechat-notification/config"
+	"wechat-notification/models"
+)
+
+func TestBuildCapabilities(t *testing.T) {
+	tokens := NewTokenManager("app", "secret")
+	caps := BuildCapabilities(&config.Config{DevMode: true}, tokens)
+	if !reflect.DeepEqual(caps.Contacts, []string{models.ContactWeChat}) {
+		t.Errorf("contacts = %v", caps.Contacts)
+	}
+	if caps.Auth[0] != AuthDevMode || caps.TokenSource != "token" {
+		t.Errorf("auth = %v, token source = %s", caps.Auth, caps.TokenSource)
+	}
+
+	tokens.SetTokenProvider("http://tokens.local/token")
+	cfg := &config.Config{SMTP: config.SMTPConfig{Host: "smtp.local"}, TelegramBotToken: "bot"}
+	caps = BuildCapabilities(cfg, tokens)
+	if !reflect.DeepEqual(caps.Contacts, []string{models.ContactWeChat, models.ContactEmail, models.ContactTelegram}) {
+		t.Errorf("contacts = %v", caps.Contacts)
+	}
+	if caps.Auth[0] != AuthOIDC || caps.TokenSource != "provider" {
+		t.Errorf("auth = %v, token source = %s", caps.Auth, caps.TokenSource)
+	}
+}
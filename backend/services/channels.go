@@ -0,0 +1,504 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"wechat-notification/models"
+)
+
+// Notification is the channel-neutral form of a template message, used by non-WeChat channels
+type Notification struct {
+	Title    string
+	Content  string
+	URL      string
+	Priority string // models.Priority*; empty means normal. Channels with sound or interruption levels map it onto those.
+}
+
+// BuildNotification flattens a template and its keywords into a title and content.
+// The "first" keyword becomes the title when present, otherwise the template name is used;
+// the remaining keywords are listed in key order with "remark" last.
+func BuildNotification(template *models.MessageTemplate, keywords map[string]string) Notification {
+	title := template.Name
+	if first := strings.TrimSpace(keywords["first"]); first != "" {
+		title = first
+	}
+
+	lines := make([]string, 0, len(keywords))
+	for _, k := range FieldOrder(keywords) {
+		if k == "first" {
+			continue
+		}
+		if v := strings.TrimSpace(keywords[k]); v != "" {
+			lines = append(lines, v)
+		}
+	}
+
+	return Notification{Title: title, Content: strings.Join(lines, "\n")}
+}
+
+// FieldOrder returns the keyword keys in display order: "first", the rest sorted, then "remark"
+func FieldOrder(keywords map[string]string) []string {
+	keys := make([]string, 0, len(keywords))
+	for k := range keywords {
+		if k != "first" && k != "remark" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	if _, ok := keywords["first"]; ok {
+		keys = append([]string{"first"}, keys...)
+	}
+	if _, ok := keywords["remark"]; ok {
+		keys = append(keys, "remark")
+	}
+	return keys
+}
+
+// ChannelNotifier delivers a notification to an external channel
+type ChannelNotifier interface {
+	Notify(n Notification) error
+}
+
+// ValidateChannel checks a channel's type and required settings, trimming whitespace in place
+func ValidateChannel(ch *models.Channel) error {
+	ch.Type = strings.TrimSpace(ch.Type)
+	ch.WebhookURL = strings.TrimSpace(ch.WebhookURL)
+	ch.Secret = strings.TrimSpace(ch.Secret)
+	ch.ServerURL = strings.TrimSuffix(strings.TrimSpace(ch.ServerURL), "/")
+	ch.Token = strings.TrimSpace(ch.Token)
+	ch.Topic = strings.TrimSpace(ch.Topic)
+
+	switch ch.Type {
+	case models.ChannelSlack, models.ChannelFeishu:
+		if !isAbsoluteURL(ch.WebhookURL, true) {
+			return errors.New("webhook URL must be an absolute https URL")
+		}
+	case models.ChannelGotify:
+		if !isAbsoluteURL(ch.ServerURL, false) || ch.Token == "" {
+			return errors.New("server URL and application token are required")
+		}
+	case models.ChannelNtfy:
+		if !isAbsoluteURL(ch.ServerURL, false) || ch.Topic == "" || strings.Contains(ch.Topic, "/") {
+			return errors.New("server URL and a topic name are required")
+		}
+	case models.ChannelServerChan:
+		if ch.Token == "" || strings.ContainsAny(ch.Token, "/?#") {
+			return errors.New("a valid SendKey is required")
+		}
+	case models.ChannelWeComBot:
+		ch.Format = strings.TrimSpace(ch.Format)
+		if !isAbsoluteURL(ch.WebhookURL, true) {
+			return errors.New("webhook URL must be an absolute https URL")
+		}
+		if ch.Format == "" {
+			ch.Format = "markdown"
+		}
+		if ch.Format != "markdown" && ch.Format != "text" {
+			return errors.New("format must be markdown or text")
+		}
+	case models.ChannelBark:
+		if ch.ServerURL == "" {
+			ch.ServerURL = BarkDefaultServer
+		}
+		if !isAbsoluteURL(ch.ServerURL, false) || ch.Token == "" || strings.ContainsAny(ch.Token, "/?#") {
+			return errors.New("a device key and a valid server URL are required")
+		}
+	case models.ChannelWeComApp:
+		ch.CorpID = strings.TrimSpace(ch.CorpID)
+		ch.ToUser = strings.TrimSpace(ch.ToUser)
+		if ch.CorpID == "" || ch.AgentID <= 0 || ch.Secret == "" {
+			return errors.New("corp ID, agent ID and app secret are required")
+		}
+		if ch.ToUser == "" {
+			ch.ToUser = "@all"
+		}
+	default:
+		return errors.New("unsupported channel type")
+	}
+	return nil
+}
+
+// isAbsoluteURL reports whether s is an absolute http(s) URL; self-hosted servers may use plain http
+func isAbsoluteURL(s string, httpsOnly bool) bool {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return u.Scheme == "https" || (!httpsOnly && u.Scheme == "http")
+}
+
+// NewChannelNotifier returns the notifier for a group, recipient or per-message channel
+func NewChannelNotifier(ch models.Channel, client *http.Client) (ChannelNotifier, error) {
+	switch ch.Type {
+	case models.ChannelSlack:
+		return &SlackNotifier{WebhookURL: ch.WebhookURL, client: client}, nil
+	case models.ChannelFeishu:
+		return &FeishuNotifier{WebhookURL: ch.WebhookURL, Secret: ch.Secret, client: client}, nil
+	case models.ChannelGotify:
+		return &GotifyNotifier{ServerURL: ch.ServerURL, Token: ch.Token, client: client}, nil
+	case models.ChannelNtfy:
+		return &NtfyNotifier{ServerURL: ch.ServerURL, Topic: ch.Topic, Token: ch.Token, client: client}, nil
+	case models.ChannelServerChan:
+		return &ServerChanNotifier{endpoint: ServerChanURL(ch.Token), client: client}, nil
+	case models.ChannelWeComBot:
+		return &WeComBotNotifier{WebhookURL: ch.WebhookURL, Format: ch.Format, client: client}, nil
+	case models.ChannelBark:
+		return &BarkNotifier{ServerURL: ch.ServerURL, DeviceKey: ch.Token, client: client}, nil
+	case models.ChannelWeComApp:
+		return &WeComAppNotifier{CorpID: ch.CorpID, AgentID: ch.AgentID, Secret: ch.Secret, ToUser: ch.ToUser, baseURL: WeComAPIBase, client: client}, nil
+	default:
+		return nil, fmt.Errorf("unsupported channel type %q", ch.Type)
+	}
+}
+
+// SlackNotifier posts Block Kit messages to a Slack incoming webhook
+type SlackNotifier struct {
+	WebhookURL string
+	client     *http.Client
+}
+
+// Notify sends the notification as a header block followed by a section with the content
+func (s *SlackNotifier) Notify(n Notification) error {
+	blocks := []map[string]interface{}{
+		{
+			"type": "header",
+			"text": map[string]interface{}{"type": "plain_text", "text": TruncateRunes(n.Title, 150)},
+		},
+	}
+	if n.Content != "" {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "section",
+			"text": map[string]interface{}{"type": "mrkdwn", "text": TruncateRunes(n.Content, 3000)},
+		})
+	}
+	if n.URL != "" {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "actions",
+			"elements": []map[string]interface{}{{
+				"type": "button",
+				"text": map[string]interface{}{"type": "plain_text", "text": "Open"},
+				"url":  n.URL,
+			}},
+		})
+	}
+
+	// text is the fallback shown in push notifications
+	payload := map[string]interface{}{"text": n.Title, "blocks": blocks}
+	resp, err := postJSON(s.client, s.WebhookURL, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Slack answers a plain "ok" on success and a short error string otherwise
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack returned HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// FeishuNotifier posts interactive cards to a Feishu / Lark custom bot webhook
+type FeishuNotifier struct {
+	WebhookURL string
+	Secret     string
+	client     *http.Client
+}
+
+// feishuResponse is the body returned by the custom bot webhook
+type feishuResponse struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+// Notify sends the notification as a card with the title in the header
+func (f *FeishuNotifier) Notify(n Notification) error {
+	elements := []map[string]interface{}{}
+	if n.Content != "" {
+		elements = append(elements, map[string]interface{}{
+			"tag":  "div",
+			"text": map[string]interface{}{"tag": "lark_md", "content": n.Content},
+		})
+	}
+	if n.URL != "" {
+		elements = append(elements, map[string]interface{}{
+			"tag": "action",
+			"actions": []map[string]interface{}{{
+				"tag":  "button",
+				"text": map[string]interface{}{"tag": "plain_text", "content": "查看详情"},
+				"type": "primary",
+				"url":  n.URL,
+			}},
+		})
+	}
+
+	payload := map[string]interface{}{
+		"msg_type": "interactive",
+		"card": map[string]interface{}{
+			"header": map[string]interface{}{
+				"title":    map[string]interface{}{"tag": "plain_text", "content": n.Title},
+				"template": "blue",
+			},
+			"elements": elements,
+		},
+	}
+	if f.Secret != "" {
+		timestamp := time.Now().Unix()
+		payload["timestamp"] = strconv.FormatInt(timestamp, 10)
+		payload["sign"] = FeishuSign(f.Secret, timestamp)
+	}
+
+	resp, err := postJSON(f.client, f.WebhookURL, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result feishuResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&result); err != nil {
+		return fmt.Errorf("feishu returned HTTP %d", resp.StatusCode)
+	}
+	if result.Code != 0 {
+		return fmt.Errorf("feishu error %d: %s", result.Code, result.Msg)
+	}
+	return nil
+}
+
+// FeishuSign computes the custom bot signature: HMAC-SHA256 keyed with "timestamp\nsecret" over an empty message
+func FeishuSign(secret string, timestamp int64) string {
+	mac := hmac.New(sha256.New, []byte(strconv.FormatInt(timestamp, 10)+"\n"+secret))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// GotifyNotifier pushes messages to a Gotify server application
+type GotifyNotifier struct {
+	ServerURL string
+	Token     string
+	client    *http.Client
+}
+
+// Notify posts the message with the jump URL as a click action
+func (g *GotifyNotifier) Notify(n Notification) error {
+	payload := map[string]interface{}{
+		"title":    n.Title,
+		"message":  n.Content,
+		"priority": gotifyPriority(n.Priority),
+	}
+	if n.URL != "" {
+		payload["extras"] = map[string]interface{}{
+			"client::notification": map[string]interface{}{"click": map[string]string{"url": n.URL}},
+		}
+	}
+
+	resp, err := postJSON(g.client, g.ServerURL+"/message?token="+url.QueryEscape(g.Token), payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var result struct {
+			ErrorDescription string `json:"errorDescription"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&result)
+		return fmt.Errorf("gotify returned HTTP %d: %s", resp.StatusCode, result.ErrorDescription)
+	}
+	return nil
+}
+
+// gotifyPriority maps a send priority onto Gotify's 0-10 scale. The Android app keeps 1-3 silent,
+// plays a sound from 4 and pops up the notification from 8.
+func gotifyPriority(priority string) int {
+	switch priority {
+	case models.PriorityLow:
+		return 2
+	case models.PriorityHigh:
+		return 8
+	case models.PriorityUrgent:
+		return 10
+	}
+	return 5
+}
+
+// NtfyNotifier publishes messages to an ntfy topic
+type NtfyNotifier struct {
+	ServerURL string
+	Topic     string
+	Token     string
+	client    *http.Client
+}
+
+// Notify publishes using ntfy's JSON form, which carries UTF-8 titles without header encoding
+func (n *NtfyNotifier) Notify(msg Notification) error {
+	payload := map[string]interface{}{
+		"topic":    n.Topic,
+		"title":    msg.Title,
+		"message":  msg.Content,
+		"priority": ntfyPriority(msg.Priority),
+	}
+	if msg.URL != "" {
+		payload["click"] = msg.URL
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, n.ServerURL+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", withoutURL(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var result struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&result)
+		return fmt.Errorf("ntfy returned HTTP %d: %s", resp.StatusCode, result.Error)
+	}
+	return nil
+}
+
+// serverChan3Key matches Server酱³ SendKeys, which embed the numeric user ID: sctp{uid}t...
+var serverChan3Key = regexp.MustCompile(`^sctp(\d+)t`)
+
+// ServerChanURL returns the push endpoint for a SendKey, routing Server酱³ keys to their per-user host
+func ServerChanURL(sendKey string) string {
+	if m := serverChan3Key.FindStringSubmatch(sendKey); m != nil {
+		return "https://" + m[1] + ".push.ft07.com/send/" + sendKey + ".send"
+	}
+	return "https://sctapi.ftqq.com/" + sendKey + ".send"
+}
+
+// ServerChanNotifier pushes messages through Server酱 (ServerChan) to a personal WeChat / app
+type ServerChanNotifier struct {
+	endpoint string
+	client   *http.Client
+}
+
+// Notify posts the title and markdown body; ServerChan reports failures in the JSON code
+func (s *ServerChanNotifier) Notify(n Notification) error {
+	desp := n.Content
+	if n.URL != "" {
+		desp += "\n\n[查看详情](" + n.URL + ")"
+	}
+	form := url.Values{}
+	form.Set("title", TruncateRunes(n.Title, 32))
+	form.Set("desp", desp)
+
+	resp, err := s.client.PostForm(s.endpoint, form)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", withoutURL(err))
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&result); err != nil {
+		return fmt.Errorf("serverchan returned HTTP %d", resp.StatusCode)
+	}
+	if result.Code != 0 {
+		return fmt.Errorf("serverchan error %d: %s", result.Code, result.Message)
+	}
+	return nil
+}
+
+// ntfyPriority maps a send priority onto ntfy's 1-5 scale; 5 uses long vibration bursts and
+// can bypass Do Not Disturb on Android
+func ntfyPriority(priority string) int {
+	switch priority {
+	case models.PriorityLow:
+		return 2
+	case models.PriorityHigh:
+		return 4
+	case models.PriorityUrgent:
+		return 5
+	}
+	return 3
+}
+
+// newChannelHTTPClient returns the client used for outbound channel webhooks
+func newChannelHTTPClient() *http.Client {
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+// ErrPrivateChannelAddress is returned when a channel given with a single message points at a
+// loopback, private or link-local address
+var ErrPrivateChannelAddress = errors.New("channel address is not public")
+
+// newPublicChannelHTTPClient returns the client for channels given with a single message, which
+// anyone with a webhook token can set. It refuses to connect to addresses that aren't public, like
+// the cloud metadata endpoint or services on the server's network, checking the address actually
+// dialed so DNS can't point a public name at them. Environment proxies are ignored, since the
+// check would only see the proxy, and http.DefaultTransport isn't used, since it may be replaced.
+func newPublicChannelHTTPClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: dialPublicOnly}
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), private in practice
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return ErrPrivateChannelAddress
+	}
+	return nil
+}
+
+// isPublicIP reports whether ip is a globally routable unicast address
+func isPublicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+func postJSON(client *http.Client, url string, payload interface{}) (*http.Response, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", withoutURL(err))
+	}
+	return resp, nil
+}
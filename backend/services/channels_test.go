@@ -0,0 +1,251 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"wechat-notification/models"
+)
+
+func TestBuildNotification(t *testing.T) {
+	template := &models.MessageTemplate{Key: "deploy", Name: "部署通知"}
+
+	n := BuildNotification(template, map[string]string{
+		"first":    "部署完成",
+		"keyword2": "v1.2.0",
+		"keyword1": "api",
+		"remark":   "点击查看详情",
+	})
+	if n.Title != "部署完成" {
+		t.Errorf("title = %q, want first keyword", n.Title)
+	}
+	if want := "api\nv1.2.0\n点击查看详情"; n.Content != want {
+		t.Errorf("content = %q, want %q", n.Content, want)
+	}
+
+	if n := BuildNotification(template, map[string]string{"keyword1": "api"}); n.Title != "部署通知" {
+		t.Errorf("title without first keyword = %q, want template name", n.Title)
+	}
+}
+
+func TestSlackNotifier(t *testing.T) {
+	var payload map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payload)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	notifier, err := NewChannelNotifier(models.Channel{Type: models.ChannelSlack, WebhookURL: server.URL}, server.Client())
+	if err != nil {
+		t.Fatalf("NewChannelNotifier error: %v", err)
+	}
+	if err := notifier.Notify(Notification{Title: "Deploy finished", Content: "api v1.2.0"}); err != nil {
+		t.Fatalf("Notify error: %v", err)
+	}
+
+	if payload["text"] != "Deploy finished" {
+		t.Errorf("fallback text = %v", payload["text"])
+	}
+	blocks, _ := payload["blocks"].([]interface{})
+	if len(blocks) != 2 {
+		t.Fatalf("expected header and section blocks, got %v", payload["blocks"])
+	}
+	if header := blocks[0].(map[string]interface{}); header["type"] != "header" {
+		t.Errorf("first block type = %v, want header", header["type"])
+	}
+
+	if _, err := NewChannelNotifier(models.Channel{Type: "pager"}, server.Client()); err == nil {
+		t.Error("expected error for unsupported channel type")
+	}
+}
+
+func TestFeishuNotifier(t *testing.T) {
+	if got, want := FeishuSign("secret", 1700000000), "fiWS2+gh28DOydAv7hzONH/mDn9+b1Y4Y5ivXWXy8vA="; got != want {
+		t.Errorf("FeishuSign = %q, want %q", got, want)
+	}
+
+	var payload map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payload)
+		w.Write([]byte(`{"code":0,"msg":"success"}`))
+	}))
+	defer server.Close()
+
+	notifier, _ := NewChannelNotifier(models.Channel{Type: models.ChannelFeishu, WebhookURL: server.URL, Secret: "secret"}, server.Client())
+	if err := notifier.Notify(Notification{Title: "部署完成", Content: "api v1.2.0"}); err != nil {
+		t.Fatalf("Notify error: %v", err)
+	}
+	if payload["msg_type"] != "interactive" || payload["sign"] == nil || payload["timestamp"] == nil {
+		t.Errorf("unexpected payload: %v", payload)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"code":19021,"msg":"sign match fail or timestamp is not within one hour from current time"}`))
+	}))
+	defer failing.Close()
+
+	notifier, _ = NewChannelNotifier(models.Channel{Type: models.ChannelFeishu, WebhookURL: failing.URL}, failing.Client())
+	if err := notifier.Notify(Notification{Title: "x"}); err == nil {
+		t.Error("expected error when feishu returns a non-zero code")
+	}
+}
+
+func TestGotifyAndNtfyNotifiers(t *testing.T) {
+	var path, query, auth string
+	var payload map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, query, auth = r.URL.Path, r.URL.RawQuery, r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&payload)
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	gotify, _ := NewChannelNotifier(models.Channel{Type: models.ChannelGotify, ServerURL: server.URL, Token: "app-token"}, server.Client())
+	if err := gotify.Notify(Notification{Title: "备份完成", Content: "nas", URL: "https://example.com"}); err != nil {
+		t.Fatalf("gotify Notify error: %v", err)
+	}
+	if path != "/message" || query != "token=app-token" || payload["title"] != "备份完成" || payload["extras"] == nil {
+		t.Errorf("unexpected gotify request: %s?%s %v", path, query, payload)
+	}
+
+	ntfy, _ := NewChannelNotifier(models.Channel{Type: models.ChannelNtfy, ServerURL: server.URL, Topic: "alerts", Token: "tk"}, server.Client())
+	if err := ntfy.Notify(Notification{Title: "备份完成", Content: "nas"}); err != nil {
+		t.Fatalf("ntfy Notify error: %v", err)
+	}
+	if path != "/" || auth != "Bearer tk" || payload["topic"] != "alerts" || payload["message"] != "nas" || payload["priority"] != 3.0 {
+		t.Errorf("unexpected ntfy request: %s auth=%q %v", path, auth, payload)
+	}
+}
+
+func TestPriorityHints(t *testing.T) {
+	var payload map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payload)
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	gotify, _ := NewChannelNotifier(models.Channel{Type: models.ChannelGotify, ServerURL: server.URL, Token: "t"}, server.Client())
+	ntfy, _ := NewChannelNotifier(models.Channel{Type: models.ChannelNtfy, ServerURL: server.URL, Topic: "alerts"}, server.Client())
+	cases := []struct {
+		priority             string
+		wantGotify, wantNtfy float64
+	}{
+		{"", 5, 3},
+		{models.PriorityLow, 2, 2},
+		{models.PriorityHigh, 8, 4},
+		{models.PriorityUrgent, 10, 5},
+	}
+	for _, tc := range cases {
+		gotify.Notify(Notification{Title: "x", Priority: tc.priority})
+		if payload["priority"] != tc.wantGotify {
+			t.Errorf("gotify priority for %q = %v, want %v", tc.priority, payload["priority"], tc.wantGotify)
+		}
+		ntfy.Notify(Notification{Title: "x", Priority: tc.priority})
+		if payload["priority"] != tc.wantNtfy {
+			t.Errorf("ntfy priority for %q = %v, want %v", tc.priority, payload["priority"], tc.wantNtfy)
+		}
+	}
+}
+
+func TestServerChanNotifier(t *testing.T) {
+	if got := ServerChanURL("SCT123abc"); got != "https://sctapi.ftqq.com/SCT123abc.send" {
+		t.Errorf("Turbo URL = %s", got)
+	}
+	if got := ServerChanURL("sctp42tXYZ"); got != "https://42.push.ft07.com/send/sctp42tXYZ.send" {
+		t.Errorf("Server酱³ URL = %s", got)
+	}
+
+	var title, desp string
+	code := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		title, desp = r.PostForm.Get("title"), r.PostForm.Get("desp")
+		w.Write([]byte(`{"code":` + strconv.Itoa(code) + `,"message":"bad key"}`))
+	}))
+	defer server.Close()
+
+	notifier := &ServerChanNotifier{endpoint: server.URL, client: server.Client()}
+	if err := notifier.Notify(Notification{Title: "磁盘告警", Content: "nas 95%", URL: "https://example.com/d"}); err != nil {
+		t.Fatalf("Notify error: %v", err)
+	}
+	if title != "磁盘告警" || !strings.HasPrefix(desp, "nas 95%") || !strings.Contains(desp, "https://example.com/d") {
+		t.Errorf("unexpected form: title=%q desp=%q", title, desp)
+	}
+
+	code = 40001
+	if err := notifier.Notify(Notification{Title: "x"}); err == nil {
+		t.Error("expected error for non-zero code")
+	}
+}
+
+func TestValidateChannel(t *testing.T) {
+	cases := []struct {
+		ch    models.Channel
+		valid bool
+	}{
+		{models.Channel{Type: models.ChannelSlack, WebhookURL: "https://hooks.slack.com/services/x"}, true},
+		{models.Channel{Type: models.ChannelSlack, WebhookURL: "http://hooks.slack.com/services/x"}, false},
+		{models.Channel{Type: models.ChannelGotify, ServerURL: "http://gotify.lan", Token: "t"}, true},
+		{models.Channel{Type: models.ChannelGotify, ServerURL: "http://gotify.lan"}, false},
+		{models.Channel{Type: models.ChannelNtfy, ServerURL: "https://ntfy.sh/", Topic: "alerts"}, true},
+		{models.Channel{Type: models.ChannelNtfy, ServerURL: "https://ntfy.sh", Topic: "a/b"}, false},
+		{models.Channel{Type: models.ChannelServerChan, Token: " SCT123abc "}, true},
+		{models.Channel{Type: models.ChannelServerChan}, false},
+		{models.Channel{Type: "email"}, false},
+	}
+	for _, tc := range cases {
+		ch := tc.ch
+		if err := ValidateChannel(&ch); (err == nil) != tc.valid {
+			t.Errorf("ValidateChannel(%+v) error = %v, want valid %v", tc.ch, err, tc.valid)
+		}
+	}
+}
+
+func TestPublicChannelClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("private address was reached")
+	}))
+	defer server.Close()
+
+	notifier, _ := NewChannelNotifier(models.Channel{Type: models.ChannelNtfy, ServerURL: server.URL, Topic: "x"}, newPublicChannelHTTPClient())
+	if err := notifier.Notify(Notification{Title: "hi"}); !errors.Is(err, ErrPrivateChannelAddress) {
+		t.Errorf("Notify error = %v, want ErrPrivateChannelAddress", err)
+	}
+
+	for ip, public := range map[string]bool{
+		"8.8.8.8": true, "2606:4700::1111": true,
+		"127.0.0.1": false, "10.1.2.3": false, "192.168.1.10": false, "172.16.0.1": false, "169.254.169.254": false,
+		"100.64.0.1": false, "0.0.0.0": false, "::1": false, "fe80::1": false, "fd00::1": false, "::ffff:127.0.0.1": false,
+	} {
+		if got := isPublicIP(net.ParseIP(ip)); got != public {
+			t.Errorf("isPublicIP(%s) = %v, want %v", ip, got, public)
+		}
+	}
+}
+
+func TestChannelErrorsHideKeys(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serverURL := server.URL
+	server.Close()
+
+	client := newChannelHTTPClient()
+	for _, ch := range []models.Channel{
+		{Type: models.ChannelGotify, ServerURL: serverURL, Token: "gotify-secret-token"},
+		{Type: models.ChannelBark, ServerURL: serverURL, Token: "bark-secret-token"},
+		{Type: models.ChannelSlack, WebhookURL: serverURL + "/services/slack-secret-token"},
+	} {
+		notifier, _ := NewChannelNotifier(ch, client)
+		err := notifier.Notify(Notification{Title: "hi"})
+		if err == nil || strings.Contains(err.Error(), "secret-token") {
+			t.Errorf("%s error should hide the key: %v", ch.Type, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"wechat-notification/config"
+	"wechat-notification/models"
+)
+
+// Config value sources, in precedence order
+const (
+	ConfigSourceDatabase    = "database"
+	ConfigSourceEnvironment = "environment"
+	ConfigSourceUnset       = "unset"
+)
+
+// ConfigFieldReport describes where an effective configuration value came from
+// and whether the environment and database disagree about it
+type ConfigFieldReport struct {
+	Field     string `json:"field"`
+	Effective string `json:"effective"`
+	Source    string `json:"source"`
+	EnvValue  string `json:"envValue"`
+	DBValue   string `json:"dbValue"`
+	Drift     bool   `json:"drift"` // Both sources set and different; the lower-precedence value is ignored
+}
+
+// WeChatConfigPrecedence documents how ResolveWeChatConfig picks values
+const WeChatConfigPrecedence = "Settings saved in the database take precedence over environment variables. " +
+	"appId and appSecret are resolved together as a pair: the database pair is used when a database appId is set, " +
+	"otherwise the environment pair. templateId falls back to the environment when the database value is empty."
+
+// ResolveWeChatConfig applies the WeChatConfigPrecedence policy and reports the source of every field.
+// Secrets are masked in the report.
+func ResolveWeChatConfig(env config.WeChatConfig, db *models.WeChatConfig) (models.WeChatConfig, []ConfigFieldReport) {
+	if db == nil {
+		db = &models.WeChatConfig{}
+	}
+
+	var effective models.WeChatConfig
+	credentialSource := ConfigSourceUnset
+	switch {
+	case db.AppID != "":
+		effective.AppID, effective.AppSecret = db.AppID, db.AppSecret
+		credentialSource = ConfigSourceDatabase
+	case env.AppID != "":
+		effective.AppID, effective.AppSecret = env.AppID, env.AppSecret
+		credentialSource = ConfigSourceEnvironment
+	}
+
+	templateSource := ConfigSourceUnset
+	switch {
+	case db.TemplateID != "":
+		effective.TemplateID = db.TemplateID
+		templateSource = ConfigSourceDatabase
+	case env.TemplateID != "":
+		effective.TemplateID = env.TemplateID
+		templateSource = ConfigSourceEnvironment
+	}
+
+	secretSource := credentialSource
+	if effective.AppSecret == "" {
+		secretSource = ConfigSourceUnset
+	}
+
+	report := []ConfigFieldReport{
+		fieldReport("appId", effective.AppID, credentialSource, env.AppID, db.AppID, false),
+		fieldReport("appSecret", effective.AppSecret, secretSource, env.AppSecret, db.AppSecret, true),
+		fieldReport("templateId", effective.TemplateID, templateSource, env.TemplateID, db.TemplateID, false),
+	}
+	return effective, report
+}
+
+func fieldReport(field, effective, source, envValue, dbValue string, secret bool) ConfigFieldReport {
+	report := ConfigFieldReport{
+		Field:     field,
+		Effective: effective,
+		Source:    source,
+		EnvValue:  envValue,
+		DBValue:   dbValue,
+		Drift:     envValue != "" && dbValue != "" && envValue != dbValue,
+	}
+	if secret {
+		report.Effective = MaskSecret(report.Effective)
+		report.EnvValue = MaskSecret(report.EnvValue)
+		report.DBValue = MaskSecret(report.DBValue)
+	}
+	return report
+}
+
+// MaskSecret hides a secret behind a fixed mask, keeping it empty when unset. Handlers treat the
+// mask sent back on save as "unchanged".
+func MaskSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "******"
+}
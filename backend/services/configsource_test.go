@@ -0,0 +1,45 @@
+package services
+
+import (
+	"testing"
+
+	"wechat-notification/config"
+	"wechat-notification/models"
+)
+
+func TestResolveWeChatConfig(t *testing.T) {
+	env := config.WeChatConfig{AppID: "wx-env", AppSecret: "env-secret-1234", TemplateID: "tpl-env"}
+
+	// Database credentials win as a pair; an empty database template falls back to the environment
+	effective, report := ResolveWeChatConfig(env, &models.WeChatConfig{AppID: "wx-db", AppSecret: "db-secret-5678"})
+	if effective.AppID != "wx-db" || effective.AppSecret != "db-secret-5678" || effective.TemplateID != "tpl-env" {
+		t.Errorf("unexpected effective config: %+v", effective)
+	}
+	want := map[string]struct {
+		source string
+		drift  bool
+	}{
+		"appId":      {ConfigSourceDatabase, true},
+		"appSecret":  {ConfigSourceDatabase, true},
+		"templateId": {ConfigSourceEnvironment, false},
+	}
+	for _, f := range report {
+		if w := want[f.Field]; f.Source != w.source || f.Drift != w.drift {
+			t.Errorf("%s: source %s drift %v, want %s drift %v", f.Field, f.Source, f.Drift, w.source, w.drift)
+		}
+		if f.Field == "appSecret" && (f.Effective != "******" || f.EnvValue != "******" || f.DBValue != "******") {
+			t.Errorf("appSecret not masked: %+v", f)
+		}
+	}
+
+	// Nothing configured anywhere
+	effective, report = ResolveWeChatConfig(config.WeChatConfig{}, nil)
+	if effective != (models.WeChatConfig{}) {
+		t.Errorf("expected empty config, got %+v", effective)
+	}
+	for _, f := range report {
+		if f.Source != ConfigSourceUnset || f.Drift {
+			t.Errorf("%s: source %s drift %v, want unset without drift", f.Field, f.Source, f.Drift)
+		}
+	}
+}
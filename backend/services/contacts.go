@@ -0,0 +1,198 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"wechat-notification/models"
+)
+
+// TelegramAPIBase is the base URL of the Telegram Bot API
+const TelegramAPIBase = "https://api.telegram.org"
+
+// ErrInvalidPreference is returned when a recipient preference names an unknown contact channel
+var ErrInvalidPreference = errors.New("preferences may only contain wechat, email and telegram")
+
+// SMTPSettings holds the outgoing mail server used for email contacts
+type SMTPSettings struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// ContactSettings holds the server-side credentials for delivering to recipient contact details
+type ContactSettings struct {
+	SMTP             SMTPSettings
+	TelegramBotToken string
+}
+
+// ValidatePreferences checks a recipient's contact preferences against the contact details they have,
+// trimming and de-duplicating the list in place
+func ValidatePreferences(r *models.Recipient) error {
+	r.Email = strings.TrimSpace(r.Email)
+	r.TelegramChatID = strings.TrimSpace(r.TelegramChatID)
+	if r.Email != "" {
+		addr, err := mail.ParseAddress(r.Email)
+		if err != nil || addr.Name != "" {
+			return errors.New("email is not a valid address")
+		}
+	}
+	if r.TelegramChatID != "" {
+		if _, err := strconv.ParseInt(strings.TrimPrefix(r.TelegramChatID, "-"), 10, 64); err != nil && !strings.HasPrefix(r.TelegramChatID, "@") {
+			return errors.New("telegramChatId must be a numeric chat ID or an @channel username")
+		}
+	}
+
+	seen := make(map[string]bool, len(r.Preferences))
+	preferences := make([]string, 0, len(r.Preferences))
+	for _, p := range r.Preferences {
+		p = strings.TrimSpace(p)
+		switch p {
+		case models.ContactWeChat:
+		case models.ContactEmail:
+			if r.Email == "" {
+				return errors.New("email preference requires an email address")
+			}
+		case models.ContactTelegram:
+			if r.TelegramChatID == "" {
+				return errors.New("telegram preference requires a telegramChatId")
+			}
+		default:
+			return ErrInvalidPreference
+		}
+		if !seen[p] {
+			seen[p] = true
+			preferences = append(preferences, p)
+		}
+	}
+	r.Preferences = preferences
+	return nil
+}
+
+// Prefers reports whether a recipient wants messages through the given contact channel.
+// Recipients without preferences receive WeChat only.
+func Prefers(r models.Recipient, contact string) bool {
+	if len(r.Preferences) == 0 {
+		return contact == models.ContactWeChat
+	}
+	return containsString(r.Preferences, contact)
+}
+
+// contactNotifier returns the notifier delivering to a recipient's email or Telegram contact
+func (s *Sender) contactNotifier(contact string, r models.Recipient) (ChannelNotifier, error) {
+	switch contact {
+	case models.ContactEmail:
+		settings := s.contacts.SMTP
+		if settings.Host == "" {
+			return nil, errors.New("SMTP is not configured")
+		}
+		if settings.From == "" {
+			settings.From = settings.Username
+		}
+		return &EmailNotifier{settings: settings, to: r.Email}, nil
+	case models.ContactTelegram:
+		if s.contacts.TelegramBotToken == "" {
+			return nil, errors.New("telegram bot token is not configured")
+		}
+		return &TelegramNotifier{baseURL: TelegramAPIBase, botToken: s.contacts.TelegramBotToken, chatID: r.TelegramChatID, client: s.channelClient}, nil
+	}
+	return nil, fmt.Errorf("unsupported contact %q", contact)
+}
+
+// EmailNotifier sends a notification as a plain-text email through SMTP
+type EmailNotifier struct {
+	settings SMTPSettings
+	to       string
+}
+
+// Notify sends the email, authenticating when a username is configured
+func (e *EmailNotifier) Notify(n Notification) error {
+	var auth smtp.Auth
+	if e.settings.Username != "" {
+		auth = smtp.PlainAuth("", e.settings.Username, e.settings.Password, e.settings.Host)
+	}
+	addr := net.JoinHostPort(e.settings.Host, strconv.Itoa(e.settings.Port))
+	if err := smtp.SendMail(addr, auth, e.settings.From, []string{e.to}, BuildEmail(e.settings.From, e.to, n, time.Now())); err != nil {
+		return fmt.Errorf("smtp: %w", err)
+	}
+	return nil
+}
+
+// BuildEmail renders a notification as a UTF-8 plain-text message with encoded headers
+func BuildEmail(from, to string, n Notification, now time.Time) []byte {
+	body := n.Content
+	if n.URL != "" {
+		body += "\n\n" + n.URL
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("From: " + from + "\r\n")
+	buf.WriteString("To: " + to + "\r\n")
+	buf.WriteString("Subject: " + mime.BEncoding.Encode("UTF-8", n.Title) + "\r\n")
+	buf.WriteString("Date: " + now.Format(time.RFC1123Z) + "\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(body))
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+	return buf.Bytes()
+}
+
+// TelegramNotifier sends a notification to a chat through a Telegram bot
+type TelegramNotifier struct {
+	baseURL  string
+	botToken string
+	chatID   string
+	client   *http.Client
+}
+
+// Notify calls sendMessage; Telegram reports failures with ok=false and a description
+func (t *TelegramNotifier) Notify(n Notification) error {
+	text := n.Title
+	if n.Content != "" {
+		text += "\n\n" + n.Content
+	}
+	if n.URL != "" {
+		text += "\n\n" + n.URL
+	}
+
+	resp, err := postJSON(t.client, t.baseURL+"/bot"+t.botToken+"/sendMessage", map[string]interface{}{
+		"chat_id": t.chatID,
+		"text":    text,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&result); err != nil {
+		return fmt.Errorf("telegram returned HTTP %d", resp.StatusCode)
+	}
+	if !result.OK {
+		return fmt.Errorf("telegram error: %s", result.Description)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+)
+
+func TestValidatePreferences(t *testing.T) {
+	r := &models.Recipient{Email: " ops@example.com ", TelegramChatID: "-100123", Preferences: []string{" email", "telegram", "email"}}
+	if err := ValidatePreferences(r); err != nil {
+		t.Fatalf("ValidatePreferences error: %v", err)
+	}
+	if r.Email != "ops@example.com" || strings.Join(r.Preferences, ",") != "email,telegram" {
+		t.Errorf("not normalized: %+v", r)
+	}
+
+	invalid := []models.Recipient{
+		{Preferences: []string{"email"}},
+		{Preferences: []string{"telegram"}},
+		{Preferences: []string{"sms"}},
+		{Email: "Ops <ops@example.com>"},
+		{TelegramChatID: "my chat"},
+	}
+	for _, r := range invalid {
+		r := r
+		if err := ValidatePreferences(&r); err == nil {
+			t.Errorf("expected error for %+v", r)
+		}
+	}
+}
+
+func TestPrefers(t *testing.T) {
+	if !Prefers(models.Recipient{}, models.ContactWeChat) || Prefers(models.Recipient{}, models.ContactEmail) {
+		t.Error("recipients without preferences should receive WeChat only")
+	}
+	r := models.Recipient{Preferences: []string{models.ContactTelegram}}
+	if Prefers(r, models.ContactWeChat) || !Prefers(r, models.ContactTelegram) {
+		t.Error("preferences should replace the WeChat default")
+	}
+	if got := wechatSkipReason(r, time.Now()); got == "" {
+		t.Error("recipient without wechat preference should be skipped for WeChat")
+	}
+}
+
+func TestBuildEmail(t *testing.T) {
+	msg := string(BuildEmail("notify@example.com", "ops@example.com", Notification{Title: "磁盘告警", Content: "nas 95%", URL: "https://example.com/d"}, time.Now()))
+	if !strings.Contains(msg, "Subject: =?UTF-8?b?") || !strings.Contains(msg, "To: ops@example.com\r\n") {
+		t.Errorf("unexpected headers:\n%s", msg)
+	}
+	if !strings.Contains(msg, "Content-Transfer-Encoding: base64\r\n\r\n") {
+		t.Errorf("body should be base64 encoded:\n%s", msg)
+	}
+}
+
+func TestTelegramNotifier(t *testing.T) {
+	var path string
+	var payload map[string]interface{}
+	ok := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&payload)
+		if ok {
+			w.Write([]byte(`{"ok":true}`))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"ok":false,"description":"Bad Request: chat not found"}`))
+	}))
+	defer server.Close()
+
+	notifier := &TelegramNotifier{baseURL: server.URL, botToken: "123:abc", chatID: "42", client: server.Client()}
+	if err := notifier.Notify(Notification{Title: "磁盘告警", Content: "nas 95%"}); err != nil {
+		t.Fatalf("Notify error: %v", err)
+	}
+	if path != "/bot123:abc/sendMessage" || payload["chat_id"] != "42" || payload["text"] != "磁盘告警\n\nnas 95%" {
+		t.Errorf("unexpected request: %s %v", path, payload)
+	}
+
+	ok = false
+	if err := notifier.Notify(Notification{Title: "x"}); err == nil || !strings.Contains(err.Error(), "chat not found") {
+		t.Errorf("expected telegram error, got %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// watchtowerTitlePrefix starts the title Watchtower gives its notifications, followed by the hostname
+const watchtowerTitlePrefix = "Watchtower updates on "
+
+// DiunEvent is the webhook body Diun posts for each new or updated image
+type DiunEvent struct {
+	DiunVersion string            `json:"diun_version"`
+	Hostname    string            `json:"hostname"`
+	Status      string            `json:"status"` // new or update
+	Provider    string            `json:"provider"`
+	Image       string            `json:"image"`
+	HubLink     string            `json:"hub_link"`
+	Digest      string            `json:"digest"`
+	Platform    string            `json:"platform"`
+	Metadata    map[string]string `json:"metadata"`
+}
+
+// Line describes the event on one line, naming the containers running the image when Diun's
+// docker provider reports them
+func (e DiunEvent) Line() string {
+	verb := "镜像更新"
+	if e.Status == "new" {
+		verb = "新镜像"
+	}
+	line := verb + " " + e.Image
+	if names := e.Metadata["ctn_names"]; names != "" {
+		line += "（容器 " + names + "）"
+	}
+	return line
+}
+
+// ParseWatchtower reads a Watchtower notification sent through shoutrrr's generic webhook, either
+// with template=json ({"title","message"}) or as the plain message. It returns the host from the
+// title, or "" when the title doesn't name one, and the message's non-empty lines.
+func ParseWatchtower(body []byte) (host string, lines []string) {
+	var payload struct {
+		Title   string `json:"title"`
+		Message string `json:"message"`
+	}
+	message := string(body)
+	if json.Unmarshal(body, &payload) == nil && payload.Message != "" {
+		message = payload.Message
+		host = strings.TrimSpace(strings.TrimPrefix(payload.Title, watchtowerTitlePrefix))
+		if !strings.HasPrefix(payload.Title, watchtowerTitlePrefix) {
+			host = ""
+		}
+	}
+	for _, line := range strings.Split(message, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return host, lines
+}
+
+// ContainerUpdateParts builds the request parts of a host's container updates: a title counting
+// them, the update lines as content, the host and the count
+func ContainerUpdateParts(host string, lines []string) map[string]string {
+	return map[string]string{
+		"title":   fmt.Sprintf("%s 有 %d 条容器更新", host, len(lines)),
+		"content": strings.Join(lines, "\n"),
+		"host":    host,
+		"count":   fmt.Sprint(len(lines)),
+	}
+}
+
+// UpdateGrouper collects container update lines per key and hands each key's lines over together
+// once the window opened by its first update has passed, so a burst of image updates on a host
+// becomes one message
+type UpdateGrouper struct {
+	mu     sync.Mutex
+	groups map[string][]string
+}
+
+// NewUpdateGrouper creates an empty update grouper
+func NewUpdateGrouper() *UpdateGrouper {
+	return &UpdateGrouper{groups: map[string][]string{}}
+}
+
+// Add queues lines under key. The first lines of a key open its window; when it closes, flush is
+// called with every line queued under the key meanwhile.
+func (g *UpdateGrouper) Add(key string, lines []string, window time.Duration, flush func(lines []string)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	pending, open := g.groups[key]
+	g.groups[key] = append(pending, lines...)
+	if open {
+		return
+	}
+	time.AfterFunc(window, func() {
+		g.mu.Lock()
+		all := g.groups[key]
+		delete(g.groups, key)
+		g.mu.Unlock()
+		flush(all)
+	})
+}
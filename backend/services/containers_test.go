@@ -0,0 +1,48 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWatchtower(t *testing.T) {
+	host, lines := ParseWatchtower([]byte(`{"title":"Watchtower updates on nas01","message":"Found new image for app\n\nStopping /app\n"}`))
+	if host != "nas01" || len(lines) != 2 || lines[1] != "Stopping /app" {
+		t.Errorf("unexpected JSON parse: %q %q", host, lines)
+	}
+	host, lines = ParseWatchtower([]byte("Found new image for db"))
+	if host != "" || len(lines) != 1 {
+		t.Errorf("unexpected plain parse: %q %q", host, lines)
+	}
+
+	event := DiunEvent{Status: "update", Image: "docker.io/library/nginx:latest", Metadata: map[string]string{"ctn_names": "web"}}
+	if got := event.Line(); got != "镜像更新 docker.io/library/nginx:latest（容器 web）" {
+		t.Errorf("unexpected Diun line: %q", got)
+	}
+	if parts := ContainerUpdateParts("nas01", lines); parts["count"] != "1" || parts["title"] != "nas01 有 1 条容器更新" {
+		t.Errorf("unexpected parts: %v", parts)
+	}
+}
+
+func TestUpdateGrouper(t *testing.T) {
+	grouper := NewUpdateGrouper()
+	flushed := make(chan []string, 2)
+	flush := func(lines []string) { flushed <- lines }
+
+	grouper.Add("diun/a", []string{"one"}, 50*time.Millisecond, flush)
+	grouper.Add("diun/a", []string{"two"}, 50*time.Millisecond, flush)
+	grouper.Add("diun/b", []string{"other"}, 50*time.Millisecond, flush)
+
+	got := map[string]int{}
+	for i := 0; i < 2; i++ {
+		select {
+		case lines := <-flushed:
+			got[lines[0]] = len(lines)
+		case <-time.After(2 * time.Second):
+			t.Fatal("groups were not flushed")
+		}
+	}
+	if got["one"] != 2 || got["other"] != 1 {
+		t.Errorf("unexpected groups: %v", got)
+	}
+}
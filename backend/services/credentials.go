@@ -0,0 +1,63 @@
+package services
+
+import (
+	"errors"
+	"strings"
+)
+
+// Credential check steps, reported as the stage that failed
+const (
+	CredentialStageToken     = "token"
+	CredentialStageTemplates = "templates"
+)
+
+// CredentialCheck is the outcome of trying WeChat credentials without saving them
+type CredentialCheck struct {
+	OK        bool   `json:"ok"`
+	Templates *int   `json:"templates,omitempty"` // Number of templates on the account, when listed
+	Stage     string `json:"stage,omitempty"`     // Step that failed: token or templates
+	ErrCode   int    `json:"errcode,omitempty"`   // WeChat errcode, verbatim
+	ErrMsg    string `json:"errmsg,omitempty"`    // WeChat errmsg, verbatim
+	Error     string `json:"error,omitempty"`
+}
+
+// VerifyCredentials fetches an access token with the given credentials and, if listTemplates is set,
+// lists the account's templates with it. A fresh token is always requested, so the cached token of
+// the current configuration doesn't hide a wrong AppSecret.
+func (s *WeChatService) VerifyCredentials(appID, appSecret string, listTemplates bool) CredentialCheck {
+	candidate := &WeChatService{
+		tokenManager: s.tokenManager.clone(appID, appSecret),
+		httpClient:   s.httpClient,
+	}
+	if _, err := candidate.tokenManager.GetAccessToken(); err != nil {
+		return failedCredentialCheck(CredentialStageToken, err, appSecret)
+	}
+	check := CredentialCheck{OK: true}
+	if listTemplates {
+		templates, err := candidate.GetPrivateTemplates()
+		if err != nil {
+			return failedCredentialCheck(CredentialStageTemplates, err, appSecret)
+		}
+		count := len(templates)
+		check.Templates = &count
+	}
+	return check
+}
+
+// failedCredentialCheck reports err without the AppSecret being tried, which may be the saved one
+// when the caller sent it masked, or an access token. WeChat's own errors are reported as they are;
+// other errors lose any URL they carry.
+func failedCredentialCheck(stage string, err error, appSecret string) CredentialCheck {
+	check := CredentialCheck{Stage: stage}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		check.ErrCode, check.ErrMsg = apiErr.ErrCode, apiErr.ErrMsg
+		check.Error = apiErr.Error()
+		return check
+	}
+	check.Error = withoutURLs(err.Error())
+	if appSecret != "" {
+		check.Error = strings.ReplaceAll(check.Error, appSecret, redacted)
+	}
+	return check
+}
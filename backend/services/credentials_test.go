@@ -0,0 +1,52 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyCredentials(t *testing.T) {
+	client := &MockHTTPClient{GetFunc: func(url string) (*http.Response, error) {
+		body := `{"errcode":40125,"errmsg":"invalid appsecret"}`
+		switch {
+		case strings.HasPrefix(url, WeChatPrivateTemplatesURL):
+			body = `{"template_list":[{"template_id":"a"},{"template_id":"b"}]}`
+		case strings.Contains(url, "secret=good"):
+			body = `{"access_token":"fresh","expires_in":7200}`
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}}
+	tokens := NewTokenManagerWithClient("app", "bad", client)
+	tokens.SetToken("cached", time.Hour)
+	svc := NewWeChatServiceWithClient(tokens, "tpl", client)
+
+	// The cached token of the current config must not mask a wrong secret
+	check := svc.VerifyCredentials("app", "bad", false)
+	if check.OK || check.Stage != CredentialStageToken || check.ErrCode != 40125 || check.ErrMsg != "invalid appsecret" {
+		t.Errorf("bad secret check = %+v", check)
+	}
+
+	check = svc.VerifyCredentials("app", "good", true)
+	if !check.OK || check.Templates == nil || *check.Templates != 2 {
+		t.Errorf("good secret check = %+v", check)
+	}
+	if token, _ := tokens.GetAccessToken(); token != "cached" {
+		t.Errorf("verification replaced the current token: %q", token)
+	}
+}
+
+func TestVerifyCredentialsHidesSecret(t *testing.T) {
+	client := &MockHTTPClient{GetFunc: func(u string) (*http.Response, error) {
+		return nil, errors.New(`Get "` + u + `": dial tcp: i/o timeout (secret topsecret)`)
+	}}
+	svc := NewWeChatServiceWithClient(NewTokenManagerWithClient("app", "topsecret", client), "tpl", client)
+
+	check := svc.VerifyCredentials("app", "topsecret", false)
+	if check.OK || check.Stage != CredentialStageToken || strings.Contains(check.Error, "topsecret") || !strings.Contains(check.Error, "i/o timeout") {
+		t.Errorf("check = %+v", check)
+	}
+}
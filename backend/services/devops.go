@@ -0,0 +1,490 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GitHubSignatureHeader carries the HMAC-SHA256 of a GitHub webhook delivery, keyed by the
+// webhook's secret
+const GitHubSignatureHeader = "X-Hub-Signature-256"
+
+// maxPushCommits is how many commits of a push are listed before the rest are counted
+const maxPushCommits = 5
+
+// VerifyGitHubSignature checks the X-Hub-Signature-256 header of a GitHub delivery
+func VerifyGitHubSignature(secret, signature string, body []byte) bool {
+	return signatureMatches(secret, signature, body)
+}
+
+type gitHubUser struct {
+	Login string `json:"login"`
+}
+
+// gitCommit is a commit of a push, the same in GitHub and GitLab payloads
+type gitCommit struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+	Author  struct {
+		Name string `json:"name"`
+	} `json:"author"`
+}
+
+// GitHubEvent holds the fields of the GitHub webhook events we notify about: push, release,
+// workflow_run and issues. The event name comes from the X-GitHub-Event header.
+type GitHubEvent struct {
+	Action     string `json:"action"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		HTMLURL  string `json:"html_url"`
+	} `json:"repository"`
+	Sender gitHubUser `json:"sender"`
+
+	// push
+	Ref     string      `json:"ref"`
+	Compare string      `json:"compare"`
+	Created bool        `json:"created"`
+	Deleted bool        `json:"deleted"`
+	Commits []gitCommit `json:"commits"`
+
+	Release *struct {
+		TagName    string `json:"tag_name"`
+		Name       string `json:"name"`
+		Body       string `json:"body"`
+		HTMLURL    string `json:"html_url"`
+		Prerelease bool   `json:"prerelease"`
+	} `json:"release"`
+
+	WorkflowRun *struct {
+		Name       string `json:"name"`
+		RunNumber  int    `json:"run_number"`
+		HeadBranch string `json:"head_branch"`
+		HeadSHA    string `json:"head_sha"`
+		Event      string `json:"event"`
+		Conclusion string `json:"conclusion"`
+		HTMLURL    string `json:"html_url"`
+	} `json:"workflow_run"`
+
+	Issue *struct {
+		Number  int    `json:"number"`
+		Title   string `json:"title"`
+		HTMLURL string `json:"html_url"`
+	} `json:"issue"`
+}
+
+// gitHubConclusions names the conclusions of a completed workflow run
+var gitHubConclusions = map[string]string{
+	"success":         "成功",
+	"failure":         "失败",
+	"cancelled":       "已取消",
+	"timed_out":       "超时",
+	"skipped":         "已跳过",
+	"action_required": "需要处理",
+	"neutral":         "已完成",
+}
+
+// gitHubIssueActions names the issue actions we notify about
+var gitHubIssueActions = map[string]string{
+	"opened":   "新建",
+	"closed":   "已关闭",
+	"reopened": "重新打开",
+}
+
+// Parts maps a GitHub event to request parts: title, content, repo, sender, event and url. It
+// reports false for events and actions not worth a notification, like releases being edited or
+// workflow runs starting.
+func (e GitHubEvent) Parts(event string) (map[string]string, bool) {
+	repo := e.Repository.FullName
+	var title, content, link string
+	switch event {
+	case "push":
+		title, content = pushSummary(repo, e.Ref, e.Created, e.Deleted, e.Commits, len(e.Commits))
+		link = e.Compare
+	case "release":
+		if e.Action != "published" || e.Release == nil {
+			return nil, false
+		}
+		title = repo + " 发布 " + e.Release.TagName
+		if e.Release.Prerelease {
+			title += "（预发布）"
+		}
+		var lines []string
+		if e.Release.Name != "" && e.Release.Name != e.Release.TagName {
+			lines = append(lines, e.Release.Name)
+		}
+		if body := strings.TrimSpace(e.Release.Body); body != "" {
+			lines = append(lines, TruncateRunes(body, 200))
+		}
+		content = strings.Join(lines, "\n")
+		link = e.Release.HTMLURL
+	case "workflow_run":
+		run := e.WorkflowRun
+		if e.Action != "completed" || run == nil {
+			return nil, false
+		}
+		conclusion := gitHubConclusions[run.Conclusion]
+		if conclusion == "" {
+			conclusion = run.Conclusion
+		}
+		title = fmt.Sprintf("%s 工作流 %s %s", repo, run.Name, conclusion)
+		content = fmt.Sprintf("#%d · 分支 %s · 提交 %s · 由 %s 触发", run.RunNumber, run.HeadBranch, shortSHA(run.HeadSHA), run.Event)
+		link = run.HTMLURL
+	case "issues":
+		action, ok := gitHubIssueActions[e.Action]
+		if !ok || e.Issue == nil {
+			return nil, false
+		}
+		title = fmt.Sprintf("%s issue #%d %s", repo, e.Issue.Number, action)
+		content = e.Issue.Title
+		link = e.Issue.HTMLURL
+	default:
+		return nil, false
+	}
+	return map[string]string{
+		"title":   title,
+		"content": content,
+		"repo":    repo,
+		"sender":  e.Sender.Login,
+		"event":   event,
+		"url":     httpURL(link),
+	}, true
+}
+
+// pushSummary describes a push: the branch or tag it created, deleted or updated and its commits.
+// total counts the commits of the push, which can be more than the payload lists.
+func pushSummary(repo, ref string, created, deleted bool, commits []gitCommit, total int) (string, string) {
+	name, kind := strings.TrimPrefix(ref, "refs/heads/"), "分支"
+	if strings.HasPrefix(ref, "refs/tags/") {
+		name, kind = strings.TrimPrefix(ref, "refs/tags/"), "标签"
+	}
+	switch {
+	case deleted:
+		return fmt.Sprintf("%s 删除%s %s", repo, kind, name), ""
+	case kind == "标签":
+		return fmt.Sprintf("%s 推送标签 %s", repo, name), ""
+	case total == 0 && created:
+		return fmt.Sprintf("%s 创建分支 %s", repo, name), ""
+	}
+
+	var lines []string
+	for i, commit := range commits {
+		if i == maxPushCommits {
+			break
+		}
+		message, _, _ := strings.Cut(commit.Message, "\n")
+		lines = append(lines, fmt.Sprintf("%s %s（%s）", shortSHA(commit.ID), message, commit.Author.Name))
+	}
+	if total > len(lines) {
+		lines = append(lines, fmt.Sprintf("…共 %d 个提交", total))
+	}
+	return fmt.Sprintf("%s 推送到 %s（%d 个提交）", repo, name, total), strings.Join(lines, "\n")
+}
+
+// gitLabNullSHA is the before or after commit of a push that creates or deletes a ref
+const gitLabNullSHA = "0000000000000000000000000000000000000000"
+
+// GitLabEvent holds the fields of the GitLab webhook events we notify about: push, tag_push,
+// pipeline and merge_request, told apart by object_kind
+type GitLabEvent struct {
+	ObjectKind string `json:"object_kind"`
+	Project    struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		WebURL            string `json:"web_url"`
+	} `json:"project"`
+	User struct {
+		Name     string `json:"name"`
+		Username string `json:"username"`
+	} `json:"user"`
+
+	// push and tag_push
+	UserUsername      string      `json:"user_username"`
+	Ref               string      `json:"ref"`
+	Before            string      `json:"before"`
+	After             string      `json:"after"`
+	Commits           []gitCommit `json:"commits"`
+	TotalCommitsCount int         `json:"total_commits_count"`
+
+	// pipeline and merge_request
+	ObjectAttributes struct {
+		ID           int64  `json:"id"`
+		IID          int64  `json:"iid"`
+		Title        string `json:"title"`
+		Ref          string `json:"ref"`
+		SHA          string `json:"sha"`
+		Status       string `json:"status"`
+		Source       string `json:"source"`
+		SourceBranch string `json:"source_branch"`
+		TargetBranch string `json:"target_branch"`
+		Action       string `json:"action"`
+		URL          string `json:"url"`
+	} `json:"object_attributes"`
+}
+
+// gitLabPipelineStatuses names the pipeline statuses we notify about; pipelines still running
+// aren't worth a message
+var gitLabPipelineStatuses = map[string]string{
+	"success":  "成功",
+	"failed":   "失败",
+	"canceled": "已取消",
+}
+
+// gitLabMergeRequestActions names the merge request actions we notify about
+var gitLabMergeRequestActions = map[string]string{
+	"open":     "新建",
+	"close":    "已关闭",
+	"reopen":   "重新打开",
+	"merge":    "已合并",
+	"approved": "已批准",
+}
+
+// Parts maps a GitLab event to request parts: title, content, repo, sender, event and url. It
+// reports false for events not worth a notification, like pipelines starting or merge requests
+// being edited.
+func (e GitLabEvent) Parts() (map[string]string, bool) {
+	repo := e.Project.PathWithNamespace
+	sender := e.User.Username
+	attrs := e.ObjectAttributes
+	var title, content, link string
+	switch e.ObjectKind {
+	case "push", "tag_push":
+		sender = e.UserUsername
+		title, content = pushSummary(repo, e.Ref, e.Before == gitLabNullSHA, e.After == gitLabNullSHA, e.Commits, e.TotalCommitsCount)
+		link = e.Project.WebURL
+		if e.ObjectKind == "push" && e.After != gitLabNullSHA && e.Before != gitLabNullSHA {
+			link += "/-/compare/" + e.Before + "..." + e.After
+		}
+	case "pipeline":
+		status, ok := gitLabPipelineStatuses[attrs.Status]
+		if !ok {
+			return nil, false
+		}
+		title = fmt.Sprintf("%s 流水线 #%d %s", repo, attrs.ID, status)
+		content = fmt.Sprintf("分支 %s · 提交 %s · 由 %s 触发", attrs.Ref, shortSHA(attrs.SHA), attrs.Source)
+		link = attrs.URL
+		if link == "" {
+			link = fmt.Sprintf("%s/-/pipelines/%d", e.Project.WebURL, attrs.ID)
+		}
+	case "merge_request":
+		action, ok := gitLabMergeRequestActions[attrs.Action]
+		if !ok {
+			return nil, false
+		}
+		title = fmt.Sprintf("%s MR !%d %s", repo, attrs.IID, action)
+		content = fmt.Sprintf("%s（%s → %s）", attrs.Title, attrs.SourceBranch, attrs.TargetBranch)
+		link = attrs.URL
+	default:
+		return nil, false
+	}
+	return map[string]string{
+		"title":   title,
+		"content": content,
+		"repo":    repo,
+		"sender":  sender,
+		"event":   e.ObjectKind,
+		"url":     httpURL(link),
+	}, true
+}
+
+// JenkinsBuild is the JSON body of the Jenkins Notification plugin. The plugin posts every phase
+// of a build: QUEUED, STARTED, COMPLETED and FINALIZED.
+type JenkinsBuild struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Build       struct {
+		FullURL  string `json:"full_url"`
+		Number   int    `json:"number"`
+		Phase    string `json:"phase"`
+		Status   string `json:"status"`
+		Duration int64  `json:"duration"` // Milliseconds
+		Notes    string `json:"notes"`
+		SCM      struct {
+			Branch string `json:"branch"`
+			Commit string `json:"commit"`
+		} `json:"scm"`
+	} `json:"build"`
+}
+
+// jenkinsStatuses names the results of a Jenkins build
+var jenkinsStatuses = map[string]string{
+	"SUCCESS":   "成功",
+	"FAILURE":   "失败",
+	"UNSTABLE":  "不稳定",
+	"ABORTED":   "已中止",
+	"NOT_BUILT": "未构建",
+}
+
+// Parts maps a build to request parts: title, job, number, status, content (branch, commit,
+// duration and notes) and url. It reports false for every phase but COMPLETED, so each build is
+// announced once with its result.
+func (b JenkinsBuild) Parts() (map[string]string, bool) {
+	if b.Build.Phase != "COMPLETED" {
+		return nil, false
+	}
+	job := b.DisplayName
+	if job == "" {
+		job = b.Name
+	}
+	status := jenkinsStatuses[b.Build.Status]
+	if status == "" {
+		status = b.Build.Status
+	}
+
+	var details []string
+	if branch := b.Build.SCM.Branch; branch != "" {
+		details = append(details, "分支 "+strings.TrimPrefix(branch, "origin/"))
+	}
+	if commit := b.Build.SCM.Commit; commit != "" {
+		details = append(details, "提交 "+shortSHA(commit))
+	}
+	if b.Build.Duration > 0 {
+		details = append(details, "耗时 "+(time.Duration(b.Build.Duration)*time.Millisecond).Round(time.Second).String())
+	}
+	lines := []string{strings.Join(details, " · ")}
+	if notes := strings.TrimSpace(b.Build.Notes); notes != "" {
+		lines = append(lines, notes)
+	}
+	return map[string]string{
+		"title":   fmt.Sprintf("%s #%d %s", job, b.Build.Number, status),
+		"job":     job,
+		"number":  fmt.Sprint(b.Build.Number),
+		"status":  status,
+		"content": strings.TrimSpace(strings.Join(lines, "\n")),
+		"url":     httpURL(b.Build.FullURL),
+	}, true
+}
+
+// shortSHA abbreviates a commit hash the way Git shows it
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// ArgoCDNotification is the body the webhook template of ArgoCDConfigMap posts. Argo CD renders
+// fields it can't resolve, like the operation of an application that never synced, as
+// "<no value>".
+type ArgoCDNotification struct {
+	App      string `json:"app"`
+	Project  string `json:"project"`
+	Sync     string `json:"sync_status"`   // Synced, OutOfSync or Unknown
+	Health   string `json:"health_status"` // Healthy, Progressing, Degraded, Suspended, Missing or Unknown
+	Phase    string `json:"operation_phase"`
+	Revision string `json:"revision"`
+	RepoURL  string `json:"repo_url"`
+	Message  string `json:"message"`
+	URL      string `json:"url"`
+}
+
+// argoCDStatuses names Argo CD's sync and health statuses and operation phases in messages
+var argoCDStatuses = map[string]string{
+	"Synced":      "已同步",
+	"OutOfSync":   "未同步",
+	"Healthy":     "健康",
+	"Progressing": "进行中",
+	"Degraded":    "降级",
+	"Suspended":   "已暂停",
+	"Missing":     "缺失",
+	"Unknown":     "未知",
+	"Succeeded":   "成功",
+	"Failed":      "失败",
+	"Error":       "出错",
+	"Running":     "进行中",
+	"Terminating": "终止中",
+}
+
+// argoCDValue returns a field of the notification, empty when Argo CD couldn't resolve it
+func argoCDValue(v string) string {
+	v = strings.TrimSpace(v)
+	if v == "<no value>" {
+		return ""
+	}
+	return v
+}
+
+// argoCDStatus returns a status named for messages
+func argoCDStatus(v string) string {
+	v = argoCDValue(v)
+	if name, ok := argoCDStatuses[v]; ok {
+		return name
+	}
+	return v
+}
+
+// Parts maps a notification to request parts: title (the sync result, or the health when the
+// application didn't sync), app, project, sync, health, phase, revision, content and url
+func (n ArgoCDNotification) Parts() map[string]string {
+	app := argoCDValue(n.App)
+	sync, health, phase := argoCDStatus(n.Sync), argoCDStatus(n.Health), argoCDStatus(n.Phase)
+	revision := shortSHA(argoCDValue(n.Revision))
+
+	title := app + " 健康状态" + health
+	if phase != "" {
+		title = app + " 同步" + phase
+	}
+	var details []string
+	if sync != "" {
+		details = append(details, "同步 "+sync)
+	}
+	if health != "" {
+		details = append(details, "健康 "+health)
+	}
+	if revision != "" {
+		details = append(details, "版本 "+revision)
+	}
+	lines := []string{strings.Join(details, " · ")}
+	if repo := argoCDValue(n.RepoURL); repo != "" {
+		lines = append(lines, "仓库："+repo)
+	}
+	if message := argoCDValue(n.Message); message != "" {
+		lines = append(lines, message)
+	}
+	return map[string]string{
+		"title":    strings.TrimSpace(title),
+		"app":      app,
+		"project":  argoCDValue(n.Project),
+		"sync":     sync,
+		"health":   health,
+		"phase":    phase,
+		"revision": revision,
+		"content":  strings.TrimSpace(strings.Join(lines, "\n")),
+		"url":      httpURL(argoCDValue(n.URL)),
+	}
+}
+
+// ArgoCDSubscribeAnnotation subscribes an Argo CD application to the trigger of ArgoCDConfigMap
+const ArgoCDSubscribeAnnotation = `notifications.argoproj.io/subscribe.on-tongzhi.tongzhi: ""`
+
+// ArgoCDConfigMap returns the entries to add to argocd-notifications-cm: a webhook service
+// posting to endpointURL, the template of ArgoCDNotification and a trigger sending it when a sync
+// finishes or an application turns degraded
+func ArgoCDConfigMap(endpointURL string) string {
+	return `service.webhook.tongzhi: |
+  url: ` + endpointURL + `
+  headers:
+  - name: Content-Type
+    value: application/json
+template.tongzhi: |
+  webhook:
+    tongzhi:
+      method: POST
+      body: |
+        {
+          "app": "{{.app.metadata.name}}",
+          "project": "{{.app.spec.project}}",
+          "sync_status": "{{.app.status.sync.status}}",
+          "health_status": "{{.app.status.health.status}}",
+          "operation_phase": "{{if .app.status.operationState}}{{.app.status.operationState.phase}}{{end}}",
+          "revision": "{{.app.status.sync.revision}}",
+          "repo_url": "{{.app.spec.source.repoURL}}",
+          "url": "{{.context.argocdUrl}}/applications/{{.app.metadata.name}}"
+        }
+trigger.on-tongzhi: |
+  - when: app.status.operationState != nil and app.status.operationState.phase in ['Succeeded', 'Error', 'Failed']
+    oncePer: app.status.operationState.syncResult.revision
+    send: [tongzhi]
+  - when: app.status.health.status == 'Degraded'
+    send: [tongzhi]
+`
+}
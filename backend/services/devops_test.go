@@ -0,0 +1,171 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestVerifyGitHubSignature(t *testing.T) {
+	body := []byte(`{"zen":"Keep it logically awesome."}`)
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if !VerifyGitHubSignature("s3cret", signature, body) {
+		t.Error("valid signature rejected")
+	}
+	if VerifyGitHubSignature("other", signature, body) || VerifyGitHubSignature("s3cret", signature, append(body, ' ')) {
+		t.Error("signature accepted with the wrong secret or body")
+	}
+	if VerifyGitHubSignature("", "sha256=", nil) {
+		t.Error("empty secret accepted")
+	}
+}
+
+func TestGitHubEventParts(t *testing.T) {
+	parse := func(body string) GitHubEvent {
+		var e GitHubEvent
+		if err := json.Unmarshal([]byte(body), &e); err != nil {
+			t.Fatalf("Unmarshal error: %v", err)
+		}
+		return e
+	}
+
+	push := parse(`{"ref":"refs/heads/main","compare":"https://github.com/o/r/compare/a...b",
+		"repository":{"full_name":"o/r"},"sender":{"login":"dev"},
+		"commits":[{"id":"0123456789abcdef","message":"Fix login\n\nDetails","author":{"name":"Dev"}}]}`)
+	parts, ok := push.Parts("push")
+	if !ok || parts["title"] != "o/r 推送到 main（1 个提交）" || parts["content"] != "0123456 Fix login（Dev）" {
+		t.Errorf("unexpected push parts: %v", parts)
+	}
+	if parts["url"] != "https://github.com/o/r/compare/a...b" || parts["sender"] != "dev" {
+		t.Errorf("unexpected push link or sender: %v", parts)
+	}
+
+	run := parse(`{"action":"completed","repository":{"full_name":"o/r"},
+		"workflow_run":{"name":"CI","run_number":42,"head_branch":"main","head_sha":"abcdef0123","event":"push","conclusion":"failure","html_url":"https://github.com/o/r/actions/runs/1"}}`)
+	if parts, ok := run.Parts("workflow_run"); !ok || parts["title"] != "o/r 工作流 CI 失败" || !strings.Contains(parts["content"], "#42") {
+		t.Errorf("unexpected workflow_run parts: %v", parts)
+	}
+
+	issue := parse(`{"action":"opened","repository":{"full_name":"o/r"},"issue":{"number":7,"title":"Crash on start","html_url":"https://github.com/o/r/issues/7"}}`)
+	if parts, ok := issue.Parts("issues"); !ok || parts["title"] != "o/r issue #7 新建" || parts["content"] != "Crash on start" {
+		t.Errorf("unexpected issues parts: %v", parts)
+	}
+
+	// Actions and events without a notification
+	for event, body := range map[string]string{
+		"release":      `{"action":"edited","release":{"tag_name":"v1"}}`,
+		"workflow_run": `{"action":"requested","workflow_run":{"name":"CI"}}`,
+		"issues":       `{"action":"labeled","issue":{"number":1}}`,
+		"star":         `{"action":"created"}`,
+	} {
+		if _, ok := parse(body).Parts(event); ok {
+			t.Errorf("%s %s should be ignored", event, body)
+		}
+	}
+	release := parse(`{"action":"published","repository":{"full_name":"o/r"},"release":{"tag_name":"v1.2.0","name":"v1.2.0","body":"Notes","prerelease":true}}`)
+	if parts, ok := release.Parts("release"); !ok || parts["title"] != "o/r 发布 v1.2.0（预发布）" || parts["content"] != "Notes" {
+		t.Errorf("unexpected release parts: %v", parts)
+	}
+}
+
+func TestGitLabEventParts(t *testing.T) {
+	parse := func(body string) GitLabEvent {
+		var e GitLabEvent
+		if err := json.Unmarshal([]byte(body), &e); err != nil {
+			t.Fatalf("Unmarshal error: %v", err)
+		}
+		return e
+	}
+
+	push := parse(`{"object_kind":"push","ref":"refs/heads/main","user_username":"dev",
+		"before":"1111111111111111111111111111111111111111","after":"2222222222222222222222222222222222222222",
+		"project":{"path_with_namespace":"g/p","web_url":"https://gitlab.example.com/g/p"},
+		"total_commits_count":7,"commits":[{"id":"abcdef0123","message":"Add feature","author":{"name":"Dev"}}]}`)
+	parts, ok := push.Parts()
+	if !ok || parts["title"] != "g/p 推送到 main（7 个提交）" || parts["content"] != "abcdef0 Add feature（Dev）\n…共 7 个提交" {
+		t.Errorf("unexpected push parts: %v", parts)
+	}
+	if parts["url"] != "https://gitlab.example.com/g/p/-/compare/1111111111111111111111111111111111111111...2222222222222222222222222222222222222222" || parts["sender"] != "dev" {
+		t.Errorf("unexpected push link or sender: %v", parts)
+	}
+
+	deleted := parse(`{"object_kind":"tag_push","ref":"refs/tags/v1","after":"0000000000000000000000000000000000000000","project":{"path_with_namespace":"g/p"}}`)
+	if parts, ok := deleted.Parts(); !ok || parts["title"] != "g/p 删除标签 v1" {
+		t.Errorf("unexpected tag deletion parts: %v", parts)
+	}
+
+	pipeline := parse(`{"object_kind":"pipeline","user":{"username":"dev"},"project":{"path_with_namespace":"g/p","web_url":"https://gitlab.example.com/g/p"},
+		"object_attributes":{"id":31,"ref":"main","sha":"abcdef0123","status":"failed","source":"push"}}`)
+	if parts, ok := pipeline.Parts(); !ok || parts["title"] != "g/p 流水线 #31 失败" || parts["url"] != "https://gitlab.example.com/g/p/-/pipelines/31" {
+		t.Errorf("unexpected pipeline parts: %v", parts)
+	}
+
+	mr := parse(`{"object_kind":"merge_request","project":{"path_with_namespace":"g/p"},
+		"object_attributes":{"iid":5,"title":"Refactor","source_branch":"feat","target_branch":"main","action":"merge","url":"https://gitlab.example.com/g/p/-/merge_requests/5"}}`)
+	if parts, ok := mr.Parts(); !ok || parts["title"] != "g/p MR !5 已合并" || parts["content"] != "Refactor（feat → main）" {
+		t.Errorf("unexpected merge request parts: %v", parts)
+	}
+
+	for _, body := range []string{
+		`{"object_kind":"pipeline","object_attributes":{"status":"running"}}`,
+		`{"object_kind":"merge_request","object_attributes":{"action":"update"}}`,
+		`{"object_kind":"note"}`,
+	} {
+		if _, ok := parse(body).Parts(); ok {
+			t.Errorf("%s should be ignored", body)
+		}
+	}
+}
+
+func TestJenkinsBuildParts(t *testing.T) {
+	var build JenkinsBuild
+	json.Unmarshal([]byte(`{"name":"deploy","display_name":"deploy","url":"job/deploy/",
+		"build":{"full_url":"https://ci.example.com/job/deploy/12/","number":12,"phase":"COMPLETED","status":"FAILURE","duration":90500,
+		"scm":{"branch":"origin/main","commit":"abcdef0123456"}}}`), &build)
+	parts, ok := build.Parts()
+	if !ok || parts["title"] != "deploy #12 失败" || parts["status"] != "失败" || parts["number"] != "12" {
+		t.Errorf("unexpected parts: %v", parts)
+	}
+	if parts["content"] != "分支 main · 提交 abcdef0 · 耗时 1m31s" || parts["url"] != "https://ci.example.com/job/deploy/12/" {
+		t.Errorf("unexpected content or link: %v", parts)
+	}
+
+	for _, phase := range []string{"QUEUED", "STARTED", "FINALIZED"} {
+		build.Build.Phase = phase
+		if _, ok := build.Parts(); ok {
+			t.Errorf("phase %s should be ignored", phase)
+		}
+	}
+}
+
+func TestArgoCDNotificationParts(t *testing.T) {
+	var n ArgoCDNotification
+	json.Unmarshal([]byte(`{"app":"guestbook","project":"default","sync_status":"Synced","health_status":"Progressing",
+		"operation_phase":"Succeeded","revision":"abcdef0123456","repo_url":"https://git.example.com/apps.git",
+		"url":"https://argocd.example.com/applications/guestbook"}`), &n)
+	parts := n.Parts()
+	if parts["title"] != "guestbook 同步成功" || parts["sync"] != "已同步" || parts["health"] != "进行中" || parts["revision"] != "abcdef0" {
+		t.Errorf("unexpected parts: %v", parts)
+	}
+	if parts["content"] != "同步 已同步 · 健康 进行中 · 版本 abcdef0\n仓库：https://git.example.com/apps.git" ||
+		parts["url"] != "https://argocd.example.com/applications/guestbook" {
+		t.Errorf("unexpected content or link: %v", parts)
+	}
+
+	// A health change without a sync, with the fields Argo CD couldn't resolve
+	json.Unmarshal([]byte(`{"app":"guestbook","sync_status":"OutOfSync","health_status":"Degraded","operation_phase":"",
+		"revision":"<no value>","repo_url":"<no value>","url":"/applications/guestbook"}`), &n)
+	parts = n.Parts()
+	if parts["title"] != "guestbook 健康状态降级" || parts["phase"] != "" || parts["revision"] != "" || parts["url"] != "" {
+		t.Errorf("unexpected parts: %v", parts)
+	}
+	if parts["content"] != "同步 未同步 · 健康 降级" {
+		t.Errorf("unexpected content: %q", parts["content"])
+	}
+}
@@ -0,0 +1,395 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"wechat-notification/config"
+	"wechat-notification/logger"
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+// Version is the server version, set at build time with
+// -ldflags "-X wechat-notification/services.Version=v1.2.3"
+var Version = "dev"
+
+const (
+	// DiagnosticsErrorWindow is how far back failed sends are listed in a diagnostics bundle
+	DiagnosticsErrorWindow = 24 * time.Hour
+
+	diagnosticsManifest  = "manifest.json"
+	diagnosticsSignature = "signature"
+	redacted             = "[REDACTED]"
+
+	// maxDiagnosticsEntry and maxDiagnosticsTotal cap how much of an uploaded bundle Verify
+	// decompresses, so a zip bomb can't exhaust memory
+	maxDiagnosticsEntry = 4 << 20
+	maxDiagnosticsTotal = 16 << 20
+)
+
+// ErrInvalidDiagnostics is returned when a diagnostics bundle was altered or signed by another server
+var ErrInvalidDiagnostics = errors.New("diagnostics bundle signature does not match")
+
+// accessTokenPattern matches access tokens in logged WeChat URLs
+var accessTokenPattern = regexp.MustCompile(`(access_token=)[^&\s"]+`)
+
+// urlPattern matches URLs in error text, whose paths and queries can carry keys like a Server酱
+// SendKey, a Telegram bot token or a Gotify token
+var urlPattern = regexp.MustCompile(`(?i)\b[a-z][a-z0-9+.-]*://[^\s"]+`)
+
+// secretConfigFields are the fields of JSON config values that hold secrets
+var secretConfigFields = []string{"token", "secret", "appSecret", "sendKey", "encodingAESKey", "authHeader", "password", "remoteWritePassword"}
+
+// Diagnostics builds support bundles: a zip of the configuration summary, recent errors and
+// version information with every known secret redacted, signed with a key derived from the
+// session secret so the server can later confirm a bundle wasn't edited
+type Diagnostics struct {
+	cfg     *config.Config
+	repo    *repository.SQLiteRepository
+	tokens  *TokenManager
+	key     []byte
+	started time.Time
+}
+
+// NewDiagnostics creates a diagnostics bundle builder
+func NewDiagnostics(cfg *config.Config, repo *repository.SQLiteRepository, tokens *TokenManager) *Diagnostics {
+	mac := hmac.New(sha256.New, []byte(cfg.SessionSecret))
+	mac.Write([]byte("diagnostics"))
+	return &Diagnostics{cfg: cfg, repo: repo, tokens: tokens, key: mac.Sum(nil), started: time.Now()}
+}
+
+// DiagnosticsVersion describes the running server
+type DiagnosticsVersion struct {
+	Version       string    `json:"version"`
+	GoVersion     string    `json:"goVersion"`
+	Platform      string    `json:"platform"`
+	SchemaVersion int       `json:"schemaVersion"`
+	StartedAt     time.Time `json:"startedAt"`
+	GeneratedAt   time.Time `json:"generatedAt"`
+}
+
+// DiagnosticsConfig summarizes the configuration; secrets are reported only as set or not
+type DiagnosticsConfig struct {
+	DevMode            bool         `json:"devMode"`
+	OIDCProvider       string       `json:"oidcProvider"`
+	PublicBaseURL      string       `json:"publicBaseUrl"`
+	LogLevel           string       `json:"logLevel"`
+	MaxInFlight        int          `json:"maxInFlight"`
+	SendConcurrency    int          `json:"sendConcurrency"`
+	SendTimeoutSeconds int          `json:"sendTimeoutSeconds"`
+	DBTimeoutSeconds   int          `json:"dbTimeoutSeconds"`
+	WeChatAppID        string       `json:"wechatAppId"`
+	WeChatTemplateID   string       `json:"wechatTemplateId"`
+	SecretsSet         []string     `json:"secretsSet"`
+	Token              TokenStatus  `json:"token"`
+	Capabilities       Capabilities `json:"capabilities"`
+	Recipients         int          `json:"recipients"`
+}
+
+// DiagnosticsErrors lists recent warnings and failed sends
+type DiagnosticsErrors struct {
+	Warnings    []logger.Entry      `json:"warnings"`
+	FailedSends []DiagnosticsFailed `json:"failedSends"`
+}
+
+// DiagnosticsFailed summarizes a send that failed for some recipients, without recipient details
+type DiagnosticsFailed struct {
+	MessageID   int64     `json:"messageId"`
+	TemplateKey string    `json:"templateKey"`
+	CreatedAt   time.Time `json:"createdAt"`
+	TotalFailed int       `json:"totalFailed"`
+	Errors      []string  `json:"errors"`
+}
+
+type diagnosticsManifestFile struct {
+	GeneratedAt time.Time         `json:"generatedAt"`
+	Files       map[string]string `json:"files"` // file name → SHA-256
+}
+
+// Bundle builds the signed diagnostics zip
+func (d *Diagnostics) Bundle(now time.Time) ([]byte, error) {
+	secrets, err := d.secrets()
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := d.repo.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	version := DiagnosticsVersion{
+		Version: Version, GoVersion: runtime.Version(), Platform: runtime.GOOS + "/" + runtime.GOARCH,
+		SchemaVersion: schema, StartedAt: d.started, GeneratedAt: now,
+	}
+
+	summary, err := d.configSummary()
+	if err != nil {
+		return nil, err
+	}
+
+	failed, err := d.repo.GetFailedMessagesSince(now.Add(-DiagnosticsErrorWindow))
+	if err != nil {
+		return nil, err
+	}
+	errs := DiagnosticsErrors{Warnings: logger.Recent(), FailedSends: []DiagnosticsFailed{}}
+	for i := range errs.Warnings {
+		errs.Warnings[i].Message = withoutURLs(errs.Warnings[i].Message)
+	}
+	for _, m := range failed {
+		var results []SendResult
+		json.Unmarshal(m.Results, &results)
+		seen := map[string]bool{}
+		entry := DiagnosticsFailed{MessageID: m.ID, TemplateKey: m.TemplateKey, CreatedAt: m.CreatedAt, TotalFailed: m.TotalFailed, Errors: []string{}}
+		for _, r := range results {
+			if text := withoutURLs(r.Error); text != "" && !seen[text] {
+				seen[text] = true
+				entry.Errors = append(entry.Errors, text)
+			}
+		}
+		errs.FailedSends = append(errs.FailedSends, entry)
+	}
+
+	files := []struct {
+		name string
+		v    interface{}
+	}{
+		{"version.json", version}, {"config.json", summary}, {"errors.json", errs},
+	}
+	manifest := diagnosticsManifestFile{GeneratedAt: now, Files: map[string]string{}}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		data, err := json.MarshalIndent(f.v, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		data = redact(data, secrets)
+		sum := sha256.Sum256(data)
+		manifest.Files[f.name] = hex.EncodeToString(sum[:])
+		if err := writeZipFile(zw, f.name, data); err != nil {
+			return nil, err
+		}
+	}
+	manifestData, _ := json.MarshalIndent(manifest, "", "  ")
+	if err := writeZipFile(zw, diagnosticsManifest, manifestData); err != nil {
+		return nil, err
+	}
+	if err := writeZipFile(zw, diagnosticsSignature, []byte(d.sign(manifestData))); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Verify checks that a bundle was produced by this server and not edited since
+func (d *Diagnostics) Verify(bundle []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(bundle), int64(len(bundle)))
+	if err != nil {
+		return ErrInvalidDiagnostics
+	}
+	contents := map[string][]byte{}
+	total := 0
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return ErrInvalidDiagnostics
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, maxDiagnosticsEntry+1))
+		rc.Close()
+		if total += len(data); err != nil || len(data) > maxDiagnosticsEntry || total > maxDiagnosticsTotal {
+			return ErrInvalidDiagnostics
+		}
+		contents[f.Name] = data
+	}
+
+	manifestData := contents[diagnosticsManifest]
+	if !hmac.Equal([]byte(d.sign(manifestData)), contents[diagnosticsSignature]) {
+		return ErrInvalidDiagnostics
+	}
+	var manifest diagnosticsManifestFile
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return ErrInvalidDiagnostics
+	}
+	if len(contents) != len(manifest.Files)+2 {
+		return ErrInvalidDiagnostics
+	}
+	for name, want := range manifest.Files {
+		data, ok := contents[name]
+		sum := sha256.Sum256(data)
+		if !ok || hex.EncodeToString(sum[:]) != want {
+			return ErrInvalidDiagnostics
+		}
+	}
+	return nil
+}
+
+func (d *Diagnostics) sign(data []byte) string {
+	mac := hmac.New(sha256.New, d.key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *Diagnostics) configSummary() (DiagnosticsConfig, error) {
+	wechat, err := d.repo.GetWeChatConfig()
+	if err != nil {
+		return DiagnosticsConfig{}, err
+	}
+	effective, _ := ResolveWeChatConfig(d.cfg.WeChat, wechat)
+	recipients, err := d.repo.CountRecipients()
+	if err != nil {
+		return DiagnosticsConfig{}, err
+	}
+	webhookToken, _ := d.repo.GetConfig(WebhookTokenConfigKey)
+	signingSecret, _ := d.repo.GetConfig(WebhookSigningSecretConfigKey)
+
+	summary := DiagnosticsConfig{
+		DevMode:            d.cfg.DevMode,
+		OIDCProvider:       d.cfg.OIDC.ProviderURL,
+		PublicBaseURL:      d.cfg.PublicBaseURL,
+		LogLevel:           logger.Level(),
+		MaxInFlight:        d.cfg.MaxInFlight,
+		SendConcurrency:    d.cfg.SendConcurrency,
+		SendTimeoutSeconds: d.cfg.SendTimeoutSeconds,
+		DBTimeoutSeconds:   d.cfg.DBTimeoutSeconds,
+		WeChatAppID:        effective.AppID,
+		WeChatTemplateID:   effective.TemplateID,
+		SecretsSet:         []string{},
+		Token:              d.tokens.Status(),
+		Capabilities:       BuildCapabilities(d.cfg, d.tokens),
+		Recipients:         recipients,
+	}
+	for _, s := range []struct{ name, value string }{
+		{"wechatAppSecret", effective.AppSecret}, {"oidcClientSecret", d.cfg.OIDC.ClientSecret},
+		{"smtpPassword", d.cfg.SMTP.Password}, {"telegramBotToken", d.cfg.TelegramBotToken}, {"webhookToken", webhookToken},
+		{"webhookSigningSecret", signingSecret},
+	} {
+		if s.value != "" {
+			summary.SecretsSet = append(summary.SecretsSet, s.name)
+		}
+	}
+	return summary, nil
+}
+
+// secrets returns every secret value the bundle must not contain, longest first so a secret
+// containing another is replaced whole
+func (d *Diagnostics) secrets() ([]string, error) {
+	values := []string{d.cfg.SessionSecret, d.cfg.WeChat.AppSecret, d.cfg.OIDC.ClientSecret, d.cfg.SMTP.Password, d.cfg.TelegramBotToken}
+	wechat, err := d.repo.GetWeChatConfig()
+	if err != nil {
+		return nil, err
+	}
+	values = append(values, wechat.AppSecret)
+	// Hashed since startup, but redacted in case an older version stored it in plaintext
+	if token, _ := d.repo.GetConfig(WebhookTokenConfigKey); token != "" {
+		values = append(values, token)
+	}
+	if secret, _ := d.repo.GetConfig(WebhookSigningSecretConfigKey); secret != "" {
+		values = append(values, secret)
+	}
+	apps, err := d.repo.GetWeChatApps()
+	if err != nil {
+		return nil, err
+	}
+	for _, app := range apps {
+		values = append(values, app.AppSecret)
+	}
+
+	// Secrets in JSON config values: compat endpoint tokens, the callback Token and
+	// EncodingAESKey, the Server酱 SendKey, the directory sync Authorization header and so on
+	configs, err := d.repo.GetAllConfig()
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range configs {
+		var fields map[string]interface{}
+		if json.Unmarshal([]byte(value), &fields) != nil {
+			continue
+		}
+		for _, name := range secretConfigFields {
+			if v, ok := fields[name].(string); ok {
+				values = append(values, v)
+			}
+		}
+	}
+	// Custom endpoint tokens are hashed at startup too
+	custom, err := d.repo.GetAllCustomEndpoints()
+	if err != nil {
+		return nil, err
+	}
+	for _, endpoint := range custom {
+		values = append(values, endpoint.Token)
+	}
+
+	// Channel keys of recipients, groups and routing rules, including WeCom app secrets
+	var channels []models.Channel
+	recipients, err := d.repo.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range recipients {
+		channels = append(channels, r.Channels...)
+	}
+	groups, err := d.repo.GetAllGroups()
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		channels = append(channels, g.Channels...)
+	}
+	rules, err := d.repo.GetAllRoutingRules()
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		channels = append(channels, rule.Channels...)
+	}
+	for _, ch := range channels {
+		values = append(values, ch.Secret, ch.Token, ch.WebhookURL)
+	}
+
+	var secrets []string
+	for _, v := range values {
+		if len(v) >= 4 {
+			secrets = append(secrets, v)
+		}
+	}
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	return secrets, nil
+}
+
+// withoutURLs replaces the URLs in error text, which may carry keys no pattern knows about
+func withoutURLs(text string) string {
+	return urlPattern.ReplaceAllString(text, "[URL]")
+}
+
+// redact replaces secret values and access tokens in data
+func redact(data []byte, secrets []string) []byte {
+	s := string(data)
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return []byte(accessTokenPattern.ReplaceAllString(s, "${1}"+redacted))
+}
+
+func writeZipFile(zw *zip.Writer, name string, data []byte) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"wechat-notification/config"
+	"wechat-notification/logger"
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+func TestDiagnosticsBundle(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	repo.SaveWeChatConfig(&models.WeChatConfig{AppID: "wx-app", AppSecret: "db-app-secret", TemplateID: "tpl"})
+	repo.SetConfig("webhook_token", "hook-token-value")
+	repo.SetJSONConfig("compat_pushplus", models.CompatEndpointConfig{Enabled: true, Token: "compat-token-value"})
+	repo.SetJSONConfig(CallbackConfigKey, models.CallbackConfig{Token: "callback-token", EncodingAESKey: "callback-aes-key"})
+	repo.Create(&models.Recipient{OpenID: "o-ops", Name: "ops", Channels: []models.Channel{
+		{Type: models.ChannelGotify, ServerURL: "https://gotify.example.com", Token: "gotify-app-token"},
+	}})
+	results, _ := json.Marshal([]SendResult{
+		{RecipientID: 1, Error: "token hook-token-value rejected", ErrCode: 40001},
+		{RecipientID: 1, Error: `Post "https://sctapi.ftqq.com/SCT-sendkey.send": dial tcp: i/o timeout`},
+	})
+	repo.CreateMessage(&models.Message{TemplateKey: "alert", TotalCount: 1, TotalFailed: 1, Results: results})
+	logger.Warnf("wechat: GET https://api.weixin.qq.com/cgi-bin/x?access_token=live-token failed")
+
+	cfg := &config.Config{SessionSecret: "session-secret", OIDC: config.OIDCConfig{ClientSecret: "oidc-secret"}}
+	diagnostics := NewDiagnostics(cfg, repo, NewTokenManager("", ""))
+	bundle, err := diagnostics.Bundle(time.Now())
+	if err != nil {
+		t.Fatalf("Bundle error: %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(bundle), int64(len(bundle)))
+	if err != nil {
+		t.Fatalf("bundle is not a zip: %v", err)
+	}
+	var all strings.Builder
+	var tampered bytes.Buffer
+	zw := zip.NewWriter(&tampered)
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		all.Write(data)
+		w, _ := zw.Create(f.Name)
+		w.Write(bytes.Replace(data, []byte("wx-app"), []byte("wx-bad"), -1))
+	}
+	zw.Close()
+	text := all.String()
+	for _, secret := range []string{"db-app-secret", "hook-token-value", "session-secret", "oidc-secret", "live-token",
+		"compat-token-value", "callback-token", "callback-aes-key", "gotify-app-token", "SCT-sendkey"} {
+		if strings.Contains(text, secret) {
+			t.Errorf("bundle leaks %q", secret)
+		}
+	}
+	for _, want := range []string{"wx-app", "schemaVersion", "wechatAppSecret", "rejected", "i/o timeout"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("bundle lacks %q", want)
+		}
+	}
+
+	if err := diagnostics.Verify(bundle); err != nil {
+		t.Fatalf("Verify error: %v", err)
+	}
+	if err := diagnostics.Verify(tampered.Bytes()); !errors.Is(err, ErrInvalidDiagnostics) {
+		t.Errorf("tampered bundle verified: %v", err)
+	}
+	other := NewDiagnostics(&config.Config{SessionSecret: "another"}, repo, NewTokenManager("", ""))
+	if err := other.Verify(bundle); !errors.Is(err, ErrInvalidDiagnostics) {
+		t.Errorf("bundle verified with another server's key: %v", err)
+	}
+}
+
+func TestDiagnosticsVerifyLimitsSize(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	// A small zip that inflates past the per-entry limit
+	var bomb bytes.Buffer
+	zw := zip.NewWriter(&bomb)
+	w, _ := zw.Create("errors.json")
+	w.Write(make([]byte, maxDiagnosticsEntry+1))
+	zw.Close()
+	if bomb.Len() > maxDiagnosticsEntry/100 {
+		t.Fatalf("test bundle is %d bytes, expected it to compress", bomb.Len())
+	}
+
+	diagnostics := NewDiagnostics(&config.Config{SessionSecret: "session-secret"}, repo, NewTokenManager("", ""))
+	if err := diagnostics.Verify(bomb.Bytes()); !errors.Is(err, ErrInvalidDiagnostics) {
+		t.Errorf("oversized bundle verified: %v", err)
+	}
+}
@@ -0,0 +1,364 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"wechat-notification/logger"
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+const (
+	// DirectorySyncConfigKey stores the directory sync configuration
+	DirectorySyncConfigKey = "directory_sync"
+	// DirectorySyncTick is how often the sync checks whether a run is due
+	DirectorySyncTick = time.Minute
+	// DefaultDirectorySyncInterval is used when the config leaves the interval unset
+	DefaultDirectorySyncInterval = 60
+	// DirectoryMetadataKey marks recipients managed by the directory sync. The value is
+	// "synced" while the recipient is in the directory and "removed" once it was deactivated.
+	DirectoryMetadataKey = "directory"
+
+	directorySynced  = "synced"
+	directoryRemoved = "removed"
+	// maxDirectorySize caps how much of the directory response is read
+	maxDirectorySize = 10 << 20
+
+	// DefaultMaxDeactivatePercent is the share of synced recipients one sync may deactivate when
+	// the config leaves it unset
+	DefaultMaxDeactivatePercent = 20
+	// minDeactivateLimit is how many recipients a sync may always deactivate, so small
+	// directories aren't held to the percentage
+	minDeactivateLimit = 5
+)
+
+var (
+	// ErrDirectoryUnsupported is returned for directory sources this build cannot read
+	ErrDirectoryUnsupported = errors.New("ldap directories are not supported; export the directory as CSV or JSON over HTTP")
+	// ErrDirectoryEmpty is returned when a sync with deactivation finds no usable records, which
+	// is far more likely a broken export than everyone leaving
+	ErrDirectoryEmpty = errors.New("directory has no records with an OpenID; refusing to deactivate every synced recipient")
+	// ErrTooManyDeactivations is returned when a sync would deactivate more recipients than the
+	// config allows
+	ErrTooManyDeactivations = errors.New("sync would deactivate more recipients than maxDeactivatePercent allows")
+)
+
+// DirectorySync periodically pulls recipients from an external directory and reconciles
+// creates, updates and deactivations. Only recipients carrying DirectoryMetadataKey are ever
+// deactivated, so recipients added by hand are left alone.
+type DirectorySync struct {
+	repo    *repository.SQLiteRepository
+	client  *http.Client
+	lastRun time.Time
+}
+
+// NewDirectorySync creates a new directory sync
+func NewDirectorySync(repo *repository.SQLiteRepository) *DirectorySync {
+	return &DirectorySync{repo: repo, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Run syncs whenever the configured interval has passed until ctx is cancelled
+func (d *DirectorySync) Run(ctx context.Context) {
+	ticker := time.NewTicker(DirectorySyncTick)
+	defer ticker.Stop()
+
+	for {
+		d.syncDue(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *DirectorySync) syncDue(now time.Time) {
+	var cfg models.DirectorySyncConfig
+	if err := d.repo.GetJSONConfig(DirectorySyncConfigKey, &cfg); err != nil {
+		logger.Warnf("directory sync: failed to load config: %v", err)
+		return
+	}
+	if !cfg.Enabled || cfg.URL == "" {
+		return
+	}
+	interval := cfg.IntervalMinutes
+	if interval <= 0 {
+		interval = DefaultDirectorySyncInterval
+	}
+	if now.Sub(d.lastRun) < time.Duration(interval)*time.Minute {
+		return
+	}
+	d.lastRun = now
+
+	plan, err := d.Sync(cfg, false)
+	if err != nil {
+		logger.Warnf("directory sync: %v", err)
+		return
+	}
+	if len(plan.Create)+len(plan.Update)+len(plan.Deactivate) > 0 {
+		logger.Infof("directory sync: %d created, %d updated, %d deactivated",
+			len(plan.Create), len(plan.Update), len(plan.Deactivate))
+	}
+}
+
+// Sync plans the reconciliation against the directory and, unless dryRun is set, applies it. A
+// plan that deactivates too many recipients is returned with ErrTooManyDeactivations and not
+// applied.
+func (d *DirectorySync) Sync(cfg models.DirectorySyncConfig, dryRun bool) (*models.DirectorySyncPlan, error) {
+	plan, err := d.Plan(cfg)
+	if err != nil {
+		return plan, err
+	}
+	if dryRun {
+		return plan, nil
+	}
+	if err := d.Apply(plan); err != nil {
+		return plan, err
+	}
+	return plan, nil
+}
+
+// Plan fetches the directory and works out the changes without touching any recipient. With
+// deactivation on, it fails when the directory has no usable records or would deactivate more
+// than cfg.MaxDeactivatePercent of the synced recipients.
+func (d *DirectorySync) Plan(cfg models.DirectorySyncConfig) (*models.DirectorySyncPlan, error) {
+	records, err := d.fetch(cfg)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := d.repo.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	byOpenID := make(map[string]models.Recipient, len(existing))
+	for _, r := range existing {
+		byOpenID[r.OpenID] = r
+	}
+
+	mapping := cfg.Mapping
+	if mapping.OpenID == "" {
+		mapping.OpenID = "openId"
+	}
+	if mapping.Name == "" {
+		mapping.Name = "name"
+	}
+
+	plan := &models.DirectorySyncPlan{Create: []models.Recipient{}, Update: []models.Recipient{}, Deactivate: []models.Recipient{}}
+	seen := make(map[string]bool, len(records))
+	for _, rec := range records {
+		openID := strings.TrimSpace(rec[mapping.OpenID])
+		if openID == "" || seen[openID] {
+			plan.Skipped++
+			continue
+		}
+		seen[openID] = true
+
+		name := strings.TrimSpace(rec[mapping.Name])
+		if name == "" {
+			name = openID
+		}
+		metadata := map[string]string{DirectoryMetadataKey: directorySynced}
+		for key, field := range mapping.Metadata {
+			if v := strings.TrimSpace(rec[field]); v != "" {
+				metadata[key] = v
+			}
+		}
+
+		current, ok := byOpenID[openID]
+		if !ok {
+			r := models.Recipient{OpenID: openID, Name: name, Metadata: metadata}
+			if mapping.Email != "" {
+				r.Email = strings.TrimSpace(rec[mapping.Email])
+			}
+			plan.Create = append(plan.Create, r)
+			continue
+		}
+
+		updated := current
+		changed := false
+		if updated.Name != name {
+			updated.Name, changed = name, true
+		}
+		if mapping.Email != "" {
+			if email := strings.TrimSpace(rec[mapping.Email]); updated.Email != email {
+				updated.Email, changed = email, true
+			}
+		}
+		updated.Metadata = make(map[string]string, len(current.Metadata)+len(metadata))
+		for k, v := range current.Metadata {
+			updated.Metadata[k] = v
+		}
+		for k, v := range metadata {
+			if updated.Metadata[k] != v {
+				updated.Metadata[k], changed = v, true
+			}
+		}
+		if current.Metadata[DirectoryMetadataKey] == directoryRemoved {
+			updated.Deactivated = false
+		}
+		if changed {
+			plan.Update = append(plan.Update, updated)
+		}
+	}
+
+	if cfg.Deactivate {
+		if len(seen) == 0 {
+			return nil, ErrDirectoryEmpty
+		}
+		synced := 0
+		for _, r := range existing {
+			if r.Metadata[DirectoryMetadataKey] != directorySynced {
+				continue
+			}
+			synced++
+			if !seen[r.OpenID] {
+				plan.Deactivate = append(plan.Deactivate, r)
+			}
+		}
+		percent := cfg.MaxDeactivatePercent
+		if percent <= 0 {
+			percent = DefaultMaxDeactivatePercent
+		}
+		if limit := max(minDeactivateLimit, synced*percent/100); len(plan.Deactivate) > limit {
+			return plan, fmt.Errorf("%w: %d of %d (limit %d)", ErrTooManyDeactivations, len(plan.Deactivate), synced, limit)
+		}
+	}
+	return plan, nil
+}
+
+// Apply carries out a plan from Plan, all of it or, on error, none of it
+func (d *DirectorySync) Apply(plan *models.DirectorySyncPlan) error {
+	for i := range plan.Deactivate {
+		r := &plan.Deactivate[i]
+		r.Metadata[DirectoryMetadataKey] = directoryRemoved
+		r.Deactivated = true
+	}
+	if err := d.repo.ApplyDirectorySync(plan.Create, plan.Update, plan.Deactivate); err != nil {
+		return fmt.Errorf("apply directory sync: %w", err)
+	}
+	plan.Applied = true
+	return nil
+}
+
+// fetch downloads the directory and flattens it into one field map per record
+func (d *DirectorySync) fetch(cfg models.DirectorySyncConfig) ([]map[string]string, error) {
+	switch cfg.Source {
+	case models.DirectorySourceCSV, models.DirectorySourceJSON:
+	case models.DirectorySourceLDAP:
+		return nil, ErrDirectoryUnsupported
+	default:
+		return nil, fmt.Errorf("unknown directory source %q", cfg.Source)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, cfg.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.AuthHeader != "" {
+		req.Header.Set("Authorization", cfg.AuthHeader)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("directory returned HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDirectorySize))
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Source == models.DirectorySourceCSV {
+		return parseDirectoryCSV(body)
+	}
+	return parseDirectoryJSON(body, cfg.ItemsPath)
+}
+
+// parseDirectoryCSV reads a CSV with a header row naming the columns
+func parseDirectoryCSV(body []byte) ([]map[string]string, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(body, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("CSV has no header row")
+	}
+	header := rows[0]
+	records := make([]map[string]string, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		rec := make(map[string]string, len(header))
+		for i, col := range header {
+			if i < len(row) {
+				rec[strings.TrimSpace(col)] = row[i]
+			}
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// parseDirectoryJSON reads the array of objects at itemsPath. Nested fields are flattened to
+// dotted paths (profile.name) so mappings can reach into them.
+func parseDirectoryJSON(body []byte, itemsPath string) ([]map[string]string, error) {
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if itemsPath != "" {
+		for _, part := range strings.Split(itemsPath, ".") {
+			obj, ok := doc.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("itemsPath %q not found", itemsPath)
+			}
+			doc = obj[part]
+		}
+	}
+	items, ok := doc.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("itemsPath %q is not an array", itemsPath)
+	}
+
+	records := make([]map[string]string, 0, len(items))
+	for _, item := range items {
+		rec := map[string]string{}
+		flattenJSON("", item, rec)
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+func flattenJSON(prefix string, v interface{}, out map[string]string) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, child := range val {
+			if prefix != "" {
+				k = prefix + "." + k
+			}
+			flattenJSON(k, child, out)
+		}
+	case nil:
+	case string:
+		out[prefix] = val
+	case json.Number:
+		out[prefix] = val.String()
+	default:
+		data, _ := json.Marshal(val)
+		out[prefix] = string(data)
+	}
+}
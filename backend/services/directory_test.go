@@ -0,0 +1,183 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+func TestDirectorySync(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	manual := &models.Recipient{OpenID: "manual", Name: "Manual"}
+	repo.Create(manual)
+	existing := &models.Recipient{OpenID: "o-bob", Name: "Bob"}
+	repo.Create(existing)
+
+	body := "open_id,full_name,dept\no-alice,Alice,ops\no-bob,Robert,dev\n,Nobody,x\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	cfg := models.DirectorySyncConfig{
+		Source: models.DirectorySourceCSV, URL: server.URL, AuthHeader: "Bearer t", Deactivate: true,
+		Mapping: models.DirectoryMapping{OpenID: "open_id", Name: "full_name", Metadata: map[string]string{"department": "dept"}},
+	}
+	sync := NewDirectorySync(repo)
+
+	plan, err := sync.Sync(cfg, true)
+	if err != nil {
+		t.Fatalf("dry run error: %v", err)
+	}
+	if len(plan.Create) != 1 || plan.Create[0].OpenID != "o-alice" || len(plan.Update) != 1 || plan.Update[0].Name != "Robert" ||
+		len(plan.Deactivate) != 0 || plan.Skipped != 1 || plan.Applied {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+	if all, _ := repo.GetAll(); len(all) != 2 {
+		t.Fatalf("dry run changed recipients: %+v", all)
+	}
+
+	if _, err := sync.Sync(cfg, false); err != nil {
+		t.Fatalf("sync error: %v", err)
+	}
+	alice, err := repo.GetByOpenID("o-alice")
+	if err != nil || alice.Name != "Alice" || alice.Metadata["department"] != "ops" || alice.Metadata[DirectoryMetadataKey] != "synced" {
+		t.Fatalf("alice not created as expected: %+v, %v", alice, err)
+	}
+
+	// Alice mutes herself, then leaves the directory: she is deactivated, the hand-added recipient is untouched
+	if _, err := repo.MuteRecipients([]int64{alice.ID}); err != nil {
+		t.Fatalf("MuteRecipients error: %v", err)
+	}
+	body = "open_id,full_name,dept\no-bob,Robert,dev\n"
+	plan, err = sync.Sync(cfg, false)
+	if err != nil {
+		t.Fatalf("sync error: %v", err)
+	}
+	if len(plan.Deactivate) != 1 || plan.Deactivate[0].OpenID != "o-alice" {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+	if alice, _ = repo.GetByOpenID("o-alice"); !alice.Deactivated || alice.Metadata[DirectoryMetadataKey] != "removed" {
+		t.Errorf("alice not deactivated: %+v", alice)
+	}
+	if m, _ := repo.GetByOpenID("manual"); m.Deactivated {
+		t.Errorf("hand-added recipient was deactivated")
+	}
+
+	// She comes back and is reactivated, still muted as she chose
+	body = "open_id,full_name,dept\no-alice,Alice,ops\no-bob,Robert,dev\n"
+	if _, err := sync.Sync(cfg, false); err != nil {
+		t.Fatalf("sync error: %v", err)
+	}
+	if alice, _ = repo.GetByOpenID("o-alice"); alice.Deactivated || !alice.Muted || alice.Metadata[DirectoryMetadataKey] != "synced" {
+		t.Errorf("alice not reactivated: %+v", alice)
+	}
+}
+
+func TestDirectorySyncGuards(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	body := "open_id,name\n"
+	for i := 0; i < 10; i++ {
+		body += fmt.Sprintf("o-%d,User %d\n", i, i)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	cfg := models.DirectorySyncConfig{Source: models.DirectorySourceCSV, URL: server.URL, Deactivate: true,
+		Mapping: models.DirectoryMapping{OpenID: "open_id"}}
+	sync := NewDirectorySync(repo)
+	if _, err := sync.Sync(cfg, false); err != nil {
+		t.Fatalf("sync error: %v", err)
+	}
+
+	// An export without records or with most of the directory gone deactivates nobody
+	body = "open_id,name\n"
+	if _, err := sync.Sync(cfg, false); !errors.Is(err, ErrDirectoryEmpty) {
+		t.Errorf("empty directory: expected ErrDirectoryEmpty, got %v", err)
+	}
+	body = "open_id,name\no-0,User 0\no-1,User 1\no-2,User 2\no-new,New\n"
+	plan, err := sync.Sync(cfg, false)
+	if !errors.Is(err, ErrTooManyDeactivations) || plan == nil || len(plan.Deactivate) != 7 || plan.Applied {
+		t.Fatalf("expected ErrTooManyDeactivations with the plan, got %+v, %v", plan, err)
+	}
+	for _, r := range mustGetAll(t, repo) {
+		if r.Deactivated {
+			t.Errorf("%s deactivated by an aborted sync", r.OpenID)
+		}
+	}
+
+	// Raising the limit lets it through, all at once
+	cfg.MaxDeactivatePercent = 100
+	if plan, err = sync.Sync(cfg, false); err != nil || !plan.Applied || plan.Create[0].ID == 0 {
+		t.Fatalf("sync with a raised limit: %+v, %v", plan, err)
+	}
+	deactivated := 0
+	for _, r := range mustGetAll(t, repo) {
+		if r.Deactivated {
+			deactivated++
+		}
+	}
+	if deactivated != 7 {
+		t.Errorf("deactivated %d recipients, want 7", deactivated)
+	}
+}
+
+func mustGetAll(t *testing.T, repo *repository.SQLiteRepository) []models.Recipient {
+	t.Helper()
+	all, err := repo.GetAll()
+	if err != nil {
+		t.Fatalf("GetAll error: %v", err)
+	}
+	return all
+}
+
+func TestDirectorySyncJSON(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":{"users":[{"wx":{"openid":"o-1"},"profile":{"name":"One"},"id":12345678901234567}]}}`))
+	}))
+	defer server.Close()
+
+	sync := NewDirectorySync(repo)
+	plan, err := sync.Sync(models.DirectorySyncConfig{
+		Source: models.DirectorySourceJSON, URL: server.URL, ItemsPath: "data.users",
+		Mapping: models.DirectoryMapping{OpenID: "wx.openid", Name: "profile.name", Metadata: map[string]string{"employeeId": "id"}},
+	}, true)
+	if err != nil {
+		t.Fatalf("Sync error: %v", err)
+	}
+	if len(plan.Create) != 1 || plan.Create[0].Name != "One" || plan.Create[0].Metadata["employeeId"] != "12345678901234567" {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+
+	if _, err := sync.Plan(models.DirectorySyncConfig{Source: models.DirectorySourceLDAP, URL: "ldap://example"}); !errors.Is(err, ErrDirectoryUnsupported) {
+		t.Errorf("expected ErrDirectoryUnsupported, got %v", err)
+	}
+}
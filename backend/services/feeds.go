@@ -0,0 +1,296 @@
+package services
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"wechat-notification/logger"
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+const (
+	// FeedPollTick is how often the poller checks for feeds that are due
+	FeedPollTick = 30 * time.Second
+	// MinFeedInterval is the shortest allowed polling interval for a feed
+	MinFeedInterval = 60
+	// maxFeedEntriesPerPoll caps notifications per poll so a feed reset can't flood recipients
+	maxFeedEntriesPerPoll = 10
+	// maxSeenFeedIDs bounds how many entry IDs are remembered per feed
+	maxSeenFeedIDs = 200
+)
+
+// ErrUnknownFeedFormat is returned when a document is neither RSS nor Atom
+var ErrUnknownFeedFormat = errors.New("unrecognized feed format, expected RSS or Atom")
+
+// FeedEntry is a normalized RSS item or Atom entry
+type FeedEntry struct {
+	ID        string
+	Title     string
+	Link      string
+	Summary   string
+	Published string
+}
+
+type rssDocument struct {
+	XMLName xml.Name `xml:"rss"`
+	Items   []struct {
+		GUID        string `xml:"guid"`
+		Title       string `xml:"title"`
+		Link        string `xml:"link"`
+		Description string `xml:"description"`
+		PubDate     string `xml:"pubDate"`
+	} `xml:"channel>item"`
+}
+
+type atomDocument struct {
+	XMLName xml.Name `xml:"feed"`
+	Entries []struct {
+		ID    string `xml:"id"`
+		Title string `xml:"title"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+		Summary string `xml:"summary"`
+		Content string `xml:"content"`
+		Updated string `xml:"updated"`
+	} `xml:"entry"`
+}
+
+// ParseFeed parses an RSS 2.0 or Atom document into entries, newest first as published
+func ParseFeed(data []byte) ([]FeedEntry, error) {
+	var rss rssDocument
+	if err := xml.Unmarshal(data, &rss); err == nil {
+		entries := make([]FeedEntry, 0, len(rss.Items))
+		for _, item := range rss.Items {
+			id := item.GUID
+			if id == "" {
+				id = item.Link
+			}
+			entries = append(entries, FeedEntry{
+				ID:        strings.TrimSpace(id),
+				Title:     strings.TrimSpace(item.Title),
+				Link:      strings.TrimSpace(item.Link),
+				Summary:   strings.TrimSpace(item.Description),
+				Published: strings.TrimSpace(item.PubDate),
+			})
+		}
+		return entries, nil
+	}
+
+	var atom atomDocument
+	if err := xml.Unmarshal(data, &atom); err == nil {
+		entries := make([]FeedEntry, 0, len(atom.Entries))
+		for _, e := range atom.Entries {
+			link := ""
+			for _, l := range e.Links {
+				if l.Rel == "" || l.Rel == "alternate" {
+					link = l.Href
+					break
+				}
+			}
+			summary := e.Summary
+			if summary == "" {
+				summary = e.Content
+			}
+			id := e.ID
+			if id == "" {
+				id = link
+			}
+			entries = append(entries, FeedEntry{
+				ID:        strings.TrimSpace(id),
+				Title:     strings.TrimSpace(e.Title),
+				Link:      strings.TrimSpace(link),
+				Summary:   strings.TrimSpace(summary),
+				Published: strings.TrimSpace(e.Updated),
+			})
+		}
+		return entries, nil
+	}
+
+	return nil, ErrUnknownFeedFormat
+}
+
+// FeedPoller polls configured feeds and sends new entries to their groups
+type FeedPoller struct {
+	repo       *repository.SQLiteRepository
+	sender     *Sender
+	httpClient HTTPClient
+
+	mu       sync.Mutex
+	failedAt map[int64]time.Time // Last failed fetch of each feed, which waits an interval like a poll
+}
+
+// NewFeedPoller creates a new feed poller
+func NewFeedPoller(repo *repository.SQLiteRepository, sender *Sender) *FeedPoller {
+	return &FeedPoller{
+		repo:       repo,
+		sender:     sender,
+		httpClient: &http.Client{Timeout: 15 * time.Second},
+		failedAt:   map[int64]time.Time{},
+	}
+}
+
+// Run polls due feeds until ctx is cancelled
+func (p *FeedPoller) Run(ctx context.Context) {
+	ticker := time.NewTicker(FeedPollTick)
+	defer ticker.Stop()
+
+	for {
+		p.pollDue(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *FeedPoller) pollDue(now time.Time) {
+	feeds, err := p.repo.GetAllFeeds()
+	if err != nil {
+		logger.Warnf("feed poller: failed to load feeds: %v", err)
+		return
+	}
+	for i := range feeds {
+		feed := &feeds[i]
+		if !feed.Enabled {
+			continue
+		}
+		interval := time.Duration(feed.IntervalSeconds) * time.Second
+		if feed.LastPolledAt != nil && now.Sub(*feed.LastPolledAt) < interval {
+			continue
+		}
+		p.mu.Lock()
+		failedAt, failed := p.failedAt[feed.ID]
+		p.mu.Unlock()
+		if failed && now.Sub(failedAt) < interval {
+			continue
+		}
+		if _, err := p.Poll(feed); err != nil {
+			logger.Warnf("feed poller: %s: %v", feed.Name, err)
+		}
+	}
+}
+
+// Poll fetches a feed once and notifies its groups of unseen entries.
+// The first poll of a feed only records existing entries so subscribing doesn't replay history.
+// Entries are marked seen once they're sent, so entries whose send failed or that didn't fit in
+// this poll go out with the next one; a failed fetch leaves the feed as it was.
+func (p *FeedPoller) Poll(feed *models.Feed) (int, error) {
+	entries, err := p.fetch(feed.URL)
+	p.mu.Lock()
+	if err != nil {
+		p.failedAt[feed.ID] = time.Now()
+	} else {
+		delete(p.failedAt, feed.ID)
+	}
+	p.mu.Unlock()
+	if err != nil {
+		p.repo.SetFeedError(feed.ID, err.Error())
+		return 0, err
+	}
+
+	seen := make(map[string]bool, len(feed.SeenIDs))
+	for _, id := range feed.SeenIDs {
+		seen[id] = true
+	}
+
+	var fresh []FeedEntry
+	for _, e := range entries {
+		if e.ID != "" && !seen[e.ID] {
+			fresh = append(fresh, e)
+		}
+	}
+
+	logger.Debugf("feed poller: %s: %d entries, %d unseen", feed.Name, len(entries), len(fresh))
+
+	first := feed.LastPolledAt == nil
+	sent := 0
+	sentIDs := map[string]bool{}
+	var sendErr error
+	if !first && len(fresh) > 0 {
+		// Feeds list newest first; notify oldest first and cap the burst
+		if len(fresh) > maxFeedEntriesPerPoll {
+			fresh = fresh[:maxFeedEntriesPerPoll]
+		}
+		for i := len(fresh) - 1; i >= 0; i-- {
+			if err := p.notify(feed, fresh[i]); err != nil {
+				sendErr = err
+				break
+			}
+			sentIDs[fresh[i].ID] = true
+			sent++
+		}
+	}
+
+	// Entries still in the feed come first so trimming drops the oldest
+	seenIDs := make([]string, 0, len(entries)+len(feed.SeenIDs))
+	kept := map[string]bool{}
+	for _, e := range entries {
+		if e.ID != "" && !kept[e.ID] && (first || seen[e.ID] || sentIDs[e.ID]) {
+			seenIDs = append(seenIDs, e.ID)
+			kept[e.ID] = true
+		}
+	}
+	for _, id := range feed.SeenIDs {
+		if !kept[id] {
+			seenIDs = append(seenIDs, id)
+			kept[id] = true
+		}
+	}
+	if len(seenIDs) > maxSeenFeedIDs {
+		seenIDs = seenIDs[:maxSeenFeedIDs]
+	}
+
+	lastError := ""
+	if sendErr != nil {
+		lastError = sendErr.Error()
+	}
+	if err := p.repo.UpdateFeedPollState(feed.ID, time.Now(), lastError, seenIDs); err != nil {
+		return sent, err
+	}
+	return sent, sendErr
+}
+
+func (p *FeedPoller) fetch(url string) ([]FeedEntry, error) {
+	resp, err := p.httpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch feed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed returned HTTP %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 5<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feed: %w", err)
+	}
+	return ParseFeed(body)
+}
+
+func (p *FeedPoller) notify(feed *models.Feed, entry FeedEntry) error {
+	template, err := p.repo.GetTemplateByKey(feed.TemplateKey)
+	if err != nil {
+		return fmt.Errorf("template %q: %w", feed.TemplateKey, err)
+	}
+
+	keywords := RenderKeywords(feed.Keywords, map[string]string{
+		"title":     entry.Title,
+		"link":      entry.Link,
+		"summary":   TruncateRunes(entry.Summary, MaxKeywordLength),
+		"published": entry.Published,
+		"feed":      feed.Name,
+	})
+	_, err = p.sender.SendToGroups(context.Background(), template, feed.GroupIDs, keywords, SendOptions{})
+	return err
+}
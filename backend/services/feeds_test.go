@@ -0,0 +1,109 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+func TestParseFeed(t *testing.T) {
+	rss := []byte(`<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Blog</title>
+<item><guid>a-2</guid><title>Second</title><link>https://example.com/2</link><description>two</description></item>
+<item><title>First</title><link>https://example.com/1</link></item>
+</channel></rss>`)
+
+	entries, err := ParseFeed(rss)
+	if err != nil {
+		t.Fatalf("ParseFeed(rss) error: %v", err)
+	}
+	if len(entries) != 2 || entries[0].ID != "a-2" || entries[0].Summary != "two" {
+		t.Errorf("unexpected rss entries: %+v", entries)
+	}
+	if entries[1].ID != "https://example.com/1" {
+		t.Errorf("rss item without guid should fall back to link, got %q", entries[1].ID)
+	}
+
+	atom := []byte(`<?xml version="1.0"?>
+<feed xmlns="http://www.w3.org/2005/Atom"><title>Releases</title>
+<entry><id>tag:v1</id><title>v1.0</title>
+<link rel="self" href="https://example.com/self"/><link href="https://example.com/v1"/>
+<content>notes</content><updated>2024-01-01T00:00:00Z</updated></entry>
+</feed>`)
+
+	entries, err = ParseFeed(atom)
+	if err != nil {
+		t.Fatalf("ParseFeed(atom) error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Link != "https://example.com/v1" || entries[0].Summary != "notes" {
+		t.Errorf("unexpected atom entries: %+v", entries)
+	}
+
+	if _, err := ParseFeed([]byte(`<html></html>`)); err != ErrUnknownFeedFormat {
+		t.Errorf("ParseFeed(html) error = %v, want ErrUnknownFeedFormat", err)
+	}
+}
+
+func TestFeedPollKeepsUnsentEntries(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	items := `<item><guid>a-1</guid><title>First</title></item>`
+	down := true
+	client := &MockHTTPClient{GetFunc: func(url string) (*http.Response, error) {
+		if down {
+			return nil, errors.New("connection refused")
+		}
+		body := `<?xml version="1.0"?><rss version="2.0"><channel>` + items + `</channel></rss>`
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}}
+	tokens := NewTokenManager("app", "secret")
+	poller := NewFeedPoller(repo, NewSender(repo, NewWeChatService(tokens, "tpl"), ""))
+	poller.httpClient = client
+
+	feed := &models.Feed{Name: "blog", URL: "https://example.com/feed", IntervalSeconds: 60, TemplateKey: "news", Enabled: true}
+	if err := repo.CreateFeed(feed); err != nil {
+		t.Fatalf("CreateFeed error: %v", err)
+	}
+	reload := func() *models.Feed {
+		f, err := repo.GetFeedByID(feed.ID)
+		if err != nil {
+			t.Fatalf("GetFeedByID error: %v", err)
+		}
+		return f
+	}
+
+	// A failed fetch leaves the feed unpolled, so the first successful poll still skips history
+	if _, err := poller.Poll(reload()); err == nil {
+		t.Fatal("expected a fetch error")
+	}
+	if f := reload(); f.LastPolledAt != nil || f.LastError == "" {
+		t.Fatalf("failed fetch changed the poll state: %+v", f)
+	}
+	down = false
+	if sent, err := poller.Poll(reload()); sent != 0 || err != nil {
+		t.Fatalf("first poll sent %d, error %v", sent, err)
+	}
+
+	// The template is missing, so the new entry fails and stays unseen
+	items = `<item><guid>a-2</guid><title>Second</title></item>` + items
+	if _, err := poller.Poll(reload()); err == nil {
+		t.Fatal("expected a send error")
+	}
+	if f := reload(); len(f.SeenIDs) != 1 || f.SeenIDs[0] != "a-1" {
+		t.Fatalf("unsent entry marked seen: %v", f.SeenIDs)
+	}
+	repo.CreateTemplate(&models.MessageTemplate{Key: "news", TemplateID: "tpl", Name: "资讯"})
+	if sent, err := poller.Poll(reload()); sent != 1 || err != nil {
+		t.Fatalf("retry sent %d, error %v", sent, err)
+	}
+}
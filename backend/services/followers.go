@@ -0,0 +1,296 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+const (
+	// WeChatUserListURL is the URL to list the followers of an official account, 10000 per page
+	WeChatUserListURL = "https://api.weixin.qq.com/cgi-bin/user/get"
+	// WeChatUserInfoBatchURL is the URL to get the basic info of several followers at once
+	WeChatUserInfoBatchURL = "https://api.weixin.qq.com/cgi-bin/user/info/batchget"
+	// MaxUserInfoBatch is how many followers user/info/batchget accepts per call
+	MaxUserInfoBatch = 100
+)
+
+// ErrNotFollower is returned when fetching the profile of a user who doesn't follow the account
+var ErrNotFollower = errors.New("user does not follow the official account")
+
+// Followers lists the OpenIDs of everyone following the account, paging through the user list
+func (s *WeChatService) Followers() ([]string, error) {
+	var openIDs []string
+	next := ""
+	for {
+		token, err := s.tokenManager.GetAccessToken()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get access token: %w", err)
+		}
+		resp, err := s.httpClient.Get(fmt.Sprintf("%s?access_token=%s&next_openid=%s", WeChatUserListURL, token, url.QueryEscape(next)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list followers: %w", withoutURL(err))
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response: %w", err)
+		}
+		var result struct {
+			models.WeChatAPIResponse
+			Total int `json:"total"`
+			Count int `json:"count"`
+			Data  struct {
+				OpenID []string `json:"openid"`
+			} `json:"data"`
+			NextOpenID string `json:"next_openid"`
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("failed to parse response: %w", err)
+		}
+		if result.ErrCode != 0 {
+			return nil, &APIError{ErrCode: result.ErrCode, ErrMsg: result.ErrMsg}
+		}
+		openIDs = append(openIDs, result.Data.OpenID...)
+		// The last page is empty or ends where it started
+		if result.Count == 0 || result.NextOpenID == "" || result.NextOpenID == next || len(openIDs) >= result.Total {
+			return openIDs, nil
+		}
+		next = result.NextOpenID
+	}
+}
+
+// SyncFollowers compares the followers of an official account with the recipients of that account
+// and, unless dryRun is set, registers new followers and flags recipients who no longer follow it
+func SyncFollowers(repo *repository.SQLiteRepository, apps *AppRegistry, appID int64, dryRun bool) (*models.FollowerSyncReport, error) {
+	wechatSvc, err := apps.Service(appID)
+	if err != nil {
+		return nil, err
+	}
+	followers, err := wechatSvc.Followers()
+	if err != nil {
+		return nil, err
+	}
+	existing, err := repo.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.FollowerSyncReport{AppID: appID, New: []models.Recipient{}, Removed: []models.Recipient{}, Returned: []models.Recipient{}}
+	known := make(map[string]bool, len(existing))
+	for _, r := range existing {
+		known[r.OpenID] = true
+	}
+	following := make(map[string]bool, len(followers))
+	for _, openID := range followers {
+		if openID == "" || following[openID] {
+			continue
+		}
+		following[openID] = true
+		if !known[openID] {
+			report.New = append(report.New, models.Recipient{
+				OpenID:   openID,
+				AppID:    appID,
+				Name:     followerName(openID),
+				Metadata: map[string]string{SubscribeMetadataKey: "sync"},
+			})
+		}
+	}
+	report.Followers = len(following)
+	for _, r := range existing {
+		switch {
+		case r.AppID != appID:
+		case !following[r.OpenID] && !r.Unsubscribed:
+			report.Removed = append(report.Removed, r)
+		case following[r.OpenID] && r.Unsubscribed:
+			report.Returned = append(report.Returned, r)
+		}
+	}
+	if dryRun {
+		return report, nil
+	}
+
+	for i := range report.New {
+		if err := repo.Create(&report.New[i]); err != nil {
+			return report, fmt.Errorf("create %s: %w", report.New[i].OpenID, err)
+		}
+	}
+	for i := range report.Removed {
+		if _, err := repo.SetUnsubscribed(report.Removed[i].OpenID, true); err != nil {
+			return report, fmt.Errorf("flag %s: %w", report.Removed[i].OpenID, err)
+		}
+		report.Removed[i].Unsubscribed = true
+	}
+	for i := range report.Returned {
+		if _, err := repo.SetUnsubscribed(report.Returned[i].OpenID, false); err != nil {
+			return report, fmt.Errorf("unflag %s: %w", report.Returned[i].OpenID, err)
+		}
+		report.Returned[i].Unsubscribed = false
+	}
+	report.Applied = true
+	return report, nil
+}
+
+// UserInfos gets the basic info of up to MaxUserInfoBatch followers in one call. WeChat rejects
+// the whole call when any OpenID is invalid.
+func (s *WeChatService) UserInfos(openIDs []string) ([]models.WeChatUserInfo, error) {
+	token, err := s.tokenManager.GetAccessToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	users := make([]map[string]string, len(openIDs))
+	for i, openID := range openIDs {
+		users[i] = map[string]string{"openid": openID, "lang": "zh_CN"}
+	}
+	payload, _ := json.Marshal(map[string]interface{}{"user_list": users})
+	resp, err := s.httpClient.Post(fmt.Sprintf("%s?access_token=%s", WeChatUserInfoBatchURL, token), "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user info: %w", withoutURL(err))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	var result struct {
+		models.WeChatAPIResponse
+		UserInfoList []models.WeChatUserInfo `json:"user_info_list"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if result.ErrCode != 0 {
+		return nil, &APIError{ErrCode: result.ErrCode, ErrMsg: result.ErrMsg}
+	}
+	return result.UserInfoList, nil
+}
+
+// RefreshWeChatProfile fetches the nickname, avatar and language of a recipient from WeChat and
+// stores them. A recipient still named after their OpenID is renamed to their nickname.
+func RefreshWeChatProfile(repo *repository.SQLiteRepository, apps *AppRegistry, r *models.Recipient, now time.Time) error {
+	wechatSvc, err := apps.Service(r.AppID)
+	if err != nil {
+		return err
+	}
+	info, err := wechatSvc.UserInfo(r.OpenID)
+	if err != nil {
+		return err
+	}
+	return storeWeChatProfile(repo, r, info, now)
+}
+
+// storeWeChatProfile saves a fetched profile on a recipient, or returns ErrNotFollower for users
+// who don't follow the account
+func storeWeChatProfile(repo *repository.SQLiteRepository, r *models.Recipient, info *models.WeChatUserInfo, now time.Time) error {
+	if info.Subscribe == 0 {
+		return ErrNotFollower
+	}
+	applyWeChatProfile(r, info, now)
+	if err := repo.SetWeChatProfile(r); err != nil {
+		return err
+	}
+	if nickname := strings.TrimSpace(r.Nickname); nickname != "" && (r.Name == followerName(r.OpenID) || r.Name == r.OpenID) {
+		r.Name = nickname
+		return repo.UpdateProfile(r)
+	}
+	return nil
+}
+
+// RefreshWeChatProfiles refreshes the WeChat profile of each recipient, carrying on past failures.
+// Profiles are fetched MaxUserInfoBatch at a time per official account.
+func RefreshWeChatProfiles(repo *repository.SQLiteRepository, apps *AppRegistry, recipients []models.Recipient, now time.Time) models.ProfileRefreshReport {
+	report := models.ProfileRefreshReport{Errors: []string{}}
+	record := func(r *models.Recipient, err error) {
+		switch {
+		case err == nil:
+			report.Refreshed++
+		case errors.Is(err, ErrNotFollower):
+			report.NotFollowing++
+		default:
+			report.Failed++
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", r.OpenID, err))
+		}
+	}
+
+	var appIDs []int64
+	byApp := map[int64][]*models.Recipient{}
+	for i := range recipients {
+		appID := recipients[i].AppID
+		if _, ok := byApp[appID]; !ok {
+			appIDs = append(appIDs, appID)
+		}
+		byApp[appID] = append(byApp[appID], &recipients[i])
+	}
+	for _, appID := range appIDs {
+		pending := byApp[appID]
+		wechatSvc, err := apps.Service(appID)
+		if err != nil {
+			for _, r := range pending {
+				record(r, err)
+			}
+			continue
+		}
+		for start := 0; start < len(pending); start += MaxUserInfoBatch {
+			refreshProfileBatch(repo, wechatSvc, pending[start:min(start+MaxUserInfoBatch, len(pending))], now, record)
+		}
+	}
+	return report
+}
+
+// refreshProfileBatch fetches and stores the profiles of one batch of recipients. A batch WeChat
+// rejects, for instance over one invalid OpenID, is fetched again one recipient at a time.
+func refreshProfileBatch(repo *repository.SQLiteRepository, wechatSvc *WeChatService, batch []*models.Recipient, now time.Time, record func(*models.Recipient, error)) {
+	openIDs := make([]string, len(batch))
+	for i, r := range batch {
+		openIDs[i] = r.OpenID
+	}
+	infos, err := wechatSvc.UserInfos(openIDs)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && len(batch) > 1 {
+		for _, r := range batch {
+			info, err := wechatSvc.UserInfo(r.OpenID)
+			if err == nil {
+				err = storeWeChatProfile(repo, r, info, now)
+			}
+			record(r, err)
+		}
+		return
+	}
+	if err != nil {
+		for _, r := range batch {
+			record(r, err)
+		}
+		return
+	}
+
+	byOpenID := make(map[string]*models.WeChatUserInfo, len(infos))
+	for i := range infos {
+		byOpenID[infos[i].OpenID] = &infos[i]
+	}
+	for _, r := range batch {
+		info, ok := byOpenID[r.OpenID]
+		if !ok {
+			record(r, errors.New("no profile returned"))
+			continue
+		}
+		record(r, storeWeChatProfile(repo, r, info, now))
+	}
+}
+
+// applyWeChatProfile copies the profile fields of a user info response to a recipient
+func applyWeChatProfile(r *models.Recipient, info *models.WeChatUserInfo, now time.Time) {
+	r.Nickname = info.Nickname
+	r.AvatarURL = info.HeadImgURL
+	r.Language = info.Language
+	r.ProfileSyncedAt = &now
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+func TestSyncFollowers(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	// Two pages, then an empty one
+	pages := map[string]string{
+		"":        `{"total":3,"count":2,"data":{"openid":["o-kept","o-new-1"]},"next_openid":"o-new-1"}`,
+		"o-new-1": `{"total":3,"count":1,"data":{"openid":["o-back"]},"next_openid":"o-back"}`,
+	}
+	client := &MockHTTPClient{GetFunc: func(u string) (*http.Response, error) {
+		if !strings.HasPrefix(u, WeChatUserListURL+"?") {
+			t.Errorf("unexpected request to %s", u)
+		}
+		parsed, _ := url.Parse(u)
+		body, ok := pages[parsed.Query().Get("next_openid")]
+		if !ok {
+			body = `{"total":3,"count":0,"next_openid":""}`
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}}
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("token", time.Hour)
+	apps := NewAppRegistry(NewWeChatServiceWithClient(tokens, "tpl", client))
+
+	for _, r := range []*models.Recipient{
+		{OpenID: "o-kept", Name: "留下"},
+		{OpenID: "o-gone", Name: "离开"},
+		{OpenID: "o-back", Name: "回来"},
+		{OpenID: "o-other-app", Name: "其他公众号", AppID: 7},
+	} {
+		if err := repo.Create(r); err != nil {
+			t.Fatalf("Create error: %v", err)
+		}
+	}
+	repo.SetUnsubscribed("o-back", true)
+
+	report, err := SyncFollowers(repo, apps, 0, true)
+	if err != nil {
+		t.Fatalf("SyncFollowers error: %v", err)
+	}
+	if report.Followers != 3 || len(report.New) != 1 || report.New[0].OpenID != "o-new-1" || report.Applied {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if len(report.Removed) != 1 || report.Removed[0].OpenID != "o-gone" || len(report.Returned) != 1 || report.Returned[0].OpenID != "o-back" {
+		t.Errorf("unexpected removed %+v / returned %+v", report.Removed, report.Returned)
+	}
+	if _, err := repo.GetByOpenID("o-new-1"); err != repository.ErrNotFound {
+		t.Errorf("dry run created a recipient: %v", err)
+	}
+
+	if report, err = SyncFollowers(repo, apps, 0, false); err != nil || !report.Applied {
+		t.Fatalf("SyncFollowers error: %v", err)
+	}
+	if r, err := repo.GetByOpenID("o-new-1"); err != nil || r.Name != "微信用户 -new-1" || r.Metadata[SubscribeMetadataKey] != "sync" {
+		t.Errorf("unexpected new recipient %+v: %v", r, err)
+	}
+	if r, _ := repo.GetByOpenID("o-gone"); !r.Unsubscribed {
+		t.Error("o-gone should be flagged as unsubscribed")
+	}
+	if r, _ := repo.GetByOpenID("o-back"); r.Unsubscribed {
+		t.Error("o-back should no longer be flagged")
+	}
+	if r, _ := repo.GetByOpenID("o-other-app"); r.Unsubscribed {
+		t.Error("recipients of other accounts should be left alone")
+	}
+
+	// A second sync has nothing left to do
+	if report, _ = SyncFollowers(repo, apps, 0, true); len(report.New)+len(report.Removed)+len(report.Returned) != 0 {
+		t.Errorf("expected no changes, got %+v", report)
+	}
+}
+
+func TestRefreshWeChatProfiles(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	profiles := map[string]string{
+		"o-follower-123456": `{"subscribe":1,"openid":"o-follower-123456","nickname":"小王","headimgurl":"http://thirdwx.qlogo.cn/mmopen/x/132","language":"zh_CN"}`,
+		"o-gone":            `{"subscribe":0,"openid":"o-gone"}`,
+	}
+	gets, posts := 0, 0
+	client := &MockHTTPClient{GetFunc: func(u string) (*http.Response, error) {
+		gets++
+		parsed, _ := url.Parse(u)
+		body, ok := profiles[parsed.Query().Get("openid")]
+		if !ok {
+			body = `{"errcode":40003,"errmsg":"invalid openid"}`
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}, PostFunc: func(u, contentType string, body io.Reader) (*http.Response, error) {
+		posts++
+		if !strings.HasPrefix(u, WeChatUserInfoBatchURL+"?") {
+			t.Errorf("unexpected request to %s", u)
+		}
+		var req struct {
+			UserList []struct {
+				OpenID string `json:"openid"`
+			} `json:"user_list"`
+		}
+		json.NewDecoder(body).Decode(&req)
+		var list []string
+		for _, user := range req.UserList {
+			profile, ok := profiles[user.OpenID]
+			if !ok {
+				// One invalid OpenID fails the whole batch
+				return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"errcode":40003,"errmsg":"invalid openid"}`))}, nil
+			}
+			list = append(list, profile)
+		}
+		resp := `{"user_info_list":[` + strings.Join(list, ",") + `]}`
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(resp))}, nil
+	}}
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("token", time.Hour)
+	apps := NewAppRegistry(NewWeChatServiceWithClient(tokens, "tpl", client))
+
+	for _, r := range []*models.Recipient{
+		{OpenID: "o-follower-123456", Name: followerName("o-follower-123456")},
+		{OpenID: "o-gone", Name: "离开"},
+		{OpenID: "o-bad", Name: "无效"},
+	} {
+		if err := repo.Create(r); err != nil {
+			t.Fatalf("Create error: %v", err)
+		}
+	}
+	recipients, _ := repo.GetAll()
+	now := time.Now()
+	report := RefreshWeChatProfiles(repo, apps, recipients, now)
+	if report.Refreshed != 1 || report.NotFollowing != 1 || report.Failed != 1 || len(report.Errors) != 1 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	// The placeholder name gives way to the nickname
+	r, _ := repo.GetByOpenID("o-follower-123456")
+	if r.Name != "小王" || r.Nickname != "小王" || r.AvatarURL != "http://thirdwx.qlogo.cn/mmopen/x/132" || r.Language != "zh_CN" {
+		t.Errorf("unexpected recipient: %+v", r)
+	}
+	if r.ProfileSyncedAt == nil || !r.ProfileSyncedAt.Equal(now) {
+		t.Errorf("expected profileSyncedAt %v, got %v", now, r.ProfileSyncedAt)
+	}
+	if r, _ := repo.GetByOpenID("o-gone"); r.ProfileSyncedAt != nil || r.Name != "离开" {
+		t.Errorf("unfollowed recipient should be left alone: %+v", r)
+	}
+
+	// Without the invalid OpenID the whole batch takes one call
+	gets, posts = 0, 0
+	report = RefreshWeChatProfiles(repo, apps, recipients[:2], now)
+	if report.Refreshed != 1 || report.NotFollowing != 1 || posts != 1 || gets != 0 {
+		t.Errorf("batch refresh: %+v, %d batch calls, %d single calls", report, posts, gets)
+	}
+}
+
+// failingClient fails every request the way net/http does, with the request URL in the error
+func failingClient() *MockHTTPClient {
+	fail := func(u string) (*http.Response, error) {
+		return nil, &url.Error{Op: "Get", URL: u, Err: errors.New("connection refused")}
+	}
+	return &MockHTTPClient{GetFunc: fail, PostFunc: func(u, contentType string, body io.Reader) (*http.Response, error) {
+		return fail(u)
+	}}
+}
+
+func TestFollowersHidesToken(t *testing.T) {
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("tok_s3cret", time.Hour)
+	_, err := NewWeChatServiceWithClient(tokens, "tpl", failingClient()).Followers()
+	if err == nil || strings.Contains(err.Error(), "tok_s3cret") {
+		t.Errorf("Followers error = %v, want one without the access token", err)
+	}
+}
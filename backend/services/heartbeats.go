@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"wechat-notification/logger"
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+const (
+	// HeartbeatCheckTick is how often the checker looks for missed heartbeats
+	HeartbeatCheckTick = 15 * time.Second
+	// MinHeartbeatInterval is the shortest allowed expected ping interval
+	MinHeartbeatInterval = 60
+)
+
+// HeartbeatChecker flags heartbeats whose pings stopped and notifies their groups
+type HeartbeatChecker struct {
+	repo   *repository.SQLiteRepository
+	sender *Sender
+}
+
+// NewHeartbeatChecker creates a new heartbeat checker
+func NewHeartbeatChecker(repo *repository.SQLiteRepository, sender *Sender) *HeartbeatChecker {
+	return &HeartbeatChecker{repo: repo, sender: sender}
+}
+
+// HeartbeatDeadline returns when the next ping is due, counting from creation until the first ping arrives
+func HeartbeatDeadline(h *models.Heartbeat) time.Time {
+	last := h.CreatedAt
+	if h.LastPingAt != nil {
+		last = *h.LastPingAt
+	}
+	return last.Add(time.Duration(h.IntervalSeconds+h.GraceSeconds) * time.Second)
+}
+
+// Run checks for missed heartbeats until ctx is cancelled
+func (hc *HeartbeatChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(HeartbeatCheckTick)
+	defer ticker.Stop()
+
+	for {
+		hc.checkDue(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (hc *HeartbeatChecker) checkDue(now time.Time) {
+	heartbeats, err := hc.repo.GetAllHeartbeats()
+	if err != nil {
+		logger.Warnf("heartbeat checker: failed to load heartbeats: %v", err)
+		return
+	}
+	for i := range heartbeats {
+		h := &heartbeats[i]
+		if !h.Enabled || h.Status == models.MonitorStatusDown || now.Before(HeartbeatDeadline(h)) {
+			continue
+		}
+		if err := hc.repo.MarkHeartbeatDown(h.ID, now); err != nil {
+			logger.Warnf("heartbeat checker: %s: %v", h.Name, err)
+			continue
+		}
+		if err := hc.notify(h, models.MonitorStatusDown, now); err != nil {
+			logger.Warnf("heartbeat checker: %s: %v", h.Name, err)
+		}
+	}
+}
+
+// Ping records a ping for a heartbeat and sends a recovery notification if it was down
+func (hc *HeartbeatChecker) Ping(h *models.Heartbeat) error {
+	now := time.Now()
+	recovered := h.Status == models.MonitorStatusDown
+	if err := hc.repo.RecordHeartbeatPing(h.ID, now, h.Status != models.MonitorStatusUp); err != nil {
+		return err
+	}
+	if recovered && h.Enabled {
+		h.LastPingAt = &now
+		return hc.notify(h, models.MonitorStatusUp, now)
+	}
+	return nil
+}
+
+func (hc *HeartbeatChecker) notify(h *models.Heartbeat, status string, checkedAt time.Time) error {
+	template, err := hc.repo.GetTemplateByKey(h.TemplateKey)
+	if err != nil {
+		return fmt.Errorf("template %q: %w", h.TemplateKey, err)
+	}
+
+	lastPing := "never"
+	if h.LastPingAt != nil {
+		lastPing = h.LastPingAt.Format("2006-01-02 15:04:05")
+	}
+	label := "DOWN"
+	if status == models.MonitorStatusUp {
+		label = "UP"
+	}
+	keywords := RenderKeywords(h.Keywords, map[string]string{
+		"heartbeat": h.Name,
+		"status":    label,
+		"lastPing":  lastPing,
+		"checkedAt": checkedAt.Format("2006-01-02 15:04:05"),
+	})
+	_, err = hc.sender.SendToGroups(context.Background(), template, h.GroupIDs, keywords, SendOptions{})
+	return err
+}
@@ -0,0 +1,23 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+)
+
+func TestHeartbeatDeadline(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := &models.Heartbeat{IntervalSeconds: 3600, GraceSeconds: 300, CreatedAt: created}
+
+	if got, want := HeartbeatDeadline(h), created.Add(65*time.Minute); !got.Equal(want) {
+		t.Errorf("deadline before first ping = %s, want %s", got, want)
+	}
+
+	pinged := created.Add(2 * time.Hour)
+	h.LastPingAt = &pinged
+	if got, want := HeartbeatDeadline(h), pinged.Add(65*time.Minute); !got.Equal(want) {
+		t.Errorf("deadline after ping = %s, want %s", got, want)
+	}
+}
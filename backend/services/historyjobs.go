@@ -0,0 +1,303 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"wechat-notification/logger"
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+// Bulk actions over the message history
+const (
+	HistoryActionRequeue = "requeue" // Queue a retry for every retryable WeChat failure that wasn't retried yet
+	HistoryActionPurge   = "purge"   // Delete the messages
+	HistoryActionExport  = "export"  // Write the messages to a CSV file
+)
+
+// History job statuses
+const (
+	HistoryJobRunning = "running"
+	HistoryJobDone    = "done"
+	HistoryJobFailed  = "failed"
+)
+
+const (
+	// historyJobBatch is how many messages a job handles between progress updates
+	historyJobBatch = 100
+	// maxHistoryJobs is how many jobs are kept; the oldest finished ones are forgotten first
+	maxHistoryJobs = 50
+)
+
+// ErrUnknownHistoryAction is returned when a bulk action isn't requeue, purge or export
+var ErrUnknownHistoryAction = errors.New("action must be requeue, purge or export")
+
+// HistoryJob is a bulk action over the message history running in the background
+type HistoryJob struct {
+	ID         int64      `json:"id"`
+	Action     string     `json:"action"`
+	Status     string     `json:"status"`
+	Total      int        `json:"total"`     // Messages matched by the filter
+	Processed  int        `json:"processed"` // Messages handled so far
+	Affected   int        `json:"affected"`  // Retries queued, messages deleted or messages exported
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+
+	export []byte
+}
+
+// HistoryJobs runs bulk actions over filtered message history and keeps their progress in memory
+type HistoryJobs struct {
+	repo   *repository.SQLiteRepository
+	sender *Sender
+
+	mu     sync.Mutex
+	jobs   map[int64]*HistoryJob
+	nextID int64
+}
+
+// NewHistoryJobs creates a new history job runner
+func NewHistoryJobs(repo *repository.SQLiteRepository, sender *Sender) *HistoryJobs {
+	return &HistoryJobs{repo: repo, sender: sender, jobs: map[int64]*HistoryJob{}}
+}
+
+// Start selects the messages matching filter and runs the action over them in the background.
+// Requeueing only looks at messages with failures, whatever the filter says.
+func (h *HistoryJobs) Start(action string, filter repository.MessageFilter) (HistoryJob, error) {
+	switch action {
+	case HistoryActionRequeue:
+		filter.FailedOnly = true
+	case HistoryActionPurge, HistoryActionExport:
+	default:
+		return HistoryJob{}, ErrUnknownHistoryAction
+	}
+	ids, err := h.repo.ListMessageIDs(filter)
+	if err != nil {
+		return HistoryJob{}, err
+	}
+
+	h.mu.Lock()
+	h.nextID++
+	job := &HistoryJob{ID: h.nextID, Action: action, Status: HistoryJobRunning, Total: len(ids), CreatedAt: time.Now()}
+	h.jobs[job.ID] = job
+	h.prune()
+	snapshot := *job
+	h.mu.Unlock()
+
+	go h.run(job, ids)
+	return snapshot, nil
+}
+
+// Get returns the current state of a job
+func (h *HistoryJobs) Get(id int64) (HistoryJob, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	job, ok := h.jobs[id]
+	if !ok {
+		return HistoryJob{}, false
+	}
+	return *job, true
+}
+
+// List returns every job, newest first
+func (h *HistoryJobs) List() []HistoryJob {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	jobs := make([]HistoryJob, 0, len(h.jobs))
+	for _, job := range h.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID > jobs[j].ID })
+	return jobs
+}
+
+// Export returns the CSV written by a finished export job
+func (h *HistoryJobs) Export(id int64) ([]byte, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	job, ok := h.jobs[id]
+	if !ok || job.Action != HistoryActionExport || job.Status != HistoryJobDone {
+		return nil, false
+	}
+	return job.export, true
+}
+
+// prune forgets the oldest finished jobs beyond maxHistoryJobs. The caller holds h.mu.
+func (h *HistoryJobs) prune() {
+	if len(h.jobs) <= maxHistoryJobs {
+		return
+	}
+	var finished []int64
+	for id, job := range h.jobs {
+		if job.Status != HistoryJobRunning {
+			finished = append(finished, id)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i] < finished[j] })
+	for _, id := range finished {
+		if len(h.jobs) <= maxHistoryJobs {
+			return
+		}
+		delete(h.jobs, id)
+	}
+}
+
+func (h *HistoryJobs) run(job *HistoryJob, ids []int64) {
+	var export *csv.Writer
+	var buf bytes.Buffer
+	if job.Action == HistoryActionExport {
+		export = csv.NewWriter(&buf)
+		export.Write([]string{"id", "createdAt", "templateKey", "adapter", "tokenName", "totalCount", "totalSent", "totalFailed", "totalSkipped", "keywords"})
+	}
+
+	var err error
+	for start := 0; start < len(ids) && err == nil; start += historyJobBatch {
+		batch := ids[start:min(start+historyJobBatch, len(ids))]
+		affected := 0
+		switch job.Action {
+		case HistoryActionRequeue:
+			affected, err = h.requeue(batch)
+		case HistoryActionPurge:
+			var n int64
+			n, err = h.repo.DeleteMessages(batch)
+			affected = int(n)
+		case HistoryActionExport:
+			affected, err = h.export(export, batch)
+		}
+
+		h.mu.Lock()
+		job.Processed += len(batch)
+		job.Affected += affected
+		h.mu.Unlock()
+	}
+	if err == nil && export != nil {
+		export.Flush()
+		err = export.Error()
+	}
+
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	job.FinishedAt = &now
+	if err != nil {
+		job.Status = HistoryJobFailed
+		job.Error = err.Error()
+		logger.Warnf("history job %d (%s): %v", job.ID, job.Action, err)
+		return
+	}
+	job.Status = HistoryJobDone
+	job.export = buf.Bytes()
+	logger.Infof("history job %d (%s): %d of %d messages affected", job.ID, job.Action, job.Affected, job.Total)
+}
+
+// requeue queues a retry for each failed recipient of the messages that isn't already queued,
+// and marks them queued in the history so running the action again doesn't send twice.
+// Only WeChat failures are retried, through the account the message went out on, and not those
+// WeChat will refuse again, like invalid or unfollowed OpenIDs. Messages whose template was
+// deleted since and recipients who moved to another account are left alone.
+func (h *HistoryJobs) requeue(ids []int64) (int, error) {
+	queued := 0
+	for _, id := range ids {
+		m, err := h.repo.GetMessageByID(id)
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return queued, err
+		}
+		template, err := h.repo.GetTemplateByKey(m.TemplateKey)
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return queued, err
+		}
+		var results []SendResult
+		if err := json.Unmarshal(m.Results, &results); err != nil {
+			continue
+		}
+
+		changed := false
+		for i, r := range results {
+			if !retryableResult(r) {
+				continue
+			}
+			recipient, err := h.repo.GetByID(r.RecipientID)
+			if errors.Is(err, repository.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return queued, err
+			}
+			if recipient.AppID != m.AppID {
+				continue
+			}
+			msg, err := h.sender.Preview(template, recipient, m.Keywords, m.AppID)
+			if err != nil {
+				continue
+			}
+			retry := &models.SendRetry{MessageID: m.ID, RecipientID: recipient.ID, AppID: m.AppID, Message: *msg, NextAttemptAt: time.Now()}
+			if err := h.repo.CreateSendRetry(retry); err != nil {
+				return queued, err
+			}
+			results[i].RetryQueued = true
+			changed = true
+			queued++
+		}
+		if changed {
+			encoded, _ := json.Marshal(results)
+			if err := h.repo.UpdateMessageResults(m.ID, encoded); err != nil {
+				return queued, err
+			}
+		}
+	}
+	return queued, nil
+}
+
+// retryableResult reports whether a recipient's WeChat send failed in a way a retry can fix. A
+// failure without an errcode didn't come from WeChat.
+func retryableResult(r SendResult) bool {
+	if r.Success || r.Skipped || r.RetryQueued || r.Throttled {
+		return false
+	}
+	return r.ErrCode != 0 && !IsPermanentErrCode(r.ErrCode)
+}
+
+// export writes one CSV row per message
+func (h *HistoryJobs) export(w *csv.Writer, ids []int64) (int, error) {
+	exported := 0
+	for _, id := range ids {
+		m, err := h.repo.GetMessageByID(id)
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return exported, err
+		}
+		keywords, _ := json.Marshal(m.Keywords)
+		w.Write([]string{
+			strconv.FormatInt(m.ID, 10), m.CreatedAt.Format(time.RFC3339), csvCell(m.TemplateKey), csvCell(m.Adapter), csvCell(m.TokenName),
+			strconv.Itoa(m.TotalCount), strconv.Itoa(m.TotalSent), strconv.Itoa(m.TotalFailed), strconv.Itoa(m.TotalSkipped), string(keywords),
+		})
+		exported++
+	}
+	return exported, nil
+}
+
+// csvCell keeps a spreadsheet from reading text as a formula: cells starting with =, +, -, @ or a
+// tab or carriage return get a leading quote
+func csvCell(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+func waitHistoryJob(t *testing.T, jobs *HistoryJobs, id int64) HistoryJob {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if job, _ := jobs.Get(id); job.Status != HistoryJobRunning {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %d did not finish", id)
+	return HistoryJob{}
+}
+
+func TestHistoryJobs(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	repo.CreateTemplate(&models.MessageTemplate{Key: "alert", TemplateID: "tpl", Name: "告警"})
+	alice := &models.Recipient{OpenID: "o-alice", Name: "Alice"}
+	repo.Create(alice)
+	bob := &models.Recipient{OpenID: "o-bob", Name: "Bob"}
+	repo.Create(bob)
+	carol := &models.Recipient{OpenID: "o-carol", Name: "Carol"}
+	repo.Create(carol)
+	// Only Alice's failure can be fixed by retrying: Bob unfollowed, Carol's failure wasn't WeChat's
+	results, _ := json.Marshal([]SendResult{
+		{RecipientID: alice.ID, Error: "system error", ErrCode: -1},
+		{RecipientID: bob.ID, Error: "require subscribe", ErrCode: WeChatErrRequireSubscribe},
+		{RecipientID: carol.ID, Error: "channel unreachable"},
+		{RecipientID: 99, Success: true},
+	})
+	repo.CreateMessage(&models.Message{TemplateKey: "alert", Keywords: map[string]string{"first": "磁盘告警"}, TotalCount: 4, TotalSent: 1, TotalFailed: 3,
+		Results: results, MessageSource: models.MessageSource{Adapter: models.SourceWebhook, TokenName: "=HYPERLINK(1)"}})
+	repo.CreateMessage(&models.Message{TemplateKey: "alert", TotalCount: 1, TotalSent: 1, MessageSource: models.MessageSource{Adapter: models.SourceWebhook, TokenName: "cron"}})
+
+	tokens := NewTokenManager("app", "secret")
+	jobs := NewHistoryJobs(repo, NewSender(repo, NewWeChatService(tokens, "tpl"), ""))
+
+	job, err := jobs.Start(HistoryActionRequeue, repository.MessageFilter{})
+	if err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	if job = waitHistoryJob(t, jobs, job.ID); job.Status != HistoryJobDone || job.Total != 1 || job.Affected != 1 {
+		t.Fatalf("unexpected requeue job: %+v", job)
+	}
+	due, _ := repo.GetDueSendRetries(time.Now().Add(time.Second), 10)
+	if len(due) != 1 || due[0].RecipientID != alice.ID || due[0].Message.ToUser != "o-alice" {
+		t.Fatalf("unexpected retries: %+v", due)
+	}
+	// Results are marked queued, so requeueing again sends nothing twice
+	job, _ = jobs.Start(HistoryActionRequeue, repository.MessageFilter{})
+	if job = waitHistoryJob(t, jobs, job.ID); job.Affected != 0 {
+		t.Errorf("second requeue queued %d retries", job.Affected)
+	}
+
+	job, _ = jobs.Start(HistoryActionExport, repository.MessageFilter{Adapter: models.SourceWebhook})
+	waitHistoryJob(t, jobs, job.ID)
+	data, ok := jobs.Export(job.ID)
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); !ok || len(lines) != 3 || !strings.Contains(lines[1], "磁盘告警") ||
+		!strings.Contains(lines[1], ",'=HYPERLINK(1),") {
+		t.Errorf("unexpected export:\n%s", data)
+	}
+
+	job, _ = jobs.Start(HistoryActionPurge, repository.MessageFilter{TokenName: "cron"})
+	if job = waitHistoryJob(t, jobs, job.ID); job.Affected != 1 {
+		t.Errorf("unexpected purge job: %+v", job)
+	}
+	if _, total, _ := repo.ListMessages(repository.MessageFilter{}, 10, 0); total != 1 {
+		t.Errorf("purge left %d messages, want 1", total)
+	}
+
+	if _, err := jobs.Start("explode", repository.MessageFilter{}); err != ErrUnknownHistoryAction {
+		t.Errorf("expected ErrUnknownHistoryAction, got %v", err)
+	}
+}
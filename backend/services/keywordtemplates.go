@@ -0,0 +1,141 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// maxKeywordTemplateLength caps the source of one keyword template
+const maxKeywordTemplateLength = 2000
+
+// KeywordTemplates extract template keywords from an inbound request with Go text/template, so
+// an endpoint can take any JSON without a parser of its own. Each template runs against a map
+// with the decoded JSON body (or the form values) as .body, a body that isn't JSON as .text, the
+// query parameters as .query and the parts the endpoint parsed, like title and content, as .parts:
+//
+//	{{.body.alert.name}} on {{field .body "hosts.0.name"}}: {{default "无" .parts.content}}
+//
+// Missing fields render empty, but reading a field of one fails; field looks up a dotted path,
+// with list indexes, without failing on a missing step.
+type KeywordTemplates map[string]*template.Template
+
+// keywordTemplateFuncs are the functions keyword templates can call besides text/template's own
+var keywordTemplateFuncs = template.FuncMap{
+	"field":   lookupField,
+	"default": defaultValue,
+	"join":    joinValues,
+	"json":    jsonValue,
+	"trim":    strings.TrimSpace,
+}
+
+// ParseKeywordTemplates compiles the keyword templates of an endpoint, keyed by template field
+func ParseKeywordTemplates(sources map[string]string) (KeywordTemplates, error) {
+	templates := make(KeywordTemplates, len(sources))
+	for field, source := range sources {
+		if len(source) > maxKeywordTemplateLength {
+			return nil, fmt.Errorf("%s: template longer than %d bytes", field, maxKeywordTemplateLength)
+		}
+		t, err := template.New(field).Option("missingkey=zero").Funcs(keywordTemplateFuncs).Parse(source)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", field, err)
+		}
+		templates[field] = t
+	}
+	return templates, nil
+}
+
+// Execute renders each keyword from the request. A missing value renders empty rather than as
+// text/template's "<no value>".
+func (t KeywordTemplates) Execute(body interface{}, query, parts map[string]string) (map[string]string, error) {
+	data := map[string]interface{}{"body": body, "query": query, "parts": parts}
+	if text, ok := body.(string); ok || body == nil {
+		data["body"], data["text"] = map[string]interface{}{}, text
+	}
+	fields := make([]string, 0, len(t))
+	for field := range t {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	keywords := make(map[string]string, len(t))
+	for _, field := range fields {
+		var buf bytes.Buffer
+		if err := t[field].Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("%s: %v", field, err)
+		}
+		keywords[field] = strings.TrimSpace(strings.ReplaceAll(buf.String(), "<no value>", ""))
+	}
+	return keywords, nil
+}
+
+// lookupField follows a dotted path through decoded JSON, indexing lists by number; it returns
+// nil when a step is missing
+func lookupField(v interface{}, path string) interface{} {
+	for _, step := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[step]
+		case map[string]string:
+			v = node[step]
+		case []interface{}:
+			i, err := strconv.Atoi(step)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil
+			}
+			v = node[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+// defaultValue returns v, or fallback when v is missing or empty
+func defaultValue(fallback, v interface{}) interface{} {
+	if v == nil || v == "" {
+		return fallback
+	}
+	return v
+}
+
+// joinValues joins the items of a list with sep
+func joinValues(sep string, v interface{}) string {
+	items, ok := v.([]interface{})
+	if !ok {
+		if v == nil {
+			return ""
+		}
+		return fmt.Sprint(v)
+	}
+	values := make([]string, len(items))
+	for i, item := range items {
+		values[i] = fmt.Sprint(item)
+	}
+	return strings.Join(values, sep)
+}
+
+// jsonValue encodes v as JSON, for objects a template doesn't break down
+func jsonValue(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// DecodeKeywordBody decodes a request body for keyword templates: JSON with numbers kept as
+// written, or the body as text when it isn't JSON
+func DecodeKeywordBody(raw []byte) interface{} {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var body interface{}
+	if err := dec.Decode(&body); err != nil || dec.More() {
+		return strings.TrimSpace(string(raw))
+	}
+	return body
+}
@@ -0,0 +1,45 @@
+package services
+
+import "testing"
+
+func TestKeywordTemplates(t *testing.T) {
+	templates, err := ParseKeywordTemplates(map[string]string{
+		"first":    `[{{.body.severity}}] {{.body.alert.name}}`,
+		"keyword1": `{{field .body "hosts.0.name"}}{{field .body "hosts.5.name"}}`,
+		"keyword2": `{{default "无" .body.missing}} / {{join "、" .body.tags}} / {{.body.count}}`,
+		"keyword3": `{{.parts.title}} {{.query.env}}`,
+		"remark":   `{{json .body.alert}}`,
+	})
+	if err != nil {
+		t.Fatalf("ParseKeywordTemplates error: %v", err)
+	}
+	body := DecodeKeywordBody([]byte(`{"severity":"critical","alert":{"name":"CPU"},"hosts":[{"name":"web-1"}],
+		"tags":["prod","db"],"count":1000000}`))
+	keywords, err := templates.Execute(body, map[string]string{"env": "prod"}, map[string]string{"title": "告警"})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	want := map[string]string{
+		"first":    "[critical] CPU",
+		"keyword1": "web-1",
+		"keyword2": "无 / prod、db / 1000000",
+		"keyword3": "告警 prod",
+		"remark":   `{"name":"CPU"}`,
+	}
+	for field, value := range want {
+		if keywords[field] != value {
+			t.Errorf("%s: got %q, want %q", field, keywords[field], value)
+		}
+	}
+
+	// A body that isn't JSON is read as .text, its fields render empty
+	templates, _ = ParseKeywordTemplates(map[string]string{"first": `{{.body.severity}}{{.text}}`})
+	keywords, err = templates.Execute(DecodeKeywordBody([]byte("plain text\n")), nil, nil)
+	if err != nil || keywords["first"] != "plain text" {
+		t.Errorf("unexpected keywords for a text body: %v, %v", keywords, err)
+	}
+
+	if _, err := ParseKeywordTemplates(map[string]string{"first": "{{.body.name"}); err == nil {
+		t.Error("expected an error for an unclosed action")
+	}
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"wechat-notification/logger"
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+const maintenanceConfigKey = "maintenance"
+
+// MaintenanceState is the persisted maintenance switch
+type MaintenanceState struct {
+	Enabled bool       `json:"enabled"`
+	Reason  string     `json:"reason,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+}
+
+// MaintenanceStatus is the maintenance switch together with the number of sends waiting for it to end
+type MaintenanceStatus struct {
+	MaintenanceState
+	Held int `json:"held"`
+}
+
+// Maintenance pauses all outbound dispatch, e.g. while WeChat credentials or templates are changed.
+// Sends received meanwhile are held and dispatched once maintenance ends; scheduled messages and
+// retries wait in their queues. A nil *Maintenance is never enabled.
+type Maintenance struct {
+	repo  *repository.SQLiteRepository
+	mu    sync.RWMutex
+	state MaintenanceState
+}
+
+// NewMaintenance creates the maintenance switch, restoring its state from the database
+func NewMaintenance(repo *repository.SQLiteRepository) (*Maintenance, error) {
+	m := &Maintenance{repo: repo}
+	if err := repo.GetJSONConfig(maintenanceConfigKey, &m.state); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Enabled reports whether outbound dispatch is paused
+func (m *Maintenance) Enabled() bool {
+	if m == nil {
+		return false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state.Enabled
+}
+
+// Status returns the maintenance switch and the number of held sends
+func (m *Maintenance) Status() (MaintenanceStatus, error) {
+	m.mu.RLock()
+	status := MaintenanceStatus{MaintenanceState: m.state}
+	m.mu.RUnlock()
+
+	held, err := m.repo.CountHeldSends()
+	status.Held = held
+	return status, err
+}
+
+// Set turns maintenance on or off and persists the switch so it survives a restart
+func (m *Maintenance) Set(enabled bool, reason string, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state := MaintenanceState{Enabled: enabled}
+	if enabled {
+		state.Reason = reason
+		state.Since = &now
+		// Keep the original start time when maintenance is already on
+		if m.state.Enabled {
+			state.Since = m.state.Since
+		}
+	}
+	if err := m.repo.SetJSONConfig(maintenanceConfigKey, state); err != nil {
+		return err
+	}
+	switch {
+	case enabled && !m.state.Enabled:
+		logger.Warnf("maintenance mode enabled, holding outbound sends: %s", reason)
+	case !enabled && m.state.Enabled:
+		logger.Warnf("maintenance mode disabled")
+	}
+	m.state = state
+	return nil
+}
+
+// hold stores a send received during maintenance; it's dispatched by ReleaseHeld
+func (s *Sender) hold(template *models.MessageTemplate, recipients []models.Recipient, keywords map[string]string, opts SendOptions) (SendResponse, error) {
+	held := &models.HeldSend{
+		TemplateKey:   template.Key,
+		Keywords:      keywords,
+		RecipientIDs:  make([]int64, 0, len(recipients)),
+		RequireAck:    opts.RequireAck,
+		ReplyTo:       opts.ReplyTo,
+		Channels:      opts.Channels,
+		GroupChannels: opts.groupChannels,
+		Tags:          opts.Tags,
+		Priority:      opts.Priority,
+		AppID:         opts.AppID,
+		URL:           opts.URL,
+		Miniprogram:   opts.Miniprogram,
+		Mode:          opts.Mode,
+		MediaID:       opts.MediaID,
+		Source:        opts.Source,
+	}
+	for _, r := range recipients {
+		held.RecipientIDs = append(held.RecipientIDs, r.ID)
+	}
+	if err := s.repo.CreateHeldSend(held); err != nil {
+		return SendResponse{}, err
+	}
+	logger.Debugf("sender: maintenance mode, held template %s for %d recipients", template.Key, len(recipients))
+	return SendResponse{TotalCount: len(recipients), Held: true, Results: []SendResult{}}, nil
+}
+
+// ReleaseHeld dispatches the sends held during maintenance, oldest first, stopping if maintenance
+// is switched on again. A send that fails is kept with its error and tried on the next release,
+// unless it can never go out. It returns how many were dispatched.
+func (s *Sender) ReleaseHeld(ctx context.Context) (int, error) {
+	s.releaseMu.Lock()
+	defer s.releaseMu.Unlock()
+
+	held, err := s.repo.ListHeldSends()
+	if err != nil {
+		return 0, err
+	}
+	released := 0
+	for _, h := range held {
+		if s.maintenance.Enabled() {
+			break
+		}
+		err := s.releaseOne(ctx, h)
+		if err != nil && !permanentReleaseError(err) {
+			logger.Warnf("sender: held send %d for template %s kept for the next release: %v", h.ID, h.TemplateKey, err)
+			if err := s.repo.SetHeldSendError(h.ID, err.Error()); err != nil {
+				return released, err
+			}
+			continue
+		}
+		if err != nil {
+			logger.Warnf("sender: held send %d for template %s dropped: %v", h.ID, h.TemplateKey, err)
+		} else {
+			released++
+		}
+		if err := s.repo.DeleteHeldSend(h.ID); err != nil {
+			return released, err
+		}
+	}
+	if released > 0 {
+		logger.Infof("sender: released %d sends held during maintenance", released)
+	}
+	return released, nil
+}
+
+func (s *Sender) releaseOne(ctx context.Context, h models.HeldSend) error {
+	template, err := LoadTemplate(s.repo, h.TemplateKey, h.Mode)
+	if err != nil {
+		return err
+	}
+	var recipients []models.Recipient
+	if len(h.RecipientIDs) > 0 {
+		if recipients, err = s.repo.GetByIDs(h.RecipientIDs); err != nil {
+			return err
+		}
+	}
+	_, err = s.Send(ctx, template, recipients, h.Keywords, SendOptions{
+		groupChannels: h.GroupChannels,
+		RequireAck:    h.RequireAck,
+		ReplyTo:       h.ReplyTo,
+		Channels:      h.Channels,
+		Tags:          h.Tags,
+		Priority:      h.Priority,
+		Source:        h.Source,
+		AppID:         h.AppID,
+		URL:           h.URL,
+		Miniprogram:   h.Miniprogram,
+		Mode:          h.Mode,
+		MediaID:       h.MediaID,
+	})
+	return err
+}
+
+// permanentReleaseError reports whether a held send can never go out: its template, the message
+// it replies to or its official account was deleted while it was held
+func permanentReleaseError(err error) bool {
+	return errors.Is(err, repository.ErrNotFound) || errors.Is(err, ErrReplyToNotFound) || errors.Is(err, ErrAppNotFound)
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+func TestMaintenanceHoldsSends(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	posts := 0
+	client := &MockHTTPClient{PostFunc: func(url, contentType string, body io.Reader) (*http.Response, error) {
+		posts++
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"errcode":0,"errmsg":"ok","msgid":1}`))}, nil
+	}}
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("token", time.Hour)
+	sender := NewSender(repo, NewWeChatServiceWithClient(tokens, "tpl", client), "")
+
+	maintenance, err := NewMaintenance(repo)
+	if err != nil {
+		t.Fatalf("NewMaintenance error: %v", err)
+	}
+	sender.SetMaintenance(maintenance)
+	if err := maintenance.Set(true, "更换公众号", time.Now()); err != nil {
+		t.Fatalf("Set error: %v", err)
+	}
+
+	template := &models.MessageTemplate{Key: "alert", TemplateID: "tpl", Name: "告警"}
+	repo.CreateTemplate(template)
+	recipient := &models.Recipient{OpenID: "o1", Name: "张三"}
+	repo.Create(recipient)
+
+	response, err := sender.Send(context.Background(), template, []models.Recipient{*recipient}, map[string]string{"first": "磁盘告警"}, SendOptions{})
+	if err != nil || !response.Held || response.TotalCount != 1 || posts != 0 {
+		t.Fatalf("send during maintenance should be held: %+v, %v, %d posts", response, err, posts)
+	}
+	if status, _ := maintenance.Status(); !status.Enabled || status.Held != 1 || status.Reason != "更换公众号" {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	// The switch survives a restart
+	if restored, _ := NewMaintenance(repo); !restored.Enabled() {
+		t.Error("maintenance state should be restored from the database")
+	}
+	if released, _ := sender.ReleaseHeld(context.Background()); released != 0 {
+		t.Errorf("held sends must stay held during maintenance, released %d", released)
+	}
+
+	maintenance.Set(false, "", time.Now())
+	released, err := sender.ReleaseHeld(context.Background())
+	if err != nil || released != 1 || posts != 1 {
+		t.Fatalf("ReleaseHeld = %d, %v, %d posts", released, err, posts)
+	}
+	if status, _ := maintenance.Status(); status.Enabled || status.Held != 0 {
+		t.Errorf("held sends should be cleared: %+v", status)
+	}
+}
+
+func TestReleaseHeldKeepsFailedSends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	repo, err := repository.NewSQLiteRepository(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("sql.Open error: %v", err)
+	}
+	defer db.Close()
+
+	posts := 0
+	client := &MockHTTPClient{PostFunc: func(url, contentType string, body io.Reader) (*http.Response, error) {
+		posts++
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"errcode":0,"errmsg":"ok","msgid":1}`))}, nil
+	}}
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("token", time.Hour)
+	sender := NewSender(repo, NewWeChatServiceWithClient(tokens, "tpl", client), "")
+
+	repo.CreateTemplate(&models.MessageTemplate{Key: "alert", TemplateID: "tpl", Name: "告警"})
+	recipient := &models.Recipient{OpenID: "o1", Name: "张三"}
+	repo.Create(recipient)
+	repo.CreateHeldSend(&models.HeldSend{TemplateKey: "alert", RecipientIDs: []int64{recipient.ID}})
+	repo.CreateHeldSend(&models.HeldSend{TemplateKey: "deleted", RecipientIDs: []int64{recipient.ID}})
+
+	// A database error keeps the send for the next release; a deleted template drops it
+	if _, err := db.Exec("ALTER TABLE recipient_unsubscriptions RENAME TO unsubscriptions_away"); err != nil {
+		t.Fatalf("rename error: %v", err)
+	}
+	if released, err := sender.ReleaseHeld(context.Background()); err != nil || released != 0 || posts != 0 {
+		t.Fatalf("ReleaseHeld = %d, %v, %d posts", released, err, posts)
+	}
+	held, _ := repo.ListHeldSends()
+	if len(held) != 1 || held[0].TemplateKey != "alert" || held[0].LastError == "" {
+		t.Fatalf("failed send should be kept with its error: %+v", held)
+	}
+
+	db.Exec("ALTER TABLE unsubscriptions_away RENAME TO recipient_unsubscriptions")
+	if released, err := sender.ReleaseHeld(context.Background()); err != nil || released != 1 || posts != 1 {
+		t.Fatalf("second ReleaseHeld = %d, %v, %d posts", released, err, posts)
+	}
+	if held, _ := repo.ListHeldSends(); len(held) != 0 {
+		t.Errorf("released send should be removed: %+v", held)
+	}
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"wechat-notification/models"
+)
+
+const (
+	// WeChatMediaUploadURL is the URL to upload temporary media (临时素材)
+	WeChatMediaUploadURL = "https://api.weixin.qq.com/cgi-bin/media/upload"
+	// MaxImageSize is the largest image WeChat accepts as temporary media
+	MaxImageSize = 10 << 20
+	// MediaLifetime is how long WeChat keeps temporary media
+	MediaLifetime = 3 * 24 * time.Hour
+)
+
+// imageExtensions are the image formats WeChat accepts as temporary media
+var imageExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".bmp": true}
+
+// ErrUnsupportedImage is returned when an upload isn't a JPEG, PNG, GIF or BMP image within MaxImageSize
+var ErrUnsupportedImage = errors.New("image must be a jpg, png, gif or bmp file of at most 10 MB")
+
+// UploadImage uploads an image as temporary media of the account, returning the media ID that
+// image messages refer to. WeChat deletes it after MediaLifetime.
+func (s *WeChatService) UploadImage(filename string, data []byte) (*models.WeChatMedia, error) {
+	if !imageExtensions[strings.ToLower(filepath.Ext(filename))] || len(data) == 0 || len(data) > MaxImageSize {
+		return nil, ErrUnsupportedImage
+	}
+	token, err := s.tokenManager.GetAccessToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("media", filepath.Base(filename))
+	if err != nil {
+		return nil, err
+	}
+	part.Write(data)
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	resp, err := s.httpClient.Post(fmt.Sprintf("%s?access_token=%s&type=image", WeChatMediaUploadURL, token), form.FormDataContentType(), &body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload media: %w", withoutURL(err))
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	var result struct {
+		models.WeChatAPIResponse
+		Type      string `json:"type"`
+		MediaID   string `json:"media_id"`
+		CreatedAt int64  `json:"created_at"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if result.ErrCode != 0 {
+		return nil, &APIError{ErrCode: result.ErrCode, ErrMsg: result.ErrMsg}
+	}
+	created := time.Unix(result.CreatedAt, 0)
+	return &models.WeChatMedia{Type: result.Type, MediaID: result.MediaID, CreatedAt: created, ExpiresAt: created.Add(MediaLifetime)}, nil
+}
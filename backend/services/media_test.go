@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+)
+
+func TestUploadImage(t *testing.T) {
+	var url, filename, content string
+	client := &MockHTTPClient{PostFunc: func(u, contentType string, b io.Reader) (*http.Response, error) {
+		url = u
+		_, params, _ := mime.ParseMediaType(contentType)
+		part, err := multipart.NewReader(b, params["boundary"]).NextPart()
+		if err != nil {
+			t.Fatalf("multipart body: %v", err)
+		}
+		filename = part.FileName()
+		data, _ := io.ReadAll(part)
+		content = part.FormName() + ":" + string(data)
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"type":"image","media_id":"m1","created_at":1700000000}`))}, nil
+	}}
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("token", time.Hour)
+	wechatSvc := NewWeChatServiceWithClient(tokens, "tpl", client)
+
+	media, err := wechatSvc.UploadImage("dir/chart.PNG", []byte("png"))
+	if err != nil {
+		t.Fatalf("UploadImage error: %v", err)
+	}
+	if url != WeChatMediaUploadURL+"?access_token=token&type=image" || filename != "chart.PNG" || content != "media:png" {
+		t.Errorf("unexpected upload: url=%s filename=%s content=%s", url, filename, content)
+	}
+	if media.MediaID != "m1" || !media.ExpiresAt.Equal(time.Unix(1700000000, 0).Add(MediaLifetime)) {
+		t.Errorf("unexpected media: %+v", media)
+	}
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"notes.txt", []byte("x")},
+		{"empty.jpg", nil},
+		{"huge.jpg", make([]byte, MaxImageSize+1)},
+	} {
+		if _, err := wechatSvc.UploadImage(tc.name, tc.data); err != ErrUnsupportedImage {
+			t.Errorf("%s: expected ErrUnsupportedImage, got %v", tc.name, err)
+		}
+	}
+}
+
+func TestSendTextWithImage(t *testing.T) {
+	var bodies []map[string]interface{}
+	client := &MockHTTPClient{PostFunc: func(u, contentType string, b io.Reader) (*http.Response, error) {
+		var body map[string]interface{}
+		json.NewDecoder(b).Decode(&body)
+		bodies = append(bodies, body)
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"errcode":0,"errmsg":"ok"}`))}, nil
+	}}
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("token", time.Hour)
+	wechatSvc := NewWeChatServiceWithClient(tokens, "tpl", client)
+
+	recipients := []models.Recipient{{ID: 1, OpenID: "o1"}}
+	response := SendMessages(context.Background(), wechatSvc, recipients, "", models.SendModeText, map[string]string{"first": "报表"}, nil, nil, "m1")
+	if response.TotalFailed != 0 {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	if len(bodies) != 2 || bodies[0]["msgtype"] != "text" || bodies[1]["msgtype"] != "image" {
+		t.Fatalf("expected text then image, got %v", bodies)
+	}
+	if image, _ := bodies[1]["image"].(map[string]interface{}); image["media_id"] != "m1" || bodies[1]["touser"] != "o1" {
+		t.Errorf("unexpected image body: %v", bodies[1])
+	}
+}
+
+func TestUploadImageHidesToken(t *testing.T) {
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("tok_s3cret", time.Hour)
+	_, err := NewWeChatServiceWithClient(tokens, "tpl", failingClient()).UploadImage("a.png", []byte("png"))
+	if err == nil || strings.Contains(err.Error(), "tok_s3cret") || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("UploadImage error = %v, want one without the access token", err)
+	}
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"wechat-notification/logger"
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+const (
+	// MonitorCheckTick is how often the checker looks for monitors that are due
+	MonitorCheckTick = 10 * time.Second
+	// MinMonitorInterval is the shortest allowed check interval for a monitor
+	MinMonitorInterval = 30
+	// DefaultMonitorTimeout is the request timeout used when a monitor doesn't set one
+	DefaultMonitorTimeout = 10
+)
+
+// MonitorResult is the outcome of a single endpoint check
+type MonitorResult struct {
+	Status       string `json:"status"`
+	StatusCode   int    `json:"statusCode,omitempty"`
+	ResponseTime int64  `json:"responseTimeMs"`
+	Error        string `json:"error,omitempty"`
+	Changed      bool   `json:"changed"`
+	Notified     bool   `json:"notified"`
+}
+
+// MonitorChecker checks configured HTTP endpoints and notifies groups when they go down or recover
+type MonitorChecker struct {
+	repo       *repository.SQLiteRepository
+	sender     *Sender
+	httpClient *http.Client
+}
+
+// NewMonitorChecker creates a new monitor checker
+func NewMonitorChecker(repo *repository.SQLiteRepository, sender *Sender) *MonitorChecker {
+	return &MonitorChecker{
+		repo:   repo,
+		sender: sender,
+		// Timeouts are applied per request from the monitor's settings
+		httpClient: &http.Client{},
+	}
+}
+
+// Run checks due monitors until ctx is cancelled
+func (m *MonitorChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(MonitorCheckTick)
+	defer ticker.Stop()
+
+	for {
+		m.checkDue(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *MonitorChecker) checkDue(now time.Time) {
+	monitors, err := m.repo.GetAllMonitors()
+	if err != nil {
+		logger.Warnf("monitor checker: failed to load monitors: %v", err)
+		return
+	}
+	for i := range monitors {
+		monitor := &monitors[i]
+		if !monitor.Enabled {
+			continue
+		}
+		if monitor.LastCheckedAt != nil && now.Sub(*monitor.LastCheckedAt) < time.Duration(monitor.IntervalSeconds)*time.Second {
+			continue
+		}
+		if _, err := m.Check(monitor); err != nil {
+			logger.Warnf("monitor checker: %s: %v", monitor.Name, err)
+		}
+	}
+}
+
+// Check probes a monitor once and records the result.
+// A notification is sent when the status flips between up and down, or when the first check finds it down.
+func (m *MonitorChecker) Check(monitor *models.Monitor) (*MonitorResult, error) {
+	result := m.probe(monitor)
+	now := time.Now()
+
+	previous := monitor.Status
+	result.Changed = result.Status != previous
+	var changedAt *time.Time
+	if result.Changed {
+		changedAt = &now
+	}
+
+	var notifyErr error
+	if result.Changed && !(previous == models.MonitorStatusUnknown && result.Status == models.MonitorStatusUp) {
+		notifyErr = m.notify(monitor, result, now)
+		result.Notified = notifyErr == nil
+	}
+
+	if err := m.repo.UpdateMonitorCheckState(monitor.ID, result.Status, now, changedAt, result.Error); err != nil {
+		return result, err
+	}
+	return result, notifyErr
+}
+
+func (m *MonitorChecker) probe(monitor *models.Monitor) *MonitorResult {
+	timeout := monitor.TimeoutSeconds
+	if timeout <= 0 {
+		timeout = DefaultMonitorTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	result := &MonitorResult{Status: models.MonitorStatusDown}
+	req, err := http.NewRequestWithContext(ctx, monitor.Method, monitor.URL, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req.Header.Set("User-Agent", "wechat-notification-monitor")
+
+	start := time.Now()
+	resp, err := m.httpClient.Do(req)
+	result.ResponseTime = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	if resp.StatusCode != monitor.ExpectedStatus {
+		result.Error = fmt.Sprintf("expected HTTP %d, got %d", monitor.ExpectedStatus, resp.StatusCode)
+		return result
+	}
+	result.Status = models.MonitorStatusUp
+	return result
+}
+
+func (m *MonitorChecker) notify(monitor *models.Monitor, result *MonitorResult, checkedAt time.Time) error {
+	template, err := m.repo.GetTemplateByKey(monitor.TemplateKey)
+	if err != nil {
+		return fmt.Errorf("template %q: %w", monitor.TemplateKey, err)
+	}
+
+	statusCode := ""
+	if result.StatusCode != 0 {
+		statusCode = strconv.Itoa(result.StatusCode)
+	}
+	status := "DOWN"
+	if result.Status == models.MonitorStatusUp {
+		status = "UP"
+	}
+	keywords := RenderKeywords(monitor.Keywords, map[string]string{
+		"monitor":      monitor.Name,
+		"url":          monitor.URL,
+		"status":       status,
+		"statusCode":   statusCode,
+		"error":        TruncateRunes(result.Error, MaxKeywordLength),
+		"responseTime": strconv.FormatInt(result.ResponseTime, 10) + "ms",
+		"checkedAt":    checkedAt.Format("2006-01-02 15:04:05"),
+	})
+	_, err = m.sender.SendToGroups(context.Background(), template, monitor.GroupIDs, keywords, SendOptions{})
+	return err
+}
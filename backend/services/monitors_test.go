@@ -0,0 +1,37 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"wechat-notification/models"
+)
+
+func TestMonitorProbe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	checker := &MonitorChecker{httpClient: server.Client()}
+
+	up := checker.probe(&models.Monitor{URL: server.URL + "/health", Method: http.MethodGet, ExpectedStatus: http.StatusOK, TimeoutSeconds: 5})
+	if up.Status != models.MonitorStatusUp || up.StatusCode != http.StatusOK || up.Error != "" {
+		t.Errorf("healthy endpoint: got %+v", up)
+	}
+
+	down := checker.probe(&models.Monitor{URL: server.URL + "/broken", Method: http.MethodGet, ExpectedStatus: http.StatusOK, TimeoutSeconds: 5})
+	if down.Status != models.MonitorStatusDown || down.StatusCode != http.StatusInternalServerError || down.Error == "" {
+		t.Errorf("broken endpoint: got %+v", down)
+	}
+
+	unreachable := checker.probe(&models.Monitor{URL: "http://127.0.0.1:1", Method: http.MethodGet, ExpectedStatus: http.StatusOK, TimeoutSeconds: 1})
+	if unreachable.Status != models.MonitorStatusDown || unreachable.Error == "" {
+		t.Errorf("unreachable endpoint: got %+v", unreachable)
+	}
+}
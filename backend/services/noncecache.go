@@ -0,0 +1,66 @@
+package services
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MaxNonceLength is the longest nonce a signed webhook request can carry
+const MaxNonceLength = 128
+
+var (
+	// ErrStaleTimestamp is returned for a signed request whose timestamp is missing or outside the
+	// replay window
+	ErrStaleTimestamp = errors.New("X-Timestamp is missing or outside the replay window")
+	// ErrReplayedNonce is returned for a signed request whose nonce was already used
+	ErrReplayedNonce = errors.New("X-Nonce is missing or was already used")
+)
+
+// NonceCache rejects replayed signed requests: a request must carry a timestamp within the window
+// of now and a nonce not seen within the window. Nonces are forgotten once their request's
+// timestamp leaves the window, when a replay would be rejected for its timestamp anyway.
+type NonceCache struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	seen      map[string]time.Time // nonce -> when it can be forgotten
+	lastSweep time.Time
+}
+
+// NewNonceCache creates a nonce cache accepting timestamps within window of now
+func NewNonceCache(window time.Duration) *NonceCache {
+	return &NonceCache{window: window, seen: make(map[string]time.Time)}
+}
+
+// Check validates the Unix timestamp and nonce of a request and remembers the nonce
+func (n *NonceCache) Check(timestamp, nonce string, now time.Time) error {
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrStaleTimestamp
+	}
+	sent := time.Unix(secs, 0)
+	if sent.Before(now.Add(-n.window)) || sent.After(now.Add(n.window)) {
+		return ErrStaleTimestamp
+	}
+	if nonce == "" || len(nonce) > MaxNonceLength {
+		return ErrReplayedNonce
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if now.Sub(n.lastSweep) >= n.window {
+		for k, forget := range n.seen {
+			if now.After(forget) {
+				delete(n.seen, k)
+			}
+		}
+		n.lastSweep = now
+	}
+	if forget, ok := n.seen[nonce]; ok && !now.After(forget) {
+		return ErrReplayedNonce
+	}
+	n.seen[nonce] = sent.Add(n.window)
+	return nil
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNonceCache(t *testing.T) {
+	nonces := NewNonceCache(5 * time.Minute)
+	now := time.Unix(1700000000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+
+	if err := nonces.Check(ts, "n-1", now); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := nonces.Check(ts, "n-1", now.Add(time.Minute)); err != ErrReplayedNonce {
+		t.Errorf("replay: expected ErrReplayedNonce, got %v", err)
+	}
+	if err := nonces.Check(ts, "", now); err != ErrReplayedNonce {
+		t.Errorf("empty nonce: expected ErrReplayedNonce, got %v", err)
+	}
+	for _, stale := range []string{"", "abc", strconv.FormatInt(now.Add(-6*time.Minute).Unix(), 10), strconv.FormatInt(now.Add(6*time.Minute).Unix(), 10)} {
+		if err := nonces.Check(stale, "n-2", now); err != ErrStaleTimestamp {
+			t.Errorf("timestamp %q: expected ErrStaleTimestamp, got %v", stale, err)
+		}
+	}
+
+	// Once the timestamp has left the window the nonce is forgotten, and the old request is
+	// rejected for its timestamp instead
+	later := now.Add(10 * time.Minute)
+	if err := nonces.Check(strconv.FormatInt(later.Unix(), 10), "n-3", later); err != nil {
+		t.Fatalf("later request: %v", err)
+	}
+	if _, ok := nonces.seen["n-1"]; ok {
+		t.Error("expired nonce should have been swept")
+	}
+	if err := nonces.Check(ts, "n-1", later); err != ErrStaleTimestamp {
+		t.Errorf("old replay: expected ErrStaleTimestamp, got %v", err)
+	}
+}
+
+func TestNonceCacheAcceptsNonceAfterWindow(t *testing.T) {
+	nonces := NewNonceCache(5 * time.Minute)
+	now := time.Unix(1700000000, 0)
+	check := func(at time.Time, nonce string) error {
+		return nonces.Check(strconv.FormatInt(at.Unix(), 10), nonce, at)
+	}
+
+	if err := check(now, "n-1"); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	// Still within the window of the first request, even with a fresh timestamp
+	if err := check(now.Add(5*time.Minute), "n-1"); err != ErrReplayedNonce {
+		t.Errorf("reuse at the window's end: expected ErrReplayedNonce, got %v", err)
+	}
+	if err := check(now.Add(5*time.Minute+time.Second), "n-1"); err != nil {
+		t.Errorf("reuse after the window: %v", err)
+	}
+}
+
+func TestNonceCacheEviction(t *testing.T) {
+	nonces := NewNonceCache(5 * time.Minute)
+	now := time.Unix(1700000000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+
+	for i := 0; i < 10; i++ {
+		if err := nonces.Check(ts, "n-"+strconv.Itoa(i), now); err != nil {
+			t.Fatalf("nonce %d: %v", i, err)
+		}
+	}
+	if len(nonces.seen) != 10 {
+		t.Fatalf("expected 10 nonces, got %d", len(nonces.seen))
+	}
+
+	// Sweeps run at most once per window, so nothing is dropped before then
+	soon := now.Add(time.Minute)
+	if err := nonces.Check(strconv.FormatInt(soon.Unix(), 10), "n-soon", soon); err != nil {
+		t.Fatalf("soon: %v", err)
+	}
+	if len(nonces.seen) != 11 {
+		t.Errorf("expected 11 nonces before the sweep, got %d", len(nonces.seen))
+	}
+
+	// The sweep after the window keeps only the nonces whose timestamps are still within it
+	later := now.Add(5*time.Minute + time.Second)
+	if err := nonces.Check(strconv.FormatInt(later.Unix(), 10), "n-later", later); err != nil {
+		t.Fatalf("later: %v", err)
+	}
+	if len(nonces.seen) != 2 {
+		t.Errorf("expected 2 nonces after the sweep, got %d: %v", len(nonces.seen), nonces.seen)
+	}
+}
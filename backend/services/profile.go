@@ -0,0 +1,179 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// WeChatOAuthAuthorizeURL is the URL to start WeChat web authorization
+	WeChatOAuthAuthorizeURL = "https://open.weixin.qq.com/connect/oauth2/authorize"
+	// WeChatOAuthTokenURL is the URL to exchange a web authorization code for an openid
+	WeChatOAuthTokenURL = "https://api.weixin.qq.com/sns/oauth2/access_token"
+)
+
+// Profile token errors
+var (
+	ErrInvalidProfileToken = errors.New("invalid profile token")
+	ErrExpiredProfileToken = errors.New("profile token expired")
+	ErrInvalidQuietHours   = errors.New("quiet hours must be in HH:MM format")
+)
+
+// ProfileTokenSigner issues and verifies signed recipient profile tokens (magic links)
+type ProfileTokenSigner struct {
+	secret []byte
+}
+
+// NewProfileTokenSigner creates a new profile token signer
+func NewProfileTokenSigner(secret string) *ProfileTokenSigner {
+	return &ProfileTokenSigner{secret: []byte(secret)}
+}
+
+// Sign returns a token granting access to a recipient's profile until ttl elapses
+func (s *ProfileTokenSigner) Sign(recipientID int64, ttl time.Duration) string {
+	payload := fmt.Sprintf("%d.%d", recipientID, time.Now().Add(ttl).Unix())
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + s.signature(payload)
+}
+
+// Verify validates a token and returns the recipient ID it was issued for
+func (s *ProfileTokenSigner) Verify(token string) (int64, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return 0, ErrInvalidProfileToken
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return 0, ErrInvalidProfileToken
+	}
+	payload := string(decoded)
+
+	if !hmac.Equal([]byte(parts[1]), []byte(s.signature(payload))) {
+		return 0, ErrInvalidProfileToken
+	}
+
+	fields := strings.SplitN(payload, ".", 2)
+	if len(fields) != 2 {
+		return 0, ErrInvalidProfileToken
+	}
+	recipientID, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, ErrInvalidProfileToken
+	}
+	expiresAt, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, ErrInvalidProfileToken
+	}
+	if time.Now().Unix() > expiresAt {
+		return 0, ErrExpiredProfileToken
+	}
+
+	return recipientID, nil
+}
+
+func (s *ProfileTokenSigner) signature(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ValidateQuietHours checks that start and end are both empty or both valid HH:MM values
+func ValidateQuietHours(start, end string) error {
+	if start == "" && end == "" {
+		return nil
+	}
+	if _, err := time.Parse("15:04", start); err != nil {
+		return ErrInvalidQuietHours
+	}
+	if _, err := time.Parse("15:04", end); err != nil {
+		return ErrInvalidQuietHours
+	}
+	return nil
+}
+
+// IsWithinQuietHours reports whether now falls inside the [start, end) window.
+// Windows that wrap past midnight (e.g. 22:00-07:00) are supported.
+func IsWithinQuietHours(start, end string, now time.Time) bool {
+	if start == "" || end == "" || start == end {
+		return false
+	}
+	startTime, err := time.Parse("15:04", start)
+	if err != nil {
+		return false
+	}
+	endTime, err := time.Parse("15:04", end)
+	if err != nil {
+		return false
+	}
+
+	minutes := now.Hour()*60 + now.Minute()
+	startMinutes := startTime.Hour()*60 + startTime.Minute()
+	endMinutes := endTime.Hour()*60 + endTime.Minute()
+
+	if startMinutes < endMinutes {
+		return minutes >= startMinutes && minutes < endMinutes
+	}
+	return minutes >= startMinutes || minutes < endMinutes
+}
+
+// WeChatOAuthTokenResponse represents the response from the web authorization token API
+type WeChatOAuthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	OpenID      string `json:"openid"`
+	Scope       string `json:"scope"`
+	ErrCode     int    `json:"errcode"`
+	ErrMsg      string `json:"errmsg"`
+}
+
+// BuildWeChatOAuthURL returns the snsapi_base authorization URL for the official account
+func BuildWeChatOAuthURL(appID, redirectURL, state string) string {
+	params := url.Values{}
+	params.Set("appid", appID)
+	params.Set("redirect_uri", redirectURL)
+	params.Set("response_type", "code")
+	params.Set("scope", "snsapi_base")
+	params.Set("state", state)
+	return fmt.Sprintf("%s?%s#wechat_redirect", WeChatOAuthAuthorizeURL, params.Encode())
+}
+
+// ExchangeWeChatOAuthCode exchanges a web authorization code for the user's openid
+func ExchangeWeChatOAuthCode(client HTTPClient, appID, appSecret, code string) (string, error) {
+	params := url.Values{}
+	params.Set("appid", appID)
+	params.Set("secret", appSecret)
+	params.Set("code", code)
+	params.Set("grant_type", "authorization_code")
+
+	resp, err := client.Get(WeChatOAuthTokenURL + "?" + params.Encode())
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange code: %w", withoutURL(err))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	var tokenResp WeChatOAuthTokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", fmt.Errorf("failed to parse oauth response: %w", err)
+	}
+	if tokenResp.ErrCode != 0 {
+		return "", fmt.Errorf("WeChat API error: code=%d, msg=%s", tokenResp.ErrCode, tokenResp.ErrMsg)
+	}
+	if tokenResp.OpenID == "" {
+		return "", fmt.Errorf("empty openid in response")
+	}
+
+	return tokenResp.OpenID, nil
+}
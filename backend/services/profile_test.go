@@ -0,0 +1,72 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+)
+
+// **Feature: wechat-notification, Property 16: 个人资料令牌往返**
+// *对于任意* 接收者 ID，签发的个人资料令牌在有效期内应验证为同一接收者，且被篡改或过期的令牌应被拒绝
+func TestProperty16_ProfileTokenRoundTrip(t *testing.T) {
+	parameters := gopter.DefaultTestParameters()
+	parameters.MinSuccessfulTests = 100
+
+	properties := gopter.NewProperties(parameters)
+	signer := NewProfileTokenSigner("test-secret")
+
+	properties.Property("Signed token verifies to the same recipient", prop.ForAll(
+		func(recipientID int64) bool {
+			id, err := signer.Verify(signer.Sign(recipientID, time.Hour))
+			return err == nil && id == recipientID
+		},
+		gen.Int64Range(1, 1<<40),
+	))
+
+	properties.Property("Token signed with another secret is rejected", prop.ForAll(
+		func(recipientID int64) bool {
+			other := NewProfileTokenSigner("other-secret")
+			_, err := signer.Verify(other.Sign(recipientID, time.Hour))
+			return err == ErrInvalidProfileToken
+		},
+		gen.Int64Range(1, 1<<40),
+	))
+
+	properties.Property("Expired token is rejected", prop.ForAll(
+		func(recipientID int64) bool {
+			_, err := signer.Verify(signer.Sign(recipientID, -time.Minute))
+			return err == ErrExpiredProfileToken
+		},
+		gen.Int64Range(1, 1<<40),
+	))
+
+	properties.TestingRun(t)
+}
+
+func TestIsWithinQuietHours(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+
+	cases := []struct {
+		start, end string
+		now        time.Time
+		want       bool
+	}{
+		{"", "", at(3, 0), false},
+		{"09:00", "17:00", at(12, 0), true},
+		{"09:00", "17:00", at(17, 0), false},
+		{"22:00", "07:00", at(23, 30), true},
+		{"22:00", "07:00", at(6, 59), true},
+		{"22:00", "07:00", at(12, 0), false},
+	}
+
+	for _, tc := range cases {
+		if got := IsWithinQuietHours(tc.start, tc.end, tc.now); got != tc.want {
+			t.Errorf("IsWithinQuietHours(%q, %q, %s) = %v, want %v", tc.start, tc.end, tc.now.Format("15:04"), got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"wechat-notification/logger"
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+const (
+	// RetryTick is how often the retry queue looks for messages that are due
+	RetryTick = 10 * time.Second
+	// RetryBaseDelay is the wait before the first retry; each further retry waits twice as long
+	RetryBaseDelay = 30 * time.Second
+	// RetryMaxDelay caps the wait between two retries
+	RetryMaxDelay = time.Hour
+	// MaxRetryAttempts is how many times a message is retried before it is given up
+	MaxRetryAttempts = 5
+	// retryBatchSize limits how many retries are sent per tick
+	retryBatchSize = 100
+)
+
+// RetryDelay returns the backoff before retry number attempt (1-based)
+func RetryDelay(attempt int) time.Duration {
+	delay := RetryBaseDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= RetryMaxDelay {
+			return RetryMaxDelay
+		}
+	}
+	return delay
+}
+
+// RetryQueue re-sends WeChat messages that failed with a transient error
+type RetryQueue struct {
+	repo        *repository.SQLiteRepository
+	apps        *AppRegistry
+	maintenance *Maintenance
+}
+
+// NewRetryQueue creates a new retry queue
+func NewRetryQueue(repo *repository.SQLiteRepository, wechatSvc *WeChatService) *RetryQueue {
+	return &RetryQueue{repo: repo, apps: NewAppRegistry(wechatSvc)}
+}
+
+// SetApps connects the registry of official accounts retries are sent through
+func (q *RetryQueue) SetApps(apps *AppRegistry) {
+	q.apps = apps
+}
+
+// SetMaintenance connects the maintenance switch that pauses retries
+func (q *RetryQueue) SetMaintenance(m *Maintenance) {
+	q.maintenance = m
+}
+
+// Run retries due messages until ctx is cancelled. Retries a previous run claimed but never
+// finished are attempted again.
+func (q *RetryQueue) Run(ctx context.Context) {
+	if reset, err := q.repo.ResetSendingSendRetries(); err != nil {
+		logger.Warnf("retry queue: failed to reset interrupted retries: %v", err)
+	} else if reset > 0 {
+		logger.Warnf("retry queue: resuming %d retry(s) interrupted by a restart", reset)
+	}
+
+	ticker := time.NewTicker(RetryTick)
+	defer ticker.Stop()
+
+	for {
+		q.retryDue(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (q *RetryQueue) retryDue(now time.Time) {
+	if q.maintenance.Enabled() {
+		return
+	}
+	due, err := q.repo.GetDueSendRetries(now, retryBatchSize)
+	if err != nil {
+		logger.Warnf("retry queue: failed to load due retries: %v", err)
+		return
+	}
+	for i := range due {
+		// Every attempt would be refused until the account's quota frees up
+		if svc, err := q.apps.Service(due[i].AppID); err == nil && now.Before(svc.ThrottledUntil()) {
+			continue
+		}
+		if err := q.Attempt(&due[i], now); err != nil {
+			logger.Warnf("retry queue: retry %d: %v", due[i].ID, err)
+		}
+	}
+}
+
+// Attempt claims a retry and re-sends it. A transient failure schedules the next attempt with
+// exponential backoff until MaxRetryAttempts is reached; any other failure gives up immediately.
+// Retries that give up are moved to the dead-letter table. A throttled attempt doesn't count
+// against the budget and waits until WeChat's quota frees up. Retries for an official account
+// that was removed give up immediately.
+func (q *RetryQueue) Attempt(retry *models.SendRetry, now time.Time) error {
+	claimed, err := q.repo.ClaimSendRetry(retry.ID)
+	if err != nil || !claimed {
+		return err
+	}
+
+	retry.Attempts++
+	wechatSvc, err := q.apps.Service(retry.AppID)
+	if err != nil {
+		retry.LastError = err.Error()
+		logger.Warnf("retry queue: giving up on retry %d for recipient %d: %v", retry.ID, retry.RecipientID, err)
+		_, err = q.repo.ExhaustSendRetry(retry)
+		return err
+	}
+	resp, err := wechatSvc.SendTemplateMessage(&retry.Message)
+	if err == nil {
+		retry.Status, retry.LastError = models.RetryStatusSucceeded, ""
+		if err := q.repo.RecordDeliveries([]int64{retry.RecipientID}, nil); err != nil {
+			logger.Warnf("retry queue: failed to record recipient activity: %v", err)
+		}
+		logger.Debugf("retry queue: retry %d delivered on attempt %d", retry.ID, retry.Attempts)
+		return q.repo.UpdateSendRetry(retry)
+	}
+
+	code := WeChatErrSystemBusy
+	if resp != nil {
+		code = resp.ErrCode
+	}
+	retry.LastError = err.Error()
+	if IsThrottledErrCode(code) {
+		retry.Attempts--
+		retry.Status = models.RetryStatusPending
+		retry.NextAttemptAt = wechatSvc.ThrottledUntil()
+		if retry.NextAttemptAt.Before(now) {
+			retry.NextAttemptAt = now.Add(ThrottleBackoff)
+		}
+		return q.repo.UpdateSendRetry(retry)
+	}
+	if IsTransientErrCode(code) && retry.Attempts < MaxRetryAttempts {
+		retry.Status = models.RetryStatusPending
+		retry.NextAttemptAt = now.Add(RetryDelay(retry.Attempts + 1))
+		return q.repo.UpdateSendRetry(retry)
+	}
+
+	logger.Warnf("retry queue: giving up on retry %d for recipient %d after %d attempts: %v", retry.ID, retry.RecipientID, retry.Attempts, err)
+	_, err = q.repo.ExhaustSendRetry(retry)
+	return err
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+func TestRetryDelay(t *testing.T) {
+	cases := map[int]time.Duration{1: 30 * time.Second, 2: time.Minute, 3: 2 * time.Minute, 5: 8 * time.Minute, 20: RetryMaxDelay}
+	for attempt, want := range cases {
+		if got := RetryDelay(attempt); got != want {
+			t.Errorf("RetryDelay(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+}
+
+func TestRetryQueueAttempt(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	reply := `{"errcode":-1,"errmsg":"system error"}`
+	client := &MockHTTPClient{PostFunc: func(url, contentType string, body io.Reader) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(reply))}, nil
+	}}
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("token", time.Hour)
+	queue := NewRetryQueue(repo, NewWeChatServiceWithClient(tokens, "tpl", client))
+
+	retry := &models.SendRetry{RecipientID: 1, Message: models.WeChatTemplateMessage{ToUser: "o1", TemplateID: "tpl"}, NextAttemptAt: time.Now()}
+	if err := repo.CreateSendRetry(retry); err != nil {
+		t.Fatalf("CreateSendRetry error: %v", err)
+	}
+
+	now := time.Now()
+	if err := queue.Attempt(retry, now); err != nil {
+		t.Fatalf("Attempt error: %v", err)
+	}
+	got, _ := repo.GetSendRetryByID(retry.ID)
+	if got.Status != models.RetryStatusPending || got.Attempts != 1 || !got.NextAttemptAt.Equal(now.Add(RetryDelay(2)).UTC()) {
+		t.Fatalf("transient failure should back off: %+v", got)
+	}
+	if due, _ := repo.GetDueSendRetries(now, 10); len(due) != 0 {
+		t.Errorf("retry should not be due before its backoff: %+v", due)
+	}
+
+	reply = `{"errcode":0,"errmsg":"ok","msgid":1}`
+	if err := queue.Attempt(got, now); err != nil {
+		t.Fatalf("Attempt error: %v", err)
+	}
+	if got, _ = repo.GetSendRetryByID(retry.ID); got.Status != models.RetryStatusSucceeded || got.Attempts != 2 {
+		t.Errorf("retry should succeed: %+v", got)
+	}
+
+	// Permanent errors are not retried
+	reply = `{"errcode":40003,"errmsg":"invalid openid"}`
+	permanent := &models.SendRetry{RecipientID: 1, Message: models.WeChatTemplateMessage{ToUser: "bad"}, NextAttemptAt: now}
+	repo.CreateSendRetry(permanent)
+	queue.Attempt(permanent, now)
+	if got, _ = repo.GetSendRetryByID(permanent.ID); got.Status != models.RetryStatusExhausted || got.Attempts != 1 {
+		t.Errorf("permanent failure should give up: %+v", got)
+	}
+
+	dead, total, err := repo.ListDeadLetters(10, 0)
+	if err != nil || total != 1 || dead[0].RetryID != permanent.ID || dead[0].LastError == "" {
+		t.Fatalf("exhausted retry should be dead-lettered: %+v, %d, %v", dead, total, err)
+	}
+	requeued, err := repo.RequeueDeadLetter(dead[0].ID, now)
+	if err != nil || requeued.Attempts != 0 || requeued.Message.ToUser != "bad" {
+		t.Fatalf("RequeueDeadLetter = %+v, %v", requeued, err)
+	}
+	if _, total, _ := repo.ListDeadLetters(10, 0); total != 0 {
+		t.Errorf("requeued dead letter should be removed, %d left", total)
+	}
+	if due, _ := repo.GetDueSendRetries(now, 10); len(due) != 1 || due[0].ID != requeued.ID {
+		t.Errorf("requeued message should be due: %+v", due)
+	}
+	if _, err := repo.RequeueDeadLetter(dead[0].ID, now); err != repository.ErrNotFound {
+		t.Errorf("second requeue error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRetryQueueResumesInterruptedRetries(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	posts := 0
+	client := &MockHTTPClient{PostFunc: func(url, contentType string, body io.Reader) (*http.Response, error) {
+		posts++
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"errcode":0,"errmsg":"ok","msgid":1}`))}, nil
+	}}
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("token", time.Hour)
+	queue := NewRetryQueue(repo, NewWeChatServiceWithClient(tokens, "tpl", client))
+
+	// Claimed by a run that crashed before recording the outcome
+	retry := &models.SendRetry{RecipientID: 1, Message: models.WeChatTemplateMessage{ToUser: "o1", TemplateID: "tpl"}, NextAttemptAt: time.Now()}
+	repo.CreateSendRetry(retry)
+	repo.ClaimSendRetry(retry.ID)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	queue.Run(ctx)
+	if got, _ := repo.GetSendRetryByID(retry.ID); got.Status != models.RetryStatusSucceeded || posts != 1 {
+		t.Errorf("interrupted retry should be attempted again: %+v, %d sends", got, posts)
+	}
+}
+
+func TestRetryQueueAttemptThrottled(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	client := &MockHTTPClient{PostFunc: func(url, contentType string, body io.Reader) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"errcode":45009,"errmsg":"reach max api daily quota limit"}`))}, nil
+	}}
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("token", time.Hour)
+	wechatSvc := NewWeChatServiceWithClient(tokens, "tpl", client)
+	queue := NewRetryQueue(repo, wechatSvc)
+
+	retry := &models.SendRetry{RecipientID: 1, Message: models.WeChatTemplateMessage{ToUser: "o1"}, Attempts: MaxRetryAttempts - 1, NextAttemptAt: time.Now()}
+	repo.CreateSendRetry(retry)
+	now := time.Now()
+	if err := queue.Attempt(retry, now); err != nil {
+		t.Fatalf("Attempt error: %v", err)
+	}
+	got, _ := repo.GetSendRetryByID(retry.ID)
+	if got.Status != models.RetryStatusPending || got.Attempts != MaxRetryAttempts-1 || !got.NextAttemptAt.Equal(wechatSvc.ThrottledUntil().UTC()) {
+		t.Fatalf("throttled attempt should wait for the quota without using the budget: %+v", got)
+	}
+	if got.NextAttemptAt.Sub(now) < DailyQuotaBackoff-time.Second {
+		t.Errorf("daily quota should back off for %s, got %s", DailyQuotaBackoff, got.NextAttemptAt.Sub(now))
+	}
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"wechat-notification/models"
+)
+
+// ErrInvalidPriority is returned for a priority other than low, normal, high or urgent
+var ErrInvalidPriority = errors.New("priority must be low, normal, high or urgent")
+
+// NormalizePriority trims and validates a priority, defaulting to normal when empty
+func NormalizePriority(priority string) (string, error) {
+	priority = strings.ToLower(strings.TrimSpace(priority))
+	switch priority {
+	case "":
+		return models.PriorityNormal, nil
+	case models.PriorityLow, models.PriorityNormal, models.PriorityHigh, models.PriorityUrgent:
+		return priority, nil
+	}
+	return "", ErrInvalidPriority
+}
+
+// ValidateRoutingRule checks a rule's name, priorities, time window and channels, normalizing them in place
+func ValidateRoutingRule(rule *models.RoutingRule) error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" {
+		return errors.New("name is required")
+	}
+	for i, p := range rule.Priorities {
+		if strings.TrimSpace(p) == "" {
+			return ErrInvalidPriority
+		}
+		normalized, err := NormalizePriority(p)
+		if err != nil {
+			return err
+		}
+		rule.Priorities[i] = normalized
+	}
+	rule.TimeStart = strings.TrimSpace(rule.TimeStart)
+	rule.TimeEnd = strings.TrimSpace(rule.TimeEnd)
+	if err := ValidateQuietHours(rule.TimeStart, rule.TimeEnd); err != nil {
+		return errors.New("time window must be two HH:MM values")
+	}
+	// Skipping WeChat without another channel would drop matching messages
+	if len(rule.Channels) == 0 {
+		return errors.New("a rule needs at least one channel")
+	}
+	for i := range rule.Channels {
+		if err := ValidateChannel(&rule.Channels[i]); err != nil {
+			return errors.New("invalid " + rule.Channels[i].Type + " channel: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// RouteContext describes a send for routing rule matching
+type RouteContext struct {
+	TemplateKey string
+	Tags        []string
+	Priority    string
+}
+
+// MatchRoutingRules returns the enabled rules that apply to a send, in order, and whether
+// any of them asks to skip the WeChat template message. A matching rule with Stop ends evaluation.
+func MatchRoutingRules(rules []models.RoutingRule, rc RouteContext, now time.Time) ([]models.RoutingRule, bool) {
+	var matched []models.RoutingRule
+	skipWeChat := false
+	for _, rule := range rules {
+		if !rule.Enabled || !routingRuleMatches(rule, rc, now) {
+			continue
+		}
+		matched = append(matched, rule)
+		skipWeChat = skipWeChat || rule.SkipWeChat
+		if rule.Stop {
+			break
+		}
+	}
+	return matched, skipWeChat
+}
+
+func routingRuleMatches(rule models.RoutingRule, rc RouteContext, now time.Time) bool {
+	if len(rule.TemplateKeys) > 0 && !containsString(rule.TemplateKeys, rc.TemplateKey) {
+		return false
+	}
+	if len(rule.Priorities) > 0 && !containsString(rule.Priorities, rc.Priority) {
+		return false
+	}
+	if len(rule.Tags) > 0 {
+		found := false
+		for _, tag := range rc.Tags {
+			if containsString(rule.Tags, tag) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if rule.TimeStart != "" && !IsWithinQuietHours(rule.TimeStart, rule.TimeEnd, now) {
+		return false
+	}
+	return true
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+)
+
+func TestMatchRoutingRules(t *testing.T) {
+	rules := []models.RoutingRule{
+		{ID: 1, Enabled: true, Priorities: []string{models.PriorityUrgent}, SkipWeChat: true},
+		{ID: 2, Enabled: true, Tags: []string{"ops", "db"}},
+		{ID: 3, Enabled: true, TemplateKeys: []string{"backup"}, TimeStart: "22:00", TimeEnd: "07:00", Stop: true},
+		{ID: 4, Enabled: true},
+		{ID: 5, Enabled: false},
+	}
+	night := time.Date(2024, 3, 1, 23, 30, 0, 0, time.Local)
+	day := time.Date(2024, 3, 1, 14, 0, 0, 0, time.Local)
+
+	cases := []struct {
+		name     string
+		rc       RouteContext
+		now      time.Time
+		wantIDs  []int64
+		wantSkip bool
+	}{
+		{"catch-all only", RouteContext{TemplateKey: "deploy", Priority: models.PriorityNormal}, day, []int64{4}, false},
+		{"urgent skips wechat", RouteContext{TemplateKey: "deploy", Priority: models.PriorityUrgent}, day, []int64{1, 4}, true},
+		{"any tag matches", RouteContext{TemplateKey: "deploy", Tags: []string{"db"}, Priority: models.PriorityNormal}, day, []int64{2, 4}, false},
+		{"stop ends evaluation", RouteContext{TemplateKey: "backup", Priority: models.PriorityNormal}, night, []int64{3}, false},
+		{"outside time window", RouteContext{TemplateKey: "backup", Priority: models.PriorityNormal}, day, []int64{4}, false},
+	}
+	for _, tc := range cases {
+		matched, skip := MatchRoutingRules(rules, tc.rc, tc.now)
+		var ids []int64
+		for _, r := range matched {
+			ids = append(ids, r.ID)
+		}
+		if len(ids) != len(tc.wantIDs) || skip != tc.wantSkip {
+			t.Errorf("%s: matched %v skip=%v, want %v skip=%v", tc.name, ids, skip, tc.wantIDs, tc.wantSkip)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tc.wantIDs[i] {
+				t.Errorf("%s: matched %v, want %v", tc.name, ids, tc.wantIDs)
+				break
+			}
+		}
+	}
+}
+
+func TestValidateRoutingRule(t *testing.T) {
+	rule := models.RoutingRule{Name: " urgent ", Priorities: []string{"URGENT"}, SkipWeChat: true,
+		Channels: []models.Channel{{Type: models.ChannelServerChan, Token: "SCTkey"}}}
+	if err := ValidateRoutingRule(&rule); err != nil {
+		t.Fatalf("ValidateRoutingRule error: %v", err)
+	}
+	if rule.Name != "urgent" || rule.Priorities[0] != models.PriorityUrgent {
+		t.Errorf("rule not normalized: %+v", rule)
+	}
+
+	invalid := []models.RoutingRule{
+		{Name: "no action"},
+		{Name: "skip without channels", SkipWeChat: true},
+		{Name: "bad priority", Priorities: []string{"critical"}, SkipWeChat: true},
+		{Name: "half window", TimeStart: "22:00", SkipWeChat: true},
+		{Name: "bad channel", Channels: []models.Channel{{Type: "fax"}}},
+	}
+	for _, r := range invalid {
+		if err := ValidateRoutingRule(&r); err == nil {
+			t.Errorf("ValidateRoutingRule(%q) should fail", r.Name)
+		}
+	}
+}
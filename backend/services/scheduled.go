@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"wechat-notification/logger"
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+const (
+	// ScheduledSendTick is how often the sender looks for scheduled messages that are due
+	ScheduledSendTick = 10 * time.Second
+	// MaxSendDelay is the furthest in the future a webhook send can be scheduled
+	MaxSendDelay = 7 * 24 * time.Hour
+)
+
+var (
+	// ErrInvalidDelay is returned when a send delay is negative or given both as delaySeconds and sendAt
+	ErrInvalidDelay = errors.New("use either delaySeconds (>= 0) or sendAt, not both")
+	// ErrDelayTooLong is returned when a send is scheduled beyond MaxSendDelay
+	ErrDelayTooLong = fmt.Errorf("send time must be within %s", MaxSendDelay)
+)
+
+// ResolveSendAt turns a relative delay or an absolute send time into the time to send.
+// The zero time means send now; a sendAt in the past is also sent immediately.
+func ResolveSendAt(delaySeconds int, sendAt *time.Time, now time.Time) (time.Time, error) {
+	if delaySeconds < 0 || (delaySeconds > 0 && sendAt != nil) {
+		return time.Time{}, ErrInvalidDelay
+	}
+
+	var at time.Time
+	switch {
+	case sendAt != nil:
+		at = *sendAt
+	case delaySeconds > 0:
+		at = now.Add(time.Duration(delaySeconds) * time.Second)
+	}
+	if !at.After(now) {
+		return time.Time{}, nil
+	}
+	if at.Sub(now) > MaxSendDelay {
+		return time.Time{}, ErrDelayTooLong
+	}
+	return at, nil
+}
+
+// ScheduledSender delivers webhook sends that were queued with a delay
+type ScheduledSender struct {
+	repo   *repository.SQLiteRepository
+	sender *Sender
+}
+
+// NewScheduledSender creates a new scheduled sender
+func NewScheduledSender(repo *repository.SQLiteRepository, sender *Sender) *ScheduledSender {
+	return &ScheduledSender{repo: repo, sender: sender}
+}
+
+// Run sends due messages until ctx is cancelled. Messages a previous run claimed but never
+// finished are sent again first.
+func (s *ScheduledSender) Run(ctx context.Context) {
+	if reset, err := s.repo.ResetSendingScheduledMessages(); err != nil {
+		logger.Warnf("scheduled sender: failed to reset interrupted messages: %v", err)
+	} else if reset > 0 {
+		logger.Warnf("scheduled sender: resending %d message(s) interrupted by a restart", reset)
+	}
+
+	ticker := time.NewTicker(ScheduledSendTick)
+	defer ticker.Stop()
+
+	for {
+		s.sendDue(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *ScheduledSender) sendDue(now time.Time) {
+	// Scheduled messages stay pending during maintenance; sends held meanwhile go out first after it
+	if s.sender.maintenance.Enabled() {
+		return
+	}
+	if _, err := s.sender.ReleaseHeld(context.Background()); err != nil {
+		logger.Warnf("scheduled sender: failed to release held sends: %v", err)
+	}
+
+	due, err := s.repo.GetDueScheduledMessages(now)
+	if err != nil {
+		logger.Warnf("scheduled sender: failed to load due messages: %v", err)
+		return
+	}
+	for i := range due {
+		if err := s.Deliver(&due[i]); err != nil {
+			logger.Warnf("scheduled sender: message %d: %v", due[i].ID, err)
+		}
+	}
+}
+
+// Deliver claims a scheduled message and sends it, recording the outcome on the message.
+// Recipients are resolved at send time so members added in the meantime are included. The rest of
+// a canary broadcast is aborted instead when its canary send didn't pass.
+func (s *ScheduledSender) Deliver(m *models.ScheduledMessage) error {
+	claimed, err := s.repo.ClaimScheduledMessage(m.ID)
+	if err != nil || !claimed {
+		return err
+	}
+	if m.Canary != nil {
+		reason, err := s.canaryFailure(m.Canary)
+		if err != nil {
+			s.repo.CompleteScheduledMessage(m.ID, models.ScheduledStatusFailed, 0, err.Error())
+			return err
+		}
+		if reason != "" {
+			logger.Warnf("scheduled sender: message %d: aborted, %s", m.ID, reason)
+			return s.repo.CompleteScheduledMessage(m.ID, models.ScheduledStatusAborted, 0, reason)
+		}
+	}
+
+	response, err := s.send(m)
+	if err != nil {
+		s.repo.CompleteScheduledMessage(m.ID, models.ScheduledStatusFailed, 0, err.Error())
+		return err
+	}
+	return s.repo.CompleteScheduledMessage(m.ID, models.ScheduledStatusSent, response.MessageID, "")
+}
+
+func (s *ScheduledSender) send(m *models.ScheduledMessage) (SendResponse, error) {
+	template, err := LoadTemplate(s.repo, m.TemplateKey, m.Mode)
+	if err != nil {
+		return SendResponse{}, fmt.Errorf("template %q: %w", m.TemplateKey, err)
+	}
+	opts := SendOptions{
+		RequireAck:  m.RequireAck,
+		ReplyTo:     m.ReplyTo,
+		Channels:    m.Channels,
+		Tags:        m.Tags,
+		Priority:    m.Priority,
+		Source:      m.Source,
+		AppID:       m.AppID,
+		URL:         m.URL,
+		Miniprogram: m.Miniprogram,
+		Mode:        m.Mode,
+		MediaID:     m.MediaID,
+	}
+	if UsesDefaultGroups(template, m.RecipientIDs) {
+		return s.sender.SendToGroups(context.Background(), template, template.DefaultGroupIDs, m.Keywords, opts)
+	}
+
+	recipients, err := LoadRecipients(s.repo, m.RecipientIDs)
+	if err != nil {
+		return SendResponse{}, err
+	}
+	if len(recipients) == 0 {
+		return SendResponse{}, errors.New("no recipients found")
+	}
+	return s.sender.Send(context.Background(), template, recipients, m.Keywords, opts)
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveSendAt(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Minute)
+	future := now.Add(2 * time.Hour)
+	tooFar := now.Add(MaxSendDelay + time.Minute)
+
+	cases := []struct {
+		name    string
+		delay   int
+		sendAt  *time.Time
+		want    time.Time
+		wantErr error
+	}{
+		{"immediate", 0, nil, time.Time{}, nil},
+		{"delay", 90, nil, now.Add(90 * time.Second), nil},
+		{"sendAt", 0, &future, future, nil},
+		{"past sendAt sends now", 0, &past, time.Time{}, nil},
+		{"negative delay", -5, nil, time.Time{}, ErrInvalidDelay},
+		{"both given", 60, &future, time.Time{}, ErrInvalidDelay},
+		{"too far", 0, &tooFar, time.Time{}, ErrDelayTooLong},
+		{"delay too long", int(MaxSendDelay/time.Second) + 1, nil, time.Time{}, ErrDelayTooLong},
+	}
+	for _, tc := range cases {
+		got, err := ResolveSendAt(tc.delay, tc.sendAt, now)
+		if err != tc.wantErr || !got.Equal(tc.want) {
+			t.Errorf("%s: ResolveSendAt = %v, %v; want %v, %v", tc.name, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,790 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"wechat-notification/logger"
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+// SendResult represents the result of sending a message to a single recipient
+type SendResult struct {
+	RecipientID   int64  `json:"recipientId"`
+	RecipientName string `json:"recipientName"`
+	Success       bool   `json:"success"`
+	Skipped       bool   `json:"skipped,omitempty"` // Recipient muted or in quiet hours
+	Error         string `json:"error,omitempty"`
+	ErrCode       int    `json:"errCode,omitempty"` // WeChat errcode of a failed send
+	Code          string `json:"code,omitempty"`    // DEADLINE_EXCEEDED when the send was abandoned at its deadline
+	MsgID         int64  `json:"msgId,omitempty"`
+	RetryQueued   bool   `json:"retryQueued,omitempty"` // Transient failure handed to the retry queue
+	Throttled     bool   `json:"throttled,omitempty"`   // Held back by a WeChat API quota; not a failure, the retry queue sends it later
+
+	Fallback *ChannelResult `json:"fallback,omitempty"` // Retry through the recipient's fallback channel after a WeChat failure
+}
+
+// SendResponse represents the response for message sending
+type SendResponse struct {
+	MessageID      int64           `json:"messageId,omitempty"`
+	TotalCount     int             `json:"totalCount"`
+	TotalSent      int             `json:"totalSent"`
+	TotalFailed    int             `json:"totalFailed"`
+	TotalSkipped   int             `json:"totalSkipped"`
+	TotalThrottled int             `json:"totalThrottled"`
+	Results        []SendResult    `json:"results"`
+	Channels       []ChannelResult `json:"channels,omitempty"`
+	Held           bool            `json:"held,omitempty"`          // Received during maintenance mode and queued until it ends
+	Sandbox        bool            `json:"sandbox,omitempty"`       // Sent at least partly through a WeChat test account
+	Canary         *CanaryRollout  `json:"canary,omitempty"`        // Canary slice of a broadcast; the rest is scheduled
+	MissingGroups  []int64         `json:"missingGroups,omitempty"` // Groups of a group send that no longer exist
+}
+
+// ChannelResult represents the result of delivering to an extra channel.
+// GroupID, RecipientID or RuleID identifies where the channel came from; all are empty for per-message channels.
+type ChannelResult struct {
+	GroupID     int64  `json:"groupId,omitempty"`
+	RecipientID int64  `json:"recipientId,omitempty"`
+	RuleID      int64  `json:"ruleId,omitempty"` // Routing rule that selected the channel
+	Type        string `json:"type"`
+	Success     bool   `json:"success"`
+	Error       string `json:"error,omitempty"`
+}
+
+// SendOptions carries optional per-send behaviour
+type SendOptions struct {
+	RequireAck  bool                 // Attach a one-tap acknowledgement link to every message
+	ReplyTo     int64                // History ID of the message this send follows up on
+	Channels    []models.Channel     // Extra channels to deliver this message to; only public addresses are reached
+	Tags        []string             // Matched against routing rules
+	Priority    string               // Matched against routing rules; empty means normal
+	Source      models.MessageSource // Inbound request the send came from, recorded in the history
+	AppID       int64                // Official account to send through; 0 uses the template's account
+	URL         string               // Link opened by tapping the message; exclusive with RequireAck
+	Miniprogram *models.Miniprogram  // Mini program opened by tapping the message; exclusive with RequireAck
+	Mode        string               // models.SendModeText sends customer service text messages instead of the template
+	MediaID     string               // Image sent after each text message; text mode only
+
+	groupChannels []models.Channel // Channels of the groups sent to, set up by an admin and so free to reach the local network
+}
+
+// ErrReplyToNotFound is returned when SendOptions.ReplyTo doesn't match a message in the history
+var ErrReplyToNotFound = errors.New("message to reply to not found")
+
+// ErrTemplateOtherAccount is reported for recipients bound to another official account than the
+// one a template message is sent through, which can't use its template ID
+var ErrTemplateOtherAccount = errors.New("recipient is bound to another official account")
+
+// Sender runs the shared send pipeline used by the UI and webhook handlers
+type Sender struct {
+	repo          *repository.SQLiteRepository
+	wechatSvc     *WeChatService
+	apps          *AppRegistry
+	baseURL       string
+	channelClient *http.Client
+	publicClient  *http.Client // Delivers SendOptions.Channels
+	contacts      ContactSettings
+	sendTimeout   time.Duration
+	maintenance   *Maintenance
+	releaseMu     sync.Mutex // Serializes ReleaseHeld
+}
+
+// Codes set on SendResult.Code
+const (
+	// SendCodeDeadlineExceeded marks results whose WeChat call didn't finish before the send deadline
+	SendCodeDeadlineExceeded = "DEADLINE_EXCEEDED"
+	// SendCodeThrottled marks results held back by a WeChat quota error and queued for a retry
+	SendCodeThrottled = "THROTTLED_RETRYING"
+)
+
+// NewSender creates a new sender
+func NewSender(repo *repository.SQLiteRepository, wechatSvc *WeChatService, baseURL string) *Sender {
+	return &Sender{repo: repo, wechatSvc: wechatSvc, apps: NewAppRegistry(wechatSvc), baseURL: baseURL, channelClient: newChannelHTTPClient(),
+		publicClient: newPublicChannelHTTPClient()}
+}
+
+// SetApps connects the registry of official accounts that templates and sends can select
+func (s *Sender) SetApps(apps *AppRegistry) {
+	s.apps = apps
+}
+
+// SetContactSettings configures the SMTP server and Telegram bot used for recipients' email and Telegram contacts
+func (s *Sender) SetContactSettings(settings ContactSettings) {
+	s.contacts = settings
+}
+
+// SetSendTimeout bounds how long the WeChat calls of a single send may take; 0 disables the limit
+func (s *Sender) SetSendTimeout(d time.Duration) {
+	s.sendTimeout = d
+}
+
+// SetMaintenance connects the maintenance switch that holds sends instead of dispatching them
+func (s *Sender) SetMaintenance(m *Maintenance) {
+	s.maintenance = m
+}
+
+// UsesTokenProvider reports whether access tokens come from a central token service, so no AppSecret is configured
+func (s *Sender) UsesTokenProvider() bool {
+	return s.wechatSvc.tokenManager.UsesTokenProvider()
+}
+
+// Send applies recipient preferences, prepares acknowledgement links, delivers the template
+// and records the send in the message history. Cancelling ctx, or exceeding the send timeout,
+// abandons the WeChat calls still in flight and reports those recipients as DEADLINE_EXCEEDED.
+func (s *Sender) Send(ctx context.Context, template *models.MessageTemplate, recipients []models.Recipient, keywords map[string]string, opts SendOptions) (SendResponse, error) {
+	record := &models.Message{TemplateKey: template.Key, Keywords: keywords, MessageSource: opts.Source}
+	if opts.ReplyTo != 0 {
+		parent, err := s.repo.GetMessageByID(opts.ReplyTo)
+		if errors.Is(err, repository.ErrNotFound) {
+			return SendResponse{}, ErrReplyToNotFound
+		}
+		if err != nil {
+			return SendResponse{}, err
+		}
+		record.ReplyTo = &parent.ID
+		record.ThreadID = parent.ThreadID
+	}
+	appID := opts.AppID
+	if appID == 0 {
+		appID = template.AppID
+	}
+	if _, err := s.apps.Service(appID); err != nil {
+		return SendResponse{}, err
+	}
+	record.AppID = appID
+	if s.maintenance.Enabled() {
+		return s.hold(template, recipients, keywords, opts)
+	}
+
+	// Respect per-template opt-outs from recipient profiles
+	recipients, err := filterUnsubscribed(s.repo, recipients, template.Key)
+	if err != nil {
+		return SendResponse{}, err
+	}
+
+	var urls map[int64]string
+	var acks map[int64]int64
+	if opts.RequireAck {
+		urls, acks, err = createAckLinks(s.repo, s.baseURL, unskipped(recipients, time.Now()), template.Key)
+		if err != nil {
+			return SendResponse{}, err
+		}
+	} else if opts.URL != "" {
+		urls = make(map[int64]string, len(recipients))
+		for _, r := range recipients {
+			urls[r.ID] = opts.URL
+		}
+	}
+
+	rules, skipWeChat := s.route(template, opts)
+
+	var response SendResponse
+	if skipWeChat {
+		response = skipAll(recipients, "routed away from WeChat by rule")
+	} else {
+		sendCtx, cancel := ctx, context.CancelFunc(func() {})
+		if s.sendTimeout > 0 {
+			sendCtx, cancel = context.WithTimeout(ctx, s.sendTimeout)
+		}
+		response = s.sendWeChat(sendCtx, appID, template, recipients, wechatKeywords(template, keywords, opts.Mode), urls, opts)
+		cancel()
+	}
+	s.recordActivity(response.Results)
+	retries := markRetries(response.Results)
+	s.deliverFallbacks(template, recipients, keywords, urls, opts.Priority, response.Results, retries)
+
+	notification := BuildNotification(template, keywords)
+	notification.Priority = opts.Priority
+	if len(opts.Channels) > 0 {
+		response.Channels = s.deliverChannels(s.publicClient, notification, ChannelResult{}, opts.Channels)
+	}
+	response.Channels = append(response.Channels, s.deliverChannels(s.channelClient, notification, ChannelResult{}, opts.groupChannels)...)
+	for _, rule := range rules {
+		response.Channels = append(response.Channels, s.deliverChannels(s.channelClient, notification, ChannelResult{RuleID: rule.ID}, rule.Channels)...)
+	}
+
+	// Personal channels follow the same mute and quiet-hours rules as the WeChat message
+	now := time.Now()
+	for _, r := range recipients {
+		if len(r.Channels) == 0 || skipReason(r, now) != "" {
+			continue
+		}
+		personal := recipientNotification(template, keywords, r, urls[r.ID], opts.Priority)
+		response.Channels = append(response.Channels, s.deliverChannels(s.channelClient, personal, ChannelResult{RecipientID: r.ID}, r.Channels)...)
+	}
+	response.Channels = append(response.Channels, s.deliverContacts(template, recipients, keywords, urls, opts.Priority, now)...)
+	s.dropUndeliveredAcks(acks, response)
+
+	record.TotalCount = response.TotalCount
+	record.TotalSent = response.TotalSent
+	record.TotalFailed = response.TotalFailed
+	record.TotalSkipped = response.TotalSkipped
+	record.TotalThrottled = response.TotalThrottled
+	record.Sandbox = response.Sandbox
+	record.Results, _ = json.Marshal(response.Results)
+	logger.Debugf("sender: template %s to %d recipients: %d sent, %d failed, %d skipped, %d throttled",
+		template.Key, response.TotalCount, response.TotalSent, response.TotalFailed, response.TotalSkipped, response.TotalThrottled)
+
+	// The messages are already out, so a history failure is logged rather than reported
+	if err := s.repo.CreateMessage(record); err != nil {
+		logger.Warnf("sender: failed to record message history: %v", err)
+	} else {
+		response.MessageID = record.ID
+	}
+	s.enqueueRetries(appID, template, recipients, wechatKeywords(template, keywords, opts.Mode), urls, opts, retries, record.ID)
+	return response, nil
+}
+
+// wechatKeywords returns the keywords of the WeChat message. Text messages have no template
+// title, so the template's name heads them unless the keywords set "first".
+func wechatKeywords(template *models.MessageTemplate, keywords map[string]string, mode string) map[string]string {
+	if mode != models.SendModeText || template.Name == "" || keywords["first"] != "" {
+		return keywords
+	}
+	titled := make(map[string]string, len(keywords)+1)
+	for k, v := range keywords {
+		titled[k] = v
+	}
+	titled["first"] = template.Name
+	return titled
+}
+
+// sendWeChat delivers the template through the official account each recipient belongs to, since
+// OpenIDs are scoped to an account. Results keep the order of recipients.
+func (s *Sender) sendWeChat(ctx context.Context, sendApp int64, template *models.MessageTemplate, recipients []models.Recipient, keywords map[string]string, urls map[int64]string, opts SendOptions) SendResponse {
+	var appOrder []int64
+	byApp := map[int64][]models.Recipient{}
+	for _, r := range recipients {
+		if _, ok := byApp[r.AppID]; !ok {
+			appOrder = append(appOrder, r.AppID)
+		}
+		byApp[r.AppID] = append(byApp[r.AppID], r)
+	}
+
+	response := SendResponse{TotalCount: len(recipients)}
+	results := make(map[int64]SendResult, len(recipients))
+	for _, appID := range appOrder {
+		wechatSvc, templateID, mode, err := s.wechatRoute(appID, sendApp, template, opts.Mode)
+		if err != nil {
+			for _, r := range byApp[appID] {
+				response.TotalFailed++
+				results[r.ID] = SendResult{RecipientID: r.ID, RecipientName: r.Name, Error: err.Error()}
+			}
+			continue
+		}
+		part := SendMessages(ctx, wechatSvc, byApp[appID], templateID, mode, keywords, urls, opts.Miniprogram, opts.MediaID)
+		response.Sandbox = response.Sandbox || wechatSvc.Sandbox()
+		response.TotalSent += part.TotalSent
+		response.TotalFailed += part.TotalFailed
+		response.TotalSkipped += part.TotalSkipped
+		response.TotalThrottled += part.TotalThrottled
+		for _, r := range part.Results {
+			results[r.RecipientID] = r
+		}
+	}
+	for _, r := range recipients {
+		response.Results = append(response.Results, results[r.ID])
+	}
+	return response
+}
+
+// wechatRoute picks the WeChat service, template ID and message mode for recipients of an
+// official account: subscribe or text, or empty for a template message. Text sends need no
+// template, so they reach recipients of any account. Template IDs are scoped to an account, so
+// recipients of another account than the send's fail with ErrTemplateOtherAccount rather than
+// getting some other template.
+func (s *Sender) wechatRoute(appID, sendApp int64, template *models.MessageTemplate, sendMode string) (*WeChatService, string, string, error) {
+	wechatSvc, err := s.apps.Service(appID)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if sendMode == models.SendModeText {
+		return wechatSvc, "", models.SendModeText, nil
+	}
+	if appID != sendApp {
+		return nil, "", "", fmt.Errorf("%w: template %s is sent through account %d, the recipient is bound to account %d",
+			ErrTemplateOtherAccount, template.Key, sendApp, appID)
+	}
+	mode := ""
+	if template.Mode == models.TemplateModeSubscribe {
+		mode = models.TemplateModeSubscribe
+	}
+	return wechatSvc, template.TemplateID, mode, nil
+}
+
+// Preview builds the template message a send would post to WeChat for recipient, without sending
+// it. It goes through the same account routing and recipient substitution as a send; without a
+// recipient the message goes to the send's account and has no touser.
+func (s *Sender) Preview(template *models.MessageTemplate, recipient *models.Recipient, keywords map[string]string, appID int64) (*models.WeChatTemplateMessage, error) {
+	sendApp := appID
+	if sendApp == 0 {
+		sendApp = template.AppID
+	}
+	target, openID, fields := sendApp, "", RenderMessageFields(keywords, models.Recipient{})
+	if recipient != nil {
+		target, openID, fields = recipient.AppID, recipient.OpenID, RenderMessageFields(keywords, *recipient)
+	}
+	wechatSvc, templateID, mode, err := s.wechatRoute(target, sendApp, template, "")
+	if err != nil {
+		return nil, err
+	}
+	msg := wechatSvc.FormatTemplateMessage(openID, templateID, fields)
+	msg.Mode = mode
+	return msg, nil
+}
+
+// markRetries flags results that failed with a transient error or were throttled and returns their recipient IDs
+func markRetries(results []SendResult) map[int64]bool {
+	retries := map[int64]bool{}
+	for i := range results {
+		if results[i].Throttled || (!results[i].Success && !results[i].Skipped && IsTransientErrCode(results[i].ErrCode)) {
+			results[i].RetryQueued = true
+			retries[results[i].RecipientID] = true
+		}
+	}
+	return retries
+}
+
+// enqueueRetries hands transient failures to the retry queue with the same message that was first sent.
+// While WeChat is throttling the recipient's account, the first retry waits until the quota frees up.
+func (s *Sender) enqueueRetries(sendApp int64, template *models.MessageTemplate, recipients []models.Recipient, keywords map[string]string, urls map[int64]string, opts SendOptions, retries map[int64]bool, messageID int64) {
+	for _, r := range recipients {
+		if !retries[r.ID] {
+			continue
+		}
+		wechatSvc, templateID, mode, err := s.wechatRoute(r.AppID, sendApp, template, opts.Mode)
+		if err != nil {
+			continue
+		}
+		next := time.Now().Add(RetryDelay(1))
+		if until := wechatSvc.ThrottledUntil(); until.After(next) {
+			next = until
+		}
+		msg := formatMessage(wechatSvc, r, templateID, mode, keywords, urls[r.ID], opts.Miniprogram, opts.MediaID)
+		retry := &models.SendRetry{
+			MessageID:     messageID,
+			RecipientID:   r.ID,
+			AppID:         r.AppID,
+			Message:       *msg,
+			NextAttemptAt: next,
+		}
+		if err := s.repo.CreateSendRetry(retry); err != nil {
+			logger.Warnf("sender: failed to queue retry for recipient %d: %v", r.ID, err)
+		}
+	}
+}
+
+// recordActivity tracks each recipient's last delivery and unsubscribed failures for the stale-recipient report.
+// Like the history, it is bookkeeping after the messages went out, so failures are only logged.
+func (s *Sender) recordActivity(results []SendResult) {
+	var delivered, unsubscribed []int64
+	for _, r := range results {
+		switch {
+		case r.Success:
+			delivered = append(delivered, r.RecipientID)
+		case IsUnsubscribedErrCode(r.ErrCode):
+			unsubscribed = append(unsubscribed, r.RecipientID)
+		}
+	}
+	if err := s.repo.RecordDeliveries(delivered, unsubscribed); err != nil {
+		logger.Warnf("sender: failed to record recipient activity: %v", err)
+	}
+}
+
+// route evaluates the routing rules for a send. Rules only add channels, so a failure to load
+// them is logged and the send continues as if none matched.
+func (s *Sender) route(template *models.MessageTemplate, opts SendOptions) ([]models.RoutingRule, bool) {
+	rules, err := s.repo.GetAllRoutingRules()
+	if err != nil {
+		logger.Warnf("sender: failed to load routing rules: %v", err)
+		return nil, false
+	}
+	priority := opts.Priority
+	if priority == "" {
+		priority = models.PriorityNormal
+	}
+	return MatchRoutingRules(rules, RouteContext{TemplateKey: template.Key, Tags: opts.Tags, Priority: priority}, time.Now())
+}
+
+// SendToGroups sends to the members of the groups and to every channel configured on those groups.
+// Groups deleted since the send was set up are reported in MissingGroups.
+func (s *Sender) SendToGroups(ctx context.Context, template *models.MessageTemplate, groupIDs []int64, keywords map[string]string, opts SendOptions) (SendResponse, error) {
+	recipients, err := s.repo.GetRecipientsByGroupIDs(groupIDs)
+	if err != nil {
+		return SendResponse{}, err
+	}
+	groups, missing, err := s.loadGroups(groupIDs)
+	if err != nil {
+		return SendResponse{}, err
+	}
+	// Held sends keep the group channels alongside the message's own
+	if s.maintenance.Enabled() {
+		opts.groupChannels = nil
+		for _, group := range groups {
+			opts.groupChannels = append(opts.groupChannels, group.Channels...)
+		}
+		response, err := s.Send(ctx, template, recipients, keywords, opts)
+		response.MissingGroups = missing
+		return response, err
+	}
+	response, err := s.Send(ctx, template, recipients, keywords, opts)
+	if err != nil {
+		return response, err
+	}
+	response.MissingGroups = missing
+
+	notification := BuildNotification(template, keywords)
+	notification.Priority = opts.Priority
+	for _, group := range groups {
+		response.Channels = append(response.Channels, s.deliverChannels(s.channelClient, notification, ChannelResult{GroupID: group.ID}, group.Channels)...)
+	}
+	return response, nil
+}
+
+// loadGroups returns the groups with the given IDs and the IDs of those that don't exist
+func (s *Sender) loadGroups(ids []int64) ([]models.Group, []int64, error) {
+	var groups []models.Group
+	var missing []int64
+	for _, id := range ids {
+		group, err := s.repo.GetGroupByID(id)
+		if errors.Is(err, repository.ErrNotFound) {
+			logger.Warnf("sender: group %d of a group send not found", id)
+			missing = append(missing, id)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		groups = append(groups, *group)
+	}
+	return groups, missing, nil
+}
+
+// deliverFallbacks retries recipients whose WeChat message failed through their fallback channel,
+// recording the attempt on the recipient's result next to the original error. A delivered fallback
+// cancels the recipient's WeChat retry.
+func (s *Sender) deliverFallbacks(template *models.MessageTemplate, recipients []models.Recipient, keywords map[string]string, urls map[int64]string, priority string, results []SendResult, retries map[int64]bool) {
+	byID := make(map[int64]models.Recipient, len(recipients))
+	for _, r := range recipients {
+		byID[r.ID] = r
+	}
+	for i := range results {
+		r, ok := byID[results[i].RecipientID]
+		if results[i].Success || results[i].Skipped || results[i].Throttled || !ok || r.FallbackChannel == nil {
+			continue
+		}
+		notification := recipientNotification(template, keywords, r, urls[r.ID], priority)
+		fallback := s.deliverChannels(s.channelClient, notification, ChannelResult{RecipientID: r.ID}, []models.Channel{*r.FallbackChannel})[0]
+		results[i].Fallback = &fallback
+		// The message reached the recipient, so retrying WeChat later would deliver it twice
+		if fallback.Success && results[i].RetryQueued {
+			results[i].RetryQueued = false
+			delete(retries, r.ID)
+		}
+		logger.Debugf("sender: recipient %d fell back to %s: success=%v", r.ID, fallback.Type, fallback.Success)
+	}
+}
+
+// deliverContacts sends to the email and Telegram contacts recipients listed in their preferences
+func (s *Sender) deliverContacts(template *models.MessageTemplate, recipients []models.Recipient, keywords map[string]string, urls map[int64]string, priority string, now time.Time) []ChannelResult {
+	var results []ChannelResult
+	for _, r := range recipients {
+		if skipReason(r, now) != "" {
+			continue
+		}
+		for _, contact := range []string{models.ContactEmail, models.ContactTelegram} {
+			if !Prefers(r, contact) {
+				continue
+			}
+			notification := recipientNotification(template, keywords, r, urls[r.ID], priority)
+			result := ChannelResult{RecipientID: r.ID, Type: contact, Success: true}
+			notifier, err := s.contactNotifier(contact, r)
+			if err == nil {
+				err = notifier.Notify(notification)
+			}
+			if err != nil {
+				result.Success = false
+				result.Error = err.Error()
+			}
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// recipientNotification builds the notification for a single recipient with their placeholders filled in
+func recipientNotification(template *models.MessageTemplate, keywords map[string]string, r models.Recipient, url, priority string) Notification {
+	n := BuildNotification(template, SubstituteRecipientFields(keywords, r))
+	n.URL = url
+	n.Priority = priority
+	return n
+}
+
+// deliverChannels sends a notification to each channel through client; owner carries the group or
+// recipient the channels belong to
+func (s *Sender) deliverChannels(client *http.Client, notification Notification, owner ChannelResult, channels []models.Channel) []ChannelResult {
+	results := make([]ChannelResult, 0, len(channels))
+	for _, ch := range channels {
+		result := owner
+		result.Type, result.Success = ch.Type, true
+		notifier, err := NewChannelNotifier(ch, client)
+		if err == nil {
+			err = notifier.Notify(notification)
+		}
+		if err != nil {
+			result.Success = false
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// SendMessages sends messages to recipients and returns the response
+// Recipients who muted themselves, are inside their quiet hours or left WeChat out of their preferences are skipped.
+// mode is subscribe or text to send subscribe or customer service text messages instead of template messages.
+// urls optionally maps recipient IDs to the jump URL attached to their message; miniprogram, when
+// set, is attached to every template or subscribe message, and mediaID to every text message.
+func SendMessages(ctx context.Context, wechatSvc *WeChatService, recipients []models.Recipient, templateID, mode string, keywords map[string]string, urls map[int64]string, miniprogram *models.Miniprogram, mediaID string) SendResponse {
+	now := time.Now()
+	var msgs []*models.WeChatTemplateMessage
+	for _, r := range recipients {
+		if wechatSkipReason(r, now) == "" {
+			msgs = append(msgs, formatMessage(wechatSvc, r, templateID, mode, keywords, urls[r.ID], miniprogram, mediaID))
+		}
+	}
+
+	results := wechatSvc.SendTemplateMessages(ctx, msgs)
+
+	var sendResults []SendResult
+	successCount, failureCount, skippedCount, throttledCount := 0, 0, 0, 0
+
+	for _, r := range recipients {
+		if reason := wechatSkipReason(r, now); reason != "" {
+			skippedCount++
+			sendResults = append(sendResults, SendResult{
+				RecipientID:   r.ID,
+				RecipientName: r.Name,
+				Skipped:       true,
+				Error:         reason,
+			})
+			continue
+		}
+
+		result := results[r.OpenID]
+		success := result != nil && result.ErrCode == 0
+
+		sendResult := SendResult{
+			RecipientID:   r.ID,
+			RecipientName: r.Name,
+			Success:       success,
+		}
+
+		if success {
+			successCount++
+			if result != nil {
+				sendResult.MsgID = result.MsgID
+			}
+		} else if result != nil && IsThrottledErrCode(result.ErrCode) {
+			throttledCount++
+			sendResult.Throttled = true
+			sendResult.Error = result.ErrMsg
+			sendResult.ErrCode = result.ErrCode
+			sendResult.Code = SendCodeThrottled
+		} else {
+			failureCount++
+			if result != nil {
+				sendResult.Error = result.ErrMsg
+				sendResult.ErrCode = result.ErrCode
+				if result.ErrCode == WeChatErrDeadlineExceeded {
+					sendResult.Code = SendCodeDeadlineExceeded
+				}
+			}
+		}
+
+		sendResults = append(sendResults, sendResult)
+	}
+
+	return SendResponse{
+		TotalCount:     len(recipients),
+		TotalSent:      successCount,
+		TotalFailed:    failureCount,
+		TotalSkipped:   skippedCount,
+		TotalThrottled: throttledCount,
+		Results:        sendResults,
+	}
+}
+
+// formatMessage builds the WeChat message for a recipient with their placeholders filled in
+func formatMessage(wechatSvc *WeChatService, r models.Recipient, templateID, mode string, keywords map[string]string, url string, miniprogram *models.Miniprogram, mediaID string) *models.WeChatTemplateMessage {
+	if mode == models.SendModeText {
+		msg := wechatSvc.FormatTextMessage(r.OpenID, SubstituteRecipientFields(keywords, r), url)
+		msg.MediaID = mediaID
+		return msg
+	}
+	msg := wechatSvc.FormatTemplateMessage(r.OpenID, templateID, RenderMessageFields(keywords, r))
+	msg.URL = url
+	msg.Miniprogram = miniprogram
+	msg.Mode = mode
+	return msg
+}
+
+// skipAll reports every recipient as skipped for the given reason
+func skipAll(recipients []models.Recipient, reason string) SendResponse {
+	results := make([]SendResult, 0, len(recipients))
+	for _, r := range recipients {
+		results = append(results, SendResult{RecipientID: r.ID, RecipientName: r.Name, Skipped: true, Error: reason})
+	}
+	return SendResponse{TotalCount: len(recipients), TotalSkipped: len(recipients), Results: results}
+}
+
+// skipReason returns why a recipient should not be messaged right now, or "" to send
+func skipReason(r models.Recipient, now time.Time) string {
+	if r.Muted {
+		return "recipient muted"
+	}
+	if r.Deactivated {
+		return "recipient deactivated"
+	}
+	if IsWithinQuietHours(r.QuietHoursStart, r.QuietHoursEnd, now) {
+		return "recipient in quiet hours"
+	}
+	return ""
+}
+
+// wechatSkipReason extends skipReason with recipients who only want other contact channels
+func wechatSkipReason(r models.Recipient, now time.Time) string {
+	if reason := skipReason(r, now); reason != "" {
+		return reason
+	}
+	if !Prefers(r, models.ContactWeChat) {
+		return "wechat not in recipient preferences"
+	}
+	return ""
+}
+
+// filterUnsubscribed drops recipients who opted out of the template on their profile page
+func filterUnsubscribed(repo *repository.SQLiteRepository, recipients []models.Recipient, templateKey string) ([]models.Recipient, error) {
+	optedOut, err := repo.GetUnsubscribedRecipientIDs(templateKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(optedOut) == 0 {
+		return recipients, nil
+	}
+
+	filtered := make([]models.Recipient, 0, len(recipients))
+	for _, r := range recipients {
+		if !optedOut[r.ID] {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered, nil
+}
+
+// LoadRecipients returns the recipients with the given IDs, or every recipient when ids is empty.
+// Sends to everyone leave out recipients flagged as unsubscribed; naming them explicitly still reaches them.
+func LoadRecipients(repo *repository.SQLiteRepository, ids []int64) ([]models.Recipient, error) {
+	if len(ids) > 0 {
+		return repo.GetByIDs(ids)
+	}
+	all, err := repo.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	recipients := make([]models.Recipient, 0, len(all))
+	for _, r := range all {
+		if !r.Unsubscribed {
+			recipients = append(recipients, r)
+		}
+	}
+	return recipients, nil
+}
+
+// LoadTemplate returns the template a send names. Text sends that don't name a template use
+// models.TextTemplateKey, which needs no template record: a blank template stands in for it.
+func LoadTemplate(repo *repository.SQLiteRepository, key, mode string) (*models.MessageTemplate, error) {
+	template, err := repo.GetTemplateByKey(key)
+	if errors.Is(err, repository.ErrNotFound) && mode == models.SendModeText && key == models.TextTemplateKey {
+		return &models.MessageTemplate{Key: models.TextTemplateKey, Mode: models.TemplateModeTemplate, DefaultGroupIDs: []int64{}, Fields: []string{}}, nil
+	}
+	return template, err
+}
+
+// UsesDefaultGroups reports whether a send without explicit recipients should go to the template's default groups
+func UsesDefaultGroups(template *models.MessageTemplate, recipientIDs []int64) bool {
+	return len(recipientIDs) == 0 && len(template.DefaultGroupIDs) > 0
+}
+
+// createAckLinks creates a pending acknowledgement per recipient and returns their jump URLs and
+// the acknowledgement IDs, by recipient ID
+func createAckLinks(repo *repository.SQLiteRepository, baseURL string, recipients []models.Recipient, templateKey string) (map[int64]string, map[int64]int64, error) {
+	urls := make(map[int64]string, len(recipients))
+	acks := make(map[int64]int64, len(recipients))
+	for _, r := range recipients {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		ack := &models.Acknowledgement{
+			Token:       hex.EncodeToString(b),
+			RecipientID: r.ID,
+			TemplateKey: templateKey,
+		}
+		if err := repo.CreateAcknowledgement(ack); err != nil {
+			return nil, nil, err
+		}
+		urls[r.ID] = baseURL + "/api/ack/" + ack.Token
+		acks[r.ID] = ack.ID
+	}
+	return urls, acks, nil
+}
+
+// unskipped returns the recipients that are messaged right now, leaving out muted ones and those
+// in quiet hours
+func unskipped(recipients []models.Recipient, now time.Time) []models.Recipient {
+	result := make([]models.Recipient, 0, len(recipients))
+	for _, r := range recipients {
+		if skipReason(r, now) == "" {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// dropUndeliveredAcks deletes the acknowledgement links that no channel carried to their
+// recipient, so nobody waits for a confirmation that can't come. Links of sends queued for a
+// retry are kept.
+func (s *Sender) dropUndeliveredAcks(acks map[int64]int64, response SendResponse) {
+	if len(acks) == 0 {
+		return
+	}
+	reached := map[int64]bool{}
+	for _, r := range response.Results {
+		if r.Success || r.RetryQueued || (r.Fallback != nil && r.Fallback.Success) {
+			reached[r.RecipientID] = true
+		}
+	}
+	for _, ch := range response.Channels {
+		if ch.RecipientID != 0 && ch.Success {
+			reached[ch.RecipientID] = true
+		}
+	}
+	var undelivered []int64
+	for recipientID, ackID := range acks {
+		if !reached[recipientID] {
+			undelivered = append(undelivered, ackID)
+		}
+	}
+	if err := s.repo.DeleteAcknowledgements(undelivered); err != nil {
+		logger.Warnf("sender: failed to delete undelivered acknowledgement links: %v", err)
+	}
+}
@@ -0,0 +1,337 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+func TestDeliverFallbacks(t *testing.T) {
+	var delivered []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		delivered = append(delivered, payload["title"].(string))
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	fallback := &models.Channel{Type: models.ChannelNtfy, ServerURL: server.URL, Topic: "me"}
+	recipients := []models.Recipient{
+		{ID: 1, Name: "张三", FallbackChannel: fallback},
+		{ID: 2, Name: "李四", FallbackChannel: fallback},
+		{ID: 3, Name: "王五"},
+		{ID: 4, Name: "赵六", FallbackChannel: fallback},
+	}
+	results := []SendResult{
+		{RecipientID: 1, ErrCode: -1, Error: "system busy", RetryQueued: true},
+		{RecipientID: 2, Success: true},
+		{RecipientID: 3, Error: "require subscribe"},
+		{RecipientID: 4, Skipped: true, Error: "recipient muted"},
+	}
+
+	s := &Sender{channelClient: server.Client()}
+	template := &models.MessageTemplate{Key: "alert", Name: "告警"}
+	retries := map[int64]bool{1: true}
+	s.deliverFallbacks(template, recipients, map[string]string{"first": "{{name}}，磁盘告警"}, nil, "", results, retries)
+
+	if len(delivered) != 1 || delivered[0] != "张三，磁盘告警" {
+		t.Fatalf("delivered = %v, want only the failed recipient with a fallback", delivered)
+	}
+	if results[0].Fallback == nil || !results[0].Fallback.Success || results[0].Error != "system busy" {
+		t.Errorf("result should keep the WeChat error and record the fallback: %+v", results[0])
+	}
+	if results[0].RetryQueued || retries[1] {
+		t.Error("a delivered fallback should cancel the WeChat retry")
+	}
+	for _, r := range results[1:] {
+		if r.Fallback != nil {
+			t.Errorf("recipient %d should not fall back", r.RecipientID)
+		}
+	}
+}
+
+func TestSendMessagesDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	client := &MockHTTPClient{PostFunc: func(url, contentType string, body io.Reader) (*http.Response, error) {
+		data, _ := io.ReadAll(body)
+		if strings.Contains(string(data), "o_stuck") {
+			<-release
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"errcode":0,"errmsg":"ok","msgid":1}`))}, nil
+	}}
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("token", time.Hour)
+	wechatSvc := NewWeChatServiceWithClient(tokens, "tpl", client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	recipients := []models.Recipient{{ID: 1, OpenID: "o_fast"}, {ID: 2, OpenID: "o_stuck"}}
+	start := time.Now()
+	response := SendMessages(ctx, wechatSvc, recipients, "tpl", "", map[string]string{"first": "hi"}, nil, nil, "")
+	if time.Since(start) > time.Second {
+		t.Fatalf("SendMessages should return at the deadline, took %s", time.Since(start))
+	}
+
+	if response.TotalSent != 1 || response.TotalFailed != 1 {
+		t.Fatalf("unexpected totals: %+v", response)
+	}
+	stuck := response.Results[1]
+	if stuck.Code != SendCodeDeadlineExceeded || stuck.ErrCode != WeChatErrDeadlineExceeded || IsTransientErrCode(stuck.ErrCode) {
+		t.Errorf("stuck recipient should report DEADLINE_EXCEEDED without a retry: %+v", stuck)
+	}
+	if response.Results[0].Code != "" {
+		t.Errorf("delivered recipient should have no code: %+v", response.Results[0])
+	}
+}
+
+func TestSendMessagesThrottled(t *testing.T) {
+	calls := 0
+	client := &MockHTTPClient{PostFunc: func(url, contentType string, body io.Reader) (*http.Response, error) {
+		calls++
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"errcode":45011,"errmsg":"api minute-quota reach limit"}`))}, nil
+	}}
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("token", time.Hour)
+	wechatSvc := NewWeChatServiceWithClient(tokens, "tpl", client)
+	wechatSvc.SetConcurrency(1)
+
+	recipients := []models.Recipient{{ID: 1, OpenID: "o1"}, {ID: 2, OpenID: "o2"}}
+	response := SendMessages(context.Background(), wechatSvc, recipients, "tpl", "", nil, nil, nil, "")
+
+	if response.TotalThrottled != 2 || response.TotalFailed != 0 {
+		t.Fatalf("throttled recipients should not count as failed: %+v", response)
+	}
+	for _, r := range response.Results {
+		if !r.Throttled || r.Code != SendCodeThrottled {
+			t.Errorf("result should be throttled: %+v", r)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("sends after the quota error should be held back, got %d API calls", calls)
+	}
+	if until := wechatSvc.ThrottledUntil(); time.Until(until) <= 0 || time.Until(until) > ThrottleBackoff {
+		t.Errorf("ThrottledUntil = %s", until)
+	}
+	if retries := markRetries(response.Results); len(retries) != 2 || !response.Results[0].RetryQueued {
+		t.Errorf("throttled recipients should be queued for retry: %v", retries)
+	}
+}
+
+func TestSendSandbox(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	var inFlight, maxInFlight int32
+	client := &MockHTTPClient{PostFunc: func(url, contentType string, body io.Reader) (*http.Response, error) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		if n > atomic.LoadInt32(&maxInFlight) {
+			atomic.StoreInt32(&maxInFlight, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"errcode":0,"errmsg":"ok"}`))}, nil
+	}}
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("token", time.Hour)
+	wechatSvc := NewWeChatServiceWithClient(tokens, "tpl", client)
+	wechatSvc.SetConcurrency(4)
+	wechatSvc.SetSandbox(true)
+
+	recipients := []models.Recipient{{ID: 1, OpenID: "o1"}, {ID: 2, OpenID: "o2"}, {ID: 3, OpenID: "o3"}}
+	sender := NewSender(repo, wechatSvc, "")
+	response, err := sender.Send(context.Background(), &models.MessageTemplate{Key: "k", TemplateID: "tpl"}, recipients, nil, SendOptions{})
+	if err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	if response.TotalSent != 3 || !response.Sandbox {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	if maxInFlight != 1 {
+		t.Errorf("sandbox sends should be serialized, saw %d in flight", maxInFlight)
+	}
+	if m, err := repo.GetMessageByID(response.MessageID); err != nil || !m.Sandbox {
+		t.Errorf("history should label the sandbox send: %+v, %v", m, err)
+	}
+}
+
+func TestSendSubscribeMode(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	var urls []string
+	var bodies []map[string]interface{}
+	var mu sync.Mutex
+	client := &MockHTTPClient{PostFunc: func(url, contentType string, body io.Reader) (*http.Response, error) {
+		var decoded map[string]interface{}
+		json.NewDecoder(body).Decode(&decoded)
+		mu.Lock()
+		urls = append(urls, url)
+		bodies = append(bodies, decoded)
+		mu.Unlock()
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"errcode":0,"errmsg":"ok"}`))}, nil
+	}}
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("token", time.Hour)
+	sender := NewSender(repo, NewWeChatServiceWithClient(tokens, "tpl", client), "")
+
+	template := &models.MessageTemplate{Key: "k", TemplateID: "sub-tpl", Mode: models.TemplateModeSubscribe}
+	recipients := []models.Recipient{{ID: 1, OpenID: "o1"}}
+	response, err := sender.Send(context.Background(), template, recipients, map[string]string{"thing1": "hi"}, SendOptions{URL: "https://example.com/a"})
+	if err != nil || response.TotalSent != 1 {
+		t.Fatalf("unexpected send: %+v, %v", response, err)
+	}
+	template.Mode = models.TemplateModeTemplate
+	sender.Send(context.Background(), template, recipients, nil, SendOptions{})
+
+	if len(urls) != 2 || !strings.HasPrefix(urls[0], WeChatSendSubscribeURL+"?") || !strings.HasPrefix(urls[1], WeChatSendMessageURL+"?") {
+		t.Fatalf("unexpected endpoints: %v", urls)
+	}
+	if bodies[0]["page"] != "https://example.com/a" || bodies[0]["url"] != nil || bodies[0]["mode"] != nil || bodies[0]["template_id"] != "sub-tpl" {
+		t.Errorf("unexpected subscribe body: %v", bodies[0])
+	}
+	if bodies[1]["mode"] != nil {
+		t.Errorf("template body carries the mode: %v", bodies[1])
+	}
+}
+
+func TestSendTextMode(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	var url string
+	var body map[string]interface{}
+	client := &MockHTTPClient{PostFunc: func(u, contentType string, b io.Reader) (*http.Response, error) {
+		url = u
+		json.NewDecoder(b).Decode(&body)
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"errcode":45015,"errmsg":"response out of time limit"}`))}, nil
+	}}
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("token", time.Hour)
+	sender := NewSender(repo, NewWeChatServiceWithClient(tokens, "tpl", client), "")
+
+	template, err := LoadTemplate(repo, models.TextTemplateKey, models.SendModeText)
+	if err != nil {
+		t.Fatalf("LoadTemplate error: %v", err)
+	}
+	if _, err := LoadTemplate(repo, models.TextTemplateKey, models.SendModeTemplate); err != repository.ErrNotFound {
+		t.Errorf("template sends shouldn't get the text stand-in, got %v", err)
+	}
+
+	recipients := []models.Recipient{{ID: 1, OpenID: "o1", Name: "Alice"}}
+	response, err := sender.Send(context.Background(), template, recipients, map[string]string{"first": "备份完成", "keyword1": "{{name}} 的 NAS"},
+		SendOptions{Mode: models.SendModeText, URL: "https://example.com/r"})
+	if err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	if !strings.HasPrefix(url, WeChatSendCustomURL+"?") {
+		t.Fatalf("text message sent to %s", url)
+	}
+	text, _ := body["text"].(map[string]interface{})
+	if body["msgtype"] != "text" || body["touser"] != "o1" || text["content"] != "备份完成\nAlice 的 NAS\nhttps://example.com/r" {
+		t.Errorf("unexpected text body: %v", body)
+	}
+	// Outside the 48h window the send fails outright rather than being retried
+	if response.TotalFailed != 1 || response.Results[0].ErrCode != WeChatErrOutOfWindow || response.Results[0].RetryQueued {
+		t.Errorf("unexpected response: %+v", response)
+	}
+	if m, _ := repo.GetMessageByID(response.MessageID); m.TemplateKey != models.TextTemplateKey {
+		t.Errorf("history recorded template %q", m.TemplateKey)
+	}
+}
+
+func TestSendAckLinks(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	var links []string
+	client := &MockHTTPClient{PostFunc: func(url, contentType string, body io.Reader) (*http.Response, error) {
+		var msg models.WeChatTemplateMessage
+		json.NewDecoder(body).Decode(&msg)
+		links = append(links, msg.URL)
+		reply := `{"errcode":0,"errmsg":"ok"}`
+		if msg.ToUser == "o_unfollowed" {
+			reply = `{"errcode":43004,"errmsg":"require subscribe"}`
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(reply))}, nil
+	}}
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("token", time.Hour)
+	sender := NewSender(repo, NewWeChatServiceWithClient(tokens, "tpl", client), "https://notify.example.com")
+
+	recipients := []models.Recipient{
+		{OpenID: "o_ok", Name: "张三"},
+		{OpenID: "o_muted", Name: "李四", Muted: true},
+		{OpenID: "o_unfollowed", Name: "王五"},
+	}
+	for i := range recipients {
+		repo.Create(&recipients[i])
+	}
+
+	response, err := sender.Send(context.Background(), &models.MessageTemplate{Key: "alert", TemplateID: "tpl"}, recipients, nil, SendOptions{RequireAck: true})
+	if err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	if response.TotalSent != 1 || response.TotalSkipped != 1 || response.TotalFailed != 1 || len(links) != 2 {
+		t.Fatalf("unexpected response: %+v, links %v", response, links)
+	}
+	acks, _ := repo.ListAcknowledgements(10)
+	if len(acks) != 1 || acks[0].RecipientID != recipients[0].ID {
+		t.Fatalf("only the recipient who got the message should have a link: %+v", acks)
+	}
+	if !strings.Contains(strings.Join(links, " "), "/api/ack/"+acks[0].Token) {
+		t.Errorf("sent links %v don't carry the kept token %s", links, acks[0].Token)
+	}
+}
+
+func TestSendToGroupsReportsMissingGroups(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	client := &MockHTTPClient{PostFunc: func(url, contentType string, body io.Reader) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"errcode":0,"errmsg":"ok"}`))}, nil
+	}}
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("token", time.Hour)
+	sender := NewSender(repo, NewWeChatServiceWithClient(tokens, "tpl", client), "")
+
+	recipient := &models.Recipient{OpenID: "o1", Name: "张三"}
+	repo.Create(recipient)
+	group := &models.Group{Name: "运维", RecipientIDs: []int64{recipient.ID}}
+	repo.CreateGroup(group)
+
+	response, err := sender.SendToGroups(context.Background(), &models.MessageTemplate{Key: "alert", TemplateID: "tpl"}, []int64{group.ID, 999}, nil, SendOptions{})
+	if err != nil {
+		t.Fatalf("SendToGroups error: %v", err)
+	}
+	if response.TotalSent != 1 || len(response.MissingGroups) != 1 || response.MissingGroups[0] != 999 {
+		t.Errorf("deleted group should be reported: %+v", response)
+	}
+}
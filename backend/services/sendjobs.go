@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"wechat-notification/logger"
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+// Send job statuses
+const (
+	SendJobQueued  = "queued"
+	SendJobRunning = "running"
+	SendJobDone    = "done"
+	SendJobFailed  = "failed"
+)
+
+const (
+	// maxRunningSendJobs is how many queued sends go out at once; each already sends to its
+	// recipients concurrently
+	maxRunningSendJobs = 2
+	// maxPendingSendJobs is how many jobs may be queued or running at once; more are refused
+	// rather than piling up goroutines behind the running ones
+	maxPendingSendJobs = 100
+	// maxSendJobs is how many jobs are kept; the oldest finished ones are deleted first
+	maxSendJobs = 200
+)
+
+// ErrSendQueueFull is returned when a send can't be queued because too many are still waiting
+var ErrSendQueueFull = errors.New("too many sends are queued, please retry later")
+
+// QueuedSend is what a send job delivers. It's stored with the job so the send can run again
+// after a restart.
+type QueuedSend struct {
+	TemplateKey   string                `json:"templateKey"`
+	Keywords      map[string]string     `json:"keywords"`
+	RecipientIDs  []int64               `json:"recipientIds"`  // Resolved when the send was queued
+	DefaultGroups bool                  `json:"defaultGroups"` // Send to the template's default groups instead
+	Canary        *models.CanaryOptions `json:"canary,omitempty"`
+	Options       SendOptions           `json:"options"`
+}
+
+// SendJobs runs webhook sends in the background, for callers that can't wait for every
+// recipient. Jobs are stored so their results can be looked up and so sends a restart
+// interrupted are resumed.
+type SendJobs struct {
+	repo   *repository.SQLiteRepository
+	sender *Sender
+	send   func(ctx context.Context, q QueuedSend) (SendResponse, error) // Delivers a job, s.deliver outside tests
+	slots  chan struct{}
+
+	mu      sync.Mutex
+	pending int // Jobs queued or running
+}
+
+// NewSendJobs creates a new send job queue
+func NewSendJobs(repo *repository.SQLiteRepository, sender *Sender) *SendJobs {
+	s := &SendJobs{repo: repo, sender: sender, slots: make(chan struct{}, maxRunningSendJobs)}
+	s.send = s.deliver
+	return s
+}
+
+// Start queues q for owner and returns the job without waiting for it. The send gets a context
+// of its own, since the request that queued it is over by the time it runs. It returns
+// ErrSendQueueFull once maxPendingSendJobs haven't finished yet.
+func (s *SendJobs) Start(owner string, q QueuedSend) (models.SendJob, error) {
+	request, err := json.Marshal(q)
+	if err != nil {
+		return models.SendJob{}, err
+	}
+
+	s.mu.Lock()
+	if s.pending >= maxPendingSendJobs {
+		s.mu.Unlock()
+		return models.SendJob{}, ErrSendQueueFull
+	}
+	s.pending++
+	s.mu.Unlock()
+
+	job := models.SendJob{Owner: owner, Status: SendJobQueued, Request: string(request)}
+	if err := s.repo.CreateSendJob(&job); err != nil {
+		s.done()
+		return models.SendJob{}, err
+	}
+	if err := s.repo.PruneSendJobs(maxSendJobs); err != nil {
+		logger.Warnf("send jobs: failed to delete old jobs: %v", err)
+	}
+
+	go s.run(job, q)
+	return job, nil
+}
+
+// Resume runs again the jobs that were queued or running when the process stopped. Like
+// scheduled sends, a job interrupted while running is sent again in full.
+func (s *SendJobs) Resume() {
+	jobs, err := s.repo.GetUnfinishedSendJobs()
+	if err != nil {
+		logger.Warnf("send jobs: failed to load interrupted jobs: %v", err)
+		return
+	}
+	if len(jobs) > 0 {
+		logger.Warnf("send jobs: resuming %d job(s) interrupted by a restart", len(jobs))
+	}
+	for _, job := range jobs {
+		var q QueuedSend
+		if err := json.Unmarshal([]byte(job.Request), &q); err != nil {
+			s.finish(job, SendResponse{}, fmt.Errorf("invalid stored request: %w", err))
+			continue
+		}
+		s.mu.Lock()
+		s.pending++
+		s.mu.Unlock()
+		go s.run(job, q)
+	}
+}
+
+// Get returns the current state of a job, if it was started by owner
+func (s *SendJobs) Get(owner string, id int64) (models.SendJob, bool) {
+	job, err := s.repo.GetSendJob(id)
+	if err != nil {
+		if !errors.Is(err, repository.ErrNotFound) {
+			logger.Warnf("send job %d: %v", id, err)
+		}
+		return models.SendJob{}, false
+	}
+	if job.Owner != owner {
+		return models.SendJob{}, false
+	}
+	return *job, true
+}
+
+func (s *SendJobs) run(job models.SendJob, q QueuedSend) {
+	s.slots <- struct{}{}
+	defer func() { <-s.slots }()
+	defer s.done()
+
+	if err := s.repo.SetSendJobStatus(job.ID, SendJobRunning); err != nil {
+		logger.Warnf("send job %d: %v", job.ID, err)
+	}
+	response, err := s.send(context.Background(), q)
+	s.finish(job, response, err)
+}
+
+// finish records the outcome of a job
+func (s *SendJobs) finish(job models.SendJob, response SendResponse, err error) {
+	if err != nil {
+		job.Status = SendJobFailed
+		job.Error = err.Error()
+		logger.Warnf("send job %d: %v", job.ID, err)
+	} else {
+		job.Status = SendJobDone
+		if job.Result, err = json.Marshal(response); err != nil {
+			logger.Warnf("send job %d: %v", job.ID, err)
+		}
+	}
+	if err := s.repo.FinishSendJob(&job); err != nil {
+		logger.Warnf("send job %d: failed to record the result: %v", job.ID, err)
+	}
+}
+
+func (s *SendJobs) done() {
+	s.mu.Lock()
+	s.pending--
+	s.mu.Unlock()
+}
+
+// deliver sends q through the send pipeline
+func (s *SendJobs) deliver(ctx context.Context, q QueuedSend) (SendResponse, error) {
+	template, err := LoadTemplate(s.repo, q.TemplateKey, q.Options.Mode)
+	if err != nil {
+		return SendResponse{}, fmt.Errorf("template %q: %w", q.TemplateKey, err)
+	}
+	if q.DefaultGroups {
+		return s.sender.SendToGroups(ctx, template, template.DefaultGroupIDs, q.Keywords, q.Options)
+	}
+	recipients, err := s.repo.GetByIDs(q.RecipientIDs)
+	if err != nil {
+		return SendResponse{}, err
+	}
+	if q.Canary != nil {
+		return s.sender.SendCanary(ctx, template, recipients, q.Keywords, q.Options, q.Canary)
+	}
+	return s.sender.Send(ctx, template, recipients, q.Keywords, q.Options)
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+func newTestSendJobs(t *testing.T, send func(ctx context.Context, q QueuedSend) (SendResponse, error)) (*SendJobs, *repository.SQLiteRepository) {
+	t.Helper()
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	jobs := NewSendJobs(repo, nil)
+	jobs.send = send
+	return jobs, repo
+}
+
+func waitSendJob(t *testing.T, jobs *SendJobs, owner string, id int64) models.SendJob {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if job, _ := jobs.Get(owner, id); job.FinishedAt != nil {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %d did not finish", id)
+	return models.SendJob{}
+}
+
+func TestSendJobs(t *testing.T) {
+	release := make(chan struct{})
+	jobs, _ := newTestSendJobs(t, func(ctx context.Context, q QueuedSend) (SendResponse, error) {
+		if q.TemplateKey == "broken" {
+			return SendResponse{}, errors.New("template not found")
+		}
+		<-release
+		return SendResponse{MessageID: 7, TotalCount: len(q.RecipientIDs), TotalSent: len(q.RecipientIDs)}, nil
+	})
+	job, err := jobs.Start("ci", QueuedSend{TemplateKey: "alert", RecipientIDs: []int64{1, 2}})
+	if err != nil || job.ID == 0 || job.Status != SendJobQueued {
+		t.Fatalf("unexpected job: %+v, %v", job, err)
+	}
+	if _, ok := jobs.Get("cron", job.ID); ok {
+		t.Error("job visible to another token")
+	}
+	close(release)
+	job = waitSendJob(t, jobs, "ci", job.ID)
+	var result SendResponse
+	if job.Status != SendJobDone || json.Unmarshal(job.Result, &result) != nil || result.TotalSent != 2 {
+		t.Fatalf("unexpected finished job: %+v", job)
+	}
+
+	failed, _ := jobs.Start("ci", QueuedSend{TemplateKey: "broken"})
+	if failed = waitSendJob(t, jobs, "ci", failed.ID); failed.Status != SendJobFailed || failed.Error != "template not found" || failed.Result != nil {
+		t.Errorf("unexpected failed job: %+v", failed)
+	}
+}
+
+func TestSendJobsResume(t *testing.T) {
+	sent := make(chan QueuedSend, 2)
+	jobs, repo := newTestSendJobs(t, func(ctx context.Context, q QueuedSend) (SendResponse, error) {
+		sent <- q
+		return SendResponse{TotalSent: 1}, nil
+	})
+
+	// Left queued and running by a process that stopped
+	queued := &models.SendJob{Owner: "ci", Status: SendJobQueued, Request: `{"templateKey":"alert","recipientIds":[1]}`}
+	running := &models.SendJob{Owner: "ci", Status: SendJobRunning, Request: `{"templateKey":"alert","recipientIds":[2]}`}
+	repo.CreateSendJob(queued)
+	repo.CreateSendJob(running)
+
+	jobs.Resume()
+	for _, id := range []int64{queued.ID, running.ID} {
+		if job := waitSendJob(t, jobs, "ci", id); job.Status != SendJobDone {
+			t.Errorf("interrupted job should be sent again: %+v", job)
+		}
+	}
+	if len(sent) != 2 {
+		t.Errorf("expected 2 resumed sends, got %d", len(sent))
+	}
+	if q := <-sent; q.TemplateKey != "alert" || len(q.RecipientIDs) != 1 {
+		t.Errorf("resumed send lost its request: %+v", q)
+	}
+}
+
+func TestSendJobsPrune(t *testing.T) {
+	jobs, _ := newTestSendJobs(t, func(ctx context.Context, q QueuedSend) (SendResponse, error) { return SendResponse{}, nil })
+	var first, last models.SendJob
+	for i := 0; i < maxSendJobs+5; i++ {
+		last, _ = jobs.Start("ci", QueuedSend{})
+		if i == 0 {
+			first = last
+		}
+		waitSendJob(t, jobs, "ci", last.ID)
+	}
+	if _, ok := jobs.Get("ci", first.ID); ok {
+		t.Error("oldest finished job was kept")
+	}
+	if _, ok := jobs.Get("ci", last.ID); !ok {
+		t.Error("newest job was deleted")
+	}
+}
+
+func TestSendJobsQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	jobs, _ := newTestSendJobs(t, func(ctx context.Context, q QueuedSend) (SendResponse, error) {
+		<-release
+		return SendResponse{}, nil
+	})
+	var last models.SendJob
+	for i := 0; i < maxPendingSendJobs; i++ {
+		var err error
+		if last, err = jobs.Start("ci", QueuedSend{}); err != nil {
+			t.Fatalf("job %d: %v", i, err)
+		}
+	}
+	if _, err := jobs.Start("ci", QueuedSend{}); err != ErrSendQueueFull {
+		t.Fatalf("expected ErrSendQueueFull, got %v", err)
+	}
+
+	close(release)
+	waitSendJob(t, jobs, "ci", last.ID)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := jobs.Start("ci", QueuedSend{}); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("queue still full after the jobs finished: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
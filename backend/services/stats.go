@@ -0,0 +1,120 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"wechat-notification/repository"
+)
+
+// MaxStatsWindow caps how far back failure statistics reach
+const MaxStatsWindow = 90 * 24 * time.Hour
+
+// FailureCounts counts failed deliveries by WeChat errcode, template and webhook token
+type FailureCounts struct {
+	Total      int            `json:"total"`
+	ByErrCode  map[string]int `json:"byErrCode"`  // errcode, or the result code (e.g. DEADLINE_EXCEEDED) when WeChat wasn't reached
+	ByTemplate map[string]int `json:"byTemplate"` // template key
+	ByToken    map[string]int `json:"byToken"`    // token name of the inbound request; "none" for UI and background sends
+}
+
+// FailureDay is the failure counts of one day, in server local time
+type FailureDay struct {
+	Date string `json:"date"` // 2006-01-02
+	FailureCounts
+}
+
+// FailureStats aggregates failed deliveries over a time window, per day and in total
+type FailureStats struct {
+	Window string        `json:"window"`
+	Since  time.Time     `json:"since"`
+	Days   []FailureDay  `json:"days"` // Every day in the window, oldest first, including days without failures
+	Totals FailureCounts `json:"totals"`
+}
+
+func newFailureCounts() FailureCounts {
+	return FailureCounts{ByErrCode: map[string]int{}, ByTemplate: map[string]int{}, ByToken: map[string]int{}}
+}
+
+func (c *FailureCounts) add(errCode, templateKey, tokenName string) {
+	c.Total++
+	c.ByErrCode[errCode]++
+	c.ByTemplate[templateKey]++
+	c.ByToken[tokenName]++
+}
+
+// ParseStatsWindow parses a window like "7d", "30d" or "12h"
+func ParseStatsWindow(window string) (time.Duration, error) {
+	var d time.Duration
+	if days := strings.TrimSuffix(window, "d"); days != window {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid window %q", window)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(window); err != nil {
+			return 0, fmt.Errorf("invalid window %q", window)
+		}
+	}
+	if d <= 0 || d > MaxStatsWindow {
+		return 0, fmt.Errorf("window must be positive and at most %d days", int(MaxStatsWindow.Hours()/24))
+	}
+	return d, nil
+}
+
+// CollectFailureStats counts the failed deliveries recorded in the message history within window
+// before now. Skipped and throttled results are not failures.
+func CollectFailureStats(repo *repository.SQLiteRepository, window string, d time.Duration, now time.Time) (FailureStats, error) {
+	since := now.Add(-d)
+	stats := FailureStats{Window: window, Since: since, Days: []FailureDay{}, Totals: newFailureCounts()}
+	dayIndex := map[string]int{}
+	for day := startOfDay(since); !day.After(now); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		dayIndex[date] = len(stats.Days)
+		stats.Days = append(stats.Days, FailureDay{Date: date, FailureCounts: newFailureCounts()})
+	}
+
+	messages, err := repo.GetFailedMessagesSince(since)
+	if err != nil {
+		return stats, err
+	}
+	for _, m := range messages {
+		var results []SendResult
+		if err := json.Unmarshal(m.Results, &results); err != nil {
+			continue
+		}
+		i, ok := dayIndex[m.CreatedAt.In(now.Location()).Format("2006-01-02")]
+		if !ok {
+			continue
+		}
+		token := m.TokenName
+		if token == "" {
+			token = "none"
+		}
+		for _, r := range results {
+			if r.Success || r.Skipped || r.Throttled {
+				continue
+			}
+			code := "unknown"
+			switch {
+			case r.ErrCode != 0:
+				code = strconv.Itoa(r.ErrCode)
+			case r.Code != "":
+				code = r.Code
+			}
+			stats.Days[i].add(code, m.TemplateKey, token)
+			stats.Totals.add(code, m.TemplateKey, token)
+		}
+	}
+	return stats, nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+func TestParseStatsWindow(t *testing.T) {
+	cases := map[string]time.Duration{"7d": 7 * 24 * time.Hour, "12h": 12 * time.Hour, "90d": MaxStatsWindow}
+	for window, want := range cases {
+		if got, err := ParseStatsWindow(window); err != nil || got != want {
+			t.Errorf("ParseStatsWindow(%q) = %s, %v, want %s", window, got, err, want)
+		}
+	}
+	for _, window := range []string{"", "xd", "0d", "-1h", "91d"} {
+		if _, err := ParseStatsWindow(window); err == nil {
+			t.Errorf("ParseStatsWindow(%q) should fail", window)
+		}
+	}
+}
+
+func TestCollectFailureStats(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	record := func(templateKey, tokenName string, results []SendResult) {
+		data, _ := json.Marshal(results)
+		repo.CreateMessage(&models.Message{TemplateKey: templateKey, TotalFailed: 1, Results: data, MessageSource: models.MessageSource{TokenName: tokenName}})
+	}
+	record("order", "webhook_token", []SendResult{
+		{RecipientID: 1, ErrCode: 43004},
+		{RecipientID: 2, ErrCode: 43004},
+		{RecipientID: 3, Success: true},
+		{RecipientID: 4, Throttled: true, ErrCode: 45009},
+	})
+	record("alert", "", []SendResult{{RecipientID: 1, Code: SendCodeDeadlineExceeded}, {RecipientID: 2, Skipped: true}})
+
+	now := time.Now()
+	stats, err := CollectFailureStats(repo, "7d", 7*24*time.Hour, now)
+	if err != nil {
+		t.Fatalf("CollectFailureStats error: %v", err)
+	}
+	if len(stats.Days) != 8 || stats.Days[7].Date != now.Format("2006-01-02") {
+		t.Fatalf("days should cover the window through today: %+v", stats.Days)
+	}
+	totals := stats.Totals
+	if totals.Total != 3 || totals.ByErrCode["43004"] != 2 || totals.ByErrCode[SendCodeDeadlineExceeded] != 1 ||
+		totals.ByTemplate["order"] != 2 || totals.ByToken["webhook_token"] != 2 || totals.ByToken["none"] != 1 {
+		t.Errorf("unexpected totals: %+v", totals)
+	}
+	if stats.Days[7].Total != 3 || stats.Days[0].Total != 0 {
+		t.Errorf("failures should be counted on today: %+v", stats.Days)
+	}
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"wechat-notification/logger"
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+const (
+	// StatsExportTick is how often the exporter checks whether yesterday's stats were exported
+	StatsExportTick = time.Hour
+	// StatsExportConfigKey stores the export targets
+	StatsExportConfigKey = "stats_export"
+	// statsExportLastKey stores the date of the last exported day
+	statsExportLastKey = "stats_export_last"
+)
+
+// StatsExporter pushes the previous day's aggregate delivery stats to a Prometheus remote-write
+// endpoint and/or writes them as a CSV file, for teams that collect metrics elsewhere
+type StatsExporter struct {
+	repo   *repository.SQLiteRepository
+	client *http.Client
+}
+
+// NewStatsExporter creates a new stats exporter
+func NewStatsExporter(repo *repository.SQLiteRepository) *StatsExporter {
+	return &StatsExporter{repo: repo, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Run exports each finished day once until ctx is cancelled
+func (e *StatsExporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(StatsExportTick)
+	defer ticker.Stop()
+
+	for {
+		e.exportDue(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *StatsExporter) exportDue(now time.Time) {
+	var cfg models.StatsExportConfig
+	if err := e.repo.GetJSONConfig(StatsExportConfigKey, &cfg); err != nil {
+		logger.Warnf("stats export: failed to load config: %v", err)
+		return
+	}
+	if cfg.RemoteWriteURL == "" && cfg.CSVDir == "" {
+		return
+	}
+	day := startOfDay(now).AddDate(0, 0, -1)
+	if last, _ := e.repo.GetConfig(statsExportLastKey); last == day.Format("2006-01-02") {
+		return
+	}
+	if _, err := e.ExportDay(cfg, day); err != nil {
+		logger.Warnf("stats export: %s: %v", day.Format("2006-01-02"), err)
+		return
+	}
+	if err := e.repo.SetConfig(statsExportLastKey, day.Format("2006-01-02")); err != nil {
+		logger.Warnf("stats export: failed to record export: %v", err)
+	}
+}
+
+// ExportDay exports the stats of the day starting at day to every configured target
+func (e *StatsExporter) ExportDay(cfg models.StatsExportConfig, day time.Time) ([]models.DailyTemplateStats, error) {
+	day = startOfDay(day)
+	stats, err := e.repo.GetDailyTemplateStats(day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.CSVDir != "" {
+		if err := writeStatsCSV(cfg.CSVDir, day, stats); err != nil {
+			return stats, fmt.Errorf("csv: %w", err)
+		}
+	}
+	if cfg.RemoteWriteURL != "" {
+		if err := e.remoteWrite(cfg, day, stats); err != nil {
+			return stats, fmt.Errorf("remote write: %w", err)
+		}
+	}
+	logger.Infof("stats export: exported %s for %d templates", day.Format("2006-01-02"), len(stats))
+	return stats, nil
+}
+
+// writeStatsCSV writes one day of stats to <dir>/tongzhi-stats-<date>.csv, replacing an earlier export
+func writeStatsCSV(dir string, day time.Time, stats []models.DailyTemplateStats) error {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"date", "template", "messages", "sent", "failed", "skipped", "throttled"})
+	for _, s := range stats {
+		w.Write([]string{s.Date, s.TemplateKey, strconv.Itoa(s.Messages), strconv.Itoa(s.Sent), strconv.Itoa(s.Failed),
+			strconv.Itoa(s.Skipped), strconv.Itoa(s.Throttled)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "tongzhi-stats-"+day.Format("2006-01-02")+".csv"), buf.Bytes(), 0o644)
+}
+
+// remoteWrite pushes the day's stats as samples stamped at the end of the day:
+// tongzhi_daily_messages{template} and tongzhi_daily_deliveries{template,status}
+func (e *StatsExporter) remoteWrite(cfg models.StatsExportConfig, day time.Time, stats []models.DailyTemplateStats) error {
+	ts := day.AddDate(0, 0, 1).Add(-time.Millisecond).UnixMilli()
+	var series [][]byte
+	for _, s := range stats {
+		series = append(series, encodeTimeSeries([][2]string{{"__name__", "tongzhi_daily_messages"}, {"template", s.TemplateKey}}, float64(s.Messages), ts))
+		for _, d := range []struct {
+			status string
+			count  int
+		}{{"sent", s.Sent}, {"failed", s.Failed}, {"skipped", s.Skipped}, {"throttled", s.Throttled}} {
+			labels := [][2]string{{"__name__", "tongzhi_daily_deliveries"}, {"status", d.status}, {"template", s.TemplateKey}}
+			series = append(series, encodeTimeSeries(labels, float64(d.count), ts))
+		}
+	}
+	if len(series) == 0 {
+		return nil
+	}
+
+	var body []byte
+	for _, s := range series {
+		body = appendBytesField(body, 1, s)
+	}
+	req, err := http.NewRequest(http.MethodPost, cfg.RemoteWriteURL, bytes.NewReader(snappyEncode(body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if cfg.RemoteWriteUsername != "" {
+		req.SetBasicAuth(cfg.RemoteWriteUsername, cfg.RemoteWritePassword)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// encodeTimeSeries encodes a prometheus.TimeSeries with one sample. Labels must be sorted by name.
+func encodeTimeSeries(labels [][2]string, value float64, timestampMs int64) []byte {
+	var ts []byte
+	for _, l := range labels {
+		var label []byte
+		label = appendBytesField(label, 1, []byte(l[0]))
+		label = appendBytesField(label, 2, []byte(l[1]))
+		ts = appendBytesField(ts, 1, label)
+	}
+	var sample []byte
+	sample = binary.AppendUvarint(sample, 1<<3|1) // value, fixed64
+	sample = binary.LittleEndian.AppendUint64(sample, math.Float64bits(value))
+	sample = binary.AppendUvarint(sample, 2<<3|0) // timestamp, varint
+	sample = binary.AppendUvarint(sample, uint64(timestampMs))
+	return appendBytesField(ts, 2, sample)
+}
+
+// appendBytesField appends a length-delimited protobuf field
+func appendBytesField(b []byte, field int, data []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// snappyEncode wraps data in the snappy block format using literal chunks only. The payload
+// isn't compressed, but every remote-write receiver can decode it without a snappy dependency here.
+func snappyEncode(data []byte) []byte {
+	out := binary.AppendUvarint(nil, uint64(len(data)))
+	for len(data) > 0 {
+		n := len(data)
+		if n > 1<<16 {
+			n = 1 << 16
+		}
+		// Literal tag with a two-byte length
+		out = append(out, 61<<2, byte(n-1), byte((n-1)>>8))
+		out = append(out, data[:n]...)
+		data = data[n:]
+	}
+	return out
+}
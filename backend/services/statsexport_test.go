@@ -0,0 +1,69 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+func TestStatsExportDay(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	repo.CreateMessage(&models.Message{TemplateKey: "order", TotalCount: 3, TotalSent: 2, TotalFailed: 1})
+	repo.CreateMessage(&models.Message{TemplateKey: "order", TotalCount: 1, TotalSent: 1})
+	repo.CreateMessage(&models.Message{TemplateKey: "alert", TotalCount: 1, TotalSkipped: 1})
+
+	var body []byte
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	exporter := NewStatsExporter(repo)
+	stats, err := exporter.ExportDay(models.StatsExportConfig{RemoteWriteURL: server.URL, RemoteWriteUsername: "u", CSVDir: dir}, time.Now())
+	if err != nil {
+		t.Fatalf("ExportDay error: %v", err)
+	}
+	if len(stats) != 2 || stats[1].TemplateKey != "order" || stats[1].Messages != 2 || stats[1].Sent != 3 || stats[1].Failed != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	csvData, err := os.ReadFile(filepath.Join(dir, "tongzhi-stats-"+time.Now().Format("2006-01-02")+".csv"))
+	if err != nil {
+		t.Fatalf("CSV not written: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(csvData)), "\n"); len(lines) != 3 || !strings.HasSuffix(lines[2], ",order,2,3,1,0,0") {
+		t.Errorf("unexpected CSV:\n%s", csvData)
+	}
+
+	if header.Get("Content-Encoding") != "snappy" || header.Get("Content-Type") != "application/x-protobuf" || header.Get("Authorization") == "" {
+		t.Errorf("unexpected remote-write headers: %v", header)
+	}
+	size, n := binary.Uvarint(body)
+	if n <= 0 || int(size) != len(body)-n-3 {
+		t.Fatalf("snappy header length %d doesn't match the %d byte payload", size, len(body)-n-3)
+	}
+	for _, want := range []string{"tongzhi_daily_messages", "tongzhi_daily_deliveries", "throttled", "order", "alert"} {
+		if !bytes.Contains(body, []byte(want)) {
+			t.Errorf("remote-write payload is missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"wechat-notification/models"
+)
+
+// MaxKeywordLength is the longest value we put in a field of a WeChat template message; longer
+// values are truncated. Text messages and other channels get the whole value.
+const MaxKeywordLength = 200
+
+// ErrInvalidMetadataKey is returned when a metadata key is empty or not a simple identifier
+var ErrInvalidMetadataKey = errors.New("metadata keys must be non-empty and contain only letters, digits, '_' or '-'")
+
+var (
+	metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+)
+
+// NormalizeMetadata validates metadata keys and trims values
+func NormalizeMetadata(metadata map[string]string) (map[string]string, error) {
+	normalized := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		key = strings.TrimSpace(key)
+		if !metadataKeyPattern.MatchString(key) {
+			return nil, ErrInvalidMetadataKey
+		}
+		normalized[key] = strings.TrimSpace(value)
+	}
+	return normalized, nil
+}
+
+// SubstituteRecipientFields replaces {{name}}, {{openId}} and {{metadata.<key>}}
+// placeholders in keyword values with the recipient's fields. Unknown
+// placeholders are left untouched so callers can spot typos in the message.
+func SubstituteRecipientFields(keywords map[string]string, recipient models.Recipient) map[string]string {
+	return renderKeywords(keywords, func(field string) (string, bool) {
+		switch {
+		case field == "name":
+			return recipient.Name, true
+		case field == "openId":
+			return recipient.OpenID, true
+		case strings.HasPrefix(field, "metadata."):
+			v, ok := recipient.Metadata[strings.TrimPrefix(field, "metadata.")]
+			return v, ok
+		}
+		return "", false
+	})
+}
+
+// RenderMessageFields produces the field values of a template message to a recipient: recipient
+// placeholders are substituted, then each value is capped at MaxKeywordLength
+func RenderMessageFields(keywords map[string]string, recipient models.Recipient) map[string]string {
+	fields := SubstituteRecipientFields(keywords, recipient)
+	for key, value := range fields {
+		fields[key] = TruncateRunes(value, MaxKeywordLength)
+	}
+	return fields
+}
+
+// RenderedField is one template field as it will be sent
+type RenderedField struct {
+	Key        string   `json:"key"`
+	Value      string   `json:"value"`
+	Truncated  bool     `json:"truncated"`
+	Unresolved []string `json:"unresolved,omitempty"` // Placeholders left in the value, e.g. typos
+}
+
+// RenderedMessage is a preview of a template message for one recipient
+type RenderedMessage struct {
+	TemplateKey string          `json:"templateKey"`
+	TemplateID  string          `json:"templateId"`
+	Fields      []RenderedField `json:"fields"`
+	Title       string          `json:"title"`   // Title used by non-WeChat channels
+	Content     string          `json:"content"` // Content used by non-WeChat channels
+}
+
+// RenderMessage previews the fields a recipient would receive, in display order, as truncated
+// for the template message, and the untruncated title and content other channels get.
+// Without a recipient, recipient placeholders stay unresolved.
+func RenderMessage(template *models.MessageTemplate, keywords map[string]string, recipient *models.Recipient) RenderedMessage {
+	substituted := keywords
+	if recipient != nil {
+		substituted = SubstituteRecipientFields(keywords, *recipient)
+	}
+
+	fields := make([]RenderedField, 0, len(keywords))
+	for _, key := range FieldOrder(keywords) {
+		value := TruncateRunes(substituted[key], MaxKeywordLength)
+		field := RenderedField{Key: key, Value: value, Truncated: value != substituted[key]}
+		for _, m := range placeholderPattern.FindAllStringSubmatch(value, -1) {
+			field.Unresolved = append(field.Unresolved, m[1])
+		}
+		fields = append(fields, field)
+	}
+
+	n := BuildNotification(template, substituted)
+	return RenderedMessage{
+		TemplateKey: template.Key,
+		TemplateID:  template.TemplateID,
+		Fields:      fields,
+		Title:       n.Title,
+		Content:     n.Content,
+	}
+}
+
+// RenderKeywords replaces {{field}} placeholders in keyword values using vars,
+// leaving unknown placeholders for later substitution stages
+func RenderKeywords(keywords map[string]string, vars map[string]string) map[string]string {
+	return renderKeywords(keywords, func(field string) (string, bool) {
+		v, ok := vars[field]
+		return v, ok
+	})
+}
+
+func renderKeywords(keywords map[string]string, lookup func(field string) (string, bool)) map[string]string {
+	result := make(map[string]string, len(keywords))
+	for key, value := range keywords {
+		result[key] = placeholderPattern.ReplaceAllStringFunc(value, func(match string) string {
+			if v, ok := lookup(placeholderPattern.FindStringSubmatch(match)[1]); ok {
+				return v
+			}
+			return match
+		})
+	}
+	return result
+}
+
+// TruncateRunes shortens s to at most max characters, marking the cut with an ellipsis
+func TruncateRunes(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-1]) + "…"
+}
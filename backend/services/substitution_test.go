@@ -0,0 +1,91 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"wechat-notification/models"
+)
+
+func TestSubstituteRecipientFields(t *testing.T) {
+	recipient := models.Recipient{
+		OpenID:   "o123",
+		Name:     "张三",
+		Metadata: map[string]string{"department": "运维部"},
+	}
+	keywords := map[string]string{
+		"first":    "{{name}} 您好",
+		"keyword1": "部门：{{ metadata.department }}",
+		"keyword2": "{{metadata.phone}} / {{unknown}}",
+		"remark":   "openid={{openId}}",
+	}
+
+	got := SubstituteRecipientFields(keywords, recipient)
+	want := map[string]string{
+		"first":    "张三 您好",
+		"keyword1": "部门：运维部",
+		"keyword2": "{{metadata.phone}} / {{unknown}}",
+		"remark":   "openid=o123",
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %q, want %q", key, got[key], value)
+		}
+	}
+}
+
+func TestNormalizeMetadataRejectsInvalidKeys(t *testing.T) {
+	if _, err := NormalizeMetadata(map[string]string{"bad key": "x"}); err != ErrInvalidMetadataKey {
+		t.Errorf("expected ErrInvalidMetadataKey, got %v", err)
+	}
+	got, err := NormalizeMetadata(map[string]string{" locale ": " zh_CN "})
+	if err != nil || got["locale"] != "zh_CN" {
+		t.Errorf("unexpected result %v, %v", got, err)
+	}
+}
+
+func TestRenderMessage(t *testing.T) {
+	template := &models.MessageTemplate{Key: "deploy", TemplateID: "tpl", Name: "部署通知"}
+	keywords := map[string]string{
+		"remark":   "{{nmae}}",
+		"keyword1": strings.Repeat("长", MaxKeywordLength+10),
+		"first":    "{{name}} 您好",
+	}
+
+	preview := RenderMessage(template, keywords, &models.Recipient{Name: "张三"})
+	if len(preview.Fields) != 3 || preview.Fields[0].Key != "first" || preview.Fields[2].Key != "remark" {
+		t.Fatalf("fields not in display order: %+v", preview.Fields)
+	}
+	if preview.Fields[0].Value != "张三 您好" || preview.Title != "张三 您好" {
+		t.Errorf("recipient placeholder not substituted: %+v", preview.Fields[0])
+	}
+	if !preview.Fields[1].Truncated || len([]rune(preview.Fields[1].Value)) != MaxKeywordLength {
+		t.Errorf("long value not truncated: %d runes", len([]rune(preview.Fields[1].Value)))
+	}
+	if !strings.Contains(preview.Content, keywords["keyword1"]) {
+		t.Error("other channels should get the long value in full")
+	}
+	if len(preview.Fields[2].Unresolved) != 1 || preview.Fields[2].Unresolved[0] != "nmae" {
+		t.Errorf("unresolved placeholders = %v, want [nmae]", preview.Fields[2].Unresolved)
+	}
+
+	anonymous := RenderMessage(template, keywords, nil)
+	if anonymous.Fields[0].Unresolved[0] != "name" {
+		t.Errorf("without a recipient {{name}} should be unresolved: %+v", anonymous.Fields[0])
+	}
+}
+
+func TestFormatMessageTruncatesTemplateFieldsOnly(t *testing.T) {
+	wechatSvc := NewWeChatService(NewTokenManager("app", "secret"), "tpl")
+	long := strings.Repeat("长", MaxKeywordLength+10)
+	keywords := map[string]string{"first": long}
+
+	msg := formatMessage(wechatSvc, models.Recipient{OpenID: "o1"}, "tpl", "", keywords, "", nil, "")
+	if got := len([]rune(msg.Data["first"].(map[string]string)["value"])); got != MaxKeywordLength {
+		t.Errorf("template field is %d runes, want %d", got, MaxKeywordLength)
+	}
+	text := formatMessage(wechatSvc, models.Recipient{OpenID: "o1"}, "", models.SendModeText, keywords, "", nil, "")
+	if !strings.Contains(text.Content, long) {
+		t.Error("text message lost the end of the long value")
+	}
+}
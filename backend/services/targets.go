@@ -0,0 +1,126 @@
+package services
+
+import (
+	"errors"
+	"strings"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+// ErrNoTargetedRecipients is returned when names, groups or tags were given but select nobody,
+// which must not fall back to a send to everyone
+var ErrNoTargetedRecipients = errors.New("no recipients match the given names, groups or tags")
+
+// RecipientTargets selects the recipients of a send for callers that don't know recipient IDs.
+// Tags match recipient metadata: "department=ops" selects recipients whose department is ops,
+// a bare "oncall" those with a non-empty oncall field.
+type RecipientTargets struct {
+	IDs    []int64
+	Names  []string // Recipient names
+	Groups []string // Group names
+	Tags   []string
+}
+
+// UnknownTargetsError lists the names and groups no recipient or group has
+type UnknownTargetsError struct {
+	Names  []string `json:"recipientNames,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+func (e *UnknownTargetsError) Error() string {
+	var parts []string
+	if len(e.Names) > 0 {
+		parts = append(parts, "unknown recipients "+strings.Join(e.Names, ", "))
+	}
+	if len(e.Groups) > 0 {
+		parts = append(parts, "unknown groups "+strings.Join(e.Groups, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// ResolveRecipientTargets returns the IDs of every recipient the targets select, IDs first. With
+// only IDs, or nothing, they're returned as given so the send keeps its defaults.
+func ResolveRecipientTargets(repo *repository.SQLiteRepository, t RecipientTargets) ([]int64, error) {
+	if len(t.Names) == 0 && len(t.Groups) == 0 && len(t.Tags) == 0 {
+		return t.IDs, nil
+	}
+
+	ids := make([]int64, 0, len(t.IDs))
+	seen := map[int64]bool{}
+	add := func(id int64) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range t.IDs {
+		add(id)
+	}
+
+	unknown := &UnknownTargetsError{}
+	if len(t.Names) > 0 || len(t.Tags) > 0 {
+		recipients, err := repo.GetAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range t.Names {
+			found := false
+			for _, r := range recipients {
+				if r.Name == name {
+					add(r.ID)
+					found = true
+				}
+			}
+			if !found {
+				unknown.Names = append(unknown.Names, name)
+			}
+		}
+		for _, r := range recipients {
+			for _, tag := range t.Tags {
+				if matchesTag(r, tag) {
+					add(r.ID)
+					break
+				}
+			}
+		}
+	}
+	if len(t.Groups) > 0 {
+		groups, err := repo.GetAllGroups()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range t.Groups {
+			found := false
+			for _, g := range groups {
+				if g.Name == name {
+					found = true
+					for _, id := range g.RecipientIDs {
+						add(id)
+					}
+				}
+			}
+			if !found {
+				unknown.Groups = append(unknown.Groups, name)
+			}
+		}
+	}
+
+	if len(unknown.Names) > 0 || len(unknown.Groups) > 0 {
+		return nil, unknown
+	}
+	if len(ids) == 0 {
+		return nil, ErrNoTargetedRecipients
+	}
+	return ids, nil
+}
+
+// matchesTag reports whether a recipient's metadata has the key=value of tag, or a non-empty key
+func matchesTag(r models.Recipient, tag string) bool {
+	key, value, hasValue := strings.Cut(tag, "=")
+	v := r.Metadata[strings.TrimSpace(key)]
+	if hasValue {
+		return v == strings.TrimSpace(value)
+	}
+	return v != ""
+}
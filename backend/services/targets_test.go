@@ -0,0 +1,53 @@
+package services
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+func TestResolveRecipientTargets(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	alice := &models.Recipient{OpenID: "o-alice", Name: "alice", Metadata: map[string]string{"department": "ops", "oncall": "yes"}}
+	bob := &models.Recipient{OpenID: "o-bob", Name: "bob", Metadata: map[string]string{"department": "dev"}}
+	carol := &models.Recipient{OpenID: "o-carol", Name: "carol"}
+	for _, r := range []*models.Recipient{alice, bob, carol} {
+		if err := repo.Create(r); err != nil {
+			t.Fatalf("Create error: %v", err)
+		}
+	}
+	if err := repo.CreateGroup(&models.Group{Name: "backend", RecipientIDs: []int64{bob.ID, carol.ID}}); err != nil {
+		t.Fatalf("CreateGroup error: %v", err)
+	}
+
+	// IDs alone, or nothing, are left for the send's defaults
+	if ids, err := ResolveRecipientTargets(repo, RecipientTargets{}); err != nil || ids != nil {
+		t.Errorf("empty targets: %v, %v", ids, err)
+	}
+
+	ids, err := ResolveRecipientTargets(repo, RecipientTargets{IDs: []int64{carol.ID}, Names: []string{"bob"}, Tags: []string{"department=ops"}})
+	if err != nil || len(ids) != 3 || ids[0] != carol.ID {
+		t.Errorf("names and tags: %v, %v", ids, err)
+	}
+	ids, err = ResolveRecipientTargets(repo, RecipientTargets{Groups: []string{"backend"}, Tags: []string{"oncall"}})
+	if err != nil || len(ids) != 3 {
+		t.Errorf("groups and a bare tag: %v, %v", ids, err)
+	}
+
+	var unknown *UnknownTargetsError
+	if _, err := ResolveRecipientTargets(repo, RecipientTargets{Names: []string{"dave"}, Groups: []string{"frontend", "backend"}}); !errors.As(err, &unknown) ||
+		len(unknown.Names) != 1 || len(unknown.Groups) != 1 || unknown.Groups[0] != "frontend" {
+		t.Errorf("expected the unknown name and group, got %v", err)
+	}
+	if _, err := ResolveRecipientTargets(repo, RecipientTargets{Tags: []string{"department=sales"}}); !errors.Is(err, ErrNoTargetedRecipients) {
+		t.Errorf("a tag matching nobody must not fall back to everyone, got %v", err)
+	}
+}
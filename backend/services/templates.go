@@ -0,0 +1,253 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"wechat-notification/logger"
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+// templateFieldPattern matches the {{name.DATA}} placeholders in a template's content
+var templateFieldPattern = regexp.MustCompile(`\{\{\s*(\w+)\.DATA\s*\}\}`)
+
+// TemplateFields returns the field names used in a template's content, in order of appearance
+func TemplateFields(content string) []string {
+	fields := []string{}
+	seen := map[string]bool{}
+	for _, m := range templateFieldPattern.FindAllStringSubmatch(content, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			fields = append(fields, m[1])
+		}
+	}
+	return fields
+}
+
+// GetPrivateTemplates lists the templates added to the account in the MP console
+func (s *WeChatService) GetPrivateTemplates() ([]models.WeChatPrivateTemplate, error) {
+	token, err := s.tokenManager.GetAccessToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	resp, err := s.httpClient.Get(fmt.Sprintf("%s?access_token=%s", WeChatPrivateTemplatesURL, token))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", withoutURL(err))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	var result struct {
+		models.WeChatAPIResponse
+		TemplateList []models.WeChatPrivateTemplate `json:"template_list"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if result.ErrCode != 0 {
+		return nil, &APIError{ErrCode: result.ErrCode, ErrMsg: result.ErrMsg}
+	}
+	return result.TemplateList, nil
+}
+
+// KeywordSchemaError reports keywords of a send that don't match its template's fields
+type KeywordSchemaError struct {
+	TemplateKey string   `json:"templateKey"`
+	Missing     []string `json:"missing"` // Template fields the send doesn't provide
+	Extra       []string `json:"extra"`   // Keywords the template has no field for
+}
+
+func (e *KeywordSchemaError) Error() string {
+	var parts []string
+	if len(e.Missing) > 0 {
+		parts = append(parts, "missing "+strings.Join(e.Missing, ", "))
+	}
+	if len(e.Extra) > 0 {
+		parts = append(parts, "unexpected "+strings.Join(e.Extra, ", "))
+	}
+	return fmt.Sprintf("keywords don't match template %s: %s", e.TemplateKey, strings.Join(parts, "; "))
+}
+
+// ValidateKeywords checks keywords against the template's fields, so a send with a misspelled or
+// forgotten keyword is rejected before reaching WeChat, which would show it blank. Templates
+// without fields accept any keywords.
+func ValidateKeywords(template *models.MessageTemplate, keywords map[string]string) error {
+	if len(template.Fields) == 0 {
+		return nil
+	}
+	schemaErr := &KeywordSchemaError{TemplateKey: template.Key, Missing: []string{}, Extra: []string{}}
+	known := make(map[string]bool, len(template.Fields))
+	for _, field := range template.Fields {
+		known[field] = true
+		if _, ok := keywords[field]; !ok {
+			schemaErr.Missing = append(schemaErr.Missing, field)
+		}
+	}
+	for key := range keywords {
+		if !known[key] {
+			schemaErr.Extra = append(schemaErr.Extra, key)
+		}
+	}
+	if len(schemaErr.Missing) == 0 && len(schemaErr.Extra) == 0 {
+		return nil
+	}
+	sort.Strings(schemaErr.Extra)
+	return schemaErr
+}
+
+// TemplateSyncResult summarizes a template metadata sync
+type TemplateSyncResult struct {
+	Synced  int      `json:"synced"`
+	Missing []string `json:"missing"` // Keys of local templates whose template ID no longer exists upstream
+}
+
+// SyncTemplateMetadata copies the title, industry and field list of each local template from the
+// official account it belongs to, flagging templates whose template ID was deleted in the MP console.
+// Subscribe message templates aren't in the account's template list and are left alone.
+func SyncTemplateMetadata(repo *repository.SQLiteRepository, apps *AppRegistry, now time.Time) (TemplateSyncResult, error) {
+	result := TemplateSyncResult{Missing: []string{}}
+	templates, err := repo.GetAllTemplates()
+	if err != nil {
+		return result, err
+	}
+
+	// Template IDs are scoped to an account, so each account's templates are listed once
+	upstreamByApp := map[int64]map[string]models.WeChatPrivateTemplate{}
+	for i := range templates {
+		t := &templates[i]
+		if t.Mode == models.TemplateModeSubscribe {
+			continue
+		}
+		byID, listed := upstreamByApp[t.AppID]
+		if !listed {
+			wechatSvc, err := apps.Service(t.AppID)
+			if err != nil {
+				return result, fmt.Errorf("template %s: %w", t.Key, err)
+			}
+			upstream, err := wechatSvc.GetPrivateTemplates()
+			if err != nil {
+				return result, err
+			}
+			byID = make(map[string]models.WeChatPrivateTemplate, len(upstream))
+			for _, u := range upstream {
+				byID[u.TemplateID] = u
+			}
+			upstreamByApp[t.AppID] = byID
+		}
+
+		remote, ok := byID[t.TemplateID]
+		t.UpstreamMissing = !ok
+		t.SyncedAt = &now
+		if ok {
+			applyUpstreamTemplate(t, remote)
+			result.Synced++
+		} else {
+			result.Missing = append(result.Missing, t.Key)
+			logger.Warnf("templates: template %s uses WeChat template ID %s, which no longer exists on the account", t.Key, t.TemplateID)
+		}
+		if err := repo.UpdateTemplateMetadata(t); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// applyUpstreamTemplate copies the title, industry and field list of a WeChat template
+func applyUpstreamTemplate(t *models.MessageTemplate, remote models.WeChatPrivateTemplate) {
+	t.Title = remote.Title
+	t.Industry = remote.PrimaryIndustry
+	if remote.DeputyIndustry != "" {
+		t.Industry += "|" + remote.DeputyIndustry
+	}
+	t.Fields = TemplateFields(remote.Content)
+}
+
+// TemplateImportResult summarizes a template import from an official account
+type TemplateImportResult struct {
+	Added   []string `json:"added"`   // Keys of templates created for template IDs new on the account
+	Updated []string `json:"updated"` // Keys of existing templates whose metadata was refreshed
+	Removed []string `json:"removed"` // Keys of local templates whose template ID is gone from the account
+}
+
+// ImportTemplates creates a local template for every template on the official account that isn't
+// configured yet, keyed by its title, and refreshes the metadata of the existing ones. Templates
+// whose template ID was deleted upstream are flagged and reported but kept, since sources may
+// still refer to their keys.
+func ImportTemplates(repo *repository.SQLiteRepository, apps *AppRegistry, appID int64, now time.Time) (TemplateImportResult, error) {
+	result := TemplateImportResult{Added: []string{}, Updated: []string{}, Removed: []string{}}
+	wechatSvc, err := apps.Service(appID)
+	if err != nil {
+		return result, err
+	}
+	upstream, err := wechatSvc.GetPrivateTemplates()
+	if err != nil {
+		return result, err
+	}
+	templates, err := repo.GetAllTemplates()
+	if err != nil {
+		return result, err
+	}
+
+	keys := make(map[string]bool, len(templates))
+	local := map[string]bool{}
+	byID := make(map[string]models.WeChatPrivateTemplate, len(upstream))
+	for _, u := range upstream {
+		byID[u.TemplateID] = u
+	}
+	for i := range templates {
+		t := &templates[i]
+		keys[t.Key] = true
+		if t.AppID != appID || t.Mode == models.TemplateModeSubscribe {
+			continue
+		}
+		local[t.TemplateID] = true
+		remote, ok := byID[t.TemplateID]
+		t.UpstreamMissing = !ok
+		t.SyncedAt = &now
+		if ok {
+			applyUpstreamTemplate(t, remote)
+			result.Updated = append(result.Updated, t.Key)
+		} else {
+			result.Removed = append(result.Removed, t.Key)
+		}
+		if err := repo.UpdateTemplateMetadata(t); err != nil {
+			return result, err
+		}
+	}
+
+	for _, remote := range upstream {
+		if local[remote.TemplateID] {
+			continue
+		}
+		key := remote.Title
+		if key == "" || keys[key] {
+			key = remote.TemplateID
+		}
+		if keys[key] {
+			logger.Warnf("templates: not importing WeChat template %s, key %s is taken", remote.TemplateID, key)
+			continue
+		}
+		t := models.MessageTemplate{Key: key, TemplateID: remote.TemplateID, Name: remote.Title, AppID: appID, SyncedAt: &now}
+		applyUpstreamTemplate(&t, remote)
+		if err := repo.CreateTemplate(&t); err != nil {
+			return result, err
+		}
+		if err := repo.UpdateTemplateMetadata(&t); err != nil {
+			return result, err
+		}
+		keys[key] = true
+		local[remote.TemplateID] = true
+		result.Added = append(result.Added, key)
+	}
+	return result, nil
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+func TestTemplateFields(t *testing.T) {
+	content := "{{first.DATA}}\n订单号：{{keyword1.DATA}}\n金额：{{ keyword2.DATA }}\n{{keyword1.DATA}}\n{{remark.DATA}}"
+	if got := strings.Join(TemplateFields(content), ","); got != "first,keyword1,keyword2,remark" {
+		t.Errorf("TemplateFields = %s", got)
+	}
+	if got := TemplateFields("纯文本"); len(got) != 0 {
+		t.Errorf("TemplateFields without placeholders = %v", got)
+	}
+}
+
+func TestSyncTemplateMetadata(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	repo.CreateTemplate(&models.MessageTemplate{Key: "order", TemplateID: "tpl_order", Name: "订单通知"})
+	repo.CreateTemplate(&models.MessageTemplate{Key: "old", TemplateID: "tpl_deleted", Name: "旧模板"})
+
+	client := &MockHTTPClient{GetFunc: func(url string) (*http.Response, error) {
+		body := `{"template_list":[{"template_id":"tpl_order","title":"订单支付成功","primary_industry":"IT科技","deputy_industry":"互联网|电子商务","content":"{{first.DATA}}\n订单：{{keyword1.DATA}}\n{{remark.DATA}}"}]}`
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}}
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("token", time.Hour)
+
+	result, err := SyncTemplateMetadata(repo, NewAppRegistry(NewWeChatServiceWithClient(tokens, "", client)), time.Now())
+	if err != nil {
+		t.Fatalf("SyncTemplateMetadata error: %v", err)
+	}
+	if result.Synced != 1 || len(result.Missing) != 1 || result.Missing[0] != "old" {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	order, _ := repo.GetTemplateByKey("order")
+	if order.Title != "订单支付成功" || order.Industry != "IT科技|互联网|电子商务" || strings.Join(order.Fields, ",") != "first,keyword1,remark" ||
+		order.UpstreamMissing || order.SyncedAt == nil {
+		t.Errorf("metadata not stored: %+v", order)
+	}
+	if old, _ := repo.GetTemplateByKey("old"); !old.UpstreamMissing {
+		t.Errorf("deleted template should be flagged: %+v", old)
+	}
+}
+
+func TestImportTemplates(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	repo.CreateTemplate(&models.MessageTemplate{Key: "order", TemplateID: "tpl_order", Name: "订单通知"})
+	repo.CreateTemplate(&models.MessageTemplate{Key: "old", TemplateID: "tpl_deleted", Name: "旧模板"})
+	repo.CreateTemplate(&models.MessageTemplate{Key: "告警通知", TemplateID: "tpl_other_account", Name: "告警", AppID: 7})
+
+	client := &MockHTTPClient{GetFunc: func(url string) (*http.Response, error) {
+		body := `{"template_list":[` +
+			`{"template_id":"tpl_order","title":"订单支付成功","content":"{{first.DATA}}"},` +
+			`{"template_id":"tpl_new","title":"物流通知","content":"{{keyword1.DATA}}\n{{keyword2.DATA}}"},` +
+			`{"template_id":"tpl_alert","title":"告警通知","content":"{{keyword1.DATA}}"}]}`
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}}
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("token", time.Hour)
+
+	result, err := ImportTemplates(repo, NewAppRegistry(NewWeChatServiceWithClient(tokens, "", client)), 0, time.Now())
+	if err != nil {
+		t.Fatalf("ImportTemplates error: %v", err)
+	}
+	if strings.Join(result.Added, ",") != "物流通知,tpl_alert" || strings.Join(result.Updated, ",") != "order" ||
+		strings.Join(result.Removed, ",") != "old" {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	imported, err := repo.GetTemplateByKey("物流通知")
+	if err != nil {
+		t.Fatalf("imported template not found: %v", err)
+	}
+	if imported.TemplateID != "tpl_new" || imported.Title != "物流通知" || strings.Join(imported.Fields, ",") != "keyword1,keyword2" {
+		t.Errorf("unexpected imported template: %+v", imported)
+	}
+
+	// A second import finds nothing new
+	result, err = ImportTemplates(repo, NewAppRegistry(NewWeChatServiceWithClient(tokens, "", client)), 0, time.Now())
+	if err != nil || len(result.Added) != 0 || len(result.Updated) != 3 {
+		t.Errorf("second import: %+v, %v", result, err)
+	}
+}
+
+func TestGetPrivateTemplatesHidesToken(t *testing.T) {
+	client := &MockHTTPClient{GetFunc: func(target string) (*http.Response, error) {
+		return nil, &url.Error{Op: "Get", URL: target, Err: errors.New("connection refused")}
+	}}
+	tokens := NewTokenManager("app", "secret")
+	tokens.SetToken("tok_s3cret", time.Hour)
+
+	_, err := NewWeChatServiceWithClient(tokens, "", client).GetPrivateTemplates()
+	if err == nil || strings.Contains(err.Error(), "tok_s3cret") || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
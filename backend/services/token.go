@@ -1,10 +1,14 @@
 package services
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 )
@@ -12,6 +16,9 @@ import (
 const (
 	// WeChatTokenURL is the URL to get access token from WeChat API
 	WeChatTokenURL = "https://api.weixin.qq.com/cgi-bin/token"
+	// WeChatStableTokenURL is the URL to get a stable access token, which doesn't invalidate
+	// tokens other systems sharing the AppID already hold
+	WeChatStableTokenURL = "https://api.weixin.qq.com/cgi-bin/stable_token"
 	// TokenBufferTime is the buffer time before token expiration to trigger refresh
 	TokenBufferTime = 5 * time.Minute
 )
@@ -37,6 +44,27 @@ type TokenManager struct {
 	expiresAt   time.Time
 	mu          sync.RWMutex
 	httpClient  HTTPClient
+	stable      bool   // Use cgi-bin/stable_token instead of cgi-bin/token
+	providerURL string // External token center to fetch tokens from instead of WeChat; takes precedence over stable
+
+	lastRefreshAt time.Time // When a token was last fetched successfully
+	lastError     string    // Error of the last failed fetch, cleared by the next successful one
+	lastErrorAt   time.Time
+}
+
+// TokenStatus describes the cached access token without revealing it
+type TokenStatus struct {
+	Cached        bool       `json:"cached"`
+	ExpiresAt     *time.Time `json:"expiresAt,omitempty"`
+	Source        string     `json:"source"` // token, stable_token or provider
+	LastRefreshAt *time.Time `json:"lastRefreshAt,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+	LastErrorAt   *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+// tokenPoster is implemented by HTTP clients that can POST, which the stable token endpoint requires
+type tokenPoster interface {
+	Post(url, contentType string, body io.Reader) (*http.Response, error)
 }
 
 // NewTokenManager creates a new token manager
@@ -70,8 +98,43 @@ func (tm *TokenManager) GetAccessToken() (string, error) {
 	return tm.refreshToken()
 }
 
+// SetStableToken switches between cgi-bin/stable_token and cgi-bin/token. Stable tokens let this
+// service share an AppID with other systems without each refresh invalidating the others' tokens.
+func (tm *TokenManager) SetStableToken(enabled bool) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.stable = enabled
+	tm.accessToken = ""
+	tm.expiresAt = time.Time{}
+}
+
+// SetTokenProvider makes the manager fetch access tokens from a central token service instead of
+// calling WeChat with the AppSecret; an empty URL switches back to WeChat. The service is called with
+// GET <url>?appid=<appID> and must answer like cgi-bin/token: {"access_token": "...", "expires_in": 7200}.
+func (tm *TokenManager) SetTokenProvider(providerURL string) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.providerURL = providerURL
+	tm.accessToken = ""
+	tm.expiresAt = time.Time{}
+}
+
+// UsesTokenProvider reports whether tokens come from a central token service, which needs no AppSecret
+func (tm *TokenManager) UsesTokenProvider() bool {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	return tm.providerURL != ""
+}
+
 // refreshToken fetches a new access token from WeChat API
 func (tm *TokenManager) refreshToken() (string, error) {
+	return tm.fetchToken(false)
+}
+
+// fetchToken fetches a new access token from WeChat API, or the token provider, unless another caller
+// refreshed it meanwhile. force asks the stable token endpoint for a new token even if the current one
+// is still valid; the token provider decides for itself when to refresh.
+func (tm *TokenManager) fetchToken(force bool) (token string, err error) {
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
 
@@ -79,14 +142,28 @@ func (tm *TokenManager) refreshToken() (string, error) {
 	if tm.accessToken != "" && time.Now().Add(TokenBufferTime).Before(tm.expiresAt) {
 		return tm.accessToken, nil
 	}
+	defer func() {
+		if err != nil {
+			tm.lastError, tm.lastErrorAt = err.Error(), time.Now()
+		} else {
+			tm.lastError, tm.lastRefreshAt = "", time.Now()
+		}
+	}()
 
-	// Build the request URL
-	url := fmt.Sprintf("%s?grant_type=client_credential&appid=%s&secret=%s",
-		WeChatTokenURL, tm.appID, tm.appSecret)
-
-	resp, err := tm.httpClient.Get(url)
+	var resp *http.Response
+	switch {
+	case tm.providerURL != "":
+		resp, err = tm.httpClient.Get(tm.providerRequestURL())
+	case tm.stable:
+		resp, err = tm.requestStableToken(force)
+	default:
+		// Build the request URL
+		url := fmt.Sprintf("%s?grant_type=client_credential&appid=%s&secret=%s",
+			WeChatTokenURL, tm.appID, tm.appSecret)
+		resp, err = tm.httpClient.Get(url)
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to request access token: %w", err)
+		return "", fmt.Errorf("failed to request access token: %w", withoutURL(err))
 	}
 	defer resp.Body.Close()
 
@@ -101,7 +178,7 @@ func (tm *TokenManager) refreshToken() (string, error) {
 	}
 
 	if tokenResp.ErrCode != 0 {
-		return "", fmt.Errorf("WeChat API error: code=%d, msg=%s", tokenResp.ErrCode, tokenResp.ErrMsg)
+		return "", &APIError{ErrCode: tokenResp.ErrCode, ErrMsg: tokenResp.ErrMsg}
 	}
 
 	if tokenResp.AccessToken == "" {
@@ -115,6 +192,70 @@ func (tm *TokenManager) refreshToken() (string, error) {
 	return tm.accessToken, nil
 }
 
+// withoutURL drops the request URL from the error of an HTTP call, so the AppSecret, access tokens
+// and channel keys the URL carries don't end up in errors shown to users or stored in the history
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
+
+// providerRequestURL adds the AppID to the token provider URL so one provider can serve several accounts
+func (tm *TokenManager) providerRequestURL() string {
+	sep := "?"
+	if strings.Contains(tm.providerURL, "?") {
+		sep = "&"
+	}
+	return tm.providerURL + sep + "appid=" + url.QueryEscape(tm.appID)
+}
+
+// requestStableToken posts the credentials to the stable token endpoint
+func (tm *TokenManager) requestStableToken(force bool) (*http.Response, error) {
+	poster, ok := tm.httpClient.(tokenPoster)
+	if !ok {
+		return nil, errors.New("HTTP client can't POST to the stable token endpoint")
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"grant_type":    "client_credential",
+		"appid":         tm.appID,
+		"secret":        tm.appSecret,
+		"force_refresh": force,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return poster.Post(WeChatStableTokenURL, "application/json", bytes.NewReader(body))
+}
+
+// Status reports whether a token is cached, when it expires and how the last refresh went
+func (tm *TokenManager) Status() TokenStatus {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	status := TokenStatus{Cached: tm.accessToken != "", Source: "token", LastError: tm.lastError}
+	switch {
+	case tm.providerURL != "":
+		status.Source = "provider"
+	case tm.stable:
+		status.Source = "stable_token"
+	}
+	if status.Cached {
+		expiresAt := tm.expiresAt
+		status.ExpiresAt = &expiresAt
+	}
+	if !tm.lastRefreshAt.IsZero() {
+		refreshedAt := tm.lastRefreshAt
+		status.LastRefreshAt = &refreshedAt
+	}
+	if tm.lastError != "" {
+		errorAt := tm.lastErrorAt
+		status.LastErrorAt = &errorAt
+	}
+	return status
+}
+
 // IsExpired checks if the current token is expired or will expire soon
 func (tm *TokenManager) IsExpired() bool {
 	tm.mu.RLock()
@@ -122,13 +263,14 @@ func (tm *TokenManager) IsExpired() bool {
 	return tm.accessToken == "" || time.Now().Add(TokenBufferTime).After(tm.expiresAt)
 }
 
-// ForceRefresh forces a token refresh regardless of expiration status
+// ForceRefresh forces a token refresh regardless of expiration status.
+// With stable tokens this sets force_refresh, which WeChat limits to a few calls a day.
 func (tm *TokenManager) ForceRefresh() (string, error) {
 	tm.mu.Lock()
 	tm.accessToken = ""
 	tm.expiresAt = time.Time{}
 	tm.mu.Unlock()
-	return tm.refreshToken()
+	return tm.fetchToken(true)
 }
 
 // SetToken sets the token directly (useful for testing)
@@ -154,4 +296,25 @@ func (tm *TokenManager) UpdateCredentials(appID, appSecret string) {
 	tm.appSecret = appSecret
 	tm.accessToken = ""
 	tm.expiresAt = time.Time{}
+	tm.lastError = ""
+}
+
+// clone returns a manager for other credentials that fetches tokens the same way, with its own cache
+func (tm *TokenManager) clone(appID, appSecret string) *TokenManager {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	return &TokenManager{
+		appID:       appID,
+		appSecret:   appSecret,
+		httpClient:  tm.httpClient,
+		stable:      tm.stable,
+		providerURL: tm.providerURL,
+	}
+}
+
+// GetCredentials returns the current app credentials
+func (tm *TokenManager) GetCredentials() (appID, appSecret string) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	return tm.appID, tm.appSecret
 }
@@ -55,8 +55,8 @@ func TestProperty4_EmptyRecipientsValidation(t *testing.T) {
 	properties.Property("Message with empty recipients should be rejected", prop.ForAll(
 		func(title, content string) bool {
 			req := &models.SendMessageRequest{
-				Title:        title,
-				Content:      content,
+				TemplateKey:  title,
+				Keywords:     map[string]string{"keyword1": content},
 				RecipientIDs: []int64{}, // Empty recipients list
 			}
 
@@ -99,8 +99,8 @@ func TestProperty5_WhitespaceMessageValidation(t *testing.T) {
 	properties.Property("Message with whitespace-only title should be rejected", prop.ForAll(
 		func(whitespaceTitle, validContent string, recipientIDs []int64) bool {
 			req := &models.SendMessageRequest{
-				Title:        whitespaceTitle,
-				Content:      validContent,
+				TemplateKey:  whitespaceTitle,
+				Keywords:     map[string]string{"keyword1": validContent},
 				RecipientIDs: recipientIDs,
 			}
 
@@ -111,10 +111,10 @@ func TestProperty5_WhitespaceMessageValidation(t *testing.T) {
 				return false
 			}
 
-			// Should contain ErrEmptyTitle
+			// Should contain ErrEmptyTemplateKey
 			hasEmptyTitleError := false
 			for _, err := range result.Errors {
-				if err == ErrEmptyTitle {
+				if err == ErrEmptyTemplateKey {
 					hasEmptyTitleError = true
 					break
 				}
@@ -127,12 +127,12 @@ func TestProperty5_WhitespaceMessageValidation(t *testing.T) {
 		genNonEmptyRecipientIDs(),
 	))
 
-	// Test whitespace-only content
-	properties.Property("Message with whitespace-only content should be rejected", prop.ForAll(
-		func(validTitle, whitespaceContent string, recipientIDs []int64) bool {
+	// Test empty keywords
+	properties.Property("Message without keywords should be rejected", prop.ForAll(
+		func(validTitle string, recipientIDs []int64) bool {
 			req := &models.SendMessageRequest{
-				Title:        validTitle,
-				Content:      whitespaceContent,
+				TemplateKey:  validTitle,
+				Keywords:     map[string]string{},
 				RecipientIDs: recipientIDs,
 			}
 
@@ -143,10 +143,10 @@ func TestProperty5_WhitespaceMessageValidation(t *testing.T) {
 				return false
 			}
 
-			// Should contain ErrEmptyContent
+			// Should contain ErrEmptyKeywords
 			hasEmptyContentError := false
 			for _, err := range result.Errors {
-				if err == ErrEmptyContent {
+				if err == ErrEmptyKeywords {
 					hasEmptyContentError = true
 					break
 				}
@@ -155,7 +155,6 @@ func TestProperty5_WhitespaceMessageValidation(t *testing.T) {
 			return hasEmptyContentError
 		},
 		genNonEmptyString(),
-		genWhitespaceString(),
 		genNonEmptyRecipientIDs(),
 	))
 
@@ -163,29 +162,29 @@ func TestProperty5_WhitespaceMessageValidation(t *testing.T) {
 	properties.Property("Message with empty title should be rejected", prop.ForAll(
 		func(validContent string, recipientIDs []int64) bool {
 			req := &models.SendMessageRequest{
-				Title:        "",
-				Content:      validContent,
+				TemplateKey:  "",
+				Keywords:     map[string]string{"keyword1": validContent},
 				RecipientIDs: recipientIDs,
 			}
 
 			result := ValidateMessage(req)
-			return !result.Valid && containsError(result.Errors, ErrEmptyTitle)
+			return !result.Valid && containsError(result.Errors, ErrEmptyTemplateKey)
 		},
 		genNonEmptyString(),
 		genNonEmptyRecipientIDs(),
 	))
 
-	// Test empty string content
-	properties.Property("Message with empty content should be rejected", prop.ForAll(
+	// Test nil keywords
+	properties.Property("Message with nil keywords should be rejected", prop.ForAll(
 		func(validTitle string, recipientIDs []int64) bool {
 			req := &models.SendMessageRequest{
-				Title:        validTitle,
-				Content:      "",
+				TemplateKey:  validTitle,
+				Keywords:     nil,
 				RecipientIDs: recipientIDs,
 			}
 
 			result := ValidateMessage(req)
-			return !result.Valid && containsError(result.Errors, ErrEmptyContent)
+			return !result.Valid && containsError(result.Errors, ErrEmptyKeywords)
 		},
 		genNonEmptyString(),
 		genNonEmptyRecipientIDs(),
@@ -214,8 +213,8 @@ func TestValidMessagePasses(t *testing.T) {
 	properties.Property("Valid message should pass validation", prop.ForAll(
 		func(title, content string, recipientIDs []int64) bool {
 			req := &models.SendMessageRequest{
-				Title:        title,
-				Content:      content,
+				TemplateKey:  title,
+				Keywords:     map[string]string{"keyword1": content},
 				RecipientIDs: recipientIDs,
 			}
 
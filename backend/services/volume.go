@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"wechat-notification/logger"
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+const (
+	// VolumeAlertConfigKey stores the send volume alert configuration
+	VolumeAlertConfigKey = "volume_alert"
+	// VolumeCheckTick is how often the monitor compares token volumes with their baselines
+	VolumeCheckTick = time.Minute
+
+	defaultVolumeWindow    = 10
+	defaultVolumeBaseline  = 12
+	defaultVolumeFactor    = 5
+	defaultVolumeMinimum   = 20
+	defaultVolumeCooldown  = 60
+	maxVolumeBaselineCount = 288
+)
+
+// VolumeMonitor watches the send volume of each inbound token and alerts the configured groups when
+// a token's latest window jumps well above the average of the windows before it
+type VolumeMonitor struct {
+	repo   *repository.SQLiteRepository
+	sender *Sender
+
+	mu      sync.Mutex
+	alerted map[string]time.Time // token name → last alert
+}
+
+// NewVolumeMonitor creates a new send volume monitor
+func NewVolumeMonitor(repo *repository.SQLiteRepository, sender *Sender) *VolumeMonitor {
+	return &VolumeMonitor{repo: repo, sender: sender, alerted: map[string]time.Time{}}
+}
+
+// ResolveVolumeAlertConfig fills in defaults for the thresholds the config leaves unset
+func ResolveVolumeAlertConfig(cfg models.VolumeAlertConfig) models.VolumeAlertConfig {
+	if cfg.WindowMinutes <= 0 {
+		cfg.WindowMinutes = defaultVolumeWindow
+	}
+	if cfg.BaselineWindows <= 0 {
+		cfg.BaselineWindows = defaultVolumeBaseline
+	}
+	cfg.BaselineWindows = min(cfg.BaselineWindows, maxVolumeBaselineCount)
+	if cfg.Factor <= 0 {
+		cfg.Factor = defaultVolumeFactor
+	}
+	if cfg.MinMessages <= 0 {
+		cfg.MinMessages = defaultVolumeMinimum
+	}
+	if cfg.CooldownMinutes <= 0 {
+		cfg.CooldownMinutes = defaultVolumeCooldown
+	}
+	return cfg
+}
+
+// Run checks token volumes until ctx is cancelled
+func (v *VolumeMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(VolumeCheckTick)
+	defer ticker.Stop()
+
+	for {
+		v.check(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Measure returns each token's message count in the window ending at now along with its baseline,
+// busiest first. A token is anomalous when its count reaches both MinMessages and Factor times the
+// baseline, so a token that was silent until now is flagged once it sends MinMessages.
+func (v *VolumeMonitor) Measure(cfg models.VolumeAlertConfig, now time.Time) ([]models.TokenVolume, error) {
+	cfg = ResolveVolumeAlertConfig(cfg)
+	window := time.Duration(cfg.WindowMinutes) * time.Minute
+
+	current, err := v.repo.CountMessagesByToken(now.Add(-window), now)
+	if err != nil {
+		return nil, err
+	}
+	start := now.Add(-window * time.Duration(cfg.BaselineWindows+1))
+	past, err := v.repo.CountMessagesByToken(start, now.Add(-window))
+	if err != nil {
+		return nil, err
+	}
+
+	volumes := []models.TokenVolume{}
+	for name, count := range current {
+		baseline := float64(past[name]) / float64(cfg.BaselineWindows)
+		volumes = append(volumes, models.TokenVolume{
+			TokenName: name,
+			Count:     count,
+			Baseline:  baseline,
+			Anomalous: count >= cfg.MinMessages && float64(count) >= cfg.Factor*baseline,
+		})
+	}
+	sort.Slice(volumes, func(i, j int) bool {
+		if volumes[i].Count != volumes[j].Count {
+			return volumes[i].Count > volumes[j].Count
+		}
+		return volumes[i].TokenName < volumes[j].TokenName
+	})
+	return volumes, nil
+}
+
+func (v *VolumeMonitor) check(now time.Time) {
+	var cfg models.VolumeAlertConfig
+	if err := v.repo.GetJSONConfig(VolumeAlertConfigKey, &cfg); err != nil {
+		logger.Warnf("volume monitor: failed to load config: %v", err)
+		return
+	}
+	if !cfg.Enabled {
+		return
+	}
+	cfg = ResolveVolumeAlertConfig(cfg)
+	volumes, err := v.Measure(cfg, now)
+	if err != nil {
+		logger.Warnf("volume monitor: failed to count messages: %v", err)
+		return
+	}
+	cooldown := time.Duration(cfg.CooldownMinutes) * time.Minute
+	for _, vol := range volumes {
+		if !vol.Anomalous {
+			continue
+		}
+		v.mu.Lock()
+		last, ok := v.alerted[vol.TokenName]
+		if ok && now.Sub(last) < cooldown {
+			v.mu.Unlock()
+			continue
+		}
+		v.alerted[vol.TokenName] = now
+		v.mu.Unlock()
+
+		logger.Warnf("volume monitor: %s sent %d messages in %d minutes, baseline %.1f", vol.TokenName, vol.Count, cfg.WindowMinutes, vol.Baseline)
+		if err := v.notify(cfg, vol); err != nil {
+			logger.Warnf("volume monitor: %s: %v", vol.TokenName, err)
+		}
+	}
+}
+
+func (v *VolumeMonitor) notify(cfg models.VolumeAlertConfig, vol models.TokenVolume) error {
+	if cfg.TemplateKey == "" || len(cfg.GroupIDs) == 0 {
+		return nil
+	}
+	template, err := v.repo.GetTemplateByKey(cfg.TemplateKey)
+	if err != nil {
+		return fmt.Errorf("template %q: %w", cfg.TemplateKey, err)
+	}
+	keywords := RenderKeywords(cfg.Keywords, map[string]string{
+		"token":    vol.TokenName,
+		"count":    fmt.Sprint(vol.Count),
+		"baseline": fmt.Sprintf("%.1f", vol.Baseline),
+		"window":   fmt.Sprint(cfg.WindowMinutes),
+	})
+	_, err = v.sender.SendToGroups(context.Background(), template, cfg.GroupIDs, keywords, SendOptions{})
+	return err
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+func TestVolumeMonitor(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	for token, n := range map[string]int{"webhook_token": 25, "compat_bark": 3, "": 40} {
+		for i := 0; i < n; i++ {
+			m := &models.Message{TemplateKey: "t", Keywords: map[string]string{}}
+			m.TokenName = token
+			if err := repo.CreateMessage(m); err != nil {
+				t.Fatalf("CreateMessage error: %v", err)
+			}
+		}
+	}
+
+	monitor := NewVolumeMonitor(repo, nil)
+	cfg := models.VolumeAlertConfig{Enabled: true}
+	now := time.Now().Add(time.Second)
+	volumes, err := monitor.Measure(cfg, now)
+	if err != nil {
+		t.Fatalf("Measure error: %v", err)
+	}
+	// Sends without a token aren't counted; the quiet token stays under minMessages
+	if len(volumes) != 2 || volumes[0].TokenName != "webhook_token" || !volumes[0].Anomalous ||
+		volumes[1].TokenName != "compat_bark" || volumes[1].Anomalous {
+		t.Fatalf("unexpected volumes: %+v", volumes)
+	}
+
+	// One window later the messages only count towards the baseline
+	if volumes, _ := monitor.Measure(cfg, now.Add(defaultVolumeWindow*time.Minute)); len(volumes) != 0 {
+		t.Errorf("expected no current volume, got %+v", volumes)
+	}
+
+	// Alerts for a token are spaced by the cooldown
+	if err := repo.SetJSONConfig(VolumeAlertConfigKey, models.VolumeAlertConfig{Enabled: true}); err != nil {
+		t.Fatalf("SetJSONConfig error: %v", err)
+	}
+	monitor.check(now)
+	first := monitor.alerted["webhook_token"]
+	if !first.Equal(now) {
+		t.Fatalf("expected an alert at %s, got %s", now, first)
+	}
+	if _, ok := monitor.alerted["compat_bark"]; ok {
+		t.Error("quiet token was alerted")
+	}
+	monitor.check(now.Add(time.Second))
+	if !monitor.alerted["webhook_token"].Equal(first) {
+		t.Error("alert repeated within the cooldown")
+	}
+}
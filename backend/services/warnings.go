@@ -0,0 +1,74 @@
+package services
+
+import (
+	"time"
+
+	"wechat-notification/logger"
+	"wechat-notification/repository"
+)
+
+// TokenExpiryWarning is how close to expiry the cached access token has to be to warn about it
+const TokenExpiryWarning = 10 * time.Minute
+
+// Soft configuration warnings. They don't fail a request but point at something to fix.
+const (
+	WarningDevMode            = "running in dev mode: authentication is disabled"
+	WarningNoCredentials      = "WeChat AppID or AppSecret is not configured"
+	WarningNoRecipients       = "no recipients configured"
+	WarningNoTemplates        = "no templates configured"
+	WarningTokenExpiresSoon   = "access token expires soon"
+	WarningTokenRefreshFailed = "last access token refresh failed: "
+	WarningMaintenance        = "maintenance mode is on: sends are held until it is switched off"
+)
+
+// ConfigWarnings collects the soft configuration warnings surfaced in API responses.
+// A nil *ConfigWarnings reports none.
+type ConfigWarnings struct {
+	repo        *repository.SQLiteRepository
+	tokens      *TokenManager
+	maintenance *Maintenance
+	devMode     bool
+}
+
+// NewConfigWarnings creates a warnings collector
+func NewConfigWarnings(repo *repository.SQLiteRepository, tokens *TokenManager, maintenance *Maintenance, devMode bool) *ConfigWarnings {
+	return &ConfigWarnings{repo: repo, tokens: tokens, maintenance: maintenance, devMode: devMode}
+}
+
+// Collect returns the current warnings, or nil when everything looks fine
+func (w *ConfigWarnings) Collect(now time.Time) []string {
+	if w == nil {
+		return nil
+	}
+	var warnings []string
+	if w.devMode {
+		warnings = append(warnings, WarningDevMode)
+	}
+	if w.maintenance.Enabled() {
+		warnings = append(warnings, WarningMaintenance)
+	}
+
+	appID, appSecret := w.tokens.GetCredentials()
+	if appID == "" || (appSecret == "" && !w.tokens.UsesTokenProvider()) {
+		warnings = append(warnings, WarningNoCredentials)
+	}
+	status := w.tokens.Status()
+	switch {
+	case status.LastError != "":
+		warnings = append(warnings, WarningTokenRefreshFailed+status.LastError)
+	case status.Cached && status.ExpiresAt.Sub(now) < TokenExpiryWarning:
+		warnings = append(warnings, WarningTokenExpiresSoon)
+	}
+
+	if count, err := w.repo.CountRecipients(); err != nil {
+		logger.Warnf("warnings: failed to count recipients: %v", err)
+	} else if count == 0 {
+		warnings = append(warnings, WarningNoRecipients)
+	}
+	if templates, err := w.repo.GetAllTemplates(); err != nil {
+		logger.Warnf("warnings: failed to load templates: %v", err)
+	} else if len(templates) == 0 {
+		warnings = append(warnings, WarningNoTemplates)
+	}
+	return warnings
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+func TestConfigWarnings(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	var nilWarnings *ConfigWarnings
+	if got := nilWarnings.Collect(time.Now()); got != nil {
+		t.Errorf("nil collector returned %v", got)
+	}
+
+	tokens := NewTokenManager("", "")
+	got := NewConfigWarnings(repo, tokens, nil, true).Collect(time.Now())
+	want := []string{WarningDevMode, WarningNoCredentials, WarningNoRecipients, WarningNoTemplates}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("warnings = %v, want %v", got, want)
+	}
+
+	tokens.UpdateCredentials("app", "secret")
+	tokens.SetToken("token", 5*time.Minute)
+	if err := repo.Create(&models.Recipient{OpenID: "o1", Name: "a"}); err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+	if err := repo.CreateTemplate(&models.MessageTemplate{Key: "k", TemplateID: "tpl", Name: "t"}); err != nil {
+		t.Fatalf("CreateTemplate error: %v", err)
+	}
+	got = NewConfigWarnings(repo, tokens, nil, false).Collect(time.Now())
+	if want := []string{WarningTokenExpiresSoon}; !reflect.DeepEqual(got, want) {
+		t.Errorf("warnings = %v, want %v", got, want)
+	}
+
+	tokens.SetToken("token", time.Hour)
+	if got := NewConfigWarnings(repo, tokens, nil, false).Collect(time.Now()); got != nil {
+		t.Errorf("expected no warnings, got %v", got)
+	}
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"wechat-notification/repository"
+)
+
+const (
+	// WebhookSigningSecretConfigKey stores the shared secret webhook callers sign request bodies with
+	WebhookSigningSecretConfigKey = "webhook_signing_secret"
+	// WebhookSignatureHeader carries the signature of a signed webhook request
+	WebhookSignatureHeader = "X-Signature"
+	// WebhookTimestampHeader carries the Unix time a signed webhook request was made
+	WebhookTimestampHeader = "X-Timestamp"
+	// WebhookNonceHeader carries a value unique to each signed webhook request
+	WebhookNonceHeader = "X-Nonce"
+)
+
+// WebhookSignature computes the X-Signature value of a request: "sha256=" and the hex
+// HMAC-SHA256, keyed with the signing secret, of the timestamp, nonce and body joined by dots
+func WebhookSignature(secret, timestamp, nonce string, body []byte) string {
+	return hmacSignature(secret, []byte(timestamp+"."+nonce+"."), body)
+}
+
+// VerifyWebhookSignature reports whether signature is the signature of a request, in constant time
+func VerifyWebhookSignature(secret, signature, timestamp, nonce string, body []byte) bool {
+	return signatureMatches(secret, signature, []byte(timestamp+"."+nonce+"."), body)
+}
+
+// hmacSignature returns "sha256=" and the hex HMAC-SHA256 of data, keyed with secret
+func hmacSignature(secret string, data ...[]byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// signatureMatches reports, in constant time, whether signature is the hmacSignature of data.
+// Nothing matches an empty secret.
+func signatureMatches(secret, signature string, data ...[]byte) bool {
+	if secret == "" || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	return hmac.Equal([]byte(hmacSignature(secret, data...)), []byte(strings.ToLower(signature)))
+}
+
+// GenerateWebhookSigningSecret replaces the signing secret; requests signed with the old one are
+// rejected from then on
+func GenerateWebhookSigningSecret(repo *repository.SQLiteRepository) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	secret := "whsec_" + hex.EncodeToString(b)
+	if err := repo.SetConfig(WebhookSigningSecretConfigKey, secret); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
@@ -0,0 +1,31 @@
+package services
+
+import "testing"
+
+func TestWebhookSignature(t *testing.T) {
+	body := []byte(`{"keywords":{"first":"hi"}}`)
+	const want = "sha256=b9047e7fa63e6f1213bd466787b6be5e20d30c455c2373ad85e725ff13db4dc3"
+	if got := WebhookSignature("whsec_test", "1700000000", "n-1", body); got != want {
+		t.Fatalf("WebhookSignature = %s", got)
+	}
+
+	cases := []struct {
+		secret, signature, timestamp, nonce string
+		body                                []byte
+		ok                                  bool
+	}{
+		{"whsec_test", want, "1700000000", "n-1", body, true},
+		{"whsec_test", "sha256=B9047E7FA63E6F1213BD466787B6BE5E20D30C455C2373AD85E725FF13DB4DC3", "1700000000", "n-1", body, true},
+		{"whsec_test", want, "1700000000", "n-1", []byte(`{"keywords":{"first":"ho"}}`), false},
+		{"whsec_test", want, "1700000001", "n-1", body, false},
+		{"whsec_test", want, "1700000000", "n-2", body, false},
+		{"whsec_other", want, "1700000000", "n-1", body, false},
+		{"whsec_test", want[len("sha256="):], "1700000000", "n-1", body, false},
+		{"", WebhookSignature("", "1700000000", "n-1", body), "1700000000", "n-1", body, false},
+	}
+	for i, tc := range cases {
+		if got := VerifyWebhookSignature(tc.secret, tc.signature, tc.timestamp, tc.nonce, tc.body); got != tc.ok {
+			t.Errorf("case %d: VerifyWebhookSignature = %v, want %v", i, got, tc.ok)
+		}
+	}
+}
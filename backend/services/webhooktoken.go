@@ -0,0 +1,319 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+const (
+	// WebhookTokenConfigKey stores the hash of the Bearer token of /api/webhook/send
+	WebhookTokenConfigKey = "webhook_token"
+	// PreviousWebhookTokenConfigKey stores the hash of the token replaced by the last rotation
+	// during its grace period
+	PreviousWebhookTokenConfigKey = "webhook_token_previous"
+	// WebhookRateLimitsConfigKey stores the rate limits of webhook credentials by token name
+	WebhookRateLimitsConfigKey = "webhook_rate_limits"
+	// WebhookDefaultRateLimitConfigKey stores the rate limit set through the admin API for webhook
+	// callers without a limit of their own, replacing WEBHOOK_RATE_LIMIT and WEBHOOK_RATE_BURST
+	WebhookDefaultRateLimitConfigKey = "webhook_rate_limit_default"
+	// DefaultTokenGrace is how long a rotated-out token keeps working by default
+	DefaultTokenGrace = 24 * time.Hour
+	// MaxTokenGrace is the longest a rotated-out token can keep working
+	MaxTokenGrace = 30 * 24 * time.Hour
+
+	// EmailGatewayConfigKey stores the email gateway settings, with the hash of its inbound token
+	EmailGatewayConfigKey = "email_gateway"
+
+	// ServerChanConfigKey stores the Server酱 endpoint settings, with the hash of its SendKey
+	ServerChanConfigKey = "serverchan"
+
+	// CompatConfigKeyPrefix prefixes the config key of each compat endpoint, e.g. compat_pushplus
+	CompatConfigKeyPrefix = "compat_"
+
+	// MinCustomEndpointTokenLength is the shortest token an admin can choose for a custom endpoint
+	MinCustomEndpointTokenLength = 16
+
+	// tokenHashPrefix marks a stored token as hashed, telling it apart from the plaintext tokens
+	// stored by earlier versions
+	tokenHashPrefix = "sha256:"
+)
+
+// ErrNoWebhookToken is returned when rotating before a webhook token was generated
+var ErrNoWebhookToken = errors.New("no webhook token to rotate")
+
+// GenerateWebhookToken replaces the webhook token right away, ending any grace period of an
+// earlier rotation so no old token keeps working. The token is returned only here; the database
+// keeps its hash.
+func GenerateWebhookToken(repo *repository.SQLiteRepository) (string, error) {
+	token, err := newWebhookToken()
+	if err != nil {
+		return "", err
+	}
+	if err := repo.SetConfig(WebhookTokenConfigKey, hashWebhookToken(token)); err != nil {
+		return "", err
+	}
+	if err := repo.SetJSONConfig(PreviousWebhookTokenConfigKey, models.RetiredToken{}); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RotateWebhookToken issues a new webhook token and keeps the current one valid for grace, so
+// integrations can move to the new token without failed sends
+func RotateWebhookToken(repo *repository.SQLiteRepository, grace time.Duration, now time.Time) (string, *models.RetiredToken, error) {
+	current, err := repo.GetConfig(WebhookTokenConfigKey)
+	if err != nil {
+		return "", nil, err
+	}
+	if current == "" {
+		return "", nil, ErrNoWebhookToken
+	}
+	token, err := newWebhookToken()
+	if err != nil {
+		return "", nil, err
+	}
+	previous := &models.RetiredToken{Hash: current, ExpiresAt: now.Add(grace)}
+	if err := repo.SetJSONConfig(PreviousWebhookTokenConfigKey, previous); err != nil {
+		return "", nil, err
+	}
+	if err := repo.SetConfig(WebhookTokenConfigKey, hashWebhookToken(token)); err != nil {
+		return "", nil, err
+	}
+	return token, previous, nil
+}
+
+// HasWebhookToken reports whether a webhook token was generated
+func HasWebhookToken(repo *repository.SQLiteRepository) bool {
+	current, _ := repo.GetConfig(WebhookTokenConfigKey)
+	return current != ""
+}
+
+// PreviousWebhookToken returns the rotated-out token while its grace period lasts, or nil
+func PreviousWebhookToken(repo *repository.SQLiteRepository, now time.Time) (*models.RetiredToken, error) {
+	var previous models.RetiredToken
+	if err := repo.GetJSONConfig(PreviousWebhookTokenConfigKey, &previous); err != nil {
+		return nil, err
+	}
+	if previous.Hash == "" || !now.Before(previous.ExpiresAt) {
+		return nil, nil
+	}
+	return &previous, nil
+}
+
+// CheckWebhookToken reports which webhook token a request presented: WebhookTokenConfigKey for
+// the current one, PreviousWebhookTokenConfigKey for the rotated-out one in its grace period, or
+// "" when neither matches
+func CheckWebhookToken(repo *repository.SQLiteRepository, token string, now time.Time) string {
+	if token == "" {
+		return ""
+	}
+	hash := []byte(hashWebhookToken(token))
+	if current, _ := repo.GetConfig(WebhookTokenConfigKey); current != "" && subtle.ConstantTimeCompare(hash, []byte(current)) == 1 {
+		return WebhookTokenConfigKey
+	}
+	if previous, _ := PreviousWebhookToken(repo, now); previous != nil && subtle.ConstantTimeCompare(hash, []byte(previous.Hash)) == 1 {
+		return PreviousWebhookTokenConfigKey
+	}
+	return ""
+}
+
+// WebhookRateLimits returns the rate limits set for webhook credentials by token name
+func WebhookRateLimits(repo *repository.SQLiteRepository) (map[string]models.RateLimit, error) {
+	limits := map[string]models.RateLimit{}
+	if err := repo.GetJSONConfig(WebhookRateLimitsConfigKey, &limits); err != nil {
+		return nil, err
+	}
+	return limits, nil
+}
+
+// WebhookRateLimit returns the rate limit of the webhook credential called name, or a zero limit
+// when the default applies. The rotated-out token shares the current token's limit.
+func WebhookRateLimit(repo *repository.SQLiteRepository, name string) (models.RateLimit, error) {
+	limits, err := WebhookRateLimits(repo)
+	if err != nil {
+		return models.RateLimit{}, err
+	}
+	if name == PreviousWebhookTokenConfigKey {
+		name = WebhookTokenConfigKey
+	}
+	return limits[name], nil
+}
+
+// SetWebhookRateLimit sets the rate limit of the webhook credential called name; a zero limit
+// goes back to the default. The limit outlives generating or rotating the credential.
+func SetWebhookRateLimit(repo *repository.SQLiteRepository, name string, limit models.RateLimit) error {
+	limits, err := WebhookRateLimits(repo)
+	if err != nil {
+		return err
+	}
+	if limit == (models.RateLimit{}) {
+		delete(limits, name)
+	} else {
+		limits[name] = limit
+	}
+	return repo.SetJSONConfig(WebhookRateLimitsConfigKey, limits)
+}
+
+// DefaultWebhookRateLimit returns the rate limit set through the admin API for webhook callers
+// without a limit of their own, or env when none was set
+func DefaultWebhookRateLimit(repo *repository.SQLiteRepository, env models.RateLimit) (models.RateLimit, error) {
+	var limit models.RateLimit
+	if err := repo.GetJSONConfig(WebhookDefaultRateLimitConfigKey, &limit); err != nil {
+		return env, err
+	}
+	if limit == (models.RateLimit{}) {
+		return env, nil
+	}
+	return limit, nil
+}
+
+// HashCustomEndpointToken returns the hash a custom endpoint's token is stored as
+func HashCustomEndpointToken(token string) string {
+	return hashWebhookToken(token)
+}
+
+// CheckCustomEndpointToken reports whether token is the custom endpoint's token
+func CheckCustomEndpointToken(endpoint *models.CustomEndpoint, token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(hashWebhookToken(token)), []byte(endpoint.Token)) == 1
+}
+
+// HashEmailGatewayToken returns the hash the email gateway's inbound token is stored as
+func HashEmailGatewayToken(token string) string {
+	return hashWebhookToken(token)
+}
+
+// CheckEmailGatewayToken reports whether token is the email gateway's inbound token
+func CheckEmailGatewayToken(cfg *models.EmailGatewayConfig, token string) bool {
+	return token != "" && cfg.Token != "" && subtle.ConstantTimeCompare([]byte(hashWebhookToken(token)), []byte(cfg.Token)) == 1
+}
+
+// HashServerChanKey returns the hash the Server酱 endpoint's SendKey is stored as
+func HashServerChanKey(key string) string {
+	return hashWebhookToken(key)
+}
+
+// CheckServerChanKey reports whether key is the Server酱 endpoint's SendKey
+func CheckServerChanKey(cfg *models.ServerChanConfig, key string) bool {
+	return key != "" && cfg.SendKey != "" && subtle.ConstantTimeCompare([]byte(hashWebhookToken(key)), []byte(cfg.SendKey)) == 1
+}
+
+// HashCompatToken returns the hash a compat endpoint's token is stored as. The GitHub endpoint
+// keeps its token in plaintext: it's the key deliveries are signed with.
+func HashCompatToken(service, token string) string {
+	if service == models.CompatGitHub {
+		return token
+	}
+	return hashWebhookToken(token)
+}
+
+// CheckCompatToken reports whether token is the token of an enabled compat endpoint
+func CheckCompatToken(service string, cfg *models.CompatEndpointConfig, token string) bool {
+	return cfg.Enabled && token != "" && cfg.Token != "" &&
+		subtle.ConstantTimeCompare([]byte(HashCompatToken(service, token)), []byte(cfg.Token)) == 1
+}
+
+// HashStoredWebhookTokens replaces plaintext webhook, custom endpoint, email gateway and compat
+// endpoint tokens and the Server酱 SendKey stored by earlier versions with their hashes. It runs at startup; the tokens
+// keep working, but can no longer be read back.
+func HashStoredWebhookTokens(repo *repository.SQLiteRepository) error {
+	current, err := repo.GetConfig(WebhookTokenConfigKey)
+	if err != nil {
+		return err
+	}
+	if current != "" && !strings.HasPrefix(current, tokenHashPrefix) {
+		if err := repo.SetConfig(WebhookTokenConfigKey, hashWebhookToken(current)); err != nil {
+			return err
+		}
+	}
+
+	endpoints, err := repo.GetAllCustomEndpoints()
+	if err != nil {
+		return err
+	}
+	for i := range endpoints {
+		if e := &endpoints[i]; !strings.HasPrefix(e.Token, tokenHashPrefix) {
+			e.Token = hashWebhookToken(e.Token)
+			if err := repo.UpdateCustomEndpoint(e); err != nil {
+				return err
+			}
+		}
+	}
+
+	var gateway models.EmailGatewayConfig
+	if err := repo.GetJSONConfig(EmailGatewayConfigKey, &gateway); err != nil {
+		return err
+	}
+	if gateway.Token != "" && !strings.HasPrefix(gateway.Token, tokenHashPrefix) {
+		gateway.Token = hashWebhookToken(gateway.Token)
+		if err := repo.SetJSONConfig(EmailGatewayConfigKey, gateway); err != nil {
+			return err
+		}
+	}
+
+	var serverChan models.ServerChanConfig
+	if err := repo.GetJSONConfig(ServerChanConfigKey, &serverChan); err != nil {
+		return err
+	}
+	if serverChan.SendKey != "" && !strings.HasPrefix(serverChan.SendKey, tokenHashPrefix) {
+		serverChan.SendKey = hashWebhookToken(serverChan.SendKey)
+		if err := repo.SetJSONConfig(ServerChanConfigKey, serverChan); err != nil {
+			return err
+		}
+	}
+
+	configs, err := repo.GetAllConfig()
+	if err != nil {
+		return err
+	}
+	for key := range configs {
+		service := strings.TrimPrefix(key, CompatConfigKeyPrefix)
+		if service == key || service == models.CompatGitHub {
+			continue
+		}
+		var cfg models.CompatEndpointConfig
+		if err := repo.GetJSONConfig(key, &cfg); err != nil {
+			return err
+		}
+		if cfg.Token != "" && !strings.HasPrefix(cfg.Token, tokenHashPrefix) {
+			cfg.Token = HashCompatToken(service, cfg.Token)
+			if err := repo.SetJSONConfig(key, cfg); err != nil {
+				return err
+			}
+		}
+	}
+
+	var previous struct {
+		models.RetiredToken
+		Token string `json:"token"`
+	}
+	if err := repo.GetJSONConfig(PreviousWebhookTokenConfigKey, &previous); err != nil {
+		return err
+	}
+	if previous.Token == "" {
+		return nil
+	}
+	return repo.SetJSONConfig(PreviousWebhookTokenConfigKey, models.RetiredToken{
+		Hash: hashWebhookToken(previous.Token), ExpiresAt: previous.ExpiresAt,
+	})
+}
+
+func hashWebhookToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return tokenHashPrefix + hex.EncodeToString(sum[:])
+}
+
+func newWebhookToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+	"wechat-notification/repository"
+)
+
+func TestRotateWebhookToken(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	now := time.Now()
+	if _, _, err := RotateWebhookToken(repo, time.Hour, now); err != ErrNoWebhookToken {
+		t.Fatalf("expected ErrNoWebhookToken, got %v", err)
+	}
+	old, err := GenerateWebhookToken(repo)
+	if err != nil {
+		t.Fatalf("GenerateWebhookToken error: %v", err)
+	}
+
+	token, previous, err := RotateWebhookToken(repo, time.Hour, now)
+	if err != nil {
+		t.Fatalf("RotateWebhookToken error: %v", err)
+	}
+	if token == old || previous.Hash != hashWebhookToken(old) || !previous.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("unexpected rotation: %s, %+v", token, previous)
+	}
+
+	// Both tokens work during the grace period, only the new one after it
+	if got := CheckWebhookToken(repo, token, now); got != WebhookTokenConfigKey {
+		t.Errorf("new token: got %q", got)
+	}
+	if got := CheckWebhookToken(repo, old, now.Add(59*time.Minute)); got != PreviousWebhookTokenConfigKey {
+		t.Errorf("old token in grace period: got %q", got)
+	}
+	if got := CheckWebhookToken(repo, old, now.Add(time.Hour)); got != "" {
+		t.Errorf("old token after grace period: got %q", got)
+	}
+	if got := CheckWebhookToken(repo, "", now); got != "" {
+		t.Errorf("empty token: got %q", got)
+	}
+
+	// Generating a token ends the grace period right away
+	if _, err := GenerateWebhookToken(repo); err != nil {
+		t.Fatalf("GenerateWebhookToken error: %v", err)
+	}
+	if got := CheckWebhookToken(repo, old, now); got != "" {
+		t.Errorf("old token after regenerating: got %q", got)
+	}
+	if got := CheckWebhookToken(repo, token, now); got != "" {
+		t.Errorf("replaced token after regenerating: got %q", got)
+	}
+}
+
+func TestHashStoredWebhookTokens(t *testing.T) {
+	repo, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository error: %v", err)
+	}
+	defer repo.Close()
+
+	// Tokens as stored before hashing
+	now := time.Now()
+	repo.SetConfig(WebhookTokenConfigKey, "current-token")
+	repo.SetConfig(PreviousWebhookTokenConfigKey, `{"token":"old-token","expiresAt":"`+now.Add(time.Hour).Format(time.RFC3339Nano)+`"}`)
+	repo.CreateTemplate(&models.MessageTemplate{Key: "alert", TemplateID: "tpl", Name: "告警"})
+	endpoint := &models.CustomEndpoint{Name: "ci", Slug: "ci", Token: "custom-endpoint-token", TemplateKey: "alert",
+		KeywordTemplates: map[string]string{"first": "{{.text}}"}, Enabled: true}
+	repo.CreateCustomEndpoint(endpoint)
+	repo.SetJSONConfig(EmailGatewayConfigKey, models.EmailGatewayConfig{Enabled: true, Token: "email-gateway-token", TemplateKey: "alert"})
+	repo.SetJSONConfig(ServerChanConfigKey, models.ServerChanConfig{Enabled: true, SendKey: "SCT-sendkey-value", TemplateKey: "alert"})
+	repo.SetJSONConfig(CompatConfigKeyPrefix+models.CompatWxPusher, models.CompatEndpointConfig{Enabled: true, Token: "AT_wxpusher-token", TemplateKey: "alert"})
+	repo.SetJSONConfig(CompatConfigKeyPrefix+models.CompatGitHub, models.CompatEndpointConfig{Enabled: true, Token: "github-secret", TemplateKey: "alert"})
+
+	if err := HashStoredWebhookTokens(repo); err != nil {
+		t.Fatalf("HashStoredWebhookTokens error: %v", err)
+	}
+	if err := HashStoredWebhookTokens(repo); err != nil {
+		t.Fatalf("second HashStoredWebhookTokens error: %v", err)
+	}
+	if stored, _ := repo.GetConfig(WebhookTokenConfigKey); stored != hashWebhookToken("current-token") {
+		t.Errorf("current token stored as %q", stored)
+	}
+	if stored, _ := repo.GetConfig(PreviousWebhookTokenConfigKey); strings.Contains(stored, "old-token") {
+		t.Errorf("previous token still stored in plaintext: %s", stored)
+	}
+	if got := CheckWebhookToken(repo, "current-token", now); got != WebhookTokenConfigKey {
+		t.Errorf("current token: got %q", got)
+	}
+	if got := CheckWebhookToken(repo, "old-token", now); got != PreviousWebhookTokenConfigKey {
+		t.Errorf("previous token: got %q", got)
+	}
+	if got := CheckWebhookToken(repo, hashWebhookToken("current-token"), now); got != "" {
+		t.Errorf("the stored hash must not work as a token, got %q", got)
+	}
+
+	stored, err := repo.GetCustomEndpointByID(endpoint.ID)
+	if err != nil {
+		t.Fatalf("GetCustomEndpointByID error: %v", err)
+	}
+	if stored.Token == "custom-endpoint-token" || !CheckCustomEndpointToken(stored, "custom-endpoint-token") {
+		t.Errorf("custom endpoint token stored as %q", stored.Token)
+	}
+	if CheckCustomEndpointToken(stored, stored.Token) || CheckCustomEndpointToken(stored, "") {
+		t.Error("custom endpoint accepted its hash or an empty token")
+	}
+
+	var gateway models.EmailGatewayConfig
+	repo.GetJSONConfig(EmailGatewayConfigKey, &gateway)
+	if gateway.Token == "email-gateway-token" || !CheckEmailGatewayToken(&gateway, "email-gateway-token") || !gateway.Enabled {
+		t.Errorf("email gateway token stored as %q", gateway.Token)
+	}
+
+	var serverChan models.ServerChanConfig
+	repo.GetJSONConfig(ServerChanConfigKey, &serverChan)
+	if serverChan.SendKey == "SCT-sendkey-value" || !CheckServerChanKey(&serverChan, "SCT-sendkey-value") || CheckServerChanKey(&serverChan, serverChan.SendKey) {
+		t.Errorf("Server酱 SendKey stored as %q", serverChan.SendKey)
+	}
+
+	var wxPusher, gitHub models.CompatEndpointConfig
+	repo.GetJSONConfig(CompatConfigKeyPrefix+models.CompatWxPusher, &wxPusher)
+	if wxPusher.Token == "AT_wxpusher-token" || !CheckCompatToken(models.CompatWxPusher, &wxPusher, "AT_wxpusher-token") ||
+		CheckCompatToken(models.CompatWxPusher, &wxPusher, wxPusher.Token) || wxPusher.TemplateKey != "alert" {
+		t.Errorf("WxPusher token stored as %q", wxPusher.Token)
+	}
+	// The GitHub token signs deliveries, so it has to stay readable
+	repo.GetJSONConfig(CompatConfigKeyPrefix+models.CompatGitHub, &gitHub)
+	if gitHub.Token != "github-secret" {
+		t.Errorf("GitHub token stored as %q", gitHub.Token)
+	}
+}
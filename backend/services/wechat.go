@@ -2,22 +2,88 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
+	"wechat-notification/logger"
 	"wechat-notification/models"
 )
 
 const (
 	// WeChatSendMessageURL is the URL to send template messages
 	WeChatSendMessageURL = "https://api.weixin.qq.com/cgi-bin/message/template/send"
+	// WeChatSendSubscribeURL is the URL to send one-time subscribe messages (订阅通知)
+	WeChatSendSubscribeURL = "https://api.weixin.qq.com/cgi-bin/message/subscribe/bizsend"
+	// WeChatSendCustomURL is the URL to send customer service messages (客服消息)
+	WeChatSendCustomURL = "https://api.weixin.qq.com/cgi-bin/message/custom/send"
+	// WeChatPrivateTemplatesURL is the URL to list the templates added to the account
+	WeChatPrivateTemplatesURL = "https://api.weixin.qq.com/cgi-bin/template/get_all_private_template"
+	// DefaultSendConcurrency is how many template messages are sent in parallel unless configured otherwise
+	DefaultSendConcurrency = 10
+	// SandboxSendConcurrency caps parallel sends on a WeChat test account (测试号), whose small
+	// per-minute quota is used up by a burst of parallel calls
+	SandboxSendConcurrency = 1
+	// ThrottleBackoff is how long sends pause after WeChat reports the per-minute API limit
+	ThrottleBackoff = time.Minute
+	// DailyQuotaBackoff is how long sends pause after the daily quota runs out; the quota resets at
+	// midnight or when cleared in the MP console, so it's checked again hourly
+	DailyQuotaBackoff = time.Hour
 )
 
+// WeChat error codes the sender reacts to
+const (
+	WeChatErrSystemBusy       = -1    // system busy; network failures are reported with the same code
+	WeChatErrDeadlineExceeded = -2    // not from WeChat: the send was abandoned when its deadline passed or it was cancelled
+	WeChatErrInvalidOpenID    = 40003 // invalid openid: not a follower of this account
+	WeChatErrRequireSubscribe = 43004 // require subscribe: the user unfollowed the account
+	WeChatErrUserRefused      = 43101 // user refuse to accept the msg
+	WeChatErrDailyQuota       = 45009 // reach max api daily quota limit
+	WeChatErrRateLimited      = 45011 // api minute-quota reach limit
+	WeChatErrOutOfWindow      = 45015 // response out of time limit: no interaction in the last 48 hours, so no customer service message
+)
+
+// APIError is an error reported by the WeChat API, keeping its errcode and errmsg
+type APIError struct {
+	ErrCode int
+	ErrMsg  string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("WeChat API error: code=%d, msg=%s", e.ErrCode, e.ErrMsg)
+}
+
+// IsTransientErrCode reports whether a send failure is worth retrying later
+func IsTransientErrCode(code int) bool {
+	return code == WeChatErrSystemBusy
+}
+
+// IsThrottledErrCode reports whether WeChat refused a send because the account hit an API quota.
+// The message itself is fine and is sent once the quota frees up.
+func IsThrottledErrCode(code int) bool {
+	return code == WeChatErrDailyQuota || code == WeChatErrRateLimited
+}
+
+// IsPermanentErrCode reports whether a send failure will fail the same way however often it's
+// retried, because the recipient can't receive messages from the account
+func IsPermanentErrCode(code int) bool {
+	return code == WeChatErrInvalidOpenID || IsUnsubscribedErrCode(code)
+}
+
+// IsUnsubscribedErrCode reports whether a send failure means the recipient stopped following the account
+func IsUnsubscribedErrCode(code int) bool {
+	return code == WeChatErrRequireSubscribe || code == WeChatErrUserRefused
+}
+
 // MessageHTTPClient interface for making HTTP requests (allows mocking in tests)
 type MessageHTTPClient interface {
+	HTTPClient
 	Post(url, contentType string, body io.Reader) (*http.Response, error)
 }
 
@@ -26,6 +92,11 @@ type WeChatService struct {
 	tokenManager *TokenManager
 	templateID   string
 	httpClient   MessageHTTPClient
+	concurrency  int  // Parallel sends in SendTemplateMessages; 0 means DefaultSendConcurrency
+	sandbox      bool // Account is a WeChat test account; sends are serialized and labelled in the history
+
+	throttleMu     sync.Mutex
+	throttledUntil time.Time // Sends are held back until then after WeChat reports a quota error
 }
 
 // NewWeChatService creates a new WeChat service
@@ -48,28 +119,65 @@ func NewWeChatServiceWithClient(tokenManager *TokenManager, templateID string, c
 
 // SendMessage sends a template message to a recipient with dynamic keywords
 func (s *WeChatService) SendMessage(openID, templateID string, keywords map[string]string) (*models.WeChatAPIResponse, error) {
+	return s.SendTemplateMessage(s.FormatTemplateMessage(openID, templateID, keywords))
+}
+
+// SendTemplateMessage sends a fully formatted template message
+func (s *WeChatService) SendTemplateMessage(msg *models.WeChatTemplateMessage) (*models.WeChatAPIResponse, error) {
+	return s.SendTemplateMessageContext(context.Background(), msg)
+}
+
+// SendTemplateMessageContext sends a fully formatted template message, giving up when ctx is done.
+// Subscribe messages go through subscribe/bizsend and text messages through custom/send instead;
+// a text message with a media ID is followed by the image as a second customer service message.
+func (s *WeChatService) SendTemplateMessageContext(ctx context.Context, msg *models.WeChatTemplateMessage) (*models.WeChatAPIResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// Don't spend calls on a quota WeChat already told us is used up
+	if until := s.ThrottledUntil(); time.Now().Before(until) {
+		return &models.WeChatAPIResponse{ErrCode: WeChatErrRateLimited, ErrMsg: "throttled"},
+			fmt.Errorf("WeChat API throttled until %s", until.Format(time.RFC3339))
+	}
+
+	switch {
+	case msg.Mode == models.TemplateModeSubscribe:
+		return s.call(ctx, WeChatSendSubscribeURL, subscribeMessage(msg))
+	case msg.Mode == models.SendModeText && msg.MediaID == "":
+		return s.call(ctx, WeChatSendCustomURL, textMessage(msg))
+	case msg.Mode == models.SendModeText:
+		if msg.Content != "" {
+			if resp, err := s.call(ctx, WeChatSendCustomURL, textMessage(msg)); err != nil {
+				return resp, err
+			}
+		}
+		return s.call(ctx, WeChatSendCustomURL, imageMessage(msg))
+	default:
+		return s.call(ctx, WeChatSendMessageURL, msg)
+	}
+}
+
+// call posts a message body to a send endpoint of the WeChat API
+func (s *WeChatService) call(ctx context.Context, endpoint string, payload interface{}) (*models.WeChatAPIResponse, error) {
 	// Get access token (will auto-refresh if expired)
 	token, err := s.tokenManager.GetAccessToken()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get access token: %w", err)
 	}
 
-	// Format the message
-	msg := s.FormatTemplateMessage(openID, templateID, keywords)
-
 	// Serialize to JSON
-	jsonData, err := json.Marshal(msg)
+	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to serialize message: %w", err)
 	}
 
 	// Build the request URL with access token
-	url := fmt.Sprintf("%s?access_token=%s", WeChatSendMessageURL, token)
+	url := fmt.Sprintf("%s?access_token=%s", endpoint, token)
 
 	// Send the request
-	resp, err := s.httpClient.Post(url, "application/json", bytes.NewReader(jsonData))
+	resp, err := s.post(ctx, url, jsonData)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send message: %w", err)
+		return nil, fmt.Errorf("failed to send message: %w", withoutURL(err))
 	}
 	defer resp.Body.Close()
 
@@ -86,6 +194,9 @@ func (s *WeChatService) SendMessage(openID, templateID string, keywords map[stri
 	}
 
 	// Check for API errors
+	if IsThrottledErrCode(apiResp.ErrCode) {
+		s.throttle(apiResp.ErrCode, time.Now())
+	}
 	if apiResp.ErrCode != 0 {
 		return &apiResp, fmt.Errorf("WeChat API error: code=%d, msg=%s", apiResp.ErrCode, apiResp.ErrMsg)
 	}
@@ -93,39 +204,173 @@ func (s *WeChatService) SendMessage(openID, templateID string, keywords map[stri
 	return &apiResp, nil
 }
 
-// SendMessageToMultiple sends a template message to multiple recipients concurrently
+// subscribeMessage converts a message to the subscribe/bizsend body
+func subscribeMessage(msg *models.WeChatTemplateMessage) *models.WeChatSubscribeMessage {
+	return &models.WeChatSubscribeMessage{
+		ToUser:      msg.ToUser,
+		TemplateID:  msg.TemplateID,
+		Page:        msg.URL,
+		Miniprogram: msg.Miniprogram,
+		Data:        msg.Data,
+	}
+}
+
+// textMessage converts a message to the custom/send body of a text message
+func textMessage(msg *models.WeChatTemplateMessage) *models.WeChatTextMessage {
+	text := &models.WeChatTextMessage{ToUser: msg.ToUser, MsgType: "text"}
+	text.Text.Content = msg.Content
+	return text
+}
+
+// imageMessage converts a message to the custom/send body of an image message
+func imageMessage(msg *models.WeChatTemplateMessage) *models.WeChatImageMessage {
+	image := &models.WeChatImageMessage{ToUser: msg.ToUser, MsgType: "image"}
+	image.Image.MediaID = msg.MediaID
+	return image
+}
+
+// ThrottledUntil returns when sending may resume after a quota error, or the zero time if it isn't throttled
+func (s *WeChatService) ThrottledUntil() time.Time {
+	s.throttleMu.Lock()
+	defer s.throttleMu.Unlock()
+	return s.throttledUntil
+}
+
+// throttle holds back sends after WeChat reported a quota error
+func (s *WeChatService) throttle(code int, now time.Time) {
+	backoff := ThrottleBackoff
+	if code == WeChatErrDailyQuota {
+		backoff = DailyQuotaBackoff
+	}
+	s.throttleMu.Lock()
+	defer s.throttleMu.Unlock()
+	if until := now.Add(backoff); until.After(s.throttledUntil) {
+		s.throttledUntil = until
+		logger.Warnf("wechat: API quota reached (errcode %d), holding back sends until %s", code, until.Format(time.RFC3339))
+	}
+}
+
+// post sends a JSON body bound to ctx. Clients that can't take a request (such as test doubles) are
+// raced against ctx instead, which frees the caller but leaves the call running until it returns.
+func (s *WeChatService) post(ctx context.Context, url string, body []byte) (*http.Response, error) {
+	if doer, ok := s.httpClient.(interface {
+		Do(*http.Request) (*http.Response, error)
+	}); ok {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return doer.Do(req)
+	}
+
+	type postResult struct {
+		resp *http.Response
+		err  error
+	}
+	done := make(chan postResult, 1)
+	go func() {
+		resp, err := s.httpClient.Post(url, "application/json", bytes.NewReader(body))
+		done <- postResult{resp, err}
+	}()
+	select {
+	case r := <-done:
+		return r.resp, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.resp != nil {
+				r.resp.Body.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// SendMessageToMultiple sends a template message to multiple recipients through the bounded worker pool
 func (s *WeChatService) SendMessageToMultiple(openIDs []string, templateID string, keywords map[string]string) (map[string]*models.WeChatAPIResponse, error) {
-	results := make(map[string]*models.WeChatAPIResponse)
-	resultChan := make(chan struct {
+	msgs := make([]*models.WeChatTemplateMessage, 0, len(openIDs))
+	for _, openID := range openIDs {
+		msgs = append(msgs, s.FormatTemplateMessage(openID, templateID, keywords))
+	}
+	return s.SendTemplateMessages(context.Background(), msgs), nil
+}
+
+// SendTemplateMessages sends formatted template messages concurrently, keyed by recipient OpenID.
+// At most the configured concurrency of messages are in flight at once, so large broadcasts don't open
+// thousands of connections to the WeChat API. Once ctx is done, unsent and in-flight messages
+// fail with WeChatErrDeadlineExceeded.
+func (s *WeChatService) SendTemplateMessages(ctx context.Context, msgs []*models.WeChatTemplateMessage) map[string]*models.WeChatAPIResponse {
+	type sendResult struct {
 		openID string
 		resp   *models.WeChatAPIResponse
-	}, len(openIDs))
+	}
 
-	// Send messages concurrently
-	for _, openID := range openIDs {
-		go func(id string) {
-			resp, err := s.SendMessage(id, templateID, keywords)
-			if err != nil {
-				resultChan <- struct {
-					openID string
-					resp   *models.WeChatAPIResponse
-				}{id, &models.WeChatAPIResponse{ErrCode: -1, ErrMsg: err.Error()}}
-			} else {
-				resultChan <- struct {
-					openID string
-					resp   *models.WeChatAPIResponse
-				}{id, resp}
+	workers := s.concurrency
+	if workers <= 0 {
+		workers = DefaultSendConcurrency
+	}
+	if s.sandbox && workers > SandboxSendConcurrency {
+		workers = SandboxSendConcurrency
+	}
+	if workers > len(msgs) {
+		workers = len(msgs)
+	}
+
+	jobs := make(chan *models.WeChatTemplateMessage)
+	resultChan := make(chan sendResult, len(msgs))
+	for i := 0; i < workers; i++ {
+		go func() {
+			for m := range jobs {
+				resp, err := s.SendTemplateMessageContext(ctx, m)
+				if err != nil {
+					code := WeChatErrSystemBusy
+					switch {
+					case resp != nil:
+						code = resp.ErrCode
+					case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled):
+						code = WeChatErrDeadlineExceeded
+					}
+					resp = &models.WeChatAPIResponse{ErrCode: code, ErrMsg: err.Error()}
+				}
+				resultChan <- sendResult{m.ToUser, resp}
 			}
-		}(openID)
+		}()
 	}
+	for _, msg := range msgs {
+		jobs <- msg
+	}
+	close(jobs)
 
 	// Collect results
-	for range openIDs {
+	results := make(map[string]*models.WeChatAPIResponse, len(msgs))
+	for range msgs {
 		r := <-resultChan
 		results[r.openID] = r.resp
 	}
 
-	return results, nil
+	return results
+}
+
+// SetConcurrency sets how many template messages SendTemplateMessages sends in parallel; 0 restores the default
+func (s *WeChatService) SetConcurrency(n int) {
+	s.concurrency = n
+}
+
+// SetSandbox marks the account as a WeChat test account (测试号). Test accounts use the same API
+// endpoints, but sends are serialized to stay inside their quota and labelled as sandbox sends.
+func (s *WeChatService) SetSandbox(sandbox bool) {
+	s.sandbox = sandbox
+}
+
+// Sandbox reports whether the account is a WeChat test account
+func (s *WeChatService) Sandbox() bool {
+	return s.sandbox
+}
+
+// AppID returns the AppID of the account
+func (s *WeChatService) AppID() string {
+	appID, _ := s.tokenManager.GetCredentials()
+	return appID
 }
 
 // FormatTemplateMessage formats a message for WeChat template API with dynamic keywords
@@ -145,6 +390,20 @@ func (s *WeChatService) FormatTemplateMessage(openID, templateID string, keyword
 	}
 }
 
+// FormatTextMessage formats a customer service text message: the title and content built from
+// the keywords as for other channels, then the jump URL on its own line, which WeChat turns into
+// a link
+func (s *WeChatService) FormatTextMessage(openID string, keywords map[string]string, url string) *models.WeChatTemplateMessage {
+	n := BuildNotification(&models.MessageTemplate{}, keywords)
+	var lines []string
+	for _, line := range []string{n.Title, n.Content, url} {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return &models.WeChatTemplateMessage{ToUser: openID, Mode: models.SendModeText, Content: strings.Join(lines, "\n")}
+}
+
 // SerializeMessage serializes a WeChatTemplateMessage to JSON bytes
 func SerializeMessage(msg *models.WeChatTemplateMessage) ([]byte, error) {
 	return json.Marshal(msg)
@@ -1,11 +1,16 @@
 package services
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -95,7 +100,7 @@ func TestProperty13_WeChatMessageFormatting(t *testing.T) {
 			tokenManager := NewTokenManager("test_app_id", "test_app_secret")
 			service := NewWeChatService(tokenManager, templateID)
 
-			msg := service.FormatTemplateMessage(openID, title, content)
+			msg := service.FormatTemplateMessage(openID, templateID, map[string]string{"title": title, "content": content})
 
 			// Check required fields
 			if msg.ToUser != openID {
@@ -378,3 +383,130 @@ func TestProperty15_TokenAutoRefresh(t *testing.T) {
 
 	properties.TestingRun(t)
 }
+
+func TestSendTemplateMessagesBoundedConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	client := &MockHTTPClient{PostFunc: func(url, contentType string, body io.Reader) (*http.Response, error) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(2 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"errcode":0,"errmsg":"ok"}`))}, nil
+	}}
+	tokenManager := NewTokenManager("app", "secret")
+	tokenManager.SetToken("token", time.Hour)
+	service := NewWeChatServiceWithClient(tokenManager, "tpl", client)
+	service.SetConcurrency(4)
+
+	msgs := make([]*models.WeChatTemplateMessage, 0, 50)
+	for i := 0; i < 50; i++ {
+		msgs = append(msgs, service.FormatTemplateMessage(fmt.Sprintf("openid_%d", i), "tpl", map[string]string{"first": "hi"}))
+	}
+	results := service.SendTemplateMessages(context.Background(), msgs)
+
+	if len(results) != 50 {
+		t.Fatalf("got %d results, want 50", len(results))
+	}
+	if maxInFlight > 4 {
+		t.Errorf("max in-flight sends = %d, want at most 4", maxInFlight)
+	}
+	if got := service.SendTemplateMessages(context.Background(), nil); len(got) != 0 {
+		t.Errorf("sending no messages returned %v", got)
+	}
+}
+
+func TestStableTokenRequest(t *testing.T) {
+	var requests []map[string]interface{}
+	client := &MockHTTPClient{PostFunc: func(url, contentType string, body io.Reader) (*http.Response, error) {
+		if url != WeChatStableTokenURL {
+			t.Errorf("token requested from %s", url)
+		}
+		var payload map[string]interface{}
+		json.NewDecoder(body).Decode(&payload)
+		requests = append(requests, payload)
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"access_token":"stable","expires_in":7200}`))}, nil
+	}}
+	tm := NewTokenManagerWithClient("app", "secret", client)
+	tm.SetStableToken(true)
+
+	if token, err := tm.GetAccessToken(); err != nil || token != "stable" {
+		t.Fatalf("GetAccessToken = %q, %v", token, err)
+	}
+	if _, err := tm.ForceRefresh(); err != nil {
+		t.Fatalf("ForceRefresh error: %v", err)
+	}
+	if len(requests) != 2 || requests[0]["appid"] != "app" || requests[0]["force_refresh"] != false || requests[1]["force_refresh"] != true {
+		t.Errorf("unexpected stable token requests: %v", requests)
+	}
+}
+
+func TestTokenProvider(t *testing.T) {
+	var requested []string
+	client := &MockHTTPClient{GetFunc: func(url string) (*http.Response, error) {
+		requested = append(requested, url)
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"access_token":"central","expires_in":3600}`))}, nil
+	}}
+	tm := NewTokenManagerWithClient("wx123", "", client)
+	tm.SetStableToken(true)
+	tm.SetTokenProvider("http://token-center.internal/token?env=prod")
+
+	token, err := tm.GetAccessToken()
+	if err != nil || token != "central" {
+		t.Fatalf("GetAccessToken = %q, %v", token, err)
+	}
+	if len(requested) != 1 || requested[0] != "http://token-center.internal/token?env=prod&appid=wx123" {
+		t.Errorf("token provider requested as %v", requested)
+	}
+	if !tm.UsesTokenProvider() {
+		t.Error("UsesTokenProvider should report the provider")
+	}
+}
+
+func TestTokenStatus(t *testing.T) {
+	reply := `{"errcode":40125,"errmsg":"invalid appsecret"}`
+	client := &MockHTTPClient{GetFunc: func(url string) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(reply))}, nil
+	}}
+	tm := NewTokenManagerWithClient("wx123", "bad", client)
+	if status := tm.Status(); status.Cached || status.Source != "token" || status.LastError != "" || status.LastRefreshAt != nil {
+		t.Fatalf("fresh manager status = %+v", status)
+	}
+
+	if _, err := tm.GetAccessToken(); err == nil {
+		t.Fatal("expected an error for an invalid secret")
+	}
+	status := tm.Status()
+	if status.Cached || !strings.Contains(status.LastError, "40125") || status.LastErrorAt == nil {
+		t.Fatalf("failed refresh should be reported: %+v", status)
+	}
+
+	reply = `{"access_token":"token","expires_in":7200}`
+	if _, err := tm.GetAccessToken(); err != nil {
+		t.Fatalf("GetAccessToken error: %v", err)
+	}
+	status = tm.Status()
+	if !status.Cached || status.ExpiresAt == nil || status.LastError != "" || status.LastRefreshAt == nil {
+		t.Errorf("successful refresh should clear the error: %+v", status)
+	}
+}
+
+func TestTokenErrorHidesSecret(t *testing.T) {
+	client := &MockHTTPClient{GetFunc: func(u string) (*http.Response, error) {
+		return nil, &url.Error{Op: "Get", URL: u, Err: errors.New("dial tcp: lookup api.weixin.qq.com: no such host")}
+	}}
+	tm := NewTokenManagerWithClient("wx123", "topsecret", client)
+	_, err := tm.GetAccessToken()
+	if err == nil || strings.Contains(err.Error(), "topsecret") {
+		t.Fatalf("error should not reveal the AppSecret: %v", err)
+	}
+	if status := tm.Status(); strings.Contains(status.LastError, "topsecret") || !strings.Contains(status.LastError, "no such host") {
+		t.Errorf("unexpected last error: %q", status.LastError)
+	}
+}
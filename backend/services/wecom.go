@@ -0,0 +1,222 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// WeComAPIBase is the base URL of the enterprise WeChat (企业微信) server API
+const WeComAPIBase = "https://qyapi.weixin.qq.com/cgi-bin"
+
+// WeCom error codes meaning the cached access token must be fetched again
+const (
+	wecomErrInvalidToken = 40014
+	wecomErrTokenExpired = 42001
+)
+
+// wecomTokenCache shares access tokens between notifiers, keyed by API base, corp ID and app secret.
+// WeCom rate-limits gettoken, so a token is fetched once per app and reused until shortly before it expires.
+type wecomTokenCache struct {
+	mu       sync.Mutex
+	tokens   map[string]wecomToken
+	fetching map[string]*wecomFetch
+}
+
+type wecomToken struct {
+	value     string
+	expiresAt time.Time
+}
+
+// wecomFetch is a gettoken call in progress; concurrent callers for the same app wait for its result
+type wecomFetch struct {
+	done  chan struct{}
+	token wecomToken
+	err   error
+}
+
+var wecomTokens = &wecomTokenCache{tokens: map[string]wecomToken{}, fetching: map[string]*wecomFetch{}}
+
+// get returns the cached token for an app, fetching it when missing or about to expire. The lock
+// isn't held during the fetch, so a slow gettoken for one app doesn't block sends through others.
+func (c *wecomTokenCache) get(client *http.Client, baseURL, corpID, secret string) (string, error) {
+	key := baseURL + "\x00" + corpID + "\x00" + secret
+	c.mu.Lock()
+	if t, ok := c.tokens[key]; ok && time.Now().Add(TokenBufferTime).Before(t.expiresAt) {
+		c.mu.Unlock()
+		return t.value, nil
+	}
+	if f, ok := c.fetching[key]; ok {
+		c.mu.Unlock()
+		<-f.done
+		return f.token.value, f.err
+	}
+	f := &wecomFetch{done: make(chan struct{})}
+	c.fetching[key] = f
+	c.mu.Unlock()
+
+	f.token, f.err = fetchWeComToken(client, baseURL, corpID, secret)
+
+	c.mu.Lock()
+	delete(c.fetching, key)
+	if f.err == nil {
+		c.tokens[key] = f.token
+	}
+	c.mu.Unlock()
+	close(f.done)
+	return f.token.value, f.err
+}
+
+func fetchWeComToken(client *http.Client, baseURL, corpID, secret string) (wecomToken, error) {
+	resp, err := client.Get(baseURL + "/gettoken?corpid=" + url.QueryEscape(corpID) + "&corpsecret=" + url.QueryEscape(secret))
+	if err != nil {
+		return wecomToken{}, fmt.Errorf("failed to request wecom access token: %w", withoutURL(err))
+	}
+	defer resp.Body.Close()
+
+	// gettoken answers with the same fields as the official account token API
+	var tokenResp TokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&tokenResp); err != nil {
+		return wecomToken{}, fmt.Errorf("failed to parse wecom token response: %w", err)
+	}
+	if tokenResp.ErrCode != 0 || tokenResp.AccessToken == "" {
+		return wecomToken{}, fmt.Errorf("wecom token error %d: %s", tokenResp.ErrCode, tokenResp.ErrMsg)
+	}
+	return wecomToken{
+		value:     tokenResp.AccessToken,
+		expiresAt: time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second),
+	}, nil
+}
+
+func (c *wecomTokenCache) invalidate(baseURL, corpID, secret string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tokens, baseURL+"\x00"+corpID+"\x00"+secret)
+}
+
+// WeComAppNotifier sends application messages through an enterprise WeChat self-built app
+type WeComAppNotifier struct {
+	CorpID  string
+	AgentID int
+	Secret  string
+	ToUser  string
+	baseURL string
+	client  *http.Client
+}
+
+// wecomSendResponse is the body returned by message/send
+type wecomSendResponse struct {
+	ErrCode     int    `json:"errcode"`
+	ErrMsg      string `json:"errmsg"`
+	InvalidUser string `json:"invaliduser"`
+}
+
+// Notify sends a text card when the notification has a link, plain text otherwise.
+// An expired or revoked token is fetched again and the send retried once.
+func (w *WeComAppNotifier) Notify(n Notification) error {
+	payload := map[string]interface{}{
+		"touser":  w.ToUser,
+		"agentid": w.AgentID,
+	}
+	if n.URL != "" {
+		payload["msgtype"] = "textcard"
+		payload["textcard"] = map[string]string{
+			"title":       TruncateRunes(n.Title, 128),
+			"description": TruncateRunes(n.Content, 512),
+			"url":         n.URL,
+			"btntxt":      "详情",
+		}
+	} else {
+		content := n.Title
+		if n.Content != "" {
+			content += "\n" + n.Content
+		}
+		payload["msgtype"] = "text"
+		payload["text"] = map[string]string{"content": content}
+	}
+
+	result, err := w.send(payload)
+	if err == nil && (result.ErrCode == wecomErrInvalidToken || result.ErrCode == wecomErrTokenExpired) {
+		wecomTokens.invalidate(w.baseURL, w.CorpID, w.Secret)
+		result, err = w.send(payload)
+	}
+	if err != nil {
+		return err
+	}
+	if result.ErrCode != 0 {
+		return fmt.Errorf("wecom error %d: %s", result.ErrCode, result.ErrMsg)
+	}
+	if result.InvalidUser != "" {
+		return fmt.Errorf("wecom rejected users: %s", result.InvalidUser)
+	}
+	return nil
+}
+
+func (w *WeComAppNotifier) send(payload map[string]interface{}) (*wecomSendResponse, error) {
+	token, err := wecomTokens.get(w.client, w.baseURL, w.CorpID, w.Secret)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := postJSON(w.client, w.baseURL+"/message/send?access_token="+url.QueryEscape(token), payload)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result wecomSendResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("wecom returned HTTP %d", resp.StatusCode)
+	}
+	return &result, nil
+}
+
+// WeComBotNotifier posts to an enterprise WeChat group robot webhook
+type WeComBotNotifier struct {
+	WebhookURL string
+	Format     string // markdown or text
+	client     *http.Client
+}
+
+// Notify sends the notification as markdown with a bold title, or as plain text
+func (w *WeComBotNotifier) Notify(n Notification) error {
+	var payload map[string]interface{}
+	if w.Format == "text" {
+		content := n.Title
+		if n.Content != "" {
+			content += "\n" + n.Content
+		}
+		if n.URL != "" {
+			content += "\n" + n.URL
+		}
+		// Text content is limited to 2048 bytes, markdown to 4096; cap by runes assuming 3-byte CJK
+		payload = map[string]interface{}{"msgtype": "text", "text": map[string]string{"content": TruncateRunes(content, 680)}}
+	} else {
+		content := "**" + n.Title + "**"
+		if n.Content != "" {
+			content += "\n" + n.Content
+		}
+		if n.URL != "" {
+			content += "\n[查看详情](" + n.URL + ")"
+		}
+		payload = map[string]interface{}{"msgtype": "markdown", "markdown": map[string]string{"content": TruncateRunes(content, 1300)}}
+	}
+
+	resp, err := postJSON(w.client, w.WebhookURL, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result wecomSendResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&result); err != nil {
+		return fmt.Errorf("wecom robot returned HTTP %d", resp.StatusCode)
+	}
+	if result.ErrCode != 0 {
+		return fmt.Errorf("wecom robot error %d: %s", result.ErrCode, result.ErrMsg)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"wechat-notification/models"
+)
+
+func TestWeComAppNotifier(t *testing.T) {
+	tokenCalls := 0
+	expireNext := false
+	var sent []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gettoken":
+			tokenCalls++
+			if r.URL.Query().Get("corpid") != "ww1" || r.URL.Query().Get("corpsecret") != "s3cret" {
+				w.Write([]byte(`{"errcode":40013,"errmsg":"invalid corpid"}`))
+				return
+			}
+			w.Write([]byte(`{"errcode":0,"access_token":"tok` + strconv.Itoa(tokenCalls) + `","expires_in":7200}`))
+		case "/message/send":
+			if expireNext {
+				expireNext = false
+				w.Write([]byte(`{"errcode":42001,"errmsg":"access_token expired"}`))
+				return
+			}
+			var payload map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&payload)
+			payload["token"] = r.URL.Query().Get("access_token")
+			sent = append(sent, payload)
+			w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+		}
+	}))
+	defer server.Close()
+
+	notifier := &WeComAppNotifier{CorpID: "ww1", AgentID: 1000002, Secret: "s3cret", ToUser: "@all", baseURL: server.URL, client: server.Client()}
+	if err := notifier.Notify(Notification{Title: "发布完成", Content: "v1.2"}); err != nil {
+		t.Fatalf("Notify error: %v", err)
+	}
+	if err := notifier.Notify(Notification{Title: "发布完成", Content: "v1.3", URL: "https://example.com"}); err != nil {
+		t.Fatalf("Notify error: %v", err)
+	}
+	if tokenCalls != 1 {
+		t.Errorf("token fetched %d times, want 1 (cached)", tokenCalls)
+	}
+	if len(sent) != 2 || sent[0]["msgtype"] != "text" || sent[1]["msgtype"] != "textcard" || sent[0]["agentid"] != float64(1000002) {
+		t.Fatalf("unexpected messages: %v", sent)
+	}
+
+	expireNext = true
+	if err := notifier.Notify(Notification{Title: "x"}); err != nil {
+		t.Fatalf("Notify after expiry error: %v", err)
+	}
+	if tokenCalls != 2 || sent[2]["token"] != "tok2" {
+		t.Errorf("expired token should be refetched: calls=%d token=%v", tokenCalls, sent[2]["token"])
+	}
+}
+
+func TestWeComTokenCacheSingleFetch(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Query().Get("corpid") == "slow" {
+			<-release
+		}
+		w.Write([]byte(`{"errcode":0,"access_token":"tok","expires_in":7200}`))
+	}))
+	defer server.Close()
+
+	cache := &wecomTokenCache{tokens: map[string]wecomToken{}, fetching: map[string]*wecomFetch{}}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if token, err := cache.get(server.Client(), server.URL, "slow", "s"); err != nil || token != "tok" {
+				t.Errorf("get = %q, %v", token, err)
+			}
+		}()
+	}
+
+	// Another app's token isn't held up by the slow fetch
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := cache.get(server.Client(), server.URL, "fast", "s"); err != nil {
+		t.Fatalf("other app: %v", err)
+	}
+	close(release)
+	wg.Wait()
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("gettoken called %d times, want 2", got)
+	}
+}
+
+func TestValidateWeComAppChannel(t *testing.T) {
+	ch := models.Channel{Type: models.ChannelWeComApp, CorpID: " ww1 ", AgentID: 1000002, Secret: "s"}
+	if err := ValidateChannel(&ch); err != nil {
+		t.Fatalf("ValidateChannel error: %v", err)
+	}
+	if ch.CorpID != "ww1" || ch.ToUser != "@all" {
+		t.Errorf("channel not normalized: %+v", ch)
+	}
+	if err := ValidateChannel(&models.Channel{Type: models.ChannelWeComApp, CorpID: "ww1", Secret: "s"}); err == nil {
+		t.Error("expected error without agent ID")
+	}
+}
+
+func TestWeComBotNotifier(t *testing.T) {
+	var payload map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payload)
+		if payload["msgtype"] == "text" {
+			w.Write([]byte(`{"errcode":93000,"errmsg":"invalid webhook url"}`))
+			return
+		}
+		w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+	}))
+	defer server.Close()
+
+	ch := models.Channel{Type: models.ChannelWeComBot, WebhookURL: server.URL}
+	if err := ValidateChannel(&ch); err == nil {
+		t.Error("plain http robot webhook should be rejected")
+	}
+
+	bot := &WeComBotNotifier{WebhookURL: server.URL, Format: "markdown", client: server.Client()}
+	if err := bot.Notify(Notification{Title: "构建失败", Content: "main #42", URL: "https://ci.example.com/42"}); err != nil {
+		t.Fatalf("Notify error: %v", err)
+	}
+	markdown, _ := payload["markdown"].(map[string]interface{})
+	if content, _ := markdown["content"].(string); content != "**构建失败**\nmain #42\n[查看详情](https://ci.example.com/42)" {
+		t.Errorf("unexpected markdown content: %q", content)
+	}
+
+	bot.Format = "text"
+	if err := bot.Notify(Notification{Title: "构建失败"}); err == nil {
+		t.Error("expected error for non-zero errcode")
+	}
+}
@@ -4,13 +4,14 @@ import { MessageTemplate } from '../types';
 
 interface Props {
   token: string;
+  hasToken: boolean;
   templates: MessageTemplate[];
   onTokenChange: (token: string) => void;
   onSuccess: (msg: string) => void;
   onError: (msg: string) => void;
 }
 
-export function WebhookConfig({ token, templates, onTokenChange, onSuccess, onError }: Props) {
+export function WebhookConfig({ token, hasToken, templates, onTokenChange, onSuccess, onError }: Props) {
   const [generating, setGenerating] = useState(false);
   
   // 获取示例用的 templateKey
@@ -23,7 +24,7 @@ export function WebhookConfig({ token, templates, onTokenChange, onSuccess, onEr
   };
 
   const handleGenerate = async () => {
-    if (token && !confirm('确定要重新生成 Token 吗？旧 Token 将失效。')) return;
+    if ((token || hasToken) && !confirm('确定要重新生成 Token 吗？旧 Token 将失效。')) return;
     try {
       setGenerating(true);
       const newToken = await generateWebhookToken();
@@ -50,10 +51,10 @@ export function WebhookConfig({ token, templates, onTokenChange, onSuccess, onEr
         <div className="form-group">
           <label className="form-label">Token</label>
           <div className="input-with-button">
-            <input type="text" className="form-input" value={token || '未生成'} readOnly />
+            <input type="text" className="form-input" value={token || (hasToken ? '已生成（仅在生成时显示，遗失请重新生成）' : '未生成')} readOnly />
             {token && <button type="button" className="btn btn-secondary" onClick={() => copyToClipboard(token)}>复制</button>}
             <button type="button" className="btn btn-primary" onClick={handleGenerate} disabled={generating}>
-              {generating ? '生成中...' : token ? '重新生成' : '生成 Token'}
+              {generating ? '生成中...' : token || hasToken ? '重新生成' : '生成 Token'}
             </button>
           </div>
         </div>
@@ -5,6 +5,7 @@ import { MessageTemplate } from '../types';
 
 export function Webhook() {
   const [webhookToken, setWebhookToken] = useState('');
+  const [hasToken, setHasToken] = useState(false);
   const [templates, setTemplates] = useState<MessageTemplate[]>([]);
   const [loading, setLoading] = useState(true);
   const [error, setError] = useState<string | null>(null);
@@ -22,6 +23,7 @@ export function Webhook() {
         getTemplates(),
       ]);
       setWebhookToken(tokenData.token || '');
+      setHasToken(tokenData.hasToken);
       setTemplates(templatesData);
     } catch (err) {
       setError(err instanceof Error ? err.message : '加载数据失败');
@@ -51,6 +53,7 @@ export function Webhook() {
       
       <WebhookConfig 
         token={webhookToken}
+        hasToken={hasToken}
         templates={templates}
         onTokenChange={setWebhookToken}
         onSuccess={showSuccess}
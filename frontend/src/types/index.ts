@@ -88,5 +88,6 @@ export interface MessageTemplate {
 // Webhook token response
 export interface WebhookTokenResponse {
   hasToken: boolean;
-  token: string;
+  // 仅在生成时返回，服务器只保存 Token 的哈希
+  token?: string;
 }